@@ -0,0 +1,81 @@
+package bench_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/pkg/gatewaytest"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+)
+
+// newServer returns a gateway in front of a catalog of n products, with
+// logging quietened so it does not dominate the measurements.
+func newServer(b *testing.B, n int) *gatewaytest.Server {
+	b.Helper()
+	if err := logger.Init(logger.Config{Level: "error"}); err != nil {
+		b.Fatal(err)
+	}
+	var products []*pbInv.Product
+	for i := range n {
+		products = append(products, &pbInv.Product{
+			Id:          "p" + strconv.Itoa(i),
+			Name:        "Product " + strconv.Itoa(i),
+			Description: "A product description long enough to matter in the encoded size",
+			Price:       float64(i) + 0.99,
+			Quantity:    int32(i),
+		})
+	}
+	return gatewaytest.NewServer(b, gatewaytest.WithInventory(gatewaytest.NewInventory(products...)))
+}
+
+// serve benchmarks req through the whole handler, middleware chain included,
+// failing on any status but want.
+func serve(b *testing.B, srv *gatewaytest.Server, want int, req *gatewaytest.Request) {
+	b.Helper()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if w := req.Record(); w.Code != want {
+				b.Fatalf("status %d, want %d: %s", w.Code, want, w.Body)
+			}
+		}
+	})
+}
+
+// BenchmarkHealth measures the middleware chain around a trivial handler
+func BenchmarkHealth(b *testing.B) {
+	srv := newServer(b, 0)
+	serve(b, srv, http.StatusOK, srv.Request(http.MethodGet, "/health"))
+}
+
+// BenchmarkUnauthorized measures requests rejected by the token check
+func BenchmarkUnauthorized(b *testing.B) {
+	srv := newServer(b, 0)
+	serve(b, srv, http.StatusUnauthorized, srv.Request(http.MethodPost, "/inventory/list").JSON(map[string]any{}))
+}
+
+// BenchmarkGetProduct measures reading one product
+func BenchmarkGetProduct(b *testing.B) {
+	srv := newServer(b, 1)
+	serve(b, srv, http.StatusOK, srv.Request(http.MethodGet, "/inventory/get").As("user-1").JSON(map[string]string{"id": "p0"}))
+}
+
+// BenchmarkListProducts measures reading a page of products, whole and pruned to some fields
+func BenchmarkListProducts(b *testing.B) {
+	srv := newServer(b, 50)
+	b.Run("full", func(b *testing.B) {
+		serve(b, srv, http.StatusOK, srv.Request(http.MethodPost, "/inventory/list").As("user-1").JSON(map[string]any{"page_size": 50}))
+	})
+	b.Run("fields", func(b *testing.B) {
+		serve(b, srv, http.StatusOK, srv.Request(http.MethodPost, "/inventory/list?fields=id,name").As("user-1").JSON(map[string]any{"page_size": 50}))
+	})
+}
+
+// BenchmarkCreateProduct measures a write with a request body
+func BenchmarkCreateProduct(b *testing.B) {
+	srv := newServer(b, 0)
+	serve(b, srv, http.StatusOK, srv.Request(http.MethodPost, "/inventory/create").As("user-1").JSON(map[string]any{"product": map[string]any{"name": "Tea", "quantity": 3}}))
+}
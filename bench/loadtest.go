@@ -0,0 +1,313 @@
+// Package bench guards the performance of the gateway's proxy path. Its tests
+// hold Go benchmarks of the middleware chain and handlers, run in memory
+// against fake backends:
+//
+//	go test -run '^$' -bench . -benchmem ./bench/
+//
+// The package itself is the load generator behind `gateway loadtest`, which
+// replays vegeta-format targets against a running gateway at the rates of a
+// profile and fails when the latency or error thresholds are exceeded.
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Target is one request of a load test.
+type Target struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// ParseTargets reads targets in vegeta's HTTP format: a "METHOD URL" line,
+// then "Key: Value" header lines and an optional "@path" line naming the
+// body file, relative to dir. Targets are separated by blank lines; lines
+// starting with # are comments.
+func ParseTargets(r io.Reader, dir string) ([]Target, error) {
+	var (
+		targets []Target
+		cur     *Target
+		line    int
+	)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		switch {
+		case text == "":
+			cur = nil
+		case strings.HasPrefix(text, "#"):
+		case cur == nil:
+			method, url, ok := strings.Cut(text, " ")
+			if !ok || method != strings.ToUpper(method) {
+				return nil, fmt.Errorf("line %d: want \"METHOD URL\", got %q", line, text)
+			}
+			targets = append(targets, Target{Method: method, URL: strings.TrimSpace(url), Header: http.Header{}})
+			cur = &targets[len(targets)-1]
+		case strings.HasPrefix(text, "@"):
+			path := text[1:]
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			body, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			cur.Body = body
+		default:
+			key, value, ok := strings.Cut(text, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: want \"Key: Value\" header, got %q", line, text)
+			}
+			cur.Header.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+	return targets, nil
+}
+
+// LoadTargets reads the targets file at path.
+func LoadTargets(path string) ([]Target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	targets, err := ParseTargets(f, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return targets, nil
+}
+
+// Stage ramps the request rate linearly from that of the previous stage,
+// zero for the first, to Rate over Duration, like the stages of k6's
+// ramping-arrival-rate executor. A stage without Duration sets the rate the
+// next one starts from.
+type Stage struct {
+	Duration time.Duration `yaml:"duration"`
+	Rate     float64       `yaml:"target"`
+}
+
+// Thresholds fail a load test. Zero values are not checked.
+type Thresholds struct {
+	P50          time.Duration `yaml:"p50"`
+	P95          time.Duration `yaml:"p95"`
+	P99          time.Duration `yaml:"p99"`
+	MaxErrorRate float64       `yaml:"max_error_rate"`
+}
+
+// Profile describes a load test.
+type Profile struct {
+	// Targets is the vegeta targets file, relative to the profile. The
+	// targets are sent in turn.
+	Targets string `yaml:"targets"`
+
+	// Stages set the request rate over time, in requests per second.
+	Stages []Stage `yaml:"stages"`
+
+	// Timeout bounds every request. Default: 10s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// MaxInFlight caps concurrent requests; requests due while at the cap
+	// are dropped and counted. Default: 1000.
+	MaxInFlight int `yaml:"max_in_flight"`
+
+	Thresholds Thresholds `yaml:"thresholds"`
+}
+
+// LoadProfile reads a YAML profile and resolves its targets file.
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Profile
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.Targets != "" && !filepath.IsAbs(p.Targets) {
+		p.Targets = filepath.Join(filepath.Dir(path), p.Targets)
+	}
+	return &p, nil
+}
+
+// Result summarizes a load test.
+type Result struct {
+	Requests int
+	// Errors are requests that failed or got a 5xx response.
+	Errors int
+	// Dropped are requests not sent because MaxInFlight were in flight.
+	Dropped  int
+	Codes    map[int]int
+	Duration time.Duration
+
+	latencies []time.Duration
+}
+
+// Percentile returns the latency below which p (0 to 1) of the requests
+// completed.
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(r.latencies))+0.5) - 1
+	return r.latencies[min(max(i, 0), len(r.latencies)-1)]
+}
+
+// ErrorRate returns the share of failed and dropped requests.
+func (r *Result) ErrorRate() float64 {
+	if r.Requests+r.Dropped == 0 {
+		return 0
+	}
+	return float64(r.Errors+r.Dropped) / float64(r.Requests+r.Dropped)
+}
+
+// Check returns the thresholds r exceeds, empty if none.
+func (t Thresholds) Check(r *Result) []string {
+	var failed []string
+	for _, c := range []struct {
+		name  string
+		p     float64
+		limit time.Duration
+	}{{"p50", 0.5, t.P50}, {"p95", 0.95, t.P95}, {"p99", 0.99, t.P99}} {
+		if got := r.Percentile(c.p); c.limit > 0 && got > c.limit {
+			failed = append(failed, fmt.Sprintf("%s latency %s exceeds %s", c.name, got, c.limit))
+		}
+	}
+	if t.MaxErrorRate > 0 && r.ErrorRate() > t.MaxErrorRate {
+		failed = append(failed, fmt.Sprintf("error rate %.4f exceeds %.4f", r.ErrorRate(), t.MaxErrorRate))
+	}
+	return failed
+}
+
+// tick is how often Run releases the requests that came due.
+const tick = 10 * time.Millisecond
+
+// Run sends targets in turn with client at the rates of p's stages, until
+// the last stage ends or ctx is done, and waits for the requests in flight.
+func Run(ctx context.Context, client *http.Client, targets []Target, p Profile) *Result {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	maxInFlight := p.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = 1000
+	}
+
+	res := &Result{Codes: map[int]int{}}
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		inFlight = make(chan struct{}, maxInFlight)
+		next     int
+	)
+	send := func(t Target) {
+		defer func() { <-inFlight; wg.Done() }()
+		code, latency, err := do(ctx, client, t, timeout)
+		mu.Lock()
+		defer mu.Unlock()
+		res.Requests++
+		res.latencies = append(res.latencies, latency)
+		if err != nil || code >= 500 {
+			res.Errors++
+		}
+		if err == nil {
+			res.Codes[code]++
+		}
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	sent := 0
+loop:
+	for {
+		due, over := scheduled(p.Stages, time.Since(start))
+		for ; sent < due; sent++ {
+			select {
+			case inFlight <- struct{}{}:
+				wg.Add(1)
+				go send(targets[next])
+				next = (next + 1) % len(targets)
+			default:
+				mu.Lock()
+				res.Dropped++
+				mu.Unlock()
+			}
+		}
+		if over {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+	}
+	wg.Wait()
+	res.Duration = time.Since(start)
+	slices.Sort(res.latencies)
+	return res
+}
+
+// scheduled returns how many requests the stages schedule in the first
+// elapsed of a test, and whether the stages are over.
+func scheduled(stages []Stage, elapsed time.Duration) (int, bool) {
+	var (
+		total float64
+		from  float64
+	)
+	for _, s := range stages {
+		d := s.Duration.Seconds()
+		if elapsed < s.Duration {
+			t := elapsed.Seconds()
+			// area under the linear ramp from `from` to s.Rate up to t
+			total += from*t + (s.Rate-from)*t*t/(2*d)
+			return int(total), false
+		}
+		total += (from + s.Rate) / 2 * d
+		elapsed -= s.Duration
+		from = s.Rate
+	}
+	return int(total + 0.5), true
+}
+
+func do(ctx context.Context, client *http.Client, t Target, timeout time.Duration) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, t.Method, t.URL, bytes.NewReader(t.Body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header = t.Header.Clone()
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, time.Since(start), err
+}
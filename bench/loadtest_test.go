@@ -0,0 +1,109 @@
+package bench_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/bench"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseTargets tests reading vegeta's target format
+func TestParseTargets(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "list.json"), []byte(`{"page_size":20}`), 0o600))
+
+	targets, err := bench.ParseTargets(strings.NewReader(`
+# catalog reads
+GET http://localhost:8080/health
+
+POST http://localhost:8080/inventory/list
+Authorization: Bearer token
+Content-Type: application/json
+@list.json
+`), dir)
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	assert.Equal(t, "GET", targets[0].Method)
+	assert.Equal(t, "http://localhost:8080/health", targets[0].URL)
+	assert.Equal(t, "Bearer token", targets[1].Header.Get("Authorization"))
+	assert.Equal(t, `{"page_size":20}`, string(targets[1].Body))
+
+	for _, bad := range []string{"", "get http://x", "GET http://x\nno header"} {
+		_, err := bench.ParseTargets(strings.NewReader(bad), dir)
+		assert.Error(t, err, bad)
+	}
+}
+
+// TestRun tests the request rate, result counting and thresholds
+func TestRun(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	targets := []bench.Target{
+		{Method: http.MethodPost, URL: ts.URL + "/ok", Header: http.Header{}, Body: []byte("ok")},
+		{Method: http.MethodGet, URL: ts.URL + "/fail", Header: http.Header{}},
+	}
+	// ramp from 0 to 200/s over 100ms, then hold 200/s for 100ms: 30 requests
+	profile := bench.Profile{Stages: []bench.Stage{
+		{Duration: 100 * time.Millisecond, Rate: 200},
+		{Duration: 100 * time.Millisecond, Rate: 200},
+	}}
+	res := bench.Run(context.Background(), ts.Client(), targets, profile)
+
+	assert.Equal(t, 30, res.Requests)
+	assert.Equal(t, 15, res.Errors)
+	assert.Equal(t, map[int]int{http.StatusOK: 15, http.StatusServiceUnavailable: 15}, res.Codes)
+	assert.Contains(t, bodies, "ok")
+	assert.InDelta(t, 0.5, res.ErrorRate(), 0.001)
+	assert.Positive(t, res.Percentile(0.99))
+
+	assert.Empty(t, bench.Thresholds{P99: time.Minute}.Check(res))
+	failed := bench.Thresholds{P50: time.Nanosecond, MaxErrorRate: 0.01}.Check(res)
+	assert.Len(t, failed, 2)
+}
+
+// TestLoadProfile tests that the targets file is resolved next to the profile
+func TestLoadProfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profile.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+targets: targets.txt
+stages:
+  - duration: 30s
+    target: 100
+  - duration: 1m
+    target: 100
+thresholds:
+  p99: 250ms
+  max_error_rate: 0.01
+`), 0o600))
+
+	p, err := bench.LoadProfile(path)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "targets.txt"), p.Targets)
+	assert.Equal(t, []bench.Stage{{Duration: 30 * time.Second, Rate: 100}, {Duration: time.Minute, Rate: 100}}, p.Stages)
+	assert.Equal(t, 250*time.Millisecond, p.Thresholds.P99)
+	assert.Equal(t, 0.01, p.Thresholds.MaxErrorRate)
+}
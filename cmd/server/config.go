@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andro-kes/gateway/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// configCmd runs the config subcommands.
+func configCmd(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "print":
+			configPrint(args[1:])
+			return
+		case "schema":
+			configSchema(args[1:])
+			return
+		case "validate":
+			configValidate(args[1:])
+			return
+		}
+	}
+	fmt.Fprintln(os.Stderr, `Usage:
+  gateway config print [-config file] [-resolved] [-http addr] [-grpc addr]
+  gateway config schema
+  gateway config validate [-config file] [file...]`)
+	os.Exit(2)
+}
+
+// configPrint prints the configuration with secrets masked.
+func configPrint(args []string) {
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	configPath := configFlag(fs)
+	overrides := addrFlags(fs)
+	resolved := fs.Bool("resolved", false, "print the final configuration: the file merged with .env, the environment and flags, with secret references resolved")
+	fs.Parse(args)
+
+	var cfg *config.Config
+	var err error
+	if *resolved {
+		cfg, err = config.Load(*configPath)
+		if err == nil {
+			overrides(cfg)
+		}
+	} else {
+		cfg, err = config.ReadFile(*configPath)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg.Redacted()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	enc.Close()
+}
+
+// configSchema prints the JSON Schema of the configuration file.
+func configSchema(args []string) {
+	fs := flag.NewFlagSet("config schema", flag.ExitOnError)
+	fs.Parse(args)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(config.Schema()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// configValidate checks configuration files against the schema, without
+// building the gateway, and exits with status 1 when any is invalid.
+func configValidate(args []string) {
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configPath := configFlag(fs)
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 && *configPath != "" {
+		paths = []string{*configPath}
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "gateway config validate: need -config or files")
+		os.Exit(2)
+	}
+	invalid := false
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = config.Validate(data)
+		}
+		if err != nil {
+			invalid = true
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Printf("%s: %s\n", path, line)
+			}
+			continue
+		}
+		fmt.Printf("%s: ok\n", path)
+	}
+	if invalid {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/andro-kes/gateway/bench"
+	"github.com/andro-kes/gateway/internal/respdiff"
+)
+
+// diff replays targets against two gateways and exits with status 1 when
+// their answers differ.
+func diff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	targetsPath := fs.String("targets", "", "vegeta targets file of the requests to replay")
+	against := fs.String("against", "", "base URL of the gateway to compare with, e.g. http://gateway-next:8080")
+	base := fs.String("base", "", "base URL of the running gateway. Default: the URLs of the targets")
+	ignore := fs.String("ignore", "", "comma-separated JSON paths not to compare, e.g. $.meta.request_id,$.products[*].updated_at")
+	loose := fs.Bool("loose-numbers", false, `treat numbers and strings holding them as equal, e.g. 5 and "5"`)
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	fs.Parse(args)
+
+	if *targetsPath == "" || *against == "" {
+		fmt.Fprintln(os.Stderr, "gateway diff: need -targets and -against")
+		os.Exit(2)
+	}
+	b, err := parseBase(*against)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gateway diff: -against:", err)
+		os.Exit(2)
+	}
+	var a *url.URL
+	if *base != "" {
+		if a, err = parseBase(*base); err != nil {
+			fmt.Fprintln(os.Stderr, "gateway diff: -base:", err)
+			os.Exit(2)
+		}
+	}
+	targets, err := bench.LoadTargets(*targetsPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	opts := respdiff.Options{LooseNumbers: *loose, Timeout: *timeout}
+	for _, p := range strings.Split(*ignore, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			opts.Ignore = append(opts.Ignore, p)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// redirects are compared, not followed
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	results := respdiff.Run(ctx, client, targets, a, b, opts)
+
+	differ, failed := 0, 0
+	for _, res := range results {
+		switch {
+		case res.Err != nil:
+			failed++
+			fmt.Printf("FAIL %s %s: %v\n", res.Method, res.URL, res.Err)
+		case res.Differs():
+			differ++
+			fmt.Printf("DIFF %s %s\n", res.Method, res.URL)
+			for _, d := range res.Differences {
+				fmt.Printf("  %s\n", d)
+			}
+		}
+	}
+	fmt.Printf("%d requests, %d same, %d differ, %d failed\n", len(results), len(results)-differ-failed, differ, failed)
+	if differ > 0 || failed > 0 || len(results) < len(targets) {
+		os.Exit(1)
+	}
+}
+
+// parseBase parses an absolute http or https URL.
+func parseBase(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http or https URL", raw)
+	}
+	return u, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andro-kes/gateway/bench"
+)
+
+// loadtest replays targets against a running gateway and exits with status
+// 1 when the profile's thresholds are exceeded.
+func loadtest(args []string) {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	profilePath := fs.String("profile", "", "YAML load profile with targets, stages and thresholds")
+	targetsPath := fs.String("targets", "", "vegeta targets file, overriding the profile's")
+	rate := fs.Float64("rate", 0, "constant requests per second, replacing the profile's stages")
+	duration := fs.Duration("duration", 30*time.Second, "length of the test at -rate")
+	fs.Parse(args)
+
+	profile := &bench.Profile{}
+	if *profilePath != "" {
+		var err error
+		if profile, err = bench.LoadProfile(*profilePath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *targetsPath != "" {
+		profile.Targets = *targetsPath
+	}
+	if *rate > 0 {
+		// start at full rate rather than ramping up from zero
+		profile.Stages = []bench.Stage{{Rate: *rate}, {Duration: *duration, Rate: *rate}}
+	}
+	if profile.Targets == "" || len(profile.Stages) == 0 {
+		fmt.Fprintln(os.Stderr, "gateway loadtest: need -profile, or -targets and -rate")
+		os.Exit(2)
+	}
+	targets, err := bench.LoadTargets(profile.Targets)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 256}}
+	res := bench.Run(ctx, client, targets, *profile)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "requests\t%d in %s (%.1f/s)\n", res.Requests, res.Duration.Round(time.Millisecond), float64(res.Requests)/res.Duration.Seconds())
+	fmt.Fprintf(tw, "errors\t%d, %d dropped (%.2f%%)\n", res.Errors, res.Dropped, 100*res.ErrorRate())
+	fmt.Fprintf(tw, "latency\tp50 %s, p95 %s, p99 %s, max %s\n", res.Percentile(0.5), res.Percentile(0.95), res.Percentile(0.99), res.Percentile(1))
+	codes := make([]int, 0, len(res.Codes))
+	for code := range res.Codes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(tw, "status %d\t%d\n", code, res.Codes[code])
+	}
+	tw.Flush()
+
+	if failed := profile.Thresholds.Check(res); len(failed) > 0 {
+		for _, f := range failed {
+			fmt.Fprintln(os.Stderr, "threshold failed:", f)
+		}
+		os.Exit(1)
+	}
+}
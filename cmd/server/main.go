@@ -1,94 +1,118 @@
+// Command gateway is the HTTP API gateway in front of the auth and inventory
+// gRPC services.
+//
+// Usage:
+//
+//	gateway serve [-config file] [-http addr] [-grpc addr]
+//	gateway validate [-config file] [-skip-backends]
+//	gateway routes [-config file]
+//	gateway config print [-config file] [-resolved]
+//	gateway config schema
+//	gateway config validate [-config file] [file...]
+//	gateway loadtest [-profile file] [-targets file] [-rate n -duration d]
+//	gateway diff -targets file -against url [-base url] [-ignore paths] [-loose-numbers]
+//	gateway version
+//
+// Without a command, or with only flags, it serves, so that existing
+// deployments keep working. Every command first loads the variables of
+// .env, or of the file named by ENV_FILE, that are not set already.
 package main
 
 import (
-	"context"
 	"flag"
-	"net/http"
+	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"strings"
 
-	pbAuth "github.com/andro-kes/auth_service/proto"
-	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/buildinfo"
+	"github.com/andro-kes/gateway/internal/config"
 	"github.com/andro-kes/gateway/internal/logger"
-	pbInv "github.com/andro-kes/inventory_service/proto"
-	"github.com/go-chi/chi/v5"
-	"go.uber.org/zap"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
-func main() {
-	zl := logger.Logger()
-	defer zl.Sync()
-
-	var (
-		httpAddr = flag.String("http", os.Getenv("HTTP_ADDR"), "HTTP address to listen on")
-		grpcAddr = flag.String("grpc", os.Getenv("GRPC_ADDR"), "gRPC address to listen on")
-	)
-	flag.Parse()
+var commands = []struct {
+	name  string
+	usage string
+	run   func(args []string)
+}{
+	{"serve", "run the gateway", serve},
+	{"validate", "check the configuration, key material and backends, then exit", validate},
+	{"routes", "print the route table with the middleware of each route", routes},
+	{"config", "print the configuration or its schema, or validate config files", configCmd},
+	{"loadtest", "replay request targets against a running gateway and check latency thresholds", loadtest},
+	{"diff", "replay request targets against two gateways and compare their responses", diff},
+	{"version", "print build information", printVersion},
+}
 
-	conn, err := grpc.NewClient(*grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
+func main() {
+	// before anything reads the environment, the logger included
+	envFile := os.Getenv("ENV_FILE")
+	if envFile == "" {
+		envFile = ".env"
+	}
+	if err := config.LoadDotEnv(envFile); err != nil {
+		fmt.Fprintln(os.Stderr, "gateway:", err)
+		os.Exit(1)
+	}
+	if err := logger.InitFromEnv(); err != nil {
 		panic(err)
 	}
-	defer conn.Close()
-
-	authClient := pbAuth.NewAuthServiceClient(conn)
-	authManager := handlers.NewAuthManager(authClient)
-
-	invClient := pbInv.NewInventoryServiceClient(conn)
-	invManager := handlers.NewInvManager(invClient)
-
-	r := chi.NewRouter()
-
-	r.Get("/health", handlers.CheckHealth)
-
-	r.Route("/auth", func(r chi.Router) {
-		r.Post("/login", authManager.LoginHandler)
-		r.Post("/register", authManager.RegisterHandler)
-		r.Post("/refresh", authManager.RefreshHandler)
-		r.Post("/revoke", authManager.RevokeHandler)
-	})
-
-	r.Route("/inventory", func(r chi.Router) {
-		r.Use(handlers.PropagateAuthToGRPC)
-		// Protected routes
-		r.Post("/create", invManager.CreateHandler)
-		r.Post("/delete", invManager.DeleteHandler)
-		r.Get("/get", invManager.GetHandler)
-		r.Post("/list", invManager.ListHandler)
-		r.Post("/update", invManager.UpdateHandler)
-	})
-
-	server := http.Server{
-		Addr:    *httpAddr,
-		Handler: r,
+	if buildinfo.Version == "" {
+		buildinfo.Version = version
 	}
 
-	svrError := make(chan error, 1)
-	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			svrError <- err
+	args := os.Args[1:]
+	switch {
+	case len(args) > 0 && isHelp(args[0]):
+		usage()
+		return
+	case len(args) == 0 || strings.HasPrefix(args[0], "-"):
+		serve(args)
+		return
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.run(args[1:])
+			return
 		}
-	}()
+	}
+	fmt.Fprintf(os.Stderr, "gateway: unknown command %q\n\n", args[0])
+	usage()
+	os.Exit(2)
+}
 
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+func isHelp(arg string) bool {
+	switch arg {
+	case "help", "-h", "-help", "--help":
+		return true
+	}
+	return false
+}
 
-	select {
-	case err := <-svrError:
-		zl.Warn("Failed to start HTTP server", zap.Error(err))
-		panic(err.Error())
-	case <-shutdown:
-		zl.Info("System shutdown")
-		break
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gateway <command> [flags]\n\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
 	}
+	fmt.Fprintln(os.Stderr, "\nRun gateway <command> -h for the flags of a command.")
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
-		panic(err.Error())
+// addrFlags registers the -http and -grpc flags and returns the function
+// that applies them to a configuration.
+func addrFlags(fs *flag.FlagSet) func(*config.Config) {
+	httpAddr := fs.String("http", os.Getenv("HTTP_ADDR"), "HTTP address to listen on when no listeners are configured")
+	grpcAddr := fs.String("grpc", os.Getenv("GRPC_ADDR"), "gRPC address of the backends")
+	return func(cfg *config.Config) {
+		if *httpAddr != "" {
+			cfg.HTTPAddr = *httpAddr
+		}
+		if *grpcAddr != "" {
+			cfg.GRPCAddr = *grpcAddr
+		}
 	}
 }
+
+// configFlag registers the -config flag shared by every command that reads
+// the configuration.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", os.Getenv("CONFIG_FILE"), "path to YAML config file")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andro-kes/gateway/pkg/gateway"
+	"github.com/go-chi/chi/v5"
+)
+
+// routes prints every route of the configured router, with its handler and
+// the middleware it passes through, outermost first.
+func routes(args []string) {
+	fs := flag.NewFlagSet("routes", flag.ExitOnError)
+	configPath := configFlag(fs)
+	fs.Parse(args)
+
+	cfg, err := gateway.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	gw, err := gateway.New(*cfg, gateway.WithoutBackendProbe())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer gw.Close(ctx)
+
+	// middleware of the root router applies to every route; print it once
+	global := len(gw.Routes().Middlewares())
+	var names []string
+	for _, mw := range gw.Routes().Middlewares() {
+		names = append(names, funcName(mw))
+	}
+	fmt.Printf("Middleware of every route: %s\n\n", strings.Join(names, " > "))
+
+	type row struct {
+		methods    []string
+		route      string
+		handler    string
+		middleware []string
+	}
+	rows := map[string]*row{}
+	chi.Walk(gw.Routes(), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		r := &row{route: route, handler: funcName(handler)}
+		for _, mw := range middlewares[global:] {
+			r.middleware = append(r.middleware, funcName(mw))
+		}
+		// routes registered for every method are listed once
+		key := route + " " + r.handler + " " + strings.Join(r.middleware, " ")
+		if rows[key] == nil {
+			rows[key] = r
+		}
+		rows[key].methods = append(rows[key].methods, method)
+		return nil
+	})
+	sorted := make([]*row, 0, len(rows))
+	for _, r := range rows {
+		sort.Strings(r.methods)
+		if len(r.methods) > 5 {
+			r.methods = []string{"*"}
+		}
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].route != sorted[j].route {
+			return sorted[i].route < sorted[j].route
+		}
+		return sorted[i].methods[0] < sorted[j].methods[0]
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tROUTE\tHANDLER\tMIDDLEWARE")
+	for _, r := range sorted {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", strings.Join(r.methods, ","), r.route, r.handler, strings.Join(r.middleware, " > "))
+	}
+	tw.Flush()
+}
+
+// closureSuffix matches the suffix of anonymous functions, e.g. ".func1.2".
+var closureSuffix = regexp.MustCompile(`(\.func\d+|\.\d+)+$`)
+
+// funcName names a handler or middleware for the route table, e.g.
+// "bulkhead.Limiter.Middleware" for a closure returned by that method.
+func funcName(v any) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Func {
+		return strings.TrimPrefix(fmt.Sprintf("%T", v), "*")
+	}
+	name := runtime.FuncForPC(rv.Pointer()).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.TrimSuffix(name, "-fm")
+	name = closureSuffix.ReplaceAllString(name, "")
+	return strings.NewReplacer("(*", "", ")", "").Replace(name)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/server"
+	"github.com/andro-kes/gateway/internal/startup"
+	"github.com/andro-kes/gateway/internal/systemd"
+	"github.com/andro-kes/gateway/internal/upgrade"
+	"github.com/andro-kes/gateway/pkg/gateway"
+	"go.uber.org/zap"
+)
+
+// serve runs the gateway until it is shut down or hands over to an upgraded
+// binary.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := configFlag(fs)
+	overrides := addrFlags(fs)
+	fs.Parse(args)
+
+	zl := logger.Logger()
+	defer zl.Sync()
+
+	cfg, err := gateway.LoadConfig(*configPath)
+	if err != nil {
+		panic(err)
+	}
+	overrides(cfg)
+
+	gw, err := gateway.New(*cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	var report startup.Report
+
+	upgrader, err := upgrade.New()
+	report.Check("upgrade", err)
+	report.Must()
+
+	listeners := cfg.Listeners
+	if len(listeners) == 0 && upgrader.Upgraded() {
+		// keep serving whatever the previous process derived from systemd or HTTP_ADDR
+		for _, name := range upgrader.Names() {
+			if !strings.HasSuffix(name, "#quic") {
+				listeners = append(listeners, server.ListenerConfig{Address: name})
+			}
+		}
+	}
+	if len(listeners) == 0 {
+		inherited, err := systemd.Listeners()
+		report.Check("systemd", err)
+		for i := range inherited {
+			listeners = append(listeners, server.ListenerConfig{Address: "systemd:" + strconv.Itoa(i)})
+		}
+	}
+	if len(listeners) == 0 {
+		listeners = []server.ListenerConfig{{Address: cfg.HTTPAddr}}
+	}
+	srv, err := server.New(listeners, cfg.HTTPServer, gw.Handler())
+	if report.Check("listeners", err) {
+		srv.Inherit(upgrader.File)
+		report.Check("listeners", srv.Listen())
+	}
+	report.Must()
+	gw.PrintFeatures(os.Stderr)
+
+	gw.OnReady("upgrade", func(context.Context) error {
+		if err := upgrader.Ready(); err != nil {
+			return fmt.Errorf("signal readiness to the previous process: %w", err)
+		}
+		return nil
+	})
+	gw.OnReady("systemd", func(context.Context) error {
+		notify(zl, "READY=1")
+		return nil
+	})
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go handleSignals(ctx, stop, gw, srv, upgrader, *configPath)
+	if err := gw.Serve(ctx, srv); err != nil {
+		zl.Warn("HTTP server failed", zap.Error(err))
+		panic(err.Error())
+	}
+}
+
+// handleSignals reloads the configuration on SIGHUP and calls stop on
+// SIGINT or SIGTERM, or once an upgraded binary took over, until ctx is
+// done.
+func handleSignals(ctx context.Context, stop func(), gw *gateway.Gateway, srv *server.Server, upgrader *upgrade.Upgrader, configPath string) {
+	zl := logger.Logger()
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(shutdown)
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	upgradeSig := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgradeSig, upgradeSignals...)
+		defer signal.Stop(upgradeSig)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			notify(zl, "RELOADING=1")
+			newCfg, err := gateway.LoadConfig(configPath)
+			if err == nil {
+				err = gw.Reload(newCfg)
+			}
+			if err != nil {
+				zl.Warn("Failed to reload access rules", zap.Error(err))
+			} else {
+				zl.Info("Access rules reloaded")
+			}
+			notify(zl, "READY=1")
+		case <-upgradeSig:
+			files, err := srv.Files()
+			var pid int
+			if err == nil {
+				pid, err = upgrader.Upgrade(files)
+			}
+			if err != nil {
+				zl.Warn("Binary upgrade failed", zap.Error(err))
+				continue
+			}
+			zl.Info("New process is ready, draining", zap.Int("pid", pid))
+			notify(zl, "MAINPID="+strconv.Itoa(pid))
+			stop()
+			return
+		case <-shutdown:
+			zl.Info("System shutdown")
+			notify(zl, "STOPPING=1")
+			stop()
+			return
+		}
+	}
+}
+
+// notify reports state changes to systemd when running under it.
+func notify(zl *zap.Logger, state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		zl.Warn("Failed to notify systemd", zap.String("state", state), zap.Error(err))
+	}
+}
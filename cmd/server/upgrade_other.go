@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// upgradeSignals is empty: socket handover needs Unix file descriptors.
+var upgradeSignals []os.Signal
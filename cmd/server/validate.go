@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andro-kes/gateway/pkg/gateway"
+)
+
+// validate runs the startup checks without serving, and exits with status 1
+// when any of them fails.
+func validate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := configFlag(fs)
+	skipBackends := fs.Bool("skip-backends", false, "do not check that the backends are reachable")
+	fs.Parse(args)
+
+	cfg, err := gateway.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var opts []gateway.Option
+	if *skipBackends {
+		opts = append(opts, gateway.WithoutBackendProbe())
+	}
+	gw, err := gateway.New(*cfg, opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer gw.Close(ctx)
+
+	gw.PrintFeatures(os.Stdout)
+	fmt.Println("\nconfiguration is valid")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/andro-kes/gateway/internal/buildinfo"
+)
+
+// version is the release version, set at build time with
+// -ldflags "-X main.version=v1.2.3". buildinfo.Version takes precedence.
+var version string
+
+// printVersion prints the version, commit and toolchain of the binary.
+func printVersion(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.Parse(args)
+
+	info := buildinfo.Get()
+	commit, committed := info.Commit, info.CommitTime
+	if info.Modified {
+		commit += " (modified)"
+	}
+	if committed == "" {
+		committed = "unknown"
+	}
+	fmt.Printf("gateway %s\n", info.Version)
+	fmt.Printf("commit:    %s\n", commit)
+	fmt.Printf("committed: %s\n", committed)
+	if info.BuildDate != "" {
+		fmt.Printf("built:     %s\n", info.BuildDate)
+	}
+	fmt.Printf("go:        %s\n", info.GoVersion)
+	fmt.Printf("platform:  %s\n", info.Platform)
+}
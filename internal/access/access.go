@@ -0,0 +1,211 @@
+// Package access enforces per route group network access rules: CIDR
+// allow/deny lists and, with a MaxMind GeoIP database, country blocking.
+// Rules can be replaced at runtime with Reload.
+package access
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Config configures access control.
+type Config struct {
+	// Groups maps route group names ("auth", "inventory", "admin") to their
+	// rules. Without an explicit "admin" entry, the admin API only accepts
+	// loopback and private addresses.
+	Groups map[string]Rules `yaml:"groups"`
+
+	// GeoIPDatabase is the path to a MaxMind country (or city) database.
+	// Required when any group uses country rules.
+	GeoIPDatabase string `yaml:"geoip_database"`
+}
+
+// Rules are the access rules of one route group. Deny rules win over allow
+// rules; a non-empty allow list rejects everything it does not match.
+type Rules struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+
+	// AllowCountries and DenyCountries are ISO 3166-1 alpha-2 codes. Clients
+	// whose country cannot be determined (e.g. private addresses) fail an
+	// allow list.
+	AllowCountries []string `yaml:"allow_countries"`
+	DenyCountries  []string `yaml:"deny_countries"`
+}
+
+// internalNetworks restrict the admin group unless it is configured explicitly.
+var internalNetworks = []string{"127.0.0.0/8", "::1", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+var deniedTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "access",
+	Name:      "denied_total",
+	Help:      "Requests rejected by access rules.",
+}, []string{"group", "reason"})
+
+type rules struct {
+	allow, deny                   []*net.IPNet
+	allowCountries, denyCountries map[string]bool
+}
+
+type state struct {
+	groups map[string]*rules
+	geo    *maxminddb.Reader
+}
+
+// Controller evaluates access rules.
+type Controller struct {
+	state atomic.Pointer[state]
+}
+
+// New builds a Controller from cfg.
+func New(cfg Config) (*Controller, error) {
+	c := &Controller{}
+	if err := c.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload atomically replaces the rules. On error the previous rules stay in effect.
+func (c *Controller) Reload(cfg Config) error {
+	groups := make(map[string]Rules, len(cfg.Groups)+1)
+	for name, rc := range cfg.Groups {
+		groups[name] = rc
+	}
+	if _, ok := groups["admin"]; !ok {
+		groups["admin"] = Rules{Allow: internalNetworks}
+	}
+
+	st := &state{groups: make(map[string]*rules, len(groups))}
+	needGeo := false
+	for name, rc := range groups {
+		r := &rules{
+			allowCountries: countrySet(rc.AllowCountries),
+			denyCountries:  countrySet(rc.DenyCountries),
+		}
+		var err error
+		if r.allow, err = realip.ParseCIDRs(rc.Allow); err != nil {
+			return fmt.Errorf("access group %s: %w", name, err)
+		}
+		if r.deny, err = realip.ParseCIDRs(rc.Deny); err != nil {
+			return fmt.Errorf("access group %s: %w", name, err)
+		}
+		needGeo = needGeo || len(r.allowCountries) > 0 || len(r.denyCountries) > 0
+		st.groups[name] = r
+	}
+
+	if needGeo && cfg.GeoIPDatabase == "" {
+		return fmt.Errorf("country rules require geoip_database")
+	}
+	if cfg.GeoIPDatabase != "" {
+		// load into memory rather than mmap so an old reader can be dropped
+		// while requests may still be using it
+		data, err := os.ReadFile(cfg.GeoIPDatabase)
+		if err != nil {
+			return fmt.Errorf("failed to read GeoIP database: %w", err)
+		}
+		if st.geo, err = maxminddb.FromBytes(data); err != nil {
+			return fmt.Errorf("failed to open GeoIP database: %w", err)
+		}
+	}
+
+	c.state.Store(st)
+	return nil
+}
+
+// Middleware enforces the rules of group. Groups without rules allow everything.
+func (c *Controller) Middleware(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, ok := realip.FromContext(r.Context())
+			if !ok {
+				ip = realip.Peer(r)
+			}
+			if reason := c.check(group, ip); reason != "" {
+				deniedTotal.WithLabelValues(group, reason).Inc()
+				logger.Logger().Info("Access denied",
+					zap.String("group", group),
+					zap.String("client_ip", ip),
+					zap.String("reason", reason),
+				)
+				http.Error(w, "access denied", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// check returns the reason the request is denied, or "" if it is allowed.
+func (c *Controller) check(group, addr string) string {
+	st := c.state.Load()
+	r, ok := st.groups[group]
+	if !ok {
+		return ""
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "invalid_ip"
+	}
+
+	if contains(r.deny, ip) {
+		return "ip_denied"
+	}
+	if len(r.allow) > 0 && !contains(r.allow, ip) {
+		return "ip_not_allowed"
+	}
+
+	if len(r.allowCountries) == 0 && len(r.denyCountries) == 0 {
+		return ""
+	}
+	country := lookupCountry(st.geo, ip)
+	if r.denyCountries[country] {
+		return "country_denied"
+	}
+	if len(r.allowCountries) > 0 && !r.allowCountries[country] {
+		return "country_not_allowed"
+	}
+	return ""
+}
+
+func lookupCountry(db *maxminddb.Reader, ip net.IP) string {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := db.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+func countrySet(codes []string) map[string]bool {
+	set := make(map[string]bool, len(codes))
+	for _, c := range codes {
+		set[strings.ToUpper(c)] = true
+	}
+	return set
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
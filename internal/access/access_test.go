@@ -0,0 +1,85 @@
+package access_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/access"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, c *access.Controller, group, remote string) int {
+	t.Helper()
+	h := c.Middleware(group)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remote
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+// TestMiddleware_AllowDeny tests CIDR allow and deny lists
+func TestMiddleware_AllowDeny(t *testing.T) {
+	c, err := access.New(access.Config{Groups: map[string]access.Rules{
+		"inventory": {Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.13"}},
+		"auth":      {Deny: []string{"203.0.113.0/24"}},
+	}})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, serve(t, c, "inventory", "10.1.2.3:1000"))
+	assert.Equal(t, http.StatusForbidden, serve(t, c, "inventory", "10.0.0.13:1000"))
+	assert.Equal(t, http.StatusForbidden, serve(t, c, "inventory", "198.51.100.1:1000"))
+	assert.Equal(t, http.StatusForbidden, serve(t, c, "auth", "203.0.113.5:1000"))
+	assert.Equal(t, http.StatusOK, serve(t, c, "auth", "198.51.100.1:1000"))
+	assert.Equal(t, http.StatusOK, serve(t, c, "unconfigured", "203.0.113.5:1000"))
+}
+
+// TestMiddleware_AdminDefault tests that the admin group only accepts internal addresses by default
+func TestMiddleware_AdminDefault(t *testing.T) {
+	c, err := access.New(access.Config{})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, serve(t, c, "admin", "127.0.0.1:1000"))
+	assert.Equal(t, http.StatusOK, serve(t, c, "admin", "192.168.1.10:1000"))
+	assert.Equal(t, http.StatusForbidden, serve(t, c, "admin", "198.51.100.1:1000"))
+
+	c, err = access.New(access.Config{Groups: map[string]access.Rules{"admin": {}}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serve(t, c, "admin", "198.51.100.1:1000"))
+}
+
+// TestReload tests that rules are swapped at runtime and kept on error
+func TestReload(t *testing.T) {
+	c, err := access.New(access.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serve(t, c, "auth", "198.51.100.1:1000"))
+
+	require.NoError(t, c.Reload(access.Config{Groups: map[string]access.Rules{
+		"auth": {Deny: []string{"198.51.100.0/24"}},
+	}}))
+	assert.Equal(t, http.StatusForbidden, serve(t, c, "auth", "198.51.100.1:1000"))
+
+	assert.Error(t, c.Reload(access.Config{Groups: map[string]access.Rules{
+		"auth": {Deny: []string{"not-a-cidr"}},
+	}}))
+	assert.Equal(t, http.StatusForbidden, serve(t, c, "auth", "198.51.100.1:1000"))
+}
+
+// TestNew_GeoIPErrors tests that country rules need a valid database
+func TestNew_GeoIPErrors(t *testing.T) {
+	_, err := access.New(access.Config{Groups: map[string]access.Rules{
+		"auth": {DenyCountries: []string{"xx"}},
+	}})
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "geo.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o600))
+	_, err = access.New(access.Config{GeoIPDatabase: path})
+	assert.Error(t, err)
+}
@@ -0,0 +1,215 @@
+// Package accesslog writes one line per HTTP request, either as structured
+// JSON through the gateway logger or in Apache Common/Combined Log Format to
+// a dedicated file or syslog sink.
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/disconnect"
+	"github.com/andro-kes/gateway/internal/http/instrument"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/syslog"
+	"github.com/andro-kes/gateway/internal/tracing"
+	"go.uber.org/zap"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatOff      = "off"
+)
+
+// Config configures the access log.
+type Config struct {
+	// Format is "json" (default, written by the gateway logger), "common",
+	// "combined" or "off".
+	Format string `yaml:"format"`
+
+	// Output is where common/combined lines go: "stdout" (default),
+	// "stderr", "syslog" or a file path. Files are reopened by Reopen, for
+	// use with logrotate.
+	Output string `yaml:"output"`
+
+	// Syslog configures the syslog sink when Output is "syslog".
+	Syslog syslog.Config `yaml:"syslog"`
+}
+
+// Logger writes access log lines.
+type Logger struct {
+	format string
+	output string
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// New opens the configured sink.
+func New(cfg Config) (*Logger, error) {
+	l := &Logger{format: cfg.Format, output: cfg.Output}
+	if l.format == "" {
+		l.format = FormatJSON
+	}
+	switch l.format {
+	case FormatJSON, FormatOff:
+		return l, nil
+	case FormatCommon, FormatCombined:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", l.format)
+	}
+
+	switch l.output {
+	case "", "stdout":
+		l.out = os.Stdout
+	case "stderr":
+		l.out = os.Stderr
+	case "syslog":
+		w, err := syslog.New(cfg.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		l.out = w
+	default:
+		f, err := openFile(l.output)
+		if err != nil {
+			return nil, err
+		}
+		l.out = f
+	}
+	return l, nil
+}
+
+func openFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	return f, nil
+}
+
+// Reopen reopens a file sink, e.g. after logrotate moved it away.
+func (l *Logger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old, ok := l.out.(*os.File)
+	if !ok || old == os.Stdout || old == os.Stderr {
+		return nil
+	}
+	f, err := openFile(l.output)
+	if err != nil {
+		return err
+	}
+	l.out = f
+	return old.Close()
+}
+
+// Close closes the sink.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out == os.Stdout || l.out == os.Stderr {
+		return nil
+	}
+	if c, ok := l.out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Middleware logs every request once the response is complete.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	if l.format == FormatOff {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := instrument.Wrap(w)
+		next.ServeHTTP(rec, r)
+		status := rec.Status()
+		if disconnect.Closed(r) {
+			status = disconnect.StatusClientClosed
+		}
+
+		ip, ok := realip.FromContext(r.Context())
+		if !ok {
+			ip = realip.Peer(r)
+		}
+
+		if l.format == FormatJSON {
+			fields := []zap.Field{
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", status),
+				zap.Int64("bytes", rec.Bytes()),
+				zap.Duration("duration", time.Since(start)),
+				zap.String("client_ip", ip),
+				zap.String("user_agent", r.UserAgent()),
+			}
+			if sc, ok := tracing.FromContext(r.Context()); ok {
+				fields = append(fields, zap.String("trace_id", sc.TraceIDString()))
+			}
+			if id, ok := requestid.FromContext(r.Context()); ok {
+				fields = append(fields, zap.String("request_id", id))
+			}
+			if status == disconnect.StatusClientClosed {
+				fields = append(fields, zap.String("outcome", "client_closed"))
+			}
+			logger.Logger().Info("Request", fields...)
+			return
+		}
+
+		line := l.clf(r, ip, start, status, rec.Bytes())
+		l.mu.Lock()
+		_, err := io.WriteString(l.out, line)
+		l.mu.Unlock()
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			logger.Logger().Warn("Failed to write access log", zap.Error(err))
+		}
+	})
+}
+
+// clf formats a Common or Combined Log Format line.
+func (l *Logger) clf(r *http.Request, ip string, start time.Time, status int, bytes int64) string {
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+	user := "-"
+	if u := r.URL.User; u != nil && u.Username() != "" {
+		user = u.Username()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `%s - %s [%s] "%s %s %s" %d %s`,
+		ip, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, escape(r.RequestURI), r.Proto, status, size)
+	if l.format == FormatCombined {
+		fmt.Fprintf(&b, ` "%s" "%s"`, orDash(escape(r.Referer())), orDash(escape(r.UserAgent())))
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
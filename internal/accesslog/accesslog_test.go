@@ -0,0 +1,109 @@
+package accesslog_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/accesslog"
+	"github.com/andro-kes/gateway/internal/disconnect"
+	"github.com/andro-kes/gateway/internal/syslog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var teapot = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusTeapot)
+	w.Write([]byte("short and stout"))
+})
+
+func newRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/inventory/get?id=1", nil)
+	r.RemoteAddr = "198.51.100.7:4000"
+	r.Header.Set("Referer", "https://shop.example.com/")
+	r.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+	return r
+}
+
+// TestMiddleware_CombinedToFile tests combined log lines written to a file and reopened after rotation
+func TestMiddleware_CombinedToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := accesslog.New(accesslog.Config{Format: accesslog.FormatCombined, Output: path})
+	require.NoError(t, err)
+	defer l.Close()
+
+	l.Middleware(teapot).ServeHTTP(httptest.NewRecorder(), newRequest())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(
+		`^198\.51\.100\.7 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /inventory/get\?id=1 HTTP/1\.1" 418 15 "https://shop\.example\.com/" "curl/8\.0 \\"quoted\\""\n$`,
+	), string(data))
+
+	// logrotate moves the file away, then the gateway reopens it on SIGHUP
+	require.NoError(t, os.Rename(path, path+".1"))
+	require.NoError(t, l.Reopen())
+	l.Middleware(teapot).ServeHTTP(httptest.NewRecorder(), newRequest())
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "418 15")
+}
+
+// TestMiddleware_CommonToSyslog tests common log lines sent to a syslog server
+func TestMiddleware_CommonToSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	l, err := accesslog.New(accesslog.Config{
+		Format: accesslog.FormatCommon,
+		Output: "syslog",
+		Syslog: syslog.Config{Network: "udp", Address: conn.LocalAddr().String(), Facility: "local3"},
+	})
+	require.NoError(t, err)
+	defer l.Close()
+
+	l.Middleware(teapot).ServeHTTP(httptest.NewRecorder(), newRequest())
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	// local3 (19) * 8 + info (6)
+	assert.Regexp(t, `^<158>1 \S+ \S+ gateway \d+ - - 198\.51\.100\.7 - - \[`, msg)
+	assert.Contains(t, msg, `"GET /inventory/get?id=1 HTTP/1.1" 418 15`)
+	assert.NotContains(t, msg, "shop.example.com")
+}
+
+// TestMiddleware_ClientClosed tests that requests whose client went away are logged with status 499 instead of what the handler wrote
+func TestMiddleware_ClientClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := accesslog.New(accesslog.Config{Format: accesslog.FormatCommon, Output: path})
+	require.NoError(t, err)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	failed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed to get product", http.StatusInternalServerError)
+	})
+	l.Middleware(disconnect.Middleware(failed)).ServeHTTP(httptest.NewRecorder(), newRequest().WithContext(ctx))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"GET /inventory/get?id=1 HTTP/1.1" 499 -`)
+}
+
+// TestNew_UnknownFormat tests that invalid formats are rejected
+func TestNew_UnknownFormat(t *testing.T) {
+	_, err := accesslog.New(accesslog.Config{Format: "apache"})
+	assert.Error(t, err)
+}
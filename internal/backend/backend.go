@@ -0,0 +1,276 @@
+// Package backend manages the gateway's outbound gRPC connections. Each named
+// backend is served by a pool of client connections with configurable
+// keepalive, reconnect backoff and maximum connection age, and optionally
+// health-checked fallback addresses.
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/interceptor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Names of the backends the gateway talks to.
+const (
+	Auth      = "auth"
+	Inventory = "inventory"
+
+	// Notifications is optional and only connected when configured.
+	Notifications = "notifications"
+)
+
+// Config describes how to connect to one backend.
+type Config struct {
+	// Address is the gRPC target. Defaults to the top-level grpc_addr.
+	Address string `yaml:"address"`
+
+	// Fallbacks are addresses RPCs fail over to, in order, while health
+	// checks report the address before them unhealthy.
+	Fallbacks []string `yaml:"fallbacks"`
+
+	// HealthCheck configures active health checks of every address.
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+
+	// PoolSize is the number of client connections RPCs are spread across. Default: 1.
+	PoolSize int `yaml:"pool_size"`
+
+	// MaxConnectionAge recycles a connection once it is this old (with ±10%
+	// jitter), so traffic rebalances across backend replicas. 0 disables recycling.
+	MaxConnectionAge time.Duration `yaml:"max_connection_age"`
+
+	// MaxConnectionAgeGrace is how long a recycled connection is kept open for
+	// in-flight RPCs before being closed. Default: 30s.
+	MaxConnectionAgeGrace time.Duration `yaml:"max_connection_age_grace"`
+
+	Keepalive KeepaliveConfig `yaml:"keepalive"`
+	Backoff   BackoffConfig   `yaml:"backoff"`
+
+	// Metadata is sent with every call to the backend, e.g. x-api-version,
+	// unless the call already carries the key.
+	Metadata map[string]string `yaml:"metadata"`
+
+	// LabelPrefix namespaces the environment labels sent to the backend:
+	// label env is sent as <prefix>env. Default: DefaultLabelPrefix.
+	LabelPrefix string `yaml:"label_prefix"`
+}
+
+// DefaultLabelPrefix is the default LabelPrefix.
+const DefaultLabelPrefix = "x-"
+
+// Defaults apply to every backend.
+type Defaults struct {
+	// Address is the address of backends without one.
+	Address string
+
+	// Labels are sent with every call to every backend, e.g. env: prod,
+	// under the LabelPrefix of the backend.
+	Labels map[string]string
+}
+
+// KeepaliveConfig controls client-side HTTP/2 keepalive pings. Pings are
+// disabled when Time is 0.
+type KeepaliveConfig struct {
+	// Time is the idle period after which the client pings the backend.
+	Time time.Duration `yaml:"time"`
+
+	// Timeout is how long to wait for a ping ack before closing the connection. Default: 20s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// PermitWithoutStream allows pings when there are no active RPCs.
+	PermitWithoutStream bool `yaml:"permit_without_stream"`
+}
+
+// BackoffConfig controls reconnect backoff. Zero values use the gRPC defaults.
+type BackoffConfig struct {
+	BaseDelay         time.Duration `yaml:"base_delay"`
+	Multiplier        float64       `yaml:"multiplier"`
+	Jitter            float64       `yaml:"jitter"`
+	MaxDelay          time.Duration `yaml:"max_delay"`
+	MinConnectTimeout time.Duration `yaml:"min_connect_timeout"`
+}
+
+func (c Config) withDefaults(defaultAddr string) Config {
+	if c.Address == "" {
+		c.Address = defaultAddr
+	}
+	if c.LabelPrefix == "" {
+		c.LabelPrefix = DefaultLabelPrefix
+	}
+	if c.PoolSize <= 0 {
+		c.PoolSize = 1
+	}
+	if c.MaxConnectionAgeGrace <= 0 {
+		c.MaxConnectionAgeGrace = 30 * time.Second
+	}
+	if c.HealthCheck.Mode == "" && len(c.Fallbacks) > 0 {
+		// failing over needs health checks; TCP works with any backend
+		c.HealthCheck.Mode = HealthTCP
+	}
+	c.HealthCheck = c.HealthCheck.withDefaults()
+	return c
+}
+
+func (c Config) dialOptions() []grpc.DialOption {
+	var opts []grpc.DialOption
+
+	if c.Keepalive.Time > 0 {
+		timeout := c.Keepalive.Timeout
+		if timeout <= 0 {
+			timeout = 20 * time.Second
+		}
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                c.Keepalive.Time,
+			Timeout:             timeout,
+			PermitWithoutStream: c.Keepalive.PermitWithoutStream,
+		}))
+	}
+
+	bc := backoff.DefaultConfig
+	if c.Backoff.BaseDelay > 0 {
+		bc.BaseDelay = c.Backoff.BaseDelay
+	}
+	if c.Backoff.Multiplier > 0 {
+		bc.Multiplier = c.Backoff.Multiplier
+	}
+	if c.Backoff.Jitter > 0 {
+		bc.Jitter = c.Backoff.Jitter
+	}
+	if c.Backoff.MaxDelay > 0 {
+		bc.MaxDelay = c.Backoff.MaxDelay
+	}
+	minConnect := c.Backoff.MinConnectTimeout
+	if minConnect <= 0 {
+		minConnect = 20 * time.Second
+	}
+	opts = append(opts, grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff:           bc,
+		MinConnectTimeout: minConnect,
+	}))
+
+	return opts
+}
+
+// StaticMetadata returns the metadata sent with every call to a backend
+// configured with c: labels under the label prefix of c, and the Metadata
+// of c, which wins over a label of the same key.
+func (c Config) StaticMetadata(labels map[string]string) map[string]string {
+	prefix := c.LabelPrefix
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	md := make(map[string]string, len(labels)+len(c.Metadata))
+	for name, value := range labels {
+		md[strings.ToLower(prefix+name)] = value
+	}
+	for key, value := range c.Metadata {
+		md[strings.ToLower(key)] = value
+	}
+	return md
+}
+
+// Manager owns the connection pools of all backends.
+type Manager struct {
+	pools map[string]*Pool
+}
+
+// NewManager creates a pool for every backend in cfgs plus the standard
+// backends (Auth, Inventory), which default to the address of defaults.
+// opts are applied to every connection in addition to the per-backend
+// options; the static metadata of a backend is applied after them.
+func NewManager(cfgs map[string]Config, defaults Defaults, opts ...grpc.DialOption) (*Manager, error) {
+	m := &Manager{pools: make(map[string]*Pool)}
+
+	all := make(map[string]Config, len(cfgs)+2)
+	all[Auth] = Config{}
+	all[Inventory] = Config{}
+	for name, cfg := range cfgs {
+		all[name] = cfg
+	}
+
+	for name, cfg := range all {
+		cfg = cfg.withDefaults(defaults.Address)
+		if cfg.Address == "" {
+			m.Close()
+			return nil, fmt.Errorf("backend %s has no address", name)
+		}
+		if err := cfg.HealthCheck.validate(); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("backend %s: %w", name, err)
+		}
+		md := cfg.StaticMetadata(defaults.Labels)
+		if err := interceptor.CheckMetadata(md); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("backend %s: %w", name, err)
+		}
+		dialOpts := append(cfg.dialOptions(), opts...)
+		p, err := newPool(name, cfg, append(dialOpts, interceptor.StaticMetadata(md)...))
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to connect backend %s: %w", name, err)
+		}
+		m.pools[name] = p
+	}
+	return m, nil
+}
+
+// Pool returns the pool for the named backend, or nil if it is not configured.
+func (m *Manager) Pool(name string) *Pool {
+	return m.pools[name]
+}
+
+// Status reports the state of every backend, sorted by name.
+func (m *Manager) Status() []Status {
+	out := make([]Status, 0, len(m.pools))
+	for _, p := range m.pools {
+		out = append(out, p.Status())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Probe checks every backend in parallel and returns the error of each
+// backend that is not reachable before ctx is done, by name. Backends named
+// in skip are not checked.
+func (m *Manager) Probe(ctx context.Context, skip ...string) map[string]error {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = map[string]error{}
+	)
+	for name, p := range m.pools {
+		if slices.Contains(skip, name) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Probe(ctx); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// Close closes all pools.
+func (m *Manager) Close() error {
+	var firstErr error
+	for _, p := range m.pools {
+		if err := p.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
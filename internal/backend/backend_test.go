@@ -0,0 +1,320 @@
+package backend_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/backend"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+type inventoryServer struct {
+	pbInv.UnimplementedInventoryServiceServer
+}
+
+func (inventoryServer) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	return &pbInv.GetResponse{Product: &pbInv.Product{Id: in.Id}}, nil
+}
+
+// namedServer answers GetProduct with its own name, to tell backends apart
+type namedServer struct {
+	pbInv.UnimplementedInventoryServiceServer
+	name string
+}
+
+func (s namedServer) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	return &pbInv.GetResponse{Product: &pbInv.Product{Id: in.Id, Name: s.name}}, nil
+}
+
+func startNamedServer(t *testing.T, name string) (string, *health.Server, *grpc.Server) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pbInv.RegisterInventoryServiceServer(srv, namedServer{name: name})
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String(), hs, srv
+}
+
+func servedBy(t *testing.T, pool *backend.Pool) string {
+	t.Helper()
+	resp, err := pbInv.NewInventoryServiceClient(pool).GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	return resp.Product.Name
+}
+
+func startServer(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pbInv.RegisterInventoryServiceServer(srv, inventoryServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// TestManager_DefaultsAndPooling tests that standard backends default to the shared address and pools serve RPCs
+func TestManager_DefaultsAndPooling(t *testing.T) {
+	addr := startServer(t)
+
+	m, err := backend.NewManager(map[string]backend.Config{
+		backend.Inventory: {PoolSize: 3, Keepalive: backend.KeepaliveConfig{Time: time.Minute}},
+	}, backend.Defaults{Address: addr}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer m.Close()
+
+	client := pbInv.NewInventoryServiceClient(m.Pool(backend.Inventory))
+	for i := 0; i < 6; i++ {
+		resp, err := client.GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+		require.NoError(t, err)
+		assert.Equal(t, "p1", resp.Product.Id)
+	}
+
+	status := m.Status()
+	require.Len(t, status, 2)
+	assert.Equal(t, backend.Auth, status[0].Name)
+	assert.Equal(t, addr, status[0].Address)
+	assert.Equal(t, 1, status[0].PoolSize)
+	assert.Equal(t, backend.Inventory, status[1].Name)
+	require.Len(t, status[1].Conns, 3)
+	for _, c := range status[1].Conns {
+		assert.Equal(t, "READY", c.State)
+	}
+}
+
+// TestManager_MissingAddress tests that a backend without any address is rejected
+func TestManager_MissingAddress(t *testing.T) {
+	_, err := backend.NewManager(nil, backend.Defaults{})
+	assert.Error(t, err)
+}
+
+// TestManager_StaticMetadata tests that labels and backend metadata are sent with every call as defaults
+func TestManager_StaticMetadata(t *testing.T) {
+	calls := make(chan metadata.MD, 1)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		calls <- md
+		return handler(ctx, req)
+	}))
+	pbInv.RegisterInventoryServiceServer(srv, inventoryServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	m, err := backend.NewManager(map[string]backend.Config{
+		backend.Inventory: {
+			LabelPrefix: "x-shop-",
+			Metadata:    map[string]string{"X-Api-Version": "2", "x-caller": "gateway", "x-shop-region": "eu-central-1"},
+		},
+	}, backend.Defaults{
+		Address: lis.Addr().String(),
+		Labels:  map[string]string{"env": "prod", "region": "eu-west-1"},
+	}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer m.Close()
+
+	client := pbInv.NewInventoryServiceClient(m.Pool(backend.Inventory))
+	_, err = client.GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	md := <-calls
+	assert.Equal(t, []string{"prod"}, md.Get("x-shop-env"))
+	assert.Equal(t, []string{"eu-central-1"}, md.Get("x-shop-region"), "backend metadata wins over labels")
+	assert.Equal(t, []string{"2"}, md.Get("x-api-version"))
+	assert.Equal(t, []string{"gateway"}, md.Get("x-caller"))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-version", "3")
+	_, err = client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3"}, (<-calls).Get("x-api-version"), "metadata of the call is kept")
+
+	_, err = client.GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod"}, (<-calls).Get("x-shop-env"))
+
+	auth := pbInv.NewInventoryServiceClient(m.Pool(backend.Auth))
+	_, err = auth.GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	md = <-calls
+	assert.Equal(t, []string{"prod"}, md.Get("x-env"), "labels use the default prefix")
+	assert.Empty(t, md.Get("x-caller"))
+}
+
+// TestManager_InvalidMetadata tests that metadata the gateway sets itself or gRPC cannot send is rejected
+func TestManager_InvalidMetadata(t *testing.T) {
+	for _, md := range []map[string]string{
+		{"x-user-id": "admin"},
+		{"grpc-timeout": "1S"},
+		{"x-api version": "2"},
+		{"x-note": "caf\u00e9"},
+	} {
+		_, err := backend.NewManager(map[string]backend.Config{backend.Inventory: {Metadata: md}}, backend.Defaults{Address: "127.0.0.1:1"}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		assert.ErrorContains(t, err, "backend inventory", md)
+	}
+}
+
+// TestPool_MaxConnectionAge tests that connections are replaced once they exceed their maximum age
+func TestPool_MaxConnectionAge(t *testing.T) {
+	addr := startServer(t)
+
+	m, err := backend.NewManager(map[string]backend.Config{
+		backend.Inventory: {MaxConnectionAge: time.Second, MaxConnectionAgeGrace: 10 * time.Millisecond},
+	}, backend.Defaults{Address: addr}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer m.Close()
+
+	pool := m.Pool(backend.Inventory)
+	time.Sleep(600 * time.Millisecond)
+	require.Greater(t, pool.Status().Conns[0].AgeSeconds, 0.5)
+	assert.Eventually(t, func() bool {
+		return pool.Status().Conns[0].AgeSeconds < 0.5
+	}, 5*time.Second, 100*time.Millisecond, "connection should have been recycled")
+
+	client := pbInv.NewInventoryServiceClient(pool)
+	_, err = client.GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+	assert.NoError(t, err)
+}
+
+// TestPool_Failover tests that traffic moves to a fallback while the primary fails gRPC health checks and back once it recovers
+func TestPool_Failover(t *testing.T) {
+	primary, primaryHealth, _ := startNamedServer(t, "primary")
+	fallback, _, _ := startNamedServer(t, "fallback")
+
+	m, err := backend.NewManager(map[string]backend.Config{
+		backend.Inventory: {
+			Address:   primary,
+			Fallbacks: []string{fallback},
+			HealthCheck: backend.HealthCheckConfig{
+				Mode:               backend.HealthGRPC,
+				Interval:           20 * time.Millisecond,
+				UnhealthyThreshold: 2,
+				HealthyThreshold:   2,
+			},
+		},
+	}, backend.Defaults{Address: primary}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer m.Close()
+
+	pool := m.Pool(backend.Inventory)
+	assert.Equal(t, "primary", servedBy(t, pool))
+
+	primaryHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	require.Eventually(t, func() bool {
+		return pool.Status().Address == fallback
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "fallback", servedBy(t, pool))
+
+	status := pool.Status()
+	require.Len(t, status.Targets, 2)
+	assert.False(t, status.Targets[0].Healthy)
+	assert.Equal(t, "NOT_SERVING", status.Targets[0].LastError)
+	assert.True(t, status.Targets[1].Active)
+
+	primaryHealth.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	require.Eventually(t, func() bool {
+		return pool.Status().Address == primary
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "primary", servedBy(t, pool))
+}
+
+// TestPool_FailoverTCP tests that fallbacks default to TCP probes
+func TestPool_FailoverTCP(t *testing.T) {
+	primary, _, primarySrv := startNamedServer(t, "primary")
+	fallback, _, _ := startNamedServer(t, "fallback")
+
+	m, err := backend.NewManager(map[string]backend.Config{
+		backend.Inventory: {
+			Address:     primary,
+			Fallbacks:   []string{fallback},
+			HealthCheck: backend.HealthCheckConfig{Interval: 20 * time.Millisecond, UnhealthyThreshold: 1},
+		},
+	}, backend.Defaults{Address: primary}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer m.Close()
+
+	pool := m.Pool(backend.Inventory)
+	assert.Equal(t, "primary", servedBy(t, pool))
+	primarySrv.Stop()
+	require.Eventually(t, func() bool {
+		return pool.Status().Address == fallback
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "fallback", servedBy(t, pool))
+}
+
+// TestManager_HealthCheckMode tests that unknown health check modes are rejected
+func TestManager_HealthCheckMode(t *testing.T) {
+	_, err := backend.NewManager(map[string]backend.Config{
+		backend.Inventory: {HealthCheck: backend.HealthCheckConfig{Mode: "http"}},
+	}, backend.Defaults{Address: "127.0.0.1:1"}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Error(t, err)
+}
+
+// TestManager_Probe tests that unreachable backends are reported by name
+func TestManager_Probe(t *testing.T) {
+	addr := startServer(t)
+	m, err := backend.NewManager(map[string]backend.Config{
+		backend.Auth: {Address: "127.0.0.1:1"},
+	}, backend.Defaults{Address: addr}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer m.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	errs := m.Probe(ctx)
+	require.Len(t, errs, 1)
+	assert.Error(t, errs[backend.Auth])
+}
+
+// TestPool_History tests that the recent health checks of each address are reported with failures and flips
+func TestPool_History(t *testing.T) {
+	primary, primaryHealth, _ := startNamedServer(t, "primary")
+
+	m, err := backend.NewManager(map[string]backend.Config{
+		backend.Inventory: {
+			Address: primary,
+			HealthCheck: backend.HealthCheckConfig{
+				Mode:               backend.HealthGRPC,
+				Interval:           10 * time.Millisecond,
+				UnhealthyThreshold: 1,
+				History:            10,
+			},
+		},
+	}, backend.Defaults{Address: primary}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.Eventually(t, func() bool {
+		h := m.History()
+		return len(h) == 1 && h[0].Checks == 10
+	}, 5*time.Second, 10*time.Millisecond)
+	h := m.History()[0]
+	assert.Equal(t, backend.Inventory, h.Backend)
+	assert.Equal(t, primary, h.Address)
+	assert.True(t, h.Healthy)
+	assert.Equal(t, 10, h.Successes)
+	assert.Zero(t, h.Flips)
+
+	primaryHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	require.Eventually(t, func() bool {
+		h = m.History()[0]
+		return !h.Healthy && h.Failures >= 2 && h.Successes > 0
+	}, 5*time.Second, time.Millisecond)
+	assert.Len(t, h.Results, 10)
+	assert.Equal(t, 1, h.Flips)
+	assert.Equal(t, "NOT_SERVING", h.LastError)
+	assert.False(t, h.LastErrorAt.IsZero())
+	assert.False(t, h.Results[len(h.Results)-1].OK)
+}
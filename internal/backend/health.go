@@ -0,0 +1,243 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Health check modes.
+const (
+	// HealthGRPC calls the standard grpc.health.v1.Health/Check RPC.
+	HealthGRPC = "grpc"
+	// HealthTCP only checks that the address accepts TCP connections.
+	HealthTCP = "tcp"
+)
+
+// HealthCheckConfig configures active health checks of a backend.
+type HealthCheckConfig struct {
+	// Mode is "grpc" or "tcp". Health checks are off when empty, unless
+	// fallbacks are configured, which default to "tcp".
+	Mode string `yaml:"mode"`
+
+	// Service is the service name sent in gRPC health checks. Empty asks
+	// for the health of the server as a whole.
+	Service string `yaml:"service"`
+
+	// Interval is the time between checks of each address. Default: 5s.
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout bounds each check. Default: 2s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// UnhealthyThreshold is the number of consecutive failed checks that
+	// mark an address unhealthy. Default: 3.
+	UnhealthyThreshold int `yaml:"unhealthy_threshold"`
+
+	// HealthyThreshold is the number of consecutive successful checks that
+	// mark an unhealthy address healthy again. Default: 2.
+	HealthyThreshold int `yaml:"healthy_threshold"`
+
+	// History is the number of recent checks of each address kept for
+	// /statusz. Default: 60.
+	History int `yaml:"history"`
+}
+
+func (c HealthCheckConfig) withDefaults() HealthCheckConfig {
+	if c.Interval <= 0 {
+		c.Interval = 5 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 2 * time.Second
+	}
+	if c.UnhealthyThreshold <= 0 {
+		c.UnhealthyThreshold = 3
+	}
+	if c.HealthyThreshold <= 0 {
+		c.HealthyThreshold = 2
+	}
+	if c.History <= 0 {
+		c.History = 60
+	}
+	return c
+}
+
+func (c HealthCheckConfig) validate() error {
+	switch c.Mode {
+	case "", HealthGRPC, HealthTCP:
+		return nil
+	default:
+		return fmt.Errorf("unknown health check mode %q", c.Mode)
+	}
+}
+
+var (
+	healthyGauge = promauto.With(metrics.Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "backend",
+		Name:      "healthy",
+		Help:      "Whether each backend address passes its health checks (1) or not (0).",
+	}, []string{"backend", "address"})
+
+	failoversTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "backend",
+		Name:      "failovers_total",
+		Help:      "Switches of a backend's traffic to another address, by the address switched to.",
+	}, []string{"backend", "address"})
+)
+
+// watch checks the health of t until the pool is closed.
+func (p *Pool) watch(t *target) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.cfg.HealthCheck.Interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		err := p.check(p.ctx, t)
+		if p.ctx.Err() != nil {
+			return
+		}
+		p.report(t, err, time.Since(start))
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check runs one health check of t.
+func (p *Pool) check(ctx context.Context, t *target) error {
+	hc := p.cfg.HealthCheck
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+
+	switch hc.Mode {
+	case HealthTCP:
+		var d net.Dialer
+		network, addr := probeAddress(t.address)
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	default:
+		p.mu.RLock()
+		conn := t.conns[0]
+		p.mu.RUnlock()
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: hc.Service})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return errors.New(resp.Status.String())
+		}
+		return nil
+	}
+}
+
+// Probe checks that the address RPCs are sent to is reachable: it runs the
+// configured health check, or waits for a connection to become ready when
+// health checks are off.
+func (p *Pool) Probe(ctx context.Context) error {
+	if p.cfg.HealthCheck.Mode != "" {
+		p.mu.RLock()
+		t := p.targets[p.active]
+		p.mu.RUnlock()
+		return p.check(ctx, t)
+	}
+	c := p.pick()
+	for {
+		state := c.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !c.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("%s not ready: connection is %s", c.Target(), strings.ToLower(state.String()))
+		}
+	}
+}
+
+// probeAddress turns a gRPC target such as "dns:///inventory:50051" or
+// "unix:///run/inventory.sock" into a network and address to dial.
+func probeAddress(target string) (network, addr string) {
+	switch {
+	case strings.HasPrefix(target, "unix://"):
+		return "unix", strings.TrimPrefix(target, "unix://")
+	case strings.HasPrefix(target, "unix:"):
+		return "unix", strings.TrimPrefix(target, "unix:")
+	case strings.HasPrefix(target, "dns:///"):
+		return "tcp", strings.TrimPrefix(target, "dns:///")
+	case strings.HasPrefix(target, "passthrough:///"):
+		return "tcp", strings.TrimPrefix(target, "passthrough:///")
+	default:
+		return "tcp", target
+	}
+}
+
+// report records the outcome of a check of t that took latency, and moves
+// traffic to the first healthy address when t changes state.
+func (p *Pool) report(t *target, err error, latency time.Duration) {
+	hc := p.cfg.HealthCheck
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t.lastCheck = time.Now()
+	t.lastError = ""
+	res := CheckResult{Time: t.lastCheck, OK: err == nil, LatencyMS: float64(latency) / float64(time.Millisecond)}
+	changed := false
+	if err != nil {
+		t.lastError = err.Error()
+		res.Error = t.lastError
+		t.successes = 0
+		t.failures++
+		if t.healthy && t.failures >= hc.UnhealthyThreshold {
+			t.healthy, changed = false, true
+			p.log.Warn("Backend address unhealthy", zap.String("backend", p.name), zap.String("address", t.address), zap.Error(err))
+		}
+	} else {
+		t.failures = 0
+		t.successes++
+		if !t.healthy && t.successes >= hc.HealthyThreshold {
+			t.healthy, changed = true, true
+			p.log.Info("Backend address healthy again", zap.String("backend", p.name), zap.String("address", t.address))
+		}
+	}
+	t.record(res, hc.History)
+	if !changed {
+		return
+	}
+	if t.healthy {
+		healthyGauge.WithLabelValues(p.name, t.address).Set(1)
+	} else {
+		healthyGauge.WithLabelValues(p.name, t.address).Set(0)
+	}
+
+	// prefer the first healthy address; with none, keep trying the primary
+	active := 0
+	for i, candidate := range p.targets {
+		if candidate.healthy {
+			active = i
+			break
+		}
+	}
+	if active == p.active {
+		return
+	}
+	from := p.targets[p.active].address
+	p.active = active
+	to := p.targets[active].address
+	failoversTotal.WithLabelValues(p.name, to).Inc()
+	p.log.Warn("Backend failed over", zap.String("backend", p.name), zap.String("from", from), zap.String("to", to))
+}
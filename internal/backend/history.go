@@ -0,0 +1,125 @@
+package backend
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// CheckResult is the outcome of one health check.
+type CheckResult struct {
+	Time      time.Time `json:"time"`
+	OK        bool      `json:"ok"`
+	LatencyMS float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Latencies are health check latency percentiles, in milliseconds.
+type Latencies struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// History summarizes the recent health checks of one backend address, so
+// that flapping dependencies stand out.
+type History struct {
+	Backend string `json:"backend"`
+	Address string `json:"address"`
+	Healthy bool   `json:"healthy"`
+	Active  bool   `json:"active"`
+
+	// Checks, Successes and Failures count the checks in the window.
+	Checks    int `json:"checks"`
+	Successes int `json:"successes"`
+	Failures  int `json:"failures"`
+
+	// Flips counts the checks in the window whose outcome differs from
+	// the one before; a steady address has none.
+	Flips int `json:"flips"`
+
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+
+	Latency Latencies `json:"latency_ms"`
+
+	// Results are the checks in the window, oldest first.
+	Results []CheckResult `json:"results"`
+}
+
+// record appends the outcome of a check of t to its history, dropping the
+// oldest one beyond size. The caller holds Pool.mu.
+func (t *target) record(res CheckResult, size int) {
+	if len(t.history) >= size {
+		t.history = slices.Delete(t.history, 0, len(t.history)-size+1)
+	}
+	t.history = append(t.history, res)
+	if !res.OK {
+		t.lastFailure = res
+	}
+}
+
+// History reports the recent health checks of every address of the pool.
+// Pools without health checks have none.
+func (p *Pool) History() []History {
+	if p.cfg.HealthCheck.Mode == "" {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]History, 0, len(p.targets))
+	for i, t := range p.targets {
+		h := History{
+			Backend:     p.name,
+			Address:     t.address,
+			Healthy:     t.healthy,
+			Active:      i == p.active,
+			Checks:      len(t.history),
+			LastError:   t.lastFailure.Error,
+			LastErrorAt: t.lastFailure.Time,
+			Results:     slices.Clone(t.history),
+		}
+		latencies := make([]float64, 0, len(t.history))
+		for j, res := range t.history {
+			if res.OK {
+				h.Successes++
+			} else {
+				h.Failures++
+			}
+			if j > 0 && res.OK != t.history[j-1].OK {
+				h.Flips++
+			}
+			latencies = append(latencies, res.LatencyMS)
+		}
+		if len(latencies) > 0 {
+			slices.Sort(latencies)
+			h.Latency = Latencies{
+				P50: percentile(latencies, 0.5),
+				P95: percentile(latencies, 0.95),
+				P99: percentile(latencies, 0.99),
+				Max: latencies[len(latencies)-1],
+			}
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// History reports the recent health checks of every backend address,
+// sorted by backend name.
+func (m *Manager) History() []History {
+	var out []History
+	for _, p := range m.pools {
+		out = append(out, p.History()...)
+	}
+	// addresses of a pool keep their configured order
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Backend < out[j].Backend })
+	return out
+}
+
+// percentile returns the nearest-rank percentile p of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
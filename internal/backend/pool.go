@@ -0,0 +1,253 @@
+package backend
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// Pool spreads RPCs round-robin across several connections to one backend.
+// It implements grpc.ClientConnInterface so generated clients can use it directly.
+//
+// A backend with fallback addresses has a set of connections per address.
+// RPCs go to the first healthy address, in configuration order, so traffic
+// fails over when health checks mark the primary unhealthy and fails back
+// once it recovers.
+type Pool struct {
+	name string
+	cfg  Config
+	opts []grpc.DialOption
+	log  *zap.Logger
+
+	mu      sync.RWMutex
+	targets []*target
+	active  int
+	next    atomic.Uint64
+
+	// ctx is canceled on Close to stop recycling and health checks
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// target is the connections to one address of a backend and its health.
+type target struct {
+	address string
+	conns   []*pooledConn
+
+	// guarded by Pool.mu
+	healthy   bool
+	failures  int
+	successes int
+	lastCheck time.Time
+	lastError string
+
+	// recent checks, oldest first, and the last failed one
+	history     []CheckResult
+	lastFailure CheckResult
+}
+
+type pooledConn struct {
+	*grpc.ClientConn
+	created time.Time
+	maxAge  time.Duration
+}
+
+// Status describes a backend pool for the admin API.
+type Status struct {
+	Name string `json:"name"`
+	// Address is the address RPCs are currently sent to.
+	Address  string         `json:"address"`
+	PoolSize int            `json:"pool_size"`
+	Conns    []ConnStatus   `json:"connections"`
+	Targets  []TargetStatus `json:"targets,omitempty"`
+}
+
+// ConnStatus describes a single pooled connection.
+type ConnStatus struct {
+	State      string  `json:"state"`
+	AgeSeconds float64 `json:"age_seconds"`
+}
+
+// TargetStatus describes the health of one address of a backend with
+// health checks or fallbacks.
+type TargetStatus struct {
+	Address   string    `json:"address"`
+	Healthy   bool      `json:"healthy"`
+	Active    bool      `json:"active"`
+	LastCheck time.Time `json:"last_check,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+func newPool(name string, cfg Config, opts []grpc.DialOption) (*Pool, error) {
+	p := &Pool{
+		name: name,
+		cfg:  cfg,
+		opts: opts,
+		// resolved before the background goroutines start, which would
+		// otherwise race to initialize the default logger
+		log: logger.Logger(),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	for _, addr := range append([]string{cfg.Address}, cfg.Fallbacks...) {
+		t := &target{address: addr, healthy: true}
+		p.targets = append(p.targets, t)
+		for i := 0; i < cfg.PoolSize; i++ {
+			c, err := p.dial(addr)
+			if err != nil {
+				p.cancel()
+				p.closeConns()
+				return nil, err
+			}
+			t.conns = append(t.conns, c)
+		}
+		healthyGauge.WithLabelValues(name, addr).Set(1)
+	}
+
+	if cfg.MaxConnectionAge > 0 {
+		p.wg.Add(1)
+		go p.recycle()
+	}
+	if cfg.HealthCheck.Mode != "" {
+		for _, t := range p.targets {
+			p.wg.Add(1)
+			go p.watch(t)
+		}
+	}
+	return p, nil
+}
+
+func (p *Pool) dial(addr string) (*pooledConn, error) {
+	cc, err := grpc.NewClient(addr, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+	// start connecting eagerly; gRPC reconnects with backoff from here on
+	cc.Connect()
+
+	c := &pooledConn{ClientConn: cc, created: time.Now()}
+	if p.cfg.MaxConnectionAge > 0 {
+		jitter := 0.9 + rand.Float64()*0.2
+		c.maxAge = time.Duration(float64(p.cfg.MaxConnectionAge) * jitter)
+	}
+	return c, nil
+}
+
+func (p *Pool) pick() *pooledConn {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	conns := p.targets[p.active].conns
+	return conns[p.next.Add(1)%uint64(len(conns))]
+}
+
+// Invoke performs a unary RPC on the next connection in the pool.
+func (p *Pool) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	return p.pick().Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream opens a stream on the next connection in the pool.
+func (p *Pool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return p.pick().NewStream(ctx, desc, method, opts...)
+}
+
+// recycle replaces connections that have exceeded their maximum age. The old
+// connection stays open for the grace period so in-flight RPCs can finish.
+func (p *Pool) recycle() {
+	defer p.wg.Done()
+
+	interval := p.cfg.MaxConnectionAge / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, t := range p.targets {
+			for i := range p.cfg.PoolSize {
+				p.mu.RLock()
+				old := t.conns[i]
+				p.mu.RUnlock()
+				if time.Since(old.created) < old.maxAge {
+					continue
+				}
+
+				fresh, err := p.dial(t.address)
+				if err != nil {
+					p.log.Warn("Failed to recycle backend connection", zap.String("backend", p.name), zap.Error(err))
+					continue
+				}
+				p.mu.Lock()
+				t.conns[i] = fresh
+				p.mu.Unlock()
+
+				time.AfterFunc(p.cfg.MaxConnectionAgeGrace, func() { old.Close() })
+			}
+		}
+	}
+}
+
+// Status reports the current state of every connection in the pool.
+func (p *Pool) Status() Status {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	active := p.targets[p.active]
+	s := Status{
+		Name:     p.name,
+		Address:  active.address,
+		PoolSize: len(active.conns),
+	}
+	for _, c := range active.conns {
+		s.Conns = append(s.Conns, ConnStatus{
+			State:      c.GetState().String(),
+			AgeSeconds: time.Since(c.created).Seconds(),
+		})
+	}
+	if len(p.targets) > 1 || p.cfg.HealthCheck.Mode != "" {
+		for i, t := range p.targets {
+			s.Targets = append(s.Targets, TargetStatus{
+				Address:   t.address,
+				Healthy:   t.healthy,
+				Active:    i == p.active,
+				LastCheck: t.lastCheck,
+				LastError: t.lastError,
+			})
+		}
+	}
+	return s
+}
+
+// Close stops connection recycling and health checks and closes every
+// connection.
+func (p *Pool) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	return p.closeConns()
+}
+
+func (p *Pool) closeConns() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var firstErr error
+	for _, t := range p.targets {
+		for _, c := range t.conns {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,279 @@
+// Package batch serves POST /batch, which runs several API requests sent in
+// one body and returns all their responses at once, so that mobile clients
+// on slow links can save round trips. Each sub-request is dispatched to the
+// gateway router as if it had arrived on its own, passing through the same
+// middleware (authentication, access control, limits, logging) as a
+// standalone request.
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Path is the route of the batch endpoint. Sub-requests may not target it.
+const Path = "/batch"
+
+// Config configures the batch endpoint.
+type Config struct {
+	// MaxRequests is the maximum number of sub-requests in one batch. Default: 20.
+	MaxRequests int `yaml:"max_requests"`
+
+	// Concurrency is the number of sub-requests of one batch run at the
+	// same time. Default: 4.
+	Concurrency int `yaml:"concurrency"`
+
+	// MaxBodyBytes bounds the size of the batch request body. Default: 1 MiB.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+
+	// MaxResponseBytes bounds the body of each sub-response; larger ones are
+	// replaced with an error. Default: 1 MiB.
+	MaxResponseBytes int `yaml:"max_response_bytes"`
+}
+
+// Request is a sub-request of a batch.
+type Request struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// Response is the outcome of a sub-request. Body holds JSON responses as
+// JSON and any other response as a string.
+type Response struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    any               `json:"body,omitempty"`
+}
+
+// hopHeaders are headers of the batch request that are not passed on to
+// sub-requests, because they describe the batch body or are set per
+// sub-request.
+var hopHeaders = []string{"Content-Length", "Content-Type", "Content-Encoding", "Accept-Encoding", "Expect", "If-Match", "If-None-Match", requestid.Header}
+
+var requestsTotal = promauto.With(metrics.Registry).NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "batch",
+	Name:      "requests_total",
+	Help:      "Sub-requests run through the batch endpoint.",
+})
+
+// subRequestKey marks the context of sub-requests, so that a sub-request
+// that reaches the batch endpoint after a rewrite, e.g. by case-insensitive
+// routing, is still refused.
+type subRequestKey struct{}
+
+// IsSubRequest reports whether ctx is the context of a batch sub-request.
+func IsSubRequest(ctx context.Context) bool {
+	return ctx.Value(subRequestKey{}) != nil
+}
+
+// Handler runs batches against a router.
+type Handler struct {
+	cfg    Config
+	router http.Handler
+}
+
+// New returns a Handler dispatching sub-requests to router, normally the
+// root router the Handler itself is mounted on.
+func New(cfg Config, router http.Handler) *Handler {
+	if cfg.MaxRequests <= 0 {
+		cfg.MaxRequests = 20
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 4
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.MaxResponseBytes <= 0 {
+		cfg.MaxResponseBytes = 1 << 20
+	}
+	return &Handler{cfg: cfg, router: router}
+}
+
+// ServeHTTP decodes a JSON array of sub-requests, runs them concurrently and
+// answers 200 with the array of their responses, in request order. The
+// batch itself only fails when its body is invalid; failed sub-requests are
+// reported through their own status.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if IsSubRequest(r.Context()) {
+		http.Error(w, "batches cannot be nested", http.StatusBadRequest)
+		return
+	}
+	var reqs []Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.cfg.MaxBodyBytes)).Decode(&reqs); err != nil {
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if len(reqs) == 0 {
+		http.Error(w, "batch is empty", http.StatusBadRequest)
+		return
+	}
+	if len(reqs) > h.cfg.MaxRequests {
+		http.Error(w, fmt.Sprintf("batch exceeds %d requests", h.cfg.MaxRequests), http.StatusBadRequest)
+		return
+	}
+	for i, req := range reqs {
+		if err := validate(req); err != nil {
+			http.Error(w, fmt.Sprintf("request %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	batchID, _ := requestid.FromContext(r.Context())
+	out := make([]Response, len(reqs))
+	sem := make(chan struct{}, h.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out[i] = h.do(r, req, subID(batchID, i))
+		}()
+	}
+	wg.Wait()
+	requestsTotal.Add(float64(len(reqs)))
+
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+	}
+}
+
+func validate(req Request) error {
+	switch strings.ToUpper(req.Method) {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return fmt.Errorf("unsupported method %q", req.Method)
+	}
+	u, err := url.Parse(req.Path)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return fmt.Errorf("path must be an absolute path, got %q", req.Path)
+	}
+	if p := strings.ToLower(path.Clean(u.Path)); p == Path || strings.HasPrefix(p, Path+"/") {
+		return fmt.Errorf("batches cannot be nested")
+	}
+	return nil
+}
+
+// subID derives the request ID of a sub-request from the batch request ID,
+// so that its logs can be traced back to the batch.
+func subID(batchID string, i int) string {
+	if batchID == "" {
+		return ""
+	}
+	return batchID + "." + strconv.Itoa(i)
+}
+
+// do runs one sub-request through the router. It carries the batch's
+// headers (credentials, client IP, Accept), overridden by its own headers.
+// Its context is canceled with the batch but carries none of the values the
+// middleware stored for the batch, such as its route. It is marked as a
+// sub-request, which the batch endpoint refuses.
+func (h *Handler) do(batch *http.Request, req Request, id string) Response {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), subRequestKey{}, true))
+	defer cancel()
+	stop := context.AfterFunc(batch.Context(), cancel)
+	defer stop()
+
+	sub, err := http.NewRequestWithContext(ctx, strings.ToUpper(req.Method), req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request")
+	}
+	sub.Header = batch.Header.Clone()
+	for _, name := range hopHeaders {
+		sub.Header.Del(name)
+	}
+	if len(req.Body) > 0 {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+	if id != "" {
+		sub.Header.Set(requestid.Header, id)
+	}
+	for name, value := range req.Headers {
+		sub.Header.Set(name, value)
+	}
+	sub.RemoteAddr = batch.RemoteAddr
+	sub.Host = batch.Host
+	sub.Proto, sub.ProtoMajor, sub.ProtoMinor = batch.Proto, batch.ProtoMajor, batch.ProtoMinor
+	sub.TLS = batch.TLS
+
+	rec := newRecorder(h.cfg.MaxResponseBytes)
+	h.router.ServeHTTP(rec, sub)
+	if rec.overflow {
+		return errorResponse(http.StatusInternalServerError, "response too large for a batch")
+	}
+
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	resp := Response{Status: rec.status, Headers: map[string]string{}}
+	for name, values := range rec.header {
+		resp.Headers[name] = strings.Join(values, ", ")
+	}
+	if rec.body.Len() > 0 {
+		body := rec.body.Bytes()
+		mediaType, _, _ := mime.ParseMediaType(rec.header.Get("Content-Type"))
+		if (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && json.Valid(body) {
+			resp.Body = json.RawMessage(body)
+		} else {
+			resp.Body = string(body)
+		}
+	}
+	return resp
+}
+
+func errorResponse(status int, msg string) Response {
+	return Response{Status: status, Body: msg}
+}
+
+// recorder buffers a sub-response up to a size limit.
+type recorder struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func newRecorder(limit int) *recorder {
+	return &recorder{header: http.Header{}, limit: limit}
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	if rec.body.Len()+len(p) > rec.limit {
+		rec.overflow = true
+		return 0, fmt.Errorf("batch: response exceeds %d bytes", rec.limit)
+	}
+	return rec.body.Write(p)
+}
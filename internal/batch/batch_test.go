@@ -0,0 +1,197 @@
+package batch_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/batch"
+	"github.com/andro-kes/gateway/internal/bulkhead"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/routing"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouter(cfg batch.Config, setup func(r chi.Router)) *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(requestid.Middleware)
+	setup(r)
+	r.Post(batch.Path, batch.New(cfg, r).ServeHTTP)
+	return r
+}
+
+func post(t *testing.T, r http.Handler, body string, header http.Header) (*httptest.ResponseRecorder, []map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, batch.Path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	var out []map[string]any
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	}
+	return rec, out
+}
+
+// TestHandler_Responses tests that sub-requests are routed and answered in order
+func TestHandler_Responses(t *testing.T) {
+	r := newRouter(batch.Config{}, func(r chi.Router) {
+		r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+			id, _ := requestid.FromContext(r.Context())
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Seen-Request-ID", id)
+			w.Write([]byte(`{"id":"` + chi.URLParam(r, "id") + `","auth":"` + r.Header.Get("Authorization") + `"}`))
+		})
+		r.Post("/items", func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.Equal(t, "v1", r.Header.Get("If-Match"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(body)
+		})
+		r.Delete("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not found", http.StatusNotFound)
+		})
+	})
+
+	rec, out := post(t, r, `[
+		{"method": "GET", "path": "/items/a"},
+		{"method": "post", "path": "/items", "headers": {"If-Match": "v1"}, "body": {"name": "Tea"}},
+		{"method": "DELETE", "path": "/items/b"},
+		{"method": "GET", "path": "/missing"}
+	]`, http.Header{"Authorization": {"Bearer t"}, "X-Request-Id": {"batch-1"}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, out, 4)
+
+	assert.Equal(t, 200.0, out[0]["status"])
+	assert.Equal(t, map[string]any{"id": "a", "auth": "Bearer t"}, out[0]["body"])
+	assert.Equal(t, "batch-1.0", out[0]["headers"].(map[string]any)["X-Seen-Request-Id"])
+
+	assert.Equal(t, 201.0, out[1]["status"])
+	assert.Equal(t, map[string]any{"name": "Tea"}, out[1]["body"])
+
+	assert.Equal(t, 404.0, out[2]["status"])
+	assert.Equal(t, "not found\n", out[2]["body"])
+
+	assert.Equal(t, 404.0, out[3]["status"])
+}
+
+// TestHandler_Concurrency tests that sub-requests run concurrently up to the limit
+func TestHandler_Concurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	r := newRouter(batch.Config{Concurrency: 2}, func(r chi.Router) {
+		r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		})
+	})
+
+	body := `[` + strings.Repeat(`{"method":"GET","path":"/slow"},`, 5) + `{"method":"GET","path":"/slow"}]`
+	rec, out := post(t, r, body, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, out, 6)
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+// TestHandler_GlobalBulkhead tests that sub-requests do not take global bulkhead slots besides their batch's
+func TestHandler_GlobalBulkhead(t *testing.T) {
+	limiter := bulkhead.New(bulkhead.Config{MaxInFlight: 1})
+	r := newRouter(batch.Config{Concurrency: 2}, func(r chi.Router) {
+		r.Use(limiter.Middleware(bulkhead.Global))
+		r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(chi.URLParam(r, "id")))
+		})
+	})
+
+	rec, out := post(t, r, `[{"method":"GET","path":"/items/a"},{"method":"GET","path":"/items/b"}]`, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, out, 2)
+	assert.Equal(t, 200.0, out[0]["status"])
+	assert.Equal(t, 200.0, out[1]["status"])
+}
+
+// TestHandler_Invalid tests rejected batches
+func TestHandler_Invalid(t *testing.T) {
+	r := newRouter(batch.Config{MaxRequests: 2}, func(r chi.Router) {})
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not an array", `{"method":"GET","path":"/a"}`},
+		{"empty", `[]`},
+		{"too many", `[{"method":"GET","path":"/a"},{"method":"GET","path":"/b"},{"method":"GET","path":"/c"}]`},
+		{"method", `[{"method":"CONNECT","path":"/a"}]`},
+		{"absolute URL", `[{"method":"GET","path":"http://evil.example/a"}]`},
+		{"relative path", `[{"method":"GET","path":"a"}]`},
+		{"nested", `[{"method":"POST","path":"/x/../batch"}]`},
+		{"nested in another case", `[{"method":"POST","path":"/BATCH"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _ := post(t, r, tt.body, nil)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+// TestHandler_NestedRewrite tests that sub-requests rewritten to the batch endpoint by case-insensitive routing or other middleware are refused
+func TestHandler_NestedRewrite(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(routing.NewNormalizer(routing.NormalizeConfig{CaseInsensitive: true}, r).Middleware)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/alias" {
+				r.URL.Path = batch.Path
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Post(batch.Path, batch.New(batch.Config{}, r).ServeHTTP)
+
+	rec, _ := post(t, r, `[{"method":"POST","path":"/Batch","body":[{"method":"GET","path":"/a"}]}]`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, out := post(t, r, `[{"method":"POST","path":"/alias","body":[{"method":"GET","path":"/a"}]}]`, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, out, 1)
+	assert.Equal(t, 400.0, out[0]["status"])
+	assert.Contains(t, out[0]["body"], "batches cannot be nested")
+}
+
+// TestHandler_ResponseLimit tests that oversized sub-responses are replaced with an error
+func TestHandler_ResponseLimit(t *testing.T) {
+	r := newRouter(batch.Config{MaxResponseBytes: 10}, func(r chi.Router) {
+		r.Get("/big", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strings.Repeat("x", 11)))
+		})
+		r.Get("/small", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})
+	})
+
+	rec, out := post(t, r, `[{"method":"GET","path":"/big"},{"method":"GET","path":"/small"}]`, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 500.0, out[0]["status"])
+	assert.Equal(t, "response too large for a batch", out[0]["body"])
+	assert.Equal(t, 200.0, out[1]["status"])
+	assert.Equal(t, "ok", out[1]["body"])
+}
@@ -0,0 +1,273 @@
+// Package bodydump logs sanitized request and response bodies of selected
+// routes, for troubleshooting payloads that get lost between JSON and proto.
+// Dumps are switched on per route at runtime and expire on their own.
+package bodydump
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andro-kes/gateway/internal/http/instrument"
+	"github.com/andro-kes/gateway/internal/logger"
+	"go.uber.org/zap"
+)
+
+const redacted = "[REDACTED]"
+
+// defaultRedact are parts of JSON keys whose values never reach the log, so
+// that new_password or refresh_token are redacted as well as password and
+// token. code and otpauth cover TOTP codes and the otpauth:// URIs that
+// carry TOTP secrets.
+var defaultRedact = []string{"password", "token", "secret", "authorization", "code", "otpauth"}
+
+// Config configures body dumps.
+type Config struct {
+	// MaxBodyBytes truncates logged bodies. Default: 64 KiB.
+	MaxBodyBytes int `yaml:"max_body_bytes"`
+
+	// MaxDuration caps how long a dump may stay enabled. Default: 1h.
+	MaxDuration time.Duration `yaml:"max_duration"`
+
+	// RedactFields are JSON keys redacted in addition to those containing
+	// password, token, secret, authorization, code or otpauth.
+	RedactFields []string `yaml:"redact_fields"`
+}
+
+// Session is an enabled dump.
+type Session struct {
+	// Route is the path prefix whose bodies are dumped.
+	Route     string    `json:"route"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Dumper holds the enabled dumps.
+type Dumper struct {
+	maxBody     int
+	maxDuration time.Duration
+	redact      map[string]bool
+	redactRaw   *regexp.Regexp
+
+	mu       sync.RWMutex
+	sessions map[string]time.Time
+}
+
+// New creates a Dumper with no dumps enabled.
+func New(cfg Config) *Dumper {
+	d := &Dumper{
+		maxBody:     cfg.MaxBodyBytes,
+		maxDuration: cfg.MaxDuration,
+		redact:      map[string]bool{},
+		sessions:    map[string]time.Time{},
+	}
+	if d.maxBody <= 0 {
+		d.maxBody = 64 << 10
+	}
+	if d.maxDuration <= 0 {
+		d.maxDuration = time.Hour
+	}
+	keys := []string{`[^"]*(?:` + strings.Join(defaultRedact, "|") + `)[^"]*`}
+	for _, f := range cfg.RedactFields {
+		d.redact[strings.ToLower(f)] = true
+		keys = append(keys, regexp.QuoteMeta(f))
+	}
+	// fallback for JSON that does not parse, e.g. cut off by the size limit
+	d.redactRaw = regexp.MustCompile(`(?i)("(?:` + strings.Join(keys, "|") + `)"\s*:\s*)(?:"(?:[^"\\]|\\.)*"?|-?[0-9][0-9.eE+-]*)`)
+	return d
+}
+
+// Enable dumps bodies of requests under route for duration, capped at MaxDuration.
+func (d *Dumper) Enable(route string, duration time.Duration) (Session, error) {
+	if !strings.HasPrefix(route, "/") {
+		return Session{}, fmt.Errorf("route must start with /")
+	}
+	if duration <= 0 {
+		return Session{}, fmt.Errorf("duration must be positive")
+	}
+	duration = min(duration, d.maxDuration)
+
+	s := Session{Route: route, ExpiresAt: time.Now().Add(duration).UTC()}
+	d.mu.Lock()
+	d.sessions[route] = s.ExpiresAt
+	d.mu.Unlock()
+	logger.Logger().Info("Body dump enabled", zap.String("route", route), zap.Time("expires_at", s.ExpiresAt))
+	return s, nil
+}
+
+// Disable stops dumping route. It reports whether a dump was enabled.
+func (d *Dumper) Disable(route string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.sessions[route]
+	delete(d.sessions, route)
+	return ok
+}
+
+// Sessions returns the dumps that have not expired, sorted by route.
+func (d *Dumper) Sessions() []Session {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := []Session{}
+	for route, exp := range d.sessions {
+		if now.After(exp) {
+			delete(d.sessions, route)
+			continue
+		}
+		out = append(out, Session{Route: route, ExpiresAt: exp})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+	return out
+}
+
+func (d *Dumper) active(path string) (string, bool) {
+	now := time.Now()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for route, exp := range d.sessions {
+		if strings.HasPrefix(path, route) && now.Before(exp) {
+			return route, true
+		}
+	}
+	return "", false
+}
+
+// Middleware logs the bodies of requests to routes with an enabled dump.
+func (d *Dumper) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := d.active(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// only the dumped prefix of the request body is read ahead; the
+		// rest streams to the handler
+		reqBody := &capture{limit: d.maxBody}
+		if r.Body != nil {
+			prefix, err := io.ReadAll(io.LimitReader(r.Body, int64(d.maxBody)+1))
+			if err != nil {
+				r.Body.Close()
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			reqBody.Write(prefix)
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+		}
+
+		iw := instrument.Wrap(w)
+		rec := &recorder{ResponseWriter: iw, body: capture{limit: d.maxBody}}
+		next.ServeHTTP(rec, r)
+
+		logger.Logger().Info("Body dump",
+			zap.String("route", route),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", iw.Status()),
+			zap.String("request_content_type", r.Header.Get("Content-Type")),
+			zap.String("request_body", d.sanitize(reqBody.buf.Bytes(), reqBody.truncated)),
+			zap.String("response_content_type", w.Header().Get("Content-Type")),
+			zap.String("response_body", d.sanitize(rec.body.buf.Bytes(), rec.body.truncated)),
+		)
+	})
+}
+
+// sanitize redacts secrets from body and truncates the result. truncated
+// reports that body is already a cut-off prefix.
+func (d *Dumper) sanitize(body []byte, truncated bool) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v any
+	if !truncated && json.Unmarshal(body, &v) == nil {
+		if out, err := json.Marshal(d.redactValue(v)); err == nil {
+			body = out
+		}
+	} else if !utf8.Valid(body) {
+		return fmt.Sprintf("[%d bytes binary]", len(body))
+	} else {
+		body = d.redactRaw.ReplaceAll(body, []byte(`$1"`+redacted+`"`))
+	}
+	if len(body) > d.maxBody {
+		body, truncated = body[:d.maxBody], true
+	}
+	if truncated {
+		return string(body) + "...[truncated]"
+	}
+	return string(body)
+}
+
+// redacts reports whether the value of the JSON key k is redacted.
+func (d *Dumper) redacts(k string) bool {
+	k = strings.ToLower(k)
+	return d.redact[k] || slices.ContainsFunc(defaultRedact, func(part string) bool { return strings.Contains(k, part) })
+}
+
+func (d *Dumper) redactValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if d.redacts(k) {
+				t[k] = redacted
+			} else {
+				t[k] = d.redactValue(val)
+			}
+		}
+	case []any:
+		for i, val := range t {
+			t[i] = d.redactValue(val)
+		}
+	}
+	return v
+}
+
+// capture keeps the first limit bytes written to it.
+type capture struct {
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (c *capture) Write(b []byte) (int, error) {
+	room := c.limit - c.buf.Len()
+	if len(b) > room {
+		c.truncated = true
+	}
+	if room > 0 {
+		c.buf.Write(b[:min(room, len(b))])
+	}
+	return len(b), nil
+}
+
+// recorder keeps a bounded copy of the response body.
+type recorder struct {
+	http.ResponseWriter
+	body capture
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes on, so that streamed responses are not held back
+// while they are dumped.
+func (r *recorder) Flush() {
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
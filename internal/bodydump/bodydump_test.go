@@ -0,0 +1,163 @@
+package bodydump_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/bodydump"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs redirects the package logger to a file and returns a reader for it
+func captureLogs(t *testing.T) func() string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "log.json")
+	require.NoError(t, logger.Init(logger.Config{Level: "info", OutputPaths: []string{path}}))
+	t.Cleanup(func() { logger.Init(logger.Config{Level: "info"}) })
+	return func() string {
+		logger.Sync()
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(data)
+	}
+}
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"access_token": "abc.def.ghi", "user": "bob"}`))
+}
+
+// TestMiddleware_DumpsSanitizedBodies tests that enabled routes log bodies with secrets redacted
+func TestMiddleware_DumpsSanitizedBodies(t *testing.T) {
+	logs := captureLogs(t)
+	d := bodydump.New(bodydump.Config{RedactFields: []string{"ssn"}})
+	_, err := d.Enable("/auth", time.Minute)
+	require.NoError(t, err)
+
+	h := d.Middleware(http.HandlerFunc(echo))
+	r := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email": "bob@example.com", "password": "hunter2", "ssn": "123"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	// the client still gets the full response
+	assert.Contains(t, w.Body.String(), "abc.def.ghi")
+
+	out := logs()
+	assert.Contains(t, out, `"msg":"Body dump"`)
+	assert.Contains(t, out, "bob@example.com")
+	assert.NotContains(t, out, "hunter2")
+	assert.NotContains(t, out, `\"ssn\":\"123\"`)
+	assert.NotContains(t, out, "abc.def.ghi")
+	assert.Contains(t, out, "[REDACTED]")
+}
+
+// TestMiddleware_Inactive tests that routes without a dump are not logged
+func TestMiddleware_Inactive(t *testing.T) {
+	logs := captureLogs(t)
+	d := bodydump.New(bodydump.Config{})
+	_, err := d.Enable("/inventory", time.Minute)
+	require.NoError(t, err)
+
+	d.Middleware(http.HandlerFunc(echo)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{}`)))
+	assert.NotContains(t, logs(), `"msg":"Body dump"`)
+}
+
+// TestMiddleware_TruncatedJSONRedacted tests that secrets are redacted even when truncation breaks the JSON
+func TestMiddleware_TruncatedJSONRedacted(t *testing.T) {
+	logs := captureLogs(t)
+	d := bodydump.New(bodydump.Config{MaxBodyBytes: 40})
+	_, err := d.Enable("/auth", time.Minute)
+	require.NoError(t, err)
+
+	d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"refresh_token": "secret-value-that-is-long", "pad": "` + strings.Repeat("x", 100) + `"}`))
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/auth/refresh", nil))
+
+	out := logs()
+	assert.Contains(t, out, "[truncated]")
+	assert.NotContains(t, out, "secret-value")
+}
+
+// TestMiddleware_RedactsKeyParts tests that keys containing password are redacted, as in password changes
+func TestMiddleware_RedactsKeyParts(t *testing.T) {
+	for _, maxBody := range []int{0, 60} {
+		logs := captureLogs(t)
+		d := bodydump.New(bodydump.Config{MaxBodyBytes: maxBody})
+		_, err := d.Enable("/auth", time.Minute)
+		require.NoError(t, err)
+
+		body := `{"current_password": "hunter2", "new_password": "correct horse", "pad": "` + strings.Repeat("x", 100) + `"}`
+		d.Middleware(http.HandlerFunc(echo)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/auth/account/password", strings.NewReader(body)))
+
+		out := logs()
+		assert.Contains(t, out, `"msg":"Body dump"`)
+		assert.NotContains(t, out, "hunter2", maxBody)
+		assert.NotContains(t, out, "correct horse", maxBody)
+	}
+}
+
+// TestMiddleware_RedactsMFA tests that MFA challenge tokens, TOTP codes and secrets are redacted
+func TestMiddleware_RedactsMFA(t *testing.T) {
+	logs := captureLogs(t)
+	d := bodydump.New(bodydump.Config{})
+	_, err := d.Enable("/auth", time.Minute)
+	require.NoError(t, err)
+
+	verify := `{"challenge_token": "chal.lenge.jwt", "code": "493817"}`
+	d.Middleware(http.HandlerFunc(echo)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/auth/mfa/verify", strings.NewReader(verify)))
+	d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"secret": "JBSWY3DPEHPK3PXP", "otpauth_url": "otpauth://totp/shop:bob?issuer=shop&secret=JBSWY3DPEHPK3PXP"}`))
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/auth/mfa/setup", nil))
+	d.Middleware(http.HandlerFunc(echo)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/auth/mfa/confirm", strings.NewReader(`{"code": 493817}`)))
+
+	out := logs()
+	assert.Contains(t, out, "/auth/mfa/setup")
+	for _, leak := range []string{"chal.lenge.jwt", "493817", "JBSWY3DPEHPK3PXP"} {
+		assert.NotContains(t, out, leak)
+	}
+}
+
+// TestMiddleware_LargeBodies tests that large request bodies stream to the handler and streamed responses are flushed while dumped
+func TestMiddleware_LargeBodies(t *testing.T) {
+	logs := captureLogs(t)
+	d := bodydump.New(bodydump.Config{MaxBodyBytes: 16})
+	_, err := d.Enable("/upload", time.Minute)
+	require.NoError(t, err)
+
+	upload := strings.Repeat("x", 1<<20)
+	var got int64
+	var flushed bool
+	w := httptest.NewRecorder()
+	d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.Copy(io.Discard, r.Body)
+		w.Write([]byte("part 1"))
+		require.NoError(t, http.NewResponseController(w).Flush())
+		flushed = true
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(upload)))
+
+	assert.Equal(t, int64(len(upload)), got)
+	assert.True(t, flushed)
+	assert.True(t, w.Flushed)
+	out := logs()
+	assert.Contains(t, out, `"request_body":"xxxxxxxxxxxxxxxx...[truncated]"`)
+	assert.Contains(t, out, `"response_body":"part 1"`)
+}
+
+// TestSessions_Expire tests that dumps are capped and expire
+func TestSessions_Expire(t *testing.T) {
+	d := bodydump.New(bodydump.Config{MaxDuration: 50 * time.Millisecond})
+	s, err := d.Enable("/inventory", time.Hour)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(50*time.Millisecond), s.ExpiresAt, 40*time.Millisecond)
+	assert.Len(t, d.Sessions(), 1)
+
+	assert.Eventually(t, func() bool { return len(d.Sessions()) == 0 }, time.Second, 10*time.Millisecond)
+}
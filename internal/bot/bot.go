@@ -0,0 +1,389 @@
+// Package bot flags requests that look automated before they reach the
+// backends: requests missing headers every browser sends, user agents of
+// known scanners, form posts filling a honeypot field that humans never see,
+// and forms submitted faster than anyone could fill them. Each heuristic is
+// a rule with its own action: tag the request, rate-limit its client or
+// block it.
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Header carries the rules a suspicious request matched, separated by
+// commas, to the handlers and filters behind the detector. Clients cannot
+// set it themselves.
+const Header = "X-Bot-Suspect"
+
+// Actions taken on the requests a rule matches. When several rules match,
+// the strongest action applies.
+const (
+	// Tag lets the request through with the Header and logs it.
+	Tag = "tag"
+	// RateLimit lets the request through unless its client IP sent more
+	// suspicious requests than the rate limit allows.
+	RateLimit = "rate_limit"
+	// Block rejects the request with 403.
+	Block = "block"
+)
+
+// Rule names, as used in the Header and metrics.
+const (
+	MissingHeaders = "missing_headers"
+	BadUserAgent   = "bad_user_agent"
+	Honeypot       = "honeypot"
+	Timing         = "timing"
+)
+
+// DefaultUserAgents are the user agent substrings of common scanners and
+// attack tools.
+var DefaultUserAgents = []string{
+	"sqlmap", "nikto", "nmap", "masscan", "zgrab", "nuclei", "dirbuster",
+	"gobuster", "wpscan", "acunetix", "havij", "fimap", "hydra",
+}
+
+// Config configures bot detection. Rules without an action are off.
+type Config struct {
+	// PathPrefixes are the routes checked. Default: /auth and /inventory.
+	PathPrefixes []string `yaml:"path_prefixes"`
+
+	// MissingHeaders matches requests without any of Headers.
+	MissingHeaders HeadersRule `yaml:"missing_headers"`
+
+	// BadUserAgents matches requests whose User-Agent contains one of
+	// Patterns, ignoring case.
+	BadUserAgents UserAgentRule `yaml:"bad_user_agents"`
+
+	// Honeypot matches form posts that fill Field.
+	Honeypot HoneypotRule `yaml:"honeypot"`
+
+	// Timing matches forms submitted sooner than MinFillTime after they
+	// were rendered, or with a render time that cannot be right.
+	Timing TimingRule `yaml:"timing"`
+
+	// RateLimit bounds the suspicious requests of one client IP under the
+	// rate_limit action.
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	// MaxBody is the largest form body inspected by the honeypot and timing
+	// rules. Larger bodies are let through uninspected. Default: 64KiB.
+	MaxBody int64 `yaml:"max_body"`
+}
+
+// HeadersRule configures the missing headers rule.
+type HeadersRule struct {
+	Action string `yaml:"action"`
+
+	// Headers are the headers requests must carry. Default: User-Agent,
+	// Accept and Accept-Language.
+	Headers []string `yaml:"headers"`
+}
+
+// UserAgentRule configures the bad user agent rule.
+type UserAgentRule struct {
+	Action string `yaml:"action"`
+
+	// Patterns are matched against the User-Agent. Default:
+	// DefaultUserAgents.
+	Patterns []string `yaml:"patterns"`
+}
+
+// HoneypotRule configures the honeypot rule.
+type HoneypotRule struct {
+	Action string `yaml:"action"`
+
+	// Field is the hidden form field, e.g. "website", looked up in
+	// URL-encoded and top-level JSON form posts.
+	Field string `yaml:"field"`
+}
+
+// TimingRule configures the timing rule. Forms send the Unix time in
+// milliseconds at which they were rendered in Header or Field.
+type TimingRule struct {
+	Action string `yaml:"action"`
+
+	// Header holds the render time. Default: X-Form-Rendered-At.
+	Header string `yaml:"header"`
+
+	// Field holds the render time in the form body, when not in Header.
+	Field string `yaml:"field"`
+
+	// MinFillTime is the least time a human takes to fill the form.
+	// Default: 2s.
+	MinFillTime time.Duration `yaml:"min_fill_time"`
+
+	// MaxAge is the longest a form may stay open. Default: 24h.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// RateLimitConfig configures the rate_limit action.
+type RateLimitConfig struct {
+	// Requests are the suspicious requests allowed per client IP and
+	// Window. Default: 10.
+	Requests int `yaml:"requests"`
+
+	// Window is the fixed counting window. Default: 1m.
+	Window time.Duration `yaml:"window"`
+}
+
+// Enabled reports whether any rule has an action.
+func (c Config) Enabled() bool {
+	return c.MissingHeaders.Action != "" || c.BadUserAgents.Action != "" ||
+		c.Honeypot.Action != "" || c.Timing.Action != ""
+}
+
+var detectionsTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "bot",
+	Name:      "detections_total",
+	Help:      "Requests matched by bot detection rules, by rule and action.",
+}, []string{"rule", "action"})
+
+// rank orders the actions by strength.
+var rank = map[string]int{Tag: 1, RateLimit: 2, Block: 3}
+
+// Detector applies the rules of a Config. A nil *Detector checks nothing.
+type Detector struct {
+	cfg      Config
+	headers  []string
+	patterns []string
+
+	mu       sync.Mutex
+	window   time.Time
+	counters map[string]int
+}
+
+// New returns the Detector of cfg, or nil when no rule has an action.
+func New(cfg Config) (*Detector, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	for name, action := range map[string]string{
+		"missing_headers": cfg.MissingHeaders.Action,
+		"bad_user_agents": cfg.BadUserAgents.Action,
+		"honeypot":        cfg.Honeypot.Action,
+		"timing":          cfg.Timing.Action,
+	} {
+		if _, ok := rank[action]; action != "" && !ok {
+			return nil, fmt.Errorf("bot rule %s: unknown action %q", name, action)
+		}
+	}
+	if cfg.Honeypot.Action != "" && cfg.Honeypot.Field == "" {
+		return nil, fmt.Errorf("bot rule honeypot: no field")
+	}
+	if len(cfg.PathPrefixes) == 0 {
+		cfg.PathPrefixes = []string{"/auth", "/inventory"}
+	}
+	if cfg.MaxBody <= 0 {
+		cfg.MaxBody = 64 << 10
+	}
+	if cfg.Timing.Header == "" {
+		cfg.Timing.Header = "X-Form-Rendered-At"
+	}
+	if cfg.Timing.MinFillTime <= 0 {
+		cfg.Timing.MinFillTime = 2 * time.Second
+	}
+	if cfg.Timing.MaxAge <= 0 {
+		cfg.Timing.MaxAge = 24 * time.Hour
+	}
+	if cfg.RateLimit.Requests <= 0 {
+		cfg.RateLimit.Requests = 10
+	}
+	if cfg.RateLimit.Window <= 0 {
+		cfg.RateLimit.Window = time.Minute
+	}
+
+	d := &Detector{cfg: cfg, headers: cfg.MissingHeaders.Headers, counters: map[string]int{}}
+	if len(d.headers) == 0 {
+		d.headers = []string{"User-Agent", "Accept", "Accept-Language"}
+	}
+	patterns := cfg.BadUserAgents.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultUserAgents
+	}
+	for _, p := range patterns {
+		d.patterns = append(d.patterns, strings.ToLower(p))
+	}
+	return d, nil
+}
+
+// Middleware checks the requests of the configured routes and tags,
+// rate-limits or blocks the suspicious ones.
+func (d *Detector) Middleware(next http.Handler) http.Handler {
+	if d == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(Header)
+		if !slices.ContainsFunc(d.cfg.PathPrefixes, func(p string) bool { return strings.HasPrefix(r.URL.Path, p) }) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rules, action := d.Check(r)
+		if len(rules) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip, _ := realip.FromContext(r.Context())
+		fields := []zap.Field{
+			zap.Strings("rules", rules),
+			zap.String("action", action),
+			zap.String("path", r.URL.Path),
+			zap.String("client_ip", ip),
+			zap.String("user_agent", r.UserAgent()),
+		}
+		if rid, ok := requestid.FromContext(r.Context()); ok {
+			fields = append(fields, zap.String("request_id", rid))
+		}
+		logger.Logger().Info("Suspected bot", fields...)
+
+		switch action {
+		case Block:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		case RateLimit:
+			if retry, ok := d.take(ip); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		r.Header.Set(Header, strings.Join(rules, ","))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Check returns the rules r matches and the strongest of their actions, and
+// counts them. Form bodies it inspects are left for the handlers to read.
+func (d *Detector) Check(r *http.Request) ([]string, string) {
+	var rules []string
+	action := ""
+	match := func(rule, ruleAction string) {
+		detectionsTotal.WithLabelValues(rule, ruleAction).Inc()
+		rules = append(rules, rule)
+		if rank[ruleAction] > rank[action] {
+			action = ruleAction
+		}
+	}
+
+	if a := d.cfg.MissingHeaders.Action; a != "" && slices.ContainsFunc(d.headers, func(h string) bool { return r.Header.Get(h) == "" }) {
+		match(MissingHeaders, a)
+	}
+	if a := d.cfg.BadUserAgents.Action; a != "" {
+		ua := strings.ToLower(r.UserAgent())
+		if slices.ContainsFunc(d.patterns, func(p string) bool { return strings.Contains(ua, p) }) {
+			match(BadUserAgent, a)
+		}
+	}
+
+	if d.cfg.Honeypot.Action == "" && d.cfg.Timing.Action == "" {
+		return rules, action
+	}
+	form := d.form(r)
+	if a := d.cfg.Honeypot.Action; a != "" && form.Get(d.cfg.Honeypot.Field) != "" {
+		match(Honeypot, a)
+	}
+	if a := d.cfg.Timing.Action; a != "" {
+		rendered := r.Header.Get(d.cfg.Timing.Header)
+		if rendered == "" && d.cfg.Timing.Field != "" {
+			rendered = form.Get(d.cfg.Timing.Field)
+		}
+		if rendered != "" && !d.humanTiming(rendered) {
+			match(Timing, a)
+		}
+	}
+	return rules, action
+}
+
+// humanTiming reports whether a form rendered at the Unix milliseconds
+// rendered can have been filled by a human.
+func (d *Detector) humanTiming(rendered string) bool {
+	ms, err := strconv.ParseInt(rendered, 10, 64)
+	if err != nil {
+		return false
+	}
+	open := time.Since(time.UnixMilli(ms))
+	return open >= d.cfg.Timing.MinFillTime && open <= d.cfg.Timing.MaxAge
+}
+
+// form returns the fields of a URL-encoded or JSON form post, and puts its
+// body back for the handlers.
+func (d *Detector) form(r *http.Request) url.Values {
+	if r.Body == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" && mediaType != "application/json" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, d.cfg.MaxBody+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || int64(len(body)) > d.cfg.MaxBody {
+		return nil
+	}
+
+	if mediaType == "application/x-www-form-urlencoded" {
+		form, _ := url.ParseQuery(string(body))
+		return form
+	}
+	var fields map[string]any
+	if json.Unmarshal(body, &fields) != nil {
+		return nil
+	}
+	form := url.Values{}
+	for k, v := range fields {
+		switch v := v.(type) {
+		case string:
+			form.Set(k, v)
+		case float64:
+			form.Set(k, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			form.Set(k, strconv.FormatBool(v))
+		}
+	}
+	return form
+}
+
+// readCloser reads the inspected body followed by the rest of the original
+// one, and closes the original one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// take counts a suspicious request of ip in the current window and
+// reports whether it is allowed, or else how long until the window ends.
+func (d *Detector) take(ip string) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if end := d.window.Add(d.cfg.RateLimit.Window); !now.Before(end) {
+		d.window = now
+		clear(d.counters)
+	}
+	d.counters[ip]++
+	if d.counters[ip] > d.cfg.RateLimit.Requests {
+		return time.Until(d.window.Add(d.cfg.RateLimit.Window)), false
+	}
+	return 0, true
+}
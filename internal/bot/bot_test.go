@@ -0,0 +1,146 @@
+package bot_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/bot"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo answers with the bot header and the request body it got.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set(bot.Header, r.Header.Get(bot.Header))
+	w.Write(body)
+})
+
+func browser(method, path, body string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/130.0")
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("Accept-Language", "en")
+	r = r.WithContext(realip.WithIP(r.Context(), "203.0.113.7"))
+	return r
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestDetector tests that each rule matches its suspicious requests and applies its action
+func TestDetector(t *testing.T) {
+	d, err := bot.New(bot.Config{
+		MissingHeaders: bot.HeadersRule{Action: bot.Tag},
+		BadUserAgents:  bot.UserAgentRule{Action: bot.Block},
+		Honeypot:       bot.HoneypotRule{Action: bot.Block, Field: "website"},
+		Timing:         bot.TimingRule{Action: bot.Tag, Field: "rendered_at"},
+	})
+	require.NoError(t, err)
+	h := d.Middleware(echo)
+
+	r := browser("POST", "/auth/login", `{"username": "ann", "password": "secret", "website": ""}`)
+	r.Header.Set("Content-Type", "application/json")
+	w := serve(h, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(bot.Header))
+	assert.Equal(t, `{"username": "ann", "password": "secret", "website": ""}`, w.Body.String(), "inspected bodies reach the handler")
+
+	r = browser("POST", "/auth/login", `{"username": "ann"}`)
+	r.Header.Del("Accept-Language")
+	r.Header.Set(bot.Header, "spoofed")
+	w = serve(h, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, bot.MissingHeaders, w.Header().Get(bot.Header))
+
+	r = browser("GET", "/inventory/list", "")
+	r.Header.Set("User-Agent", "sqlmap/1.7")
+	assert.Equal(t, http.StatusForbidden, serve(h, r).Code)
+
+	r = browser("POST", "/auth/register", "username=bob&website=http%3A%2F%2Fspam.example")
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Equal(t, http.StatusForbidden, serve(h, r).Code)
+
+	now := time.Now()
+	r = browser("POST", "/auth/register", `{"username": "bob", "rendered_at": `+strconv.FormatInt(now.Add(-200*time.Millisecond).UnixMilli(), 10)+`}`)
+	r.Header.Set("Content-Type", "application/json")
+	assert.Equal(t, bot.Timing, serve(h, r).Header().Get(bot.Header), "filled faster than a human")
+
+	r = browser("POST", "/auth/register", `{"username": "bob"}`)
+	r.Header.Set("X-Form-Rendered-At", strconv.FormatInt(now.Add(-10*time.Second).UnixMilli(), 10))
+	assert.Empty(t, serve(h, r).Header().Get(bot.Header))
+
+	r = browser("GET", "/health", "")
+	r.Header.Set("User-Agent", "sqlmap/1.7")
+	assert.Equal(t, http.StatusOK, serve(h, r).Code, "other routes are not checked")
+
+	assert.GreaterOrEqual(t, detections(t, bot.Honeypot, bot.Block), 1.0)
+}
+
+// TestDetector_RateLimit tests that the suspicious requests of a client IP are rate-limited
+func TestDetector_RateLimit(t *testing.T) {
+	d, err := bot.New(bot.Config{
+		BadUserAgents: bot.UserAgentRule{Action: bot.RateLimit, Patterns: []string{"python-requests"}},
+		RateLimit:     bot.RateLimitConfig{Requests: 2, Window: time.Minute},
+	})
+	require.NoError(t, err)
+	h := d.Middleware(echo)
+
+	bad := func() *http.Request {
+		r := browser("GET", "/inventory/list", "")
+		r.Header.Set("User-Agent", "python-requests/2.31")
+		return r
+	}
+	for range 2 {
+		w := serve(h, bad())
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, bot.BadUserAgent, w.Header().Get(bot.Header))
+	}
+	w := serve(h, bad())
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, serve(h, browser("GET", "/inventory/list", "")).Code, "other requests of the client pass")
+}
+
+// TestNew tests that detection is off without actions and that rules are validated
+func TestNew(t *testing.T) {
+	d, err := bot.New(bot.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, d)
+
+	_, err = bot.New(bot.Config{BadUserAgents: bot.UserAgentRule{Action: "ban"}})
+	assert.Error(t, err)
+	_, err = bot.New(bot.Config{Honeypot: bot.HoneypotRule{Action: bot.Block}})
+	assert.Error(t, err)
+}
+
+// detections returns gateway_bot_detections_total of rule and action.
+func detections(t *testing.T, rule, action string) float64 {
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != "gateway_bot_detections_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["rule"] == rule && labels["action"] == action {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+// Package buildinfo describes the running binary: its version, commit,
+// build date and toolchain, set with -ldflags or read from the build info
+// the Go toolchain embeds. The gateway serves it on /version and exports it
+// as the gateway_build_info metric, so that operators can check what is
+// actually deployed.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Set at build time, e.g. with
+// -ldflags "-X github.com/andro-kes/gateway/internal/buildinfo.Date=2026-01-02T15:04:05Z".
+// Version falls back to the module version and Commit to the VCS revision.
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info describes the running binary.
+type Info struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+
+	// Modified reports uncommitted changes in the build's working tree.
+	Modified   bool   `json:"modified,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	BuildDate  string `json:"build_date,omitempty"`
+	GoVersion  string `json:"go_version"`
+	Platform   string `json:"platform"`
+
+	// Features are the enabled features of the startup table.
+	Features []string `json:"features,omitempty"`
+}
+
+var (
+	buildInfo = promauto.With(metrics.Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "build_info",
+		Help:      "Always 1, labeled with the version, commit, build date and Go version of the gateway.",
+	}, []string{"version", "commit", "build_date", "go_version"})
+	featureEnabled = promauto.With(metrics.Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "feature_enabled",
+		Help:      "Always 1, one series per enabled feature of the startup table.",
+	}, []string{"feature"})
+)
+
+// Get returns the build of the running binary, without features.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				info.CommitTime = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+}
+
+// Publish sets the gateway_build_info and gateway_feature_enabled metrics
+// to info, replacing what an earlier gateway published.
+func Publish(info Info) {
+	buildInfo.Reset()
+	buildInfo.WithLabelValues(info.Version, info.Commit, info.BuildDate, info.GoVersion).Set(1)
+	featureEnabled.Reset()
+	for _, f := range info.Features {
+		featureEnabled.WithLabelValues(f).Set(1)
+	}
+}
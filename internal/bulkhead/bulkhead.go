@@ -0,0 +1,133 @@
+// Package bulkhead caps the number of in-flight requests, globally and per
+// backend, so a slow backend cannot tie up every gateway goroutine. Requests
+// over the limit are shed immediately with 503 and Retry-After.
+package bulkhead
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/batch"
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Global is the name of the bulkhead shared by all routes.
+const Global = "global"
+
+// Config configures the bulkheads. A limit of 0 disables that bulkhead.
+type Config struct {
+	// MaxInFlight caps concurrent requests across the gateway, except health
+	// checks, metrics and batch sub-requests, whose batch already holds a
+	// slot.
+	MaxInFlight int `yaml:"max_in_flight"`
+
+	// Backends caps concurrent requests per backend ("auth", "inventory").
+	Backends map[string]int `yaml:"backends"`
+
+	// RetryAfter is sent with shed requests. Default: 1s.
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+var (
+	inFlight = promauto.With(metrics.Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "bulkhead",
+		Name:      "in_flight",
+		Help:      "Requests currently holding a bulkhead slot.",
+	}, []string{"bulkhead"})
+
+	capacity = promauto.With(metrics.Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "bulkhead",
+		Name:      "capacity",
+		Help:      "Maximum concurrent requests of a bulkhead.",
+	}, []string{"bulkhead"})
+
+	rejectedTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "bulkhead",
+		Name:      "rejected_total",
+		Help:      "Requests shed because a bulkhead was full.",
+	}, []string{"bulkhead"})
+)
+
+// Limiter holds the configured bulkheads.
+type Limiter struct {
+	retryAfter int
+	bulkheads  map[string]chan struct{}
+}
+
+// New creates the bulkheads configured in cfg.
+func New(cfg Config) *Limiter {
+	retryAfter := cfg.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	l := &Limiter{
+		retryAfter: int(math.Ceil(retryAfter.Seconds())),
+		bulkheads:  map[string]chan struct{}{},
+	}
+	add := func(name string, limit int) {
+		if limit > 0 {
+			l.bulkheads[name] = make(chan struct{}, limit)
+			capacity.WithLabelValues(name).Set(float64(limit))
+		}
+	}
+	add(Global, cfg.MaxInFlight)
+	for name, limit := range cfg.Backends {
+		add(name, limit)
+	}
+	return l
+}
+
+// Middleware limits requests through the named bulkhead. Unconfigured
+// bulkheads let everything through.
+func (l *Limiter) Middleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		sem, ok := l.bulkheads[name]
+		if !ok {
+			return next
+		}
+		gauge := inFlight.WithLabelValues(name)
+		rejected := rejectedTotal.WithLabelValues(name)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if name == Global && (r.URL.Path == "/health" || r.URL.Path == "/metrics" || batch.IsSubRequest(r.Context())) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			select {
+			case sem <- struct{}{}:
+			default:
+				rejected.Inc()
+				l.reject(w, r)
+				return
+			}
+			gauge.Inc()
+			defer func() {
+				<-sem
+				gauge.Dec()
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (l *Limiter) reject(w http.ResponseWriter, r *http.Request) {
+	message := i18n.Message(w, r, "too_many_concurrent_requests")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(l.retryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":               "overloaded",
+		"message":             message,
+		"retry_after_seconds": l.retryAfter,
+	})
+}
@@ -0,0 +1,92 @@
+package bulkhead_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/bulkhead"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingHandler holds requests until release is closed
+func blockingHandler(started *sync.WaitGroup, release chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+	})
+}
+
+// TestMiddleware_ShedsExcess tests that requests beyond the limit get 503 with Retry-After
+func TestMiddleware_ShedsExcess(t *testing.T) {
+	l := bulkhead.New(bulkhead.Config{
+		Backends:   map[string]int{"inventory": 2},
+		RetryAfter: 1500 * time.Millisecond,
+	})
+
+	var started sync.WaitGroup
+	release := make(chan struct{})
+	h := l.Middleware("inventory")(blockingHandler(&started, release))
+
+	started.Add(2)
+	var done sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/inventory/get", nil))
+		}()
+	}
+	started.Wait()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/inventory/get", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	close(release)
+	done.Wait()
+
+	// slots are released once requests finish
+	started.Add(1)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/inventory/get", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestMiddleware_GlobalExemptions tests that health checks bypass the global bulkhead
+func TestMiddleware_GlobalExemptions(t *testing.T) {
+	l := bulkhead.New(bulkhead.Config{MaxInFlight: 1})
+
+	var started sync.WaitGroup
+	release := make(chan struct{})
+	defer close(release)
+	h := l.Middleware(bulkhead.Global)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started.Done()
+			<-release
+		}
+	}))
+
+	started.Add(1)
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	started.Wait()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// TestMiddleware_Unconfigured tests that bulkheads without a limit pass everything
+func TestMiddleware_Unconfigured(t *testing.T) {
+	l := bulkhead.New(bulkhead.Config{})
+	w := httptest.NewRecorder()
+	l.Middleware("auth")(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,215 @@
+// Package cachecontrol sets Cache-Control (and optionally Expires) on
+// responses according to per-route policies, so that CDNs and browsers in
+// front of the gateway cache product reads and never store token responses,
+// without each handler setting the headers itself.
+package cachecontrol
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policies.
+const (
+	// Public responses may be stored by shared caches such as CDNs, even for
+	// requests carrying credentials.
+	Public = "public"
+	// Private responses may only be stored by the client's own cache.
+	Private = "private"
+	// NoCache responses may be stored but must be revalidated before reuse.
+	NoCache = "no-cache"
+	// NoStore responses must not be stored at all.
+	NoStore = "no-store"
+)
+
+// Config configures the cache policies.
+type Config struct {
+	// Rules are tried in order; the first matching one applies. When nil,
+	// DefaultRules apply; an empty list disables the middleware.
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule is the cache policy of a group of routes.
+type Rule struct {
+	// PathPrefix selects the routes the rule applies to, e.g. "/inventory/get".
+	PathPrefix string `yaml:"path_prefix"`
+
+	// Methods restricts the rule to these request methods. Empty means all.
+	Methods []string `yaml:"methods"`
+
+	// Policy is public, private, no-cache or no-store.
+	Policy string `yaml:"policy"`
+
+	// MaxAge is how long public and private responses are fresh.
+	MaxAge time.Duration `yaml:"max_age"`
+
+	// SharedMaxAge overrides MaxAge for shared caches (s-maxage).
+	SharedMaxAge time.Duration `yaml:"shared_max_age"`
+
+	// StaleWhileRevalidate lets caches serve a stale response while they
+	// revalidate it in the background.
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
+
+	// StaleIfError lets caches serve a stale response when the gateway fails.
+	StaleIfError time.Duration `yaml:"stale_if_error"`
+
+	// Expires also sets the Expires header, for HTTP/1.0 caches.
+	Expires bool `yaml:"expires"`
+
+	// Override replaces a Cache-Control header set by the handler, which
+	// is kept otherwise.
+	Override bool `yaml:"override"`
+}
+
+// DefaultRules keep token, admin and job responses out of every cache.
+var DefaultRules = []Rule{
+	{PathPrefix: "/auth", Policy: NoStore, Expires: true},
+	{PathPrefix: "/admin", Policy: NoStore},
+	{PathPrefix: "/jobs", Policy: NoStore},
+}
+
+type rule struct {
+	Rule
+	methods []string
+	// value is the Cache-Control of cacheable responses; private is used
+	// instead when a public response sets a cookie
+	value   string
+	private string
+}
+
+// Policies applies cache policies to responses.
+type Policies struct {
+	rules []rule
+}
+
+// New validates cfg and returns its Policies.
+func New(cfg Config) (*Policies, error) {
+	rules := cfg.Rules
+	if rules == nil {
+		rules = DefaultRules
+	}
+	p := &Policies{}
+	for _, r := range rules {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return nil, fmt.Errorf("cache rule path prefix %q must start with /", r.PathPrefix)
+		}
+		compiled := rule{Rule: r}
+		for _, m := range r.Methods {
+			compiled.methods = append(compiled.methods, strings.ToUpper(m))
+		}
+		switch r.Policy {
+		case Public:
+			compiled.value = directives(r, Public, true)
+			compiled.private = directives(r, Private, false)
+		case Private:
+			compiled.value = directives(r, Private, false)
+		case NoCache, NoStore:
+			compiled.value = r.Policy
+		default:
+			return nil, fmt.Errorf("cache rule for %q has unknown policy %q", r.PathPrefix, r.Policy)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+func directives(r Rule, scope string, shared bool) string {
+	d := []string{scope, "max-age=" + seconds(r.MaxAge)}
+	if shared && r.SharedMaxAge > 0 {
+		d = append(d, "s-maxage="+seconds(r.SharedMaxAge))
+	}
+	if r.StaleWhileRevalidate > 0 {
+		d = append(d, "stale-while-revalidate="+seconds(r.StaleWhileRevalidate))
+	}
+	if r.StaleIfError > 0 {
+		d = append(d, "stale-if-error="+seconds(r.StaleIfError))
+	}
+	return strings.Join(d, ", ")
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+func (p *Policies) match(r *http.Request) (*rule, bool) {
+	for i := range p.rules {
+		rule := &p.rules[i]
+		if strings.HasPrefix(r.URL.Path, rule.PathPrefix) && (len(rule.methods) == 0 || slices.Contains(rule.methods, r.Method)) {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// Middleware sets the headers of the policy matching each request when the
+// response header is written.
+func (p *Policies) Middleware(next http.Handler) http.Handler {
+	if len(p.rules) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := p.match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&writer{ResponseWriter: w, rule: rule}, r)
+	})
+}
+
+// apply sets the cache headers for a response with the given status.
+// Errors are never cached, and a public response that sets a cookie is
+// downgraded to private.
+func (rule *rule) apply(h http.Header, status int) {
+	if h.Get("Cache-Control") != "" && !rule.Override {
+		return
+	}
+	value := rule.value
+	cacheable := rule.Policy == Public || rule.Policy == Private
+	if cacheable && !(status >= 200 && status < 300 || status == http.StatusNotModified) {
+		value, cacheable = NoStore, false
+	}
+	if rule.Policy == Public && cacheable && len(h.Values("Set-Cookie")) > 0 {
+		value = rule.private
+	}
+	h.Set("Cache-Control", value)
+
+	if rule.Expires {
+		if cacheable {
+			h.Set("Expires", time.Now().Add(rule.MaxAge).UTC().Format(http.TimeFormat))
+		} else {
+			h.Set("Expires", "0")
+		}
+	}
+}
+
+// writer applies the cache policy just before the header is written.
+type writer struct {
+	http.ResponseWriter
+	rule    *rule
+	written bool
+}
+
+func (w *writer) WriteHeader(code int) {
+	// informational responses are followed by the final one
+	if !w.written && code >= 200 {
+		w.written = true
+		w.rule.apply(w.Header(), code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
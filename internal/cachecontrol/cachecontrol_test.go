@@ -0,0 +1,104 @@
+package cachecontrol_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/cachecontrol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, p *cachecontrol.Policies, method, path string, h http.HandlerFunc) http.Header {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	p.Middleware(h).ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec.Header()
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("{}"))
+}
+
+// TestPolicies_Rules tests rule matching and the resulting directives
+func TestPolicies_Rules(t *testing.T) {
+	p, err := cachecontrol.New(cachecontrol.Config{Rules: []cachecontrol.Rule{
+		{PathPrefix: "/inventory/get", Methods: []string{"get"}, Policy: cachecontrol.Public, MaxAge: time.Minute, SharedMaxAge: 5 * time.Minute, StaleWhileRevalidate: 30 * time.Second, Expires: true},
+		{PathPrefix: "/inventory", Policy: cachecontrol.Private, MaxAge: 10 * time.Second},
+		{PathPrefix: "/auth", Policy: cachecontrol.NoStore, Expires: true},
+	}})
+	require.NoError(t, err)
+
+	h := serve(t, p, "GET", "/inventory/get", ok)
+	assert.Equal(t, "public, max-age=60, s-maxage=300, stale-while-revalidate=30", h.Get("Cache-Control"))
+	expires, err := http.ParseTime(h.Get("Expires"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expires, 2*time.Second)
+
+	h = serve(t, p, "POST", "/inventory/get", ok)
+	assert.Equal(t, "private, max-age=10", h.Get("Cache-Control"))
+	assert.Empty(t, h.Get("Expires"))
+
+	h = serve(t, p, "POST", "/auth/login", ok)
+	assert.Equal(t, "no-store", h.Get("Cache-Control"))
+	assert.Equal(t, "0", h.Get("Expires"))
+
+	h = serve(t, p, "GET", "/health", ok)
+	assert.Empty(t, h.Get("Cache-Control"))
+}
+
+// TestPolicies_Responses tests that errors, cookies and handler headers are respected
+func TestPolicies_Responses(t *testing.T) {
+	p, err := cachecontrol.New(cachecontrol.Config{Rules: []cachecontrol.Rule{
+		{PathPrefix: "/override", Policy: cachecontrol.NoStore, Override: true},
+		{PathPrefix: "/", Policy: cachecontrol.Public, MaxAge: time.Minute},
+	}})
+	require.NoError(t, err)
+
+	h := serve(t, p, "GET", "/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	assert.Equal(t, "no-store", h.Get("Cache-Control"))
+
+	h = serve(t, p, "GET", "/product", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+	assert.Equal(t, "public, max-age=60", h.Get("Cache-Control"))
+
+	h = serve(t, p, "GET", "/session", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "a", Value: "b"})
+		ok(w, r)
+	})
+	assert.Equal(t, "private, max-age=60", h.Get("Cache-Control"))
+
+	h = serve(t, p, "GET", "/custom", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=5")
+		ok(w, r)
+	})
+	assert.Equal(t, "max-age=5", h.Get("Cache-Control"))
+
+	h = serve(t, p, "GET", "/override", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=5")
+		ok(w, r)
+	})
+	assert.Equal(t, "no-store", h.Get("Cache-Control"))
+}
+
+// TestNew tests defaults and validation
+func TestNew(t *testing.T) {
+	p, err := cachecontrol.New(cachecontrol.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, "no-store", serve(t, p, "POST", "/auth/refresh", ok).Get("Cache-Control"))
+	assert.Empty(t, serve(t, p, "GET", "/inventory/get", ok).Get("Cache-Control"))
+
+	p, err = cachecontrol.New(cachecontrol.Config{Rules: []cachecontrol.Rule{}})
+	require.NoError(t, err)
+	assert.Empty(t, serve(t, p, "POST", "/auth/refresh", ok).Get("Cache-Control"))
+
+	_, err = cachecontrol.New(cachecontrol.Config{Rules: []cachecontrol.Rule{{PathPrefix: "/a", Policy: "forever"}}})
+	assert.Error(t, err)
+	_, err = cachecontrol.New(cachecontrol.Config{Rules: []cachecontrol.Rule{{PathPrefix: "a", Policy: cachecontrol.NoStore}}})
+	assert.Error(t, err)
+}
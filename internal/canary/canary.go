@@ -0,0 +1,274 @@
+// Package canary splits traffic between the primary gRPC backend and
+// alternate backends. An HTTP middleware assigns each request to a variant and
+// a grpc.ClientConnInterface wrapper sends the request's RPCs to that variant.
+package canary
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/backend"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Primary is the variant name used for requests served by the default backend.
+const Primary = "primary"
+
+// Config describes one traffic-splitting rule.
+type Config struct {
+	// Name is the variant name reported in metrics and the X-Gateway-Variant header.
+	Name string `yaml:"name"`
+
+	// PathPrefix selects the routes the rule applies to, e.g. "/inventory".
+	PathPrefix string `yaml:"path_prefix"`
+
+	// Backend names the backend whose calls the rule redirects, e.g.
+	// "inventory". Calls to other backends made for the same request still
+	// go to those backends. Default: inventory.
+	Backend string `yaml:"backend"`
+
+	// Address is the gRPC address of the alternate backend.
+	Address string `yaml:"address"`
+
+	// Percent of matching requests (0-100) sent to the alternate backend.
+	Percent float64 `yaml:"percent"`
+
+	// Header forces the variant when present on the request. If HeaderValue
+	// is set the header must also have that value.
+	Header      string `yaml:"header"`
+	HeaderValue string `yaml:"header_value"`
+
+	// Cookie forces the variant when present on the request. If CookieValue
+	// is set the cookie must also have that value.
+	Cookie      string `yaml:"cookie"`
+	CookieValue string `yaml:"cookie_value"`
+
+	// Sticky pins a client to its first assignment with a cookie.
+	Sticky bool `yaml:"sticky"`
+
+	// StickyTTL is the lifetime of the sticky cookie. Default: 24h.
+	StickyTTL time.Duration `yaml:"sticky_ttl"`
+}
+
+var (
+	requestsTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "canary",
+		Name:      "requests_total",
+		Help:      "HTTP requests assigned to each traffic-splitting variant.",
+	}, []string{"rule", "variant"})
+
+	rpcTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "canary",
+		Name:      "rpc_total",
+		Help:      "Backend RPCs sent to each variant, by gRPC status code.",
+	}, []string{"variant", "code"})
+
+	rpcDuration = promauto.With(metrics.Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "canary",
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of backend RPCs sent to each variant.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"variant"})
+)
+
+type variantKey struct{}
+
+// WithVariant returns a copy of ctx assigned to the named variant.
+func WithVariant(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, variantKey{}, name)
+}
+
+// VariantFromContext returns the variant assigned to ctx, or Primary.
+func VariantFromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(variantKey{}).(string); ok && v != "" {
+		return v
+	}
+	return Primary
+}
+
+// Splitter holds the traffic-splitting rules and the connections to the
+// alternate backends.
+type Splitter struct {
+	rules []Config
+	conns map[string]*grpc.ClientConn
+	// backends maps each variant to the backend it stands in for
+	backends map[string]string
+}
+
+// New dials every alternate backend and returns a Splitter for cfgs.
+func New(cfgs []Config, opts ...grpc.DialOption) (*Splitter, error) {
+	s := &Splitter{conns: make(map[string]*grpc.ClientConn), backends: make(map[string]string)}
+	for _, cfg := range cfgs {
+		if cfg.Name == "" || cfg.Name == Primary {
+			s.Close()
+			return nil, fmt.Errorf("canary rule for %q needs a name other than %q", cfg.PathPrefix, Primary)
+		}
+		if cfg.Address == "" {
+			s.Close()
+			return nil, fmt.Errorf("canary rule %s has no address", cfg.Name)
+		}
+		if !strings.HasPrefix(cfg.PathPrefix, "/") {
+			s.Close()
+			return nil, fmt.Errorf("canary rule %s path prefix %q must start with /", cfg.Name, cfg.PathPrefix)
+		}
+		if cfg.Percent < 0 || cfg.Percent > 100 {
+			s.Close()
+			return nil, fmt.Errorf("canary rule %s percent %v must be between 0 and 100", cfg.Name, cfg.Percent)
+		}
+		if _, dup := s.conns[cfg.Name]; dup {
+			s.Close()
+			return nil, fmt.Errorf("duplicate canary rule %s", cfg.Name)
+		}
+		if cfg.Backend == "" {
+			cfg.Backend = backend.Inventory
+		}
+		if cfg.StickyTTL <= 0 {
+			cfg.StickyTTL = 24 * time.Hour
+		}
+
+		conn, err := grpc.NewClient(cfg.Address, opts...)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to dial canary backend %s: %w", cfg.Address, err)
+		}
+		s.conns[cfg.Name] = conn
+		s.backends[cfg.Name] = cfg.Backend
+		s.rules = append(s.rules, cfg)
+	}
+	return s, nil
+}
+
+// Close closes the connections to all alternate backends.
+func (s *Splitter) Close() error {
+	var firstErr error
+	for _, c := range s.conns {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Middleware assigns each request matching a rule to a variant.
+func (s *Splitter) Middleware(next http.Handler) http.Handler {
+	if len(s.rules) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := s.match(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		variant := assign(w, r, rule)
+		requestsTotal.WithLabelValues(rule.Name, variant).Inc()
+		w.Header().Set("X-Gateway-Variant", variant)
+
+		next.ServeHTTP(w, r.WithContext(WithVariant(r.Context(), variant)))
+	})
+}
+
+// Backends returns the backend each variant stands in for, by variant name.
+func (s *Splitter) Backends() map[string]string {
+	return maps.Clone(s.backends)
+}
+
+// Conn wraps primary, the connection to the named backend, so that its RPCs
+// made on behalf of a request assigned to an alternate variant of that
+// backend are sent to the variant instead. Connections to backends without
+// variants are returned as they are.
+func (s *Splitter) Conn(name string, primary grpc.ClientConnInterface) grpc.ClientConnInterface {
+	if s == nil || !slices.Contains(slices.Collect(maps.Values(s.backends)), name) {
+		return primary
+	}
+	return &splitConn{name: name, primary: primary, s: s}
+}
+
+func (s *Splitter) match(path string) (Config, bool) {
+	for _, rule := range s.rules {
+		if strings.HasPrefix(path, rule.PathPrefix) {
+			return rule, true
+		}
+	}
+	return Config{}, false
+}
+
+func assign(w http.ResponseWriter, r *http.Request, rule Config) string {
+	if rule.Header != "" {
+		if v := r.Header.Get(rule.Header); v != "" && (rule.HeaderValue == "" || v == rule.HeaderValue) {
+			return rule.Name
+		}
+	}
+	if rule.Cookie != "" {
+		if c, err := r.Cookie(rule.Cookie); err == nil && (rule.CookieValue == "" || c.Value == rule.CookieValue) {
+			return rule.Name
+		}
+	}
+
+	stickyName := "gw_variant_" + rule.Name
+	if rule.Sticky {
+		if c, err := r.Cookie(stickyName); err == nil && (c.Value == rule.Name || c.Value == Primary) {
+			return c.Value
+		}
+	}
+
+	variant := Primary
+	if rand.Float64()*100 < rule.Percent {
+		variant = rule.Name
+	}
+
+	if rule.Sticky {
+		http.SetCookie(w, &http.Cookie{
+			Name:     stickyName,
+			Value:    variant,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+			Secure:   r.TLS != nil,
+			MaxAge:   int(rule.StickyTTL.Seconds()),
+		})
+	}
+	return variant
+}
+
+type splitConn struct {
+	name    string
+	primary grpc.ClientConnInterface
+	s       *Splitter
+}
+
+func (c *splitConn) pick(ctx context.Context) (string, grpc.ClientConnInterface) {
+	variant := VariantFromContext(ctx)
+	if conn, ok := c.s.conns[variant]; ok && c.s.backends[variant] == c.name {
+		return variant, conn
+	}
+	return Primary, c.primary
+}
+
+func (c *splitConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	variant, conn := c.pick(ctx)
+	start := time.Now()
+	err := conn.Invoke(ctx, method, args, reply, opts...)
+	metrics.ObserveDuration(ctx, rpcDuration.WithLabelValues(variant), time.Since(start), err != nil)
+	rpcTotal.WithLabelValues(variant, status.Code(err).String()).Inc()
+	return err
+}
+
+func (c *splitConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	_, conn := c.pick(ctx)
+	return conn.NewStream(ctx, desc, method, opts...)
+}
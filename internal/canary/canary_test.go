@@ -0,0 +1,163 @@
+package canary_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/canary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newSplitter builds a splitter whose alternate backend is never dialed by these tests
+func newSplitter(t *testing.T, cfg canary.Config) *canary.Splitter {
+	t.Helper()
+	cfg.Name = "canary"
+	cfg.Address = "localhost:1"
+	s, err := canary.New([]canary.Config{cfg}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// variantOf runs req through the splitter and returns the variant the handler saw
+func variantOf(t *testing.T, s *canary.Splitter, req *http.Request) (string, *httptest.ResponseRecorder) {
+	t.Helper()
+	var seen string
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = canary.VariantFromContext(r.Context())
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return seen, rec
+}
+
+// TestSplitter_PercentRouting tests the 0% and 100% extremes of percentage routing
+func TestSplitter_PercentRouting(t *testing.T) {
+	none := newSplitter(t, canary.Config{PathPrefix: "/inventory", Percent: 0})
+	all := newSplitter(t, canary.Config{PathPrefix: "/inventory", Percent: 100})
+
+	for i := 0; i < 20; i++ {
+		v, _ := variantOf(t, none, httptest.NewRequest(http.MethodGet, "/inventory/get", nil))
+		assert.Equal(t, canary.Primary, v)
+
+		v, rec := variantOf(t, all, httptest.NewRequest(http.MethodGet, "/inventory/get", nil))
+		assert.Equal(t, "canary", v)
+		assert.Equal(t, "canary", rec.Header().Get("X-Gateway-Variant"))
+	}
+}
+
+// TestSplitter_UnmatchedRoute tests that requests outside the rule's prefix stay on the primary
+func TestSplitter_UnmatchedRoute(t *testing.T) {
+	s := newSplitter(t, canary.Config{PathPrefix: "/inventory", Percent: 100})
+
+	v, rec := variantOf(t, s, httptest.NewRequest(http.MethodPost, "/auth/login", nil))
+
+	assert.Equal(t, canary.Primary, v)
+	assert.Empty(t, rec.Header().Get("X-Gateway-Variant"))
+}
+
+// TestSplitter_HeaderAndCookieOverride tests forcing the variant by header or cookie
+func TestSplitter_HeaderAndCookieOverride(t *testing.T) {
+	s := newSplitter(t, canary.Config{
+		PathPrefix:  "/inventory",
+		Percent:     0,
+		Header:      "X-Canary",
+		HeaderValue: "always",
+		Cookie:      "beta",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/inventory/list", nil)
+	req.Header.Set("X-Canary", "always")
+	v, _ := variantOf(t, s, req)
+	assert.Equal(t, "canary", v)
+
+	req = httptest.NewRequest(http.MethodGet, "/inventory/list", nil)
+	req.Header.Set("X-Canary", "sometimes")
+	v, _ = variantOf(t, s, req)
+	assert.Equal(t, canary.Primary, v)
+
+	req = httptest.NewRequest(http.MethodGet, "/inventory/list", nil)
+	req.AddCookie(&http.Cookie{Name: "beta", Value: "1"})
+	v, _ = variantOf(t, s, req)
+	assert.Equal(t, "canary", v)
+}
+
+// TestSplitter_StickyAssignment tests that a sticky cookie pins later requests to the first assignment
+func TestSplitter_StickyAssignment(t *testing.T) {
+	s := newSplitter(t, canary.Config{PathPrefix: "/inventory", Percent: 100, Sticky: true})
+
+	v, rec := variantOf(t, s, httptest.NewRequest(http.MethodGet, "/inventory/get", nil))
+	require.Equal(t, "canary", v)
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "gw_variant_canary", cookies[0].Name)
+	assert.Equal(t, "canary", cookies[0].Value)
+
+	// a client previously pinned to the primary stays there despite 100% rollout
+	req := httptest.NewRequest(http.MethodGet, "/inventory/get", nil)
+	req.AddCookie(&http.Cookie{Name: "gw_variant_canary", Value: canary.Primary})
+	v, rec = variantOf(t, s, req)
+	assert.Equal(t, canary.Primary, v)
+	assert.Empty(t, rec.Result().Cookies())
+}
+
+// TestNew_Invalid tests that rules without a path prefix or with a percent outside 0-100 are rejected
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  canary.Config
+	}{
+		{"empty prefix", canary.Config{Percent: 10}},
+		{"relative prefix", canary.Config{PathPrefix: "inventory", Percent: 10}},
+		{"negative percent", canary.Config{PathPrefix: "/inventory", Percent: -1}},
+		{"percent above 100", canary.Config{PathPrefix: "/inventory", Percent: 150}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Name = "canary"
+			tt.cfg.Address = "localhost:1"
+			_, err := canary.New([]canary.Config{tt.cfg}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// recordingConn is a primary connection counting the RPCs it gets
+type recordingConn struct {
+	grpc.ClientConnInterface
+	calls int
+}
+
+func (c *recordingConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	c.calls++
+	return nil
+}
+
+// TestSplitter_BackendScope tests that a variant only takes the calls of the backend it stands in for
+func TestSplitter_BackendScope(t *testing.T) {
+	s := newSplitter(t, canary.Config{PathPrefix: "/inventory", Percent: 100})
+	assert.Equal(t, map[string]string{"canary": "inventory"}, s.Backends())
+
+	auth := &recordingConn{}
+	assert.Same(t, auth, s.Conn("auth", auth), "backends without variants are not wrapped")
+
+	ctx := canary.WithVariant(context.Background(), "canary")
+	require.NoError(t, s.Conn("auth", auth).Invoke(ctx, "/auth.AuthService/Validate", nil, nil))
+	assert.Equal(t, 1, auth.calls)
+
+	inventory := &recordingConn{}
+	conn := s.Conn("inventory", inventory)
+	require.NoError(t, conn.Invoke(context.Background(), "/inventory.InventoryService/GetProduct", nil, nil))
+	assert.Equal(t, 1, inventory.calls, "primary requests stay on the primary")
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, conn.Invoke(ctx, "/inventory.InventoryService/GetProduct", nil, nil), "the canary address is not served")
+	assert.Equal(t, 1, inventory.calls)
+}
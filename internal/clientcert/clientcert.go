@@ -0,0 +1,94 @@
+// Package clientcert maps the verified TLS client certificates of internal
+// callers to identities, so that services calling the gateway over mTLS
+// need no access token. Certificates are verified by the listener against
+// its client CA; this package only decides who a verified certificate
+// stands for.
+package clientcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// Config lists the identities of client certificates.
+type Config struct {
+	Identities []Identity `yaml:"identities"`
+}
+
+// Identity is the caller a client certificate stands for. A certificate
+// matches when it has the CommonName, DNSName or URI set, e.g. a SPIFFE ID.
+type Identity struct {
+	CommonName string `yaml:"common_name"`
+	DNSName    string `yaml:"dns_name"`
+	URI        string `yaml:"uri"`
+
+	// ID is the user ID of the caller. Default: the matched name.
+	ID string `yaml:"id"`
+
+	// Roles are the roles of the caller.
+	Roles []string `yaml:"roles"`
+}
+
+// Mapper maps client certificates to identities. A nil *Mapper maps none.
+type Mapper struct {
+	identities []Identity
+}
+
+// New returns the Mapper of cfg, or nil without identities.
+func New(cfg Config) (*Mapper, error) {
+	if len(cfg.Identities) == 0 {
+		return nil, nil
+	}
+	m := &Mapper{}
+	for i, id := range cfg.Identities {
+		var names []string
+		for _, name := range []string{id.CommonName, id.DNSName, id.URI} {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) != 1 {
+			return nil, fmt.Errorf("client certificate identity %d: exactly one of common_name, dns_name and uri is required", i)
+		}
+		if id.ID == "" {
+			id.ID = names[0]
+		}
+		m.identities = append(m.identities, id)
+	}
+	return m, nil
+}
+
+// ErrUnknown is returned by Identify for verified certificates without an
+// identity.
+var ErrUnknown = errors.New("client certificate has no identity")
+
+// Identify returns the identity of the verified client certificate of
+// state. It returns nil, nil without a verified certificate, and the first
+// identity the certificate matches otherwise.
+func (m *Mapper) Identify(state *tls.ConnectionState) (*Identity, error) {
+	if m == nil || state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	cert := state.VerifiedChains[0][0]
+	for i, id := range m.identities {
+		if matches(cert, id) {
+			return &m.identities[i], nil
+		}
+	}
+	return nil, ErrUnknown
+}
+
+func matches(cert *x509.Certificate, id Identity) bool {
+	switch {
+	case id.CommonName != "":
+		return cert.Subject.CommonName == id.CommonName
+	case id.DNSName != "":
+		return slices.Contains(cert.DNSNames, id.DNSName)
+	default:
+		return slices.ContainsFunc(cert.URIs, func(u *url.URL) bool { return u.String() == id.URI })
+	}
+}
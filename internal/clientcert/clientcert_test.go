@@ -0,0 +1,63 @@
+package clientcert_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/clientcert"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func verified(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+// TestMapper tests that verified certificates map to the identity of their common name, DNS name or URI
+func TestMapper(t *testing.T) {
+	m, err := clientcert.New(clientcert.Config{Identities: []clientcert.Identity{
+		{CommonName: "billing", Roles: []string{"inventory_reader"}},
+		{DNSName: "reports.internal", ID: "reports"},
+		{URI: "spiffe://corp/ns/prod/sa/sync", ID: "sync", Roles: []string{"admin"}},
+	}})
+	require.NoError(t, err)
+
+	id, err := m.Identify(verified(&x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}))
+	require.NoError(t, err)
+	assert.Equal(t, "billing", id.ID)
+	assert.Equal(t, []string{"inventory_reader"}, id.Roles)
+
+	id, err = m.Identify(verified(&x509.Certificate{DNSNames: []string{"other.internal", "reports.internal"}}))
+	require.NoError(t, err)
+	assert.Equal(t, "reports", id.ID)
+
+	spiffe, _ := url.Parse("spiffe://corp/ns/prod/sa/sync")
+	id, err = m.Identify(verified(&x509.Certificate{URIs: []*url.URL{spiffe}}))
+	require.NoError(t, err)
+	assert.Equal(t, "sync", id.ID)
+
+	_, err = m.Identify(verified(&x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}))
+	assert.ErrorIs(t, err, clientcert.ErrUnknown)
+
+	id, err = m.Identify(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "billing"}}}})
+	require.NoError(t, err)
+	assert.Nil(t, id, "unverified certificates are ignored")
+	id, err = m.Identify(nil)
+	require.NoError(t, err)
+	assert.Nil(t, id)
+}
+
+// TestNew tests that each identity names one certificate field
+func TestNew(t *testing.T) {
+	m, err := clientcert.New(clientcert.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	_, err = clientcert.New(clientcert.Config{Identities: []clientcert.Identity{{ID: "nobody"}}})
+	assert.Error(t, err)
+	_, err = clientcert.New(clientcert.Config{Identities: []clientcert.Identity{{CommonName: "a", DNSName: "b"}}})
+	assert.Error(t, err)
+}
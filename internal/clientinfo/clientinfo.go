@@ -0,0 +1,69 @@
+// Package clientinfo captures details about the HTTP client (address chain,
+// scheme, user agent) so they can be forwarded to backends as gRPC metadata.
+package clientinfo
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/andro-kes/gateway/internal/realip"
+)
+
+// Info describes the client of an inbound request.
+type Info struct {
+	// ForwardedFor is the client address chain, oldest first, ending with
+	// the address of the peer connected to the gateway.
+	ForwardedFor []string
+
+	// Proto is the scheme the client used ("http" or "https").
+	Proto string
+
+	// RealIP is the best guess of the originating client address.
+	RealIP string
+
+	UserAgent string
+}
+
+type infoKey struct{}
+
+// WithInfo returns a copy of ctx carrying info.
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, infoKey{}, info)
+}
+
+// FromContext returns the client info stored in ctx.
+func FromContext(ctx context.Context) (Info, bool) {
+	info, ok := ctx.Value(infoKey{}).(Info)
+	return info, ok
+}
+
+// Middleware records client info for every request. X-Forwarded-For and
+// X-Forwarded-Proto sent by the client are only honoured when the connecting
+// peer is a trusted proxy; otherwise they are discarded.
+func Middleware(res *realip.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer := realip.Peer(r)
+
+			info := Info{
+				ForwardedFor: []string{peer},
+				Proto:        "http",
+				RealIP:       res.ClientIP(r),
+				UserAgent:    r.UserAgent(),
+			}
+			if r.TLS != nil {
+				info.Proto = "https"
+			}
+
+			if res.Trusted(peer) {
+				info.ForwardedFor = append(realip.ForwardedFor(r), peer)
+				if p := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); p == "http" || p == "https" {
+					info.Proto = p
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithInfo(r.Context(), info)))
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package clientinfo_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/clientinfo"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func capture(t *testing.T, trusted []string, r *http.Request) clientinfo.Info {
+	t.Helper()
+	res, err := realip.New(realip.Config{TrustedProxies: trusted})
+	require.NoError(t, err)
+
+	var info clientinfo.Info
+	h := clientinfo.Middleware(res)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+		info, ok = clientinfo.FromContext(r.Context())
+		require.True(t, ok)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	return info
+}
+
+// TestMiddleware_UntrustedPeer tests that forwarded headers from untrusted peers are ignored
+func TestMiddleware_UntrustedPeer(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.7:5555"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("User-Agent", "curl/8.0")
+
+	info := capture(t, []string{"10.0.0.0/8"}, r)
+	assert.Equal(t, []string{"203.0.113.7"}, info.ForwardedFor)
+	assert.Equal(t, "203.0.113.7", info.RealIP)
+	assert.Equal(t, "http", info.Proto)
+	assert.Equal(t, "curl/8.0", info.UserAgent)
+}
+
+// TestMiddleware_TrustedProxy tests that the chain is extended and the real IP skips trusted hops
+func TestMiddleware_TrustedProxy(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:5555"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.9, 10.0.0.5")
+	r.Header.Set("X-Forwarded-Proto", "https")
+
+	info := capture(t, []string{"10.0.0.0/8"}, r)
+	assert.Equal(t, []string{"198.51.100.1", "203.0.113.9", "10.0.0.5", "10.0.0.2"}, info.ForwardedFor)
+	assert.Equal(t, "203.0.113.9", info.RealIP)
+	assert.Equal(t, "https", info.Proto)
+}
+
+// TestMiddleware_TLS tests that the scheme reflects the connection when not forwarded
+func TestMiddleware_TLS(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.TLS = &tls.ConnectionState{}
+
+	info := capture(t, nil, r)
+	assert.Equal(t, "https", info.Proto)
+}
@@ -0,0 +1,460 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/access"
+	"github.com/andro-kes/gateway/internal/accesslog"
+	"github.com/andro-kes/gateway/internal/backend"
+	"github.com/andro-kes/gateway/internal/batch"
+	"github.com/andro-kes/gateway/internal/bodydump"
+	"github.com/andro-kes/gateway/internal/bot"
+	"github.com/andro-kes/gateway/internal/bulkhead"
+	"github.com/andro-kes/gateway/internal/cachecontrol"
+	"github.com/andro-kes/gateway/internal/canary"
+	"github.com/andro-kes/gateway/internal/clientcert"
+	"github.com/andro-kes/gateway/internal/contenttype"
+	"github.com/andro-kes/gateway/internal/cookie"
+	"github.com/andro-kes/gateway/internal/discovery"
+	"github.com/andro-kes/gateway/internal/envelope"
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/fault"
+	"github.com/andro-kes/gateway/internal/feature"
+	"github.com/andro-kes/gateway/internal/filter"
+	"github.com/andro-kes/gateway/internal/fixture"
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/andro-kes/gateway/internal/interceptor"
+	"github.com/andro-kes/gateway/internal/jobs"
+	"github.com/andro-kes/gateway/internal/maintenance"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/mfa"
+	"github.com/andro-kes/gateway/internal/mirror"
+	"github.com/andro-kes/gateway/internal/money"
+	"github.com/andro-kes/gateway/internal/notification"
+	"github.com/andro-kes/gateway/internal/overload"
+	"github.com/andro-kes/gateway/internal/pagination"
+	"github.com/andro-kes/gateway/internal/passthrough"
+	"github.com/andro-kes/gateway/internal/personalize"
+	"github.com/andro-kes/gateway/internal/queue"
+	"github.com/andro-kes/gateway/internal/quota"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/respcache"
+	"github.com/andro-kes/gateway/internal/rotation"
+	"github.com/andro-kes/gateway/internal/routing"
+	"github.com/andro-kes/gateway/internal/schema"
+	"github.com/andro-kes/gateway/internal/secrets"
+	"github.com/andro-kes/gateway/internal/server"
+	"github.com/andro-kes/gateway/internal/servicecred"
+	"github.com/andro-kes/gateway/internal/signature"
+	"github.com/andro-kes/gateway/internal/slo"
+	"github.com/andro-kes/gateway/internal/timing"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/andro-kes/gateway/internal/tracing"
+	"github.com/andro-kes/gateway/internal/webhook"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the top-level gateway configuration. It is read from an optional
+// YAML file and then overridden by environment variables and command-line flags.
+type Config struct {
+	// HTTPAddr is the address the HTTP server listens on when no Listeners
+	// are configured. Env: HTTP_ADDR.
+	HTTPAddr string `yaml:"http_addr"`
+
+	// Listeners are the TCP addresses and Unix sockets served, each with its
+	// own TLS and protocol settings.
+	Listeners []server.ListenerConfig `yaml:"listeners"`
+
+	// HTTPServer holds the timeouts and header limits of every listener.
+	HTTPServer server.Limits `yaml:"http_server"`
+
+	// GRPCAddr is the address of the upstream gRPC services. Env: GRPC_ADDR.
+	GRPCAddr string `yaml:"grpc_addr"`
+
+	// Backends configures the connection pool of each gRPC backend ("auth",
+	// "inventory"). Backends without an address use GRPCAddr.
+	Backends map[string]backend.Config `yaml:"backends"`
+
+	// RealIP configures which proxies are trusted to report the client IP.
+	RealIP realip.Config `yaml:"real_ip"`
+
+	// Concurrency caps in-flight requests globally and per backend.
+	Concurrency bulkhead.Config `yaml:"concurrency"`
+
+	// Overload sheds low-priority requests when latency, load or CPU exceed their targets.
+	Overload overload.Config `yaml:"overload"`
+
+	// Queue queues requests and bulk calls by priority class once the
+	// backends are saturated.
+	Queue queue.Config `yaml:"queue"`
+
+	// GRPCClient configures the interceptors applied to every backend call.
+	GRPCClient interceptor.Config `yaml:"grpc_client"`
+
+	// BackendAuth attaches the gateway's own credential to every backend
+	// call.
+	BackendAuth servicecred.Config `yaml:"backend_auth"`
+
+	// Auth configures access token handling on protected routes.
+	Auth AuthConfig `yaml:"auth"`
+
+	// Admin configures the /admin API.
+	Admin AdminConfig `yaml:"admin"`
+
+	// Inventory configures the /inventory routes.
+	Inventory InventoryConfig `yaml:"inventory"`
+
+	// Events configures publishing of mutation events to Kafka or NATS.
+	Events events.Config `yaml:"events"`
+
+	// Webhooks configures delivery of events to HTTP endpoints.
+	Webhooks webhook.Config `yaml:"webhooks"`
+
+	// Batch limits the sub-requests of POST /batch.
+	Batch batch.Config `yaml:"batch"`
+
+	// Jobs configures the background job runner behind CSV imports and
+	// exports, and where job state is kept.
+	Jobs jobs.Config `yaml:"jobs"`
+
+	// Quotas enforces daily and monthly request budgets per client.
+	Quotas quota.Config `yaml:"quotas"`
+
+	// Routes declares passthrough endpoints, each served by a unary RPC of
+	// a backend, without custom handler code.
+	Routes []passthrough.Route `yaml:"routes"`
+
+	// RPC serves POST /rpc/{service}/{method} for the allowed RPCs of the
+	// backends, resolved with their reflection service.
+	RPC passthrough.RPCConfig `yaml:"rpc"`
+
+	// Schema fetches the schemas of backends with their reflection service
+	// and refreshes them.
+	Schema schema.Config `yaml:"schema"`
+
+	// Money configures how prices are exchanged with clients.
+	Money money.Config `yaml:"money"`
+
+	// Notifications configures the background delivery of /notifications
+	// requests. The route group is only served when backends has a
+	// "notifications" entry.
+	Notifications notification.Config `yaml:"notifications"`
+
+	// Pagination bounds list page sizes and signs list cursors.
+	Pagination pagination.Config `yaml:"pagination"`
+
+	// ContentTypes extends the request media types accepted on mutating routes.
+	ContentTypes contenttype.Config `yaml:"content_types"`
+
+	// ProtobufPassthrough lets clients send and receive protobuf binary
+	// bodies (application/x-protobuf) instead of JSON.
+	ProtobufPassthrough bool `yaml:"protobuf_passthrough"`
+
+	// CacheControl sets Cache-Control and Expires on responses per route.
+	CacheControl cachecontrol.Config `yaml:"cache_control"`
+
+	// ResponseCache caches GET responses of selected routes in the gateway.
+	ResponseCache respcache.Config `yaml:"response_cache"`
+
+	// ResponseEnvelope wraps JSON responses in {"data": ..., "meta": ...}
+	// per route or on request.
+	ResponseEnvelope envelope.Config `yaml:"response_envelope"`
+
+	// AccessLog configures the per-request access log.
+	AccessLog accesslog.Config `yaml:"access_log"`
+
+	// I18n adds languages and messages to the catalogs the messages of
+	// JSON error bodies are localized with.
+	I18n i18n.Config `yaml:"i18n"`
+
+	// Tracing decides which requests are traced.
+	Tracing tracing.Config `yaml:"tracing"`
+
+	// LatencyBudgets logs requests slower than the budget of their route,
+	// with the time spent per segment.
+	LatencyBudgets timing.Config `yaml:"latency_budgets"`
+
+	// Metrics selects whether metrics are scraped from /metrics, pushed to
+	// an OpenTelemetry collector, or both.
+	Metrics metrics.Config `yaml:"metrics"`
+
+	// ServerTiming reports the time spent per segment to clients in a
+	// Server-Timing response header.
+	ServerTiming timing.ServerTimingConfig `yaml:"server_timing"`
+
+	// Maintenance configures maintenance mode, which can also be toggled
+	// through the admin API.
+	Maintenance maintenance.Config `yaml:"maintenance"`
+
+	// BodyDump limits the request/response body dumps enabled through the admin API.
+	BodyDump bodydump.Config `yaml:"body_dump"`
+
+	// Access restricts route groups by client IP and country. Reloaded on SIGHUP.
+	Access access.Config `yaml:"access"`
+
+	// Filters are custom request filters loaded at startup, applied in order.
+	Filters []filter.Config `yaml:"filters"`
+
+	// Canary routes a share of matching requests to alternate backends.
+	Canary []canary.Config `yaml:"canary"`
+
+	// Mirror duplicates selected read RPCs to a shadow backend.
+	Mirror mirror.Config `yaml:"mirror"`
+
+	// Startup configures the checks run before the gateway starts serving.
+	Startup StartupConfig `yaml:"startup"`
+
+	// Fixtures records backend calls to fixture files, or replays them
+	// without the backends.
+	Fixtures fixture.Config `yaml:"fixtures"`
+
+	// Routing configures the answers to requests without a route.
+	Routing routing.Config `yaml:"routing"`
+
+	// Features are the feature flags consulted by routes and handlers,
+	// toggled at runtime through the admin API.
+	Features feature.Config `yaml:"features"`
+
+	// SLO tracks service level objectives per route group and alerts when
+	// their error budgets burn too fast.
+	SLO slo.Config `yaml:"slo"`
+
+	// Faults injects latency, errors and dropped connections for
+	// resilience tests, once enabled through the admin API.
+	Faults fault.Config `yaml:"faults"`
+
+	// Bots tags, rate-limits or blocks requests that look automated before
+	// they reach the backends.
+	Bots bot.Config `yaml:"bots"`
+
+	// Secrets configures the stores that secret values may reference
+	// instead of holding the secret, e.g. hmac_secret: vault:/secret/data/gateway#jwt.
+	Secrets secrets.Config `yaml:"secrets"`
+}
+
+// StartupConfig configures the checks run before the gateway starts serving.
+type StartupConfig struct {
+	// BackendTimeout bounds the reachability check of the backends. Default: 5s.
+	BackendTimeout time.Duration `yaml:"backend_timeout"`
+
+	// AllowUnreachableBackends starts the gateway with a warning when a
+	// backend cannot be reached, instead of failing.
+	AllowUnreachableBackends bool `yaml:"allow_unreachable_backends"`
+}
+
+// AuthConfig configures access token handling on protected routes.
+type AuthConfig struct {
+	// JWT holds the key material for verifying access token signatures.
+	JWT token.Config `yaml:"jwt"`
+
+	// DisableIdentityMetadata stops the gateway from forwarding x-user-id,
+	// x-user-roles and x-token-exp to backends (for zero-trust setups where
+	// backends must re-validate the token themselves).
+	DisableIdentityMetadata bool `yaml:"disable_identity_metadata"`
+
+	// Personalization forwards claims of the caller, such as its locale or
+	// tier, to backends as metadata on inventory reads and declared routes.
+	Personalization personalize.Config `yaml:"personalization"`
+
+	// ResponseMode is how login and refresh return tokens when the client
+	// sends no X-Auth-Response header: "cookies" (default) or "tokens".
+	// Env: AUTH_RESPONSE_MODE.
+	ResponseMode string `yaml:"response_mode"`
+
+	// RememberTTL is the lifetime of the refresh token cookie of logins with
+	// remember_me. Default: the expiry of the refresh token.
+	RememberTTL time.Duration `yaml:"remember_ttl"`
+
+	// Cookies configures the names and attributes of the token cookies.
+	Cookies cookie.Config `yaml:"cookies"`
+
+	// RefreshRotation rejects reused refresh tokens and revokes their session.
+	RefreshRotation rotation.Config `yaml:"refresh_rotation"`
+
+	// Signatures authenticates machine clients by HMAC request signatures
+	// with replay protection, as an alternative to access tokens.
+	Signatures signature.Config `yaml:"signatures"`
+
+	// ClientCerts authenticates internal callers by the TLS client
+	// certificates verified by listeners with a client CA.
+	ClientCerts clientcert.Config `yaml:"client_certs"`
+
+	// Discovery serves the OpenID Connect discovery document and the key
+	// set of the access tokens under /.well-known.
+	Discovery discovery.Config `yaml:"discovery"`
+
+	// Account serves /auth/account for users to change their password or
+	// email and delete their account.
+	Account AccountConfig `yaml:"account"`
+
+	// MFA adds a TOTP code step to the logins of users who set it up, and
+	// serves /auth/mfa.
+	MFA mfa.Config `yaml:"mfa"`
+
+	// MagicLink serves /auth/magic-link, the passwordless login by emailed
+	// links. It needs the notifications backend.
+	MagicLink MagicLinkConfig `yaml:"magic_link"`
+}
+
+// AccountConfig configures the /auth/account routes.
+type AccountConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// MaxAuthAge is how recently users must have logged in to change their
+	// account, going by the auth_time or iat of their token. Default: 5m.
+	MaxAuthAge time.Duration `yaml:"max_auth_age"`
+}
+
+// MagicLinkConfig configures the /auth/magic-link routes.
+type MagicLinkConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// URL is the absolute URL of the page the links open, which posts the
+	// token of its token query parameter to /auth/magic-link/verify.
+	URL string `yaml:"url"`
+
+	// Template is the notification template of the link emails, which gets
+	// the link and expires_in_seconds. Default: magic_link.
+	Template string `yaml:"template"`
+
+	// Subject is the subject of the link emails.
+	Subject string `yaml:"subject"`
+}
+
+// AdminConfig configures the /admin API.
+type AdminConfig struct {
+	// Token is the bearer token admin requests must present. The admin API
+	// is disabled when empty. Env: ADMIN_TOKEN.
+	Token string `yaml:"token"`
+
+	// Users serves /admin/users, the user administration of auth_service.
+	Users AdminUsersConfig `yaml:"users"`
+}
+
+// AdminUsersConfig configures the /admin/users routes. Unlike the rest of
+// the admin API, they take the access token of an operator instead of the
+// admin token.
+type AdminUsersConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Roles are the token roles allowed to administer users.
+	// Default: [user_admin].
+	Roles []string `yaml:"roles"`
+}
+
+// InventoryConfig configures the /inventory routes.
+type InventoryConfig struct {
+	// RequireIfMatch rejects updates and deletes without an If-Match header.
+	RequireIfMatch bool `yaml:"require_if_match"`
+
+	// HardDeleteRoles are the token roles allowed to delete products
+	// permanently. Roles need a JWT key to be verified. Default: admin, or
+	// everyone without a JWT key; an empty list allows everyone.
+	HardDeleteRoles []string `yaml:"hard_delete_roles"`
+
+	// AvailabilityRoles are the token roles allowed to take products offline
+	// and back online, including soft deletes. Roles need a JWT key to be
+	// verified. Default: admin, or everyone without a JWT key; an empty list
+	// allows everyone.
+	AvailabilityRoles []string `yaml:"availability_roles"`
+
+	// StreamPageSize is the backend page size used when streaming lists as
+	// NDJSON. Default: 500.
+	StreamPageSize int32 `yaml:"stream_page_size"`
+}
+
+// Load reads the configuration file at path (if non-empty), applies
+// environment overrides on top of it and resolves the secret references.
+func Load(path string) (*Config, error) {
+	cfg, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := os.Getenv("HTTP_ADDR"); v != "" {
+		cfg.HTTPAddr = v
+	}
+	if v := os.Getenv("GRPC_ADDR"); v != "" {
+		cfg.GRPCAddr = v
+	}
+	if v := os.Getenv("JWT_SECRET"); v != "" {
+		cfg.Auth.JWT.HMACSecret = v
+	}
+	if v := os.Getenv("AUTH_RESPONSE_MODE"); v != "" {
+		cfg.Auth.ResponseMode = v
+	}
+	if v := os.Getenv("COOKIE_ACCESS_NAME"); v != "" {
+		cfg.Auth.Cookies.AccessName = v
+	}
+	if v := os.Getenv("COOKIE_REFRESH_NAME"); v != "" {
+		cfg.Auth.Cookies.RefreshName = v
+	}
+	if v := os.Getenv("COOKIE_REMEMBER_NAME"); v != "" {
+		cfg.Auth.Cookies.RememberName = v
+	}
+	if v := os.Getenv("COOKIE_DOMAIN"); v != "" {
+		cfg.Auth.Cookies.Domain = v
+	}
+	if v := os.Getenv("COOKIE_PATH"); v != "" {
+		cfg.Auth.Cookies.Path = v
+	}
+	if v := os.Getenv("COOKIE_SAMESITE"); v != "" {
+		cfg.Auth.Cookies.SameSite = v
+	}
+	if v := os.Getenv("COOKIE_SECURE"); v != "" {
+		cfg.Auth.Cookies.Secure = v
+	}
+	if v := os.Getenv("COOKIE_PREFIX"); v != "" {
+		cfg.Auth.Cookies.Prefix = v
+	}
+	if v := os.Getenv("COOKIE_ENCRYPTION_KEYS"); v != "" {
+		cfg.Auth.Cookies.EncryptionKeys = nil
+		for _, key := range strings.Split(v, ",") {
+			cfg.Auth.Cookies.EncryptionKeys = append(cfg.Auth.Cookies.EncryptionKeys, strings.TrimSpace(key))
+		}
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		cfg.Admin.Token = v
+	}
+	if v := os.Getenv("PAGINATION_SECRET"); v != "" {
+		cfg.Pagination.Secret = v
+	}
+	if v := os.Getenv("FIXTURE_MODE"); v != "" {
+		cfg.Fixtures.Mode = v
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); v != "" {
+		cfg.Metrics.OTLP.Endpoint = strings.TrimSuffix(v, "/") + "/v1/metrics"
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"); v != "" {
+		cfg.Metrics.OTLP.Endpoint = v
+	}
+	if v := os.Getenv("OTEL_SERVICE_NAME"); v != "" {
+		cfg.Metrics.OTLP.ServiceName = v
+	}
+
+	if err := cfg.resolveSecrets(context.Background()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ReadFile reads the configuration file at path alone, without environment
+// overrides or secret resolution. An empty path yields the zero Config.
+func ReadFile(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
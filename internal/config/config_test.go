@@ -0,0 +1,67 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadDotEnv tests that .env variables fill in the environment without overriding it
+func TestLoadDotEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, os.WriteFile(path, []byte(`# local development
+export GRPC_ADDR=localhost:50051 # inventory
+HTTP_ADDR=":9090"
+JWT_SECRET='literal $ecret # kept'
+ADMIN_TOKEN="line\nbreak"
+`), 0o600))
+	t.Setenv("HTTP_ADDR", ":8080")
+	for _, name := range []string{"GRPC_ADDR", "JWT_SECRET", "ADMIN_TOKEN"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	require.NoError(t, config.LoadDotEnv(path))
+	cfg, err := config.Load("")
+	require.NoError(t, err)
+	assert.Equal(t, ":8080", cfg.HTTPAddr)
+	assert.Equal(t, "localhost:50051", cfg.GRPCAddr)
+	assert.Equal(t, "literal $ecret # kept", cfg.Auth.JWT.HMACSecret)
+	assert.Equal(t, "line\nbreak", cfg.Admin.Token)
+
+	assert.NoError(t, config.LoadDotEnv(filepath.Join(t.TempDir(), "missing")))
+	require.NoError(t, os.WriteFile(path, []byte("NOT A VARIABLE\n"), 0o600))
+	assert.ErrorContains(t, config.LoadDotEnv(path), "line 1")
+}
+
+// TestConfig_Redacted tests that secrets are masked or shown as their reference without changing the original
+func TestConfig_Redacted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pagination")
+	require.NoError(t, os.WriteFile(path, []byte("cursor-secret\n"), 0o600))
+	cfgPath := filepath.Join(t.TempDir(), "gateway.yaml")
+	require.NoError(t, os.WriteFile(cfgPath, []byte(`
+auth:
+  jwt:
+    hmac_secret: plain
+  cookies:
+    encryption_keys: [k1]
+pagination:
+  secret: file:`+path+`
+`), 0o600))
+	t.Setenv("JWT_SECRET", "")
+
+	cfg, err := config.Load(cfgPath)
+	require.NoError(t, err)
+	assert.Equal(t, "cursor-secret", cfg.Pagination.Secret)
+
+	out := cfg.Redacted()
+	assert.Equal(t, "<redacted>", out.Auth.JWT.HMACSecret)
+	assert.Equal(t, []string{"<redacted>"}, out.Auth.Cookies.EncryptionKeys)
+	assert.Equal(t, "file:"+path, out.Pagination.Secret)
+	assert.Equal(t, "plain", cfg.Auth.JWT.HMACSecret)
+	assert.Equal(t, []string{"k1"}, cfg.Auth.Cookies.EncryptionKeys)
+}
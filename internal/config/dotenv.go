@@ -0,0 +1,91 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// LoadDotEnv sets the variables of the .env file at path that are not
+// already set in the environment, so that the environment wins over the
+// file. A missing file is not an error.
+//
+// Lines are KEY=VALUE, optionally prefixed with "export". Blank lines and
+// lines starting with # are skipped. Values may be single-quoted, taken
+// literally, or double-quoted, where \n, \t, \" and \\ are unescaped.
+// Unquoted values end at " #".
+func LoadDotEnv(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	vars, err := parseDotEnv(bufio.NewScanner(f))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, kv := range vars {
+		if _, set := os.LookupEnv(kv[0]); set {
+			continue
+		}
+		if err := os.Setenv(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseDotEnv returns the KEY, VALUE pairs of a .env file in order.
+func parseDotEnv(sc *bufio.Scanner) ([][2]string, error) {
+	var vars [][2]string
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		value, err := unquote(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		vars = append(vars, [2]string{key, value})
+	}
+	return vars, sc.Err()
+}
+
+func unquote(v string) (string, error) {
+	if v == "" {
+		return v, nil
+	}
+	switch q := v[0]; q {
+	case '\'', '"':
+		end := strings.LastIndexByte(v, q)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated %c quote", q)
+		}
+		if rest := strings.TrimSpace(v[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after quoted value", rest)
+		}
+		v = v[1:end]
+		if q == '"' {
+			v = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(v)
+		}
+		return v, nil
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
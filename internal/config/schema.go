@@ -0,0 +1,272 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exclusive lists the options of which a configuration sets at most one,
+// by the path of the mapping holding them. In a path, [] stands for any
+// element of a list.
+var exclusive = []struct {
+	path string
+	keys []string
+}{
+	{"listeners[].tls", []string{"cert_file", "cert"}},
+	{"listeners[].tls", []string{"key_file", "key"}},
+	{"listeners[].tls", []string{"client_ca_file", "client_ca"}},
+	{"backend_auth", []string{"token", "oauth"}},
+	{"backend_auth.tls", []string{"cert_file", "cert"}},
+	{"backend_auth.tls", []string{"key_file", "key"}},
+	{"features", []string{"file", "redis"}},
+	{"routes[]", []string{"method", "methods"}},
+	{"routes[]", []string{"rpc", "methods"}},
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// durationPattern matches the durations of time.ParseDuration, e.g. 1m30s.
+const durationPattern = `^[-+]?(0|([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|ms|s|m|h))+$`
+
+// Schema returns the JSON Schema of the configuration file, for editors and
+// CI to check configuration files with.
+func Schema() map[string]any {
+	s := schemaOf(reflect.TypeOf(Config{}), "")
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "gateway configuration"
+	return s
+}
+
+func schemaOf(t reflect.Type, path string) map[string]any {
+	if t == durationType {
+		// yaml.v3 also reads integers as nanoseconds
+		return map[string]any{"type": []string{"string", "integer"}, "pattern": durationPattern}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), path)
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), path+"[]")}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), join(path, "*"))}
+	case reflect.Struct:
+		props := map[string]any{}
+		for _, f := range fields(t) {
+			props[f.name] = schemaOf(f.typ, join(path, f.name))
+		}
+		s := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+		var rules []any
+		for _, e := range exclusive {
+			if e.path == path {
+				rules = append(rules, map[string]any{"not": map[string]any{"required": e.keys}})
+			}
+		}
+		if len(rules) > 0 {
+			s["allOf"] = rules
+		}
+		return s
+	}
+	return map[string]any{}
+}
+
+// Validate checks the configuration file data against the schema: unknown
+// keys, values of the wrong type and exclusive options set together. It
+// reports every problem found, each with its line and path.
+func Validate(data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	var v validator
+	v.check(doc.Content[0], reflect.TypeOf(Config{}), "", "")
+	return errors.Join(v.errs...)
+}
+
+// field is a key of a configuration mapping.
+type field struct {
+	name string
+	typ  reflect.Type
+}
+
+// fields returns the keys of struct t as yaml.v3 reads them.
+func fields(t reflect.Type) []field {
+	var out []field
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if slices.Contains(strings.Split(opts, ","), "inline") {
+			out = append(out, fields(f.Type)...)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		out = append(out, field{name, f.Type})
+	}
+	return out
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) errorf(n *yaml.Node, path, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if path != "" {
+		msg = path + ": " + msg
+	}
+	v.errs = append(v.errs, fmt.Errorf("line %d: %s", n.Line, msg))
+}
+
+// check checks node n against type t. path names n in messages, e.g.
+// listeners[0].tls; pattern is the path of exclusive, e.g. listeners[].tls.
+func (v *validator) check(n *yaml.Node, t reflect.Type, path, pattern string) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			v.errorf(n, path, "want a mapping, not %s", describe(n))
+			return
+		}
+		known := map[string]reflect.Type{}
+		for _, f := range fields(t) {
+			known[f.name] = f.typ
+		}
+		set := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if key.Tag == "!!merge" {
+				v.checkMerge(value, t, path, pattern)
+				continue
+			}
+			ft, ok := known[key.Value]
+			if !ok {
+				v.errorf(key, join(path, key.Value), "unknown key")
+				continue
+			}
+			if value.Tag != "!!null" {
+				set[key.Value] = key
+			}
+			v.check(value, ft, join(path, key.Value), join(pattern, key.Value))
+		}
+		for _, e := range exclusive {
+			if e.path != pattern {
+				continue
+			}
+			var keys []string
+			var last *yaml.Node
+			for _, k := range e.keys {
+				if node, ok := set[k]; ok {
+					keys = append(keys, k)
+					if last == nil || node.Line > last.Line {
+						last = node
+					}
+				}
+			}
+			if len(keys) > 1 {
+				v.errorf(last, path, "%s are exclusive", strings.Join(keys, " and "))
+			}
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			v.errorf(n, path, "want a list, not %s", describe(n))
+			return
+		}
+		for i, item := range n.Content {
+			v.check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), pattern+"[]")
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			v.errorf(n, path, "want a mapping, not %s", describe(n))
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			v.check(value, t.Elem(), join(path, key.Value), join(pattern, "*"))
+		}
+	default:
+		if n.Kind != yaml.ScalarNode || n.Decode(reflect.New(t).Interface()) != nil {
+			v.errorf(n, path, "want %s, not %s", kind(t), describe(n))
+		}
+	}
+}
+
+// checkMerge checks the mappings merged into a mapping with <<.
+func (v *validator) checkMerge(n *yaml.Node, t reflect.Type, path, pattern string) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Kind != yaml.SequenceNode {
+		v.check(n, t, path, pattern)
+		return
+	}
+	for _, item := range n.Content {
+		v.check(item, t, path, pattern)
+	}
+}
+
+func kind(t reflect.Type) string {
+	if t == durationType {
+		return "a duration, e.g. 30s"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "a " + t.Kind().String()
+}
+
+func describe(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", n.Value)
+}
@@ -0,0 +1,88 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidate tests that unknown keys, type errors and exclusive options are reported with their line and path
+func TestValidate(t *testing.T) {
+	assert.NoError(t, config.Validate(nil))
+	assert.NoError(t, config.Validate([]byte(`
+listeners:
+  - address: ":8443"
+    tls: &tls
+      cert_file: cert.pem
+      key_file: key.pem
+  - address: ":9443"
+    tls: *tls
+quotas:
+  daily: 1000
+  clients:
+    partner: {monthly: 50000}
+startup:
+  backend_timeout: 1m30s
+features:
+  redis: {addr: "redis:6379"}
+`)))
+
+	err := config.Validate([]byte(`
+http_addr: ":8080"
+listeners:
+  - address: ":8443"
+    tls:
+      cert_file: cert.pem
+      cert: "-----BEGIN CERTIFICATE-----"
+      keyfile: key.pem
+backends:
+  inventory:
+    adress: inventory:50051
+quotas:
+  clients:
+    partner: {daily: lots}
+startup:
+  backend_timeout: soon
+  allow_unreachable_backends: [true]
+features: on
+`))
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		"line 8: listeners[0].tls.keyfile: unknown key",
+		"line 7: listeners[0].tls: cert_file and cert are exclusive",
+		"line 11: backends.inventory.adress: unknown key",
+		"line 14: quotas.clients.partner.daily: want an integer, not \"lots\"",
+		"line 16: startup.backend_timeout: want a duration, e.g. 30s, not \"soon\"",
+		"line 17: startup.allow_unreachable_backends: want true or false, not a list",
+		"line 18: features: want a mapping, not \"on\"",
+	}, strings.Split(err.Error(), "\n"))
+
+	assert.ErrorContains(t, config.Validate([]byte("http_addr: [")), "yaml")
+}
+
+// TestSchema tests that the schema describes the keys, their types and the exclusive options
+func TestSchema(t *testing.T) {
+	s := config.Schema()
+	assert.Equal(t, "https://json-schema.org/draft/2020-12/schema", s["$schema"])
+	assert.Equal(t, false, s["additionalProperties"])
+
+	props := s["properties"].(map[string]any)
+	assert.NotContains(t, props["secrets"].(map[string]any)["properties"], "refs")
+	quotas := props["quotas"].(map[string]any)["properties"].(map[string]any)
+	assert.Contains(t, quotas, "daily", "inline fields are keys of the mapping")
+	assert.Contains(t, quotas, "clients")
+
+	startup := props["startup"].(map[string]any)["properties"].(map[string]any)
+	assert.Equal(t, []string{"string", "integer"}, startup["backend_timeout"].(map[string]any)["type"])
+	assert.Equal(t, map[string]any{"type": "boolean"}, startup["allow_unreachable_backends"])
+
+	backends := props["backends"].(map[string]any)
+	assert.Equal(t, "object", backends["type"])
+	assert.Contains(t, backends["additionalProperties"].(map[string]any)["properties"], "address")
+
+	tls := props["listeners"].(map[string]any)["items"].(map[string]any)["properties"].(map[string]any)["tls"].(map[string]any)
+	assert.Contains(t, tls["allOf"], map[string]any{"not": map[string]any{"required": []string{"cert_file", "cert"}}})
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/andro-kes/gateway/internal/secrets"
+)
+
+// secretField is a configuration value that may reference a secret.
+type secretField struct {
+	name  string
+	value *string
+}
+
+// secretFields lists the values that may reference a secret, named by
+// their YAML path.
+func (c *Config) secretFields() []secretField {
+	fields := []secretField{
+		{"auth.jwt.hmac_secret", &c.Auth.JWT.HMACSecret},
+		{"admin.token", &c.Admin.Token},
+		{"pagination.secret", &c.Pagination.Secret},
+		{"jobs.redis.password", &c.Jobs.Redis.Password},
+		{"quotas.redis.password", &c.Quotas.Redis.Password},
+		{"auth.refresh_rotation.redis.password", &c.Auth.RefreshRotation.Redis.Password},
+		{"features.redis.password", &c.Features.Redis.Password},
+		{"auth.signatures.redis.password", &c.Auth.Signatures.Redis.Password},
+		{"auth.mfa.challenge_secret", &c.Auth.MFA.ChallengeSecret},
+		{"backend_auth.token", &c.BackendAuth.Token},
+		{"backend_auth.oauth.client_secret", &c.BackendAuth.OAuth.ClientSecret},
+		{"backend_auth.tls.cert", &c.BackendAuth.TLS.Cert},
+		{"backend_auth.tls.key", &c.BackendAuth.TLS.Key},
+	}
+	for i := range c.Auth.Cookies.EncryptionKeys {
+		fields = append(fields, secretField{fmt.Sprintf("auth.cookies.encryption_keys[%d]", i), &c.Auth.Cookies.EncryptionKeys[i]})
+	}
+	for i := range c.Auth.Signatures.Keys {
+		fields = append(fields, secretField{fmt.Sprintf("auth.signatures.keys[%d].secret", i), &c.Auth.Signatures.Keys[i].Secret})
+	}
+	for i := range c.Maintenance.AllowTokens {
+		fields = append(fields, secretField{fmt.Sprintf("maintenance.allow_tokens[%d]", i), &c.Maintenance.AllowTokens[i]})
+	}
+	for i := range c.Webhooks.Endpoints {
+		fields = append(fields, secretField{fmt.Sprintf("webhooks.endpoints[%d].secret", i), &c.Webhooks.Endpoints[i].Secret})
+	}
+	for i := range c.Listeners {
+		tls := &c.Listeners[i].TLS
+		fields = append(fields,
+			secretField{fmt.Sprintf("listeners[%d].tls.cert", i), &tls.Cert},
+			secretField{fmt.Sprintf("listeners[%d].tls.key", i), &tls.Key},
+		)
+	}
+	return fields
+}
+
+// resolveSecrets replaces the secret references of c with the secrets and
+// records the references in c.Secrets.Refs.
+func (c *Config) resolveSecrets(ctx context.Context) error {
+	var resolver *secrets.Resolver
+	for _, f := range c.secretFields() {
+		if !secrets.IsRef(*f.value) {
+			continue
+		}
+		if resolver == nil {
+			resolver = secrets.NewResolver(c.Secrets)
+			c.Secrets.Refs = map[string]string{}
+		}
+		ref := *f.value
+		value, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.value = value
+		c.Secrets.Refs[f.name] = ref
+	}
+	return nil
+}
+
+// SecretValue returns the value of the secret field name, as listed in
+// Secrets.Refs.
+func (c *Config) SecretValue(name string) string {
+	for _, f := range c.secretFields() {
+		if f.name == name {
+			return *f.value
+		}
+	}
+	return ""
+}
+
+// redacted replaces the secrets in config dumps.
+const redacted = "<redacted>"
+
+// Redacted returns a copy of c with every secret value replaced, for
+// printing. Secrets read from a store show their reference instead.
+func (c Config) Redacted() Config {
+	c.Auth.Cookies.EncryptionKeys = slices.Clone(c.Auth.Cookies.EncryptionKeys)
+	c.Maintenance.AllowTokens = slices.Clone(c.Maintenance.AllowTokens)
+	c.Webhooks.Endpoints = slices.Clone(c.Webhooks.Endpoints)
+	c.Listeners = slices.Clone(c.Listeners)
+	for _, f := range c.secretFields() {
+		if ref, ok := c.Secrets.Refs[f.name]; ok {
+			*f.value = ref
+		} else if *f.value != "" {
+			*f.value = redacted
+		}
+	}
+	if c.Secrets.Vault.Token != "" {
+		c.Secrets.Vault.Token = redacted
+	}
+	if len(c.Metrics.OTLP.Headers) > 0 {
+		headers := make(map[string]string, len(c.Metrics.OTLP.Headers))
+		for name := range c.Metrics.OTLP.Headers {
+			headers[name] = redacted
+		}
+		c.Metrics.OTLP.Headers = headers
+	}
+	return c
+}
@@ -0,0 +1,78 @@
+// Package contenttype rejects request bodies the handlers cannot parse, so
+// they can rely on the declared body format.
+package contenttype
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// JSON is the media type every handler accepts.
+const JSON = "application/json"
+
+// Config configures the accepted request media types.
+type Config struct {
+	// Allow adds media types to the default application/json, for routes
+	// that learn to parse other formats.
+	Allow []string `yaml:"allow"`
+}
+
+// Checker enforces the request Content-Type of mutating requests.
+type Checker struct {
+	mu      sync.RWMutex
+	allowed map[string]bool
+}
+
+// New returns a Checker that accepts application/json and cfg.Allow.
+func New(cfg Config) *Checker {
+	c := &Checker{allowed: map[string]bool{}}
+	c.Allow(JSON)
+	for _, t := range cfg.Allow {
+		c.Allow(t)
+	}
+	return c
+}
+
+// Allow adds a media type such as "application/x-protobuf" to the allowlist.
+func (c *Checker) Allow(mediaType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.allowed[strings.ToLower(strings.TrimSpace(mediaType))] = true
+}
+
+// Allowed reports whether a Content-Type header value is acceptable. Media
+// type parameters are ignored, except that JSON must be UTF-8 (RFC 8259).
+func (c *Checker) Allowed(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	c.mu.RLock()
+	ok := c.allowed[mediaType]
+	c.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	if charset, set := params["charset"]; set && mediaType == JSON {
+		return strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "utf8")
+	}
+	return true
+}
+
+// Middleware answers 415 to POST, PUT, PATCH and DELETE requests that carry
+// a body with a missing or unsupported Content-Type. Requests without a body
+// pass, e.g. a refresh relying on the refresh_token cookie.
+func (c *Checker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if r.ContentLength != 0 && !c.Allowed(r.Header.Get("Content-Type")) {
+				http.Error(w, "unsupported content type, expected "+JSON, http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,64 @@
+package contenttype_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/contenttype"
+	"github.com/stretchr/testify/assert"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func serve(c *contenttype.Checker, method, contentType, body string) int {
+	req := httptest.NewRequest(method, "/inventory/create", strings.NewReader(body))
+	if body == "" {
+		req = httptest.NewRequest(method, "/inventory/create", nil)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	c.Middleware(ok).ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// TestMiddleware tests which requests pass the Content-Type check
+func TestMiddleware(t *testing.T) {
+	c := contenttype.New(contenttype.Config{})
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"json", http.MethodPost, "application/json", `{}`, http.StatusOK},
+		{"charset", http.MethodPut, "application/json; charset=UTF-8", `{}`, http.StatusOK},
+		{"case insensitive", http.MethodPatch, "Application/JSON", `{}`, http.StatusOK},
+		{"other charset", http.MethodPost, "application/json; charset=latin1", `{}`, http.StatusUnsupportedMediaType},
+		{"form", http.MethodPost, "application/x-www-form-urlencoded", `a=b`, http.StatusUnsupportedMediaType},
+		{"missing", http.MethodDelete, "", `{}`, http.StatusUnsupportedMediaType},
+		{"malformed", http.MethodPost, "application/", `{}`, http.StatusUnsupportedMediaType},
+		{"no body", http.MethodPost, "", "", http.StatusOK},
+		{"get", http.MethodGet, "text/plain", `ignored`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, serve(c, tt.method, tt.contentType, tt.body))
+		})
+	}
+}
+
+// TestChecker_Allow tests extending the allowlist
+func TestChecker_Allow(t *testing.T) {
+	c := contenttype.New(contenttype.Config{Allow: []string{"application/x-protobuf"}})
+	assert.Equal(t, http.StatusOK, serve(c, http.MethodPost, "application/x-protobuf", "\x0a\x02p1"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(c, http.MethodPost, "application/msgpack", "\x80"))
+
+	c.Allow("application/msgpack")
+	assert.Equal(t, http.StatusOK, serve(c, http.MethodPost, "application/msgpack", "\x80"))
+}
@@ -0,0 +1,276 @@
+// Package cookie sets and reads the access and refresh token cookies with
+// the configured names and attributes.
+package cookie
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/clientinfo"
+)
+
+// Name prefixes that browsers enforce: a __Secure- cookie must be Secure,
+// a __Host- cookie must also have no Domain and the path "/".
+const (
+	HostPrefix   = "__Host-"
+	SecurePrefix = "__Secure-"
+)
+
+// Secure modes.
+const (
+	// Auto marks cookies Secure when the client used https, as reported by
+	// a trusted proxy's X-Forwarded-Proto or seen on the connection.
+	Auto = "auto"
+	// Always marks every cookie Secure.
+	Always = "always"
+	// Never marks no cookie Secure.
+	Never = "never"
+)
+
+// Config configures the access and refresh token cookies.
+type Config struct {
+	// AccessName is the name of the access token cookie, before the prefix.
+	// Default: access_token. Env: COOKIE_ACCESS_NAME.
+	AccessName string `yaml:"access_name"`
+
+	// RefreshName is the name of the refresh token cookie, before the
+	// prefix. Default: refresh_token. Env: COOKIE_REFRESH_NAME.
+	RefreshName string `yaml:"refresh_name"`
+
+	// RememberName is the name of the cookie recording whether the login
+	// asked to be remembered, before the prefix. Default: remember_me. Env:
+	// COOKIE_REMEMBER_NAME.
+	RememberName string `yaml:"remember_name"`
+
+	// Domain shares the cookies with subdomains, e.g. "example.com". Empty
+	// keeps them on the gateway's host. Env: COOKIE_DOMAIN.
+	Domain string `yaml:"domain"`
+
+	// Path limits the cookies to a path. Default: "/". Env: COOKIE_PATH.
+	Path string `yaml:"path"`
+
+	// SameSite is "lax", "strict" or "none". Default: lax. Env:
+	// COOKIE_SAMESITE.
+	SameSite string `yaml:"same_site"`
+
+	// Secure is "auto", "always" or "never". Default: auto. Env:
+	// COOKIE_SECURE.
+	Secure string `yaml:"secure"`
+
+	// Prefix is "__Host-", "__Secure-" or empty, and is prepended to both
+	// names. Either prefix implies Secure always. Env: COOKIE_PREFIX.
+	Prefix string `yaml:"prefix"`
+
+	// EncryptionKeys encrypt the cookie values with AES-256-GCM when set,
+	// so that browsers only store opaque values. Each key is a secret of at
+	// least 32 bytes. The first one encrypts and all of them decrypt: put a
+	// new key first to rotate, and remove the old one to invalidate every
+	// cookie sealed with it. Env: COOKIE_ENCRYPTION_KEYS, comma-separated.
+	EncryptionKeys []string `yaml:"encryption_keys"`
+}
+
+// Jar writes and reads the token cookies.
+type Jar struct {
+	access   string
+	refresh  string
+	remember string
+	domain   string
+	path     string
+	sameSite http.SameSite
+	secure   string
+	sealer   *sealer
+}
+
+var defaultJar, _ = New(Config{})
+
+// New validates cfg against the rules browsers apply to prefixed and
+// SameSite=None cookies, and checks the length of the encryption keys.
+func New(cfg Config) (*Jar, error) {
+	if cfg.AccessName == "" {
+		cfg.AccessName = "access_token"
+	}
+	if cfg.RefreshName == "" {
+		cfg.RefreshName = "refresh_token"
+	}
+	if cfg.RememberName == "" {
+		cfg.RememberName = "remember_me"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.Secure == "" {
+		cfg.Secure = Auto
+	}
+	j := &Jar{
+		access:   cfg.Prefix + cfg.AccessName,
+		refresh:  cfg.Prefix + cfg.RefreshName,
+		remember: cfg.Prefix + cfg.RememberName,
+		domain:   cfg.Domain,
+		path:     cfg.Path,
+		secure:   cfg.Secure,
+	}
+
+	var err error
+	if j.sealer, err = newSealer(cfg.EncryptionKeys); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Secure {
+	case Auto, Always, Never:
+	default:
+		return nil, fmt.Errorf("unknown cookie secure mode %q", cfg.Secure)
+	}
+	switch cfg.Prefix {
+	case "":
+	case HostPrefix:
+		if cfg.Domain != "" || cfg.Path != "/" {
+			return nil, fmt.Errorf("%s cookies cannot have a domain or a path other than /", HostPrefix)
+		}
+		fallthrough
+	case SecurePrefix:
+		if cfg.Secure == Never {
+			return nil, fmt.Errorf("%s cookies must be secure", cfg.Prefix)
+		}
+		j.secure = Always
+	default:
+		return nil, fmt.Errorf("unknown cookie prefix %q", cfg.Prefix)
+	}
+	switch strings.ToLower(cfg.SameSite) {
+	case "", "lax":
+		j.sameSite = http.SameSiteLaxMode
+	case "strict":
+		j.sameSite = http.SameSiteStrictMode
+	case "none":
+		if cfg.Secure == Never {
+			return nil, fmt.Errorf("SameSite=None cookies must be secure")
+		}
+		j.sameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("unknown cookie SameSite mode %q", cfg.SameSite)
+	}
+	return j, nil
+}
+
+// get returns j, or the default jar when j is nil.
+func (j *Jar) get() *Jar {
+	if j == nil {
+		return defaultJar
+	}
+	return j
+}
+
+// AccessName returns the name of the access token cookie, with its prefix.
+func (j *Jar) AccessName() string {
+	return j.get().access
+}
+
+// RefreshName returns the name of the refresh token cookie, with its prefix.
+func (j *Jar) RefreshName() string {
+	return j.get().refresh
+}
+
+// Access returns the access token cookie of r, or "" when it is missing or
+// cannot be decrypted.
+func (j *Jar) Access(r *http.Request) string {
+	j = j.get()
+	return j.value(r, j.access)
+}
+
+// Refresh returns the refresh token cookie of r, or "" when it is missing or
+// cannot be decrypted.
+func (j *Jar) Refresh(r *http.Request) string {
+	j = j.get()
+	return j.value(r, j.refresh)
+}
+
+// SetAccess sets the access token cookie, expiring at expires.
+func (j *Jar) SetAccess(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	j = j.get()
+	c := j.cookie(r, j.access, token)
+	c.Expires = expires
+	http.SetCookie(w, c)
+}
+
+// SetRefresh sets the refresh token cookie. A zero expires makes it a
+// session cookie.
+func (j *Jar) SetRefresh(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	j = j.get()
+	c := j.cookie(r, j.refresh, token)
+	c.Expires = expires
+	http.SetCookie(w, c)
+}
+
+// Remember returns whether the login of r asked to be remembered, and false
+// for ok when it did not say.
+func (j *Jar) Remember(r *http.Request) (remember, ok bool) {
+	j = j.get()
+	switch j.value(r, j.remember) {
+	case "1":
+		return true, true
+	case "0":
+		return false, true
+	}
+	return false, false
+}
+
+// SetRemember records whether the login asked to be remembered, for as long
+// as the refresh token cookie lives.
+func (j *Jar) SetRemember(w http.ResponseWriter, r *http.Request, remember bool, expires time.Time) {
+	j = j.get()
+	value := "0"
+	if remember {
+		value = "1"
+	}
+	c := j.cookie(r, j.remember, value)
+	c.Expires = expires
+	http.SetCookie(w, c)
+}
+
+// Clear expires the token cookies.
+func (j *Jar) Clear(w http.ResponseWriter, r *http.Request) {
+	j = j.get()
+	for _, name := range []string{j.access, j.refresh, j.remember} {
+		c := j.cookie(r, name, "")
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+func (j *Jar) cookie(r *http.Request, name, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    j.sealer.seal(name, value),
+		Domain:   j.domain,
+		Path:     j.path,
+		HttpOnly: true,
+		SameSite: j.sameSite,
+		Secure:   j.isSecure(r),
+	}
+}
+
+func (j *Jar) isSecure(r *http.Request) bool {
+	switch j.secure {
+	case Always:
+		return true
+	case Never:
+		return false
+	}
+	if info, ok := clientinfo.FromContext(r.Context()); ok {
+		return info.Proto == "https"
+	}
+	return r.TLS != nil
+}
+
+func (j *Jar) value(r *http.Request, name string) string {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	v, err := j.sealer.open(name, c.Value)
+	if err != nil {
+		return ""
+	}
+	return v
+}
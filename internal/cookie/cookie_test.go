@@ -0,0 +1,131 @@
+package cookie_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/clientinfo"
+	"github.com/andro-kes/gateway/internal/cookie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNew tests the validation of prefixes, SameSite and Secure modes
+func TestNew(t *testing.T) {
+	for _, cfg := range []cookie.Config{
+		{Secure: "sometimes"},
+		{SameSite: "loose"},
+		{Prefix: "__Cookie-"},
+		{Prefix: cookie.HostPrefix, Domain: "example.com"},
+		{Prefix: cookie.HostPrefix, Path: "/auth"},
+		{Prefix: cookie.SecurePrefix, Secure: cookie.Never},
+		{SameSite: "none", Secure: cookie.Never},
+	} {
+		_, err := cookie.New(cfg)
+		assert.Error(t, err, "%+v", cfg)
+	}
+
+	j, err := cookie.New(cookie.Config{Prefix: cookie.HostPrefix, AccessName: "at"})
+	require.NoError(t, err)
+	assert.Equal(t, "__Host-at", j.AccessName())
+	assert.Equal(t, "__Host-refresh_token", j.RefreshName())
+
+	var nilJar *cookie.Jar
+	assert.Equal(t, "access_token", nilJar.AccessName())
+}
+
+// TestJar_Set tests the attributes of the cookies written
+func TestJar_Set(t *testing.T) {
+	j, err := cookie.New(cookie.Config{Domain: "example.com", Path: "/app", SameSite: "None"})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	r = r.WithContext(clientinfo.WithInfo(r.Context(), clientinfo.Info{Proto: "https"}))
+	j.SetAccess(w, r, "a1", time.Now().Add(time.Minute))
+	j.SetRefresh(w, r, "r1", time.Time{})
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 2)
+	for _, c := range cookies {
+		assert.Equal(t, "example.com", c.Domain)
+		assert.Equal(t, "/app", c.Path)
+		assert.Equal(t, http.SameSiteNoneMode, c.SameSite)
+		assert.True(t, c.Secure, "forwarded proto is https")
+		assert.True(t, c.HttpOnly)
+	}
+	assert.False(t, cookies[0].Expires.IsZero())
+	assert.True(t, cookies[1].Expires.IsZero())
+
+	r.AddCookie(cookies[1])
+	assert.Equal(t, "r1", j.Refresh(r))
+	assert.Empty(t, j.Access(r))
+
+	w = httptest.NewRecorder()
+	j.Clear(w, httptest.NewRequest(http.MethodPost, "/auth/refresh", nil))
+	for _, c := range w.Result().Cookies() {
+		assert.Equal(t, -1, c.MaxAge)
+		assert.False(t, c.Secure, "plain http")
+	}
+}
+
+// TestJar_Secure tests the Secure modes
+func TestJar_Secure(t *testing.T) {
+	secure := func(cfg cookie.Config, r *http.Request) bool {
+		j, err := cookie.New(cfg)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		j.SetAccess(w, r, "a1", time.Time{})
+		return w.Result().Cookies()[0].Secure
+	}
+	plain := httptest.NewRequest(http.MethodGet, "/", nil)
+	tlsReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	tlsReq.TLS = &tls.ConnectionState{}
+
+	assert.False(t, secure(cookie.Config{}, plain))
+	assert.True(t, secure(cookie.Config{}, tlsReq))
+	assert.True(t, secure(cookie.Config{Secure: cookie.Always}, plain))
+	assert.False(t, secure(cookie.Config{Secure: cookie.Never}, tlsReq))
+	assert.True(t, secure(cookie.Config{Prefix: cookie.SecurePrefix}, plain))
+}
+
+// TestJar_Encryption tests that values are encrypted, decrypted with any key and dropped once their key is removed
+func TestJar_Encryption(t *testing.T) {
+	oldKey := strings.Repeat("o", 32)
+	newKey := strings.Repeat("n", 32)
+	_, err := cookie.New(cookie.Config{EncryptionKeys: []string{"short"}})
+	assert.Error(t, err)
+
+	seal := func(keys ...string) (*cookie.Jar, *http.Cookie) {
+		j, err := cookie.New(cookie.Config{EncryptionKeys: keys})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		j.SetRefresh(w, httptest.NewRequest(http.MethodGet, "/", nil), "refresh-1", time.Time{})
+		return j, w.Result().Cookies()[0]
+	}
+	read := func(j *cookie.Jar, c *http.Cookie) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(c)
+		return j.Refresh(r)
+	}
+
+	oldJar, sealed := seal(oldKey)
+	assert.NotContains(t, sealed.Value, "refresh-1")
+	assert.Equal(t, "refresh-1", read(oldJar, sealed))
+
+	rotated, _ := seal(newKey, oldKey)
+	assert.Equal(t, "refresh-1", read(rotated, sealed))
+
+	newJar, _ := seal(newKey)
+	assert.Empty(t, read(newJar, sealed))
+	assert.Empty(t, read(newJar, &http.Cookie{Name: "refresh_token", Value: "refresh-1"}))
+
+	// a sealed refresh token is not accepted as the access token
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "access_token", Value: sealed.Value})
+	assert.Empty(t, oldJar.Access(r))
+}
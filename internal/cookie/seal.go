@@ -0,0 +1,82 @@
+package cookie
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// minKeyLen is the minimum length of an encryption key.
+const minKeyLen = 32
+
+// sealer encrypts cookie values with AES-256-GCM. The first key encrypts;
+// every key is tried to decrypt, so that a new key can be put first while
+// the cookies sealed with the old one stay readable until it is removed.
+type sealer struct {
+	aeads []cipher.AEAD
+}
+
+func newSealer(keys []string) (*sealer, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	s := &sealer{}
+	for i, key := range keys {
+		if len(key) < minKeyLen {
+			return nil, fmt.Errorf("cookie encryption key %d is shorter than %d bytes", i, minKeyLen)
+		}
+		sum := sha256.Sum256([]byte(key))
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	return s, nil
+}
+
+// seal encrypts value with the first key. A nil sealer returns value.
+func (s *sealer) seal(name, value string) string {
+	if s == nil || value == "" {
+		return value
+	}
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	// the name is authenticated so that one cookie cannot be passed as the other
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), []byte(name)))
+}
+
+var errUnsealed = errors.New("cookie: value not encrypted with a known key")
+
+// open decrypts a sealed value with any of the keys. A nil sealer returns
+// value.
+func (s *sealer) open(name, value string) (string, error) {
+	if s == nil || value == "" {
+		return value, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", errUnsealed
+	}
+	for _, aead := range s.aeads {
+		if len(data) < aead.NonceSize() {
+			break
+		}
+		nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", errUnsealed
+}
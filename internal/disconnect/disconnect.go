@@ -0,0 +1,76 @@
+// Package disconnect handles clients that go away before their response is
+// complete. The request context, which every backend call is made with, is
+// canceled when the client disconnects; the calls in flight are abandoned,
+// the response is dropped instead of written to a closed connection, and the
+// request is recorded as client_closed rather than as a gateway error.
+package disconnect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// StatusClientClosed is the status recorded for requests whose client went
+// away, after nginx's 499 Client Closed Request. It is never sent.
+const StatusClientClosed = 499
+
+var closedTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "http",
+	Name:      "client_closed_total",
+	Help:      "Requests whose client disconnected before the response was complete.",
+}, []string{"method"})
+
+// Closed reports whether the client of r went away. Backend deadlines are
+// not disconnects: they end the context with DeadlineExceeded.
+func Closed(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// Middleware stops writing responses once their client went away and counts
+// the requests it happened to.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&writer{ResponseWriter: w, r: r}, r)
+		if Closed(r) {
+			closedTotal.WithLabelValues(r.Method).Inc()
+		}
+	})
+}
+
+// writer drops the status and body written after the client went away, such
+// as the 500 of a backend call canceled with the request.
+type writer struct {
+	http.ResponseWriter
+	r *http.Request
+}
+
+func (w *writer) WriteHeader(code int) {
+	if Closed(w.r) {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if Closed(w.r) {
+		return 0, w.r.Context().Err()
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *writer) Flush() {
+	if Closed(w.r) {
+		return
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
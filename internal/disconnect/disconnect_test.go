@@ -0,0 +1,82 @@
+package disconnect_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/disconnect"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMiddleware tests that a disconnect cancels the backend call and that the 500 written after it is dropped and counted as client_closed
+func TestMiddleware(t *testing.T) {
+	backendErr := make(chan error, 1)
+	writeErr := make(chan error, 1)
+	started := make(chan struct{})
+	srv := httptest.NewServer(disconnect.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		// a backend call made with the request context
+		<-r.Context().Done()
+		backendErr <- r.Context().Err()
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("failed to get product"))
+		writeErr <- err
+	})))
+	defer srv.Close()
+
+	before := closed(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/inventory/42", nil)
+	require.NoError(t, err)
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err = http.DefaultClient.Do(req)
+	require.ErrorIs(t, err, context.Canceled)
+
+	select {
+	case err := <-backendErr:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend call was not canceled")
+	}
+	assert.ErrorIs(t, <-writeErr, context.Canceled)
+	assert.Eventually(t, func() bool {
+		return closed(t) == before+1
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestClosed tests that only canceled requests count as closed by the client, not expired deadlines
+func TestClosed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(t, disconnect.Closed(httptest.NewRequest("GET", "/", nil).WithContext(ctx)))
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	assert.False(t, disconnect.Closed(httptest.NewRequest("GET", "/", nil).WithContext(ctx)))
+	assert.False(t, disconnect.Closed(httptest.NewRequest("GET", "/", nil)))
+}
+
+// closed returns gateway_http_client_closed_total for GET requests.
+func closed(t *testing.T) float64 {
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != "gateway_http_client_closed_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if m.GetLabel()[0].GetValue() == http.MethodGet {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,301 @@
+// Package discovery serves the OpenID Connect discovery document and the
+// JSON Web Key Set of the access tokens, so that third-party resource
+// servers can validate the tokens the gateway hands out with standard
+// libraries. The documents are either proxied from auth_service or
+// generated from the public key the gateway verifies tokens with.
+package discovery
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/andro-kes/gateway/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Paths of the documents.
+const (
+	ConfigurationPath = "/.well-known/openid-configuration"
+	KeysPath          = "/.well-known/jwks.json"
+)
+
+// Config configures the discovery documents.
+type Config struct {
+	// Enabled serves the documents.
+	Enabled bool `yaml:"enabled"`
+
+	// Upstream is the base URL of the HTTP server of auth_service, e.g.
+	// "http://auth_service:8081". The documents are proxied from it when
+	// set, and generated from auth.jwt.public_key_file otherwise.
+	Upstream string `yaml:"upstream"`
+
+	// Issuer is the issuer of the generated document. Default: auth.jwt.issuer.
+	Issuer string `yaml:"issuer"`
+
+	// PublicURL is the base URL clients reach the gateway at, e.g.
+	// "https://api.example.com". The jwks_uri of the documents points to
+	// it; required unless proxying.
+	PublicURL string `yaml:"public_url"`
+
+	// KeyID is the kid of the generated key. Default: its RFC 7638
+	// thumbprint.
+	KeyID string `yaml:"key_id"`
+
+	// CacheTTL is how long proxied documents are kept, and how long clients
+	// may cache the documents. Default: 5m.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+// fetchTimeout bounds the requests to the upstream.
+const fetchTimeout = 5 * time.Second
+
+// Discovery serves the documents. A nil *Discovery serves none.
+type Discovery struct {
+	upstream  *url.URL
+	publicURL string
+	maxAge    time.Duration
+	client    *http.Client
+
+	// generated documents, by path
+	docs map[string][]byte
+
+	mu     sync.Mutex
+	cached map[string]cached
+}
+
+type cached struct {
+	body    []byte
+	fetched time.Time
+}
+
+// New returns the Discovery of cfg, or nil when disabled. issuer is the iss
+// of the access tokens and key the public key they are verified with; they
+// are only used when the documents are generated.
+func New(cfg Config, issuer string, key crypto.PublicKey) (*Discovery, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = 5 * time.Minute
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = issuer
+	}
+	d := &Discovery{
+		publicURL: strings.TrimSuffix(cfg.PublicURL, "/"),
+		maxAge:    cfg.CacheTTL,
+		client:    &http.Client{Timeout: fetchTimeout},
+		cached:    map[string]cached{},
+	}
+	if cfg.Upstream != "" {
+		u, err := url.Parse(cfg.Upstream)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("discovery upstream %q is not an absolute URL", cfg.Upstream)
+		}
+		d.upstream = u
+		return d, nil
+	}
+
+	switch {
+	case key == nil:
+		return nil, errors.New("discovery needs auth.jwt.public_key_file or an upstream: HMAC secrets cannot be published")
+	case cfg.Issuer == "":
+		return nil, errors.New("discovery needs an issuer, or auth.jwt.issuer")
+	case d.publicURL == "":
+		return nil, errors.New("discovery needs the public_url of the gateway")
+	}
+	jwk, algs, err := newJWK(key, cfg.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := json.Marshal(map[string]any{"keys": []map[string]string{jwk}})
+	if err != nil {
+		return nil, err
+	}
+	conf, err := json.Marshal(map[string]any{
+		"issuer":                                cfg.Issuer,
+		"jwks_uri":                              d.publicURL + KeysPath,
+		"response_types_supported":              []string{"id_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": algs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.docs = map[string][]byte{ConfigurationPath: conf, KeysPath: keys}
+	return d, nil
+}
+
+// Proxied reports whether the documents come from the upstream.
+func (d *Discovery) Proxied() bool {
+	return d != nil && d.upstream != nil
+}
+
+// ConfigurationHandler serves the OpenID Connect discovery document.
+func (d *Discovery) ConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	d.serve(w, r, ConfigurationPath)
+}
+
+// KeysHandler serves the JSON Web Key Set.
+func (d *Discovery) KeysHandler(w http.ResponseWriter, r *http.Request) {
+	d.serve(w, r, KeysPath)
+}
+
+func (d *Discovery) serve(w http.ResponseWriter, r *http.Request, path string) {
+	body := d.docs[path]
+	if d.upstream != nil {
+		var err error
+		if body, err = d.fetch(r.Context(), path); err != nil {
+			message := i18n.Message(w, r, "discovery_unavailable")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]any{
+				"error":   "discovery_unavailable",
+				"message": message,
+			})
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(d.maxAge.Seconds())))
+	w.Write(body)
+}
+
+// fetch returns the upstream document at path, fetching it when the cached
+// one is older than the cache TTL. A cached document is served past its TTL
+// while the upstream fails.
+func (d *Discovery) fetch(ctx context.Context, path string) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.cached[path]
+	if ok && time.Since(c.fetched) < d.maxAge {
+		return c.body, nil
+	}
+	body, err := d.get(ctx, path)
+	if err != nil {
+		logger.Logger().Warn("Failed to fetch discovery document",
+			zap.String("path", path),
+			zap.Bool("serving_stale", ok),
+			zap.Error(err),
+		)
+		if ok {
+			return c.body, nil
+		}
+		return nil, err
+	}
+	d.cached[path] = cached{body: body, fetched: time.Now()}
+	return body, nil
+}
+
+func (d *Discovery) get(ctx context.Context, path string) ([]byte, error) {
+	// the request of the client may go away, the cached document stays
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.upstream.JoinPath(path).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream answered %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if path != ConfigurationPath || d.publicURL == "" {
+		if !json.Valid(body) {
+			return nil, errors.New("upstream answered invalid JSON")
+		}
+		return body, nil
+	}
+
+	// auth_service points to its own key set, which clients may not reach
+	var doc map[string]any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("upstream answered invalid JSON: %w", err)
+	}
+	doc["jwks_uri"] = d.publicURL + KeysPath
+	return json.Marshal(doc)
+}
+
+// newJWK returns the JSON Web Key of key and the token algorithms it
+// verifies.
+func newJWK(key crypto.PublicKey, kid string) (map[string]string, []string, error) {
+	var (
+		jwk  map[string]string
+		algs []string
+	)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk = map[string]string{
+			"kty": "RSA",
+			"n":   encode(k.N.Bytes()),
+			"e":   encode(big.NewInt(int64(k.E)).Bytes()),
+		}
+		algs = []string{"RS256", "RS384", "RS512"}
+	case *ecdsa.PublicKey:
+		params := k.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		jwk = map[string]string{
+			"kty": "EC",
+			"crv": params.Name,
+			"x":   encode(k.X.FillBytes(make([]byte, size))),
+			"y":   encode(k.Y.FillBytes(make([]byte, size))),
+		}
+		switch params.Name {
+		case "P-256":
+			algs = []string{"ES256"}
+		case "P-384":
+			algs = []string{"ES384"}
+		case "P-521":
+			algs = []string{"ES512"}
+		default:
+			return nil, nil, fmt.Errorf("unsupported JWT key curve %s", params.Name)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported JWT public key type %T", key)
+	}
+
+	if kid == "" {
+		// RFC 7638: the required members, in lexicographic order, without
+		// whitespace; json.Marshal sorts map keys
+		members, err := json.Marshal(jwk)
+		if err != nil {
+			return nil, nil, err
+		}
+		sum := sha256.Sum256(members)
+		kid = encode(sum[:])
+	}
+	jwk["kid"] = kid
+	jwk["use"] = "sig"
+	if len(algs) == 1 {
+		jwk["alg"] = algs[0]
+	}
+	return jwk, algs, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
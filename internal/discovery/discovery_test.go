@@ -0,0 +1,155 @@
+package discovery_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/discovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, h http.HandlerFunc) (*httptest.ResponseRecorder, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/", nil))
+	var doc map[string]any
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	}
+	return rec, doc
+}
+
+// TestDiscovery_Generated tests the documents generated from an RSA public key
+func TestDiscovery_Generated(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	d, err := discovery.New(discovery.Config{Enabled: true, PublicURL: "https://api.example.com/"}, "https://auth.example.com", &key.PublicKey)
+	require.NoError(t, err)
+
+	rec, conf := get(t, d.ConfigurationHandler)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "https://auth.example.com", conf["issuer"])
+	assert.Equal(t, "https://api.example.com/.well-known/jwks.json", conf["jwks_uri"])
+	assert.Equal(t, []any{"RS256", "RS384", "RS512"}, conf["id_token_signing_alg_values_supported"])
+
+	rec, set := get(t, d.KeysHandler)
+	require.Equal(t, http.StatusOK, rec.Code)
+	keys := set["keys"].([]any)
+	require.Len(t, keys, 1)
+	jwk := keys[0].(map[string]any)
+	assert.Equal(t, "RSA", jwk["kty"])
+	assert.Equal(t, "sig", jwk["use"])
+	assert.NotEmpty(t, jwk["kid"])
+	n, err := base64.RawURLEncoding.DecodeString(jwk["n"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, 0, new(big.Int).SetBytes(n).Cmp(key.N))
+	assert.Equal(t, "AQAB", jwk["e"])
+}
+
+// TestDiscovery_GeneratedEC tests the key of an ECDSA public key, with a configured kid
+func TestDiscovery_GeneratedEC(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	d, err := discovery.New(discovery.Config{Enabled: true, Issuer: "https://api.example.com", PublicURL: "https://api.example.com", KeyID: "2026-10"}, "", &key.PublicKey)
+	require.NoError(t, err)
+
+	_, conf := get(t, d.ConfigurationHandler)
+	assert.Equal(t, "https://api.example.com", conf["issuer"])
+	assert.Equal(t, []any{"ES384"}, conf["id_token_signing_alg_values_supported"])
+
+	_, set := get(t, d.KeysHandler)
+	jwk := set["keys"].([]any)[0].(map[string]any)
+	assert.Equal(t, "EC", jwk["kty"])
+	assert.Equal(t, "P-384", jwk["crv"])
+	assert.Equal(t, "ES384", jwk["alg"])
+	assert.Equal(t, "2026-10", jwk["kid"])
+	x, err := base64.RawURLEncoding.DecodeString(jwk["x"].(string))
+	require.NoError(t, err)
+	assert.Len(t, x, 48)
+}
+
+// TestDiscovery_Proxied tests that the documents of the upstream are cached, point to the gateway and outlive upstream failures
+func TestDiscovery_Proxied(t *testing.T) {
+	var calls, failing atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case discovery.ConfigurationPath:
+			w.Write([]byte(`{"issuer": "https://auth.example.com", "jwks_uri": "http://auth_service:8081/.well-known/jwks.json"}`))
+		case discovery.KeysPath:
+			w.Write([]byte(`{"keys": [{"kty": "RSA", "kid": "upstream"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	d, err := discovery.New(discovery.Config{Enabled: true, Upstream: upstream.URL, PublicURL: "https://api.example.com", CacheTTL: time.Second}, "", nil)
+	require.NoError(t, err)
+	assert.True(t, d.Proxied())
+
+	rec, conf := get(t, d.ConfigurationHandler)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://auth.example.com", conf["issuer"])
+	assert.Equal(t, "https://api.example.com/.well-known/jwks.json", conf["jwks_uri"])
+	_, set := get(t, d.KeysHandler)
+	assert.Equal(t, "upstream", set["keys"].([]any)[0].(map[string]any)["kid"])
+
+	get(t, d.ConfigurationHandler)
+	assert.Equal(t, int32(2), calls.Load(), "cached documents are not fetched again")
+
+	failing.Store(1)
+	time.Sleep(1100 * time.Millisecond)
+	rec, conf = get(t, d.ConfigurationHandler)
+	assert.Equal(t, http.StatusOK, rec.Code, "stale documents are served while the upstream fails")
+	assert.Equal(t, "https://auth.example.com", conf["issuer"])
+}
+
+// TestDiscovery_Unavailable tests the answer when the upstream fails before any document was fetched
+func TestDiscovery_Unavailable(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	d, err := discovery.New(discovery.Config{Enabled: true, Upstream: upstream.URL}, "", nil)
+	require.NoError(t, err)
+	rec, _ := get(t, d.KeysHandler)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Contains(t, rec.Body.String(), "discovery_unavailable")
+}
+
+// TestNew tests the configurations that cannot be served
+func TestNew(t *testing.T) {
+	d, err := discovery.New(discovery.Config{}, "", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, err = discovery.New(discovery.Config{Enabled: true, PublicURL: "https://api.example.com"}, "https://auth.example.com", nil)
+	assert.ErrorContains(t, err, "HMAC secrets cannot be published")
+	_, err = discovery.New(discovery.Config{Enabled: true, PublicURL: "https://api.example.com"}, "", &key.PublicKey)
+	assert.ErrorContains(t, err, "issuer")
+	_, err = discovery.New(discovery.Config{Enabled: true}, "https://auth.example.com", &key.PublicKey)
+	assert.ErrorContains(t, err, "public_url")
+	_, err = discovery.New(discovery.Config{Enabled: true, Upstream: "auth_service:8081"}, "", nil)
+	assert.Error(t, err)
+}
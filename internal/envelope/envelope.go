@@ -0,0 +1,256 @@
+// Package envelope wraps JSON responses in a uniform envelope for clients
+// that want the same shape from every endpoint:
+//
+//	{"data": ..., "meta": {"request_id": "...", "duration_ms": 12.5, "pagination": {"next": "..."}}}
+//
+// Error responses carry their body under "error" instead of "data". Other
+// responses, such as plain text, XML, files and streams, are sent as is.
+package envelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/requestid"
+)
+
+// DefaultHeader is the request header clients turn the envelope on or off
+// with.
+const DefaultHeader = "X-Envelope"
+
+// Config decides which responses are wrapped.
+type Config struct {
+	// Enabled wraps the responses of routes without a rule.
+	Enabled bool `yaml:"enabled"`
+
+	// Routes turn the envelope on or off under a path prefix. The first rule
+	// whose prefix matches the request path applies.
+	Routes []RouteRule `yaml:"routes"`
+
+	// Header lets clients override the route with "true" or "false".
+	// Default: DefaultHeader.
+	Header string `yaml:"header"`
+
+	// MaxBodyBytes bounds the bodies wrapped; larger ones are sent as is.
+	// Default: 1 MiB.
+	MaxBodyBytes int `yaml:"max_body_bytes"`
+}
+
+// RouteRule turns the envelope on or off under a path prefix.
+type RouteRule struct {
+	// PathPrefix selects the requests, e.g. "/inventory".
+	PathPrefix string `yaml:"path_prefix"`
+
+	Enabled bool `yaml:"enabled"`
+}
+
+// Meta describes the request a response answers.
+type Meta struct {
+	RequestID  string      `json:"request_id,omitempty"`
+	DurationMS float64     `json:"duration_ms"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Pagination holds the next and previous pages of a list, from its Link
+// header.
+type Pagination struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// Wrapper wraps the responses selected by its configuration. A nil Wrapper
+// wraps nothing.
+type Wrapper struct {
+	cfg Config
+}
+
+// New returns the wrapper of cfg, or nil when cfg is empty.
+func New(cfg Config) (*Wrapper, error) {
+	if !cfg.Enabled && len(cfg.Routes) == 0 && cfg.Header == "" {
+		return nil, nil
+	}
+	for _, r := range cfg.Routes {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return nil, fmt.Errorf("envelope path prefix %q must start with /", r.PathPrefix)
+		}
+	}
+	if cfg.MaxBodyBytes < 0 {
+		return nil, fmt.Errorf("envelope max_body_bytes %d must not be negative", cfg.MaxBodyBytes)
+	}
+	if cfg.Header == "" {
+		cfg.Header = DefaultHeader
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	return &Wrapper{cfg: cfg}, nil
+}
+
+// wraps reports whether the response to r is wrapped.
+func (wr *Wrapper) wraps(r *http.Request) bool {
+	if on, err := strconv.ParseBool(r.Header.Get(wr.cfg.Header)); err == nil {
+		return on
+	}
+	for _, rule := range wr.cfg.Routes {
+		if strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			return rule.Enabled
+		}
+	}
+	return wr.cfg.Enabled
+}
+
+// Middleware wraps the JSON responses of the requests it selects. Put it
+// after the request ID middleware and before the response cache, so that
+// cached bodies are stored unwrapped and get fresh metadata.
+func (wr *Wrapper) Middleware(next http.Handler) http.Handler {
+	if wr == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !wr.wraps(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		bw := &writer{ResponseWriter: w, limit: wr.cfg.MaxBodyBytes}
+		next.ServeHTTP(bw, r)
+		if !bw.buffering {
+			return
+		}
+		id, _ := requestid.FromContext(r.Context())
+		meta := Meta{
+			RequestID:  id,
+			DurationMS: math.Round(float64(time.Since(start).Microseconds())) / 1000,
+			Pagination: pagination(w.Header().Values("Link")),
+		}
+		bw.send(meta)
+	})
+}
+
+// writer buffers JSON bodies until the handler returns and passes other
+// responses through.
+type writer struct {
+	http.ResponseWriter
+	limit int
+
+	status    int
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (w *writer) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	if code < 200 {
+		// informational responses are followed by the final one
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	w.buffering = code != http.StatusNoContent && code != http.StatusNotModified && isJSON(w.Header().Get("Content-Type"))
+	if !w.buffering {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.buffering {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.body.Len()+len(b) > w.limit {
+		// too large to wrap: send what there is as is
+		w.passThrough()
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+// Flush sends streamed responses on, but holds back buffered ones.
+func (w *writer) Flush() {
+	if !w.buffering {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// passThrough stops buffering and sends the header and body so far.
+func (w *writer) passThrough() {
+	w.buffering = false
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+	w.body.Reset()
+}
+
+// send writes the buffered body in the envelope, or as is when it is not
+// valid JSON.
+func (w *writer) send(meta Meta) {
+	body := bytes.TrimSpace(w.body.Bytes())
+	if !json.Valid(body) {
+		w.passThrough()
+		return
+	}
+	key := "data"
+	if w.status >= http.StatusBadRequest {
+		key = "error"
+	}
+	wrapped, err := json.Marshal(map[string]any{key: json.RawMessage(body), "meta": meta})
+	if err != nil {
+		w.passThrough()
+		return
+	}
+	wrapped = append(wrapped, '\n')
+	// the ETag is left as is: it identifies the data, which If-Match and
+	// If-None-Match compare against
+	w.Header().Set("Content-Length", strconv.Itoa(len(wrapped)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(wrapped)
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// pagination returns the next and prev links of Link header values, or nil
+// when there are none.
+func pagination(links []string) *Pagination {
+	var p Pagination
+	for _, value := range links {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = target[1 : len(target)-1]
+			for _, param := range strings.Split(params, ";") {
+				switch strings.TrimSpace(param) {
+				case `rel="next"`, "rel=next":
+					p.Next = target
+				case `rel="prev"`, "rel=prev":
+					p.Prev = target
+				}
+			}
+		}
+	}
+	if p == (Pagination{}) {
+		return nil
+	}
+	return &p
+}
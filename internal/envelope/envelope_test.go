@@ -0,0 +1,147 @@
+package envelope_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/envelope"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve sends a request for path with header through wr to h
+func serve(wr *envelope.Wrapper, h http.HandlerFunc, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req = req.WithContext(requestid.WithID(req.Context(), "req-1"))
+	w := httptest.NewRecorder()
+	wr.Middleware(h).ServeHTTP(w, req)
+	return w
+}
+
+func jsonHandler(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "99")
+		w.Header().Set("Link", `</inventory/list?page_token=b>; rel="next", </inventory/list?page_token=a>; rel="prev"`)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+// TestWrapper tests that JSON bodies are wrapped with the request metadata
+func TestWrapper(t *testing.T) {
+	wr, err := envelope.New(envelope.Config{Enabled: true})
+	require.NoError(t, err)
+
+	w := serve(wr, jsonHandler(http.StatusOK, `{"items":[1,2]}`), "/inventory/list", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got struct {
+		Data json.RawMessage `json:"data"`
+		Meta envelope.Meta   `json:"meta"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.JSONEq(t, `{"items":[1,2]}`, string(got.Data))
+	assert.Equal(t, "req-1", got.Meta.RequestID)
+	assert.GreaterOrEqual(t, got.Meta.DurationMS, 0.0)
+	assert.Equal(t, &envelope.Pagination{Next: "/inventory/list?page_token=b", Prev: "/inventory/list?page_token=a"}, got.Meta.Pagination)
+	assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+	assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+
+	w = serve(wr, jsonHandler(http.StatusNotFound, `{"error":"not_found","message":"no route"}`), "/x", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error":{"error":"not_found","message":"no route"}}`, withoutMeta(t, w.Body.Bytes()))
+}
+
+// withoutMeta returns body without its meta member
+func withoutMeta(t *testing.T, body []byte) string {
+	t.Helper()
+	var m map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(body, &m))
+	require.Contains(t, m, "meta")
+	delete(m, "meta")
+	out, err := json.Marshal(m)
+	require.NoError(t, err)
+	return string(out)
+}
+
+// TestWrapper_PassThrough tests that responses other than JSON bodies are sent as is
+func TestWrapper_PassThrough(t *testing.T) {
+	wr, err := envelope.New(envelope.Config{Enabled: true, MaxBodyBytes: 16})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"text", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("pong"))
+		}, "pong"},
+		{"no content", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNoContent)
+		}, ""},
+		{"invalid json", jsonHandler(http.StatusOK, `{"items":`), `{"items":`},
+		{"too large", jsonHandler(http.StatusOK, `{"items":[1,2,3,4,5,6]}`), `{"items":[1,2,3,4,5,6]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(wr, tt.handler, "/x", nil)
+			assert.Equal(t, tt.body, w.Body.String())
+		})
+	}
+}
+
+// TestWrapper_Select tests that route rules and the request header pick the wrapped responses
+func TestWrapper_Select(t *testing.T) {
+	wr, err := envelope.New(envelope.Config{Routes: []envelope.RouteRule{
+		{PathPrefix: "/inventory/export", Enabled: false},
+		{PathPrefix: "/inventory", Enabled: true},
+	}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		path    string
+		header  string
+		wrapped bool
+	}{
+		{"/inventory/list", "", true},
+		{"/inventory/export", "", false},
+		{"/health", "", false},
+		{"/health", "true", true},
+		{"/inventory/list", "0", false},
+		{"/inventory/list", "maybe", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.header, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set(envelope.DefaultHeader, tt.header)
+			}
+			w := serve(wr, jsonHandler(http.StatusOK, `{}`), tt.path, header)
+			assert.Equal(t, tt.wrapped, strings.HasPrefix(w.Body.String(), `{"data":`), w.Body.String())
+		})
+	}
+}
+
+// TestNew tests that empty configurations disable the envelope and bad prefixes are rejected
+func TestNew(t *testing.T) {
+	wr, err := envelope.New(envelope.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, wr)
+	w := serve(wr, jsonHandler(http.StatusOK, `{}`), "/x", http.Header{envelope.DefaultHeader: {"true"}})
+	assert.Equal(t, `{}`, w.Body.String())
+
+	_, err = envelope.New(envelope.Config{Routes: []envelope.RouteRule{{PathPrefix: "inventory", Enabled: true}}})
+	assert.ErrorContains(t, err, "must start with /")
+}
@@ -0,0 +1,316 @@
+// Package events publishes a structured event for every successful mutation
+// that passes through the gateway (product.created, user.login, ...) to Kafka
+// or NATS, and hands it to in-process listeners such as webhooks. Emitting
+// never blocks request handling: events are queued and published in batches
+// by a background goroutine, and dropped and counted when the queue is full.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Event types emitted by the handlers.
+const (
+	ProductCreated = "product.created"
+	ProductDeleted = "product.deleted"
+	UserRegistered = "user.registered"
+	UserLogin      = "user.login"
+
+	PasswordChanged = "user.password_changed"
+	EmailChanged    = "user.email_changed"
+	UserDeleted     = "user.deleted"
+)
+
+// Config configures event publishing. Publishing is disabled when Driver is
+// empty.
+type Config struct {
+	// Driver is "kafka" or "nats".
+	Driver string `yaml:"driver"`
+
+	// Brokers are the Kafka bootstrap addresses.
+	Brokers []string `yaml:"brokers"`
+
+	// URL is the NATS server URL. Default: nats://127.0.0.1:4222.
+	URL string `yaml:"url"`
+
+	// TopicPrefix is prepended to the event type to form the Kafka topic or
+	// NATS subject of types missing from Topics. Default: "gateway.".
+	TopicPrefix string `yaml:"topic_prefix"`
+
+	// Topics maps event types to topics (subjects).
+	Topics map[string]string `yaml:"topics"`
+
+	// BatchSize is the maximum number of events published at once. Default: 100.
+	BatchSize int `yaml:"batch_size"`
+
+	// FlushInterval bounds how long an event waits for its batch to fill. Default: 1s.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+
+	// QueueSize bounds the events waiting to be published; further events
+	// are dropped. Default: 10000.
+	QueueSize int `yaml:"queue_size"`
+
+	// Timeout bounds publishing one batch. Default: 10s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Event is the payload published for a mutation.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Subject   string    `json:"subject,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	Data      any       `json:"data,omitempty"`
+}
+
+// Message is an encoded event bound for a topic. Key is the event subject,
+// so events about the same resource stay ordered in Kafka.
+type Message struct {
+	Type  string
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// Listener receives every emitted event. It is called synchronously from
+// Emit and must not block.
+type Listener func(Message)
+
+// Publisher delivers batches of messages to a broker.
+type Publisher interface {
+	Publish(ctx context.Context, msgs []Message) error
+	Close() error
+}
+
+var (
+	eventsTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "events",
+		Name:      "total",
+		Help:      "Events by type and result (published, failed, dropped).",
+	}, []string{"type", "result"})
+
+	publishDuration = promauto.With(metrics.Registry).NewHistogram(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "events",
+		Name:      "publish_duration_seconds",
+		Help:      "Time to publish one batch of events.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// Emitter queues events and publishes them in batches.
+type Emitter struct {
+	cfg       Config
+	publisher Publisher
+
+	mu        sync.RWMutex
+	closed    bool
+	listeners []Listener
+	queue     chan Message
+	done      chan struct{}
+}
+
+// New connects to the configured broker. Without a driver, events are only
+// handed to listeners; a nil Emitter's methods do nothing.
+func New(cfg Config) (*Emitter, error) {
+	var (
+		p   Publisher
+		err error
+	)
+	switch cfg.Driver {
+	case "":
+	case "kafka":
+		p, err = newKafka(cfg)
+	case "nats":
+		p, err = newNATS(cfg)
+	default:
+		return nil, fmt.Errorf("unknown events driver %q", cfg.Driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewWithPublisher(cfg, p), nil
+}
+
+// NewWithPublisher returns an Emitter publishing to p, or only notifying
+// listeners if p is nil.
+func NewWithPublisher(cfg Config, p Publisher) *Emitter {
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = "gateway."
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	e := &Emitter{
+		cfg:       cfg,
+		publisher: p,
+		queue:     make(chan Message, cfg.QueueSize),
+		done:      make(chan struct{}),
+	}
+	if p == nil {
+		close(e.done)
+	} else {
+		go e.run()
+	}
+	return e
+}
+
+// Listen adds a listener for all subsequent events.
+func (e *Emitter) Listen(l Listener) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, l)
+}
+
+// Topic returns the topic events of type typ are published to.
+func (e *Emitter) Topic(typ string) string {
+	if topic, ok := e.cfg.Topics[typ]; ok {
+		return topic
+	}
+	return e.cfg.TopicPrefix + typ
+}
+
+// Emit queues an event of type typ about subject, such as a product or user
+// ID. The caller's user ID, request ID and client IP are taken from ctx. data
+// is encoded before Emit returns, so the caller may reuse it.
+func (e *Emitter) Emit(ctx context.Context, typ, subject string, data any) {
+	if e == nil {
+		return
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed || (e.publisher == nil && len(e.listeners) == 0) {
+		return
+	}
+
+	ev := Event{
+		ID:      requestid.New(),
+		Type:    typ,
+		Time:    time.Now().UTC(),
+		Subject: subject,
+		Data:    data,
+	}
+	if claims, ok := token.FromContext(ctx); ok {
+		ev.Actor = claims.UserID
+	}
+	ev.RequestID, _ = requestid.FromContext(ctx)
+	ev.ClientIP, _ = realip.FromContext(ctx)
+
+	value, err := json.Marshal(ev)
+	if err != nil {
+		eventsTotal.WithLabelValues(typ, "failed").Inc()
+		logger.Logger().Warn("Failed to encode event", zap.String("type", typ), zap.Error(err))
+		return
+	}
+	msg := Message{Type: typ, Topic: e.Topic(typ), Key: []byte(subject), Value: value}
+
+	for _, l := range e.listeners {
+		l(msg)
+	}
+	if e.publisher == nil {
+		return
+	}
+	select {
+	case e.queue <- msg:
+	default:
+		eventsTotal.WithLabelValues(typ, "dropped").Inc()
+	}
+}
+
+// Close publishes the queued events, waiting until done or ctx is done, and
+// closes the broker connection.
+func (e *Emitter) Close(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+
+	var err error
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if e.publisher != nil {
+		if cerr := e.publisher.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (e *Emitter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Message, 0, e.cfg.BatchSize)
+	for {
+		select {
+		case msg, ok := <-e.queue:
+			if !ok {
+				e.publish(batch)
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) < e.cfg.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		e.publish(batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *Emitter) publish(batch []Message) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := e.publisher.Publish(ctx, batch)
+	publishDuration.Observe(time.Since(start).Seconds())
+
+	result := "published"
+	if err != nil {
+		result = "failed"
+		logger.Logger().Warn("Failed to publish events", zap.Int("count", len(batch)), zap.Error(err))
+	}
+	for _, msg := range batch {
+		eventsTotal.WithLabelValues(msg.Type, result).Inc()
+	}
+}
@@ -0,0 +1,142 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePublisher records published batches
+type fakePublisher struct {
+	mu      sync.Mutex
+	batches [][]events.Message
+	err     error
+	block   chan struct{}
+	closed  bool
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, msgs []events.Message) error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = append(p.batches, append([]events.Message(nil), msgs...))
+	return p.err
+}
+
+func (p *fakePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *fakePublisher) published() [][]events.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.batches
+}
+
+// TestEmitter_Event tests the event payload and topic
+func TestEmitter_Event(t *testing.T) {
+	p := &fakePublisher{}
+	e := events.NewWithPublisher(events.Config{Topics: map[string]string{events.UserLogin: "auth-logins"}}, p)
+
+	ctx := token.WithClaims(requestid.WithID(context.Background(), "req-1"), &token.Claims{UserID: "user-7"})
+	e.Emit(ctx, events.ProductCreated, "prod-1", map[string]any{"name": "Tea"})
+	e.Emit(context.Background(), events.UserLogin, "user-8", nil)
+	require.NoError(t, e.Close(context.Background()))
+	assert.True(t, p.closed)
+
+	batches := p.published()
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 2)
+	msg := batches[0][0]
+	assert.Equal(t, "gateway.product.created", msg.Topic)
+	assert.Equal(t, "prod-1", string(msg.Key))
+
+	var ev events.Event
+	require.NoError(t, json.Unmarshal(msg.Value, &ev))
+	assert.Len(t, ev.ID, 32)
+	assert.Equal(t, events.ProductCreated, ev.Type)
+	assert.Equal(t, "prod-1", ev.Subject)
+	assert.Equal(t, "user-7", ev.Actor)
+	assert.Equal(t, "req-1", ev.RequestID)
+	assert.Equal(t, map[string]any{"name": "Tea"}, ev.Data)
+	assert.WithinDuration(t, time.Now(), ev.Time, time.Minute)
+
+	assert.Equal(t, "auth-logins", batches[0][1].Topic)
+}
+
+// TestEmitter_Batching tests flushing by batch size and by interval
+func TestEmitter_Batching(t *testing.T) {
+	p := &fakePublisher{}
+	e := events.NewWithPublisher(events.Config{BatchSize: 2, FlushInterval: 20 * time.Millisecond}, p)
+	defer e.Close(context.Background())
+
+	for range 3 {
+		e.Emit(context.Background(), events.ProductDeleted, "prod-1", nil)
+	}
+	require.Eventually(t, func() bool { return len(p.published()) == 2 }, time.Second, 5*time.Millisecond)
+	batches := p.published()
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 1)
+}
+
+// TestEmitter_Drop tests that Emit never blocks when the broker is slow
+func TestEmitter_Drop(t *testing.T) {
+	p := &fakePublisher{block: make(chan struct{}), err: errors.New("broker down")}
+	e := events.NewWithPublisher(events.Config{BatchSize: 1, QueueSize: 1}, p)
+
+	done := make(chan struct{})
+	go func() {
+		for range 10 {
+			e.Emit(context.Background(), events.ProductCreated, "prod-1", nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Emit blocked")
+	}
+	close(p.block)
+	require.NoError(t, e.Close(context.Background()))
+	assert.LessOrEqual(t, len(p.published()), 2)
+}
+
+// TestEmitter_Listen tests that listeners get events without a broker
+func TestEmitter_Listen(t *testing.T) {
+	e, err := events.New(events.Config{})
+	require.NoError(t, err)
+	var got []events.Message
+	e.Listen(func(msg events.Message) { got = append(got, msg) })
+
+	e.Emit(context.Background(), events.UserRegistered, "user-1", nil)
+	require.NoError(t, e.Close(context.Background()))
+	e.Emit(context.Background(), events.UserRegistered, "user-2", nil)
+	require.Len(t, got, 1)
+	assert.Equal(t, "user-1", string(got[0].Key))
+
+	var nilEmitter *events.Emitter
+	nilEmitter.Emit(context.Background(), events.ProductCreated, "prod-1", nil)
+	assert.NoError(t, nilEmitter.Close(context.Background()))
+}
+
+// TestNew tests driver selection
+func TestNew(t *testing.T) {
+	_, err := events.New(events.Config{Driver: "rabbitmq"})
+	assert.Error(t, err)
+	_, err = events.New(events.Config{Driver: "kafka"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type kafkaPublisher struct {
+	w *kafka.Writer
+}
+
+func newKafka(cfg Config) (*kafkaPublisher, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("events: kafka driver requires brokers")
+	}
+	return &kafkaPublisher{w: &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// the Emitter batches already; don't wait for more messages
+		BatchSize:    max(cfg.BatchSize, 1),
+		BatchTimeout: time.Millisecond,
+	}}, nil
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, msgs []Message) error {
+	out := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		out[i] = kafka.Message{
+			Topic: msg.Topic,
+			Key:   msg.Key,
+			Value: msg.Value,
+			Headers: []kafka.Header{
+				{Key: "content-type", Value: []byte("application/json")},
+				{Key: "event-type", Value: []byte(msg.Type)},
+			},
+		}
+	}
+	return p.w.WriteMessages(ctx, out...)
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.w.Close()
+}
@@ -0,0 +1,47 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+type natsPublisher struct {
+	nc *nats.Conn
+}
+
+func newNATS(cfg Config) (*natsPublisher, error) {
+	url := cfg.URL
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	nc, err := nats.Connect(url, nats.Name("gateway"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("events: failed to connect to NATS: %w", err)
+	}
+	return &natsPublisher{nc: nc}, nil
+}
+
+// Publish sends the batch and waits for the server to acknowledge it with a
+// flush. Core NATS does not persist messages; consumers that must not miss
+// events should read them from a JetStream stream on these subjects.
+func (p *natsPublisher) Publish(ctx context.Context, msgs []Message) error {
+	for _, msg := range msgs {
+		m := nats.NewMsg(msg.Topic)
+		m.Data = msg.Value
+		m.Header.Set("Content-Type", "application/json")
+		m.Header.Set("Event-Type", msg.Type)
+		if len(msg.Key) > 0 {
+			m.Header.Set("Subject", string(msg.Key))
+		}
+		if err := p.nc.PublishMsg(m); err != nil {
+			return err
+		}
+	}
+	return p.nc.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) Close() error {
+	return p.nc.Drain()
+}
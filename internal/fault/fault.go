@@ -0,0 +1,219 @@
+// Package fault injects failures into a share of the requests of selected
+// routes: added latency, error responses or dropped connections, so that
+// teams can see how their clients cope with a slow or failing gateway. It
+// is meant for test environments: nothing is injected until enabled through
+// the admin API, which only offers it when the configuration allows it.
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Header marks responses with an injected fault, naming its kinds.
+const Header = "X-Fault-Injected"
+
+// Config configures fault injection.
+type Config struct {
+	// Allow offers fault injection through the admin API. Leave it off in
+	// production.
+	Allow bool `yaml:"allow"`
+
+	// Rules are the faults injected once enabled, unless the admin API
+	// replaces them.
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule injects faults into a share of the requests it matches. A rule can
+// combine latency with an error or a drop.
+type Rule struct {
+	// PathPrefix selects the routes, e.g. "/inventory".
+	PathPrefix string `yaml:"path_prefix" json:"path_prefix"`
+
+	// Methods restricts the rule to these request methods. Empty means all.
+	Methods []string `yaml:"methods" json:"methods,omitempty"`
+
+	// Percent of the matching requests get the fault.
+	Percent float64 `yaml:"percent" json:"percent"`
+
+	// Latency delays the request before it is served. The admin API takes
+	// and reports it as a duration string, e.g. "1.5s".
+	Latency time.Duration `yaml:"latency" json:"-"`
+
+	// Status answers with this error status instead of serving the request.
+	Status int `yaml:"status" json:"status,omitempty"`
+
+	// Drop closes the connection without an answer.
+	Drop bool `yaml:"drop" json:"drop,omitempty"`
+}
+
+type ruleJSON struct {
+	// the fields of Rule but Latency, which json:"-" leaves out
+	plainRule
+	Latency string `json:"latency,omitempty"`
+}
+
+type plainRule Rule
+
+func (r Rule) MarshalJSON() ([]byte, error) {
+	out := ruleJSON{plainRule: plainRule(r)}
+	if r.Latency > 0 {
+		out.Latency = r.Latency.String()
+	}
+	return json.Marshal(out)
+}
+
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var in ruleJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*r = Rule(in.plainRule)
+	if in.Latency != "" {
+		d, err := time.ParseDuration(in.Latency)
+		if err != nil {
+			return fmt.Errorf("fault latency: %w", err)
+		}
+		r.Latency = d
+	}
+	return nil
+}
+
+// Status is the state of fault injection, as reported and set by the admin
+// API.
+type Status struct {
+	Enabled bool   `json:"enabled"`
+	Rules   []Rule `json:"rules"`
+}
+
+var injectedTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "faults",
+	Name:      "injected_total",
+	Help:      "Faults injected into requests, by kind (latency, status, drop).",
+}, []string{"kind"})
+
+// Injector injects the faults of its rules while enabled. A nil *Injector
+// injects nothing.
+type Injector struct {
+	status atomic.Pointer[Status]
+}
+
+// New returns a disabled Injector with the rules of cfg, or nil when cfg
+// does not allow fault injection.
+func New(cfg Config) (*Injector, error) {
+	if !cfg.Allow {
+		return nil, nil
+	}
+	in := &Injector{}
+	if err := in.Set(Status{Rules: cfg.Rules}); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// Set validates st and applies it.
+func (in *Injector) Set(st Status) error {
+	rules := make([]Rule, len(st.Rules))
+	for i, rule := range st.Rules {
+		switch {
+		case !strings.HasPrefix(rule.PathPrefix, "/"):
+			return fmt.Errorf("fault path prefix %q must start with /", rule.PathPrefix)
+		case rule.Percent <= 0 || rule.Percent > 100:
+			return fmt.Errorf("fault %s: percent %v must be between 0 and 100", rule.PathPrefix, rule.Percent)
+		case rule.Status != 0 && (rule.Status < 400 || rule.Status > 599):
+			return fmt.Errorf("fault %s: status %d is not an error status", rule.PathPrefix, rule.Status)
+		case rule.Status != 0 && rule.Drop:
+			return fmt.Errorf("fault %s: a fault either answers with a status or drops the connection", rule.PathPrefix)
+		case rule.Latency <= 0 && rule.Status == 0 && !rule.Drop:
+			return fmt.Errorf("fault %s: no latency, status or drop to inject", rule.PathPrefix)
+		}
+		methods := make([]string, len(rule.Methods))
+		for j, m := range rule.Methods {
+			methods[j] = strings.ToUpper(m)
+		}
+		rule.Methods = methods
+		rules[i] = rule
+	}
+	in.status.Store(&Status{Enabled: st.Enabled, Rules: rules})
+	return nil
+}
+
+// Status returns the current state.
+func (in *Injector) Status() Status {
+	return *in.status.Load()
+}
+
+// Middleware injects the fault of the first rule matching the request into
+// the configured share of requests while enabled.
+func (in *Injector) Middleware(next http.Handler) http.Handler {
+	if in == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := in.status.Load()
+		rule := st.match(r)
+		if rule == nil || rand.Float64()*100 >= rule.Percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var kinds []string
+		if rule.Latency > 0 {
+			kinds = append(kinds, "latency")
+			injectedTotal.WithLabelValues("latency").Inc()
+			select {
+			case <-time.After(rule.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		switch {
+		case rule.Drop:
+			injectedTotal.WithLabelValues("drop").Inc()
+			// the server closes the connection without logging a panic
+			panic(http.ErrAbortHandler)
+		case rule.Status != 0:
+			injectedTotal.WithLabelValues("status").Inc()
+			w.Header().Set(Header, strings.Join(append(kinds, "status"), ", "))
+			message := i18n.Message(w, r, "fault_injected", "status", strconv.Itoa(rule.Status), "status_text", http.StatusText(rule.Status))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(rule.Status)
+			json.NewEncoder(w).Encode(map[string]any{
+				"error":   "fault_injected",
+				"message": message,
+			})
+			return
+		}
+		w.Header().Set(Header, strings.Join(kinds, ", "))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (st *Status) match(r *http.Request) *Rule {
+	if !st.Enabled {
+		return nil
+	}
+	for i, rule := range st.Rules {
+		if !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			continue
+		}
+		if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, r.Method) {
+			continue
+		}
+		return &st.Rules[i]
+	}
+	return nil
+}
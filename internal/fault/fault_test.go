@@ -0,0 +1,83 @@
+package fault_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/fault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+// TestInjector tests that faults are only injected once enabled, into the requests of their rule
+func TestInjector(t *testing.T) {
+	in, err := fault.New(fault.Config{Allow: true, Rules: []fault.Rule{
+		{PathPrefix: "/inventory", Methods: []string{"post"}, Percent: 100, Status: http.StatusServiceUnavailable},
+		{PathPrefix: "/inventory", Percent: 100, Latency: 20 * time.Millisecond},
+		{PathPrefix: "/auth", Percent: 100, Drop: true},
+	}})
+	require.NoError(t, err)
+	h := in.Middleware(ok)
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	rec := serve("POST", "/inventory/products")
+	assert.Equal(t, http.StatusOK, rec.Code, "nothing is injected until enabled")
+
+	st := in.Status()
+	st.Enabled = true
+	require.NoError(t, in.Set(st))
+
+	rec = serve("POST", "/inventory/products")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "status", rec.Header().Get(fault.Header))
+	assert.Contains(t, rec.Body.String(), `"error":"fault_injected"`)
+
+	start := time.Now()
+	rec = serve("GET", "/inventory/products")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "latency", rec.Header().Get(fault.Header))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() { serve("POST", "/auth/login") })
+
+	rec = serve("GET", "/health")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get(fault.Header))
+}
+
+// TestInjector_Set tests that rules from the admin API are validated and take latencies as duration strings
+func TestInjector_Set(t *testing.T) {
+	in, err := fault.New(fault.Config{Allow: true})
+	require.NoError(t, err)
+
+	var st fault.Status
+	require.NoError(t, json.Unmarshal([]byte(`{"enabled": true, "rules": [{"path_prefix": "/inventory", "percent": 10, "latency": "1.5s"}]}`), &st))
+	require.NoError(t, in.Set(st))
+	assert.Equal(t, 1500*time.Millisecond, in.Status().Rules[0].Latency)
+	out, err := json.Marshal(in.Status())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"enabled": true, "rules": [{"path_prefix": "/inventory", "percent": 10, "latency": "1.5s"}]}`, string(out))
+
+	for _, rule := range []fault.Rule{
+		{PathPrefix: "inventory", Percent: 10, Status: 500},
+		{PathPrefix: "/inventory", Percent: 0, Status: 500},
+		{PathPrefix: "/inventory", Percent: 10, Status: 200},
+		{PathPrefix: "/inventory", Percent: 10, Status: 500, Drop: true},
+		{PathPrefix: "/inventory", Percent: 10},
+	} {
+		assert.Error(t, in.Set(fault.Status{Rules: []fault.Rule{rule}}), rule)
+	}
+
+	in, err = fault.New(fault.Config{Rules: []fault.Rule{{PathPrefix: "/inventory", Percent: 100, Drop: true}}})
+	require.NoError(t, err)
+	assert.Nil(t, in, "fault injection must be allowed")
+}
@@ -0,0 +1,278 @@
+// Package feature implements feature flags: named switches that routes and
+// handlers consult, so that features can be dark-launched and then turned
+// on per deployment or per tenant without a release. Flags start from the
+// configuration and can be toggled at runtime through the admin API; the
+// toggles are kept in memory, in a file or in Redis, where every gateway
+// instance sees them.
+package feature
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Config configures the feature flags.
+type Config struct {
+	// Flags are the known flags and their initial state.
+	Flags map[string]Flag `yaml:"flags"`
+
+	// TenantHeader names the tenant of a request, e.g. X-Tenant-ID, for
+	// flags with per-tenant states.
+	TenantHeader string `yaml:"tenant_header"`
+
+	// Routes are only served while their flag is on, and answer 404
+	// otherwise. Rules are tried in order; the first matching one applies.
+	Routes []RouteRule `yaml:"routes"`
+
+	// File keeps the runtime toggles in a JSON file instead of memory,
+	// e.g. on a volume shared by the instances.
+	File string `yaml:"file"`
+
+	// Redis keeps the runtime toggles in Redis when its address is set.
+	Redis RedisConfig `yaml:"redis"`
+
+	// Refresh is how often toggles made by other instances are read from
+	// the file or Redis. Default: 10s.
+	Refresh time.Duration `yaml:"refresh"`
+}
+
+// Flag is the state of a flag.
+type Flag struct {
+	// Enabled is the state for tenants without their own.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// Tenants overrides the state for individual tenants.
+	Tenants map[string]bool `yaml:"tenants" json:"tenants,omitempty"`
+
+	Description string `yaml:"description" json:"description,omitempty"`
+}
+
+// For reports whether the flag is on for tenant, which may be empty.
+func (f Flag) For(tenant string) bool {
+	if on, ok := f.Tenants[tenant]; ok && tenant != "" {
+		return on
+	}
+	return f.Enabled
+}
+
+// RouteRule gates a group of routes behind a flag.
+type RouteRule struct {
+	// PathPrefix selects the routes, e.g. "/inventory/v2".
+	PathPrefix string `yaml:"path_prefix"`
+
+	// Methods restricts the rule to these request methods. Empty means all.
+	Methods []string `yaml:"methods"`
+
+	// Flag must be on for the routes to be served.
+	Flag string `yaml:"flag"`
+}
+
+// ErrUnknown is returned when setting a flag missing from the configuration.
+var ErrUnknown = errors.New("unknown feature flag")
+
+// Status is a flag as reported by the admin API.
+type Status struct {
+	Name string `json:"name"`
+	Flag
+}
+
+// Flags holds the flag states. A nil *Flags has every flag off and gates
+// nothing.
+type Flags struct {
+	defaults map[string]Flag
+	header   string
+	routes   []RouteRule
+	store    Store
+	current  atomic.Pointer[map[string]Flag]
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New returns the Flags of cfg, with runtime toggles kept in Redis or the
+// file when configured and in memory otherwise. It returns nil when no flag
+// is configured.
+func New(cfg Config) (*Flags, error) {
+	if len(cfg.Flags) == 0 {
+		return nil, nil
+	}
+	var store Store = NewMemoryStore()
+	switch {
+	case cfg.Redis.Addr != "":
+		var err error
+		if store, err = NewRedisStore(cfg.Redis); err != nil {
+			return nil, err
+		}
+	case cfg.File != "":
+		store = NewFileStore(cfg.File)
+	}
+	return NewWithStore(cfg, store)
+}
+
+// NewWithStore returns the Flags of cfg with runtime toggles kept in store.
+func NewWithStore(cfg Config, store Store) (*Flags, error) {
+	f := &Flags{defaults: cfg.Flags, header: cfg.TenantHeader, store: store}
+	for _, rule := range cfg.Routes {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return nil, fmt.Errorf("feature route path prefix %q must start with /", rule.PathPrefix)
+		}
+		if _, ok := cfg.Flags[rule.Flag]; !ok {
+			return nil, fmt.Errorf("feature route %s: unknown flag %q", rule.PathPrefix, rule.Flag)
+		}
+		methods := make([]string, len(rule.Methods))
+		for i, m := range rule.Methods {
+			methods[i] = strings.ToUpper(m)
+		}
+		rule.Methods = methods
+		f.routes = append(f.routes, rule)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := f.reload(ctx); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	refresh := cfg.Refresh
+	if refresh <= 0 {
+		refresh = 10 * time.Second
+	}
+	var runCtx context.Context
+	runCtx, f.cancel = context.WithCancel(context.Background())
+	f.done = make(chan struct{})
+	go f.run(runCtx, refresh)
+	return f, nil
+}
+
+// reload merges the toggles of the store over the configured defaults.
+func (f *Flags) reload(ctx context.Context) error {
+	toggles, err := f.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("feature: failed to load flags: %w", err)
+	}
+	flags := make(map[string]Flag, len(f.defaults))
+	for name, flag := range f.defaults {
+		if t, ok := toggles[name]; ok {
+			t.Description = flag.Description
+			flag = t
+		}
+		flags[name] = flag
+	}
+	f.current.Store(&flags)
+	return nil
+}
+
+func (f *Flags) run(ctx context.Context, interval time.Duration) {
+	defer close(f.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			loadCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+			if err := f.reload(loadCtx); err != nil {
+				logger.Logger().Warn("Failed to refresh feature flags", zap.Error(err))
+			}
+			cancel()
+		}
+	}
+}
+
+// Tenant returns the tenant of r, empty without a tenant header.
+func (f *Flags) Tenant(r *http.Request) string {
+	if f == nil || f.header == "" {
+		return ""
+	}
+	return r.Header.Get(f.header)
+}
+
+// Enabled reports whether flag name is on for tenant. Unknown flags are off.
+func (f *Flags) Enabled(name, tenant string) bool {
+	if f == nil {
+		return false
+	}
+	return (*f.current.Load())[name].For(tenant)
+}
+
+// List returns every flag, sorted by name.
+func (f *Flags) List() []Status {
+	if f == nil {
+		return nil
+	}
+	flags := *f.current.Load()
+	out := make([]Status, 0, len(flags))
+	for name, flag := range flags {
+		out = append(out, Status{Name: name, Flag: flag})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Set toggles the known flag name in the store, so that every instance
+// sharing it applies the state.
+func (f *Flags) Set(ctx context.Context, name string, flag Flag) error {
+	if _, ok := f.defaults[name]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknown, name)
+	}
+	flag.Description = ""
+	if err := f.store.Set(ctx, name, flag); err != nil {
+		return err
+	}
+	return f.reload(ctx)
+}
+
+// Close stops refreshing the flags and closes the store.
+func (f *Flags) Close() error {
+	if f == nil {
+		return nil
+	}
+	f.cancel()
+	<-f.done
+	return f.store.Close()
+}
+
+type flagsKey struct{}
+
+// Middleware answers 404 for routes whose flag is off for the tenant of the
+// request, and makes the flags available to handlers through Enabled.
+func (f *Flags) Middleware(next http.Handler) http.Handler {
+	if f == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range f.routes {
+			if !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+				continue
+			}
+			if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, r.Method) {
+				continue
+			}
+			if !f.Enabled(rule.Flag, f.Tenant(r)) {
+				http.NotFound(w, r)
+				return
+			}
+			break
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), flagsKey{}, f)))
+	})
+}
+
+// Enabled reports whether flag name is on for the tenant of r, as seen by
+// the flags of Middleware. Without them every flag is off.
+func Enabled(r *http.Request, name string) bool {
+	f, _ := r.Context().Value(flagsKey{}).(*Flags)
+	return f.Enabled(name, f.Tenant(r))
+}
@@ -0,0 +1,76 @@
+package feature_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/feature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFlags_Middleware tests that gated routes answer 404 until their flag is on for the tenant and handlers see the flags
+func TestFlags_Middleware(t *testing.T) {
+	flags, err := feature.New(feature.Config{
+		Flags: map[string]feature.Flag{
+			"inventory_v2": {Tenants: map[string]bool{"acme": true}},
+			"new_search":   {Enabled: true},
+		},
+		TenantHeader: "X-Tenant-ID",
+		Routes:       []feature.RouteRule{{PathPrefix: "/inventory/v2", Flag: "inventory_v2"}},
+	})
+	require.NoError(t, err)
+	defer flags.Close()
+
+	h := flags.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if feature.Enabled(r, "new_search") {
+			w.Header().Set("X-Search", "new")
+		}
+	}))
+	serve := func(path, tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Tenant-ID", tenant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusNotFound, serve("/inventory/v2/products", "").Code)
+	assert.Equal(t, http.StatusOK, serve("/inventory/v2/products", "acme").Code)
+	rec := serve("/inventory/products", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "new", rec.Header().Get("X-Search"))
+
+	require.NoError(t, flags.Set(context.Background(), "inventory_v2", feature.Flag{Enabled: true}))
+	assert.Equal(t, http.StatusOK, serve("/inventory/v2/products", "").Code)
+	assert.ErrorIs(t, flags.Set(context.Background(), "missing", feature.Flag{}), feature.ErrUnknown)
+}
+
+// TestFlags_FileStore tests that toggles written to the file by one instance reach another
+func TestFlags_FileStore(t *testing.T) {
+	cfg := feature.Config{
+		Flags:   map[string]feature.Flag{"response_cache": {Description: "cache GET responses"}},
+		File:    filepath.Join(t.TempDir(), "features.json"),
+		Refresh: 10 * time.Millisecond,
+	}
+	a, err := feature.New(cfg)
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := feature.New(cfg)
+	require.NoError(t, err)
+	defer b.Close()
+
+	assert.False(t, b.Enabled("response_cache", ""))
+	require.NoError(t, a.Set(context.Background(), "response_cache", feature.Flag{Enabled: true}))
+	require.Eventually(t, func() bool {
+		return b.Enabled("response_cache", "")
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []feature.Status{{
+		Name: "response_cache",
+		Flag: feature.Flag{Enabled: true, Description: "cache GET responses"},
+	}}, b.List())
+}
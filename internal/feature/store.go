@@ -0,0 +1,190 @@
+package feature
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store keeps the flags toggled at runtime, which take precedence over the
+// configured states.
+type Store interface {
+	// Load returns every toggled flag.
+	Load(ctx context.Context) (map[string]Flag, error)
+	Set(ctx context.Context, name string, flag Flag) error
+	Close() error
+}
+
+// MemoryStore keeps the toggles in process memory; they are lost on restart
+// and each instance has its own.
+type MemoryStore struct {
+	mu    sync.Mutex
+	flags map[string]Flag
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{flags: map[string]Flag{}}
+}
+
+func (s *MemoryStore) Load(context.Context) (map[string]Flag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.flags), nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, name string, flag Flag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags[name] = flag
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore keeps the toggles in a JSON file, an object of flags by name.
+// A missing file has no toggles.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore returns a FileStore for the file at path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load(context.Context) (map[string]Flag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *FileStore) load() (map[string]Flag, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]Flag{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	flags := map[string]Flag{}
+	if err := json.Unmarshal(data, &flags); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	return flags, nil
+}
+
+// Set rewrites the file, replacing it atomically.
+func (s *FileStore) Set(_ context.Context, name string, flag Flag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flags, err := s.load()
+	if err != nil {
+		return err
+	}
+	flags[name] = flag
+	data, err := json.MarshalIndent(flags, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// RedisConfig configures the Redis store.
+type RedisConfig struct {
+	// Addr is the host:port of the Redis server.
+	Addr string `yaml:"addr"`
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+
+	// Key is the hash holding the toggles. Default: "gateway:features".
+	Key string `yaml:"key"`
+}
+
+// storeTimeout bounds the calls to the store made outside requests.
+const storeTimeout = 5 * time.Second
+
+// RedisStore keeps the toggles in a Redis hash of JSON flags by name,
+// shared by every gateway instance.
+type RedisStore struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisStore connects to Redis and checks that it answers.
+func NewRedisStore(cfg RedisConfig) (*RedisStore, error) {
+	if cfg.Key == "" {
+		cfg.Key = "gateway:features"
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("feature: failed to connect to Redis: %w", err)
+	}
+	return &RedisStore{client: client, key: cfg.Key}, nil
+}
+
+func (s *RedisStore) Load(ctx context.Context) (map[string]Flag, error) {
+	values, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]Flag, len(values))
+	for name, v := range values {
+		var flag Flag
+		if err := json.Unmarshal([]byte(v), &flag); err != nil {
+			return nil, fmt.Errorf("flag %s: %w", name, err)
+		}
+		flags[name] = flag
+	}
+	return flags, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, name string, flag Flag) error {
+	data, err := json.Marshal(flag)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.key, name, data).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
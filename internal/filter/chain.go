@@ -0,0 +1,334 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/realip"
+	"go.uber.org/zap"
+)
+
+// maxBodyBytes caps how much of a request body is buffered for filters.
+const maxBodyBytes = 4 << 20
+
+// Chain runs request filters in order and response filters in reverse order.
+type Chain struct {
+	entries []entry
+}
+
+type entry struct {
+	name     string
+	filter   Filter
+	timeout  time.Duration
+	failOpen bool
+	prefixes []string
+	body     bool
+}
+
+// applies reports whether the entry filters requests for path.
+func (e entry) applies(path string) bool {
+	return len(e.prefixes) == 0 || slices.ContainsFunc(e.prefixes, func(p string) bool { return strings.HasPrefix(path, p) })
+}
+
+// NewChain loads every configured filter plugin and returns the resulting chain.
+func NewChain(cfgs []Config) (*Chain, error) {
+	c := &Chain{}
+	for _, cfg := range cfgs {
+		for _, p := range cfg.PathPrefixes {
+			if !strings.HasPrefix(p, "/") {
+				return nil, fmt.Errorf("filter %s: path prefix %q must start with /", cfg.Path, p)
+			}
+		}
+		f, err := Open(cfg.Path, cfg.Options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load filter %s: %w", cfg.Path, err)
+		}
+		c.Add(f, cfg)
+	}
+	return c, nil
+}
+
+// Add appends f to the chain using the timeout and failure policy from cfg.
+// cfg.Path is ignored.
+func (c *Chain) Add(f Filter, cfg Config) {
+	e := entry{
+		name:     cfg.Name,
+		filter:   f,
+		timeout:  cfg.Timeout,
+		failOpen: cfg.FailOpen,
+		prefixes: cfg.PathPrefixes,
+		body:     cfg.RequestBody,
+	}
+	if e.name == "" {
+		e.name = f.Name()
+	}
+	if e.timeout <= 0 {
+		e.timeout = defaultTimeout
+	}
+	c.entries = append(c.entries, e)
+}
+
+// Len returns the number of filters in the chain.
+func (c *Chain) Len() int {
+	return len(c.entries)
+}
+
+// Middleware applies the chain around next. Requests no filter applies to
+// pass untouched, and request bodies are only buffered for the filters that
+// asked for them.
+func (c *Chain) Middleware(next http.Handler) http.Handler {
+	if len(c.entries) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entries []entry
+		hasResponseFilters, buffered := false, false
+		for _, e := range c.entries {
+			if !e.applies(r.URL.Path) {
+				continue
+			}
+			entries = append(entries, e)
+			if _, ok := e.filter.(ResponseFilter); ok {
+				hasResponseFilters = true
+			}
+			buffered = buffered || e.body
+		}
+		if len(entries) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if buffered {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
+			if len(body) > maxBodyBytes {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+
+		clientIP, ok := realip.FromContext(r.Context())
+		if !ok {
+			clientIP = realip.Peer(r)
+		}
+
+		req := &Request{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.Query(),
+			Header:     r.Header.Clone(),
+			RemoteAddr: r.RemoteAddr,
+			ClientIP:   clientIP,
+			Body:       body,
+		}
+
+		for _, e := range entries {
+			rf, ok := e.filter.(RequestFilter)
+			if !ok {
+				continue
+			}
+			candidate := req.forFilter(e)
+			var resp *Response
+			err := e.call(r.Context(), func(ctx context.Context) error {
+				var ferr error
+				resp, ferr = rf.FilterRequest(ctx, candidate)
+				return ferr
+			})
+			if err != nil {
+				if e.failOpen {
+					logger.Logger().Warn("Request filter failed, continuing", zap.String("filter", e.name), zap.Error(err))
+					continue
+				}
+				logger.Logger().Error("Request filter failed", zap.String("filter", e.name), zap.Error(err))
+				http.Error(w, "request rejected by filter", http.StatusBadGateway)
+				return
+			}
+			if resp != nil {
+				writeResponse(w, resp)
+				return
+			}
+			if !e.body {
+				candidate.Body = req.Body
+			}
+			req = candidate
+		}
+
+		r = applyRequest(r, req, buffered)
+
+		if !hasResponseFilters {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bw := &bufferedWriter{w: w, header: make(http.Header)}
+		next.ServeHTTP(bw, r)
+		if bw.streaming {
+			// already sent as it was flushed, without response filters
+			return
+		}
+		resp := bw.response()
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			rf, ok := e.filter.(ResponseFilter)
+			if !ok {
+				continue
+			}
+			candidate := resp.clone()
+			err := e.call(r.Context(), func(ctx context.Context) error {
+				return rf.FilterResponse(ctx, req.forFilter(e), candidate)
+			})
+			if err != nil {
+				if e.failOpen {
+					logger.Logger().Warn("Response filter failed, continuing", zap.String("filter", e.name), zap.Error(err))
+					continue
+				}
+				logger.Logger().Error("Response filter failed", zap.String("filter", e.name), zap.Error(err))
+				http.Error(w, "response rejected by filter", http.StatusBadGateway)
+				return
+			}
+			resp = candidate
+		}
+
+		writeResponse(w, resp)
+	})
+}
+
+// call invokes fn under the entry's timeout, converting panics into errors.
+// fn keeps running in the background after a timeout, which is why filters
+// only ever receive copies of request state.
+func (e entry) call(parent context.Context, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(parent, e.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("filter panicked: %v", p)
+			}
+		}()
+		done <- fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("filter timed out after %s", e.timeout)
+		}
+		return ctx.Err()
+	}
+}
+
+// applyRequest returns r changed as filters changed req. Its body is only
+// replaced when it was buffered; otherwise it streams on as it is.
+func applyRequest(r *http.Request, req *Request, buffered bool) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.Method = req.Method
+	r2.URL.Path = req.Path
+	r2.URL.RawPath = ""
+	r2.URL.RawQuery = req.Query.Encode()
+	r2.Header = req.Header
+	if buffered {
+		r2.Body = io.NopCloser(bytes.NewReader(req.Body))
+		r2.ContentLength = int64(len(req.Body))
+	}
+	return r2
+}
+
+func writeResponse(w http.ResponseWriter, resp *Response) {
+	for k, vs := range resp.Header {
+		w.Header()[k] = vs
+	}
+	// filters may have changed the body length
+	w.Header().Del("Content-Length")
+	status := resp.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(resp.Body)
+}
+
+// bufferedWriter holds the handler's response so response filters can
+// see it whole. A handler that flushes is streaming, e.g. NDJSON or SSE:
+// the response is then sent as it comes, without response filters, rather
+// than held in memory until it ends.
+type bufferedWriter struct {
+	w         http.ResponseWriter
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	streaming bool
+}
+
+func (b *bufferedWriter) Header() http.Header {
+	if b.streaming {
+		return b.w.Header()
+	}
+	return b.header
+}
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	if b.streaming {
+		b.w.WriteHeader(status)
+		return
+	}
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	if b.streaming {
+		return b.w.Write(p)
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// Flush switches to streaming: what is buffered is sent, and so is
+// everything written after.
+func (b *bufferedWriter) Flush() {
+	if !b.streaming {
+		b.streaming = true
+		logger.Logger().Debug("Streamed response skips response filters")
+		writeResponse(b.w, b.response())
+	}
+	http.NewResponseController(b.w).Flush()
+}
+
+func (b *bufferedWriter) Unwrap() http.ResponseWriter {
+	return b.w
+}
+
+func (b *bufferedWriter) response() *Response {
+	status := b.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &Response{
+		StatusCode: status,
+		Header:     b.header,
+		Body:       b.body.Bytes(),
+	}
+}
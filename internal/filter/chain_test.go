@@ -0,0 +1,247 @@
+package filter_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/filter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// funcFilter adapts plain functions to the filter interfaces
+type funcFilter struct {
+	name string
+	pre  func(ctx context.Context, req *filter.Request) (*filter.Response, error)
+	post func(ctx context.Context, req *filter.Request, resp *filter.Response) error
+}
+
+func (f *funcFilter) Name() string { return f.name }
+
+func (f *funcFilter) FilterRequest(ctx context.Context, req *filter.Request) (*filter.Response, error) {
+	if f.pre == nil {
+		return nil, nil
+	}
+	return f.pre(ctx, req)
+}
+
+func (f *funcFilter) FilterResponse(ctx context.Context, req *filter.Request, resp *filter.Response) error {
+	if f.post == nil {
+		return nil
+	}
+	return f.post(ctx, req, resp)
+}
+
+// echoHandler reports what it received so tests can see filter modifications
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("X-Seen-Tenant", r.Header.Get("X-Tenant"))
+	w.Header().Set("X-Seen-Path", r.URL.Path)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func serve(t *testing.T, c *filter.Chain, req *http.Request) *http.Response {
+	t.Helper()
+	ts := httptest.NewServer(c.Middleware(http.HandlerFunc(echoHandler)))
+	t.Cleanup(ts.Close)
+
+	u := ts.URL + req.URL.Path
+	out, err := http.NewRequest(req.Method, u, req.Body)
+	require.NoError(t, err)
+	out.Header = req.Header
+	resp, err := http.DefaultClient.Do(out)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// TestChain_RequestFilterModifiesRequest tests that changes made by a filter reach the handler
+func TestChain_RequestFilterModifiesRequest(t *testing.T) {
+	c := &filter.Chain{}
+	c.Add(&funcFilter{
+		name: "tenant",
+		pre: func(ctx context.Context, req *filter.Request) (*filter.Response, error) {
+			req.Header.Set("X-Tenant", "acme")
+			req.Path = "/rewritten"
+			req.Body = []byte("filtered")
+			return nil, nil
+		},
+	}, filter.Config{RequestBody: true})
+
+	resp := serve(t, c, httptest.NewRequest(http.MethodPost, "/original", strings.NewReader("raw")))
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "acme", resp.Header.Get("X-Seen-Tenant"))
+	assert.Equal(t, "/rewritten", resp.Header.Get("X-Seen-Path"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "filtered", string(body))
+}
+
+// TestChain_RequestBody tests that bodies stream past filters that do not ask for them and filters stay on their routes
+func TestChain_RequestBody(t *testing.T) {
+	var seen [][]byte
+	c := &filter.Chain{}
+	c.Add(&funcFilter{
+		name: "headers",
+		pre: func(ctx context.Context, req *filter.Request) (*filter.Response, error) {
+			seen = append(seen, req.Body)
+			req.Header.Set("X-Tenant", "acme")
+			return nil, nil
+		},
+	}, filter.Config{})
+	c.Add(&funcFilter{
+		name: "payload",
+		pre: func(ctx context.Context, req *filter.Request) (*filter.Response, error) {
+			seen = append(seen, req.Body)
+			return nil, nil
+		},
+	}, filter.Config{RequestBody: true, PathPrefixes: []string{"/inventory"}})
+
+	upload := strings.Repeat("x", 5<<20)
+	resp := serve(t, c, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(upload)))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "acme", resp.Header.Get("X-Seen-Tenant"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, len(upload), len(body), "bodies over the buffer limit stream to the handler")
+	assert.Equal(t, [][]byte{nil}, seen)
+
+	seen = nil
+	resp = serve(t, c, httptest.NewRequest(http.MethodPost, "/inventory/create", strings.NewReader(`{"name":"tea"}`)))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, [][]byte{nil, []byte(`{"name":"tea"}`)}, seen)
+
+	resp = serve(t, c, httptest.NewRequest(http.MethodPost, "/inventory/create", strings.NewReader(upload)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	_, err = filter.NewChain([]filter.Config{{Path: "x.so", PathPrefixes: []string{"inventory"}}})
+	assert.ErrorContains(t, err, "must start with /")
+}
+
+// TestChain_RequestFilterShortCircuits tests that a filter can answer the request itself
+func TestChain_RequestFilterShortCircuits(t *testing.T) {
+	c := &filter.Chain{}
+	c.Add(&funcFilter{
+		name: "deny",
+		pre: func(ctx context.Context, req *filter.Request) (*filter.Response, error) {
+			return &filter.Response{StatusCode: http.StatusForbidden, Body: []byte("blocked by policy")}, nil
+		},
+	}, filter.Config{})
+
+	resp := serve(t, c, httptest.NewRequest(http.MethodGet, "/anything", nil))
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("X-Seen-Path"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "blocked by policy", string(body))
+}
+
+// TestChain_FailurePolicy tests timeouts, errors and panics with fail-closed and fail-open filters
+func TestChain_FailurePolicy(t *testing.T) {
+	slow := func(ctx context.Context, req *filter.Request) (*filter.Response, error) {
+		req.Header.Set("X-Tenant", "late")
+		time.Sleep(200 * time.Millisecond)
+		return nil, nil
+	}
+	failing := func(ctx context.Context, req *filter.Request) (*filter.Response, error) {
+		return nil, errors.New("boom")
+	}
+	panicking := func(ctx context.Context, req *filter.Request) (*filter.Response, error) {
+		panic("filter bug")
+	}
+
+	tests := []struct {
+		name     string
+		pre      func(ctx context.Context, req *filter.Request) (*filter.Response, error)
+		failOpen bool
+		wantCode int
+	}{
+		{name: "timeout fail-closed", pre: slow, wantCode: http.StatusBadGateway},
+		{name: "timeout fail-open", pre: slow, failOpen: true, wantCode: http.StatusOK},
+		{name: "error fail-closed", pre: failing, wantCode: http.StatusBadGateway},
+		{name: "panic fail-open", pre: panicking, failOpen: true, wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &filter.Chain{}
+			c.Add(&funcFilter{name: tt.name, pre: tt.pre}, filter.Config{
+				Timeout:  20 * time.Millisecond,
+				FailOpen: tt.failOpen,
+			})
+
+			resp := serve(t, c, httptest.NewRequest(http.MethodGet, "/path", nil))
+
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			// changes from a failed filter must never be applied
+			assert.Empty(t, resp.Header.Get("X-Seen-Tenant"))
+		})
+	}
+}
+
+// TestChain_ResponseFilter tests that response filters can rewrite the handler's response
+func TestChain_ResponseFilter(t *testing.T) {
+	c := &filter.Chain{}
+	c.Add(&funcFilter{
+		name: "redact",
+		post: func(ctx context.Context, req *filter.Request, resp *filter.Response) error {
+			resp.Body = []byte(strings.ReplaceAll(string(resp.Body), "secret", "******"))
+			resp.Header.Set("X-Filtered", "true")
+			return nil
+		},
+	}, filter.Config{})
+
+	resp := serve(t, c, httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("my secret value")))
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("X-Filtered"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "my ****** value", string(body))
+}
+
+// TestChain_StreamedResponse tests that flushed responses are sent as they come instead of being buffered for response filters
+func TestChain_StreamedResponse(t *testing.T) {
+	c := &filter.Chain{}
+	c.Add(&funcFilter{
+		name: "redact",
+		post: func(ctx context.Context, req *filter.Request, resp *filter.Response) error {
+			resp.Body = []byte("filtered")
+			return nil
+		},
+	}, filter.Config{})
+
+	first := make(chan struct{})
+	ts := httptest.NewServer(c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("{\"n\":1}\n"))
+		require.NoError(t, http.NewResponseController(w).Flush())
+		select {
+		case <-first:
+		case <-time.After(2 * time.Second):
+		}
+		w.Write([]byte("{\"n\":2}\n"))
+	})))
+	defer ts.Close()
+
+	start := time.Now()
+	resp, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "{\"n\":1}\n", line)
+	assert.Less(t, time.Since(start), time.Second, "the first line arrives before the handler returns")
+	close(first)
+}
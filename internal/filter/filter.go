@@ -0,0 +1,128 @@
+// Package filter provides an extension point for operator-supplied request
+// filters. Filters see a copy of the request (and optionally the response),
+// never the live http.Request, and every call runs under its own timeout so a
+// misbehaving filter cannot stall or crash the gateway.
+package filter
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Request is the sandboxed view of an inbound request handed to filters.
+// Changes made to it are applied to the real request only if the filter
+// returns in time and without error.
+type Request struct {
+	Method     string
+	Path       string
+	Query      url.Values
+	Header     http.Header
+	RemoteAddr string
+
+	// Body is the request body, for filters configured with RequestBody.
+	// It is nil for the others, and their changes to it are ignored.
+	Body []byte
+
+	// ClientIP is the originating client IP, resolved from forwarding
+	// headers only when the peer is a trusted proxy.
+	ClientIP string
+}
+
+// Response is the sandboxed view of a response. Pre filters return one to
+// short-circuit routing; post filters may modify the one produced by the handler.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Filter is implemented by every custom filter.
+type Filter interface {
+	Name() string
+}
+
+// RequestFilter runs before the request reaches the router. Returning a
+// non-nil Response stops the chain and sends that response to the client.
+type RequestFilter interface {
+	Filter
+	FilterRequest(ctx context.Context, req *Request) (*Response, error)
+}
+
+// ResponseFilter runs after the handler has produced a response.
+type ResponseFilter interface {
+	Filter
+	FilterResponse(ctx context.Context, req *Request, resp *Response) error
+}
+
+// Factory constructs a filter from its options. Go plugins must export a
+// symbol named NewFilter of this type.
+type Factory func(options map[string]string) (Filter, error)
+
+// Config describes a single filter to load.
+type Config struct {
+	// Name identifies the filter in logs. Defaults to the filter's own Name().
+	Name string `yaml:"name"`
+
+	// Path is the location of the Go plugin (.so) implementing the filter.
+	Path string `yaml:"path"`
+
+	// Timeout bounds every call into the filter. Default: 100ms.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// FailOpen lets requests through when the filter errors or times out
+	// instead of rejecting them.
+	FailOpen bool `yaml:"fail_open"`
+
+	// PathPrefixes limits the filter to requests whose path starts with one
+	// of them, e.g. "/inventory". Default: every request.
+	PathPrefixes []string `yaml:"path_prefixes"`
+
+	// RequestBody hands the filter the request body. Requests the filter
+	// applies to are then buffered in memory, and bodies over 4 MiB are
+	// refused with 413. Without it Request.Body is nil and the body streams
+	// to the handler, so uploads of any size pass.
+	RequestBody bool `yaml:"request_body"`
+
+	// Options are passed verbatim to the filter factory.
+	Options map[string]string `yaml:"options"`
+}
+
+const defaultTimeout = 100 * time.Millisecond
+
+func (r *Request) clone() *Request {
+	c := *r
+	c.Query = cloneValues(r.Query)
+	c.Header = r.Header.Clone()
+	c.Body = append([]byte(nil), r.Body...)
+	return &c
+}
+
+// forFilter returns a copy of r for the filter of e, without the body unless
+// e asked for it.
+func (r *Request) forFilter(e entry) *Request {
+	c := r.clone()
+	if !e.body {
+		c.Body = nil
+	}
+	return c
+}
+
+func (r *Response) clone() *Response {
+	c := *r
+	c.Header = r.Header.Clone()
+	c.Body = append([]byte(nil), r.Body...)
+	return &c
+}
+
+func cloneValues(v url.Values) url.Values {
+	if v == nil {
+		return url.Values{}
+	}
+	c := make(url.Values, len(v))
+	for k, vs := range v {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
@@ -0,0 +1,42 @@
+//go:build cgo && (linux || darwin || freebsd)
+
+package filter
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// Open loads a Go plugin built with -buildmode=plugin and constructs its
+// filter via the exported NewFilter symbol.
+func Open(path string, options map[string]string) (Filter, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("NewFilter")
+	if err != nil {
+		return nil, err
+	}
+
+	var factory Factory
+	switch fn := sym.(type) {
+	case func(map[string]string) (Filter, error):
+		factory = fn
+	case *Factory:
+		factory = *fn
+	default:
+		return nil, fmt.Errorf("NewFilter has unexpected type %T", sym)
+	}
+
+	f, err := factory(options)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := f.(RequestFilter); !ok {
+		if _, ok := f.(ResponseFilter); !ok {
+			return nil, fmt.Errorf("filter %s implements neither RequestFilter nor ResponseFilter", f.Name())
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,11 @@
+//go:build !cgo || !(linux || darwin || freebsd)
+
+package filter
+
+import "errors"
+
+// Open is unavailable when the gateway is built without cgo, as Go plugins
+// require it.
+func Open(path string, options map[string]string) (Filter, error) {
+	return nil, errors.New("filter plugins are not supported by this build; build the gateway with CGO_ENABLED=1, e.g. docker build --build-arg CGO_ENABLED=1")
+}
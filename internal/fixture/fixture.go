@@ -0,0 +1,265 @@
+// Package fixture records the RPCs the gateway sends to its backends, with
+// their responses, in fixture files, and replays them later without the
+// backends: recorded once against real services, the fixtures make CI runs
+// deterministic and let the gateway run offline for demos.
+//
+// Each backend has one file, <dir>/<backend>.json, holding a JSON array of
+// exchanges. A replayed call is answered by the exchange with the same
+// method and an equal request message; request metadata is not compared.
+// Streaming RPCs are neither recorded nor replayed.
+package fixture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Modes.
+const (
+	// Record passes calls to the backends and writes every exchange to the
+	// fixture files.
+	Record = "record"
+	// Replay answers calls from the fixture files; the backends are never
+	// called.
+	Replay = "replay"
+)
+
+// Config configures fixture recording and replay.
+type Config struct {
+	// Mode is "record", "replay", or empty to call the backends as usual.
+	// Env: FIXTURE_MODE.
+	Mode string `yaml:"mode"`
+
+	// Dir holds the fixture files. Default: testdata/fixtures.
+	Dir string `yaml:"dir"`
+}
+
+// Exchange is one recorded call.
+type Exchange struct {
+	Method   string          `json:"method"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    *Error          `json:"error,omitempty"`
+}
+
+// Error is the status of a failed call.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Recorder records or replays the calls of every backend.
+type Recorder struct {
+	mode string
+	dir  string
+	log  *zap.Logger
+
+	mu    sync.Mutex
+	files map[string][]Exchange
+}
+
+// New loads the fixture files of cfg.Dir. It returns nil when recording and
+// replay are off; a nil Recorder's Conn returns the backend unchanged.
+func New(cfg Config) (*Recorder, error) {
+	switch cfg.Mode {
+	case "":
+		return nil, nil
+	case Record, Replay:
+	default:
+		return nil, fmt.Errorf("unknown fixture mode %q", cfg.Mode)
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "testdata/fixtures"
+	}
+
+	r := &Recorder{mode: cfg.Mode, dir: cfg.Dir, log: logger.Logger(), files: map[string][]Exchange{}}
+	paths, err := filepath.Glob(filepath.Join(cfg.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var exchanges []Exchange
+		if err := json.Unmarshal(data, &exchanges); err != nil {
+			return nil, fmt.Errorf("failed to parse fixture %s: %w", path, err)
+		}
+		r.files[strings.TrimSuffix(filepath.Base(path), ".json")] = exchanges
+	}
+	if cfg.Mode == Replay && len(r.files) == 0 {
+		return nil, fmt.Errorf("no fixture files to replay in %s", cfg.Dir)
+	}
+	if cfg.Mode == Record {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Mode returns "record", "replay", or "" for a nil Recorder.
+func (r *Recorder) Mode() string {
+	if r == nil {
+		return ""
+	}
+	return r.mode
+}
+
+// Dir returns the directory of the fixture files.
+func (r *Recorder) Dir() string {
+	if r == nil {
+		return ""
+	}
+	return r.dir
+}
+
+// Conn wraps conn, the connection to the named backend, to record or
+// replay its calls.
+func (r *Recorder) Conn(backend string, conn grpc.ClientConnInterface) grpc.ClientConnInterface {
+	if r == nil {
+		return conn
+	}
+	return &fixtureConn{ClientConnInterface: conn, r: r, backend: backend}
+}
+
+type fixtureConn struct {
+	grpc.ClientConnInterface
+	r       *Recorder
+	backend string
+}
+
+func (c *fixtureConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	in, ok := args.(proto.Message)
+	out, ok2 := reply.(proto.Message)
+	if c.r.mode == Replay {
+		if !ok || !ok2 {
+			return status.Errorf(codes.Unimplemented, "fixture: cannot replay %s", method)
+		}
+		return c.r.replay(c.backend, method, in, out)
+	}
+
+	err := c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+	if ok && ok2 && ctx.Err() == nil {
+		if recErr := c.r.record(c.backend, method, in, out, err); recErr != nil {
+			c.r.log.Warn("Failed to record fixture", zap.String("backend", c.backend), zap.String("method", method), zap.Error(recErr))
+		}
+	}
+	return err
+}
+
+func (c *fixtureConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if c.r.mode == Replay {
+		return nil, status.Errorf(codes.Unimplemented, "fixture: streams are not replayed: %s", method)
+	}
+	return c.ClientConnInterface.NewStream(ctx, desc, method, opts...)
+}
+
+// find returns the index of the exchange of backend answering method and
+// in, or -1.
+func (r *Recorder) find(backend, method string, in proto.Message) int {
+	for i, ex := range r.files[backend] {
+		if ex.Method != method {
+			continue
+		}
+		recorded := in.ProtoReflect().New().Interface()
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(ex.Request, recorded); err != nil {
+			continue
+		}
+		if proto.Equal(recorded, in) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *Recorder) replay(backend, method string, in, out proto.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.find(backend, method, in)
+	if i < 0 {
+		r.log.Warn("No fixture for backend call", zap.String("backend", backend), zap.String("method", method))
+		return status.Errorf(codes.Unavailable, "fixture: no recorded response for %s", method)
+	}
+	ex := r.files[backend][i]
+	if ex.Error != nil {
+		return status.Error(parseCode(ex.Error.Code), ex.Error.Message)
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(ex.Response, out); err != nil {
+		return status.Errorf(codes.Internal, "fixture: invalid response for %s: %v", method, err)
+	}
+	return nil
+}
+
+// record stores the exchange, replacing an earlier one of the same
+// request, and rewrites the backend's fixture file.
+func (r *Recorder) record(backend, method string, in, out proto.Message, callErr error) error {
+	marshal := protojson.MarshalOptions{UseProtoNames: true}
+	req, err := marshal.Marshal(in)
+	if err != nil {
+		return err
+	}
+	ex := Exchange{Method: method, Request: req}
+	if callErr != nil {
+		st := status.Convert(callErr)
+		ex.Error = &Error{Code: st.Code().String(), Message: st.Message()}
+	} else if ex.Response, err = marshal.Marshal(out); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.find(backend, method, in); i >= 0 {
+		r.files[backend][i] = ex
+	} else {
+		r.files[backend] = append(r.files[backend], ex)
+	}
+	return r.write(backend)
+}
+
+// write replaces the fixture file of backend atomically.
+func (r *Recorder) write(backend string) error {
+	data, err := json.MarshalIndent(r.files[backend], "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(r.dir, backend+".json")
+	tmp, err := os.CreateTemp(r.dir, backend+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func parseCode(s string) codes.Code {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if c.String() == s {
+			return c
+		}
+	}
+	return codes.Unknown
+}
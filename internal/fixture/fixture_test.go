@@ -0,0 +1,102 @@
+package fixture_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/fixture"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+type inventoryServer struct {
+	pbInv.UnimplementedInventoryServiceServer
+}
+
+func (inventoryServer) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	if in.Id == "missing" {
+		return nil, status.Error(codes.NotFound, "product not found")
+	}
+	return &pbInv.GetResponse{Product: &pbInv.Product{Id: in.Id, Name: "Tea", Quantity: 3}}, nil
+}
+
+func dial(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pbInv.RegisterInventoryServiceServer(srv, inventoryServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestRecorder_RecordReplay tests that recorded responses and errors are replayed without the backend
+func TestRecorder_RecordReplay(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	ctx := context.Background()
+
+	rec, err := fixture.New(fixture.Config{Mode: fixture.Record, Dir: dir})
+	require.NoError(t, err)
+	client := pbInv.NewInventoryServiceClient(rec.Conn("inventory", dial(t)))
+	_, err = client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	_, err = client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"}) // recorded once
+	require.NoError(t, err)
+	_, err = client.GetProduct(ctx, &pbInv.GetRequest{Id: "missing"})
+	require.Error(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dir, "inventory.json"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), `"method": "/inventory.InventoryService/GetProduct"`))
+	assert.Contains(t, string(data), `"code": "NotFound"`)
+
+	replay, err := fixture.New(fixture.Config{Mode: fixture.Replay, Dir: dir})
+	require.NoError(t, err)
+	client = pbInv.NewInventoryServiceClient(replay.Conn("inventory", nil))
+
+	resp, err := client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	assert.Equal(t, "Tea", resp.Product.Name)
+	assert.Equal(t, int32(3), resp.Product.Quantity)
+
+	_, err = client.GetProduct(ctx, &pbInv.GetRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.GetProduct(ctx, &pbInv.GetRequest{Id: "p2"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, err = pbInv.NewInventoryServiceClient(replay.Conn("auth", nil)).GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// TestNew tests mode validation and that replay needs fixtures
+func TestNew(t *testing.T) {
+	rec, err := fixture.New(fixture.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, rec)
+	assert.Empty(t, rec.Mode())
+
+	_, err = fixture.New(fixture.Config{Mode: "rewind"})
+	assert.Error(t, err)
+
+	_, err = fixture.New(fixture.Config{Mode: fixture.Replay, Dir: t.TempDir()})
+	assert.ErrorContains(t, err, "no fixture files")
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "inventory.json"), []byte("{"), 0o644))
+	_, err = fixture.New(fixture.Config{Mode: fixture.Replay, Dir: dir})
+	assert.ErrorContains(t, err, "failed to parse fixture")
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/cookie"
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/token"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/status"
+)
+
+// AccountManager serves /auth/account: changing the password or email of
+// the caller and deleting their account. Every route needs a recent login.
+type AccountManager struct {
+	Service AccountService
+
+	// Auth revokes the session of the caller when their password changes or
+	// their account is deleted.
+	Auth AuthService
+
+	// Events publishes the account changes. May be nil.
+	Events *events.Emitter
+
+	// Cookies names and clears the token cookies. Nil uses the defaults.
+	Cookies *cookie.Jar
+
+	// MaxAuthAge is how long after entering their credentials users may
+	// change their account.
+	MaxAuthAge time.Duration
+}
+
+// DefaultMaxAuthAge is how recent a login RequireRecentLogin asks for by
+// default.
+const DefaultMaxAuthAge = 5 * time.Minute
+
+func NewAccountManager(service AccountService, auth AuthService, maxAuthAge time.Duration) *AccountManager {
+	if maxAuthAge <= 0 {
+		maxAuthAge = DefaultMaxAuthAge
+	}
+	return &AccountManager{
+		Service:    service,
+		Auth:       auth,
+		MaxAuthAge: maxAuthAge,
+	}
+}
+
+// RequireRecentLogin rejects requests whose access token was not obtained
+// by entering credentials within MaxAuthAge. It runs after the
+// Authenticator.
+func (am *AccountManager) RequireRecentLogin(next http.Handler) http.Handler {
+	return RequireRecentLogin(am.MaxAuthAge)(next)
+}
+
+// RequireRecentLogin rejects requests whose access token was not obtained
+// by entering credentials within maxAge, going by its auth_time claim, or
+// its iat claim without one. A stolen or long refreshed session can thus not
+// take over the account. It runs after the Authenticator. A maxAge of zero
+// means DefaultMaxAuthAge.
+func RequireRecentLogin(maxAge time.Duration) func(http.Handler) http.Handler {
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAuthAge
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := token.FromContext(r.Context())
+			if !ok || claims.UserID == "" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			authTime := claims.AuthTime
+			if authTime.IsZero() {
+				authTime = claims.IssuedAt
+			}
+			if authTime.IsZero() || time.Since(authTime) > maxAge {
+				// RFC 9470 step-up authentication challenge
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description="A recent login is required", max_age=%d`, int(maxAge.Seconds())))
+				http.Error(w, "Recent login required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type changePasswordBody struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	RefreshToken    string `json:"refresh_token"`
+}
+
+// ChangePasswordHandler serves PUT /auth/account/password. The session of
+// the caller is revoked and its cookies cleared, so that they log in again
+// with the new password.
+func (am *AccountManager) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CurrentPassword == "" || req.NewPassword == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		http.Error(w, "New password must differ from the current one", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := token.FromContext(r.Context())
+	err := am.Service.ChangePassword(r.Context(), &ChangePasswordRequest{
+		UserID:          claims.UserID,
+		CurrentPassword: req.CurrentPassword,
+		NewPassword:     req.NewPassword,
+	})
+	if err != nil {
+		am.fail(w, r, "change_password", err, "Failed to change password")
+		return
+	}
+	auditAccount(r, claims.UserID, "change_password")
+	am.Events.Emit(r.Context(), events.PasswordChanged, claims.UserID, nil)
+	am.endSession(w, r, claims.UserID, req.RefreshToken)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type changeEmailBody struct {
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+// ChangeEmailHandler serves PUT /auth/account/email.
+func (am *AccountManager) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req changeEmailBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil || addr.Address != req.Email {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := token.FromContext(r.Context())
+	err = am.Service.ChangeEmail(r.Context(), &ChangeEmailRequest{
+		UserID:   claims.UserID,
+		Password: req.Password,
+		Email:    req.Email,
+	})
+	if err != nil {
+		am.fail(w, r, "change_email", err, "Failed to change email")
+		return
+	}
+	auditAccount(r, claims.UserID, "change_email")
+	am.Events.Emit(r.Context(), events.EmailChanged, claims.UserID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type deleteAccountBody struct {
+	Password     string `json:"password"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// DeleteAccountHandler serves DELETE /auth/account and ends the session of
+// the caller.
+func (am *AccountManager) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var req deleteAccountBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := token.FromContext(r.Context())
+	err := am.Service.DeleteAccount(r.Context(), &DeleteAccountRequest{
+		UserID:   claims.UserID,
+		Password: req.Password,
+	})
+	if err != nil {
+		am.fail(w, r, "delete_account", err, "Failed to delete account")
+		return
+	}
+	auditAccount(r, claims.UserID, "delete_account")
+	am.Events.Emit(r.Context(), events.UserDeleted, claims.UserID, nil)
+	am.endSession(w, r, claims.UserID, req.RefreshToken)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fail answers with the HTTP status of the gRPC error of a failed account
+// change, and logs it along with the audit fields.
+func (am *AccountManager) fail(w http.ResponseWriter, r *http.Request, action string, err error, message string) {
+	claims, _ := token.FromContext(r.Context())
+	logger.Logger().Warn("Account change failed",
+		zap.String("action", action),
+		zap.String("user_id", claims.UserID),
+		zap.String("code", status.Code(err).String()),
+		zap.Error(err),
+	)
+	failRPC(w, err, message)
+}
+
+// endSession revokes the refresh token of the caller, from the request body
+// or its cookie, and clears the token cookies.
+func (am *AccountManager) endSession(w http.ResponseWriter, r *http.Request, userID, refreshToken string) {
+	if refreshToken == "" {
+		refreshToken = am.Cookies.Refresh(r)
+	}
+	if refreshToken != "" {
+		resp, err := am.Auth.Revoke(r.Context(), &pb.RevokeRequest{RefreshToken: refreshToken, UserId: userID})
+		if err == nil && resp != nil && resp.Error != "" {
+			err = errors.New(resp.Error)
+		}
+		if err != nil {
+			logger.Logger().Error("Failed to revoke session after account change", zap.String("user_id", userID), zap.Error(err))
+		}
+	}
+	am.Cookies.Clear(w, r)
+}
+
+// auditAccount logs who changed their account, how and from where.
+func auditAccount(r *http.Request, userID, action string) {
+	fields := []zap.Field{zap.String("user_id", userID), zap.String("action", action)}
+	if rid, ok := requestid.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("request_id", rid))
+	}
+	if ip, ok := realip.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("client_ip", ip))
+	}
+	logger.Logger().Info("Account changed", fields...)
+}
@@ -0,0 +1,150 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mockAccountService is a mock implementation of handlers.AccountService
+type mockAccountService struct {
+	passwords []*handlers.ChangePasswordRequest
+	emails    []*handlers.ChangeEmailRequest
+	deletes   []*handlers.DeleteAccountRequest
+	err       error
+}
+
+func (m *mockAccountService) ChangePassword(ctx context.Context, in *handlers.ChangePasswordRequest) error {
+	m.passwords = append(m.passwords, in)
+	return m.err
+}
+
+func (m *mockAccountService) ChangeEmail(ctx context.Context, in *handlers.ChangeEmailRequest) error {
+	m.emails = append(m.emails, in)
+	return m.err
+}
+
+func (m *mockAccountService) DeleteAccount(ctx context.Context, in *handlers.DeleteAccountRequest) error {
+	m.deletes = append(m.deletes, in)
+	return m.err
+}
+
+// setupAccountRouter creates a router with the account routes behind a verifying authenticator
+func setupAccountRouter(t *testing.T, accounts handlers.AccountService, auth handlers.AuthService) *chi.Mux {
+	verifier, err := token.NewVerifier(token.Config{HMACSecret: "account-secret"})
+	require.NoError(t, err)
+	authenticator := handlers.NewAuthenticator(verifier, false)
+	am := handlers.NewAccountManager(accounts, auth, 5*time.Minute)
+
+	r := chi.NewRouter()
+	r.Route("/auth/account", func(r chi.Router) {
+		r.Use(authenticator.Middleware)
+		r.Use(am.RequireRecentLogin)
+		r.Put("/password", am.ChangePasswordHandler)
+		r.Put("/email", am.ChangeEmailHandler)
+		r.Delete("/", am.DeleteAccountHandler)
+	})
+	return r
+}
+
+func accountRequest(method, path, body string, claims map[string]any) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+generateSignedJWT("account-secret", claims))
+	return req
+}
+
+// TestAccount_ChangePassword tests that a password change reaches auth_service and revokes the session
+func TestAccount_ChangePassword(t *testing.T) {
+	var revoked []*pb.RevokeRequest
+	auth := &mockAuthService{
+		revokeFunc: func(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error) {
+			revoked = append(revoked, in)
+			return &pb.RevokeResponse{}, nil
+		},
+	}
+	accounts := &mockAccountService{}
+	r := setupAccountRouter(t, accounts, auth)
+
+	now := time.Now()
+	req := accountRequest(http.MethodPut, "/auth/account/password", `{"current_password": "old", "new_password": "n3w-passw0rd"}`,
+		map[string]any{"uid": "user-1", "exp": now.Add(time.Hour).Unix(), "iat": now.Unix()})
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-1"})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Len(t, accounts.passwords, 1)
+	assert.Equal(t, handlers.ChangePasswordRequest{UserID: "user-1", CurrentPassword: "old", NewPassword: "n3w-passw0rd"}, *accounts.passwords[0])
+	require.Len(t, revoked, 1)
+	assert.Equal(t, "refresh-1", revoked[0].RefreshToken)
+	assert.Equal(t, "user-1", revoked[0].UserId)
+	for _, c := range w.Result().Cookies() {
+		assert.Equal(t, -1, c.MaxAge, c.Name)
+	}
+}
+
+// TestAccount_RequireRecentLogin tests that account changes need a login within the max auth age
+func TestAccount_RequireRecentLogin(t *testing.T) {
+	accounts := &mockAccountService{}
+	r := setupAccountRouter(t, accounts, &mockAuthService{})
+	now := time.Now()
+
+	// refreshed a minute ago, but the credentials were entered an hour ago
+	req := accountRequest(http.MethodPut, "/auth/account/email", `{"password": "secret", "email": "new@example.com"}`,
+		map[string]any{"uid": "user-1", "exp": now.Add(time.Hour).Unix(), "iat": now.Add(-time.Minute).Unix(), "auth_time": now.Add(-time.Hour).Unix()})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="insufficient_user_authentication"`)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "max_age=300")
+
+	req = accountRequest(http.MethodPut, "/auth/account/email", `{"password": "secret", "email": "new@example.com"}`,
+		map[string]any{"uid": "user-1", "exp": now.Add(time.Hour).Unix()})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "tokens without auth_time or iat are never recent")
+	assert.Empty(t, accounts.emails)
+
+	req = accountRequest(http.MethodPut, "/auth/account/email", `{"password": "secret", "email": "new@example.com"}`,
+		map[string]any{"uid": "user-1", "exp": now.Add(time.Hour).Unix(), "auth_time": now.Add(-time.Minute).Unix()})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	require.Len(t, accounts.emails, 1)
+	assert.Equal(t, "new@example.com", accounts.emails[0].Email)
+}
+
+// TestAccount_Delete tests account deletion and the mapping of auth_service errors
+func TestAccount_Delete(t *testing.T) {
+	accounts := &mockAccountService{err: status.Error(codes.PermissionDenied, "wrong password")}
+	r := setupAccountRouter(t, accounts, &mockAuthService{})
+	claims := map[string]any{"uid": "user-1", "exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix()}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, accountRequest(http.MethodDelete, "/auth/account", `{"password": "guess"}`, claims))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "wrong password")
+
+	accounts.err = nil
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, accountRequest(http.MethodDelete, "/auth/account", `{"password": "secret"}`, claims))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	require.Len(t, accounts.deletes, 2)
+	assert.Equal(t, "user-1", accounts.deletes[1].UserID)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, accountRequest(http.MethodDelete, "/auth/account", `{}`, claims))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/backend"
+	"github.com/andro-kes/gateway/internal/bodydump"
+	"github.com/andro-kes/gateway/internal/fault"
+	"github.com/andro-kes/gateway/internal/feature"
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/maintenance"
+	"github.com/andro-kes/gateway/internal/quota"
+	"github.com/andro-kes/gateway/internal/respcache"
+	"github.com/andro-kes/gateway/internal/schema"
+	"github.com/andro-kes/gateway/internal/webhook"
+	"github.com/go-chi/chi/v5"
+)
+
+type AdminManager struct {
+	Backends    *backend.Manager
+	Maintenance *maintenance.Mode
+	BodyDump    *bodydump.Dumper
+
+	// Webhooks serves the /admin/webhooks routes. May be nil.
+	Webhooks *webhook.Dispatcher
+
+	// Quotas serves the /admin/quotas routes. May be nil.
+	Quotas *quota.Meter
+
+	// Schemas serves GET /admin/schemas. May be nil.
+	Schemas *schema.Cache
+
+	// Features serves the /admin/features routes. May be nil.
+	Features *feature.Flags
+
+	// Faults serves the /admin/faults routes. May be nil.
+	Faults *fault.Injector
+
+	// Cache serves the /admin/cache routes. May be nil.
+	Cache *respcache.Cache
+}
+
+func NewAdminManager(backends *backend.Manager, mode *maintenance.Mode, dumper *bodydump.Dumper) *AdminManager {
+	return &AdminManager{
+		Backends:    backends,
+		Maintenance: mode,
+		BodyDump:    dumper,
+	}
+}
+
+// BackendsHandler reports the connection state of every backend pool.
+func (am *AdminManager) BackendsHandler(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{
+		"backends": am.Backends.Status(),
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// MaintenanceHandler reports the maintenance mode state.
+func (am *AdminManager) MaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if err := render.Write(w, r, http.StatusOK, am.Maintenance.Status()); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetMaintenanceHandler enables or disables maintenance mode.
+func (am *AdminManager) SetMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var req maintenance.Status
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	am.Maintenance.Set(req)
+	am.MaintenanceHandler(w, r)
+}
+
+// BodyDumpsHandler lists the routes whose bodies are being dumped.
+func (am *AdminManager) BodyDumpsHandler(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{
+		"sessions": am.BodyDump.Sessions(),
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// EnableBodyDumpHandler starts dumping bodies of a route for the given number of minutes.
+func (am *AdminManager) EnableBodyDumpHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Route   string `json:"route"`
+		Minutes int    `json:"minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	session, err := am.BodyDump.Enable(req.Route, time.Duration(req.Minutes)*time.Minute)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := render.Write(w, r, http.StatusOK, session); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// DisableBodyDumpHandler stops dumping bodies of the route given in the query.
+func (am *AdminManager) DisableBodyDumpHandler(w http.ResponseWriter, r *http.Request) {
+	if !am.BodyDump.Disable(r.URL.Query().Get("route")) {
+		http.Error(w, "no body dump for route", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhooksHandler lists the registered webhook endpoints, without secrets.
+func (am *AdminManager) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{
+		"endpoints": am.Webhooks.Endpoints(),
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// RegisterWebhookHandler adds or replaces a webhook endpoint.
+func (am *AdminManager) RegisterWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req webhook.Endpoint
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := am.Webhooks.Register(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	am.WebhooksHandler(w, r)
+}
+
+// RemoveWebhookHandler removes the webhook endpoint named in the path.
+func (am *AdminManager) RemoveWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !am.Webhooks.Remove(chi.URLParam(r, "id")) {
+		http.Error(w, "no such webhook", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveriesHandler reports the state of recent webhook deliveries,
+// newest first.
+func (am *AdminManager) WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{
+		"deliveries": am.Webhooks.Deliveries(),
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// QuotaHandler reports the budgets of the client named in the path.
+func (am *AdminManager) QuotaHandler(w http.ResponseWriter, r *http.Request) {
+	client := chi.URLParam(r, "client")
+	usage, err := am.Quotas.Usage(r.Context(), client)
+	if err != nil {
+		http.Error(w, "failed to read quota", http.StatusInternalServerError)
+		return
+	}
+	out := map[string]any{
+		"client": client,
+		"quotas": usage,
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ResetQuotaHandler clears the current budgets of the client named in the
+// path.
+func (am *AdminManager) ResetQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	if err := am.Quotas.Reset(r.Context(), chi.URLParam(r, "client")); err != nil {
+		http.Error(w, "failed to reset quota", http.StatusInternalServerError)
+		return
+	}
+	am.QuotaHandler(w, r)
+}
+
+// SchemasHandler reports the services of the backend schemas fetched with
+// reflection.
+func (am *AdminManager) SchemasHandler(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{
+		"schemas": am.Schemas.Status(),
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// FeaturesHandler reports the state of every feature flag.
+func (am *AdminManager) FeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{
+		"features": am.Features.List(),
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetFeatureHandler toggles the feature flag named in the path.
+func (am *AdminManager) SetFeatureHandler(w http.ResponseWriter, r *http.Request) {
+	var req feature.Flag
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	err := am.Features.Set(r.Context(), chi.URLParam(r, "name"), req)
+	if errors.Is(err, feature.ErrUnknown) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to store feature flag", http.StatusInternalServerError)
+		return
+	}
+	am.FeaturesHandler(w, r)
+}
+
+// FaultsHandler reports the state of fault injection.
+func (am *AdminManager) FaultsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := render.Write(w, r, http.StatusOK, am.Faults.Status()); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetFaultsHandler enables or disables fault injection and replaces its
+// rules.
+func (am *AdminManager) SetFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	var req fault.Status
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := am.Faults.Set(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	am.FaultsHandler(w, r)
+}
+
+// CacheHandler reports the cached routes and the cached responses whose URL
+// matches the pattern query parameter, in which * matches any text.
+func (am *AdminManager) CacheHandler(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{
+		"routes":  am.Cache.Routes(),
+		"entries": am.Cache.Entries(r.URL.Query().Get("pattern")),
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PurgeCacheHandler drops the cached responses whose URL matches the
+// pattern query parameter, of the route named by the route parameter, or
+// both.
+func (am *AdminManager) PurgeCacheHandler(w http.ResponseWriter, r *http.Request) {
+	pattern, route := r.URL.Query().Get("pattern"), r.URL.Query().Get("route")
+	if pattern == "" && route == "" {
+		http.Error(w, "pattern or route is required, pattern=* purges everything", http.StatusBadRequest)
+		return
+	}
+	out := map[string]any{
+		"purged": am.Cache.Purge(pattern, route),
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetCacheRouteHandler switches caching of a route on or off.
+func (am *AdminManager) SetCacheRouteHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PathPrefix string `json:"path_prefix"`
+		Enabled    bool   `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := am.Cache.SetEnabled(req.PathPrefix, req.Enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	out := map[string]any{
+		"routes": am.Cache.Routes(),
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,327 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/backend"
+	"github.com/andro-kes/gateway/internal/bodydump"
+	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/maintenance"
+	"github.com/andro-kes/gateway/internal/quota"
+	"github.com/andro-kes/gateway/internal/respcache"
+	"github.com/andro-kes/gateway/internal/webhook"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// setupAdminTestRouter creates a test router with the admin handlers
+func setupAdminTestRouter(t *testing.T) *chi.Mux {
+	backends, err := backend.NewManager(nil, backend.Defaults{Address: "localhost:1"}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { backends.Close() })
+
+	mode, err := maintenance.New(maintenance.Config{})
+	require.NoError(t, err)
+
+	webhooks, err := webhook.New(webhook.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() { webhooks.Close(context.Background()) })
+
+	adminManager := handlers.NewAdminManager(backends, mode, bodydump.New(bodydump.Config{}))
+	adminManager.Webhooks = webhooks
+	adminManager.Quotas = quota.NewWithStore(quota.Config{Header: "X-API-Key", Keys: []string{"shop"}, Limits: quota.Limits{Daily: 5}}, quota.NewMemoryStore(0))
+	r := chi.NewRouter()
+	r.Use(adminManager.Quotas.Middleware)
+	r.Use(mode.Middleware)
+	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(handlers.RequireAdminToken("admin-secret"))
+		r.Get("/backends", adminManager.BackendsHandler)
+		r.Get("/maintenance", adminManager.MaintenanceHandler)
+		r.Put("/maintenance", adminManager.SetMaintenanceHandler)
+		r.Get("/debug/bodydump", adminManager.BodyDumpsHandler)
+		r.Post("/debug/bodydump", adminManager.EnableBodyDumpHandler)
+		r.Delete("/debug/bodydump", adminManager.DisableBodyDumpHandler)
+		r.Get("/webhooks", adminManager.WebhooksHandler)
+		r.Post("/webhooks", adminManager.RegisterWebhookHandler)
+		r.Delete("/webhooks/{id}", adminManager.RemoveWebhookHandler)
+		r.Get("/webhooks/deliveries", adminManager.WebhookDeliveriesHandler)
+		r.Get("/quotas/{client}", adminManager.QuotaHandler)
+		r.Delete("/quotas/{client}", adminManager.ResetQuotaHandler)
+	})
+	return r
+}
+
+// TestBackendsHandler_Success tests listing backend connection states
+func TestBackendsHandler_Success(t *testing.T) {
+	ts := httptest.NewServer(setupAdminTestRouter(t))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/admin/backends", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var respBody struct {
+		Backends []backend.Status `json:"backends"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	require.NoError(t, err)
+	require.Len(t, respBody.Backends, 2)
+	assert.Equal(t, "auth", respBody.Backends[0].Name)
+	assert.Equal(t, "localhost:1", respBody.Backends[0].Address)
+	assert.Len(t, respBody.Backends[0].Conns, 1)
+	assert.NotEmpty(t, respBody.Backends[0].Conns[0].State)
+}
+
+// TestAdminRoutes_TokenRequired tests that admin routes reject missing or wrong tokens
+func TestAdminRoutes_TokenRequired(t *testing.T) {
+	ts := httptest.NewServer(setupAdminTestRouter(t))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		header   string
+		wantCode int
+	}{
+		{name: "missing token", header: "", wantCode: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer nope", wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", ts.URL+"/admin/backends", nil)
+			require.NoError(t, err)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+		})
+	}
+}
+
+// TestMaintenanceHandler_Toggle tests enabling and disabling maintenance mode through the admin API
+func TestMaintenanceHandler_Toggle(t *testing.T) {
+	ts := httptest.NewServer(setupAdminTestRouter(t))
+	defer ts.Close()
+
+	setMode := func(body string) maintenance.Status {
+		req, err := http.NewRequest("PUT", ts.URL+"/admin/maintenance", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var st maintenance.Status
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+		return st
+	}
+
+	st := setMode(`{"enabled": true, "message": "upgrading", "retry_after_seconds": 120}`)
+	assert.True(t, st.Enabled)
+	assert.Equal(t, "upgrading", st.Message)
+	assert.NotNil(t, st.Since)
+
+	resp, err := http.Get(ts.URL + "/ping")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "120", resp.Header.Get("Retry-After"))
+
+	st = setMode(`{"enabled": false}`)
+	assert.False(t, st.Enabled)
+
+	resp, err = http.Get(ts.URL + "/ping")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// TestBodyDumpHandlers tests enabling, listing and disabling body dumps through the admin API
+func TestBodyDumpHandlers(t *testing.T) {
+	ts := httptest.NewServer(setupAdminTestRouter(t))
+	defer ts.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := do("POST", "/admin/debug/bodydump", `{"route": "/inventory/update", "minutes": 5}`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do("POST", "/admin/debug/bodydump", `{"route": "inventory", "minutes": 5}`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do("GET", "/admin/debug/bodydump", "")
+	var list struct {
+		Sessions []bodydump.Session `json:"sessions"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	resp.Body.Close()
+	require.Len(t, list.Sessions, 1)
+	assert.Equal(t, "/inventory/update", list.Sessions[0].Route)
+
+	resp = do("DELETE", "/admin/debug/bodydump?route=/inventory/update", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do("DELETE", "/admin/debug/bodydump?route=/inventory/update", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// TestWebhooksHandlers tests registering, listing and removing webhooks
+func TestWebhooksHandlers(t *testing.T) {
+	ts := httptest.NewServer(setupAdminTestRouter(t))
+	defer ts.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := do("POST", "/admin/webhooks", `{"id":"shop","url":"https://shop.example.com/hook","secret":"s3cret","events":["product.created"]}`)
+	var result struct {
+		Endpoints []map[string]any `json:"endpoints"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, result.Endpoints, 1)
+	assert.Equal(t, "shop", result.Endpoints[0]["id"])
+	assert.NotContains(t, result.Endpoints[0], "secret")
+
+	resp = do("POST", "/admin/webhooks", `{"id":"bad","url":"https://example.com"}`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do("GET", "/admin/webhooks/deliveries", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do("DELETE", "/admin/webhooks/shop", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = do("DELETE", "/admin/webhooks/shop", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// TestQuotaHandlers tests viewing and resetting the quota of a client
+func TestQuotaHandlers(t *testing.T) {
+	ts := httptest.NewServer(setupAdminTestRouter(t))
+	defer ts.Close()
+
+	do := func(method, path string) map[string]any {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var out map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+		return out
+	}
+	used := func(out map[string]any) float64 {
+		quotas := out["quotas"].([]any)
+		require.Len(t, quotas, 1)
+		return quotas[0].(map[string]any)["used"].(float64)
+	}
+
+	for range 3 {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/ping", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-API-Key", "shop")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	out := do(http.MethodGet, "/admin/quotas/shop")
+	assert.Equal(t, "shop", out["client"])
+	assert.Equal(t, float64(3), used(out))
+	assert.Equal(t, float64(0), used(do(http.MethodDelete, "/admin/quotas/shop")))
+}
+
+// TestCacheHandlers tests listing, purging and toggling cached routes through the admin API
+func TestCacheHandlers(t *testing.T) {
+	cache, err := respcache.New(respcache.Config{Rules: []respcache.Rule{{PathPrefix: "/inventory/get", TTL: time.Minute, Shared: true}}})
+	require.NoError(t, err)
+	adminManager := handlers.NewAdminManager(nil, nil, nil)
+	adminManager.Cache = cache
+	r := chi.NewRouter()
+	r.With(cache.Middleware).Get("/inventory/get", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"product":{"id":"` + r.URL.Query().Get("id") + `"}}`))
+	})
+	r.Get("/admin/cache", adminManager.CacheHandler)
+	r.Delete("/admin/cache", adminManager.PurgeCacheHandler)
+	r.Put("/admin/cache/routes", adminManager.SetCacheRouteHandler)
+
+	serve := func(method, path, body string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var out map[string]any
+		json.Unmarshal(w.Body.Bytes(), &out)
+		return w.Code, out
+	}
+	for _, id := range []string{"p1", "p1", "p2"} {
+		serve(http.MethodGet, "/inventory/get?id="+id, "")
+	}
+
+	code, out := serve(http.MethodGet, "/admin/cache?pattern=*p1", "")
+	require.Equal(t, http.StatusOK, code)
+	entries := out["entries"].([]any)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "/inventory/get?id=p1", entries[0].(map[string]any)["url"])
+	assert.Equal(t, float64(1), entries[0].(map[string]any)["hits"])
+	assert.Equal(t, float64(2), out["routes"].([]any)[0].(map[string]any)["entries"])
+
+	code, _ = serve(http.MethodDelete, "/admin/cache", "")
+	assert.Equal(t, http.StatusBadRequest, code, "purging everything takes pattern=*")
+	code, out = serve(http.MethodDelete, "/admin/cache?pattern=*p2", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), out["purged"])
+
+	code, _ = serve(http.MethodPut, "/admin/cache/routes", `{"path_prefix": "/catalog", "enabled": false}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, out = serve(http.MethodPut, "/admin/cache/routes", `{"path_prefix": "/inventory/get", "enabled": false}`)
+	require.Equal(t, http.StatusOK, code)
+	route := out["routes"].([]any)[0].(map[string]any)
+	assert.Equal(t, false, route["enabled"])
+	assert.Equal(t, float64(0), route["entries"])
+}
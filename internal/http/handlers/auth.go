@@ -1,27 +1,72 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/cookie"
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/mfa"
+	"github.com/andro-kes/gateway/internal/rotation"
+	"go.uber.org/zap"
 )
 
 type AuthManager struct {
-	Client pb.AuthServiceClient
+	Service AuthService
+
+	// Events publishes user.registered and user.login events. May be nil.
+	Events *events.Emitter
+
+	// Rotations detects reuse of rotated refresh tokens. May be nil.
+	Rotations *rotation.Tracker
+
+	// Cookies names and sets the token cookies. Nil uses the defaults.
+	Cookies *cookie.Jar
+
+	// RememberTTL is the lifetime of the refresh token cookie of logins with
+	// remember_me. When zero it follows the token expiry.
+	RememberTTL time.Duration
+
+	// ResponseMode is how login and refresh return tokens to clients that
+	// send no X-Auth-Response header: ResponseCookies (default) or
+	// ResponseTokens.
+	ResponseMode string
+
+	// MFA checks whether users have MFA set up and verifies their codes.
+	// Used when Challenges is set.
+	MFA MFAService
+
+	// Challenges issues the challenge tokens of logins of users with MFA.
+	// Nil disables MFA.
+	Challenges *mfa.Challenges
 }
 
-func NewAuthManager(client pb.AuthServiceClient) *AuthManager {
+// Response modes of login and refresh.
+const (
+	// ResponseCookies sets both tokens in HttpOnly cookies, for browsers.
+	// The access token is also returned in the body.
+	ResponseCookies = "cookies"
+	// ResponseTokens returns both tokens in the body and sets no cookie,
+	// for native and API clients.
+	ResponseTokens = "tokens"
+)
+
+func NewAuthManager(service AuthService) *AuthManager {
 	return &AuthManager{
-		Client: client,
+		Service: service,
 	}
 }
 
 func (am *AuthManager) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var req pb.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	opts, err := decodeLogin(w, r, &req)
+	if err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 	defer r.Body.Close()
@@ -31,58 +76,56 @@ func (am *AuthManager) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := am.Client.Login(r.Context(), &req)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	mode, ok := am.responseMode(r)
+	if !ok {
+		http.Error(w, "Unknown X-Auth-Response mode", http.StatusBadRequest)
 		return
 	}
 
-	if resp.RefreshToken != "" {
-		setRefreshTokenInCookie(w, r, resp)
+	resp, err := am.Service.Login(withRemember(r.Context(), opts.RememberMe), &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-
-	if resp.AccessToken != "" {
-		setAccessTokenInCookie(w, r, resp)
+	if am.Challenges != nil && am.challenge(w, r, resp, opts.RememberMe) {
+		return
 	}
+	am.loggedIn(w, r, mode, resp, opts.RememberMe)
+}
 
-	out := map[string]any{
-		"user_id": resp.UserId,
-	}
-	if resp.AccessToken != "" {
-		out["access_token"] = resp.AccessToken
-	}
-	if resp.AccessExpiresIn != nil {
-		out["access_expires_in_seconds"] = int64(resp.AccessExpiresIn.AsDuration().Seconds())
-	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(out); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		return
+// loggedIn logs and publishes a completed login and hands its tokens out.
+func (am *AuthManager) loggedIn(w http.ResponseWriter, r *http.Request, mode string, resp *pb.TokenResponse, remember *bool) {
+	auditLogin(r, resp.UserId, remember)
+	var data map[string]bool
+	if remember != nil {
+		data = map[string]bool{"remember_me": *remember}
 	}
+	am.Events.Emit(r.Context(), events.UserLogin, resp.UserId, data)
+	am.writeTokens(w, r, mode, resp, remember)
 }
 
 func (am *AuthManager) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var req pb.RegisterRequest
 
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(w, r, &req)
 	if err != nil {
-		http.Error(w, "Failed to decode request body", http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
 	defer r.Body.Close()
 
-	resp, err := am.Client.Register(r.Context(), &req)
+	resp, err := am.Service.Register(r.Context(), &req)
 	if err != nil {
 		http.Error(w, "Failed to register user", http.StatusInternalServerError)
 		return
 	}
+	am.Events.Emit(r.Context(), events.UserRegistered, resp.UserId, map[string]string{"username": req.Username})
 
 	out := map[string]any{
 		"user_id": resp.UserId,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(out); err != nil {
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
@@ -91,24 +134,93 @@ func (am *AuthManager) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 func (am *AuthManager) RefreshHandler(w http.ResponseWriter, r *http.Request) {
 	var req pb.RefreshRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Failed to decode requets body", http.StatusBadRequest)
+	if err := decodeRequest(w, r, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 	defer r.Body.Close()
 
-	resp, err := am.Client.Refresh(r.Context(), &req)
+	mode, ok := am.responseMode(r)
+	if !ok {
+		http.Error(w, "Unknown X-Auth-Response mode", http.StatusBadRequest)
+		return
+	}
+
+	if req.RefreshToken == "" {
+		req.RefreshToken = am.Cookies.Refresh(r)
+	}
+
+	reuse, err := am.Rotations.Check(r.Context(), req.RefreshToken)
+	if err != nil {
+		logger.Logger().Warn("Failed to check refresh token rotation", zap.Error(err))
+	}
+	if reuse != nil {
+		am.revokeSession(r, reuse)
+		am.Cookies.Clear(w, r)
+		http.Error(w, "Refresh token already used; session revoked", http.StatusUnauthorized)
+		return
+	}
+
+	var remember *bool
+	if v, ok := am.Cookies.Remember(r); ok {
+		remember = &v
+	}
+	resp, err := am.Service.Refresh(withRemember(r.Context(), remember), &req)
 	if err != nil {
 		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
 		return
 	}
 
-	if resp.RefreshToken != "" {
-		setRefreshTokenInCookie(w, r, resp)
+	if err := am.Rotations.Rotated(r.Context(), req.RefreshToken, resp.RefreshToken, resp.UserId); err != nil {
+		logger.Logger().Warn("Failed to record refresh token rotation", zap.Error(err))
 	}
 
-	if resp.AccessToken != "" {
-		setAccessTokenInCookie(w, r, resp)
+	am.writeTokens(w, r, mode, resp, remember)
+}
+
+// revokeSession revokes the live refresh token of the session a reused
+// token belongs to.
+func (am *AuthManager) revokeSession(r *http.Request, reuse *rotation.Reuse) {
+	zl := logger.Logger()
+	zl.Warn("Rotated refresh token reused, revoking session", zap.String("user_id", reuse.UserID))
+	if reuse.Live == "" {
+		return
+	}
+	resp, err := am.Service.Revoke(r.Context(), &pb.RevokeRequest{RefreshToken: reuse.Live, UserId: reuse.UserID})
+	if err == nil && resp != nil && resp.Error != "" {
+		err = errors.New(resp.Error)
+	}
+	if err != nil {
+		zl.Error("Failed to revoke session of reused refresh token", zap.String("user_id", reuse.UserID), zap.Error(err))
+	}
+}
+
+// responseMode returns the mode requested by the X-Auth-Response header or
+// configured, and false for an unknown mode.
+func (am *AuthManager) responseMode(r *http.Request) (string, bool) {
+	mode := strings.ToLower(r.Header.Get("X-Auth-Response"))
+	if mode == "" {
+		mode = am.ResponseMode
+	}
+	switch mode {
+	case "":
+		return ResponseCookies, true
+	case ResponseCookies, ResponseTokens:
+		return mode, true
+	}
+	return "", false
+}
+
+// writeTokens returns the tokens of resp in the given response mode.
+// remember is the remember-me choice of the login, if made.
+func (am *AuthManager) writeTokens(w http.ResponseWriter, r *http.Request, mode string, resp *pb.TokenResponse, remember *bool) {
+	if mode == ResponseCookies {
+		if resp.RefreshToken != "" {
+			am.setRefreshTokenInCookie(w, r, resp, remember)
+		}
+		if resp.AccessToken != "" {
+			am.setAccessTokenInCookie(w, r, resp, remember)
+		}
 	}
 
 	out := map[string]any{
@@ -120,57 +232,64 @@ func (am *AuthManager) RefreshHandler(w http.ResponseWriter, r *http.Request) {
 	if resp.AccessExpiresIn != nil {
 		out["access_expires_in_seconds"] = int64(resp.AccessExpiresIn.AsDuration().Seconds())
 	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(out); err != nil {
+	if mode == ResponseTokens {
+		if resp.RefreshToken != "" {
+			out["refresh_token"] = resp.RefreshToken
+		}
+		if resp.RefreshExpiresIn != nil {
+			out["refresh_expires_in_seconds"] = int64(resp.RefreshExpiresIn.AsDuration().Seconds())
+		}
+	}
+	w.Header().Add("Vary", "X-Auth-Response")
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
 }
 
-func setRefreshTokenInCookie(w http.ResponseWriter, r *http.Request, resp *pb.TokenResponse) {
-	c := &http.Cookie{
-		Name:     "refresh_token",
-		Value:    resp.RefreshToken,
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		Secure:   r.TLS != nil,
-	}
+// setRefreshTokenInCookie sets the refresh token cookie. It expires with
+// the token, or after RememberTTL when the login asked to be remembered, and
+// with the browser session when it asked not to be.
+func (am *AuthManager) setRefreshTokenInCookie(w http.ResponseWriter, r *http.Request, resp *pb.TokenResponse, remember *bool) {
+	var expires time.Time
 	if resp.RefreshExpiresIn != nil {
-		c.Expires = time.Now().Add(resp.RefreshExpiresIn.AsDuration())
+		expires = time.Now().Add(resp.RefreshExpiresIn.AsDuration())
 	}
-	http.SetCookie(w, c)
+	if remember != nil {
+		switch {
+		case !*remember:
+			expires = time.Time{}
+		case am.RememberTTL > 0:
+			expires = time.Now().Add(am.RememberTTL)
+		}
+		am.Cookies.SetRemember(w, r, *remember, expires)
+	}
+	am.Cookies.SetRefresh(w, r, resp.RefreshToken, expires)
 }
 
-func setAccessTokenInCookie(w http.ResponseWriter, r *http.Request, resp *pb.TokenResponse) {
-	ac := &http.Cookie{
-		Name:     "access_token",
-		Value:    resp.AccessToken,
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		Secure:   r.TLS != nil,
-	}
+func (am *AuthManager) setAccessTokenInCookie(w http.ResponseWriter, r *http.Request, resp *pb.TokenResponse, remember *bool) {
+	expires := time.Now().Add(5 * time.Minute)
 	if resp.AccessExpiresIn != nil {
-		ac.Expires = time.Now().Add(resp.AccessExpiresIn.AsDuration())
-	} else {
-		ac.Expires = time.Now().Add(5 * time.Minute)
+		expires = time.Now().Add(resp.AccessExpiresIn.AsDuration())
+	}
+	if remember != nil && !*remember {
+		expires = time.Time{}
 	}
-	http.SetCookie(w, ac)
+	am.Cookies.SetAccess(w, r, resp.AccessToken, expires)
 
 	w.Header().Set("Authorization", "Bearer "+resp.AccessToken)
 	w.Header().Set("Access-Control-Expose-Headers", "Authorization")
 }
 
 func (am *AuthManager) RevokeHandler(w http.ResponseWriter, r *http.Request) {
-	var req *pb.RevokeRequest
+	var req pb.RevokeRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Failed to decode request body", http.StatusBadRequest)
+	if err := decodeRequest(w, r, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 
-	resp, err := am.Client.Revoke(r.Context(), req)
+	resp, err := am.Service.Revoke(r.Context(), &req)
 	if err != nil {
 		errMsg := "Failed to revoke token"
 		if resp != nil && resp.Error != "" {
@@ -181,8 +300,7 @@ func (am *AuthManager) RevokeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	out := map[string]any{"Message": "Token revoked"}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(out); err != nil {
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
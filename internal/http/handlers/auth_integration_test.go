@@ -3,63 +3,75 @@ package handlers_test
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/clientcert"
+	"github.com/andro-kes/gateway/internal/cookie"
 	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/interceptor"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/rotation"
+	"github.com/andro-kes/gateway/internal/signature"
+	"github.com/andro-kes/gateway/internal/token"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/durationpb"
 )
 
-// mockAuthServiceClient is a mock implementation of pb.AuthServiceClient
-type mockAuthServiceClient struct {
-	pb.AuthServiceClient
-	loginFunc    func(ctx context.Context, in *pb.LoginRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error)
-	registerFunc func(ctx context.Context, in *pb.RegisterRequest, opts ...grpc.CallOption) (*pb.RegisterResponse, error)
-	refreshFunc  func(ctx context.Context, in *pb.RefreshRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error)
-	revokeFunc   func(ctx context.Context, in *pb.RevokeRequest, opts ...grpc.CallOption) (*pb.RevokeResponse, error)
+// mockAuthService is a mock implementation of handlers.AuthService
+type mockAuthService struct {
+	loginFunc    func(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error)
+	registerFunc func(ctx context.Context, in *pb.RegisterRequest) (*pb.RegisterResponse, error)
+	refreshFunc  func(ctx context.Context, in *pb.RefreshRequest) (*pb.TokenResponse, error)
+	revokeFunc   func(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error)
 }
 
-func (m *mockAuthServiceClient) Login(ctx context.Context, in *pb.LoginRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error) {
+func (m *mockAuthService) Login(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error) {
 	if m.loginFunc != nil {
-		return m.loginFunc(ctx, in, opts...)
+		return m.loginFunc(ctx, in)
 	}
 	return nil, fmt.Errorf("loginFunc not implemented")
 }
 
-func (m *mockAuthServiceClient) Register(ctx context.Context, in *pb.RegisterRequest, opts ...grpc.CallOption) (*pb.RegisterResponse, error) {
+func (m *mockAuthService) Register(ctx context.Context, in *pb.RegisterRequest) (*pb.RegisterResponse, error) {
 	if m.registerFunc != nil {
-		return m.registerFunc(ctx, in, opts...)
+		return m.registerFunc(ctx, in)
 	}
 	return nil, fmt.Errorf("registerFunc not implemented")
 }
 
-func (m *mockAuthServiceClient) Refresh(ctx context.Context, in *pb.RefreshRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error) {
+func (m *mockAuthService) Refresh(ctx context.Context, in *pb.RefreshRequest) (*pb.TokenResponse, error) {
 	if m.refreshFunc != nil {
-		return m.refreshFunc(ctx, in, opts...)
+		return m.refreshFunc(ctx, in)
 	}
 	return nil, fmt.Errorf("refreshFunc not implemented")
 }
 
-func (m *mockAuthServiceClient) Revoke(ctx context.Context, in *pb.RevokeRequest, opts ...grpc.CallOption) (*pb.RevokeResponse, error) {
+func (m *mockAuthService) Revoke(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error) {
 	if m.revokeFunc != nil {
-		return m.revokeFunc(ctx, in, opts...)
+		return m.revokeFunc(ctx, in)
 	}
 	return nil, fmt.Errorf("revokeFunc not implemented")
 }
 
 // setupTestRouter creates a test router with the auth handlers
-func setupTestRouter(mockClient pb.AuthServiceClient) *chi.Mux {
+func setupTestRouter(mockClient handlers.AuthService) *chi.Mux {
 	authManager := handlers.NewAuthManager(mockClient)
 	r := chi.NewRouter()
 
@@ -104,8 +116,8 @@ func generateMockJWT(expiry time.Time) string {
 
 // TestLoginHandler_Success tests successful authentication
 func TestLoginHandler_Success(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		loginFunc: func(ctx context.Context, in *pb.LoginRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error) {
+	mockClient := &mockAuthService{
+		loginFunc: func(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error) {
 			assert.Equal(t, "testuser", in.Username)
 			assert.Equal(t, "testpass", in.Password)
 
@@ -172,8 +184,8 @@ func TestLoginHandler_Success(t *testing.T) {
 
 // TestLoginHandler_InvalidCredentials tests failed authentication
 func TestLoginHandler_InvalidCredentials(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		loginFunc: func(ctx context.Context, in *pb.LoginRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error) {
+	mockClient := &mockAuthService{
+		loginFunc: func(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error) {
 			return nil, fmt.Errorf("invalid credentials")
 		},
 	}
@@ -205,7 +217,7 @@ func TestLoginHandler_InvalidCredentials(t *testing.T) {
 
 // TestLoginHandler_MissingCredentials tests missing username/password
 func TestLoginHandler_MissingCredentials(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -248,8 +260,8 @@ func TestLoginHandler_MissingCredentials(t *testing.T) {
 
 // TestRegisterHandler_Success tests successful user registration
 func TestRegisterHandler_Success(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		registerFunc: func(ctx context.Context, in *pb.RegisterRequest, opts ...grpc.CallOption) (*pb.RegisterResponse, error) {
+	mockClient := &mockAuthService{
+		registerFunc: func(ctx context.Context, in *pb.RegisterRequest) (*pb.RegisterResponse, error) {
 			assert.Equal(t, "newuser", in.Username)
 			assert.Equal(t, "newpass", in.Password)
 
@@ -290,8 +302,8 @@ func TestRegisterHandler_Success(t *testing.T) {
 
 // TestRefreshHandler_Success tests successful token refresh
 func TestRefreshHandler_Success(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		refreshFunc: func(ctx context.Context, in *pb.RefreshRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error) {
+	mockClient := &mockAuthService{
+		refreshFunc: func(ctx context.Context, in *pb.RefreshRequest) (*pb.TokenResponse, error) {
 			assert.Equal(t, "refresh-token-xyz", in.RefreshToken)
 
 			return &pb.TokenResponse{
@@ -348,8 +360,8 @@ func TestRefreshHandler_Success(t *testing.T) {
 
 // TestRevokeHandler_Success tests successful token revocation
 func TestRevokeHandler_Success(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		revokeFunc: func(ctx context.Context, in *pb.RevokeRequest, opts ...grpc.CallOption) (*pb.RevokeResponse, error) {
+	mockClient := &mockAuthService{
+		revokeFunc: func(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error) {
 			assert.Equal(t, "refresh-token-to-revoke", in.RefreshToken)
 			assert.Equal(t, "user-123", in.UserId)
 
@@ -387,7 +399,7 @@ func TestRevokeHandler_Success(t *testing.T) {
 
 // TestProtectedRoute_WithValidToken tests accessing a protected route with a valid token
 func TestProtectedRoute_WithValidToken(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -416,7 +428,7 @@ func TestProtectedRoute_WithValidToken(t *testing.T) {
 
 // TestProtectedRoute_WithExpiredToken tests accessing a protected route with an expired token
 func TestProtectedRoute_WithExpiredToken(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -444,7 +456,7 @@ func TestProtectedRoute_WithExpiredToken(t *testing.T) {
 
 // TestProtectedRoute_WithMissingToken tests accessing a protected route without a token
 func TestProtectedRoute_WithMissingToken(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -464,7 +476,7 @@ func TestProtectedRoute_WithMissingToken(t *testing.T) {
 
 // TestProtectedRoute_WithInvalidToken tests accessing a protected route with a malformed token
 func TestProtectedRoute_WithInvalidToken(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -489,7 +501,7 @@ func TestProtectedRoute_WithInvalidToken(t *testing.T) {
 
 // TestProtectedRoute_WithTokenInCookie tests accessing a protected route with token in cookie
 func TestProtectedRoute_WithTokenInCookie(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -516,7 +528,7 @@ func TestProtectedRoute_WithTokenInCookie(t *testing.T) {
 
 // TestLoginHandler_InvalidJSON tests login with malformed JSON
 func TestLoginHandler_InvalidJSON(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -531,7 +543,7 @@ func TestLoginHandler_InvalidJSON(t *testing.T) {
 
 // TestRegisterHandler_InvalidJSON tests register with malformed JSON
 func TestRegisterHandler_InvalidJSON(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -546,8 +558,8 @@ func TestRegisterHandler_InvalidJSON(t *testing.T) {
 
 // TestRegisterHandler_Failure tests register when the gRPC call fails
 func TestRegisterHandler_Failure(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		registerFunc: func(ctx context.Context, in *pb.RegisterRequest, opts ...grpc.CallOption) (*pb.RegisterResponse, error) {
+	mockClient := &mockAuthService{
+		registerFunc: func(ctx context.Context, in *pb.RegisterRequest) (*pb.RegisterResponse, error) {
 			return nil, fmt.Errorf("user already exists")
 		},
 	}
@@ -572,7 +584,7 @@ func TestRegisterHandler_Failure(t *testing.T) {
 
 // TestRefreshHandler_InvalidJSON tests refresh with malformed JSON
 func TestRefreshHandler_InvalidJSON(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -587,8 +599,8 @@ func TestRefreshHandler_InvalidJSON(t *testing.T) {
 
 // TestRefreshHandler_Failure tests refresh when the gRPC call fails
 func TestRefreshHandler_Failure(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		refreshFunc: func(ctx context.Context, in *pb.RefreshRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error) {
+	mockClient := &mockAuthService{
+		refreshFunc: func(ctx context.Context, in *pb.RefreshRequest) (*pb.TokenResponse, error) {
 			return nil, fmt.Errorf("invalid refresh token")
 		},
 	}
@@ -612,7 +624,7 @@ func TestRefreshHandler_Failure(t *testing.T) {
 
 // TestRevokeHandler_InvalidJSON tests revoke with malformed JSON
 func TestRevokeHandler_InvalidJSON(t *testing.T) {
-	mockClient := &mockAuthServiceClient{}
+	mockClient := &mockAuthService{}
 	router := setupTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -627,8 +639,8 @@ func TestRevokeHandler_InvalidJSON(t *testing.T) {
 
 // TestRevokeHandler_Failure tests revoke when the gRPC call fails
 func TestRevokeHandler_Failure(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		revokeFunc: func(ctx context.Context, in *pb.RevokeRequest, opts ...grpc.CallOption) (*pb.RevokeResponse, error) {
+	mockClient := &mockAuthService{
+		revokeFunc: func(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error) {
 			return nil, fmt.Errorf("token not found")
 		},
 	}
@@ -653,8 +665,8 @@ func TestRevokeHandler_Failure(t *testing.T) {
 
 // TestLoginHandler_TokensWithoutExpiry tests login response when tokens don't have expiry set
 func TestLoginHandler_TokensWithoutExpiry(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		loginFunc: func(ctx context.Context, in *pb.LoginRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error) {
+	mockClient := &mockAuthService{
+		loginFunc: func(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error) {
 			return &pb.TokenResponse{
 				UserId:       "user-123",
 				AccessToken:  generateMockJWT(time.Now().Add(5 * time.Minute)),
@@ -692,8 +704,8 @@ func TestLoginHandler_TokensWithoutExpiry(t *testing.T) {
 
 // TestRefreshHandler_TokensWithoutExpiry tests refresh response when tokens don't have expiry set
 func TestRefreshHandler_TokensWithoutExpiry(t *testing.T) {
-	mockClient := &mockAuthServiceClient{
-		refreshFunc: func(ctx context.Context, in *pb.RefreshRequest, opts ...grpc.CallOption) (*pb.TokenResponse, error) {
+	mockClient := &mockAuthService{
+		refreshFunc: func(ctx context.Context, in *pb.RefreshRequest) (*pb.TokenResponse, error) {
 			return &pb.TokenResponse{
 				UserId:       "user-123",
 				AccessToken:  generateMockJWT(time.Now().Add(5 * time.Minute)),
@@ -727,3 +739,472 @@ func TestRefreshHandler_TokensWithoutExpiry(t *testing.T) {
 	_, hasExpiry := respBody["access_expires_in_seconds"]
 	assert.False(t, hasExpiry, "Should not have access_expires_in_seconds when not set")
 }
+
+// generateSignedJWT creates an HS256 token signed with secret
+func generateSignedJWT(secret string, claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payloadJSON, _ := json.Marshal(claims)
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payloadJSON)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setupAuthenticatorRouter creates a router whose protected route reports the identity it received
+func setupAuthenticatorRouter(t *testing.T, secret string, propagate bool) *chi.Mux {
+	verifier, err := token.NewVerifier(token.Config{HMACSecret: secret})
+	require.NoError(t, err)
+	authenticator := handlers.NewAuthenticator(verifier, propagate)
+
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(authenticator.Middleware)
+		r.Get("/protected", func(w http.ResponseWriter, r *http.Request) {
+			out := map[string]any{}
+			if id, ok := interceptor.IdentityFromContext(r.Context()); ok {
+				out["user_id"] = id.UserID
+				out["roles"] = id.Roles
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(out)
+		})
+	})
+	return r
+}
+
+// TestAuthenticator_VerifiedIdentity tests that verified claims are made available for backend metadata
+func TestAuthenticator_VerifiedIdentity(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+	ts := httptest.NewServer(setupAuthenticatorRouter(t, secret, true))
+	defer ts.Close()
+
+	accessToken := generateSignedJWT(secret, map[string]any{
+		"uid":   "user-123",
+		"roles": []string{"admin"},
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	})
+
+	req, err := http.NewRequest("GET", ts.URL+"/protected", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var respBody map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&respBody))
+	assert.Equal(t, "user-123", respBody["user_id"])
+	assert.Equal(t, []any{"admin"}, respBody["roles"])
+}
+
+// TestAuthenticator_ForgedToken tests that tokens with a bad signature are rejected
+func TestAuthenticator_ForgedToken(t *testing.T) {
+	ts := httptest.NewServer(setupAuthenticatorRouter(t, "0123456789abcdef0123456789abcdef", true))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/protected", nil)
+	require.NoError(t, err)
+	// unsigned test tokens are only accepted when no verifier is configured
+	req.Header.Set("Authorization", "Bearer "+generateMockJWT(time.Now().Add(5*time.Minute)))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "invalid access token")
+}
+
+// TestAuthenticator_IdentityPropagationDisabled tests the zero-trust switch
+func TestAuthenticator_IdentityPropagationDisabled(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+	ts := httptest.NewServer(setupAuthenticatorRouter(t, secret, false))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/protected", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+generateSignedJWT(secret, map[string]any{
+		"uid": "user-123",
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var respBody map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&respBody))
+	assert.Empty(t, respBody)
+}
+
+// TestAuthenticator_Metrics tests that token checks are counted by route pattern and result
+func TestAuthenticator_Metrics(t *testing.T) {
+	r := chi.NewRouter()
+	r.Route("/metered", func(r chi.Router) {
+		r.Use(handlers.PropagateAuthToGRPC)
+		r.Get("/products/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	send := func(token string) {
+		req := httptest.NewRequest(http.MethodGet, "/metered/products/p1", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send("")
+	send("not-a-jwt")
+	send(generateMockJWT(time.Now().Add(-time.Minute)))
+	send(generateMockJWT(time.Now().Add(time.Minute)))
+	send(generateMockJWT(time.Now().Add(time.Minute)))
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for result, n := range map[string]int{"missing": 1, "invalid": 1, "expired": 1, "accepted": 2} {
+		assert.Contains(t, body, fmt.Sprintf(`gateway_auth_tokens_total{result=%q,route="/metered/products/{id}"} %d`, result, n))
+	}
+	assert.Contains(t, body, `gateway_auth_verification_seconds_count{route="/metered/products/{id}"} 4`)
+}
+
+// TestAuthenticator_Optional tests that the optional variant attaches the identity of valid tokens and serves other requests anonymously
+func TestAuthenticator_Optional(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+	verifier, err := token.NewVerifier(token.Config{HMACSecret: secret})
+	require.NoError(t, err)
+	authenticator := handlers.NewAuthenticator(verifier, true)
+	r := chi.NewRouter()
+	r.With(authenticator.OptionalMiddleware).Get("/optional/products", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := interceptor.IdentityFromContext(r.Context())
+		w.Write([]byte(id.UserID))
+	})
+
+	send := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/optional/products", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("Bearer " + generateSignedJWT(secret, map[string]any{"uid": "user-123", "exp": time.Now().Add(time.Minute).Unix()}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user-123", w.Body.String())
+
+	for _, auth := range []string{"", "Basic dXNlcjpwdw==", "Bearer " + generateMockJWT(time.Now().Add(time.Minute)), "Bearer " + generateSignedJWT(secret, map[string]any{"uid": "user-123", "exp": time.Now().Add(-time.Minute).Unix()})} {
+		w = send(auth)
+		assert.Equal(t, http.StatusOK, w.Code, auth)
+		assert.Empty(t, w.Body.String(), auth)
+	}
+
+	w = httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for result, n := range map[string]int{"anonymous": 1, "invalid": 2, "expired": 1, "accepted": 1} {
+		assert.Contains(t, w.Body.String(), fmt.Sprintf(`gateway_auth_tokens_total{result=%q,route="/optional/products"} %d`, result, n))
+	}
+}
+
+// TestAuthenticator_Validator tests that refused claims get distinct messages
+func TestAuthenticator_Validator(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+	cfg := token.Config{HMACSecret: secret, ClockSkew: 30 * time.Second, Audience: "gateway"}
+	verifier, err := token.NewVerifier(cfg)
+	require.NoError(t, err)
+	authenticator := handlers.NewAuthenticator(verifier, false)
+	authenticator.Validator = token.NewValidator(cfg)
+	h := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(claims map[string]any) *httptest.ResponseRecorder {
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+generateSignedJWT(secret, claims))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(map[string]any{"aud": "gateway", "nbf": time.Now().Add(10 * time.Second).Unix()})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send(map[string]any{"aud": "gateway", "nbf": time.Now().Add(time.Minute).Unix()})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "access token not valid yet")
+
+	w = send(map[string]any{"aud": "billing"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "access token audience not accepted")
+}
+
+// TestRefreshHandler_ReuseRevokesSession tests that a rotated refresh token presented again revokes the live one
+func TestRefreshHandler_ReuseRevokesSession(t *testing.T) {
+	var revoked []*pb.RevokeRequest
+	next := 0
+	mockClient := &mockAuthService{
+		refreshFunc: func(ctx context.Context, in *pb.RefreshRequest) (*pb.TokenResponse, error) {
+			next++
+			return &pb.TokenResponse{UserId: "user-123", RefreshToken: fmt.Sprintf("refresh-%d", next)}, nil
+		},
+		revokeFunc: func(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error) {
+			revoked = append(revoked, in)
+			return &pb.RevokeResponse{}, nil
+		},
+	}
+	authManager := handlers.NewAuthManager(mockClient)
+	authManager.Rotations = rotation.NewWithStore(rotation.Config{}, rotation.NewMemoryStore())
+
+	refresh := func(token string, cookie bool) *httptest.ResponseRecorder {
+		body := `{}`
+		if !cookie {
+			body = fmt.Sprintf(`{"refresh_token":%q}`, token)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBufferString(body))
+		if cookie {
+			req.AddCookie(&http.Cookie{Name: "refresh_token", Value: token})
+		}
+		w := httptest.NewRecorder()
+		authManager.RefreshHandler(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, refresh("refresh-0", false).Code)
+	require.Equal(t, http.StatusOK, refresh("refresh-1", true).Code)
+	assert.Empty(t, revoked)
+
+	w := refresh("refresh-0", true)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 2, next, "reused token must not reach auth_service")
+	require.Len(t, revoked, 1)
+	assert.Equal(t, "refresh-2", revoked[0].RefreshToken)
+	assert.Equal(t, "user-123", revoked[0].UserId)
+	for _, c := range w.Result().Cookies() {
+		assert.Equal(t, -1, c.MaxAge, c.Name)
+	}
+}
+
+// TestCookies_Configured tests that login sets and protected routes read the configured cookies
+func TestCookies_Configured(t *testing.T) {
+	jar, err := cookie.New(cookie.Config{AccessName: "at", RefreshName: "rt", Prefix: cookie.SecurePrefix, Domain: "example.com"})
+	require.NoError(t, err)
+	authManager := handlers.NewAuthManager(&mockAuthService{
+		loginFunc: func(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error) {
+			return &pb.TokenResponse{UserId: "user-123", AccessToken: generateMockJWT(time.Now().Add(time.Minute)), RefreshToken: "refresh-1"}, nil
+		},
+	})
+	authManager.Cookies = jar
+
+	w := httptest.NewRecorder()
+	authManager.LoginHandler(w, httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBufferString(`{"username":"u","password":"p"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "__Secure-rt", cookies[0].Name)
+	assert.Equal(t, "__Secure-at", cookies[1].Name)
+	for _, c := range cookies {
+		assert.True(t, c.Secure)
+		assert.Equal(t, "example.com", c.Domain)
+	}
+
+	authenticator := handlers.NewAuthenticator(nil, false)
+	authenticator.Cookies = jar
+	protected := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.AddCookie(cookies[1])
+	w = httptest.NewRecorder()
+	protected.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: cookies[1].Value})
+	w = httptest.NewRecorder()
+	protected.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// TestLoginHandler_ResponseMode tests that the tokens mode returns the refresh token in the body instead of cookies
+func TestLoginHandler_ResponseMode(t *testing.T) {
+	authManager := handlers.NewAuthManager(&mockAuthService{
+		loginFunc: func(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error) {
+			return &pb.TokenResponse{
+				UserId:           "user-123",
+				AccessToken:      "access-1",
+				RefreshToken:     "refresh-1",
+				RefreshExpiresIn: durationpb.New(24 * time.Hour),
+			}, nil
+		},
+	})
+	login := func(header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBufferString(`{"username":"u","password":"p"}`))
+		if header != "" {
+			req.Header.Set("X-Auth-Response", header)
+		}
+		w := httptest.NewRecorder()
+		authManager.LoginHandler(w, req)
+		return w
+	}
+
+	w := login("")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, w.Result().Cookies(), 2)
+	assert.NotContains(t, w.Body.String(), "refresh-1")
+
+	w = login("tokens")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+	var out map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &out))
+	assert.Equal(t, "access-1", out["access_token"])
+	assert.Equal(t, "refresh-1", out["refresh_token"])
+	assert.Equal(t, float64(86400), out["refresh_expires_in_seconds"])
+
+	authManager.ResponseMode = handlers.ResponseTokens
+	assert.Empty(t, login("").Result().Cookies())
+	assert.Len(t, login("cookies").Result().Cookies(), 2)
+	assert.Equal(t, http.StatusBadRequest, login("jwt").Code)
+}
+
+// TestLoginHandler_RememberMe tests that remember_me reaches auth_service and picks persistent or session cookies, also on refresh
+func TestLoginHandler_RememberMe(t *testing.T) {
+	var forwarded []string
+	remembered := func(ctx context.Context) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		forwarded = append(forwarded, strings.Join(md.Get("x-remember-me"), ","))
+	}
+	tokens := &pb.TokenResponse{
+		UserId:           "user-123",
+		AccessToken:      "access-1",
+		RefreshToken:     "refresh-1",
+		AccessExpiresIn:  durationpb.New(5 * time.Minute),
+		RefreshExpiresIn: durationpb.New(24 * time.Hour),
+	}
+	authManager := handlers.NewAuthManager(&mockAuthService{
+		loginFunc: func(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error) {
+			remembered(ctx)
+			return tokens, nil
+		},
+		refreshFunc: func(ctx context.Context, in *pb.RefreshRequest) (*pb.TokenResponse, error) {
+			remembered(ctx)
+			return tokens, nil
+		},
+	})
+	authManager.RememberTTL = 30 * 24 * time.Hour
+
+	cookiesOf := func(w *httptest.ResponseRecorder) map[string]*http.Cookie {
+		out := map[string]*http.Cookie{}
+		for _, c := range w.Result().Cookies() {
+			out[c.Name] = c
+		}
+		return out
+	}
+	login := func(body string) map[string]*http.Cookie {
+		w := httptest.NewRecorder()
+		authManager.LoginHandler(w, httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBufferString(body)))
+		require.Equal(t, http.StatusOK, w.Code)
+		return cookiesOf(w)
+	}
+
+	cookies := login(`{"username":"u","password":"p"}`)
+	assert.NotContains(t, cookies, "remember_me")
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), cookies["refresh_token"].Expires, time.Minute)
+
+	cookies = login(`{"username":"u","password":"p","remember_me":true}`)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), cookies["refresh_token"].Expires, time.Minute)
+	assert.Equal(t, cookies["refresh_token"].Expires, cookies["remember_me"].Expires)
+
+	cookies = login(`{"username":"u","password":"p","remember_me":false}`)
+	assert.True(t, cookies["refresh_token"].Expires.IsZero())
+	assert.True(t, cookies["access_token"].Expires.IsZero())
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBufferString(`{}`))
+	req.AddCookie(cookies["refresh_token"])
+	req.AddCookie(cookies["remember_me"])
+	w := httptest.NewRecorder()
+	authManager.RefreshHandler(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, cookiesOf(w)["refresh_token"].Expires.IsZero())
+
+	assert.Equal(t, []string{"", "true", "false", "false"}, forwarded)
+}
+
+// TestAuthenticator_SignedRequest tests that signed machine requests are served as their key and cannot be replayed
+func TestAuthenticator_SignedRequest(t *testing.T) {
+	signatures, err := signature.New(signature.Config{Keys: []signature.Key{{ID: "partner-1", Secret: "partner-secret", Roles: []string{"partner"}}}})
+	require.NoError(t, err)
+	authenticator := handlers.NewAuthenticator(nil, true)
+	authenticator.Signatures = signatures
+
+	r := chi.NewRouter()
+	r.With(authenticator.Middleware).Post("/protected", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		id, _ := interceptor.IdentityFromContext(r.Context())
+		json.NewEncoder(w).Encode(map[string]any{"user_id": id.UserID, "roles": id.Roles, "body": string(body)})
+	})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	body := []byte(`{"sku": "A-1"}`)
+	req, err := http.NewRequest("POST", ts.URL+"/protected?dry_run=1", bytes.NewReader(body))
+	require.NoError(t, err)
+	signature.Sign(req, "partner-1", "partner-secret", "5f0c0a4e9b7d4c21", body, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var out map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	assert.Equal(t, "partner-1", out["user_id"])
+	assert.Equal(t, []any{"partner"}, out["roles"])
+	assert.Equal(t, string(body), out["body"], "the body is still readable after verification")
+
+	replay, err := http.NewRequest("POST", ts.URL+"/protected?dry_run=1", bytes.NewReader(body))
+	require.NoError(t, err)
+	replay.Header = req.Header.Clone()
+	resp, err = http.DefaultClient.Do(replay)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	msg, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(msg), "nonce already used")
+}
+
+// TestAuthenticator_ClientCertificate tests that verified client certificates with an identity need no access token
+func TestAuthenticator_ClientCertificate(t *testing.T) {
+	certs, err := clientcert.New(clientcert.Config{Identities: []clientcert.Identity{{CommonName: "billing", Roles: []string{"inventory_reader"}}}})
+	require.NoError(t, err)
+	authenticator := handlers.NewAuthenticator(nil, true)
+	authenticator.ClientCerts = certs
+
+	r := chi.NewRouter()
+	r.With(authenticator.Middleware).Get("/protected", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := interceptor.IdentityFromContext(r.Context())
+		claims, _ := token.FromContext(r.Context())
+		json.NewEncoder(w).Encode(map[string]any{"user_id": id.UserID, "roles": id.Roles, "type": claims.Type})
+	})
+	withCert := func(commonName string) *http.Request {
+		req := httptest.NewRequest("GET", "/protected", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}}}
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, withCert("billing"))
+	require.Equal(t, http.StatusOK, w.Code)
+	var out map[string]any
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&out))
+	assert.Equal(t, "billing", out["user_id"])
+	assert.Equal(t, []any{"inventory_reader"}, out["roles"])
+	assert.Equal(t, "client_certificate", out["type"])
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, withCert("stranger"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "certificates without an identity need a token")
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/jobs"
+	"github.com/andro-kes/gateway/internal/money"
+	"github.com/andro-kes/gateway/internal/queue"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+)
+
+// CSV is the media type of product imports and exports.
+const CSV = "text/csv"
+
+// csvColumns are the columns of product CSV files. Tags are separated by
+// semicolons.
+var csvColumns = []string{"id", "name", "description", "price", "quantity", "tags", "available"}
+
+// errBusy is the error of bulk calls that could not get a queue slot.
+var errBusy = errors.New("gateway is too busy, try again later")
+
+// maxImportBytes bounds the size of an imported CSV file.
+const maxImportBytes = 32 << 20
+
+// maxImportErrors bounds the row errors reported in an import result.
+const maxImportErrors = 100
+
+// ImportResult is the result of a products.import job.
+type ImportResult struct {
+	Created int           `json:"created"`
+	Failed  int           `json:"failed"`
+	Errors  []ImportError `json:"errors,omitempty"`
+}
+
+// ImportError is a CSV row that could not be imported.
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportHandler validates a CSV file of products and creates them in a
+// background job, answering 202 with the job ID. The header row names the
+// columns, in any order; only name is required and id is ignored, so that
+// exports can be imported again.
+func (im *InvManager) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != CSV {
+		http.Error(w, "unsupported content type, expected "+CSV, http.StatusUnsupportedMediaType)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "import file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		http.Error(w, "invalid CSV: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, "CSV header row is required", http.StatusBadRequest)
+		return
+	}
+	header := records[0]
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(csvColumns, header[i]) {
+			http.Error(w, fmt.Sprintf("unknown CSV column %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+	if !slices.Contains(header, "name") {
+		http.Error(w, "CSV column name is required", http.StatusBadRequest)
+		return
+	}
+
+	rows := records[1:]
+	job, err := im.Jobs.Submit(r.Context(), "products.import", func(ctx context.Context, t *jobs.Tracker) (any, error) {
+		t.SetTotal(int64(len(rows)))
+		var result ImportResult
+		for i, row := range rows {
+			if err := im.importRow(ctx, header, row); err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				result.Failed++
+				if len(result.Errors) < maxImportErrors {
+					// line 1 is the header
+					result.Errors = append(result.Errors, ImportError{Line: i + 2, Error: err.Error()})
+				}
+			} else {
+				result.Created++
+			}
+			t.Add(1)
+		}
+		return result, nil
+	})
+	writeJobAccepted(w, r, job, err)
+}
+
+func (im *InvManager) importRow(ctx context.Context, header, row []string) error {
+	if len(row) != len(header) {
+		return fmt.Errorf("expected %d fields, got %d", len(header), len(row))
+	}
+	p := &pbInv.Product{Available: true}
+	for i, value := range row {
+		value = strings.TrimSpace(value)
+		switch header[i] {
+		case "name":
+			p.Name = value
+		case "description":
+			p.Description = value
+		case "price":
+			price, err := im.parsePrice(value)
+			if err != nil {
+				return err
+			}
+			p.Price = price
+		case "quantity":
+			if value == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid quantity %q", value)
+			}
+			p.Quantity = int32(n)
+		case "tags":
+			for tag := range strings.SplitSeq(value, ";") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					p.Tags = append(p.Tags, tag)
+				}
+			}
+		case "available":
+			if value == "" {
+				continue
+			}
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid available %q", value)
+			}
+			p.Available = b
+		}
+	}
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+
+	release, err := im.Queue.Acquire(ctx, queue.Bulk)
+	if err != nil {
+		return errBusy
+	}
+	resp, err := im.Service.CreateProduct(ctx, &pbInv.CreateRequest{Product: p})
+	release()
+	if err != nil {
+		return errors.New("failed to create product")
+	}
+	im.Events.Emit(ctx, events.ProductCreated, resp.GetProduct().GetId(), resp.GetProduct())
+	return nil
+}
+
+// parsePrice parses a CSV price, validating it against the currency when
+// decimal prices are enabled.
+func (im *InvManager) parsePrice(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if im.Money != nil {
+		amount, err := money.Parse(value, im.Money.Currency())
+		if err != nil {
+			return 0, fmt.Errorf("invalid price %q: %w", value, err)
+		}
+		return im.Money.ToBackend(amount), nil
+	}
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price < 0 {
+		return 0, fmt.Errorf("invalid price %q", value)
+	}
+	return price, nil
+}
+
+// ExportHandler writes the products matching an optional list request body
+// (filter, order_by) to a CSV file in a background job, answering 202 with
+// the job ID. The file is downloaded from the job once it has finished.
+func (im *InvManager) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	var req pbInv.ListRequest
+	if r.ContentLength != 0 {
+		if err := decodeRequest(w, r, &req); err != nil && !errors.Is(err, io.EOF) {
+			writeDecodeError(w, err)
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	pageSize := im.StreamPageSize
+	if pageSize <= 0 {
+		pageSize = 500
+	}
+	filter, orderBy := req.Filter, req.OrderBy
+	job, err := im.Jobs.Submit(r.Context(), "products.export", func(ctx context.Context, t *jobs.Tracker) (any, error) {
+		var buf bytes.Buffer
+		out := csv.NewWriter(&buf)
+		_ = out.Write(csvColumns)
+		for offset := int32(0); ; {
+			release, err := im.Queue.Acquire(ctx, queue.Bulk)
+			if err != nil {
+				return nil, errBusy
+			}
+			resp, err := im.Service.ListProducts(ctx, &pbInv.ListRequest{
+				PrevSize: offset,
+				PageSize: pageSize,
+				Filter:   filter,
+				OrderBy:  orderBy,
+			})
+			release()
+			if err != nil {
+				return nil, errors.New("failed to list products")
+			}
+			for _, p := range resp.Products {
+				_ = out.Write([]string{
+					p.Id,
+					p.Name,
+					p.Description,
+					im.formatPrice(p.Price),
+					strconv.Itoa(int(p.Quantity)),
+					strings.Join(p.Tags, ";"),
+					strconv.FormatBool(p.Available),
+				})
+			}
+			t.Add(int64(len(resp.Products)))
+			offset += int32(len(resp.Products))
+			if int32(len(resp.Products)) < pageSize {
+				break
+			}
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			return nil, err
+		}
+		return &jobs.Output{Name: "products.csv", ContentType: CSV + "; charset=utf-8", Data: buf.Bytes()}, nil
+	})
+	writeJobAccepted(w, r, job, err)
+}
+
+func (im *InvManager) formatPrice(price float64) string {
+	if im.Money != nil {
+		if amount, err := im.Money.FromBackend(price); err == nil {
+			return amount.String()
+		}
+	}
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
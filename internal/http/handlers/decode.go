@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxRequestBytes bounds the size of a JSON or protobuf request body.
+const maxRequestBytes = 1 << 20
+
+// readBody reads the request body into buf, growing it first by
+// Content-Length. Bodies over maxRequestBytes fail with *http.MaxBytesError.
+func readBody(w http.ResponseWriter, r *http.Request, buf *bytes.Buffer) error {
+	return render.ReadAll(buf, http.MaxBytesReader(w, r.Body, maxRequestBytes), r.ContentLength)
+}
+
+// decodeRequest reads the request body into req: as protobuf binary when the
+// client sent one of the protobuf media types (only admitted when protobuf
+// pass-through is enabled), as JSON otherwise. The body is read into a pooled
+// buffer sized by Content-Length.
+func decodeRequest(w http.ResponseWriter, r *http.Request, req proto.Message) error {
+	buf := render.GetBuffer()
+	defer render.PutBuffer(buf)
+	if err := readBody(w, r, buf); err != nil {
+		return err
+	}
+	return unmarshalRequest(r, buf.Bytes(), req)
+}
+
+// decodeJSON reads a JSON request body into v, for request types that are
+// not protobuf messages. Bodies over maxRequestBytes fail with
+// *http.MaxBytesError.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	buf := render.GetBuffer()
+	defer render.PutBuffer(buf)
+	if err := readBody(w, r, buf); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf.Bytes(), v)
+}
+
+// unmarshalRequest decodes a request body already read, in the format
+// decodeRequest would. Neither format keeps references to data. An empty
+// JSON body is io.EOF, for handlers whose body is optional.
+func unmarshalRequest(r *http.Request, data []byte, req proto.Message) error {
+	if isProtobuf(r) {
+		return proto.Unmarshal(data, req)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return io.EOF
+	}
+	return json.Unmarshal(data, req)
+}
+
+// isProtobuf reports whether the request body is protobuf binary.
+func isProtobuf(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return slices.Contains(render.Protobuf{}.MediaTypes(), mediaType)
+}
+
+// priceError is a price in a request body that the gateway rejected. Its
+// message is meant for the client.
+type priceError struct {
+	err error
+}
+
+func (e *priceError) Error() string {
+	return e.err.Error()
+}
+
+// decodePriced is decodeRequest for requests carrying prices. With a Money
+// converter, JSON prices are validated and converted to the backend
+// representation first.
+func (im *InvManager) decodePriced(w http.ResponseWriter, r *http.Request, req proto.Message) error {
+	if im.Money == nil || isProtobuf(r) {
+		return decodeRequest(w, r, req)
+	}
+	buf := render.GetBuffer()
+	defer render.PutBuffer(buf)
+	if err := readBody(w, r, buf); err != nil {
+		return err
+	}
+	data, err := im.Money.NormalizeRequest(buf.Bytes())
+	if err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return err
+		}
+		return &priceError{err: err}
+	}
+	return json.Unmarshal(data, req)
+}
+
+// writeDecodeError answers a request whose body could not be decoded.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var pe *priceError
+	if errors.As(err, &pe) {
+		http.Error(w, pe.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "failed to decode request body", http.StatusBadRequest)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// productETag returns the strong entity tag of a product version, derived
+// from its ID and update timestamp, or from its content when the backend
+// reports no update timestamp.
+func productETag(p *pbInv.Product) string {
+	if p == nil {
+		return ""
+	}
+	h := sha256.New()
+	if ts := p.GetUpdatedAt(); ts != nil {
+		h.Write([]byte(p.GetId() + "\x00" + strconv.FormatInt(ts.AsTime().UnixNano(), 10)))
+	} else {
+		data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(p)
+		h.Write(data)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value lists
+// etag or "*". Weak tags never match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || (etag != "" && candidate == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces the If-Match precondition of a write to product id.
+// The backend has no conditional writes, so the current version is fetched
+// and compared; a concurrent write between the check and the write can still
+// slip through. Without If-Match the write proceeds unless RequireIfMatch is
+// set. It reports whether the write may proceed and has answered otherwise.
+func (im *InvManager) checkIfMatch(w http.ResponseWriter, r *http.Request, id string) bool {
+	if r.Header.Get("If-Match") == "" {
+		return im.matchIfMatch(w, r, nil)
+	}
+
+	current, err := im.Service.GetProduct(r.Context(), &pbInv.GetRequest{Id: id})
+	if err != nil && status.Code(err) != codes.NotFound {
+		http.Error(w, "failed to check precondition", http.StatusInternalServerError)
+		return false
+	}
+	return im.matchIfMatch(w, r, current.GetProduct())
+}
+
+// matchIfMatch is checkIfMatch against an already fetched product, nil if it
+// does not exist.
+func (im *InvManager) matchIfMatch(w http.ResponseWriter, r *http.Request, current *pbInv.Product) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		if im.RequireIfMatch {
+			http.Error(w, "If-Match header required", http.StatusPreconditionRequired)
+			return false
+		}
+		return true
+	}
+	if current == nil {
+		http.Error(w, "product does not exist", http.StatusPreconditionFailed)
+		return false
+	}
+	if etag := productETag(current); !etagMatches(ifMatch, etag) {
+		w.Header().Set("ETag", etag)
+		http.Error(w, "product has been modified", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
@@ -2,57 +2,116 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/jobs"
+	"github.com/andro-kes/gateway/internal/money"
+	"github.com/andro-kes/gateway/internal/pagination"
+	"github.com/andro-kes/gateway/internal/queue"
 	pbInv "github.com/andro-kes/inventory_service/proto"
 )
 
 type InvManager struct {
-	Client pbInv.InventoryServiceClient
+	Service InventoryService
+
+	// Pager bounds list page sizes and issues the cursors of next/prev
+	// links. When nil, list requests are passed through unchanged.
+	Pager *pagination.Pager
+
+	// RequireIfMatch rejects updates and deletes without an If-Match header
+	// with 428, forcing clients to use optimistic concurrency.
+	RequireIfMatch bool
+
+	// HardDeleteRoles are the roles allowed to delete products permanently,
+	// as opposed to soft deletes. When empty, anyone may.
+	HardDeleteRoles []string
+
+	// AvailabilityRoles are the roles allowed to take products offline and
+	// back online, including soft deletes and restores. When empty, anyone may.
+	AvailabilityRoles []string
+
+	// StreamPageSize is the number of products fetched per backend call when
+	// streaming a list as NDJSON. Default: 500.
+	StreamPageSize int32
+
+	// Money validates and converts decimal prices in create and update
+	// requests. When nil, prices are passed through as sent.
+	Money *money.Converter
+
+	// Events publishes product.created and product.deleted events. May be nil.
+	Events *events.Emitter
+
+	// Jobs runs CSV imports and exports in the background.
+	Jobs *jobs.Runner
+
+	// Queue admits the backend calls of imports and exports as bulk
+	// traffic. May be nil.
+	Queue *queue.Queue
 }
 
-func NewInvManager(client pbInv.InventoryServiceClient) *InvManager {
+// NDJSON is the media type of streamed list responses, one JSON object per line.
+const NDJSON = "application/x-ndjson"
+
+// streamWriteTimeout bounds writing one page of a streamed list, replacing
+// the server write timeout that would otherwise cut off long streams.
+const streamWriteTimeout = 30 * time.Second
+
+func NewInvManager(service InventoryService) *InvManager {
 	return &InvManager{
-		Client: client,
+		Service: service,
 	}
 }
 
 func (im *InvManager) CreateHandler(w http.ResponseWriter, r *http.Request) {
 	var req pbInv.CreateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+	if err := im.decodePriced(w, r, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 	defer r.Body.Close()
 
-	product, err := im.Client.CreateProduct(r.Context(), &req)
+	product, err := im.Service.CreateProduct(r.Context(), &req)
 	if err != nil {
 		http.Error(w, "failed to create product", http.StatusInternalServerError)
 		return
 	}
+	im.Events.Emit(r.Context(), events.ProductCreated, product.GetProduct().GetId(), product.GetProduct())
+	if etag := productETag(product.GetProduct()); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(product); err != nil {
+	if err := render.Write(w, r, http.StatusOK, product); err != nil {
 		http.Error(w, "failed to encode result", http.StatusInternalServerError)
 	}
 }
 
 func (im *InvManager) GetHandler(w http.ResponseWriter, r *http.Request) {
 	var req pbInv.GetRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+	if err := decodeRequest(w, r, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 	defer r.Body.Close()
 
-	p, err := im.Client.GetProduct(r.Context(), &req)
+	p, err := im.Service.GetProduct(r.Context(), &req)
 	if err != nil {
 		http.Error(w, "failed to get product", http.StatusInternalServerError)
 		return
 	}
+	if etag := productETag(p.GetProduct()); etag != "" {
+		w.Header().Set("ETag", etag)
+		if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(p); err != nil {
+	if err := render.Write(w, r, http.StatusOK, p); err != nil {
 		http.Error(w, "failed to encode result", http.StatusInternalServerError)
 		return
 	}
@@ -60,20 +119,26 @@ func (im *InvManager) GetHandler(w http.ResponseWriter, r *http.Request) {
 
 func (im *InvManager) UpdateHandler(w http.ResponseWriter, r *http.Request) {
 	var req pbInv.UpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+	if err := im.decodePriced(w, r, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 	defer r.Body.Close()
 
-	p, err := im.Client.UpdateProduct(r.Context(), &req)
+	if !im.checkIfMatch(w, r, req.GetProduct().GetId()) {
+		return
+	}
+
+	p, err := im.Service.UpdateProduct(r.Context(), &req)
 	if err != nil {
 		http.Error(w, "failed to update product", http.StatusInternalServerError)
 		return
 	}
+	if etag := productETag(p.GetProduct()); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(p); err != nil {
+	if err := render.Write(w, r, http.StatusOK, p); err != nil {
 		http.Error(w, "failed to encode result", http.StatusInternalServerError)
 		return
 	}
@@ -81,42 +146,140 @@ func (im *InvManager) UpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 func (im *InvManager) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	var req pbInv.DeleteRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+	if err := decodeRequest(w, r, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 	defer r.Body.Close()
 
-	resp, err := im.Client.DeleteProduct(r.Context(), &req)
+	if !requireRole(w, r, im.HardDeleteRoles, "hard delete") || !im.checkIfMatch(w, r, req.GetId()) {
+		return
+	}
+
+	resp, err := im.Service.DeleteProduct(r.Context(), &req)
 	if err != nil {
 		http.Error(w, "failed to delete product", http.StatusInternalServerError)
 		return
 	}
+	im.Events.Emit(r.Context(), events.ProductDeleted, req.GetId(), nil)
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
+	if err := render.Write(w, r, http.StatusOK, resp); err != nil {
 		http.Error(w, "failed to encode result", http.StatusInternalServerError)
 		return
 	}
 }
 
+// ListHandler lists products. With a Pager, the page size is bounded and
+// the response carries next/prev Link headers whose ?cursor= parameter
+// replaces the paging fields of the body, which may then be empty.
 func (im *InvManager) ListHandler(w http.ResponseWriter, r *http.Request) {
 	var req pbInv.ListRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+	if err := decodeRequest(w, r, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeDecodeError(w, err)
 		return
 	}
 	defer r.Body.Close()
 
-	resp, err := im.Client.ListProducts(r.Context(), &req)
+	if render.Accepts(r.Header.Get("Accept"), NDJSON) {
+		im.streamList(w, r, &req)
+		return
+	}
+
+	var page pagination.Cursor
+	if im.Pager != nil {
+		if raw := r.URL.Query().Get("cursor"); raw != "" {
+			c, err := im.Pager.Decode(raw)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			req.PrevSize, req.PageSize, req.Filter, req.OrderBy = c.Offset, c.PageSize, c.Filter, c.OrderBy
+		}
+		req.PageSize = im.Pager.PageSize(req.PageSize)
+		page = pagination.Cursor{Offset: max(0, req.PrevSize), PageSize: req.PageSize, Filter: req.Filter, OrderBy: req.OrderBy}
+	}
+
+	resp, err := im.Service.ListProducts(r.Context(), &req)
 	if err != nil {
 		http.Error(w, "failed to list products", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
+	if im.Pager != nil {
+		if links := im.Pager.Links(r.URL, page, len(resp.Products)); links != "" {
+			w.Header().Set("Link", links)
+		}
+	}
+
+	if err := render.Write(w, r, http.StatusOK, resp); err != nil {
 		http.Error(w, "failed to encode result", http.StatusInternalServerError)
 		return
 	}
 }
+
+// streamList pages through the backend starting at req.PrevSize and writes
+// one product per line, flushing after every page, so the full catalog is
+// never buffered. A positive req.PageSize caps the number of products sent.
+// Errors after the first line are reported as a final {"error": ...} line.
+func (im *InvManager) streamList(w http.ResponseWriter, r *http.Request, req *pbInv.ListRequest) {
+	pageSize := im.StreamPageSize
+	if pageSize <= 0 {
+		pageSize = 500
+	}
+	limit := req.PageSize
+	offset := req.PrevSize
+
+	fields := r.URL.Query().Get(render.FieldsParam)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	sent := int32(0)
+	for limit <= 0 || sent < limit {
+		page := &pbInv.ListRequest{
+			PrevSize: offset,
+			PageSize: pageSize,
+			Filter:   req.Filter,
+			OrderBy:  req.OrderBy,
+		}
+		if limit > 0 && limit-sent < pageSize {
+			page.PageSize = limit - sent
+		}
+
+		resp, err := im.Service.ListProducts(r.Context(), page)
+		if err != nil {
+			if sent == 0 {
+				http.Error(w, "failed to list products", http.StatusInternalServerError)
+				return
+			}
+			_ = enc.Encode(map[string]string{"error": "failed to list products"})
+			return
+		}
+		if sent == 0 {
+			w.Header().Set("Content-Type", NDJSON)
+			w.Header().Add("Vary", "Accept")
+		}
+
+		_ = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		for _, p := range resp.Products {
+			line, err := render.Prepare(r, p, fields)
+			if err != nil {
+				_ = enc.Encode(map[string]string{"error": "failed to encode product"})
+				return
+			}
+			if err := enc.Encode(line); err != nil {
+				// client went away
+				return
+			}
+		}
+		sent += int32(len(resp.Products))
+		offset += int32(len(resp.Products))
+		_ = rc.Flush()
+
+		if int32(len(resp.Products)) < page.PageSize {
+			break
+		}
+	}
+	if sent == 0 {
+		w.Header().Set("Content-Type", NDJSON)
+		w.WriteHeader(http.StatusOK)
+	}
+}
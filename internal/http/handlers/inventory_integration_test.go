@@ -1,6 +1,7 @@
 package handlers_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -8,63 +9,77 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/andro-kes/gateway/internal/events"
 	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/money"
+	"github.com/andro-kes/gateway/internal/pagination"
+	"github.com/andro-kes/gateway/internal/token"
 	pbInv "github.com/andro-kes/inventory_service/proto"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// mockInventoryServiceClient is a mock implementation of pbInv.InventoryServiceClient
-type mockInventoryServiceClient struct {
-	pbInv.InventoryServiceClient
-	createProductFunc func(ctx context.Context, in *pbInv.CreateRequest, opts ...grpc.CallOption) (*pbInv.CreateResponse, error)
-	getProductFunc    func(ctx context.Context, in *pbInv.GetRequest, opts ...grpc.CallOption) (*pbInv.GetResponse, error)
-	updateProductFunc func(ctx context.Context, in *pbInv.UpdateRequest, opts ...grpc.CallOption) (*pbInv.UpdateResponse, error)
-	deleteProductFunc func(ctx context.Context, in *pbInv.DeleteRequest, opts ...grpc.CallOption) (*pbInv.DeleteResponse, error)
-	listProductsFunc  func(ctx context.Context, in *pbInv.ListRequest, opts ...grpc.CallOption) (*pbInv.ListResponse, error)
+// mockInventoryService is a mock implementation of handlers.InventoryService
+type mockInventoryService struct {
+	createProductFunc func(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error)
+	getProductFunc    func(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error)
+	updateProductFunc func(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error)
+	deleteProductFunc func(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error)
+	listProductsFunc  func(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error)
 }
 
-func (m *mockInventoryServiceClient) CreateProduct(ctx context.Context, in *pbInv.CreateRequest, opts ...grpc.CallOption) (*pbInv.CreateResponse, error) {
+func (m *mockInventoryService) CreateProduct(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
 	if m.createProductFunc != nil {
-		return m.createProductFunc(ctx, in, opts...)
+		return m.createProductFunc(ctx, in)
 	}
 	return nil, fmt.Errorf("createProductFunc not implemented")
 }
 
-func (m *mockInventoryServiceClient) GetProduct(ctx context.Context, in *pbInv.GetRequest, opts ...grpc.CallOption) (*pbInv.GetResponse, error) {
+func (m *mockInventoryService) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
 	if m.getProductFunc != nil {
-		return m.getProductFunc(ctx, in, opts...)
+		return m.getProductFunc(ctx, in)
 	}
 	return nil, fmt.Errorf("getProductFunc not implemented")
 }
 
-func (m *mockInventoryServiceClient) UpdateProduct(ctx context.Context, in *pbInv.UpdateRequest, opts ...grpc.CallOption) (*pbInv.UpdateResponse, error) {
+func (m *mockInventoryService) UpdateProduct(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error) {
 	if m.updateProductFunc != nil {
-		return m.updateProductFunc(ctx, in, opts...)
+		return m.updateProductFunc(ctx, in)
 	}
 	return nil, fmt.Errorf("updateProductFunc not implemented")
 }
 
-func (m *mockInventoryServiceClient) DeleteProduct(ctx context.Context, in *pbInv.DeleteRequest, opts ...grpc.CallOption) (*pbInv.DeleteResponse, error) {
+func (m *mockInventoryService) DeleteProduct(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error) {
 	if m.deleteProductFunc != nil {
-		return m.deleteProductFunc(ctx, in, opts...)
+		return m.deleteProductFunc(ctx, in)
 	}
 	return nil, fmt.Errorf("deleteProductFunc not implemented")
 }
 
-func (m *mockInventoryServiceClient) ListProducts(ctx context.Context, in *pbInv.ListRequest, opts ...grpc.CallOption) (*pbInv.ListResponse, error) {
+func (m *mockInventoryService) ListProducts(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error) {
 	if m.listProductsFunc != nil {
-		return m.listProductsFunc(ctx, in, opts...)
+		return m.listProductsFunc(ctx, in)
 	}
 	return nil, fmt.Errorf("listProductsFunc not implemented")
 }
 
 // setupInventoryTestRouter creates a test router with the inventory handlers
-func setupInventoryTestRouter(mockClient pbInv.InventoryServiceClient) *chi.Mux {
+func setupInventoryTestRouter(mockClient handlers.InventoryService) *chi.Mux {
 	invManager := handlers.NewInvManager(mockClient)
 	r := chi.NewRouter()
 
@@ -81,8 +96,8 @@ func setupInventoryTestRouter(mockClient pbInv.InventoryServiceClient) *chi.Mux
 
 // TestCreateHandler_Success tests successful product creation
 func TestCreateHandler_Success(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		createProductFunc: func(ctx context.Context, in *pbInv.CreateRequest, opts ...grpc.CallOption) (*pbInv.CreateResponse, error) {
+	mockClient := &mockInventoryService{
+		createProductFunc: func(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
 			assert.NotNil(t, in.Product)
 			assert.Equal(t, "Test Product", in.Product.Name)
 			assert.Equal(t, "Test Description", in.Product.Description)
@@ -139,9 +154,67 @@ func TestCreateHandler_Success(t *testing.T) {
 	assert.Equal(t, "Test Description", product["description"])
 }
 
+// TestCreateHandler_Protobuf tests protobuf binary request and response bodies
+func TestCreateHandler_Protobuf(t *testing.T) {
+	renderer := render.NewRegistry(render.JSON{}, render.Protobuf{})
+
+	mockClient := &mockInventoryService{
+		createProductFunc: func(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
+			assert.Equal(t, "Test Product", in.Product.Name)
+			assert.Equal(t, int32(100), in.Product.Quantity)
+			return &pbInv.CreateResponse{Product: &pbInv.Product{Id: "prod-123", Name: in.Product.Name}}, nil
+		},
+	}
+
+	router := setupInventoryTestRouter(mockClient)
+	ts := httptest.NewServer(renderer.Middleware(router))
+	defer ts.Close()
+
+	body, err := proto.Marshal(&pbInv.CreateRequest{Product: &pbInv.Product{Name: "Test Product", Quantity: 100}})
+	require.NoError(t, err)
+	req, err := http.NewRequest("POST", ts.URL+"/inventory/create", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Accept", "application/x-protobuf")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-protobuf", resp.Header.Get("Content-Type"))
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var out pbInv.CreateResponse
+	require.NoError(t, proto.Unmarshal(data, &out))
+	assert.Equal(t, "prod-123", out.Product.Id)
+	assert.Equal(t, "Test Product", out.Product.Name)
+}
+
+// TestCreateHandler_TooLarge tests that oversized bodies are refused before reaching the backend
+func TestCreateHandler_TooLarge(t *testing.T) {
+	mockClient := &mockInventoryService{
+		createProductFunc: func(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
+			t.Error("backend called with an oversized body")
+			return &pbInv.CreateResponse{}, nil
+		},
+	}
+	ts := httptest.NewServer(setupInventoryTestRouter(mockClient))
+	defer ts.Close()
+
+	body, err := proto.Marshal(&pbInv.CreateRequest{Product: &pbInv.Product{Name: "Test Product", Description: strings.Repeat("x", 2<<20)}})
+	require.NoError(t, err)
+	for _, contentType := range []string{"application/x-protobuf", "application/json"} {
+		resp, err := http.Post(ts.URL+"/inventory/create", contentType, bytes.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, contentType)
+	}
+}
+
 // TestCreateHandler_InvalidJSON tests create with malformed JSON
 func TestCreateHandler_InvalidJSON(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{}
+	mockClient := &mockInventoryService{}
 	router := setupInventoryTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -159,8 +232,8 @@ func TestCreateHandler_InvalidJSON(t *testing.T) {
 
 // TestCreateHandler_GRPCFailure tests create when the gRPC call fails
 func TestCreateHandler_GRPCFailure(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		createProductFunc: func(ctx context.Context, in *pbInv.CreateRequest, opts ...grpc.CallOption) (*pbInv.CreateResponse, error) {
+	mockClient := &mockInventoryService{
+		createProductFunc: func(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
 			return nil, fmt.Errorf("database connection failed")
 		},
 	}
@@ -189,8 +262,8 @@ func TestCreateHandler_GRPCFailure(t *testing.T) {
 
 // TestGetHandler_Success tests successful product retrieval
 func TestGetHandler_Success(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		getProductFunc: func(ctx context.Context, in *pbInv.GetRequest, opts ...grpc.CallOption) (*pbInv.GetResponse, error) {
+	mockClient := &mockInventoryService{
+		getProductFunc: func(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
 			assert.Equal(t, "prod-456", in.Id)
 
 			return &pbInv.GetResponse{
@@ -239,7 +312,7 @@ func TestGetHandler_Success(t *testing.T) {
 
 // TestGetHandler_InvalidJSON tests get with malformed JSON
 func TestGetHandler_InvalidJSON(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{}
+	mockClient := &mockInventoryService{}
 	router := setupInventoryTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -254,8 +327,8 @@ func TestGetHandler_InvalidJSON(t *testing.T) {
 
 // TestGetHandler_GRPCFailure tests get when the gRPC call fails
 func TestGetHandler_GRPCFailure(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		getProductFunc: func(ctx context.Context, in *pbInv.GetRequest, opts ...grpc.CallOption) (*pbInv.GetResponse, error) {
+	mockClient := &mockInventoryService{
+		getProductFunc: func(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
 			return nil, fmt.Errorf("product not found")
 		},
 	}
@@ -282,8 +355,8 @@ func TestGetHandler_GRPCFailure(t *testing.T) {
 
 // TestUpdateHandler_Success tests successful product update
 func TestUpdateHandler_Success(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		updateProductFunc: func(ctx context.Context, in *pbInv.UpdateRequest, opts ...grpc.CallOption) (*pbInv.UpdateResponse, error) {
+	mockClient := &mockInventoryService{
+		updateProductFunc: func(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error) {
 			assert.NotNil(t, in.Product)
 			assert.Equal(t, "prod-789", in.Product.Id)
 			assert.Equal(t, "Updated Product", in.Product.Name)
@@ -339,7 +412,7 @@ func TestUpdateHandler_Success(t *testing.T) {
 
 // TestUpdateHandler_InvalidJSON tests update with malformed JSON
 func TestUpdateHandler_InvalidJSON(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{}
+	mockClient := &mockInventoryService{}
 	router := setupInventoryTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -354,8 +427,8 @@ func TestUpdateHandler_InvalidJSON(t *testing.T) {
 
 // TestUpdateHandler_GRPCFailure tests update when the gRPC call fails
 func TestUpdateHandler_GRPCFailure(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		updateProductFunc: func(ctx context.Context, in *pbInv.UpdateRequest, opts ...grpc.CallOption) (*pbInv.UpdateResponse, error) {
+	mockClient := &mockInventoryService{
+		updateProductFunc: func(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error) {
 			return nil, fmt.Errorf("update failed")
 		},
 	}
@@ -385,8 +458,8 @@ func TestUpdateHandler_GRPCFailure(t *testing.T) {
 
 // TestDeleteHandler_Success tests successful product deletion
 func TestDeleteHandler_Success(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		deleteProductFunc: func(ctx context.Context, in *pbInv.DeleteRequest, opts ...grpc.CallOption) (*pbInv.DeleteResponse, error) {
+	mockClient := &mockInventoryService{
+		deleteProductFunc: func(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error) {
 			assert.Equal(t, "prod-999", in.Id)
 
 			return &pbInv.DeleteResponse{
@@ -427,7 +500,7 @@ func TestDeleteHandler_Success(t *testing.T) {
 
 // TestDeleteHandler_InvalidJSON tests delete with malformed JSON
 func TestDeleteHandler_InvalidJSON(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{}
+	mockClient := &mockInventoryService{}
 	router := setupInventoryTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -442,8 +515,8 @@ func TestDeleteHandler_InvalidJSON(t *testing.T) {
 
 // TestDeleteHandler_GRPCFailure tests delete when the gRPC call fails
 func TestDeleteHandler_GRPCFailure(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		deleteProductFunc: func(ctx context.Context, in *pbInv.DeleteRequest, opts ...grpc.CallOption) (*pbInv.DeleteResponse, error) {
+	mockClient := &mockInventoryService{
+		deleteProductFunc: func(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error) {
 			return nil, fmt.Errorf("delete failed")
 		},
 	}
@@ -470,8 +543,8 @@ func TestDeleteHandler_GRPCFailure(t *testing.T) {
 
 // TestListHandler_Success tests successful product listing
 func TestListHandler_Success(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		listProductsFunc: func(ctx context.Context, in *pbInv.ListRequest, opts ...grpc.CallOption) (*pbInv.ListResponse, error) {
+	mockClient := &mockInventoryService{
+		listProductsFunc: func(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error) {
 			assert.Equal(t, int32(10), in.PageSize)
 			assert.Equal(t, "name", in.OrderBy)
 
@@ -542,7 +615,7 @@ func TestListHandler_Success(t *testing.T) {
 
 // TestListHandler_InvalidJSON tests list with malformed JSON
 func TestListHandler_InvalidJSON(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{}
+	mockClient := &mockInventoryService{}
 	router := setupInventoryTestRouter(mockClient)
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -557,8 +630,8 @@ func TestListHandler_InvalidJSON(t *testing.T) {
 
 // TestListHandler_GRPCFailure tests list when the gRPC call fails
 func TestListHandler_GRPCFailure(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		listProductsFunc: func(ctx context.Context, in *pbInv.ListRequest, opts ...grpc.CallOption) (*pbInv.ListResponse, error) {
+	mockClient := &mockInventoryService{
+		listProductsFunc: func(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error) {
 			return nil, fmt.Errorf("database query failed")
 		},
 	}
@@ -585,8 +658,8 @@ func TestListHandler_GRPCFailure(t *testing.T) {
 
 // TestListHandler_EmptyList tests list when no products are returned
 func TestListHandler_EmptyList(t *testing.T) {
-	mockClient := &mockInventoryServiceClient{
-		listProductsFunc: func(ctx context.Context, in *pbInv.ListRequest, opts ...grpc.CallOption) (*pbInv.ListResponse, error) {
+	mockClient := &mockInventoryService{
+		listProductsFunc: func(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error) {
 			return &pbInv.ListResponse{
 				Products:  []*pbInv.Product{},
 				TotalSize: 0,
@@ -625,3 +698,534 @@ func TestListHandler_EmptyList(t *testing.T) {
 		assert.Equal(t, float64(0), totalSize)
 	}
 }
+
+// catalogClient serves ListProducts pages from a catalog of n products, failing from offset failFrom unless it is negative
+func catalogClient(n int, failFrom int32, calls *[]*pbInv.ListRequest) *mockInventoryService {
+	return &mockInventoryService{
+		listProductsFunc: func(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error) {
+			*calls = append(*calls, in)
+			if failFrom >= 0 && in.PrevSize >= failFrom {
+				return nil, fmt.Errorf("backend unavailable")
+			}
+			resp := &pbInv.ListResponse{}
+			for i := in.PrevSize; i < in.PrevSize+in.PageSize && int(i) < n; i++ {
+				resp.Products = append(resp.Products, &pbInv.Product{Id: fmt.Sprintf("p%d", i)})
+			}
+			return resp, nil
+		},
+	}
+}
+
+func streamList(t *testing.T, client handlers.InventoryService, body string) (*http.Response, []string) {
+	t.Helper()
+	invManager := handlers.NewInvManager(client)
+	invManager.StreamPageSize = 3
+	ts := httptest.NewServer(http.HandlerFunc(invManager.ListHandler))
+	t.Cleanup(ts.Close)
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", handlers.NDJSON)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return resp, lines
+}
+
+// TestListHandler_NDJSON tests that the catalog is paged through and streamed line by line
+func TestListHandler_NDJSON(t *testing.T) {
+	var calls []*pbInv.ListRequest
+	resp, lines := streamList(t, catalogClient(7, -1, &calls), `{"filter":"tea"}`)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, handlers.NDJSON, resp.Header.Get("Content-Type"))
+	require.Len(t, lines, 7)
+	assert.JSONEq(t, `{"id":"p0"}`, lines[0])
+	assert.JSONEq(t, `{"id":"p6"}`, lines[6])
+
+	require.Len(t, calls, 3)
+	assert.Equal(t, int32(6), calls[2].PrevSize)
+	assert.Equal(t, "tea", calls[2].Filter)
+}
+
+// TestListHandler_NDJSONLimit tests that page_size caps the streamed products
+func TestListHandler_NDJSONLimit(t *testing.T) {
+	var calls []*pbInv.ListRequest
+	_, lines := streamList(t, catalogClient(100, -1, &calls), `{"prev_size":2,"page_size":4}`)
+
+	require.Len(t, lines, 4)
+	assert.JSONEq(t, `{"id":"p2"}`, lines[0])
+	assert.JSONEq(t, `{"id":"p5"}`, lines[3])
+	require.Len(t, calls, 2)
+	assert.Equal(t, int32(1), calls[1].PageSize)
+}
+
+// TestListHandler_NDJSONError tests that a failure after the first page ends the stream with an error line
+func TestListHandler_NDJSONError(t *testing.T) {
+	var calls []*pbInv.ListRequest
+	resp, lines := streamList(t, catalogClient(100, 3, &calls), `{}`)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, lines, 4)
+	assert.JSONEq(t, `{"error":"failed to list products"}`, lines[3])
+
+	resp, _ = streamList(t, catalogClient(100, 0, &calls), `{}`)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+// TestListHandler_Cursor tests page size capping and following the next link
+func TestListHandler_Cursor(t *testing.T) {
+	var calls []*pbInv.ListRequest
+	invManager := handlers.NewInvManager(catalogClient(5, -1, &calls))
+	pager, err := pagination.New(pagination.Config{MaxPageSize: 3})
+	require.NoError(t, err)
+	invManager.Pager = pager
+
+	r := chi.NewRouter()
+	r.Post("/inventory/list", invManager.ListHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/inventory/list", "application/json", strings.NewReader(`{"page_size":1000000,"filter":"tea"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), calls[0].PageSize)
+
+	next := regexp.MustCompile(`<([^>]+)>; rel="next"`).FindStringSubmatch(resp.Header.Get("Link"))
+	require.Len(t, next, 2)
+	resp, err = http.Post(ts.URL+next[1], "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body pbInv.ListResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Len(t, body.Products, 2)
+	assert.Equal(t, "p3", body.Products[0].Id)
+	assert.Equal(t, "tea", calls[1].Filter)
+	assert.Contains(t, resp.Header.Get("Link"), `rel="prev"`)
+	assert.NotContains(t, resp.Header.Get("Link"), `rel="next"`)
+
+	resp, err = http.Post(ts.URL+"/inventory/list?cursor=forged", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// versionedClient keeps one product whose updated_at changes on every update
+func versionedClient(updates *int) *mockInventoryService {
+	version := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	product := func() *pbInv.Product {
+		return &pbInv.Product{Id: "prod-1", Name: "Tea", UpdatedAt: timestamppb.New(version)}
+	}
+	return &mockInventoryService{
+		getProductFunc: func(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+			if in.Id != "prod-1" {
+				return nil, status.Error(codes.NotFound, "no such product")
+			}
+			return &pbInv.GetResponse{Product: product()}, nil
+		},
+		updateProductFunc: func(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error) {
+			*updates++
+			version = version.Add(time.Second)
+			return &pbInv.UpdateResponse{Product: product()}, nil
+		},
+		deleteProductFunc: func(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error) {
+			return &pbInv.DeleteResponse{Success: true}, nil
+		},
+	}
+}
+
+func sendWithHeader(t *testing.T, url, body, header, value string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+// TestUpdateHandler_IfMatch tests optimistic concurrency with product ETags
+func TestUpdateHandler_IfMatch(t *testing.T) {
+	var updates int
+	ts := httptest.NewServer(setupInventoryTestRouter(versionedClient(&updates)))
+	defer ts.Close()
+
+	resp := sendWithHeader(t, ts.URL+"/inventory/get", `{"id":"prod-1"}`, "", "")
+	etag := resp.Header.Get("ETag")
+	require.Regexp(t, `^"[0-9a-f]{24}"$`, etag)
+
+	resp = sendWithHeader(t, ts.URL+"/inventory/get", `{"id":"prod-1"}`, "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	update := `{"product":{"id":"prod-1","name":"Green tea"}}`
+	resp = sendWithHeader(t, ts.URL+"/inventory/update", update, "If-Match", etag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	newETag := resp.Header.Get("ETag")
+	assert.NotEqual(t, etag, newETag)
+
+	// a second client still holding the old version loses
+	resp = sendWithHeader(t, ts.URL+"/inventory/update", update, "If-Match", etag)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, newETag, resp.Header.Get("ETag"))
+	assert.Equal(t, 1, updates)
+
+	resp = sendWithHeader(t, ts.URL+"/inventory/delete", `{"id":"prod-1"}`, "If-Match", `W/`+newETag)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp = sendWithHeader(t, ts.URL+"/inventory/delete", `{"id":"prod-1"}`, "If-Match", newETag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = sendWithHeader(t, ts.URL+"/inventory/delete", `{"id":"missing"}`, "If-Match", "*")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+}
+
+// TestUpdateHandler_RequireIfMatch tests that writes without If-Match can be refused
+func TestUpdateHandler_RequireIfMatch(t *testing.T) {
+	var updates int
+	invManager := handlers.NewInvManager(versionedClient(&updates))
+	invManager.RequireIfMatch = true
+	ts := httptest.NewServer(http.HandlerFunc(invManager.UpdateHandler))
+	defer ts.Close()
+
+	resp := sendWithHeader(t, ts.URL, `{"product":{"id":"prod-1"}}`, "", "")
+	assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+	resp = sendWithHeader(t, ts.URL, `{"product":{"id":"prod-1"}}`, "If-Match", "*")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, updates)
+}
+
+// withClaims is a stand-in for the authenticator that attaches fixed claims
+func withClaims(claims *token.Claims) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims != nil {
+				r = r.WithContext(token.WithClaims(r.Context(), claims))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func setupProductsTestRouter(client handlers.InventoryService, claims *token.Claims) *chi.Mux {
+	invManager := handlers.NewInvManager(client)
+	invManager.HardDeleteRoles = []string{"admin"}
+	r := chi.NewRouter()
+	r.Use(withClaims(claims))
+	r.Post("/inventory/delete", invManager.DeleteHandler)
+	r.Delete("/inventory/products/{id}", invManager.ProductDeleteHandler)
+	r.Post("/inventory/products/{id}/restore", invManager.RestoreHandler)
+	return r
+}
+
+// TestProductDeleteHandler_Soft tests that soft deletes and restores toggle availability
+func TestProductDeleteHandler_Soft(t *testing.T) {
+	var updates []*pbInv.UpdateRequest
+	mockClient := &mockInventoryService{
+		updateProductFunc: func(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error) {
+			updates = append(updates, in)
+			if in.Product.Id != "prod-1" {
+				return nil, status.Error(codes.NotFound, "no such product")
+			}
+			return &pbInv.UpdateResponse{Product: &pbInv.Product{Id: in.Product.Id, Available: in.Product.Available}}, nil
+		},
+	}
+	ts := httptest.NewServer(setupProductsTestRouter(mockClient, nil))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/inventory/products/prod-1?soft=true", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+
+	resp, err = http.Post(ts.URL+"/inventory/products/prod-1/restore", "application/json", nil)
+	require.NoError(t, err)
+	var result pbInv.UpdateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, result.Product.Available)
+
+	resp, err = http.Post(ts.URL+"/inventory/products/missing/restore", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	require.Len(t, updates, 3)
+	assert.False(t, updates[0].Product.Available)
+	assert.True(t, updates[1].Product.Available)
+	for _, u := range updates {
+		assert.Equal(t, []string{"available"}, u.UpdateMask.GetPaths())
+	}
+}
+
+// TestProductDeleteHandler_HardDeleteRoles tests the role policy of permanent deletes
+func TestProductDeleteHandler_HardDeleteRoles(t *testing.T) {
+	var deleted []string
+	mockClient := &mockInventoryService{
+		deleteProductFunc: func(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error) {
+			deleted = append(deleted, in.Id)
+			return &pbInv.DeleteResponse{Success: true}, nil
+		},
+	}
+
+	tests := []struct {
+		name   string
+		claims *token.Claims
+		status int
+	}{
+		{"no claims", nil, http.StatusForbidden},
+		{"missing role", &token.Claims{Roles: []string{"user"}, Verified: true}, http.StatusForbidden},
+		{"unverified token", &token.Claims{Roles: []string{"admin"}}, http.StatusForbidden},
+		{"admin", &token.Claims{Roles: []string{"admin"}, Verified: true}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted = nil
+			ts := httptest.NewServer(setupProductsTestRouter(mockClient, tt.claims))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+"/inventory/products/prod-1", nil)
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			resp, err = http.Post(ts.URL+"/inventory/delete", "application/json", strings.NewReader(`{"id":"prod-2"}`))
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			if tt.status == http.StatusOK {
+				assert.Equal(t, []string{"prod-1", "prod-2"}, deleted)
+			} else {
+				assert.Empty(t, deleted)
+			}
+		})
+	}
+}
+
+// TestAdjustHandler tests stock adjustments and their audit log
+func TestAdjustHandler(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "gateway.log")
+	require.NoError(t, logger.Init(logger.Config{Level: "info", OutputPaths: []string{logPath}}))
+	t.Cleanup(func() { logger.Init(logger.Config{Level: "info"}) })
+
+	quantity := int32(5)
+	var masks [][]string
+	mockClient := &mockInventoryService{
+		getProductFunc: func(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+			if in.Id != "prod-1" {
+				return nil, status.Error(codes.NotFound, "no such product")
+			}
+			return &pbInv.GetResponse{Product: &pbInv.Product{Id: "prod-1", Quantity: quantity}}, nil
+		},
+		updateProductFunc: func(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error) {
+			masks = append(masks, in.UpdateMask.GetPaths())
+			quantity = in.Product.Quantity
+			return &pbInv.UpdateResponse{Product: &pbInv.Product{Id: "prod-1", Quantity: quantity}}, nil
+		},
+	}
+	invManager := handlers.NewInvManager(mockClient)
+	r := chi.NewRouter()
+	r.Use(withClaims(&token.Claims{UserID: "user-7", Verified: true}))
+	r.Post("/inventory/products/{id}/adjust", invManager.AdjustHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	adjust := func(id, body string) int {
+		resp, err := http.Post(ts.URL+"/inventory/products/"+id+"/adjust", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, adjust("prod-1", `{"delta":-3,"reason":"sale"}`))
+	assert.Equal(t, int32(2), quantity)
+	assert.Equal(t, http.StatusConflict, adjust("prod-1", `{"delta":-3,"reason":"sale"}`))
+	assert.Equal(t, http.StatusBadRequest, adjust("prod-1", `{"delta":0,"reason":"sale"}`))
+	assert.Equal(t, http.StatusBadRequest, adjust("prod-1", `{"delta":1}`))
+	assert.Equal(t, http.StatusNotFound, adjust("missing", `{"delta":1,"reason":"recount"}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, adjust("prod-1", `{"delta":1,"reason":"`+strings.Repeat("x", 2<<20)+`"}`))
+	assert.Equal(t, int32(2), quantity)
+	assert.Equal(t, [][]string{{"quantity"}}, masks)
+
+	data, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "Stock adjusted", entry["msg"])
+	assert.Equal(t, "user-7", entry["user_id"])
+	assert.Equal(t, "prod-1", entry["product_id"])
+	assert.Equal(t, "sale", entry["reason"])
+	assert.EqualValues(t, -3, entry["delta"])
+	assert.EqualValues(t, 5, entry["quantity_before"])
+	assert.EqualValues(t, 2, entry["quantity_after"])
+}
+
+// TestAvailabilityHandler tests availability changes and their role policy
+func TestAvailabilityHandler(t *testing.T) {
+	var updates []*pbInv.UpdateRequest
+	mockClient := &mockInventoryService{
+		updateProductFunc: func(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error) {
+			updates = append(updates, in)
+			return &pbInv.UpdateResponse{Product: in.Product}, nil
+		},
+	}
+	newServer := func(claims *token.Claims) *httptest.Server {
+		invManager := handlers.NewInvManager(mockClient)
+		invManager.AvailabilityRoles = []string{"storefront-admin"}
+		r := chi.NewRouter()
+		r.Use(withClaims(claims))
+		r.Post("/inventory/products/{id}/availability", invManager.AvailabilityHandler)
+		r.Delete("/inventory/products/{id}", invManager.ProductDeleteHandler)
+		return httptest.NewServer(r)
+	}
+
+	ts := newServer(&token.Claims{Roles: []string{"storefront-admin"}, Verified: true})
+	defer ts.Close()
+	resp, err := http.Post(ts.URL+"/inventory/products/prod-1/availability", "application/json", strings.NewReader(`{"available":false}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, updates, 1)
+	assert.Equal(t, "prod-1", updates[0].Product.Id)
+	assert.False(t, updates[0].Product.Available)
+	assert.Equal(t, []string{"available"}, updates[0].UpdateMask.GetPaths())
+
+	resp, err = http.Post(ts.URL+"/inventory/products/prod-1/availability", "application/json", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(ts.URL+"/inventory/products/prod-1/availability", "application/json", strings.NewReader(`{"available":false,"pad":"`+strings.Repeat("x", 2<<20)+`"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	require.Len(t, updates, 1)
+
+	other := newServer(&token.Claims{Roles: []string{"user"}, Verified: true})
+	defer other.Close()
+	resp, err = http.Post(other.URL+"/inventory/products/prod-1/availability", "application/json", strings.NewReader(`{"available":true}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	req, err := http.NewRequest(http.MethodDelete, other.URL+"/inventory/products/prod-1?soft=true", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Len(t, updates, 1)
+}
+
+// TestCreateHandler_DecimalPrice tests decimal price validation and conversion
+func TestCreateHandler_DecimalPrice(t *testing.T) {
+	var prices []float64
+	mockClient := &mockInventoryService{
+		createProductFunc: func(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
+			prices = append(prices, in.Product.Price)
+			return &pbInv.CreateResponse{Product: in.Product}, nil
+		},
+	}
+	invManager := handlers.NewInvManager(mockClient)
+	var err error
+	invManager.Money, err = money.New(money.Config{Currency: "USD"})
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(invManager.CreateHandler))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"product":{"name":"Tea","price":"29.99","currency":"USD"}}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Post(ts.URL, "application/json", strings.NewReader(`{"product":{"name":"Tea","price":"29.999"}}`))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), "too many decimal places")
+
+	resp, err = http.Post(ts.URL, "application/json", strings.NewReader(`{"product":`))
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), "failed to decode request body")
+
+	assert.Equal(t, []float64{29.99}, prices)
+}
+
+// eventRecorder is an events.Publisher keeping everything published
+type eventRecorder struct {
+	mu   sync.Mutex
+	msgs []events.Message
+}
+
+func (p *eventRecorder) Publish(ctx context.Context, msgs []events.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, msgs...)
+	return nil
+}
+
+func (p *eventRecorder) Close() error { return nil }
+
+// TestInventoryEvents tests that successful mutations publish events and failed ones do not
+func TestInventoryEvents(t *testing.T) {
+	mockClient := &mockInventoryService{
+		createProductFunc: func(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
+			if in.Product.Name == "" {
+				return nil, status.Error(codes.InvalidArgument, "name required")
+			}
+			return &pbInv.CreateResponse{Product: &pbInv.Product{Id: "prod-1", Name: in.Product.Name}}, nil
+		},
+		deleteProductFunc: func(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error) {
+			return &pbInv.DeleteResponse{Success: true}, nil
+		},
+	}
+	recorder := &eventRecorder{}
+	emitter := events.NewWithPublisher(events.Config{}, recorder)
+	invManager := handlers.NewInvManager(mockClient)
+	invManager.Events = emitter
+	r := chi.NewRouter()
+	r.Post("/inventory/create", invManager.CreateHandler)
+	r.Post("/inventory/delete", invManager.DeleteHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, body := range []string{`{"product":{"name":"Tea"}}`, `{"product":{}}`} {
+		resp, err := http.Post(ts.URL+"/inventory/create", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	resp, err := http.Post(ts.URL+"/inventory/delete", "application/json", strings.NewReader(`{"id":"prod-1"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NoError(t, emitter.Close(context.Background()))
+
+	require.Len(t, recorder.msgs, 2)
+	var created, deleted events.Event
+	require.NoError(t, json.Unmarshal(recorder.msgs[0].Value, &created))
+	require.NoError(t, json.Unmarshal(recorder.msgs[1].Value, &deleted))
+	assert.Equal(t, events.ProductCreated, created.Type)
+	assert.Equal(t, "prod-1", created.Subject)
+	assert.Equal(t, "Tea", created.Data.(map[string]any)["name"])
+	assert.Equal(t, events.ProductDeleted, deleted.Type)
+	assert.Equal(t, "prod-1", deleted.Subject)
+}
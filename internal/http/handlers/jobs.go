@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/jobs"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/go-chi/chi/v5"
+)
+
+type JobsManager struct {
+	Runner *jobs.Runner
+}
+
+func NewJobsManager(runner *jobs.Runner) *JobsManager {
+	return &JobsManager{
+		Runner: runner,
+	}
+}
+
+// jobView is a job as reported to clients, with a download link in place
+// of the output file.
+type jobView struct {
+	*jobs.Job
+	Output *outputView `json:"output,omitempty"`
+}
+
+type outputView struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	URL         string `json:"url"`
+}
+
+// GetHandler reports the state, progress and result of a job. Jobs of other
+// users are reported as not found.
+func (jm *JobsManager) GetHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jm.load(w, r)
+	if !ok {
+		return
+	}
+	view := jobView{Job: job}
+	if out := job.Output; out != nil {
+		view.Output = &outputView{
+			Name:        out.Name,
+			ContentType: out.ContentType,
+			Size:        len(out.Data),
+			URL:         r.URL.Path + "/output",
+		}
+	}
+	if !job.Finished() {
+		w.Header().Set("Retry-After", "1")
+	}
+	if err := render.Write(w, r, http.StatusOK, view); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+	}
+}
+
+// OutputHandler downloads the file produced by a finished job.
+func (jm *JobsManager) OutputHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jm.load(w, r)
+	if !ok {
+		return
+	}
+	if job.Output == nil {
+		http.Error(w, "job has no output", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", job.Output.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(job.Output.Data)))
+	if job.Output.Name != "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+job.Output.Name+`"`)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(job.Output.Data)
+}
+
+func (jm *JobsManager) load(w http.ResponseWriter, r *http.Request) (*jobs.Job, bool) {
+	job, err := jm.Runner.Get(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, jobs.ErrNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "failed to load job", http.StatusInternalServerError)
+		return nil, false
+	}
+	if job.Owner != "" {
+		claims, ok := token.FromContext(r.Context())
+		if !ok || claims.UserID != job.Owner {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return nil, false
+		}
+	}
+	return job, true
+}
+
+// writeJobAccepted answers 202 with the ID and status URL of a queued job,
+// or 503 when the runner cannot take it.
+func writeJobAccepted(w http.ResponseWriter, r *http.Request, job *jobs.Job, err error) {
+	if err != nil {
+		if errors.Is(err, jobs.ErrQueueFull) {
+			w.Header().Set("Retry-After", "5")
+		}
+		http.Error(w, "job queue unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	location := "/jobs/" + job.ID
+	w.Header().Set("Location", location)
+	out := map[string]string{"id": job.ID, "status": job.State, "url": location}
+	if err := render.Write(w, r, http.StatusAccepted, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,210 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/jobs"
+	"github.com/andro-kes/gateway/internal/token"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupJobsTestRouter(t *testing.T, client handlers.InventoryService, claims *token.Claims) *httptest.Server {
+	runner := jobs.NewWithStore(jobs.Config{}, jobs.NewMemoryStore())
+	t.Cleanup(func() { runner.Close(context.Background()) })
+
+	invManager := handlers.NewInvManager(client)
+	invManager.Jobs = runner
+	invManager.StreamPageSize = 2
+	jobsManager := handlers.NewJobsManager(runner)
+
+	r := chi.NewRouter()
+	r.Use(withClaims(claims))
+	r.Post("/inventory/products/import", invManager.ImportHandler)
+	r.Post("/inventory/products/export", invManager.ExportHandler)
+	r.Get("/jobs/{id}", jobsManager.GetHandler)
+	r.Get("/jobs/{id}/output", jobsManager.OutputHandler)
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// pollJob fetches a job until it has finished
+func pollJob(t *testing.T, url string) map[string]any {
+	t.Helper()
+	var job map[string]any
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		job = nil
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+		return job["state"] == jobs.StateSucceeded || job["state"] == jobs.StateFailed
+	}, time.Second, 5*time.Millisecond)
+	return job
+}
+
+// TestImportHandler tests that a CSV import runs as a job reporting progress and row errors
+func TestImportHandler(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		created []*pbInv.Product
+	)
+	mockClient := &mockInventoryService{
+		createProductFunc: func(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			created = append(created, in.Product)
+			return &pbInv.CreateResponse{Product: in.Product}, nil
+		},
+	}
+	ts := setupJobsTestRouter(t, mockClient, &token.Claims{UserID: "user-1"})
+
+	body := "name,price,quantity,tags,available\n" +
+		"Tea,4.5,10,drink;hot,true\n" +
+		"Cup,abc,1,,\n" +
+		"Mug,7,3,,false\n"
+	resp, err := http.Post(ts.URL+"/inventory/products/import", "text/csv", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var accepted map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&accepted))
+	assert.Equal(t, "queued", accepted["status"])
+	assert.Equal(t, "/jobs/"+accepted["id"], resp.Header.Get("Location"))
+
+	job := pollJob(t, ts.URL+resp.Header.Get("Location"))
+	assert.Equal(t, "succeeded", job["state"])
+	assert.Equal(t, "products.import", job["kind"])
+	assert.Equal(t, map[string]any{"done": 3.0, "total": 3.0}, job["progress"])
+	assert.Equal(t, map[string]any{
+		"created": 2.0,
+		"failed":  1.0,
+		"errors":  []any{map[string]any{"line": 3.0, "error": `invalid price "abc"`}},
+	}, job["result"])
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, created, 2)
+	assert.Equal(t, "Tea", created[0].Name)
+	assert.Equal(t, 4.5, created[0].Price)
+	assert.Equal(t, []string{"drink", "hot"}, created[0].Tags)
+	assert.True(t, created[0].Available)
+	assert.False(t, created[1].Available)
+}
+
+// TestImportHandler_Invalid tests that malformed files are rejected before a job is queued
+func TestImportHandler_Invalid(t *testing.T) {
+	ts := setupJobsTestRouter(t, &mockInventoryService{}, nil)
+
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json", `{}`, http.StatusUnsupportedMediaType},
+		{"text/csv", "", http.StatusBadRequest},
+		{"text/csv", "price\n1\n", http.StatusBadRequest},
+		{"text/csv", "name,color\nTea,green\n", http.StatusBadRequest},
+		{"text/csv", "name\n\"Tea\n", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(ts.URL+"/inventory/products/import", tt.contentType, strings.NewReader(tt.body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, tt.status, resp.StatusCode, tt.body)
+	}
+}
+
+// TestExportHandler tests that the catalog is exported to a downloadable CSV file
+func TestExportHandler(t *testing.T) {
+	mockClient := &mockInventoryService{
+		listProductsFunc: func(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error) {
+			assert.Equal(t, "available", in.Filter)
+			resp := &pbInv.ListResponse{}
+			for i := in.PrevSize; i < in.PrevSize+in.PageSize && i < 3; i++ {
+				resp.Products = append(resp.Products, &pbInv.Product{
+					Id:        fmt.Sprintf("p%d", i),
+					Name:      fmt.Sprintf("Item, %d", i),
+					Price:     1.25,
+					Quantity:  i,
+					Tags:      []string{"a", "b"},
+					Available: true,
+				})
+			}
+			return resp, nil
+		},
+	}
+	ts := setupJobsTestRouter(t, mockClient, &token.Claims{UserID: "user-1"})
+
+	resp, err := http.Post(ts.URL+"/inventory/products/export", "application/json", strings.NewReader(`{"filter":"available"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	job := pollJob(t, ts.URL+resp.Header.Get("Location"))
+	assert.Equal(t, "succeeded", job["state"])
+	assert.Equal(t, 3.0, job["progress"].(map[string]any)["done"])
+	output := job["output"].(map[string]any)
+	assert.Equal(t, "products.csv", output["name"])
+	assert.Equal(t, resp.Header.Get("Location")+"/output", output["url"])
+
+	download, err := http.Get(ts.URL + output["url"].(string))
+	require.NoError(t, err)
+	defer download.Body.Close()
+	require.Equal(t, http.StatusOK, download.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", download.Header.Get("Content-Type"))
+	data, err := io.ReadAll(download.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "id,name,description,price,quantity,tags,available\n"+
+		"p0,\"Item, 0\",,1.25,0,a;b,true\n"+
+		"p1,\"Item, 1\",,1.25,1,a;b,true\n"+
+		"p2,\"Item, 2\",,1.25,2,a;b,true\n", string(data))
+}
+
+// TestJobsGetHandler_Owner tests that jobs are only visible to the user who started them
+func TestJobsGetHandler_Owner(t *testing.T) {
+	runner := jobs.NewWithStore(jobs.Config{}, jobs.NewMemoryStore())
+	t.Cleanup(func() { runner.Close(context.Background()) })
+	ctx := token.WithClaims(context.Background(), &token.Claims{UserID: "user-1"})
+	job, err := runner.Submit(ctx, "noop", func(ctx context.Context, tr *jobs.Tracker) (any, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	jobsManager := handlers.NewJobsManager(runner)
+	for _, tt := range []struct {
+		claims *token.Claims
+		status int
+	}{
+		{&token.Claims{UserID: "user-1"}, http.StatusOK},
+		{&token.Claims{UserID: "user-2"}, http.StatusNotFound},
+		{nil, http.StatusNotFound},
+	} {
+		r := chi.NewRouter()
+		r.Use(withClaims(tt.claims))
+		r.Get("/jobs/{id}", jobsManager.GetHandler)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/jobs/"+job.ID, nil))
+		assert.Equal(t, tt.status, rec.Code)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/jobs/{id}", jobsManager.GetHandler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/jobs/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"net/url"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/notification"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MagicLinkManager serves /auth/magic-link, the passwordless login: users
+// get a link with a one-time token by email, and the page it opens
+// exchanges the token for the tokens of a login.
+type MagicLinkManager struct {
+	Service MagicLinkService
+
+	// Auth completes the logins, as LoginHandler does.
+	Auth *AuthManager
+
+	// Notifications sends the links.
+	Notifications *notification.Dispatcher
+
+	// URL is the page the links open, which posts the token to
+	// /auth/magic-link/verify. The token is added as the token query
+	// parameter.
+	URL *url.URL
+
+	// Template and Subject are those of the link emails.
+	Template string
+	Subject  string
+}
+
+func NewMagicLinkManager(service MagicLinkService, auth *AuthManager, notifications *notification.Dispatcher, link *url.URL) *MagicLinkManager {
+	return &MagicLinkManager{
+		Service:       service,
+		Auth:          auth,
+		Notifications: notifications,
+		URL:           link,
+		Template:      "magic_link",
+	}
+}
+
+// RequestHandler serves POST /auth/magic-link: it emails a login link to
+// the user with the given address. It answers 202 whether or not the
+// address belongs to a user, so that it cannot be used to find accounts.
+func (mm *MagicLinkManager) RequestHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil || addr.Address != req.Email {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	link, err := mm.Service.CreateMagicLink(r.Context(), &CreateMagicLinkRequest{Email: req.Email})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.NotFound, codes.FailedPrecondition, codes.PermissionDenied:
+		// unknown or locked users get no link, and the same answer
+		auditMagicLink(r, "", "unknown_user")
+		mm.accepted(w, r)
+		return
+	default:
+		logger.Logger().Error("Failed to create magic link", zap.Error(err))
+		http.Error(w, "Failed to send login link", http.StatusInternalServerError)
+		return
+	}
+
+	u := *mm.URL
+	q := u.Query()
+	q.Set("token", link.Token)
+	u.RawQuery = q.Encode()
+	msg := notification.Message{
+		ID:       requestid.New(),
+		Channel:  "email",
+		To:       req.Email,
+		Subject:  mm.Subject,
+		Template: mm.Template,
+		Data: map[string]any{
+			"link":               u.String(),
+			"expires_in_seconds": link.ExpiresInSeconds,
+		},
+	}
+	if err := mm.Notifications.Send(r.Context(), msg); err != nil {
+		if errors.Is(err, notification.ErrQueueFull) {
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, "Failed to send login link", http.StatusServiceUnavailable)
+		return
+	}
+	auditMagicLink(r, link.UserID, "sent")
+	mm.accepted(w, r)
+}
+
+func (mm *MagicLinkManager) accepted(w http.ResponseWriter, r *http.Request) {
+	out := map[string]string{"status": "sent"}
+	if err := render.Write(w, r, http.StatusAccepted, out); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// VerifyHandler serves POST /auth/magic-link/verify: it redeems the token
+// of a link and answers as LoginHandler does, MFA challenge included.
+func (mm *MagicLinkManager) VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token      string `json:"token"`
+		RememberMe *bool  `json:"remember_me"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	am := mm.Auth
+	mode, ok := am.responseMode(r)
+	if !ok {
+		http.Error(w, "Unknown X-Auth-Response mode", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := mm.Service.RedeemMagicLink(withRemember(r.Context(), req.RememberMe), &RedeemMagicLinkRequest{Token: req.Token})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.NotFound, codes.Unauthenticated, codes.PermissionDenied, codes.FailedPrecondition:
+			auditMagicLink(r, "", "rejected")
+			http.Error(w, "Invalid or expired login link", http.StatusUnauthorized)
+		default:
+			logger.Logger().Error("Failed to redeem magic link", zap.Error(err))
+			http.Error(w, "Failed to verify login link", http.StatusInternalServerError)
+		}
+		return
+	}
+	if am.Challenges != nil && am.challenge(w, r, resp, req.RememberMe) {
+		return
+	}
+	am.loggedIn(w, r, mode, resp, req.RememberMe)
+}
+
+// auditMagicLink logs the magic link requests and rejected links.
+func auditMagicLink(r *http.Request, userID, outcome string) {
+	fields := []zap.Field{zap.String("outcome", outcome)}
+	if userID != "" {
+		fields = append(fields, zap.String("user_id", userID))
+	}
+	if rid, ok := requestid.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("request_id", rid))
+	}
+	if ip, ok := realip.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("client_ip", ip))
+	}
+	logger.Logger().Info("Magic link", fields...)
+}
@@ -0,0 +1,123 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/mfa"
+	"github.com/andro-kes/gateway/internal/notification"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mockMagicLinkService is a mock implementation of handlers.MagicLinkService with single use tokens
+type mockMagicLinkService struct {
+	users  map[string]string
+	tokens map[string]string
+}
+
+func (m *mockMagicLinkService) CreateMagicLink(ctx context.Context, in *handlers.CreateMagicLinkRequest) (*handlers.CreateMagicLinkResponse, error) {
+	userID, ok := m.users[in.Email]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no such user")
+	}
+	tok := "tok-" + userID
+	m.tokens[tok] = userID
+	return &handlers.CreateMagicLinkResponse{UserID: userID, Token: tok, ExpiresInSeconds: 900}, nil
+}
+
+func (m *mockMagicLinkService) RedeemMagicLink(ctx context.Context, in *handlers.RedeemMagicLinkRequest) (*pb.TokenResponse, error) {
+	userID, ok := m.tokens[in.Token]
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	delete(m.tokens, in.Token)
+	return loginAs(userID)(ctx, nil)
+}
+
+// setupMagicLinkRouter creates a router with the magic link routes sending through conn
+func setupMagicLinkRouter(t *testing.T, service handlers.MagicLinkService, conn *recordingConn) (*chi.Mux, *notification.Dispatcher) {
+	d, err := notification.New(notification.Config{}, conn)
+	require.NoError(t, err)
+	link, err := url.Parse("https://shop.example.com/login/magic?lang=en")
+	require.NoError(t, err)
+	mm := handlers.NewMagicLinkManager(service, handlers.NewAuthManager(&mockAuthService{}), d, link)
+
+	r := chi.NewRouter()
+	r.Post("/auth/magic-link", mm.RequestHandler)
+	r.Post("/auth/magic-link/verify", mm.VerifyHandler)
+	return r, d
+}
+
+// TestMagicLink tests that a requested link is emailed and its token logs in once
+func TestMagicLink(t *testing.T) {
+	service := &mockMagicLinkService{users: map[string]string{"ann@example.com": "user-1"}, tokens: map[string]string{}}
+	conn := &recordingConn{}
+	r, d := setupMagicLinkRouter(t, service, conn)
+
+	w, out := postJSON(r, "/auth/magic-link", `{"email": "ann@example.com"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "sent", out["status"])
+	w, _ = postJSON(r, "/auth/magic-link", `{"email": "nobody@example.com"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code, "unknown addresses get the same answer")
+	w, _ = postJSON(r, "/auth/magic-link", `{"email": "not an address"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	require.NoError(t, d.Close(context.Background()))
+	require.Len(t, conn.sent, 1)
+	msg := conn.sent[0]
+	assert.Equal(t, "email", msg["channel"])
+	assert.Equal(t, "ann@example.com", msg["to"])
+	assert.Equal(t, "magic_link", msg["template"])
+	data := msg["data"].(map[string]any)
+	link, err := url.Parse(data["link"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "shop.example.com", link.Host)
+	assert.Equal(t, "en", link.Query().Get("lang"))
+	assert.EqualValues(t, 900, data["expires_in_seconds"])
+
+	body := `{"token": "` + link.Query().Get("token") + `"}`
+	w, out = postJSON(r, "/auth/magic-link/verify", body)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user-1", out["user_id"])
+	assert.NotEmpty(t, out["access_token"])
+	var refresh *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "refresh_token" {
+			refresh = c
+		}
+	}
+	require.NotNil(t, refresh, "the same cookies as a password login are set")
+	assert.True(t, refresh.HttpOnly)
+
+	w, _ = postJSON(r, "/auth/magic-link/verify", body)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "links are single use")
+}
+
+// TestMagicLink_MFA tests that magic link logins of users with MFA are challenged
+func TestMagicLink_MFA(t *testing.T) {
+	service := &mockMagicLinkService{tokens: map[string]string{"tok-user-1": "user-1"}}
+	challenges, err := mfa.New(mfa.Config{Enabled: true})
+	require.NoError(t, err)
+	am := handlers.NewAuthManager(&mockAuthService{
+		revokeFunc: func(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error) {
+			return &pb.RevokeResponse{}, nil
+		},
+	})
+	am.MFA = &mockMFAService{enabled: map[string]bool{"user-1": true}}
+	am.Challenges = challenges
+	mm := handlers.NewMagicLinkManager(service, am, nil, &url.URL{})
+
+	w, out := postJSON(http.HandlerFunc(mm.VerifyHandler), "/auth/magic-link/verify", `{"token": "tok-user-1"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, out["mfa_required"])
+	assert.Nil(t, out["access_token"])
+	assert.Empty(t, w.Result().Cookies())
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/mfa"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/token"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// challenge withholds the tokens of a login whose user has MFA set up and
+// answers with a challenge token instead, to be exchanged with a code on
+// /auth/mfa/verify. It reports whether it answered. Logins are refused
+// while the MFA status cannot be checked.
+func (am *AuthManager) challenge(w http.ResponseWriter, r *http.Request, resp *pb.TokenResponse, remember *bool) bool {
+	st, err := am.MFA.MFAStatus(r.Context(), &UserRequest{UserID: resp.UserId})
+	if err != nil {
+		logger.Logger().Error("Failed to check MFA status", zap.String("user_id", resp.UserId), zap.Error(err))
+		am.revokeWithheld(r, resp)
+		http.Error(w, "Failed to check MFA status", http.StatusInternalServerError)
+		return true
+	}
+	if !st.Enabled {
+		return false
+	}
+
+	am.revokeWithheld(r, resp)
+	raw, ch, err := am.Challenges.Issue(resp.UserId, remember)
+	if err != nil {
+		http.Error(w, "Failed to issue MFA challenge", http.StatusInternalServerError)
+		return true
+	}
+	auditMFA(r, resp.UserId, "challenged")
+	out := map[string]any{
+		"mfa_required":                 true,
+		"challenge_token":              raw,
+		"challenge_expires_in_seconds": int64(am.Challenges.TTL().Seconds()),
+		"user_id":                      ch.UserID,
+	}
+	if err := render.Write(w, r, http.StatusOK, out); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+	return true
+}
+
+// revokeWithheld revokes the refresh token of a login whose tokens were not
+// handed out, so that its session does not linger.
+func (am *AuthManager) revokeWithheld(r *http.Request, resp *pb.TokenResponse) {
+	if resp.RefreshToken == "" {
+		return
+	}
+	rev, err := am.Service.Revoke(r.Context(), &pb.RevokeRequest{RefreshToken: resp.RefreshToken, UserId: resp.UserId})
+	if err == nil && rev != nil && rev.Error != "" {
+		err = errors.New(rev.Error)
+	}
+	if err != nil {
+		logger.Logger().Warn("Failed to revoke the session of an MFA challenge", zap.String("user_id", resp.UserId), zap.Error(err))
+	}
+}
+
+// challengeErrors are the client messages of refused challenge tokens.
+var challengeErrors = map[error]string{
+	mfa.ErrInvalid:         "Invalid MFA challenge",
+	mfa.ErrExpired:         "MFA challenge expired, log in again",
+	mfa.ErrUsed:            "MFA challenge already used, log in again",
+	mfa.ErrTooManyAttempts: "Too many MFA codes, log in again",
+}
+
+// MFAVerifyHandler serves POST /auth/mfa/verify: it exchanges the challenge
+// token of a login and a code for the tokens of the login.
+func (am *AuthManager) MFAVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" || req.Code == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	mode, ok := am.responseMode(r)
+	if !ok {
+		http.Error(w, "Unknown X-Auth-Response mode", http.StatusBadRequest)
+		return
+	}
+
+	ch, err := am.Challenges.Attempt(req.ChallengeToken)
+	if err != nil {
+		http.Error(w, challengeErrors[err], http.StatusUnauthorized)
+		return
+	}
+	resp, err := am.MFA.VerifyMFA(withRemember(r.Context(), ch.RememberMe), &MFACodeRequest{UserID: ch.UserID, Code: req.Code})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied:
+			auditMFA(r, ch.UserID, "code_rejected")
+			http.Error(w, "Invalid MFA code", http.StatusUnauthorized)
+		default:
+			logger.Logger().Error("Failed to verify MFA code", zap.String("user_id", ch.UserID), zap.Error(err))
+			http.Error(w, "Failed to verify MFA code", http.StatusInternalServerError)
+		}
+		return
+	}
+	am.Challenges.Done(ch)
+	am.loggedIn(w, r, mode, resp, ch.RememberMe)
+}
+
+// MFASetupHandler serves POST /auth/mfa/setup: it starts the enrollment of
+// the caller and returns the TOTP secret to add to their authenticator app.
+// MFA is only enabled once a code is confirmed.
+func (am *AuthManager) MFASetupHandler(w http.ResponseWriter, r *http.Request) {
+	claims, _ := token.FromContext(r.Context())
+	resp, err := am.MFA.SetupMFA(r.Context(), &UserRequest{UserID: claims.UserID})
+	if err != nil {
+		failRPC(w, err, "Failed to set up MFA")
+		return
+	}
+	auditMFA(r, claims.UserID, "setup_started")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := render.Write(w, r, http.StatusOK, resp); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// MFAConfirmHandler serves POST /auth/mfa/setup/confirm: a first code from
+// the authenticator app enables MFA for the caller.
+func (am *AuthManager) MFAConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	claims, _ := token.FromContext(r.Context())
+	if err := am.MFA.ConfirmMFA(r.Context(), &MFACodeRequest{UserID: claims.UserID, Code: req.Code}); err != nil {
+		failRPC(w, err, "Failed to confirm MFA")
+		return
+	}
+	auditMFA(r, claims.UserID, "enabled")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auditMFA logs the MFA steps of a user.
+func auditMFA(r *http.Request, userID, step string) {
+	fields := []zap.Field{zap.String("user_id", userID), zap.String("step", step)}
+	if rid, ok := requestid.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("request_id", rid))
+	}
+	if ip, ok := realip.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("client_ip", ip))
+	}
+	logger.Logger().Info("MFA", fields...)
+}
@@ -0,0 +1,185 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/mfa"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// mockMFAService is a mock implementation of handlers.MFAService accepting the code 123456
+type mockMFAService struct {
+	enabled   map[string]bool
+	statusErr error
+	confirmed []*handlers.MFACodeRequest
+}
+
+func (m *mockMFAService) MFAStatus(ctx context.Context, in *handlers.UserRequest) (*handlers.MFAStatusResponse, error) {
+	if m.statusErr != nil {
+		return nil, m.statusErr
+	}
+	return &handlers.MFAStatusResponse{Enabled: m.enabled[in.UserID]}, nil
+}
+
+func (m *mockMFAService) SetupMFA(ctx context.Context, in *handlers.UserRequest) (*handlers.SetupMFAResponse, error) {
+	return &handlers.SetupMFAResponse{Secret: "JBSWY3DPEHPK3PXP", OTPAuthURL: "otpauth://totp/gateway:" + in.UserID + "?secret=JBSWY3DPEHPK3PXP"}, nil
+}
+
+func (m *mockMFAService) ConfirmMFA(ctx context.Context, in *handlers.MFACodeRequest) error {
+	if in.Code != "123456" {
+		return status.Error(codes.InvalidArgument, "invalid code")
+	}
+	m.confirmed = append(m.confirmed, in)
+	return nil
+}
+
+func (m *mockMFAService) VerifyMFA(ctx context.Context, in *handlers.MFACodeRequest) (*pb.TokenResponse, error) {
+	if in.Code != "123456" {
+		return nil, status.Error(codes.Unauthenticated, "invalid code")
+	}
+	return &pb.TokenResponse{
+		UserId:           in.UserID,
+		AccessToken:      generateMockJWT(time.Now().Add(5 * time.Minute)),
+		RefreshToken:     "refresh-after-mfa",
+		AccessExpiresIn:  durationpb.New(5 * time.Minute),
+		RefreshExpiresIn: durationpb.New(24 * time.Hour),
+	}, nil
+}
+
+// setupMFARouter creates a router with the login and MFA routes
+func setupMFARouter(t *testing.T, auth handlers.AuthService, service handlers.MFAService) *chi.Mux {
+	challenges, err := mfa.New(mfa.Config{Enabled: true, MaxAttempts: 2})
+	require.NoError(t, err)
+	am := handlers.NewAuthManager(auth)
+	am.MFA = service
+	am.Challenges = challenges
+
+	verifier, err := token.NewVerifier(token.Config{HMACSecret: "mfa-secret"})
+	require.NoError(t, err)
+	authenticator := handlers.NewAuthenticator(verifier, false)
+
+	r := chi.NewRouter()
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/login", am.LoginHandler)
+		r.Post("/mfa/verify", am.MFAVerifyHandler)
+		r.Group(func(r chi.Router) {
+			r.Use(authenticator.Middleware)
+			r.Use(handlers.RequireRecentLogin(0))
+			r.Post("/mfa/setup", am.MFASetupHandler)
+			r.Post("/mfa/setup/confirm", am.MFAConfirmHandler)
+		})
+	})
+	return r
+}
+
+func loginAs(userID string) func(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error) {
+	return func(ctx context.Context, in *pb.LoginRequest) (*pb.TokenResponse, error) {
+		return &pb.TokenResponse{
+			UserId:       userID,
+			AccessToken:  generateMockJWT(time.Now().Add(5 * time.Minute)),
+			RefreshToken: "refresh-" + userID,
+		}, nil
+	}
+}
+
+func postJSON(r http.Handler, path, body string) (*httptest.ResponseRecorder, map[string]any) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body)))
+	var out map[string]any
+	json.Unmarshal(w.Body.Bytes(), &out)
+	return w, out
+}
+
+// TestMFA_Login tests that logins of users with MFA return a challenge to exchange with a code for the tokens
+func TestMFA_Login(t *testing.T) {
+	var revoked []*pb.RevokeRequest
+	auth := &mockAuthService{
+		loginFunc: loginAs("user-1"),
+		revokeFunc: func(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error) {
+			revoked = append(revoked, in)
+			return &pb.RevokeResponse{}, nil
+		},
+	}
+	r := setupMFARouter(t, auth, &mockMFAService{enabled: map[string]bool{"user-1": true}})
+
+	w, out := postJSON(r, "/auth/login", `{"username": "alice", "password": "secret"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, out["mfa_required"])
+	assert.Nil(t, out["access_token"])
+	assert.Empty(t, w.Result().Cookies())
+	require.Len(t, revoked, 1, "the session of the withheld tokens is revoked")
+	assert.Equal(t, "refresh-user-1", revoked[0].RefreshToken)
+	challenge, _ := out["challenge_token"].(string)
+	require.NotEmpty(t, challenge)
+
+	w, _ = postJSON(r, "/auth/mfa/verify", `{"challenge_token": "`+challenge+`", "code": "000000"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, out = postJSON(r, "/auth/mfa/verify", `{"challenge_token": "`+challenge+`", "code": "123456"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user-1", out["user_id"])
+	assert.NotEmpty(t, out["access_token"])
+
+	w, _ = postJSON(r, "/auth/mfa/verify", `{"challenge_token": "`+challenge+`", "code": "123456"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "challenges are single use")
+}
+
+// TestMFA_LoginWithoutMFA tests that users without MFA and failed MFA checks are handled
+func TestMFA_LoginWithoutMFA(t *testing.T) {
+	service := &mockMFAService{}
+	r := setupMFARouter(t, &mockAuthService{loginFunc: loginAs("user-2")}, service)
+
+	w, out := postJSON(r, "/auth/login", `{"username": "bob", "password": "secret"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, out["access_token"])
+	assert.Nil(t, out["mfa_required"])
+
+	service.statusErr = status.Error(codes.Unavailable, "auth_service down")
+	w, out = postJSON(r, "/auth/login", `{"username": "bob", "password": "secret"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "logins fail closed")
+	assert.Nil(t, out)
+}
+
+// TestMFA_Setup tests enrolling in MFA with a fresh login
+func TestMFA_Setup(t *testing.T) {
+	service := &mockMFAService{}
+	r := setupMFARouter(t, &mockAuthService{}, service)
+	now := time.Now()
+	bearer := "Bearer " + generateSignedJWT("mfa-secret", map[string]any{"uid": "user-3", "exp": now.Add(time.Hour).Unix(), "iat": now.Unix()})
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/mfa/setup", nil)
+	req.Header.Set("Authorization", bearer)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Body.String(), "JBSWY3DPEHPK3PXP")
+
+	req = httptest.NewRequest(http.MethodPost, "/auth/mfa/setup/confirm", bytes.NewBufferString(`{"code": "111111"}`))
+	req.Header.Set("Authorization", bearer)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/auth/mfa/setup/confirm", bytes.NewBufferString(`{"code": "123456"}`))
+	req.Header.Set("Authorization", bearer)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	require.Len(t, service.confirmed, 1)
+	assert.Equal(t, "user-3", service.confirmed[0].UserID)
+}
@@ -1,104 +1,256 @@
 package handlers
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"crypto/subtle"
 	"errors"
 	"net/http"
 	"strings"
 	"time"
 
-	"google.golang.org/grpc/metadata"
+	"github.com/andro-kes/gateway/internal/clientcert"
+	"github.com/andro-kes/gateway/internal/cookie"
+	"github.com/andro-kes/gateway/internal/interceptor"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/signature"
+	"github.com/andro-kes/gateway/internal/timing"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-// PropagateAuthToGRPC extracts the access token from Authorization header or
-// access_token cookie, checks expiry (quick decode of JWT payload only),
-// returns 401 if missing/expired (so frontend can call /auth/refresh), and
-// otherwise injects the Authorization value into outgoing gRPC metadata.
+var (
+	tokensTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "auth",
+		Name:      "tokens_total",
+		Help:      "Access tokens checked on protected routes, by route and result (missing, invalid, expired, not_yet_valid, issued_in_future, wrong_issuer, wrong_audience, accepted, anonymous).",
+	}, []string{"route", "result"})
+
+	verifyDuration = promauto.With(metrics.Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "auth",
+		Name:      "verification_seconds",
+		Help:      "Time spent parsing and verifying access tokens, by route.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 8),
+	}, []string{"route"})
+)
+
+// routePattern returns the pattern of the route r will be served by, e.g.
+// "/inventory/products/{id}", or "unmatched", so that metric labels stay
+// bounded whatever paths clients send.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return "unmatched"
+	}
+	if pattern := rctx.Routes.Find(chi.NewRouteContext(), r.Method, r.URL.Path); pattern != "" {
+		return pattern
+	}
+	return "unmatched"
+}
+
+// Authenticator guards protected routes. It extracts the access token from
+// the Authorization header or access token cookie, verifies its signature when
+// a Verifier is configured, and rejects missing/invalid/expired tokens with
+// 401 (so the frontend can call /auth/refresh).
+type Authenticator struct {
+	// Verifier checks token signatures. When nil only the expiry is checked.
+	Verifier *token.Verifier
+
+	// PropagateIdentity attaches x-user-id, x-user-roles and x-token-exp
+	// metadata to backend calls. Only verified tokens are propagated.
+	PropagateIdentity bool
+
+	// Cookies names the access token cookie. Nil uses the default name.
+	Cookies *cookie.Jar
+
+	// Validator checks the time, issuer and audience claims. When nil only
+	// the expiry is checked, without clock skew tolerance.
+	Validator *token.Validator
+
+	// Signatures authenticates machine clients that sign their requests
+	// instead of sending an access token. May be nil.
+	Signatures *signature.Verifier
+
+	// ClientCerts authenticates internal callers by the verified TLS client
+	// certificate of their connection. Certificates without an identity
+	// fall back to the other methods. May be nil.
+	ClientCerts *clientcert.Mapper
+}
+
+// rejections are the client message and metric result of each reason a
+// token is refused by the Validator.
+var rejections = map[error]struct{ message, result string }{
+	token.ErrExpired:        {"access token expired", "expired"},
+	token.ErrNotYetValid:    {"access token not valid yet", "not_yet_valid"},
+	token.ErrIssuedInFuture: {"access token issued in the future", "issued_in_future"},
+	token.ErrIssuer:         {"access token issuer not accepted", "wrong_issuer"},
+	token.ErrAudience:       {"access token audience not accepted", "wrong_audience"},
+}
+
+func NewAuthenticator(verifier *token.Verifier, propagateIdentity bool) *Authenticator {
+	return &Authenticator{
+		Verifier:          verifier,
+		PropagateIdentity: propagateIdentity,
+	}
+}
+
+// PropagateAuthToGRPC checks expiry (quick decode of JWT payload only) and
+// attaches the Authorization value to the context for outgoing gRPC calls
+// (see interceptor.AuthMetadata).
 func PropagateAuthToGRPC(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
+	return (&Authenticator{}).Middleware(next)
+}
 
-		if auth == "" {
-			c, err := r.Cookie("access_token")
-			if err == nil && c.Value != "" {
-				auth = "Bearer " + c.Value
-			}
-		}
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.serve(next, w, r, false)
+	})
+}
 
-		if auth == "" {
-			http.Error(w, "missing access token", http.StatusUnauthorized)
-			return
-		}
+// OptionalMiddleware authenticates like Middleware but serves requests
+// without a valid access token anonymously instead of rejecting them with
+// 401, for public routes that personalize their answers when they know the
+// caller. Requests with a bad signature are still rejected.
+func (a *Authenticator) OptionalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.serve(next, w, r, true)
+	})
+}
 
-		const prefix = "Bearer "
-		if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
-			http.Error(w, "invalid access token", http.StatusUnauthorized)
-			return
-		}
+// serve authenticates r and serves it with next. Token problems reject r,
+// unless optional, in which case r is served without identity.
+func (a *Authenticator) serve(next http.Handler, w http.ResponseWriter, r *http.Request, optional bool) {
+	if id, err := a.ClientCerts.Identify(r.TLS); err == nil && id != nil {
+		a.serveAs(next, w, r, &token.Claims{UserID: id.ID, Type: "client_certificate", Roles: id.Roles, Verified: true})
+		return
+	}
+	if a.Signatures != nil && signature.Signed(r) {
+		a.signed(next, w, r)
+		return
+	}
+	auth := r.Header.Get("Authorization")
 
-		raw := strings.TrimSpace(auth[len(prefix):])
-		if raw == "" {
-			http.Error(w, "empty access token", http.StatusUnauthorized)
-			return
+	if auth == "" {
+		if v := a.Cookies.Access(r); v != "" {
+			auth = "Bearer " + v
 		}
+	}
 
-		// Quick expiry check without signature verification:
-		expired, err := tokenExpired(raw)
-		if err != nil {
-			// malformed token: force refresh / re-login
-			http.Error(w, "invalid access token", http.StatusUnauthorized)
+	route := routePattern(r)
+	reject := func(result, message string) {
+		tokensTotal.WithLabelValues(route, result).Inc()
+		if optional {
+			next.ServeHTTP(w, r)
 			return
 		}
-		if expired {
-			http.Error(w, "access token expired", http.StatusUnauthorized)
-			return
+		http.Error(w, message, http.StatusUnauthorized)
+	}
+	if auth == "" {
+		result := "missing"
+		if optional {
+			result = "anonymous"
 		}
+		reject(result, "missing access token")
+		return
+	}
 
-		// token not expired — inject into outgoing gRPC metadata
-		ctx := metadata.NewOutgoingContext(r.Context(), metadata.Pairs("authorization", auth))
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		reject("invalid", "invalid access token")
+		return
+	}
 
-// tokenExpired decodes JWT payload and returns true if exp <= now.
-func tokenExpired(token string) (bool, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) < 2 {
-		return false, errors.New("malformed token")
+	raw := strings.TrimSpace(auth[len(prefix):])
+	if raw == "" {
+		reject("missing", "empty access token")
+		return
 	}
-	payload := parts[1]
-	raw, err := base64.RawURLEncoding.DecodeString(payload)
+
+	start := time.Now()
+	claims, err := a.claims(raw)
+	verified := time.Since(start)
+	metrics.ObserveDuration(r.Context(), verifyDuration.WithLabelValues(route), verified, false)
+	timing.FromContext(r.Context()).Add(timing.Auth, verified)
 	if err != nil {
-		// try standard base64 if padding present
-		raw, err = base64.StdEncoding.DecodeString(payload)
-		if err != nil {
-			return false, err
-		}
+		// malformed or forged token: force refresh / re-login
+		reject("invalid", "invalid access token")
+		return
 	}
+	if err := a.Validator.Validate(claims, time.Now()); err != nil {
+		rejection := rejections[err]
+		reject(rejection.result, rejection.message)
+		return
+	}
+	tokensTotal.WithLabelValues(route, "accepted").Inc()
 
-	var claims map[string]interface{}
-	if err := json.Unmarshal(raw, &claims); err != nil {
-		return false, err
+	// token valid — the auth interceptor attaches it to outgoing gRPC metadata
+	ctx := interceptor.WithAuthorization(r.Context(), auth)
+	ctx = token.WithClaims(ctx, claims)
+	if a.PropagateIdentity && claims.Verified {
+		ctx = interceptor.WithIdentity(ctx, interceptor.Identity{
+			UserID:    claims.UserID,
+			Roles:     claims.Roles,
+			ExpiresAt: claims.ExpiresAt,
+		})
 	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
 
-	v, ok := claims["exp"]
-	if !ok {
-		return false, errors.New("exp not present")
+// signed serves a signed request as its key, which stands for the user.
+func (a *Authenticator) signed(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	key, err := a.Signatures.Verify(r)
+	timing.FromContext(r.Context()).Add(timing.Auth, time.Since(start))
+	switch {
+	case errors.Is(err, signature.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, signature.ErrMalformed), errors.Is(err, signature.ErrUnknownKey),
+		errors.Is(err, signature.ErrStale), errors.Is(err, signature.ErrMismatch), errors.Is(err, signature.ErrReplayed):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "failed to verify request signature", http.StatusInternalServerError)
+		return
 	}
-	var expInt int64
-	switch t := v.(type) {
-	case float64:
-		expInt = int64(t)
-	case json.Number:
-		i, err := t.Int64()
-		if err != nil {
-			return false, err
-		}
-		expInt = i
-	default:
-		return false, errors.New("invalid exp type")
+
+	a.serveAs(next, w, r, &token.Claims{UserID: key.ID, Type: "signature", Roles: key.Roles, Verified: true})
+}
+
+// serveAs serves r as the caller of claims, authenticated without an access
+// token.
+func (a *Authenticator) serveAs(next http.Handler, w http.ResponseWriter, r *http.Request, claims *token.Claims) {
+	ctx := token.WithClaims(r.Context(), claims)
+	if a.PropagateIdentity {
+		ctx = interceptor.WithIdentity(ctx, interceptor.Identity{UserID: claims.UserID, Roles: claims.Roles})
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (a *Authenticator) claims(raw string) (*token.Claims, error) {
+	if a.Verifier != nil {
+		return a.Verifier.Verify(raw)
+	}
+	return token.Parse(raw)
+}
+
+// RequireAdminToken protects admin routes with a static bearer token.
+func RequireAdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const prefix = "Bearer "
+			auth := r.Header.Get("Authorization")
+			if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+				http.Error(w, "missing admin token", http.StatusUnauthorized)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(token)) != 1 {
+				http.Error(w, "invalid admin token", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
-	now := time.Now().Unix()
-	return now >= expInt, nil
 }
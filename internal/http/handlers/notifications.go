@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/notification"
+	"github.com/andro-kes/gateway/internal/requestid"
+)
+
+type NotifyManager struct {
+	Dispatcher *notification.Dispatcher
+}
+
+func NewNotifyManager(dispatcher *notification.Dispatcher) *NotifyManager {
+	return &NotifyManager{
+		Dispatcher: dispatcher,
+	}
+}
+
+// SendHandler queues a notification and answers 202 with its ID without
+// waiting for delivery. A full queue is answered with 503.
+func (nm *NotifyManager) SendHandler(w http.ResponseWriter, r *http.Request) {
+	var msg notification.Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if msg.Channel == "" || msg.To == "" {
+		http.Error(w, "channel and to are required", http.StatusBadRequest)
+		return
+	}
+	if msg.Body == "" && msg.Template == "" {
+		http.Error(w, "body or template is required", http.StatusBadRequest)
+		return
+	}
+	msg.ID = requestid.New()
+
+	if err := nm.Dispatcher.Send(r.Context(), msg); err != nil {
+		if errors.Is(err, notification.ErrQueueFull) {
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, "notification queue unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	out := map[string]string{"id": msg.ID, "status": "queued"}
+	if err := render.Write(w, r, http.StatusAccepted, out); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+	}
+}
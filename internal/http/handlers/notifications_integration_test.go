@@ -0,0 +1,65 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/notification"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// recordingConn stands in for the notifications backend
+type recordingConn struct {
+	grpc.ClientConnInterface
+	mu   sync.Mutex
+	sent []map[string]any
+}
+
+func (c *recordingConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, args.(*structpb.Struct).AsMap())
+	return nil
+}
+
+// TestSendHandler tests that notifications are accepted and delivered asynchronously
+func TestSendHandler(t *testing.T) {
+	conn := &recordingConn{}
+	d, err := notification.New(notification.Config{}, conn)
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(handlers.NewNotifyManager(d).SendHandler))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"channel":"email","to":"a@example.com","template":"welcome","data":{"name":"Ann"}}`))
+	require.NoError(t, err)
+	var result map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "queued", result["status"])
+	assert.Len(t, result["id"], 32)
+
+	resp, err = http.Post(ts.URL, "application/json", strings.NewReader(`{"channel":"email","body":"hi"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	require.NoError(t, d.Close(context.Background()))
+	require.Len(t, conn.sent, 1)
+	assert.Equal(t, result["id"], conn.sent[0]["id"])
+	assert.Equal(t, "welcome", conn.sent[0]["template"])
+
+	resp, err = http.Post(ts.URL, "application/json", strings.NewReader(`{"channel":"email","to":"a@example.com","body":"hi"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+// rememberMetadata tells auth_service whether the login asked to be
+// remembered, so that it can pick the refresh token lifetime.
+const rememberMetadata = "x-remember-me"
+
+// loginOptions are the fields of a login request that the gateway handles
+// itself. auth_service's LoginRequest has no room for them, so only JSON
+// bodies carry them.
+type loginOptions struct {
+	// RememberMe asks for long-lived cookies when true and for session
+	// cookies when false. When absent the cookies follow the token expiry.
+	RememberMe *bool `json:"remember_me"`
+}
+
+// decodeLogin decodes the login request and its options.
+func decodeLogin(w http.ResponseWriter, r *http.Request, req *pb.LoginRequest) (loginOptions, error) {
+	var opts loginOptions
+	buf := render.GetBuffer()
+	defer render.PutBuffer(buf)
+	if err := readBody(w, r, buf); err != nil {
+		return opts, err
+	}
+	if err := unmarshalRequest(r, buf.Bytes(), req); err != nil {
+		return opts, err
+	}
+	if isProtobuf(r) {
+		return opts, nil
+	}
+	return opts, json.Unmarshal(buf.Bytes(), &opts)
+}
+
+// withRemember forwards the remember-me choice to auth_service, if made.
+func withRemember(ctx context.Context, remember *bool) context.Context {
+	if remember == nil {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, rememberMetadata, strconv.FormatBool(*remember))
+}
+
+// auditLogin logs who logged in, from where and whether they asked to be
+// remembered.
+func auditLogin(r *http.Request, userID string, remember *bool) {
+	fields := []zap.Field{zap.String("user_id", userID)}
+	if remember != nil {
+		fields = append(fields, zap.Bool("remember_me", *remember))
+	}
+	if rid, ok := requestid.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("request_id", rid))
+	}
+	if ip, ok := realip.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("client_ip", ip))
+	}
+	logger.Logger().Info("User logged in", fields...)
+}
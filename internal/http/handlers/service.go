@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	pbAuth "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/backend"
+	"github.com/andro-kes/gateway/internal/passthrough"
+	"github.com/andro-kes/gateway/internal/schema"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// AuthService is the auth backend as seen by the handlers. The gRPC client
+// is adapted with NewGRPCAuthService; other transports and in-memory fakes
+// implement it directly.
+type AuthService interface {
+	Login(ctx context.Context, in *pbAuth.LoginRequest) (*pbAuth.TokenResponse, error)
+	Register(ctx context.Context, in *pbAuth.RegisterRequest) (*pbAuth.RegisterResponse, error)
+	Refresh(ctx context.Context, in *pbAuth.RefreshRequest) (*pbAuth.TokenResponse, error)
+	Revoke(ctx context.Context, in *pbAuth.RevokeRequest) (*pbAuth.RevokeResponse, error)
+}
+
+// AccountService changes and deletes user accounts, as seen by the account
+// handlers. An AuthService can implement it too.
+type AccountService interface {
+	ChangePassword(ctx context.Context, in *ChangePasswordRequest) error
+	ChangeEmail(ctx context.Context, in *ChangeEmailRequest) error
+	DeleteAccount(ctx context.Context, in *DeleteAccountRequest) error
+}
+
+// ChangePasswordRequest is the input of the ChangePassword RPC.
+type ChangePasswordRequest struct {
+	UserID          string `json:"user_id"`
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangeEmailRequest is the input of the ChangeEmail RPC.
+type ChangeEmailRequest struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+// DeleteAccountRequest is the input of the DeleteAccount RPC.
+type DeleteAccountRequest struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password"`
+}
+
+// UserAdminService administers the users of auth_service, as seen by the
+// /admin/users handlers. An AuthService can implement it too.
+type UserAdminService interface {
+	ListUsers(ctx context.Context, in *ListUsersRequest) (*ListUsersResponse, error)
+	LockUser(ctx context.Context, in *LockUserRequest) error
+	UnlockUser(ctx context.Context, in *UserRequest) error
+	ForcePasswordReset(ctx context.Context, in *UserRequest) error
+	RevokeSessions(ctx context.Context, in *UserRequest) error
+}
+
+// ListUsersRequest is the input of the ListUsers RPC.
+type ListUsersRequest struct {
+	// Query filters users by username or email.
+	Query     string `json:"query,omitempty"`
+	PageSize  int32  `json:"page_size,omitempty"`
+	PageToken string `json:"page_token,omitempty"`
+}
+
+// ListUsersResponse is the output of the ListUsers RPC.
+type ListUsersResponse struct {
+	Users         []User `json:"users"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// User is a user as listed by auth_service.
+type User struct {
+	ID       string `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Locked   bool   `json:"locked"`
+}
+
+// LockUserRequest is the input of the LockUser RPC.
+type LockUserRequest struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// UserRequest is the input of the RPCs that only name a user.
+type UserRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// MFAService enrolls users in multi-factor authentication and checks their
+// codes, as seen by the login and /auth/mfa handlers. An AuthService can
+// implement it too.
+type MFAService interface {
+	MFAStatus(ctx context.Context, in *UserRequest) (*MFAStatusResponse, error)
+	SetupMFA(ctx context.Context, in *UserRequest) (*SetupMFAResponse, error)
+	ConfirmMFA(ctx context.Context, in *MFACodeRequest) error
+	VerifyMFA(ctx context.Context, in *MFACodeRequest) (*pbAuth.TokenResponse, error)
+}
+
+// MFAStatusResponse is the output of the MFAStatus RPC.
+type MFAStatusResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SetupMFAResponse is the output of the SetupMFA RPC: the TOTP secret to
+// show to the user, also as an otpauth:// URI for QR codes.
+type SetupMFAResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+// MFACodeRequest is the input of the ConfirmMFA and VerifyMFA RPCs.
+type MFACodeRequest struct {
+	UserID string `json:"user_id"`
+	Code   string `json:"code"`
+}
+
+// MagicLinkService issues and redeems the one-time tokens of magic link
+// logins, as seen by the /auth/magic-link handlers. An AuthService can
+// implement it too.
+type MagicLinkService interface {
+	CreateMagicLink(ctx context.Context, in *CreateMagicLinkRequest) (*CreateMagicLinkResponse, error)
+	RedeemMagicLink(ctx context.Context, in *RedeemMagicLinkRequest) (*pbAuth.TokenResponse, error)
+}
+
+// CreateMagicLinkRequest is the input of the CreateMagicLink RPC.
+type CreateMagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// CreateMagicLinkResponse is the output of the CreateMagicLink RPC: the
+// one-time token to send to the user and how long it is valid.
+type CreateMagicLinkResponse struct {
+	UserID           string `json:"user_id"`
+	Token            string `json:"token"`
+	ExpiresInSeconds int64  `json:"expires_in_seconds"`
+}
+
+// RedeemMagicLinkRequest is the input of the RedeemMagicLink RPC.
+type RedeemMagicLinkRequest struct {
+	Token string `json:"token"`
+}
+
+// ProfileService returns the attributes of users, such as their locale or
+// tier, that personalization forwards to backends when their token lacks
+// them. An AuthService can implement it too.
+type ProfileService interface {
+	GetProfile(ctx context.Context, in *UserRequest) (*ProfileResponse, error)
+}
+
+// ProfileResponse is the output of the GetProfile RPC: the attributes of
+// the user by claim name.
+type ProfileResponse struct {
+	Attributes map[string]string `json:"attributes"`
+}
+
+// InventoryService is the inventory backend as seen by the handlers. The
+// gRPC client is adapted with NewGRPCInventoryService.
+type InventoryService interface {
+	ListProducts(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error)
+	GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error)
+	CreateProduct(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error)
+	UpdateProduct(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error)
+	DeleteProduct(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error)
+}
+
+// NewGRPCAuthService returns the AuthService calling client.
+func NewGRPCAuthService(client pbAuth.AuthServiceClient) AuthService {
+	return grpcAuth{client: client}
+}
+
+type grpcAuth struct {
+	client pbAuth.AuthServiceClient
+}
+
+func (s grpcAuth) Login(ctx context.Context, in *pbAuth.LoginRequest) (*pbAuth.TokenResponse, error) {
+	return s.client.Login(ctx, in)
+}
+
+func (s grpcAuth) Register(ctx context.Context, in *pbAuth.RegisterRequest) (*pbAuth.RegisterResponse, error) {
+	return s.client.Register(ctx, in)
+}
+
+func (s grpcAuth) Refresh(ctx context.Context, in *pbAuth.RefreshRequest) (*pbAuth.TokenResponse, error) {
+	return s.client.Refresh(ctx, in)
+}
+
+func (s grpcAuth) Revoke(ctx context.Context, in *pbAuth.RevokeRequest) (*pbAuth.RevokeResponse, error) {
+	return s.client.Revoke(ctx, in)
+}
+
+// NewGRPCInventoryService returns the InventoryService calling client.
+func NewGRPCInventoryService(client pbInv.InventoryServiceClient) InventoryService {
+	return grpcInventory{client: client}
+}
+
+type grpcInventory struct {
+	client pbInv.InventoryServiceClient
+}
+
+func (s grpcInventory) ListProducts(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error) {
+	return s.client.ListProducts(ctx, in)
+}
+
+func (s grpcInventory) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	return s.client.GetProduct(ctx, in)
+}
+
+func (s grpcInventory) CreateProduct(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
+	return s.client.CreateProduct(ctx, in)
+}
+
+func (s grpcInventory) UpdateProduct(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error) {
+	return s.client.UpdateProduct(ctx, in)
+}
+
+func (s grpcInventory) DeleteProduct(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error) {
+	return s.client.DeleteProduct(ctx, in)
+}
+
+// failRPC answers with the HTTP status of the gRPC error err of an
+// auth_service call, and its message unless it is a server error.
+func failRPC(w http.ResponseWriter, err error, message string) {
+	st := status.Convert(err)
+	code := passthrough.HTTPStatus(st.Code())
+	if code < http.StatusInternalServerError {
+		message = st.Message()
+	}
+	http.Error(w, message, code)
+}
+
+// authServiceName is the full name of the gRPC service of auth_service.
+const authServiceName = "auth.AuthService"
+
+// NewGRPCAccountService returns the AccountService calling the
+// ChangePassword, ChangeEmail and DeleteAccount RPCs of auth_service over
+// conn. The generated client of auth_service has no such RPCs, so they are
+// called by name, with the descriptors auth_service serves over gRPC
+// reflection and cached in schemas. Calls fail with Unimplemented until
+// auth_service serves them.
+func NewGRPCAccountService(conn grpc.ClientConnInterface, schemas *schema.Cache) AccountService {
+	return reflectedAuth{conn: conn, schemas: schemas}
+}
+
+// NewGRPCMFAService returns the MFAService calling the MFAStatus, SetupMFA,
+// ConfirmMFA and VerifyMFA RPCs of auth_service over conn, by name as
+// NewGRPCAccountService does.
+func NewGRPCMFAService(conn grpc.ClientConnInterface, schemas *schema.Cache) MFAService {
+	return reflectedAuth{conn: conn, schemas: schemas}
+}
+
+// NewGRPCMagicLinkService returns the MagicLinkService calling the
+// CreateMagicLink and RedeemMagicLink RPCs of auth_service over conn, by
+// name as NewGRPCAccountService does.
+func NewGRPCMagicLinkService(conn grpc.ClientConnInterface, schemas *schema.Cache) MagicLinkService {
+	return reflectedAuth{conn: conn, schemas: schemas}
+}
+
+// NewGRPCUserAdminService returns the UserAdminService calling the
+// ListUsers, LockUser, UnlockUser, ForcePasswordReset and RevokeSessions
+// RPCs of auth_service over conn, by name as NewGRPCAccountService does.
+func NewGRPCUserAdminService(conn grpc.ClientConnInterface, schemas *schema.Cache) UserAdminService {
+	return reflectedAuth{conn: conn, schemas: schemas}
+}
+
+// NewGRPCProfileService returns the ProfileService calling the GetProfile
+// RPC of auth_service over conn, by name as NewGRPCAccountService does.
+func NewGRPCProfileService(conn grpc.ClientConnInterface, schemas *schema.Cache) ProfileService {
+	return reflectedAuth{conn: conn, schemas: schemas}
+}
+
+// reflectedAuth calls the RPCs of auth_service its generated client lacks.
+type reflectedAuth struct {
+	conn    grpc.ClientConnInterface
+	schemas *schema.Cache
+}
+
+func (s reflectedAuth) ChangePassword(ctx context.Context, in *ChangePasswordRequest) error {
+	return s.call(ctx, "ChangePassword", in, nil)
+}
+
+func (s reflectedAuth) ChangeEmail(ctx context.Context, in *ChangeEmailRequest) error {
+	return s.call(ctx, "ChangeEmail", in, nil)
+}
+
+func (s reflectedAuth) DeleteAccount(ctx context.Context, in *DeleteAccountRequest) error {
+	return s.call(ctx, "DeleteAccount", in, nil)
+}
+
+func (s reflectedAuth) ListUsers(ctx context.Context, in *ListUsersRequest) (*ListUsersResponse, error) {
+	var out ListUsersResponse
+	if err := s.call(ctx, "ListUsers", in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (s reflectedAuth) LockUser(ctx context.Context, in *LockUserRequest) error {
+	return s.call(ctx, "LockUser", in, nil)
+}
+
+func (s reflectedAuth) UnlockUser(ctx context.Context, in *UserRequest) error {
+	return s.call(ctx, "UnlockUser", in, nil)
+}
+
+func (s reflectedAuth) ForcePasswordReset(ctx context.Context, in *UserRequest) error {
+	return s.call(ctx, "ForcePasswordReset", in, nil)
+}
+
+func (s reflectedAuth) RevokeSessions(ctx context.Context, in *UserRequest) error {
+	return s.call(ctx, "RevokeSessions", in, nil)
+}
+
+func (s reflectedAuth) MFAStatus(ctx context.Context, in *UserRequest) (*MFAStatusResponse, error) {
+	var out MFAStatusResponse
+	if err := s.call(ctx, "MFAStatus", in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (s reflectedAuth) SetupMFA(ctx context.Context, in *UserRequest) (*SetupMFAResponse, error) {
+	var out SetupMFAResponse
+	if err := s.call(ctx, "SetupMFA", in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (s reflectedAuth) ConfirmMFA(ctx context.Context, in *MFACodeRequest) error {
+	return s.call(ctx, "ConfirmMFA", in, nil)
+}
+
+func (s reflectedAuth) VerifyMFA(ctx context.Context, in *MFACodeRequest) (*pbAuth.TokenResponse, error) {
+	var out pbAuth.TokenResponse
+	if err := s.call(ctx, "VerifyMFA", in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (s reflectedAuth) CreateMagicLink(ctx context.Context, in *CreateMagicLinkRequest) (*CreateMagicLinkResponse, error) {
+	var out CreateMagicLinkResponse
+	if err := s.call(ctx, "CreateMagicLink", in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (s reflectedAuth) RedeemMagicLink(ctx context.Context, in *RedeemMagicLinkRequest) (*pbAuth.TokenResponse, error) {
+	var out pbAuth.TokenResponse
+	if err := s.call(ctx, "RedeemMagicLink", in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (s reflectedAuth) GetProfile(ctx context.Context, in *UserRequest) (*ProfileResponse, error) {
+	var out ProfileResponse
+	if err := s.call(ctx, "GetProfile", in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// call invokes method with in, encoded with its JSON field names, and
+// decodes the response into out the same way, unless out is nil. Generated
+// messages, such as TokenResponse, are decoded as protobuf JSON.
+func (s reflectedAuth) call(ctx context.Context, method string, in, out any) error {
+	sd, err := s.schemas.Service(ctx, backend.Auth, authServiceName)
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "auth_service: %v", err)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return status.Errorf(codes.Unimplemented, "auth_service has no %s RPC", method)
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req := dynamicpb.NewMessage(md.Input())
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, req); err != nil {
+		return err
+	}
+	resp := dynamicpb.NewMessage(md.Output())
+	if err := s.conn.Invoke(ctx, "/"+authServiceName+"/"+method, req, resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if data, err = (protojson.MarshalOptions{UseProtoNames: true}).Marshal(resp); err != nil {
+		return err
+	}
+	if m, ok := out.(proto.Message); ok {
+		return (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, m)
+	}
+	return json.Unmarshal(data, out)
+}
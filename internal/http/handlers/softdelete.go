@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/token"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// ProductDeleteHandler serves DELETE /inventory/products/{id}. With
+// ?soft=true the product is only marked unavailable and can be restored,
+// which requires one of AvailabilityRoles; otherwise it is deleted
+// permanently, which requires one of HardDeleteRoles.
+func (im *InvManager) ProductDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	soft, err := strconv.ParseBool(r.URL.Query().Get("soft"))
+	if err != nil && r.URL.Query().Has("soft") {
+		http.Error(w, "invalid soft parameter", http.StatusBadRequest)
+		return
+	}
+	if soft {
+		if requireRole(w, r, im.AvailabilityRoles, "soft delete") {
+			im.setAvailable(w, r, id, false)
+		}
+		return
+	}
+
+	if !requireRole(w, r, im.HardDeleteRoles, "hard delete") || !im.checkIfMatch(w, r, id) {
+		return
+	}
+	resp, err := im.Service.DeleteProduct(r.Context(), &pbInv.DeleteRequest{Id: id})
+	if status.Code(err) == codes.NotFound {
+		http.Error(w, "product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to delete product", http.StatusInternalServerError)
+		return
+	}
+	im.Events.Emit(r.Context(), events.ProductDeleted, id, nil)
+
+	if err := render.Write(w, r, http.StatusOK, resp); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+	}
+}
+
+// RestoreHandler serves POST /inventory/products/{id}/restore, making a
+// soft-deleted product available again.
+func (im *InvManager) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if requireRole(w, r, im.AvailabilityRoles, "restore") {
+		im.setAvailable(w, r, chi.URLParam(r, "id"), true)
+	}
+}
+
+// AvailabilityRequest is the body of an availability change.
+type AvailabilityRequest struct {
+	Available *bool `json:"available"`
+}
+
+// AvailabilityHandler serves POST /inventory/products/{id}/availability,
+// taking a product offline or back online with {"available": false} without
+// sending the full product.
+func (im *InvManager) AvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	var req AvailabilityRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Available == nil {
+		http.Error(w, "available is required", http.StatusBadRequest)
+		return
+	}
+
+	if requireRole(w, r, im.AvailabilityRoles, "changing availability") {
+		im.setAvailable(w, r, chi.URLParam(r, "id"), *req.Available)
+	}
+}
+
+// setAvailable sets the availability of product id with an update of the
+// available field alone. The inventory service has no soft-delete RPCs, so
+// soft deletes and restores are availability changes too.
+func (im *InvManager) setAvailable(w http.ResponseWriter, r *http.Request, id string, available bool) {
+	if !im.checkIfMatch(w, r, id) {
+		return
+	}
+	p, err := im.Service.UpdateProduct(r.Context(), &pbInv.UpdateRequest{
+		Product:    &pbInv.Product{Id: id, Available: available},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"available"}},
+	})
+	if status.Code(err) == codes.NotFound {
+		http.Error(w, "product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to update product", http.StatusInternalServerError)
+		return
+	}
+	if etag := productETag(p.GetProduct()); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	if err := render.Write(w, r, http.StatusOK, p); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+	}
+}
+
+// requireRole reports whether the caller holds one of roles and answers 403
+// otherwise. Roles are only trusted from tokens whose signature was verified.
+// An empty roles list allows everyone.
+func requireRole(w http.ResponseWriter, r *http.Request, roles []string, action string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	claims, ok := token.FromContext(r.Context())
+	if !ok || !claims.Verified || !claims.HasRole(roles...) {
+		http.Error(w, action+" requires role "+strings.Join(roles, " or "), http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/backend"
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/slo"
+)
+
+// statusPage is the body of /statusz.
+type statusPage struct {
+	// Status is "ok" when every checked address is healthy, "degraded"
+	// otherwise.
+	Status     string            `json:"status"`
+	Time       time.Time         `json:"time"`
+	Backends   []backend.History `json:"backends"`
+	Objectives []slo.Status      `json:"objectives,omitempty"`
+}
+
+// StatusHandler serves the recent health checks of every backend address
+// with health checks and the state of the service level objectives: as an
+// HTML page to browsers, which list text/html in Accept, and in the
+// negotiated format otherwise.
+func StatusHandler(backends *backend.Manager, objectives *slo.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := statusPage{
+			Status:     "ok",
+			Time:       time.Now().UTC(),
+			Backends:   backends.History(),
+			Objectives: objectives.Status(),
+		}
+		for _, h := range page.Backends {
+			if !h.Healthy {
+				page.Status = "degraded"
+			}
+		}
+		for _, o := range page.Objectives {
+			if o.Burning {
+				page.Status = "degraded"
+			}
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		if render.Accepts(r.Header.Get("Accept"), "text/html") {
+			w.Header().Add("Vary", "Accept")
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := statusTemplate.Execute(w, page); err != nil {
+				http.Error(w, "failed to render status page", http.StatusInternalServerError)
+			}
+			return
+		}
+		if err := render.Write(w, r, http.StatusOK, page); err != nil {
+			http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		}
+	}
+}
+
+var statusTemplate = template.Must(template.New("statusz").Funcs(template.FuncMap{
+	"ms": func(v float64) string {
+		return time.Duration(v * float64(time.Millisecond)).Round(10 * time.Microsecond).String()
+	},
+	"ts":  func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+	"pct": func(v float64) string { return strconv.FormatFloat(v*100, 'f', 1, 64) + "%" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>Gateway status: {{.Status}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; vertical-align: top; }
+.ok { color: #1a7f37; } .fail { color: #cf222e; }
+.checks span { display: inline-block; width: 4px; height: 14px; margin-right: 1px; }
+.checks .ok { background: #1a7f37; } .checks .fail { background: #cf222e; }
+</style>
+</head>
+<body>
+<h1>Gateway status: <span class="{{if eq .Status "ok"}}ok{{else}}fail{{end}}">{{.Status}}</span></h1>
+<p>{{ts .Time}}, refreshed every 10s.</p>
+{{if .Backends}}
+<table>
+<tr><th>Backend</th><th>Address</th><th>State</th><th>Checks</th><th>Failures</th><th>Flips</th><th>Latency p50 / p95 / p99</th><th>Last error</th><th>Recent checks, oldest first</th></tr>
+{{range .Backends}}
+<tr>
+<td>{{.Backend}}</td>
+<td>{{.Address}}{{if .Active}} (active){{end}}</td>
+<td class="{{if .Healthy}}ok{{else}}fail{{end}}">{{if .Healthy}}healthy{{else}}unhealthy{{end}}</td>
+<td>{{.Checks}}</td>
+<td>{{.Failures}}</td>
+<td>{{.Flips}}</td>
+<td>{{ms .Latency.P50}} / {{ms .Latency.P95}} / {{ms .Latency.P99}}</td>
+<td>{{if .LastError}}{{.LastError}}<br><small>{{ts .LastErrorAt}}</small>{{end}}</td>
+<td class="checks">{{range .Results}}<span class="{{if .OK}}ok{{else}}fail{{end}}" title="{{ts .Time}} {{ms .LatencyMS}}{{if .Error}}: {{.Error}}{{end}}"></span>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No backend has health checks configured.</p>
+{{end}}
+{{if .Objectives}}
+<h2>Objectives</h2>
+<table>
+<tr><th>Objective</th><th>Target</th><th>State</th><th>Budget left</th><th>Burn rate per window</th></tr>
+{{range .Objectives}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{.Target}}%{{if .Latency}} within {{.Latency}}{{end}}</td>
+<td class="{{if .Burning}}fail{{else}}ok{{end}}">{{if .Burning}}burning{{else}}ok{{end}}</td>
+<td>{{pct .BudgetRemaining}}</td>
+<td>{{range .Windows}}{{.Window}}: {{printf "%.2f" .BurnRate}} ({{.Bad}}/{{.Requests}} bad)<br>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/token"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// maxReasonLength bounds the free-text reason of a stock adjustment.
+const maxReasonLength = 200
+
+// AdjustRequest is the body of a stock adjustment.
+type AdjustRequest struct {
+	// Delta is added to the product quantity; negative for sales, losses etc.
+	Delta int32 `json:"delta"`
+
+	// Reason is recorded in the audit log, e.g. "sale" or "recount".
+	Reason string `json:"reason"`
+}
+
+// AdjustHandler serves POST /inventory/products/{id}/adjust, changing the
+// stock of a product by a delta. The inventory service has no stock
+// adjustment RPC, so the quantity is read, adjusted and written back with an
+// update of the quantity field alone. An If-Match header is checked against
+// the product read; without one, two concurrent adjustments can lose one of
+// them. Every adjustment is written to the log with the caller's identity.
+func (im *InvManager) AdjustHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req AdjustRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Delta == 0 {
+		http.Error(w, "delta must not be zero", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" || len(req.Reason) > maxReasonLength {
+		http.Error(w, "reason is required and limited to 200 bytes", http.StatusBadRequest)
+		return
+	}
+
+	current, err := im.Service.GetProduct(r.Context(), &pbInv.GetRequest{Id: id})
+	if status.Code(err) == codes.NotFound || (err == nil && current.GetProduct() == nil) {
+		http.Error(w, "product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to get product", http.StatusInternalServerError)
+		return
+	}
+	if !im.matchIfMatch(w, r, current.GetProduct()) {
+		return
+	}
+
+	before := current.GetProduct().GetQuantity()
+	after := int64(before) + int64(req.Delta)
+	if after < 0 {
+		http.Error(w, "insufficient stock", http.StatusConflict)
+		return
+	}
+	if after > math.MaxInt32 {
+		http.Error(w, "quantity out of range", http.StatusBadRequest)
+		return
+	}
+
+	p, err := im.Service.UpdateProduct(r.Context(), &pbInv.UpdateRequest{
+		Product:    &pbInv.Product{Id: id, Quantity: int32(after)},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"quantity"}},
+	})
+	if err != nil {
+		http.Error(w, "failed to update product", http.StatusInternalServerError)
+		return
+	}
+	auditAdjustment(r, id, req, before, int32(after))
+	if etag := productETag(p.GetProduct()); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	if err := render.Write(w, r, http.StatusOK, p); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+	}
+}
+
+// auditAdjustment logs who changed the stock of a product, by how much and why.
+func auditAdjustment(r *http.Request, id string, req AdjustRequest, before, after int32) {
+	fields := []zap.Field{
+		zap.String("product_id", id),
+		zap.Int32("delta", req.Delta),
+		zap.String("reason", req.Reason),
+		zap.Int32("quantity_before", before),
+		zap.Int32("quantity_after", after),
+	}
+	if claims, ok := token.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("user_id", claims.UserID), zap.Bool("verified", claims.Verified))
+	}
+	if rid, ok := requestid.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("request_id", rid))
+	}
+	if ip, ok := realip.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("client_ip", ip))
+	}
+	logger.Logger().Info("Stock adjusted", fields...)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// UserAdminManager serves /admin/users, the user administration of
+// auth_service. Operators authenticate with their own access token, which
+// must carry one of Roles, so that every action is logged with who did it.
+type UserAdminManager struct {
+	Service UserAdminService
+
+	// Roles are the token roles allowed to administer users.
+	Roles []string
+}
+
+func NewUserAdminManager(service UserAdminService, roles []string) *UserAdminManager {
+	if len(roles) == 0 {
+		roles = []string{"user_admin"}
+	}
+	return &UserAdminManager{
+		Service: service,
+		Roles:   roles,
+	}
+}
+
+// RequireRole rejects operators without one of Roles. It runs after the
+// Authenticator.
+func (um *UserAdminManager) RequireRole(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requireRole(w, r, um.Roles, "user administration") {
+			auditUserAdmin(r, "denied", "", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ListHandler serves GET /admin/users?query=&page_size=&page_token=.
+func (um *UserAdminManager) ListHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := ListUsersRequest{Query: q.Get("query"), PageToken: q.Get("page_token")}
+	if s := q.Get("page_size"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid page_size", http.StatusBadRequest)
+			return
+		}
+		req.PageSize = int32(n)
+	}
+	resp, err := um.Service.ListUsers(r.Context(), &req)
+	auditUserAdmin(r, "list", "", err)
+	if err != nil {
+		failRPC(w, err, "user administration failed")
+		return
+	}
+	if resp.Users == nil {
+		resp.Users = []User{}
+	}
+	if err := render.Write(w, r, http.StatusOK, resp); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+	}
+}
+
+// LockHandler serves POST /admin/users/{id}/lock with an optional
+// {"reason"}. Locked users can no longer log in or refresh their tokens.
+func (um *UserAdminManager) LockHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	err := um.Service.LockUser(r.Context(), &LockUserRequest{UserID: id, Reason: body.Reason})
+	auditUserAdmin(r, "lock", id, err, zap.String("reason", body.Reason))
+	um.done(w, err)
+}
+
+// UnlockHandler serves POST /admin/users/{id}/unlock.
+func (um *UserAdminManager) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := um.Service.UnlockUser(r.Context(), &UserRequest{UserID: id})
+	auditUserAdmin(r, "unlock", id, err)
+	um.done(w, err)
+}
+
+// PasswordResetHandler serves POST /admin/users/{id}/password-reset, which
+// makes the user choose a new password at their next login.
+func (um *UserAdminManager) PasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := um.Service.ForcePasswordReset(r.Context(), &UserRequest{UserID: id})
+	auditUserAdmin(r, "force_password_reset", id, err)
+	um.done(w, err)
+}
+
+// RevokeSessionsHandler serves DELETE /admin/users/{id}/sessions, which
+// revokes every refresh token of the user.
+func (um *UserAdminManager) RevokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := um.Service.RevokeSessions(r.Context(), &UserRequest{UserID: id})
+	auditUserAdmin(r, "revoke_sessions", id, err)
+	um.done(w, err)
+}
+
+func (um *UserAdminManager) done(w http.ResponseWriter, err error) {
+	if err != nil {
+		failRPC(w, err, "user administration failed")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auditUserAdmin logs every user administration request: the operator,
+// the action, its target user and its outcome.
+func auditUserAdmin(r *http.Request, action, userID string, err error, extra ...zap.Field) {
+	fields := []zap.Field{zap.String("action", action)}
+	if claims, ok := token.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("operator", claims.UserID))
+	}
+	if userID != "" {
+		fields = append(fields, zap.String("user_id", userID))
+	}
+	fields = append(fields, extra...)
+	if rid, ok := requestid.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("request_id", rid))
+	}
+	if ip, ok := realip.FromContext(r.Context()); ok {
+		fields = append(fields, zap.String("client_ip", ip))
+	}
+	switch {
+	case action == "denied":
+		logger.Logger().Warn("User administration denied", fields...)
+	case err != nil:
+		logger.Logger().Warn("User administration failed", append(fields, zap.Error(err))...)
+	default:
+		logger.Logger().Info("User administration", fields...)
+	}
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/andro-kes/gateway/internal/buildinfo"
+	"github.com/andro-kes/gateway/internal/http/render"
+)
+
+// VersionHandler serves the build of the running gateway and its enabled
+// features.
+func VersionHandler(info buildinfo.Info) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if err := render.Write(w, r, http.StatusOK, info); err != nil {
+			http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package instrument provides the response writer the logging, metrics and
+// recovery middlewares share to learn what a handler actually sent: the
+// final status, the size of the body and whether the header is out.
+package instrument
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// Writer records the response written through it. Flush and Hijack reach
+// the underlying writer, so streams and upgrades keep working.
+type Writer struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	written  bool
+	hijacked bool
+}
+
+// Wrap returns w as a *Writer, reusing it if it already is one, so that
+// middlewares that run one after another all see the same response.
+func Wrap(w http.ResponseWriter) *Writer {
+	if iw, ok := w.(*Writer); ok {
+		return iw
+	}
+	return &Writer{ResponseWriter: w}
+}
+
+// Status returns the status sent, http.StatusOK when the handler returned
+// without writing anything, since that is what the server then sends.
+func (w *Writer) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Bytes returns the size of the body written so far.
+func (w *Writer) Bytes() int64 {
+	return w.bytes
+}
+
+// Written reports whether the header has been sent, after which the status
+// can no longer change.
+func (w *Writer) Written() bool {
+	return w.written
+}
+
+// Hijacked reports whether the connection was taken over, e.g. for a
+// WebSocket.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+func (w *Writer) WriteHeader(code int) {
+	// informational responses are followed by the final one; the server
+	// ignores any status after that
+	if !w.written && code >= 200 {
+		w.status, w.written = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	if !w.written {
+		w.status, w.written = http.StatusOK, true
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush sends the header, if not sent yet, and the buffered body.
+func (w *Writer) Flush() {
+	w.FlushError()
+}
+
+// FlushError is Flush returning the error of the underlying writer, as used
+// by http.ResponseController.
+func (w *Writer) FlushError() error {
+	if !w.written {
+		w.status, w.written = http.StatusOK, true
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack takes over the connection from the server.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+		if !w.written {
+			w.status, w.written = http.StatusSwitchingProtocols, true
+		}
+	}
+	return conn, rw, err
+}
+
+func (w *Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,74 @@
+package instrument_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/http/instrument"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWriter tests that the writer records the final status actually sent and the body size
+func TestWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := instrument.Wrap(rec)
+	assert.Same(t, w, instrument.Wrap(w))
+	assert.False(t, w.Written())
+	assert.Equal(t, http.StatusOK, w.Status())
+
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte("created"))
+	assert.True(t, w.Written())
+	assert.Equal(t, http.StatusCreated, w.Status(), "the server ignores later statuses")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.EqualValues(t, 7, w.Bytes())
+
+	w = instrument.Wrap(httptest.NewRecorder())
+	require.NoError(t, http.NewResponseController(w).Flush())
+	assert.True(t, w.Written())
+	assert.Equal(t, http.StatusOK, w.Status())
+}
+
+// TestMetrics tests that requests are counted by route pattern and the status sent
+func TestMetrics(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(instrument.Metrics)
+	r.Get("/inventory/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.WriteHeader(http.StatusOK)
+	})
+	before := requests(t, "/inventory/{id}", "404")
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/inventory/42", nil))
+	assert.Equal(t, before+1, requests(t, "/inventory/{id}", "404"))
+
+	before = requests(t, "unmatched", "404")
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/elsewhere", nil))
+	assert.Equal(t, before+1, requests(t, "unmatched", "404"))
+}
+
+// requests returns gateway_http_requests_total for GET requests of route
+// answered with status.
+func requests(t *testing.T, route, status string) float64 {
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != "gateway_http_requests_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["method"] == "GET" && labels["route"] == route && labels["status"] == status {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
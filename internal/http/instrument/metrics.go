@@ -0,0 +1,51 @@
+package instrument
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/disconnect"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests served, by method, route and the status actually sent (499 when the client went away).",
+	}, []string{"method", "route", "status"})
+
+	requestDuration = promauto.With(metrics.Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time spent serving HTTP requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// Metrics counts requests by the status sent and times them. Routes are
+// labelled by their pattern, e.g. "/inventory/{id}", or "unmatched".
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		iw := Wrap(w)
+		next.ServeHTTP(iw, r)
+
+		status := iw.Status()
+		if disconnect.Closed(r) {
+			status = disconnect.StatusClientClosed
+		}
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		requestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		metrics.ObserveDuration(r.Context(), requestDuration.WithLabelValues(r.Method, route), time.Since(start), status >= 500)
+	})
+}
@@ -0,0 +1,40 @@
+package render
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer caps the capacity of buffers returned to the pool, so one
+// large response does not keep its memory alive for every later one.
+const maxPooledBuffer = 64 << 10
+
+var buffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// GetBuffer returns an empty buffer from the pool. Hand it back with
+// PutBuffer once nothing references its bytes any more.
+func GetBuffer() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+// PutBuffer returns buf to the pool.
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	buffers.Put(buf)
+}
+
+// ReadAll reads r into buf, growing it first to sizeHint, e.g. a request's
+// Content-Length, when that is known and poolable.
+func ReadAll(buf *bytes.Buffer, r io.Reader, sizeHint int64) error {
+	if sizeHint > 0 && sizeHint <= maxPooledBuffer {
+		buf.Grow(int(sizeHint))
+	}
+	_, err := buf.ReadFrom(r)
+	return err
+}
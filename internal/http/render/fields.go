@@ -0,0 +1,102 @@
+package render
+
+import (
+	"strings"
+)
+
+// FieldsParam is the query parameter listing the response fields to keep.
+const FieldsParam = "fields"
+
+// fieldTree is a parsed field selection: each key maps to the selection of
+// its children, nil meaning the whole value.
+type fieldTree map[string]fieldTree
+
+// parseFields parses a comma-separated list of dot-separated paths such as
+// "id,name,tags" or "product.id,product.price".
+func parseFields(fields string) fieldTree {
+	tree := fieldTree{}
+	for _, path := range strings.Split(fields, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		node := tree
+		parts := strings.Split(path, ".")
+		for i, part := range parts {
+			child, seen := node[part]
+			if seen && child == nil {
+				// an ancestor already selects the whole value
+				break
+			}
+			if i == len(parts)-1 {
+				node[part] = nil
+				break
+			}
+			if child == nil {
+				child = fieldTree{}
+				node[part] = child
+			}
+			node = child
+		}
+	}
+	return tree
+}
+
+// Select prunes v to the fields listed in fields (see FieldsParam) and
+// returns it in its JSON data model. Arrays are transparent, so "id" selects
+// the id of every element. An object whose only key is not selected, such as
+// the {"product": ...} and {"products": [...]} wrappers, is transparent too,
+// so "id,name" works on single products and lists alike. An empty selection
+// returns v unchanged.
+func Select(v any, fields string) (any, error) {
+	sel := parseFields(fields)
+	if len(sel) == 0 {
+		return v, nil
+	}
+	t, err := tree(v)
+	if err != nil {
+		return nil, err
+	}
+	return prune(t, sel), nil
+}
+
+func prune(v any, sel fieldTree) any {
+	switch v := v.(type) {
+	case []any:
+		for i, item := range v {
+			v[i] = prune(item, sel)
+		}
+		return v
+	case map[string]any:
+		if len(v) == 1 {
+			for k, child := range v {
+				if _, selected := sel[k]; !selected && isContainer(child) {
+					v[k] = prune(child, sel)
+					return v
+				}
+			}
+		}
+		out := make(map[string]any, len(sel))
+		for k, sub := range sel {
+			child, ok := v[k]
+			if !ok {
+				continue
+			}
+			if sub != nil {
+				child = prune(child, sub)
+			}
+			out[k] = child
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func isContainer(v any) bool {
+	switch v.(type) {
+	case map[string]any, []any:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package render_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var catalog = &pbInv.ListResponse{
+	Products: []*pbInv.Product{
+		{Id: "p1", Name: "Tea", Description: "Black tea", Price: 4.5, Tags: []string{"drinks"}},
+		{Id: "p2", Name: "Mug", Description: "Ceramic", Price: 9},
+	},
+	TotalSize: 2,
+}
+
+func selectJSON(t *testing.T, v any, fields string) string {
+	t.Helper()
+	out, err := render.Select(v, fields)
+	require.NoError(t, err)
+	data, err := json.Marshal(out)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// TestSelect tests pruning responses to the requested fields
+func TestSelect(t *testing.T) {
+	product := &pbInv.GetResponse{Product: catalog.Products[0]}
+
+	assert.JSONEq(t, `{"product":{"id":"p1","name":"Tea","price":4.5}}`, selectJSON(t, product, "id,name,price"))
+	assert.JSONEq(t, `{"product":{"id":"p1"}}`, selectJSON(t, product, "product.id"))
+	assert.JSONEq(t, `{"products":[{"id":"p1"},{"id":"p2"}],"total_size":2}`, selectJSON(t, catalog, "products.id,total_size"))
+	assert.JSONEq(t, `{"total_size":2}`, selectJSON(t, catalog, "total_size"))
+	assert.JSONEq(t, `{"products":[{"id":"p1","tags":["drinks"]},{"id":"p2"}]}`, selectJSON(t, catalog, "products.id, products.tags, products.id.ignored"))
+	assert.JSONEq(t, `{"product":{}}`, selectJSON(t, product, "unknown"))
+
+	out, err := render.Select(product, " , ")
+	require.NoError(t, err)
+	assert.Same(t, product, out)
+}
+
+// TestWrite_Fields tests that the fields query parameter applies to every format but protobuf
+func TestWrite_Fields(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/inventory/get?fields=id,name", nil)
+	rec := httptest.NewRecorder()
+	require.NoError(t, render.Write(rec, req, http.StatusOK, &pbInv.GetResponse{Product: catalog.Products[0]}))
+	assert.JSONEq(t, `{"product":{"id":"p1","name":"Tea"}}`, rec.Body.String())
+
+	req.Header.Set("Accept", "application/xml")
+	rec = httptest.NewRecorder()
+	require.NoError(t, render.Write(rec, req, http.StatusOK, &pbInv.GetResponse{Product: catalog.Products[0]}))
+	assert.Contains(t, rec.Body.String(), "<response><product><id>p1</id><name>Tea</name></product></response>")
+}
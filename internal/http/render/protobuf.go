@@ -0,0 +1,34 @@
+package render
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf encodes protobuf messages in their binary wire format, skipping
+// the JSON conversion. Other values fall back to the remaining encoders.
+type Protobuf struct{}
+
+func (Protobuf) MediaTypes() []string {
+	return []string{"application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf"}
+}
+
+func (Protobuf) CanEncode(v any) bool {
+	_, ok := v.(proto.Message)
+	return ok
+}
+
+func (Protobuf) Encode(w io.Writer, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("render: %T is not a protobuf message", v)
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,318 @@
+// Package render writes handler responses in the format the client asks for
+// with the Accept header: JSON by default, XML or MessagePack. Encoders are
+// looked up in a Registry, so more formats can be plugged in.
+package render
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andro-kes/gateway/internal/timing"
+)
+
+// Encoder serializes response values in one format.
+type Encoder interface {
+	// MediaTypes lists the media types the encoder serves. The first one is
+	// sent as the response Content-Type.
+	MediaTypes() []string
+
+	// Encode writes v to w.
+	Encode(w io.Writer, v any) error
+}
+
+// Registry selects encoders by Accept header. The first registered encoder
+// is the default.
+type Registry struct {
+	mu         sync.RWMutex
+	encoders   []Encoder
+	transforms []Transform
+}
+
+// Transform rewrites a response value in its JSON data model (maps, slices,
+// json.Number, string, bool, nil) before it is pruned and encoded, e.g. to
+// format prices. It may modify v in place.
+type Transform func(v any) any
+
+// NewRegistry returns a registry with the given encoders.
+func NewRegistry(encoders ...Encoder) *Registry {
+	return &Registry{encoders: encoders}
+}
+
+// Register adds an encoder. Encoders registered earlier win when several
+// match an Accept header equally well.
+func (reg *Registry) Register(enc Encoder) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.encoders = append(reg.encoders, enc)
+}
+
+// AddTransform adds a transform applied to every response except protobuf
+// ones, in the order added.
+func (reg *Registry) AddTransform(t Transform) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.transforms = append(reg.transforms, t)
+}
+
+// Prepare applies the registered transforms to v and prunes it to fields
+// (see Select), returning what the non-protobuf encoders encode.
+func (reg *Registry) Prepare(v any, fields string) (any, error) {
+	reg.mu.RLock()
+	transforms := reg.transforms
+	reg.mu.RUnlock()
+	if len(transforms) > 0 {
+		t, err := tree(v)
+		if err != nil {
+			return nil, err
+		}
+		for _, transform := range transforms {
+			t = transform(t)
+		}
+		v = t
+	}
+	return Select(v, fields)
+}
+
+// Default serves JSON, XML and MessagePack.
+var Default = NewRegistry(JSON{}, XML{}, MessagePack{})
+
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func (m mediaRange) matches(mediaType string) bool {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	return (m.typ == "*" || m.typ == typ) && (m.subtype == "*" || m.subtype == subtype)
+}
+
+func (m mediaRange) specificity() int {
+	switch {
+	case m.typ == "*":
+		return 0
+	case m.subtype == "*":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// parseAccept returns the media ranges of an Accept header, most preferred
+// first. Malformed ranges are skipped.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		typ, subtype, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return ranges[i].specificity() > ranges[j].specificity()
+	})
+	return ranges
+}
+
+// Accepts reports whether an Accept header explicitly lists mediaType with a
+// non-zero quality. Wildcards do not count, so formats that change the
+// response shape, such as streaming, are only used when asked for by name.
+func Accepts(accept, mediaType string) bool {
+	for _, r := range parseAccept(accept) {
+		if r.q > 0 && r.specificity() == 2 && r.matches(mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate returns the encoder for an Accept header value and the media type
+// to send. An empty header selects the default encoder; ok is false when no
+// encoder is acceptable.
+func (reg *Registry) Negotiate(accept string) (enc Encoder, mediaType string, ok bool) {
+	return reg.negotiate(accept, nil)
+}
+
+// ValueEncoder is implemented by encoders that only handle some values, such
+// as Protobuf. They are skipped in negotiation for other values.
+type ValueEncoder interface {
+	Encoder
+	CanEncode(v any) bool
+}
+
+// negotiate is Negotiate restricted to encoders that can encode v, if v is
+// non-nil.
+func (reg *Registry) negotiate(accept string, v any) (Encoder, string, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	var encoders []Encoder
+	for _, enc := range reg.encoders {
+		if ve, ok := enc.(ValueEncoder); ok && v != nil && !ve.CanEncode(v) {
+			continue
+		}
+		encoders = append(encoders, enc)
+	}
+	if len(encoders) == 0 {
+		return nil, "", false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return encoders[0], encoders[0].MediaTypes()[0], true
+	}
+
+	ranges := parseAccept(accept)
+	// explicitly refused types (q=0) are never selected by a wildcard
+	refused := func(mediaType string) bool {
+		for _, r := range ranges {
+			if r.q == 0 && r.specificity() == 2 && r.matches(mediaType) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range ranges {
+		if r.q == 0 {
+			continue
+		}
+		for _, enc := range encoders {
+			for _, mt := range enc.MediaTypes() {
+				if r.matches(mt) && !refused(mt) {
+					if r.specificity() < 2 {
+						// wildcards get the encoder's canonical type
+						mt = enc.MediaTypes()[0]
+					}
+					return enc, mt, true
+				}
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// Write encodes v with the encoder negotiated from r's Accept header and
+// writes it with status, transformed and pruned to the ?fields= selection
+// (see Prepare) except for protobuf. Clients accepting none of the formats
+// get 406. The response is encoded into a pooled buffer first, so it goes
+// out with a Content-Length; if encoding fails, nothing is written and the
+// error is returned, so the caller can still answer with an error status.
+func (reg *Registry) Write(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	w.Header().Add("Vary", "Accept")
+	enc, mediaType, ok := reg.negotiate(r.Header.Get("Accept"), v)
+	if !ok {
+		http.Error(w, "not acceptable, supported types: "+strings.Join(reg.mediaTypes(), ", "), http.StatusNotAcceptable)
+		return nil
+	}
+
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	if err := reg.encode(buf, enc, r, v); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encode prepares v for enc, unless it is protobuf, and encodes it into buf,
+// timed as the encode segment of the request.
+func (reg *Registry) encode(buf *bytes.Buffer, enc Encoder, r *http.Request, v any) error {
+	defer timing.Start(r.Context(), timing.Encode)()
+	if _, raw := enc.(Protobuf); !raw {
+		var err error
+		if v, err = reg.Prepare(v, r.URL.Query().Get(FieldsParam)); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(buf, v)
+}
+
+func (reg *Registry) mediaTypes() []string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	var types []string
+	for _, enc := range reg.encoders {
+		types = append(types, enc.MediaTypes()[0])
+	}
+	return types
+}
+
+type registryKey struct{}
+
+// Middleware makes reg the registry that Write and Prepare use for the
+// request, so that several gateways in one process can have their own
+// encoders and transforms.
+func (reg *Registry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), registryKey{}, reg)))
+	})
+}
+
+// FromRequest returns the registry installed for r by Middleware, or
+// Default.
+func FromRequest(r *http.Request) *Registry {
+	if reg, ok := r.Context().Value(registryKey{}).(*Registry); ok {
+		return reg
+	}
+	return Default
+}
+
+// Prepare prepares v using the registry of r.
+func Prepare(r *http.Request, v any, fields string) (any, error) {
+	return FromRequest(r).Prepare(v, fields)
+}
+
+// Write writes v using the registry of r.
+func Write(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	return FromRequest(r).Write(w, r, status, v)
+}
+
+// JSON encodes values with encoding/json.
+type JSON struct{}
+
+func (JSON) MediaTypes() []string {
+	return []string{"application/json"}
+}
+
+func (JSON) Encode(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// tree converts v to its JSON data model (maps, slices, json.Number, string,
+// bool, nil), so every format uses the same field names as JSON, including
+// the json tags of generated protobuf types.
+func tree(v any) (any, error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(buf)
+	dec.UseNumber()
+	var out any
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,215 @@
+package render_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestNegotiate tests encoder selection by Accept header
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/json", "application/json"},
+		{"text/xml", "text/xml"},
+		{"application/*", "application/json"},
+		{"application/json;q=0.5, application/xml", "application/xml"},
+		{"application/x-msgpack", "application/x-msgpack"},
+		{"text/html, application/msgpack;q=0.9, */*;q=0.1", "application/msgpack"},
+		{"*/*, application/json;q=0", "application/xml"},
+		{"garbage, application/xml", "application/xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			_, mediaType, ok := render.Default.Negotiate(tt.accept)
+			require.True(t, ok)
+			assert.Equal(t, tt.want, mediaType)
+		})
+	}
+
+	_, _, ok := render.Default.Negotiate("text/html, application/json;q=0")
+	assert.False(t, ok)
+}
+
+func write(t *testing.T, accept string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/inventory/get", nil)
+	req.Header.Set("Accept", accept)
+	rec := httptest.NewRecorder()
+	require.NoError(t, render.Write(rec, req, http.StatusOK, v))
+	return rec
+}
+
+var product = &pbInv.GetResponse{Product: &pbInv.Product{Id: "p1", Name: "Tea & <Biscuits>"}}
+
+// TestWrite_JSON tests the default JSON encoding
+func TestWrite_JSON(t *testing.T) {
+	rec := write(t, "", product)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+	assert.JSONEq(t, `{"product":{"id":"p1","name":"Tea & <Biscuits>"}}`, rec.Body.String())
+	assert.Equal(t, strconv.Itoa(rec.Body.Len()), rec.Header().Get("Content-Length"))
+}
+
+// TestWrite_XML tests that XML uses the JSON field names
+func TestWrite_XML(t *testing.T) {
+	rec := write(t, "application/xml", map[string]any{
+		"products": []any{map[string]any{"id": "p1", "qty": 3}},
+		"total":    1,
+		"2fa":      true,
+		"next":     nil,
+	})
+	assert.Equal(t, "application/xml", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<response><_2fa>true</_2fa><next></next><products><item><id>p1</id><qty>3</qty></item></products><total>1</total></response>`+"\n",
+		rec.Body.String())
+
+	rec = write(t, "text/xml", product)
+	assert.Contains(t, rec.Body.String(), "<product><id>p1</id><name>Tea &amp; &lt;Biscuits&gt;</name></product>")
+}
+
+// TestWrite_MessagePack tests MessagePack encoding with native numbers
+func TestWrite_MessagePack(t *testing.T) {
+	rec := write(t, "application/msgpack", map[string]any{"id": "p1", "qty": 3, "price": 9.5})
+	assert.Equal(t, "application/msgpack", rec.Header().Get("Content-Type"))
+
+	var out map[string]any
+	require.NoError(t, msgpack.Unmarshal(rec.Body.Bytes(), &out))
+	assert.Equal(t, "p1", out["id"])
+	assert.EqualValues(t, 3, out["qty"])
+	assert.Equal(t, 9.5, out["price"])
+}
+
+// TestWrite_NotAcceptable tests clients accepting none of the formats
+func TestWrite_NotAcceptable(t *testing.T) {
+	rec := write(t, "text/html", product)
+	assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "application/msgpack")
+}
+
+// TestWrite_EncodeError tests that nothing is written when encoding fails
+func TestWrite_EncodeError(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	err := render.Write(rec, req, http.StatusOK, map[string]any{"bad": make(chan int)})
+	assert.Error(t, err)
+	assert.Empty(t, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Content-Type"))
+}
+
+// TestWrite_Protobuf tests that protobuf is only negotiated for protobuf messages
+func TestWrite_Protobuf(t *testing.T) {
+	reg := render.NewRegistry(render.JSON{}, render.Protobuf{})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/x-protobuf, application/json;q=0.5")
+	rec := httptest.NewRecorder()
+	require.NoError(t, reg.Write(rec, req, http.StatusOK, product))
+	assert.Equal(t, "application/x-protobuf", rec.Header().Get("Content-Type"))
+	var out pbInv.GetResponse
+	require.NoError(t, proto.Unmarshal(rec.Body.Bytes(), &out))
+	assert.Equal(t, "p1", out.Product.Id)
+
+	rec = httptest.NewRecorder()
+	require.NoError(t, reg.Write(rec, req, http.StatusOK, map[string]any{"user_id": "u1"}))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+}
+
+// TestWrite_Transform tests that transforms rewrite all but protobuf responses
+func TestWrite_Transform(t *testing.T) {
+	reg := render.NewRegistry(render.JSON{}, render.Protobuf{})
+	reg.AddTransform(func(v any) any {
+		v.(map[string]any)["product"].(map[string]any)["name"] = "redacted"
+		return v
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/inventory/get?fields=name", nil)
+	rec := httptest.NewRecorder()
+	require.NoError(t, reg.Write(rec, req, http.StatusOK, product))
+	assert.JSONEq(t, `{"product":{"name":"redacted"}}`, rec.Body.String())
+
+	req.Header.Set("Accept", "application/x-protobuf")
+	rec = httptest.NewRecorder()
+	require.NoError(t, reg.Write(rec, req, http.StatusOK, product))
+	var got pbInv.GetResponse
+	require.NoError(t, proto.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, "Tea & <Biscuits>", got.Product.Name)
+}
+
+// TestRegistry_Middleware tests that Write uses the registry installed for the request and Default otherwise
+func TestRegistry_Middleware(t *testing.T) {
+	reg := render.NewRegistry(render.JSON{})
+	reg.AddTransform(func(v any) any {
+		v.(map[string]any)["product"].(map[string]any)["name"] = "redacted"
+		return v
+	})
+	write := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, render.Write(w, r, http.StatusOK, product))
+	})
+
+	rec := httptest.NewRecorder()
+	reg.Middleware(write).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/inventory/get?fields=name", nil))
+	assert.JSONEq(t, `{"product":{"name":"redacted"}}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	write.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/inventory/get?fields=name", nil))
+	assert.JSONEq(t, `{"product":{"name":"Tea & <Biscuits>"}}`, rec.Body.String(), "other registries are not affected")
+}
+
+// discardWriter is a ResponseWriter that keeps nothing, so benchmarks only
+// count the allocations of Write itself.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) WriteHeader(int)             {}
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func productPage(n int) *pbInv.ListResponse {
+	resp := &pbInv.ListResponse{}
+	for i := range n {
+		resp.Products = append(resp.Products, &pbInv.Product{
+			Id:          "p" + strconv.Itoa(i),
+			Name:        "Product " + strconv.Itoa(i),
+			Description: "A product description long enough to matter in the encoded size",
+			Price:       float64(i) + 0.99,
+			Quantity:    int32(i),
+		})
+	}
+	return resp
+}
+
+func benchmarkWrite(b *testing.B, target string, v any) {
+	reg := render.NewRegistry(render.JSON{})
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		w := &discardWriter{header: http.Header{}}
+		for pb.Next() {
+			clear(w.header)
+			if err := reg.Write(w, req, http.StatusOK, v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkWrite_JSON measures encoding a single product and a page of them
+func BenchmarkWrite_JSON(b *testing.B) {
+	b.Run("product", func(b *testing.B) { benchmarkWrite(b, "/inventory/get", product) })
+	b.Run("list", func(b *testing.B) { benchmarkWrite(b, "/inventory/list", productPage(50)) })
+	b.Run("fields", func(b *testing.B) { benchmarkWrite(b, "/inventory/list?fields=id,name", productPage(50)) })
+}
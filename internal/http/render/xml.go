@@ -0,0 +1,100 @@
+package render
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// XML encodes values as XML with a <response> root. Object fields become
+// elements named after their JSON keys, and array elements are wrapped in
+// <item> elements:
+//
+//	{"products":[{"id":"p1"}]}
+//
+// becomes
+//
+//	<response><products><item><id>p1</id></item></products></response>
+type XML struct{}
+
+func (XML) MediaTypes() []string {
+	return []string{"application/xml", "text/xml"}
+}
+
+func (XML) Encode(w io.Writer, v any) error {
+	t, err := tree(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := encodeXML(enc, "response", t); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func encodeXML(enc *xml.Encoder, name string, v any) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := encodeXML(enc, k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err := encodeXML(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	case string:
+		if err := enc.EncodeToken(xml.CharData(v)); err != nil {
+			return err
+		}
+	case json.Number, bool:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("render: unexpected %T in XML tree", v)
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// xmlName makes a JSON key a valid XML element name by replacing characters
+// other than letters, digits, '-', '_' and '.' and prefixing names that do
+// not start with a letter or '_'.
+func xmlName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || !(b[0] == '_' || b[0] >= 'a' && b[0] <= 'z' || b[0] >= 'A' && b[0] <= 'Z') {
+		b = append([]byte("_"), b...)
+	}
+	return string(b)
+}
@@ -0,0 +1,220 @@
+// Package i18n localizes the messages of the gateway's JSON error bodies.
+// The error code of a body, such as "not_found", stays the same in every
+// language for clients to act on; its "message" is picked from a catalog by
+// the request's Accept-Language header. Catalogs for a few languages are
+// embedded, and message files extend them or add languages.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultLocale answers requests that accept none of the catalogs.
+const DefaultLocale = "en"
+
+//go:embed catalog/*.json
+var embedded embed.FS
+
+// builtin holds the embedded catalogs.
+var builtin = mustBuiltin()
+
+// Config extends the embedded catalogs.
+type Config struct {
+	// DefaultLocale answers requests that accept none of the catalogs.
+	// Default: en.
+	DefaultLocale string `yaml:"default_locale"`
+
+	// Catalogs are message files in YAML or JSON, each a mapping of message
+	// IDs to texts named after its locale, e.g. de.yaml or pt-BR.json. They
+	// replace the embedded messages they define and add new locales.
+	Catalogs []string `yaml:"catalogs"`
+}
+
+// Catalog holds the messages of each locale.
+type Catalog struct {
+	defaultLocale string
+	messages      map[string]map[string]string
+
+	// locales maps lowercase tags to the locales of messages.
+	locales map[string]string
+}
+
+// New returns the embedded catalogs extended by cfg, or nil when cfg is
+// empty, in which case Message uses the embedded catalogs.
+func New(cfg Config) (*Catalog, error) {
+	if cfg.DefaultLocale == "" && len(cfg.Catalogs) == 0 {
+		return nil, nil
+	}
+	c := newCatalog()
+	for locale, messages := range builtin.messages {
+		c.add(locale, messages)
+	}
+	for _, file := range cfg.Catalogs {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := yaml.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		c.add(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), messages)
+	}
+	if cfg.DefaultLocale != "" {
+		locale, ok := c.locales[strings.ToLower(cfg.DefaultLocale)]
+		if !ok {
+			return nil, fmt.Errorf("no catalog for default locale %q", cfg.DefaultLocale)
+		}
+		c.defaultLocale = locale
+	}
+	return c, nil
+}
+
+func newCatalog() *Catalog {
+	return &Catalog{defaultLocale: DefaultLocale, messages: map[string]map[string]string{}, locales: map[string]string{}}
+}
+
+// add merges messages into those of locale.
+func (c *Catalog) add(locale string, messages map[string]string) {
+	if existing, ok := c.locales[strings.ToLower(locale)]; ok {
+		locale = existing
+	} else {
+		c.locales[strings.ToLower(locale)] = locale
+		c.messages[locale] = map[string]string{}
+	}
+	for id, text := range messages {
+		c.messages[locale][id] = text
+	}
+}
+
+func mustBuiltin() *Catalog {
+	c := newCatalog()
+	files, err := embedded.ReadDir("catalog")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		data, err := embedded.ReadFile(path.Join("catalog", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := yaml.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: catalog %s: %v", f.Name(), err))
+		}
+		c.add(strings.TrimSuffix(f.Name(), ".json"), messages)
+	}
+	return c
+}
+
+// Locales returns the locales of the catalog, sorted.
+func (c *Catalog) Locales() []string {
+	if c == nil {
+		c = builtin
+	}
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+type catalogKey struct{}
+
+// Middleware makes the catalog available to Message for the request.
+func (c *Catalog) Middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), catalogKey{}, c)))
+	})
+}
+
+// Message returns the text of message id in the language r accepts best,
+// with each {name} replaced by the value following name in args. It sets
+// the Content-Language of the response to the language of the text. Without
+// a translation, the text of the default locale is used, then the English
+// one, then id itself.
+func Message(w http.ResponseWriter, r *http.Request, id string, args ...string) string {
+	c, ok := r.Context().Value(catalogKey{}).(*Catalog)
+	if !ok {
+		c = builtin
+	}
+	locale := c.negotiate(r.Header.Get("Accept-Language"))
+	text, ok := c.messages[locale][id]
+	for _, fallback := range []string{c.defaultLocale, DefaultLocale} {
+		if ok {
+			break
+		}
+		locale = fallback
+		text, ok = c.messages[locale][id]
+	}
+	if !ok {
+		text = id
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", locale)
+	if len(args) < 2 {
+		return text
+	}
+	pairs := make([]string, 0, len(args))
+	for i := 0; i+1 < len(args); i += 2 {
+		pairs = append(pairs, "{"+args[i]+"}", args[i+1])
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// negotiate returns the locale of the catalog that the Accept-Language
+// header accept prefers, matching "de-CH" to "de" when there is no "de-CH"
+// catalog, or the default locale.
+func (c *Catalog) negotiate(accept string) string {
+	type tag struct {
+		name string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{strings.ToLower(name), q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	for _, t := range tags {
+		if t.name == "*" {
+			return c.defaultLocale
+		}
+		if locale, ok := c.locales[t.name]; ok {
+			return locale
+		}
+		if base, _, ok := strings.Cut(t.name, "-"); ok {
+			if locale, ok := c.locales[base]; ok {
+				return locale
+			}
+		}
+	}
+	return c.defaultLocale
+}
@@ -0,0 +1,113 @@
+package i18n_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// message resolves id for a request accepting accept, through the middleware of c
+func message(c *i18n.Catalog, accept, id string, args ...string) (string, http.Header) {
+	var text string
+	w := httptest.NewRecorder()
+	c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		text = i18n.Message(w, r, id, args...)
+	})).ServeHTTP(w, func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if accept != "" {
+			req.Header.Set("Accept-Language", accept)
+		}
+		return req
+	}())
+	return text, w.Header()
+}
+
+// TestMessage tests that messages are picked by Accept-Language, fall back to English and have their arguments replaced
+func TestMessage(t *testing.T) {
+	tests := []struct {
+		accept   string
+		locale   string
+		expected string
+	}{
+		{"", "en", "no route for GET /x"},
+		{"de", "de", "keine Route für GET /x"},
+		{"de-CH, en;q=0.5", "de", "keine Route für GET /x"},
+		{"fr;q=0.2, ru", "ru", "нет маршрута для GET /x"},
+		{"pt-BR, es;q=0.9", "es", "no hay ninguna ruta para GET /x"},
+		{"FR-ca", "fr", "aucune route pour GET /x"},
+		{"de;q=0, fr;q=0.1", "fr", "aucune route pour GET /x"},
+		{"pt, *;q=0.5", "en", "no route for GET /x"},
+		{"de;q=abc", "en", "no route for GET /x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			text, header := message(nil, tt.accept, "not_found", "method", "GET", "path", "/x")
+			assert.Equal(t, tt.expected, text)
+			assert.Equal(t, tt.locale, header.Get("Content-Language"))
+			assert.Equal(t, "Accept-Language", header.Get("Vary"))
+		})
+	}
+
+	text, _ := message(nil, "de", "unknown_message")
+	assert.Equal(t, "unknown_message", text)
+}
+
+// TestCatalogs tests that the embedded catalogs translate every English message
+func TestCatalogs(t *testing.T) {
+	c, err := i18n.New(i18n.Config{DefaultLocale: "en"})
+	require.NoError(t, err)
+	locales := c.Locales()
+	assert.Equal(t, []string{"de", "en", "es", "fr", "ru"}, locales)
+
+	ids := []string{"discovery_unavailable", "fault_injected", "internal_error", "maintenance", "method_not_allowed", "not_found", "overloaded", "queue_full", "queue_timeout", "too_many_concurrent_requests"}
+	for _, locale := range locales {
+		for _, id := range ids {
+			_, header := message(c, locale, id)
+			assert.Equal(t, locale, header.Get("Content-Language"), "%s has no %s message", locale, id)
+		}
+	}
+}
+
+// TestNew tests that message files override embedded messages, add locales and set the default locale
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	de := filepath.Join(dir, "de.yaml")
+	require.NoError(t, os.WriteFile(de, []byte("overloaded: Bitte später erneut versuchen\n"), 0o600))
+	pt := filepath.Join(dir, "pt-BR.json")
+	require.NoError(t, os.WriteFile(pt, []byte(`{"overloaded": "o gateway está sobrecarregado"}`), 0o600))
+
+	c, err := i18n.New(i18n.Config{DefaultLocale: "pt-br", Catalogs: []string{de, pt}})
+	require.NoError(t, err)
+
+	text, _ := message(c, "de", "overloaded")
+	assert.Equal(t, "Bitte später erneut versuchen", text)
+	text, _ = message(c, "de", "queue_full")
+	assert.Equal(t, "die Anfragewarteschlange ist voll", text)
+
+	text, header := message(c, "pt-BR", "overloaded")
+	assert.Equal(t, "o gateway está sobrecarregado", text)
+	assert.Equal(t, "pt-BR", header.Get("Content-Language"))
+	text, header = message(c, "ja", "overloaded")
+	assert.Equal(t, "o gateway está sobrecarregado", text)
+	assert.Equal(t, "pt-BR", header.Get("Content-Language"))
+
+	// pt-BR only translates overloaded
+	text, header = message(c, "pt", "queue_full")
+	assert.Equal(t, "request queue is full", text)
+	assert.Equal(t, "en", header.Get("Content-Language"))
+
+	_, err = i18n.New(i18n.Config{DefaultLocale: "ja"})
+	assert.ErrorContains(t, err, `no catalog for default locale "ja"`)
+	_, err = i18n.New(i18n.Config{Catalogs: []string{filepath.Join(dir, "missing.yaml")}})
+	assert.Error(t, err)
+
+	c, err = i18n.New(i18n.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, c)
+}
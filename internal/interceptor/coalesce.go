@@ -0,0 +1,138 @@
+package interceptor
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultCoalesce are the methods coalesced when Config.Coalesce is nil.
+var DefaultCoalesce = []string{
+	"/inventory.InventoryService/GetProduct",
+	"/inventory.InventoryService/ListProducts",
+}
+
+// perCall are the metadata keys that differ between otherwise identical
+// calls without changing their result; they are left out of the key of a
+// coalesced call, which carries those of the first caller.
+var perCall = map[string]bool{
+	"traceparent":       true,
+	"x-request-id":      true,
+	"x-forwarded-for":   true,
+	"x-forwarded-proto": true,
+	"x-real-ip":         true,
+	"x-user-agent":      true,
+}
+
+var coalescedTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "grpc_client",
+	Name:      "coalesced_total",
+	Help:      "Outbound gRPC calls that shared the result of an identical call in flight.",
+}, []string{"method"})
+
+// flight is a call in flight, shared by the identical calls made meanwhile.
+type flight struct {
+	done  chan struct{}
+	reply proto.Message
+	err   error
+}
+
+// Coalesce makes identical concurrent calls of methods share one backend
+// call. Calls are identical when their method, request and outgoing
+// metadata, but for per-call keys like x-request-id, are; so calls of
+// different users are never shared. Each caller gets its own copy of the
+// reply, and stops waiting when its own context ends. The shared call
+// outlives the caller that started it. Place it after the interceptors
+// that set metadata.
+func Coalesce(methods []string) grpc.UnaryClientInterceptor {
+	coalesced := map[string]bool{}
+	for _, m := range methods {
+		coalesced[m] = true
+	}
+	var (
+		mu      sync.Mutex
+		flights = map[string]*flight{}
+	)
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		in, inOK := req.(proto.Message)
+		out, outOK := reply.(proto.Message)
+		if !coalesced[method] || !inOK || !outOK {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		key, err := coalesceKey(ctx, method, in)
+		if err != nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		mu.Lock()
+		f, shared := flights[key]
+		if !shared {
+			f = &flight{done: make(chan struct{})}
+			flights[key] = f
+		}
+		mu.Unlock()
+
+		if shared {
+			coalescedTotal.WithLabelValues(method).Inc()
+		} else {
+			go func() {
+				shared := out.ProtoReflect().New().Interface()
+				f.err = invoker(context.WithoutCancel(ctx), method, req, shared, cc, opts...)
+				f.reply = shared
+				mu.Lock()
+				delete(flights, key)
+				mu.Unlock()
+				close(f.done)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-f.done:
+		}
+		if f.err != nil {
+			return f.err
+		}
+		proto.Reset(out)
+		proto.Merge(out, f.reply)
+		return nil
+	}
+}
+
+func coalesceKey(ctx context.Context, method string, req proto.Message) (string, error) {
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(method)
+	b.WriteByte(0)
+	b.WriteString(strconv.Itoa(len(body)))
+	b.WriteByte(0)
+	b.Write(body)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for _, k := range slices.Sorted(maps.Keys(md)) {
+		if perCall[k] {
+			continue
+		}
+		for _, v := range md[k] {
+			b.WriteByte(0)
+			b.WriteString(k)
+			b.WriteByte('=')
+			b.WriteString(v)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,82 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// reservedHeaders are the metadata keys the gateway or gRPC set themselves.
+// Copying them from the client would let it override what the gateway
+// vouches for, such as its identity.
+var reservedHeaders = map[string]string{
+	"authorization":           "forwarded by the gateway after checking the access token",
+	"x-user-id":               "set by the gateway from the verified token",
+	"x-user-roles":            "set by the gateway from the verified token",
+	"x-token-exp":             "set by the gateway from the verified token",
+	"x-request-id":            "set by the gateway",
+	"x-forwarded-for":         "set by the gateway from trusted proxies only",
+	"x-forwarded-proto":       "set by the gateway from trusted proxies only",
+	"x-real-ip":               "set by the gateway from trusted proxies only",
+	"x-user-agent":            "set by the gateway",
+	"x-remember-me":           "set by the gateway on login",
+	"x-gateway-authorization": "carries the gateway's service token",
+	"traceparent":             "propagated by the gateway's tracing",
+	"user-agent":              "reserved by gRPC",
+	"content-type":            "reserved by gRPC",
+	"te":                      "reserved by gRPC",
+}
+
+// CheckHeaders returns an error if a header of names cannot be propagated:
+// a key the gateway or gRPC set, a grpc- prefixed or binary (-bin) key.
+func CheckHeaders(names []string) error {
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if why, ok := reservedHeaders[key]; ok {
+			return fmt.Errorf("header %s cannot be propagated: %s", name, why)
+		}
+		if key == "" || strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, ":") || strings.HasSuffix(key, "-bin") {
+			return fmt.Errorf("header %q cannot be propagated", name)
+		}
+	}
+	return nil
+}
+
+type headersKey struct{}
+
+// PropagateHeaders returns middleware that stores the values of the named
+// request headers in the request context, for HeaderMetadata to forward.
+func PropagateHeaders(names []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(names) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var kv []string
+			for _, name := range names {
+				for _, v := range r.Header.Values(name) {
+					kv = append(kv, strings.ToLower(name), v)
+				}
+			}
+			if len(kv) > 0 {
+				r = r.WithContext(context.WithValue(r.Context(), headersKey{}, kv))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HeaderMetadata forwards the headers stored by PropagateHeaders as
+// metadata of the same name.
+func HeaderMetadata() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if kv, ok := ctx.Value(headersKey{}).([]string); ok {
+			ctx = metadata.AppendToOutgoingContext(ctx, kv...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
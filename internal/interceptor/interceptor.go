@@ -0,0 +1,373 @@
+// Package interceptor provides the unary client interceptor pipeline applied
+// to every backend connection. Cross-cutting concerns (timing, tracing,
+// logging, metrics, auth metadata, coalescing, deadlines, retries) live here
+// instead of in handlers.
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/clientinfo"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/timing"
+	"github.com/andro-kes/gateway/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Config configures the interceptor pipeline.
+type Config struct {
+	// Timeout is applied to calls whose context has no deadline. Default: 10s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// SlowCallThreshold is the duration above which calls are logged as slow.
+	// Default: 1s.
+	SlowCallThreshold time.Duration `yaml:"slow_call_threshold"`
+
+	Retry RetryConfig `yaml:"retry"`
+
+	// PropagateHeaders are the inbound HTTP headers copied into the metadata
+	// of every backend call, e.g. X-Tenant-ID or Accept-Language. Headers
+	// the gateway sets itself cannot be listed.
+	PropagateHeaders []string `yaml:"propagate_headers"`
+
+	// Coalesce are the full method names whose identical concurrent calls
+	// share one backend call. Default: DefaultCoalesce; an empty list turns
+	// coalescing off.
+	Coalesce []string `yaml:"coalesce"`
+
+	// Labels describe the environment, e.g. env: prod, and are sent with
+	// every backend call under the label_prefix of the backend.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// RetryConfig controls retries of failed calls.
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first. Default: 1 (no retries).
+	MaxAttempts int `yaml:"max_attempts"`
+
+	// Backoff is the delay before the first retry; it doubles on every attempt. Default: 100ms.
+	Backoff time.Duration `yaml:"backoff"`
+
+	// Codes are the gRPC status codes that are retried. Default: [UNAVAILABLE].
+	Codes []string `yaml:"codes"`
+
+	// Methods limits retries to these full method names. Empty means all methods.
+	Methods []string `yaml:"methods"`
+}
+
+var (
+	rpcDuration = promauto.With(metrics.Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "grpc_client",
+		Name:      "duration_seconds",
+		Help:      "Latency of outbound gRPC calls, including retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	retriesTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "grpc_client",
+		Name:      "retries_total",
+		Help:      "Retried outbound gRPC calls.",
+	}, []string{"method"})
+)
+
+// Chain returns the gateway's interceptors in the order they must run.
+func Chain(cfg Config) []grpc.UnaryClientInterceptor {
+	coalesce := cfg.Coalesce
+	if coalesce == nil {
+		coalesce = DefaultCoalesce
+	}
+	return []grpc.UnaryClientInterceptor{
+		Timing(),
+		Tracing(),
+		Logging(cfg.SlowCallThreshold),
+		Metrics(),
+		AuthMetadata(),
+		ClientMetadata(),
+		HeaderMetadata(),
+		Coalesce(coalesce),
+		Deadline(cfg.Timeout),
+		Retry(cfg.Retry),
+	}
+}
+
+// DialOption applies Chain(cfg) to a connection.
+func DialOption(cfg Config) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(Chain(cfg)...)
+}
+
+// StreamDialOption applies StreamMetadata to the streams of a connection.
+func StreamDialOption() grpc.DialOption {
+	return grpc.WithChainStreamInterceptor(StreamMetadata())
+}
+
+// StreamMetadata attaches to streams the metadata that AuthMetadata,
+// ClientMetadata and HeaderMetadata attach to unary calls.
+func StreamMetadata() grpc.StreamClientInterceptor {
+	unary := []grpc.UnaryClientInterceptor{AuthMetadata(), ClientMetadata(), HeaderMetadata()}
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		for _, u := range unary {
+			// each interceptor hands its outgoing context to the invoker
+			_ = u(ctx, method, nil, nil, cc, func(next context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+				ctx = next
+				return nil
+			})
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// Timing adds the duration of the call to the backend segment of the
+// request's timings.
+func Timing() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		defer timing.Start(ctx, timing.Backend)()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Tracing records a client span for the call and propagates it in the
+// traceparent metadata.
+func Tracing() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := tracing.StartSpan(ctx, method)
+		ctx = metadata.AppendToOutgoingContext(ctx, tracing.Header, span.Context.TraceParent())
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		span.SetAttributes(zap.String("code", status.Code(err).String()))
+		span.End(err)
+		return err
+	}
+}
+
+// Logging logs every call at debug level, and failed calls and calls slower
+// than slow as warnings, with the request ID of the HTTP request that made
+// them.
+func Logging(slow time.Duration) grpc.UnaryClientInterceptor {
+	if slow <= 0 {
+		slow = time.Second
+	}
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		duration := time.Since(start)
+
+		zl := logger.Logger()
+		fields := func() []zap.Field {
+			id, _ := requestid.FromContext(ctx)
+			fields := []zap.Field{
+				zap.String("method", method),
+				zap.String("backend", target(cc)),
+				zap.String("request_id", id),
+				zap.String("client_ip", clientIP(ctx)),
+				zap.String("code", status.Code(err).String()),
+				zap.Duration("duration", duration),
+			}
+			if err != nil {
+				fields = append(fields, zap.Error(err))
+			}
+			return fields
+		}
+
+		switch {
+		case status.Code(err) == codes.Canceled && errors.Is(ctx.Err(), context.Canceled):
+			// the client went away; not a backend failure
+			if zl.Core().Enabled(zap.DebugLevel) {
+				zl.Debug("Backend call canceled by client", fields()...)
+			}
+		case err != nil:
+			zl.Warn("Backend call failed", fields()...)
+		case duration > slow:
+			zl.Warn("Slow backend call", append(fields(), zap.Duration("threshold", slow))...)
+		case zl.Core().Enabled(zap.DebugLevel):
+			zl.Debug("Backend call", fields()...)
+		}
+		return err
+	}
+}
+
+func target(cc *grpc.ClientConn) string {
+	if cc == nil {
+		return ""
+	}
+	return cc.Target()
+}
+
+func clientIP(ctx context.Context) string {
+	ip, _ := realip.FromContext(ctx)
+	return ip
+}
+
+// Metrics records call latency by method and status code.
+func Metrics() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		metrics.ObserveDuration(ctx, rpcDuration.WithLabelValues(method, status.Code(err).String()), time.Since(start), err != nil)
+		return err
+	}
+}
+
+type authKey struct{}
+
+// WithAuthorization returns a copy of ctx whose backend calls carry the
+// given Authorization value.
+func WithAuthorization(ctx context.Context, auth string) context.Context {
+	return context.WithValue(ctx, authKey{}, auth)
+}
+
+// AuthorizationFromContext returns the Authorization value stored in ctx.
+func AuthorizationFromContext(ctx context.Context) (string, bool) {
+	auth, ok := ctx.Value(authKey{}).(string)
+	return auth, ok && auth != ""
+}
+
+// Identity is the verified caller identity forwarded to backends.
+type Identity struct {
+	UserID    string
+	Roles     []string
+	ExpiresAt time.Time
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx whose backend calls carry the caller's
+// identity as x-user-id, x-user-roles and x-token-exp metadata.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored in ctx.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// AuthMetadata attaches the caller's Authorization value (unless already
+// present) and identity to outgoing metadata.
+func AuthMetadata() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if auth, ok := AuthorizationFromContext(ctx); ok {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if len(md.Get("authorization")) == 0 {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", auth)
+			}
+		}
+		if id, ok := IdentityFromContext(ctx); ok {
+			kv := []string{"x-user-id", id.UserID}
+			if len(id.Roles) > 0 {
+				kv = append(kv, "x-user-roles", strings.Join(id.Roles, ","))
+			}
+			if !id.ExpiresAt.IsZero() {
+				kv = append(kv, "x-token-exp", strconv.FormatInt(id.ExpiresAt.Unix(), 10))
+			}
+			ctx = metadata.AppendToOutgoingContext(ctx, kv...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// ClientMetadata forwards the HTTP client's address chain, scheme and user
+// agent as x-forwarded-for, x-forwarded-proto, x-real-ip and x-user-agent
+// metadata, and the request ID as x-request-id. grpc-go reserves the
+// user-agent key for its own value, hence the x- prefix.
+func ClientMetadata() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if id, ok := requestid.FromContext(ctx); ok {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", id)
+		}
+		if info, ok := clientinfo.FromContext(ctx); ok {
+			kv := []string{
+				"x-forwarded-for", strings.Join(info.ForwardedFor, ", "),
+				"x-forwarded-proto", info.Proto,
+				"x-real-ip", info.RealIP,
+			}
+			if info.UserAgent != "" {
+				kv = append(kv, "x-user-agent", info.UserAgent)
+			}
+			ctx = metadata.AppendToOutgoingContext(ctx, kv...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Deadline bounds calls that have no deadline of their own.
+func Deadline(timeout time.Duration) grpc.UnaryClientInterceptor {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Retry re-invokes calls that fail with a retryable status code, with
+// exponential backoff, until the attempts or the context run out.
+func Retry(cfg RetryConfig) grpc.UnaryClientInterceptor {
+	if cfg.MaxAttempts <= 1 {
+		return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 100 * time.Millisecond
+	}
+
+	retryable := map[codes.Code]bool{}
+	if len(cfg.Codes) == 0 {
+		retryable[codes.Unavailable] = true
+	}
+	for _, name := range cfg.Codes {
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(`"` + strings.ToUpper(name) + `"`)); err != nil {
+			logger.Logger().Warn("Ignoring unknown retry code", zap.String("code", name))
+			continue
+		}
+		retryable[c] = true
+	}
+	methods := map[string]bool{}
+	for _, m := range cfg.Methods {
+		methods[m] = true
+	}
+
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if len(methods) > 0 && !methods[method] {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		delay := cfg.Backoff
+		var err error
+		for attempt := 1; ; attempt++ {
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || attempt >= cfg.MaxAttempts || !retryable[status.Code(err)] {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+			delay *= 2
+			retriesTotal.WithLabelValues(method).Inc()
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package interceptor_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/clientinfo"
+	"github.com/andro-kes/gateway/internal/interceptor"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/tracing"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// recordingServer remembers the metadata of the last call and can fail the first N calls
+type recordingServer struct {
+	pbInv.UnimplementedInventoryServiceServer
+	calls       atomic.Int32
+	failFirst   int32
+	md          metadata.MD
+	hasDeadline bool
+	delay       time.Duration
+}
+
+func (s *recordingServer) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	n := s.calls.Add(1)
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	_, s.hasDeadline = ctx.Deadline()
+	time.Sleep(s.delay)
+	if n <= s.failFirst {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return &pbInv.GetResponse{Product: &pbInv.Product{Id: in.Id}}, nil
+}
+
+func newClient(t *testing.T, srv *recordingServer, cfg interceptor.Config) pbInv.InventoryServiceClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := grpc.NewServer()
+	pbInv.RegisterInventoryServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		interceptor.DialOption(cfg),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pbInv.NewInventoryServiceClient(conn)
+}
+
+// TestChain_PropagatesAuthTraceAndDeadline tests the metadata and deadline added to every call
+func TestChain_PropagatesAuthTraceAndDeadline(t *testing.T) {
+	srv := &recordingServer{}
+	client := newClient(t, srv, interceptor.Config{})
+
+	parent := tracing.NewTrace()
+	ctx := tracing.WithSpanContext(context.Background(), parent)
+	ctx = interceptor.WithAuthorization(ctx, "Bearer user-token")
+
+	_, err := client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Bearer user-token"}, srv.md.Get("authorization"))
+	require.Len(t, srv.md.Get("traceparent"), 1)
+	sc, ok := tracing.Parse(srv.md.Get("traceparent")[0])
+	require.True(t, ok)
+	assert.Equal(t, parent.TraceID, sc.TraceID)
+	assert.NotEqual(t, parent.SpanID, sc.SpanID)
+	assert.True(t, srv.hasDeadline)
+}
+
+// TestRetry_RecoversFromUnavailable tests that unavailable backends are retried up to the limit
+func TestRetry_RecoversFromUnavailable(t *testing.T) {
+	srv := &recordingServer{failFirst: 2}
+	client := newClient(t, srv, interceptor.Config{
+		Retry: interceptor.RetryConfig{MaxAttempts: 3, Backoff: time.Millisecond},
+	})
+
+	resp, err := client.GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	assert.Equal(t, "p1", resp.Product.Id)
+	assert.Equal(t, int32(3), srv.calls.Load())
+}
+
+// TestRetry_GivesUp tests that retries stop after MaxAttempts
+func TestRetry_GivesUp(t *testing.T) {
+	srv := &recordingServer{failFirst: 5}
+	client := newClient(t, srv, interceptor.Config{
+		Retry: interceptor.RetryConfig{MaxAttempts: 2, Backoff: time.Millisecond},
+	})
+
+	_, err := client.GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, int32(2), srv.calls.Load())
+}
+
+// TestRetry_MethodAllowlist tests that methods outside the allowlist are not retried
+func TestRetry_MethodAllowlist(t *testing.T) {
+	srv := &recordingServer{failFirst: 1}
+	client := newClient(t, srv, interceptor.Config{
+		Retry: interceptor.RetryConfig{
+			MaxAttempts: 3,
+			Backoff:     time.Millisecond,
+			Methods:     []string{pbInv.InventoryService_ListProducts_FullMethodName},
+		},
+	})
+
+	_, err := client.GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, int32(1), srv.calls.Load())
+}
+
+// TestAuthMetadata_Identity tests that the caller identity is forwarded as metadata
+func TestAuthMetadata_Identity(t *testing.T) {
+	srv := &recordingServer{}
+	client := newClient(t, srv, interceptor.Config{})
+
+	exp := time.Unix(1700000000, 0)
+	ctx := interceptor.WithIdentity(context.Background(), interceptor.Identity{
+		UserID:    "user-1",
+		Roles:     []string{"admin", "support"},
+		ExpiresAt: exp,
+	})
+
+	_, err := client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"user-1"}, srv.md.Get("x-user-id"))
+	assert.Equal(t, []string{"admin,support"}, srv.md.Get("x-user-roles"))
+	assert.Equal(t, []string{"1700000000"}, srv.md.Get("x-token-exp"))
+	assert.Empty(t, srv.md.Get("authorization"))
+}
+
+type streamServer struct {
+	testpb.UnimplementedTestServiceServer
+	md metadata.MD
+}
+
+func (s *streamServer) StreamingOutputCall(in *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	s.md, _ = metadata.FromIncomingContext(stream.Context())
+	return nil
+}
+
+// TestStreamMetadata tests that streams carry the same metadata as unary calls
+func TestStreamMetadata(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &streamServer{}
+	s := grpc.NewServer()
+	testpb.RegisterTestServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		interceptor.StreamDialOption(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	ctx := interceptor.WithAuthorization(context.Background(), "Bearer user-token")
+	ctx = interceptor.WithIdentity(ctx, interceptor.Identity{UserID: "user-1"})
+	ctx = requestid.WithID(ctx, "req-1")
+	stream, err := testpb.NewTestServiceClient(conn).StreamingOutputCall(ctx, &testpb.StreamingOutputCallRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+
+	assert.Equal(t, []string{"Bearer user-token"}, srv.md.Get("authorization"))
+	assert.Equal(t, []string{"user-1"}, srv.md.Get("x-user-id"))
+	assert.Equal(t, []string{"req-1"}, srv.md.Get("x-request-id"))
+}
+
+// TestClientMetadata tests that HTTP client details are forwarded as metadata
+func TestClientMetadata(t *testing.T) {
+	srv := &recordingServer{}
+	client := newClient(t, srv, interceptor.Config{})
+
+	ctx := requestid.WithID(context.Background(), "req-1")
+	ctx = clientinfo.WithInfo(ctx, clientinfo.Info{
+		ForwardedFor: []string{"198.51.100.1", "10.0.0.2"},
+		Proto:        "https",
+		RealIP:       "198.51.100.1",
+		UserAgent:    "curl/8.0",
+	})
+
+	_, err := client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"198.51.100.1, 10.0.0.2"}, srv.md.Get("x-forwarded-for"))
+	assert.Equal(t, []string{"https"}, srv.md.Get("x-forwarded-proto"))
+	assert.Equal(t, []string{"198.51.100.1"}, srv.md.Get("x-real-ip"))
+	assert.Equal(t, []string{"curl/8.0"}, srv.md.Get("x-user-agent"))
+	assert.Equal(t, []string{"req-1"}, srv.md.Get("x-request-id"))
+}
+
+// TestPropagateHeaders tests that allowlisted request headers are forwarded as metadata
+func TestPropagateHeaders(t *testing.T) {
+	srv := &recordingServer{}
+	client := newClient(t, srv, interceptor.Config{})
+
+	handler := interceptor.PropagateHeaders([]string{"X-Tenant-ID", "Accept-Language"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := client.GetProduct(r.Context(), &pbInv.GetRequest{Id: "p1"})
+		require.NoError(t, err)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Tenant-ID", "acme")
+	r.Header.Add("Accept-Language", "de")
+	r.Header.Add("Accept-Language", "en;q=0.5")
+	r.Header.Set("X-Other", "dropped")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, []string{"acme"}, srv.md.Get("x-tenant-id"))
+	assert.Equal(t, []string{"de", "en;q=0.5"}, srv.md.Get("accept-language"))
+	assert.Empty(t, srv.md.Get("x-other"))
+
+	assert.NoError(t, interceptor.CheckHeaders([]string{"X-Tenant-ID", "Accept-Language"}))
+	for _, name := range []string{"X-User-ID", "Authorization", "traceparent", "grpc-timeout", "X-Data-Bin", "X-Gateway-Authorization"} {
+		assert.Error(t, interceptor.CheckHeaders([]string{name}), name)
+	}
+}
+
+// captureLogs redirects the logger to a file at the given level and returns a function reading it
+func captureLogs(t *testing.T, level string) func() string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	require.NoError(t, logger.Init(logger.Config{Level: level, OutputPaths: []string{path}}))
+	t.Cleanup(func() { logger.Init(logger.Config{Level: "info"}) })
+	return func() string {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(data)
+	}
+}
+
+// TestLogging_SlowCall tests that calls above the threshold are logged with their request ID
+func TestLogging_SlowCall(t *testing.T) {
+	logs := captureLogs(t, "info")
+	srv := &recordingServer{delay: 50 * time.Millisecond}
+	client := newClient(t, srv, interceptor.Config{SlowCallThreshold: 10 * time.Millisecond})
+
+	ctx := requestid.WithID(context.Background(), "req-slow")
+	_, err := client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+
+	out := logs()
+	assert.Contains(t, out, `"msg":"Slow backend call"`)
+	assert.Contains(t, out, `"request_id":"req-slow"`)
+	assert.Contains(t, out, `"method":"`+pbInv.InventoryService_GetProduct_FullMethodName+`"`)
+	assert.Contains(t, out, `"backend":"127.0.0.1:`)
+	assert.Contains(t, out, `"code":"OK"`)
+}
+
+// TestLogging_Debug tests that every call is logged at debug level
+func TestLogging_Debug(t *testing.T) {
+	logs := captureLogs(t, "debug")
+	srv := &recordingServer{}
+	client := newClient(t, srv, interceptor.Config{})
+
+	ctx := requestid.WithID(context.Background(), "req-fast")
+	_, err := client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+
+	out := logs()
+	assert.Contains(t, out, `"msg":"Backend call"`)
+	assert.Contains(t, out, `"request_id":"req-fast"`)
+	assert.NotContains(t, out, "Slow backend call")
+}
+
+// TestCoalesce_SharesIdenticalCalls tests that identical concurrent calls share one backend call
+func TestCoalesce_SharesIdenticalCalls(t *testing.T) {
+	srv := &recordingServer{delay: 50 * time.Millisecond}
+	client := newClient(t, srv, interceptor.Config{})
+
+	const callers = 5
+	var wg sync.WaitGroup
+	ids := make([]string, callers)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := requestid.WithID(context.Background(), "req-"+strconv.Itoa(i))
+			resp, err := client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+			if assert.NoError(t, err) {
+				ids[i] = resp.Product.Id
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), srv.calls.Load())
+	for _, id := range ids {
+		assert.Equal(t, "p1", id)
+	}
+
+	_, err := client.GetProduct(context.Background(), &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), srv.calls.Load(), "finished calls are not shared")
+}
@@ -0,0 +1,63 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// CheckMetadata returns an error if md cannot be sent as static metadata:
+// a key that CheckHeaders rejects, a key that is not lowercase letters,
+// digits, '-', '_' and '.', or a value that is not printable ASCII.
+func CheckMetadata(md map[string]string) error {
+	for key, value := range md {
+		if err := CheckHeaders([]string{key}); err != nil {
+			return fmt.Errorf("metadata %q: %w", key, err)
+		}
+		for _, c := range key {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				return fmt.Errorf("metadata key %q must be lowercase letters, digits, '-', '_' and '.'", key)
+			}
+		}
+		for _, c := range value {
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("metadata %q: value must be printable ASCII", key)
+			}
+		}
+	}
+	return nil
+}
+
+// StaticMetadata returns the dial options attaching md to every unary call
+// and stream of a connection, or nil when md is empty. md holds defaults:
+// keys the call already carries, e.g. from propagated headers, are kept.
+// Apply them after DialOption, so that they see the metadata of the chain.
+func StaticMetadata(md map[string]string) []grpc.DialOption {
+	if len(md) == 0 {
+		return nil
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(withDefaults(ctx, md), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(withDefaults(ctx, md), desc, cc, method, opts...)
+		}),
+	}
+}
+
+func withDefaults(ctx context.Context, md map[string]string) context.Context {
+	out, _ := metadata.FromOutgoingContext(ctx)
+	var kv []string
+	for key, value := range md {
+		if len(out.Get(key)) == 0 {
+			kv = append(kv, key, value)
+		}
+	}
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
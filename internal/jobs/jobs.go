@@ -0,0 +1,452 @@
+// Package jobs runs long operations, such as CSV imports and exports, in the
+// background so that requests can answer 202 with a job ID right away
+// instead of holding the connection open for minutes. Clients then poll the
+// job for its progress and result. Job state is kept in memory, or in Redis
+// so that any gateway instance can report on it.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Job states.
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// ErrQueueFull is returned by Submit when the queue has no room left.
+var ErrQueueFull = errors.New("job queue full")
+
+// ErrClosed is returned by Submit after Close.
+var ErrClosed = errors.New("job runner closed")
+
+// ErrNotFound is returned for unknown or expired jobs.
+var ErrNotFound = errors.New("job not found")
+
+// Config configures the job runner.
+type Config struct {
+	// Workers is the number of jobs run concurrently. Default: 2.
+	Workers int `yaml:"workers"`
+
+	// QueueSize bounds the jobs waiting for a worker; Submit fails with
+	// ErrQueueFull beyond it. Default: 100.
+	QueueSize int `yaml:"queue_size"`
+
+	// Timeout bounds the run time of a job. Default: 30m.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// TTL is how long finished jobs and their results are kept. Default: 24h.
+	TTL time.Duration `yaml:"ttl"`
+
+	// Heartbeat is how often the state of unfinished jobs is saved. A job
+	// whose state is not saved for three heartbeats, because the instance
+	// running it died, is reported as failed. Default: 5s.
+	Heartbeat time.Duration `yaml:"heartbeat"`
+
+	// Redis stores job state in Redis instead of memory when its address is set.
+	Redis RedisConfig `yaml:"redis"`
+}
+
+// Job is the state of a background job.
+type Job struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Owner      string          `json:"owner,omitempty"`
+	State      string          `json:"state"`
+	Progress   Progress        `json:"progress"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Output     *Output         `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  time.Time       `json:"started_at,omitzero"`
+	FinishedAt time.Time       `json:"finished_at,omitzero"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// Finished reports whether the job succeeded or failed.
+func (j *Job) Finished() bool {
+	return j.State == StateSucceeded || j.State == StateFailed
+}
+
+// Progress counts the units of work of a job, such as CSV rows. Total is 0
+// while unknown.
+type Progress struct {
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+}
+
+// Output is a file produced by a job, such as a CSV export. A Func returns
+// an *Output to have it stored for download instead of encoded as Result.
+type Output struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// Func is the work of a job. It reports progress through t and returns the
+// job result, which is encoded as JSON unless it is an *Output. Func must
+// return when ctx is done.
+type Func func(ctx context.Context, t *Tracker) (any, error)
+
+// Store persists job state.
+type Store interface {
+	// Save stores job, replacing an earlier state. Jobs expire ttl after
+	// their last save.
+	Save(ctx context.Context, job *Job, ttl time.Duration) error
+
+	// Load returns the last saved state of a job, or ErrNotFound.
+	Load(ctx context.Context, id string) (*Job, error)
+
+	Close() error
+}
+
+var (
+	jobsTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "jobs",
+		Name:      "total",
+		Help:      "Jobs by kind and result (queued, rejected, succeeded, failed).",
+	}, []string{"kind", "result"})
+
+	jobDuration = promauto.With(metrics.Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "jobs",
+		Name:      "duration_seconds",
+		Help:      "Run time of jobs by kind.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 15, 60, 300, 900, 1800},
+	}, []string{"kind"})
+)
+
+// storeTimeout bounds each store call.
+const storeTimeout = 5 * time.Second
+
+// Tracker reports the progress of a running job.
+type Tracker struct {
+	r   *Runner
+	job *Job
+}
+
+// SetTotal sets the total units of work, once known.
+func (t *Tracker) SetTotal(n int64) {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	t.job.Progress.Total = n
+}
+
+// Add records n more units of work done.
+func (t *Tracker) Add(n int64) {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	t.job.Progress.Done += n
+}
+
+type task struct {
+	ctx context.Context
+	job *Job
+	fn  Func
+}
+
+// Runner queues jobs and runs them on a bounded pool of workers.
+type Runner struct {
+	cfg   Config
+	store Store
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan task
+	// active holds the current state of queued and running jobs, which is
+	// saved on every heartbeat
+	active map[string]*Job
+
+	// base is canceled on Close to stop running jobs
+	base   context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// saveMu orders saves, so that a stale snapshot of a job never
+	// overwrites its final state
+	saveMu sync.Mutex
+}
+
+// New returns a Runner storing job state in Redis when configured, in memory
+// otherwise.
+func New(cfg Config) (*Runner, error) {
+	var store Store = NewMemoryStore()
+	if cfg.Redis.Addr != "" {
+		var err error
+		if store, err = NewRedisStore(cfg.Redis); err != nil {
+			return nil, err
+		}
+	}
+	return NewWithStore(cfg, store), nil
+}
+
+// NewWithStore starts the workers of a Runner saving job state to store.
+func NewWithStore(cfg Config, store Store) *Runner {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 2
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Minute
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = 5 * time.Second
+	}
+
+	r := &Runner{
+		cfg:    cfg,
+		store:  store,
+		queue:  make(chan task, cfg.QueueSize),
+		active: map[string]*Job{},
+	}
+	r.base, r.cancel = context.WithCancel(context.Background())
+	for range cfg.Workers {
+		r.wg.Add(1)
+		go r.work()
+	}
+	go r.heartbeat()
+	return r
+}
+
+// Submit queues fn as a job of the given kind, such as "products.import",
+// owned by the caller's user ID from ctx. fn runs with the values of ctx
+// but outlives the request. The returned job is a snapshot of the queued
+// state.
+func (r *Runner) Submit(ctx context.Context, kind string, fn Func) (*Job, error) {
+	now := time.Now().UTC()
+	job := &Job{
+		ID:        requestid.New(),
+		Kind:      kind,
+		State:     StateQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if claims, ok := token.FromContext(ctx); ok {
+		job.Owner = claims.UserID
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrClosed
+	}
+	select {
+	case r.queue <- task{ctx: context.WithoutCancel(ctx), job: job, fn: fn}:
+	default:
+		r.mu.Unlock()
+		jobsTotal.WithLabelValues(kind, "rejected").Inc()
+		return nil, ErrQueueFull
+	}
+	r.active[job.ID] = job
+	snapshot := *job
+	r.mu.Unlock()
+
+	jobsTotal.WithLabelValues(kind, "queued").Inc()
+	r.save(&snapshot)
+	return &snapshot, nil
+}
+
+// Get returns the current state of a job, or ErrNotFound. Unfinished jobs
+// not saved for three heartbeats are reported as failed.
+func (r *Runner) Get(ctx context.Context, id string) (*Job, error) {
+	r.mu.RLock()
+	if job, ok := r.active[id]; ok {
+		snapshot := *job
+		r.mu.RUnlock()
+		return &snapshot, nil
+	}
+	r.mu.RUnlock()
+
+	job, err := r.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !job.Finished() && time.Since(job.UpdatedAt) > 3*r.cfg.Heartbeat {
+		job.State = StateFailed
+		job.Error = "job was interrupted"
+	}
+	return job, nil
+}
+
+// Close stops accepting jobs, cancels the running ones and fails the queued
+// ones, waiting until their final state is saved or ctx is done.
+func (r *Runner) Close(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+		r.cancel()
+	}
+	r.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(finished)
+	}()
+	var err error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if cerr := r.store.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *Runner) work() {
+	defer r.wg.Done()
+	for t := range r.queue {
+		r.run(t)
+	}
+}
+
+func (r *Runner) run(t task) {
+	kind := t.job.Kind
+	if r.base.Err() != nil {
+		r.finish(t.job, nil, errors.New("gateway shut down before the job started"))
+		return
+	}
+
+	r.mu.Lock()
+	t.job.State = StateRunning
+	t.job.StartedAt = time.Now().UTC()
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(t.ctx, r.cfg.Timeout)
+	defer cancel()
+	stop := context.AfterFunc(r.base, cancel)
+	defer stop()
+
+	start := time.Now()
+	result, err := r.call(ctx, t)
+	jobDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.Canceled) && r.base.Err() != nil {
+		err = errors.New("gateway shut down while the job was running")
+	}
+	r.finish(t.job, result, err)
+}
+
+// call runs the job function, turning a panic into a failure.
+func (r *Runner) call(ctx context.Context, t task) (result any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Logger().Error("Job panicked", zap.String("job_id", t.job.ID), zap.String("kind", t.job.Kind), zap.Any("panic", p))
+			err = errors.New("internal error")
+		}
+	}()
+	return t.fn(ctx, &Tracker{r: r, job: t.job})
+}
+
+func (r *Runner) finish(job *Job, result any, err error) {
+	if err == nil {
+		switch out := result.(type) {
+		case nil:
+		case *Output:
+			job.Output = out
+		default:
+			job.Result, err = json.Marshal(result)
+			if err != nil {
+				err = fmt.Errorf("failed to encode result: %w", err)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	job.State = StateSucceeded
+	if err != nil {
+		job.State = StateFailed
+		job.Error = err.Error()
+		job.Output = nil
+		job.Result = nil
+	}
+	job.FinishedAt = time.Now().UTC()
+	snapshot := *job
+	delete(r.active, job.ID)
+	r.mu.Unlock()
+
+	jobsTotal.WithLabelValues(job.Kind, snapshot.State).Inc()
+	if err != nil {
+		logger.Logger().Warn("Job failed", zap.String("job_id", job.ID), zap.String("kind", job.Kind), zap.Error(err))
+	}
+	r.save(&snapshot)
+}
+
+// heartbeat periodically saves the state of unfinished jobs, so that other
+// instances see their progress and can tell them from abandoned ones.
+func (r *Runner) heartbeat() {
+	ticker := time.NewTicker(r.cfg.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.base.Done():
+			return
+		}
+		r.mu.RLock()
+		snapshots := make([]Job, 0, len(r.active))
+		for _, job := range r.active {
+			snapshots = append(snapshots, *job)
+		}
+		r.mu.RUnlock()
+		for i := range snapshots {
+			r.save(&snapshots[i])
+		}
+	}
+}
+
+// save stores a snapshot of a job. Snapshots of unfinished jobs are skipped
+// once the job has finished, and kept long enough for it to finish.
+func (r *Runner) save(job *Job) {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+	if !job.Finished() {
+		r.mu.RLock()
+		_, active := r.active[job.ID]
+		r.mu.RUnlock()
+		if !active {
+			return
+		}
+	}
+
+	job.UpdatedAt = time.Now().UTC()
+	ttl := r.cfg.TTL
+	if !job.Finished() {
+		ttl += r.cfg.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := r.store.Save(ctx, job, ttl); err != nil {
+		logger.Logger().Warn("Failed to save job state", zap.String("job_id", job.ID), zap.Error(err))
+	}
+}
@@ -0,0 +1,196 @@
+package jobs_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/jobs"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitFinished(t *testing.T, r *jobs.Runner, id string) *jobs.Job {
+	t.Helper()
+	var job *jobs.Job
+	require.Eventually(t, func() bool {
+		var err error
+		job, err = r.Get(context.Background(), id)
+		require.NoError(t, err)
+		return job.Finished()
+	}, time.Second, 5*time.Millisecond)
+	return job
+}
+
+// TestRunner_Result tests progress reporting and the stored result
+func TestRunner_Result(t *testing.T) {
+	r := jobs.NewWithStore(jobs.Config{}, jobs.NewMemoryStore())
+	defer r.Close(context.Background())
+
+	release := make(chan struct{})
+	ctx := token.WithClaims(context.Background(), &token.Claims{UserID: "user-1"})
+	job, err := r.Submit(ctx, "count", func(ctx context.Context, tr *jobs.Tracker) (any, error) {
+		tr.SetTotal(3)
+		tr.Add(2)
+		<-release
+		tr.Add(1)
+		return map[string]int{"counted": 3}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StateQueued, job.State)
+	assert.Equal(t, "user-1", job.Owner)
+	assert.Len(t, job.ID, 32)
+
+	require.Eventually(t, func() bool {
+		running, err := r.Get(context.Background(), job.ID)
+		require.NoError(t, err)
+		return running.State == jobs.StateRunning && running.Progress == jobs.Progress{Done: 2, Total: 3}
+	}, time.Second, 5*time.Millisecond)
+	close(release)
+
+	done := waitFinished(t, r, job.ID)
+	assert.Equal(t, jobs.StateSucceeded, done.State)
+	assert.Equal(t, jobs.Progress{Done: 3, Total: 3}, done.Progress)
+	assert.JSONEq(t, `{"counted":3}`, string(done.Result))
+	assert.False(t, done.FinishedAt.IsZero())
+
+	_, err = r.Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, jobs.ErrNotFound)
+}
+
+// TestRunner_Failure tests failed and panicking jobs and file outputs
+func TestRunner_Failure(t *testing.T) {
+	r := jobs.NewWithStore(jobs.Config{}, jobs.NewMemoryStore())
+	defer r.Close(context.Background())
+
+	failed, err := r.Submit(context.Background(), "fail", func(ctx context.Context, tr *jobs.Tracker) (any, error) {
+		return nil, errors.New("backend down")
+	})
+	require.NoError(t, err)
+	panicked, err := r.Submit(context.Background(), "panic", func(ctx context.Context, tr *jobs.Tracker) (any, error) {
+		panic("boom")
+	})
+	require.NoError(t, err)
+	file, err := r.Submit(context.Background(), "file", func(ctx context.Context, tr *jobs.Tracker) (any, error) {
+		return &jobs.Output{Name: "a.csv", ContentType: "text/csv", Data: []byte("id\n")}, nil
+	})
+	require.NoError(t, err)
+
+	job := waitFinished(t, r, failed.ID)
+	assert.Equal(t, jobs.StateFailed, job.State)
+	assert.Equal(t, "backend down", job.Error)
+
+	job = waitFinished(t, r, panicked.ID)
+	assert.Equal(t, jobs.StateFailed, job.State)
+	assert.Equal(t, "internal error", job.Error)
+
+	job = waitFinished(t, r, file.ID)
+	assert.Equal(t, jobs.StateSucceeded, job.State)
+	require.NotNil(t, job.Output)
+	assert.Equal(t, "id\n", string(job.Output.Data))
+	assert.Nil(t, job.Result)
+}
+
+// TestRunner_QueueFull tests that Submit fails instead of blocking
+func TestRunner_QueueFull(t *testing.T) {
+	r := jobs.NewWithStore(jobs.Config{Workers: 1, QueueSize: 1}, jobs.NewMemoryStore())
+	release := make(chan struct{})
+	block := func(ctx context.Context, tr *jobs.Tracker) (any, error) {
+		<-release
+		return nil, nil
+	}
+
+	running, err := r.Submit(context.Background(), "block", block)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, _ := r.Get(context.Background(), running.ID)
+		return job.State == jobs.StateRunning
+	}, time.Second, 5*time.Millisecond)
+	queued, err := r.Submit(context.Background(), "block", block)
+	require.NoError(t, err)
+	_, err = r.Submit(context.Background(), "block", block)
+	assert.ErrorIs(t, err, jobs.ErrQueueFull)
+
+	close(release)
+	require.NoError(t, r.Close(context.Background()))
+	job, err := r.Get(context.Background(), queued.ID)
+	require.NoError(t, err)
+	assert.True(t, job.Finished())
+	_, err = r.Submit(context.Background(), "block", block)
+	assert.ErrorIs(t, err, jobs.ErrClosed)
+}
+
+// TestRunner_Close tests that Close cancels running jobs and records why
+func TestRunner_Close(t *testing.T) {
+	store := jobs.NewMemoryStore()
+	r := jobs.NewWithStore(jobs.Config{Workers: 1}, store)
+	started := make(chan struct{})
+	job, err := r.Submit(context.Background(), "wait", func(ctx context.Context, tr *jobs.Tracker) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	require.NoError(t, err)
+	<-started
+
+	require.NoError(t, r.Close(context.Background()))
+	saved, err := store.Load(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StateFailed, saved.State)
+	assert.Equal(t, "gateway shut down while the job was running", saved.Error)
+}
+
+// TestRunner_Interrupted tests that jobs abandoned by another instance are reported as failed
+func TestRunner_Interrupted(t *testing.T) {
+	store := jobs.NewMemoryStore()
+	stale := &jobs.Job{ID: "stale", Kind: "import", State: jobs.StateRunning, UpdatedAt: time.Now().Add(-time.Minute)}
+	require.NoError(t, store.Save(context.Background(), stale, time.Hour))
+
+	r := jobs.NewWithStore(jobs.Config{Heartbeat: time.Second}, store)
+	defer r.Close(context.Background())
+	job, err := r.Get(context.Background(), "stale")
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StateFailed, job.State)
+	assert.Equal(t, "job was interrupted", job.Error)
+}
+
+// TestRunner_Heartbeat tests that running jobs are saved periodically
+func TestRunner_Heartbeat(t *testing.T) {
+	store := jobs.NewMemoryStore()
+	r := jobs.NewWithStore(jobs.Config{Heartbeat: 10 * time.Millisecond}, store)
+	defer r.Close(context.Background())
+
+	release := make(chan struct{})
+	defer close(release)
+	job, err := r.Submit(context.Background(), "wait", func(ctx context.Context, tr *jobs.Tracker) (any, error) {
+		tr.Add(5)
+		<-release
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		saved, err := store.Load(context.Background(), job.ID)
+		return err == nil && saved.State == jobs.StateRunning && saved.Progress.Done == 5
+	}, time.Second, 5*time.Millisecond)
+}
+
+// TestMemoryStore_Expiry tests that jobs expire after their TTL
+func TestMemoryStore_Expiry(t *testing.T) {
+	store := jobs.NewMemoryStore()
+	require.NoError(t, store.Save(context.Background(), &jobs.Job{ID: "a"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	_, err := store.Load(context.Background(), "a")
+	assert.ErrorIs(t, err, jobs.ErrNotFound)
+
+	// the stored job is a copy
+	job := &jobs.Job{ID: "b", Result: json.RawMessage(`1`)}
+	require.NoError(t, store.Save(context.Background(), job, time.Hour))
+	job.State = jobs.StateFailed
+	loaded, err := store.Load(context.Background(), "b")
+	require.NoError(t, err)
+	assert.Empty(t, loaded.State)
+}
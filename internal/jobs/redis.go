@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfig configures the Redis job store.
+type RedisConfig struct {
+	// Addr is the host:port of the Redis server.
+	Addr string `yaml:"addr"`
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+
+	// Prefix is prepended to job IDs to form keys. Default: "gateway:jobs:".
+	Prefix string `yaml:"prefix"`
+}
+
+// RedisStore keeps job state in Redis, one JSON value per job, so that jobs
+// survive restarts and can be polled through any gateway instance.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects to Redis and checks that it answers.
+func NewRedisStore(cfg RedisConfig) (*RedisStore, error) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "gateway:jobs:"
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("jobs: failed to connect to Redis: %w", err)
+	}
+	return &RedisStore{client: client, prefix: cfg.Prefix}, nil
+}
+
+func (s *RedisStore) Save(ctx context.Context, job *Job, ttl time.Duration) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+job.ID, data, ttl).Err()
+}
+
+func (s *RedisStore) Load(ctx context.Context, id string) (*Job, error) {
+	data, err := s.client.Get(ctx, s.prefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	job     Job
+	expires time.Time
+}
+
+// MemoryStore keeps job state in process memory. Jobs are lost on restart
+// and only visible to the instance running them.
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]entry
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: map[string]entry{}}
+}
+
+// Save stores a copy of job and drops expired jobs.
+func (s *MemoryStore) Save(_ context.Context, job *Job, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, e := range s.jobs {
+		if now.After(e.expires) {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = entry{job: *job, expires: now.Add(ttl)}
+	return nil
+}
+
+// Load returns a copy of the job with the given ID.
+func (s *MemoryStore) Load(_ context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.jobs[id]
+	if !ok || time.Now().After(e.expires) {
+		return nil, ErrNotFound
+	}
+	job := e.job
+	return &job, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,118 @@
+// Package journald sends log entries to the systemd journal using its native
+// protocol, so fields stay individually queryable with journalctl.
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultSocket is the journal's native protocol socket.
+const DefaultSocket = "/run/systemd/journal/socket"
+
+// Config configures the journal destination.
+type Config struct {
+	// Socket is the journal socket path. Default: DefaultSocket.
+	Socket string `yaml:"socket"`
+
+	// Identifier is sent as SYSLOG_IDENTIFIER. Default: "gateway".
+	Identifier string `yaml:"identifier"`
+}
+
+// Writer sends entries to the journal. It is safe for concurrent use.
+type Writer struct {
+	identifier string
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+// New connects to the journal socket.
+func New(cfg Config) (*Writer, error) {
+	if cfg.Socket == "" {
+		cfg.Socket = DefaultSocket
+	}
+	if cfg.Identifier == "" {
+		cfg.Identifier = "gateway"
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: cfg.Socket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	return &Writer{identifier: cfg.Identifier, conn: conn}, nil
+}
+
+// Send sends one entry with the given syslog priority (0-7). Field names are
+// mapped to journal field names (see FieldName); MESSAGE, PRIORITY and
+// SYSLOG_IDENTIFIER are set by Send.
+func (w *Writer) Send(priority int, msg string, fields map[string]string) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	writeField(&buf, "MESSAGE", msg)
+	writeField(&buf, "PRIORITY", fmt.Sprint(priority))
+	writeField(&buf, "SYSLOG_IDENTIFIER", w.identifier)
+	for _, name := range names {
+		writeField(&buf, FieldName(name), fields[name])
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.conn.Write(buf.Bytes())
+	return err
+}
+
+// writeField appends one field. Values containing newlines use the binary
+// form: name, newline, little-endian 64-bit length, value.
+func writeField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if strings.ContainsRune(value, '\n') {
+		buf.WriteByte('\n')
+		binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	} else {
+		buf.WriteByte('=')
+	}
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// FieldName maps a log field key to a valid journal field name: upper case
+// letters, digits and underscores, not starting with a digit or underscore
+// (those are reserved for trusted fields), at most 64 bytes. For example
+// "trace_id" becomes TRACE_ID and "http.status" becomes HTTP_STATUS.
+func FieldName(key string) string {
+	b := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			b = append(b, c-'a'+'A')
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			b = append(b, c)
+		default:
+			b = append(b, '_')
+		}
+	}
+	b = bytes.TrimLeft(b, "_")
+	if len(b) == 0 || (b[0] >= '0' && b[0] <= '9') {
+		b = append([]byte("F_"), b...)
+	}
+	if len(b) > 64 {
+		b = b[:64]
+	}
+	return string(b)
+}
+
+// Close closes the socket.
+func (w *Writer) Close() error {
+	return w.conn.Close()
+}
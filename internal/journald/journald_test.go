@@ -0,0 +1,55 @@
+package journald_test
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/journald"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWriter_Send tests the native protocol encoding of an entry
+func TestWriter_Send(t *testing.T) {
+	dir, err := os.MkdirTemp("", "journald")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	w, err := journald.New(journald.Config{Socket: socket})
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, w.Send(4, "slow call", map[string]string{
+		"trace_id": "abc",
+		"stack":    "line1\nline2",
+	}))
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len("line1\nline2")))
+	want := "MESSAGE=slow call\nPRIORITY=4\nSYSLOG_IDENTIFIER=gateway\n" +
+		"STACK\n" + string(size) + "line1\nline2\n" +
+		"TRACE_ID=abc\n"
+	assert.Equal(t, want, string(buf[:n]))
+}
+
+// TestFieldName tests the mapping of log field keys to journal field names
+func TestFieldName(t *testing.T) {
+	assert.Equal(t, "TRACE_ID", journald.FieldName("trace_id"))
+	assert.Equal(t, "HTTP_STATUS", journald.FieldName("http.status"))
+	assert.Equal(t, "PID", journald.FieldName("_PID"))
+	assert.Equal(t, "F_2XX", journald.FieldName("2xx"))
+	assert.Len(t, journald.FieldName(strings.Repeat("a", 100)), 64)
+}
@@ -0,0 +1,106 @@
+// Package lifecycle runs the functions modules register for the stages of
+// the gateway's life: start, before traffic is served; ready, once it is;
+// drain, when shutdown begins and in-flight requests still complete; and
+// stop, after the listeners are closed.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Stage is a stage of the gateway's life.
+type Stage string
+
+// Stages, in the order they run.
+const (
+	// Start hooks run before the listeners serve. The first failing one
+	// aborts startup. Their context lasts until the stop stage, so they
+	// can start background work, e.g. watchers, bound to it.
+	Start Stage = "start"
+	// Ready hooks run once the listeners serve. Failures are logged.
+	Ready Stage = "ready"
+	// Drain hooks run when shutdown begins, before the listeners close.
+	// Failures are logged.
+	Drain Stage = "drain"
+	// Stop hooks run after the listeners closed and in-flight requests
+	// completed, in reverse registration order. Failures are logged.
+	Stop Stage = "stop"
+)
+
+// Hook is a function run at a stage. Its context carries the deadline of
+// the stage, if any.
+type Hook func(ctx context.Context) error
+
+type hook struct {
+	name string
+	fn   Hook
+}
+
+// Hooks is a registry of hooks per stage. The zero value is ready to use.
+type Hooks struct {
+	mu      sync.Mutex
+	hooks   map[Stage][]hook
+	life    context.Context
+	cancel  context.CancelFunc
+	stopped bool
+}
+
+// Register adds fn to the hooks of stage under name, which identifies it in
+// logs and errors.
+func (h *Hooks) Register(stage Stage, name string, fn Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hooks == nil {
+		h.hooks = map[Stage][]hook{}
+	}
+	h.hooks[stage] = append(h.hooks[stage], hook{name: name, fn: fn})
+}
+
+// Run runs the hooks of stage with ctx. Start hooks run with a context that
+// is canceled when the stop stage begins instead, and Run returns the error
+// of the first one that fails; other stages run every hook and log failures.
+// The stop stage runs at most once.
+func (h *Hooks) Run(ctx context.Context, stage Stage) error {
+	h.mu.Lock()
+	hooks := slices.Clone(h.hooks[stage])
+	switch stage {
+	case Start:
+		if h.life == nil {
+			h.life, h.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		}
+		ctx = h.life
+	case Stop:
+		if h.stopped {
+			h.mu.Unlock()
+			return nil
+		}
+		h.stopped = true
+		if h.cancel != nil {
+			h.cancel()
+		}
+		slices.Reverse(hooks)
+	}
+	h.mu.Unlock()
+
+	for _, hk := range hooks {
+		err := hk.fn(ctx)
+		if err == nil {
+			continue
+		}
+		if stage == Start {
+			return fmt.Errorf("%s hook %s: %w", stage, hk.name, err)
+		}
+		logger.Logger().Warn("Lifecycle hook failed",
+			zap.String("stage", string(stage)),
+			zap.String("hook", hk.name),
+			zap.Error(err),
+		)
+	}
+	return nil
+}
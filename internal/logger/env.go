@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andro-kes/gateway/internal/journald"
+	"github.com/andro-kes/gateway/internal/syslog"
+)
+
+// ConfigFromEnv builds a Config from environment variables:
+//
+//	LOG_LEVEL        debug, info, warn, error (default info)
+//	LOG_FORMAT       json or console (default json)
+//	LOG_DEVELOPMENT  development mode (bool)
+//	LOG_STDOUT       write to stdout (bool, default true)
+//	LOG_OUTPUTS      comma-separated extra outputs: stderr or file paths
+//	LOG_FILE         rotated log file
+//	LOG_ROTATE       rotate LOG_FILE (bool, default true)
+//	LOG_MAX_SIZE     megabytes before rotation (default 100)
+//	LOG_MAX_BACKUPS  rotated files kept (default 7)
+//	LOG_MAX_AGE      days rotated files are kept (default 30)
+//	LOG_COMPRESS     gzip rotated files (bool)
+//	LOG_SYSLOG       syslog server: udp://host:514, tcp://host:514,
+//	                 unixgram:///dev/log, or true for the local /dev/log
+//	LOG_SYSLOG_FACILITY  syslog facility (default local0)
+//	LOG_JOURNALD     send logs to the systemd journal (bool)
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Level:        os.Getenv("LOG_LEVEL"),
+		Encoding:     os.Getenv("LOG_FORMAT"),
+		Filename:     os.Getenv("LOG_FILE"),
+		FileRotation: true,
+	}
+	if _, err := parseLevel(cfg.Level); err != nil {
+		return cfg, err
+	}
+	switch strings.ToLower(cfg.Encoding) {
+	case "", "json", "console":
+	default:
+		return cfg, fmt.Errorf("unknown LOG_FORMAT: %s", cfg.Encoding)
+	}
+	if v := os.Getenv("LOG_OUTPUTS"); v != "" {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				cfg.OutputPaths = append(cfg.OutputPaths, p)
+			}
+		}
+	}
+
+	stdout := true
+	journal := false
+	for _, b := range []struct {
+		name string
+		dst  *bool
+	}{
+		{"LOG_DEVELOPMENT", &cfg.Development},
+		{"LOG_STDOUT", &stdout},
+		{"LOG_ROTATE", &cfg.FileRotation},
+		{"LOG_COMPRESS", &cfg.Compress},
+		{"LOG_JOURNALD", &journal},
+	} {
+		if v := os.Getenv(b.name); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", b.name, err)
+			}
+			*b.dst = parsed
+		}
+	}
+	cfg.DisableStdout = !stdout
+	if journal {
+		cfg.Journald = &journald.Config{}
+	}
+
+	for _, n := range []struct {
+		name string
+		dst  *int
+	}{
+		{"LOG_MAX_SIZE", &cfg.MaxSize},
+		{"LOG_MAX_BACKUPS", &cfg.MaxBackups},
+		{"LOG_MAX_AGE", &cfg.MaxAge},
+	} {
+		if v := os.Getenv(n.name); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 0 {
+				return cfg, fmt.Errorf("invalid %s: %q", n.name, v)
+			}
+			*n.dst = parsed
+		}
+	}
+
+	if v := os.Getenv("LOG_SYSLOG"); v != "" {
+		sc := &syslog.Config{Facility: os.Getenv("LOG_SYSLOG_FACILITY")}
+		if network, address, ok := strings.Cut(v, "://"); ok {
+			sc.Network, sc.Address = network, address
+		} else if enabled, err := strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid LOG_SYSLOG: %q", v)
+		} else if !enabled {
+			sc = nil
+		}
+		cfg.Syslog = sc
+	}
+
+	return cfg, nil
+}
+
+// InitFromEnv initializes the package logger from environment variables
+// (see ConfigFromEnv).
+func InitFromEnv() error {
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	return Init(cfg)
+}
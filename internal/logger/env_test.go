@@ -0,0 +1,71 @@
+package logger_test
+
+import (
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfigFromEnv tests that environment variables are mapped to the logger config
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("LOG_FORMAT", "console")
+	t.Setenv("LOG_FILE", "/var/log/gateway/gateway.log")
+	t.Setenv("LOG_MAX_SIZE", "50")
+	t.Setenv("LOG_COMPRESS", "true")
+	t.Setenv("LOG_STDOUT", "false")
+	t.Setenv("LOG_OUTPUTS", "stderr, /tmp/extra.log")
+	t.Setenv("LOG_SYSLOG", "tcp://logs.internal:514")
+	t.Setenv("LOG_SYSLOG_FACILITY", "daemon")
+	t.Setenv("LOG_JOURNALD", "1")
+
+	cfg, err := logger.ConfigFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "debug", cfg.Level)
+	assert.Equal(t, "console", cfg.Encoding)
+	assert.Equal(t, "/var/log/gateway/gateway.log", cfg.Filename)
+	assert.True(t, cfg.FileRotation)
+	assert.Equal(t, 50, cfg.MaxSize)
+	assert.True(t, cfg.Compress)
+	assert.True(t, cfg.DisableStdout)
+	assert.Equal(t, []string{"stderr", "/tmp/extra.log"}, cfg.OutputPaths)
+	require.NotNil(t, cfg.Syslog)
+	assert.Equal(t, "tcp", cfg.Syslog.Network)
+	assert.Equal(t, "logs.internal:514", cfg.Syslog.Address)
+	assert.Equal(t, "daemon", cfg.Syslog.Facility)
+	assert.NotNil(t, cfg.Journald)
+}
+
+// TestConfigFromEnv_Defaults tests the config without any variables set
+func TestConfigFromEnv_Defaults(t *testing.T) {
+	cfg, err := logger.ConfigFromEnv()
+	require.NoError(t, err)
+	assert.False(t, cfg.DisableStdout)
+	assert.Nil(t, cfg.Syslog)
+	assert.Nil(t, cfg.Journald)
+
+	t.Setenv("LOG_SYSLOG", "true")
+	cfg, err = logger.ConfigFromEnv()
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Syslog)
+	assert.Empty(t, cfg.Syslog.Address, "local /dev/log")
+}
+
+// TestConfigFromEnv_Invalid tests that malformed values are rejected
+func TestConfigFromEnv_Invalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"LOG_LEVEL":    "verbose",
+		"LOG_FORMAT":   "xml",
+		"LOG_MAX_SIZE": "big",
+		"LOG_COMPRESS": "maybe",
+		"LOG_SYSLOG":   "logs.internal",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err := logger.ConfigFromEnv()
+			assert.Error(t, err)
+		})
+	}
+}
@@ -2,10 +2,13 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/andro-kes/gateway/internal/journald"
+	"github.com/andro-kes/gateway/internal/syslog"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
@@ -28,6 +31,18 @@ type Config struct {
 	// ErrorOutputPaths specifies where internal zap errors are written.
 	ErrorOutputPaths []string
 
+	// DisableStdout drops the default stdout sink, e.g. when logging to
+	// journald from a systemd unit whose stdout also ends up in the journal.
+	DisableStdout bool
+
+	// Syslog, if set, also sends logs to a syslog server as RFC 5424 messages
+	// with the log fields as structured data.
+	Syslog *syslog.Config
+
+	// Journald, if set, also sends logs to the systemd journal with every log
+	// field as a journal field (trace_id becomes TRACE_ID).
+	Journald *journald.Config
+
 	// File rotation options: if Filename is non-empty and FileRotation true,
 	// logs will be written to that file using lumberjack for rotation.
 	FileRotation bool
@@ -49,6 +64,9 @@ var (
 	zapLogger   *zap.Logger
 	sugar       *zap.SugaredLogger
 	initialized = false
+
+	// closers release the network sinks of the current logger
+	closers []io.Closer
 )
 
 // Init initializes the package logger with the given config.
@@ -58,6 +76,10 @@ func Init(cfg Config) error {
 	// If previously initialized, attempt to Sync old logger.
 	if initialized {
 		_ = Sync()
+		for _, c := range closers {
+			_ = c.Close()
+		}
+		closers = nil
 		zapLogger = nil
 		sugar = nil
 		initialized = false
@@ -113,8 +135,10 @@ func Init(cfg Config) error {
 	// Build write syncers
 	var syncers []zapcore.WriteSyncer
 
-	// Always include stdout as a default sink (so logs appear in containers)
-	syncers = append(syncers, zapcore.AddSync(os.Stdout))
+	// Include stdout as a default sink (so logs appear in containers)
+	if !cfg.DisableStdout {
+		syncers = append(syncers, zapcore.AddSync(os.Stdout))
+	}
 
 	// If user provided explicit output paths, add them (except stdout/stderr which are handled)
 	for _, p := range cfg.OutputPaths {
@@ -164,12 +188,35 @@ func Init(cfg Config) error {
 	}
 
 	// Combine syncers into one core sink
-	var core zapcore.Core
+	var cores []zapcore.Core
 	if len(syncers) == 1 {
-		core = zapcore.NewCore(encoder, syncers[0], level)
-	} else {
-		core = zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(syncers...), level)
+		cores = append(cores, zapcore.NewCore(encoder, syncers[0], level))
+	} else if len(syncers) > 1 {
+		cores = append(cores, zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(syncers...), level))
+	}
+
+	// Structured sinks encode fields themselves
+	var sinks []io.Closer
+	if cfg.Syslog != nil {
+		w, serr := syslog.New(*cfg.Syslog)
+		if serr != nil {
+			return serr
+		}
+		sinks = append(sinks, w)
+		cores = append(cores, newSyslogCore(w, level))
+	}
+	if cfg.Journald != nil {
+		w, jerr := journald.New(*cfg.Journald)
+		if jerr != nil {
+			for _, c := range sinks {
+				_ = c.Close()
+			}
+			return jerr
+		}
+		sinks = append(sinks, w)
+		cores = append(cores, newJournaldCore(w, level))
 	}
+	core := zapcore.NewTee(cores...)
 
 	// Options
 	opts := []zap.Option{
@@ -184,6 +231,7 @@ func Init(cfg Config) error {
 
 	zapLogger = zap.New(core, opts...)
 	sugar = zapLogger.Sugar()
+	closers = sinks
 	initialized = true
 
 	return nil
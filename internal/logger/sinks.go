@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/journald"
+	"github.com/andro-kes/gateway/internal/syslog"
+	"go.uber.org/zap/zapcore"
+)
+
+// fieldCore is a zapcore.Core for sinks that keep log fields structured
+// instead of encoding the entry into one line.
+type fieldCore struct {
+	zapcore.LevelEnabler
+	fields []zapcore.Field
+	send   func(ent zapcore.Entry, fields map[string]string) error
+}
+
+func (c *fieldCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zapcore.Field(nil), c.fields...), fields...)
+	return &clone
+}
+
+func (c *fieldCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *fieldCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	values := make(map[string]string, len(enc.Fields)+3)
+	for k, v := range enc.Fields {
+		values[k] = fieldString(v)
+	}
+	if ent.LoggerName != "" {
+		values["logger"] = ent.LoggerName
+	}
+	if ent.Caller.Defined {
+		values["caller"] = ent.Caller.TrimmedPath()
+	}
+	if ent.Stack != "" {
+		values["stacktrace"] = ent.Stack
+	}
+	return c.send(ent, values)
+}
+
+func (c *fieldCore) Sync() error {
+	return nil
+}
+
+// fieldString renders a field value; objects and arrays become JSON.
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// severity maps zap levels to syslog severities, which journald uses as PRIORITY.
+func severity(l zapcore.Level) syslog.Severity {
+	switch {
+	case l <= zapcore.DebugLevel:
+		return syslog.Debug
+	case l == zapcore.InfoLevel:
+		return syslog.Info
+	case l == zapcore.WarnLevel:
+		return syslog.Warning
+	case l == zapcore.ErrorLevel:
+		return syslog.Error
+	case l == zapcore.FatalLevel:
+		return syslog.Alert
+	default:
+		return syslog.Critical
+	}
+}
+
+// newSyslogCore sends entries as RFC 5424 messages with the fields as
+// structured data.
+func newSyslogCore(w *syslog.Writer, level zapcore.LevelEnabler) zapcore.Core {
+	return &fieldCore{
+		LevelEnabler: level,
+		send: func(ent zapcore.Entry, fields map[string]string) error {
+			return w.SendData(severity(ent.Level), fields, ent.Message)
+		},
+	}
+}
+
+// newJournaldCore sends entries to the journal with every field as a journal
+// field, e.g. trace_id as TRACE_ID. The caller is mapped to CODE_FILE and
+// CODE_LINE.
+func newJournaldCore(w *journald.Writer, level zapcore.LevelEnabler) zapcore.Core {
+	return &fieldCore{
+		LevelEnabler: level,
+		send: func(ent zapcore.Entry, fields map[string]string) error {
+			if ent.Caller.Defined {
+				delete(fields, "caller")
+				fields["code_file"] = ent.Caller.File
+				fields["code_line"] = strconv.Itoa(ent.Caller.Line)
+			}
+			return w.Send(int(severity(ent.Level)), ent.Message, fields)
+		},
+	}
+}
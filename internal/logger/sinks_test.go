@@ -0,0 +1,89 @@
+package logger_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/journald"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/syslog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// TestInit_Journald tests the mapping of zap fields to journal fields
+func TestInit_Journald(t *testing.T) {
+	dir, err := os.MkdirTemp("", "logger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "journal")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, logger.Init(logger.Config{
+		DisableStdout: true,
+		Journald:      &journald.Config{Socket: socket},
+	}))
+	t.Cleanup(func() { logger.Init(logger.Config{}) })
+
+	logger.Logger().With(zap.String("trace_id", "abc")).Warn("Backend call failed",
+		zap.Error(errors.New("unavailable")), zap.Duration("duration", 1500*time.Millisecond), zap.Int("attempt", 2))
+	logger.Logger().Debug("not sent")
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	entry := string(buf[:n])
+	for _, want := range []string{
+		"MESSAGE=Backend call failed\n",
+		"PRIORITY=4\n",
+		"SYSLOG_IDENTIFIER=gateway\n",
+		"TRACE_ID=abc\n",
+		"ERROR=unavailable\n",
+		"DURATION=1.5s\n",
+		"ATTEMPT=2\n",
+		"CODE_FILE=",
+		"CODE_LINE=",
+	} {
+		assert.Contains(t, entry, want)
+	}
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = conn.Read(buf)
+	assert.Error(t, err, "debug entries are below the level")
+}
+
+// TestInit_Syslog tests that zap fields are sent as syslog structured data
+func TestInit_Syslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, logger.Init(logger.Config{
+		DisableStdout: true,
+		Syslog:        &syslog.Config{Address: conn.LocalAddr().String(), Facility: "daemon"},
+	}))
+	t.Cleanup(func() { logger.Init(logger.Config{}) })
+
+	logger.Logger().Error("Backend call failed", zap.String("method", "/inventory.InventoryService/Get"))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	buf := make([]byte, 8192)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	// daemon (3) * 8 + err (3)
+	assert.True(t, strings.HasPrefix(msg, "<27>1 "), msg)
+	assert.Contains(t, msg, `[fields@32473 caller="`)
+	assert.Contains(t, msg, ` method="/inventory.InventoryService/Get"`)
+	assert.Contains(t, msg, ` stacktrace="`)
+	assert.True(t, strings.HasSuffix(msg, "] Backend call failed"), msg)
+}
@@ -0,0 +1,148 @@
+// Package maintenance implements a runtime-toggleable maintenance mode that
+// answers 503 for everything except health checks, metrics, the admin API and
+// allowlisted clients.
+package maintenance
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/andro-kes/gateway/internal/realip"
+)
+
+// TokenHeader carries a bypass token from Config.AllowTokens.
+const TokenHeader = "X-Maintenance-Token"
+
+const defaultMessage = "service is under maintenance"
+
+// Config configures maintenance mode.
+type Config struct {
+	// Enabled starts the gateway in maintenance mode.
+	Enabled bool `yaml:"enabled"`
+
+	// Message is returned to rejected clients.
+	Message string `yaml:"message"`
+
+	// RetryAfter is sent in the Retry-After header. Default: 5m.
+	RetryAfter time.Duration `yaml:"retry_after"`
+
+	// AllowIPs are client CIDRs (or single IPs) that bypass maintenance mode.
+	AllowIPs []string `yaml:"allow_ips"`
+
+	// AllowTokens bypass maintenance mode when sent in X-Maintenance-Token.
+	AllowTokens []string `yaml:"allow_tokens"`
+}
+
+// Status is the current maintenance state, as reported by the admin API.
+type Status struct {
+	Enabled           bool       `json:"enabled"`
+	Message           string     `json:"message,omitempty"`
+	RetryAfterSeconds int        `json:"retry_after_seconds,omitempty"`
+	Since             *time.Time `json:"since,omitempty"`
+}
+
+// Mode holds the maintenance state.
+type Mode struct {
+	allowIPs    []*net.IPNet
+	allowTokens []string
+	status      atomic.Pointer[Status]
+}
+
+// New builds a Mode from cfg.
+func New(cfg Config) (*Mode, error) {
+	allow, err := realip.ParseCIDRs(cfg.AllowIPs)
+	if err != nil {
+		return nil, fmt.Errorf("maintenance allow_ips: %w", err)
+	}
+	m := &Mode{allowIPs: allow, allowTokens: cfg.AllowTokens}
+	m.Set(Status{
+		Enabled:           cfg.Enabled,
+		Message:           cfg.Message,
+		RetryAfterSeconds: int(cfg.RetryAfter / time.Second),
+	})
+	return m, nil
+}
+
+// Set replaces the maintenance state, filling in defaults for enabled mode.
+func (m *Mode) Set(st Status) {
+	st.Since = nil
+	if st.Enabled {
+		if st.Message == "" {
+			st.Message = defaultMessage
+		}
+		if st.RetryAfterSeconds <= 0 {
+			st.RetryAfterSeconds = int((5 * time.Minute) / time.Second)
+		}
+		now := time.Now().UTC()
+		if prev := m.status.Load(); prev != nil && prev.Enabled && prev.Since != nil {
+			now = *prev.Since
+		}
+		st.Since = &now
+	}
+	m.status.Store(&st)
+}
+
+// Status returns the current maintenance state.
+func (m *Mode) Status() Status {
+	return *m.status.Load()
+}
+
+// Middleware rejects requests with 503 while maintenance mode is enabled.
+func (m *Mode) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := m.status.Load()
+		if !st.Enabled || bypassPath(r.URL.Path) || m.allowed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		message := st.Message
+		if message == defaultMessage {
+			message = i18n.Message(w, r, "maintenance")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(st.RetryAfterSeconds))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":               "maintenance",
+			"message":             message,
+			"retry_after_seconds": st.RetryAfterSeconds,
+		})
+	})
+}
+
+func bypassPath(path string) bool {
+	return path == "/health" || path == "/metrics" || path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+func (m *Mode) allowed(r *http.Request) bool {
+	if tok := r.Header.Get(TokenHeader); tok != "" {
+		for _, t := range m.allowTokens {
+			if subtle.ConstantTimeCompare([]byte(tok), []byte(t)) == 1 {
+				return true
+			}
+		}
+	}
+	ip, ok := realip.FromContext(r.Context())
+	if !ok {
+		ip = realip.Peer(r)
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range m.allowIPs {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package maintenance_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/maintenance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(m *maintenance.Mode, r *http.Request) *httptest.ResponseRecorder {
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestMiddleware_Enabled tests the 503 response while maintenance mode is on
+func TestMiddleware_Enabled(t *testing.T) {
+	m, err := maintenance.New(maintenance.Config{Enabled: true, RetryAfter: 2 * time.Minute})
+	require.NoError(t, err)
+
+	w := serve(m, httptest.NewRequest(http.MethodGet, "/inventory/get", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "120", w.Header().Get("Retry-After"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "maintenance", body["error"])
+	assert.NotEmpty(t, body["message"])
+}
+
+// TestMiddleware_Bypass tests the paths, IPs and tokens that skip maintenance mode
+func TestMiddleware_Bypass(t *testing.T) {
+	m, err := maintenance.New(maintenance.Config{
+		Enabled:     true,
+		AllowIPs:    []string{"10.0.0.0/8"},
+		AllowTokens: []string{"deploy-secret"},
+	})
+	require.NoError(t, err)
+
+	for _, path := range []string{"/health", "/metrics", "/admin/maintenance"} {
+		assert.Equal(t, http.StatusOK, serve(m, httptest.NewRequest(http.MethodGet, path, nil)).Code, path)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+	r.RemoteAddr = "10.1.1.1:1234"
+	assert.Equal(t, http.StatusOK, serve(m, r).Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+	r.Header.Set(maintenance.TokenHeader, "deploy-secret")
+	assert.Equal(t, http.StatusOK, serve(m, r).Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+	r.Header.Set(maintenance.TokenHeader, "wrong")
+	assert.Equal(t, http.StatusServiceUnavailable, serve(m, r).Code)
+}
+
+// TestSet_KeepsSince tests that re-enabling an active maintenance keeps its start time
+func TestSet_KeepsSince(t *testing.T) {
+	m, err := maintenance.New(maintenance.Config{})
+	require.NoError(t, err)
+	assert.False(t, m.Status().Enabled)
+
+	m.Set(maintenance.Status{Enabled: true})
+	since := m.Status().Since
+	require.NotNil(t, since)
+
+	m.Set(maintenance.Status{Enabled: true, Message: "still going"})
+	assert.Equal(t, *since, *m.Status().Since)
+	assert.Equal(t, "still going", m.Status().Message)
+}
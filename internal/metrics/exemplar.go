@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ExemplarFunc returns the exemplar labels of an observation made for a
+// request, or nil for none. failed and elapsed describe the request or the
+// part of it observed, e.g. a backend call.
+type ExemplarFunc func(failed bool, elapsed time.Duration) prometheus.Labels
+
+type exemplarKey struct{}
+
+// WithExemplar returns a copy of ctx whose observations carry the exemplar
+// labels of fn, e.g. the ID of the trace of the request.
+func WithExemplar(ctx context.Context, fn ExemplarFunc) context.Context {
+	return context.WithValue(ctx, exemplarKey{}, fn)
+}
+
+// ObserveDuration records elapsed, in seconds, in o, with the exemplar of
+// ctx if it has one.
+func ObserveDuration(ctx context.Context, o prometheus.Observer, elapsed time.Duration, failed bool) {
+	if fn, ok := ctx.Value(exemplarKey{}).(ExemplarFunc); ok {
+		if eo, ok := o.(prometheus.ExemplarObserver); ok {
+			if labels := fn(failed, elapsed); labels != nil {
+				eo.ObserveWithExemplar(elapsed.Seconds(), labels)
+				return
+			}
+		}
+	}
+	o.Observe(elapsed.Seconds())
+}
@@ -0,0 +1,31 @@
+// Package metrics holds the gateway's Prometheus registry. Subsystems register
+// their own collectors against Registry, which is served for scraping via
+// Handler, pushed to an OpenTelemetry collector by an Exporter, or both.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every gateway metric name.
+const Namespace = "gateway"
+
+// Registry is the package-level registry all gateway metrics are registered in.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format, or in
+// the OpenMetrics format, which carries exemplars, to scrapers asking for it.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry, EnableOpenMetrics: true})
+}
@@ -0,0 +1,366 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// Exporters.
+const (
+	// Prometheus serves the registry at /metrics for scraping.
+	Prometheus = "prometheus"
+	// OTLP pushes the registry to an OpenTelemetry collector.
+	OTLP = "otlp"
+	// Both does both.
+	Both = "both"
+)
+
+// Config selects how metrics leave the gateway.
+type Config struct {
+	// Exporter is "prometheus" (default), "otlp" or "both".
+	Exporter string `yaml:"exporter"`
+
+	// OTLP configures the push to an OpenTelemetry collector.
+	OTLP OTLPConfig `yaml:"otlp"`
+}
+
+// OTLPConfig configures the OTLP/HTTP metrics push.
+type OTLPConfig struct {
+	// Endpoint is the collector's metrics URL, e.g.
+	// http://otel-collector:4318/v1/metrics. Env:
+	// OTEL_EXPORTER_OTLP_METRICS_ENDPOINT, or OTEL_EXPORTER_OTLP_ENDPOINT
+	// with /v1/metrics appended.
+	Endpoint string `yaml:"endpoint"`
+
+	// Headers are sent with every push, e.g. an API key of a hosted
+	// collector.
+	Headers map[string]string `yaml:"headers"`
+
+	// Interval is the time between pushes. Default: 60s.
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout bounds every push. Default: 10s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// ServiceName is the service.name resource attribute. Default:
+	// "gateway". Env: OTEL_SERVICE_NAME.
+	ServiceName string `yaml:"service_name"`
+
+	// ResourceAttributes are further resource attributes, e.g.
+	// deployment.environment.
+	ResourceAttributes map[string]string `yaml:"resource_attributes"`
+}
+
+// ServesPrometheus reports whether cfg keeps the /metrics endpoint.
+func (cfg Config) ServesPrometheus() bool {
+	return cfg.Exporter != OTLP
+}
+
+// Exporter pushes the registry to an OpenTelemetry collector in the OTLP
+// JSON encoding, as cumulative sums, gauges, histograms and summaries. A nil
+// *Exporter does nothing.
+type Exporter struct {
+	cfg    OTLPConfig
+	client *http.Client
+	start  time.Time
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewExporter validates cfg and starts pushing when it selects OTLP. It
+// returns nil when metrics are only scraped.
+func NewExporter(cfg Config) (*Exporter, error) {
+	switch cfg.Exporter {
+	case "", Prometheus:
+		return nil, nil
+	case OTLP, Both:
+	default:
+		return nil, fmt.Errorf("unknown metrics exporter %q", cfg.Exporter)
+	}
+	o := cfg.OTLP
+	if o.Endpoint == "" {
+		return nil, fmt.Errorf("otlp exporter needs an endpoint")
+	}
+	if u, err := url.Parse(o.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("otlp endpoint %q must be an http or https URL", o.Endpoint)
+	}
+	if o.Interval <= 0 {
+		o.Interval = time.Minute
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.ServiceName == "" {
+		o.ServiceName = Namespace
+	}
+	e := &Exporter{
+		cfg:    o,
+		client: &http.Client{},
+		start:  time.Now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+			if err := e.Push(ctx); err != nil {
+				logger.Logger().Warn("Failed to push metrics", zap.String("endpoint", e.cfg.Endpoint), zap.Error(err))
+			}
+			cancel()
+		}
+	}
+}
+
+// Close stops the periodic push and pushes once more, so the last interval
+// is not lost, until ctx is done.
+func (e *Exporter) Close(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	e.once.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return e.Push(ctx)
+}
+
+// Push sends the current value of every metric in Registry.
+func (e *Exporter) Push(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	families, err := Registry.Gather()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(e.request(families, time.Now()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// The OTLP JSON encoding of ExportMetricsServiceRequest. 64-bit integers are
+// strings, as in the protobuf JSON mapping.
+type (
+	otlpRequest struct {
+		ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+	}
+	resourceMetrics struct {
+		Resource     resource       `json:"resource"`
+		ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeMetrics struct {
+		Scope   scope        `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	keyValue struct {
+		Key   string      `json:"key"`
+		Value stringValue `json:"value"`
+	}
+	stringValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpMetric struct {
+		Name        string     `json:"name"`
+		Description string     `json:"description,omitempty"`
+		Sum         *sum       `json:"sum,omitempty"`
+		Gauge       *gauge     `json:"gauge,omitempty"`
+		Histogram   *histogram `json:"histogram,omitempty"`
+		Summary     *summary   `json:"summary,omitempty"`
+	}
+	sum struct {
+		DataPoints             []numberPoint `json:"dataPoints"`
+		AggregationTemporality int           `json:"aggregationTemporality"`
+		IsMonotonic            bool          `json:"isMonotonic"`
+	}
+	gauge struct {
+		DataPoints []numberPoint `json:"dataPoints"`
+	}
+	numberPoint struct {
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string     `json:"timeUnixNano"`
+		AsDouble          float64    `json:"asDouble"`
+	}
+	histogram struct {
+		DataPoints             []histogramPoint `json:"dataPoints"`
+		AggregationTemporality int              `json:"aggregationTemporality"`
+	}
+	histogramPoint struct {
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		TimeUnixNano      string     `json:"timeUnixNano"`
+		Count             string     `json:"count"`
+		Sum               float64    `json:"sum"`
+		BucketCounts      []string   `json:"bucketCounts"`
+		ExplicitBounds    []float64  `json:"explicitBounds"`
+	}
+	summary struct {
+		DataPoints []summaryPoint `json:"dataPoints"`
+	}
+	summaryPoint struct {
+		Attributes        []keyValue      `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		Count             string          `json:"count"`
+		Sum               float64         `json:"sum"`
+		QuantileValues    []quantileValue `json:"quantileValues"`
+	}
+	quantileValue struct {
+		Quantile float64 `json:"quantile"`
+		Value    float64 `json:"value"`
+	}
+)
+
+// cumulative is AGGREGATION_TEMPORALITY_CUMULATIVE: Prometheus counters
+// count from process start.
+const cumulative = 2
+
+func (e *Exporter) request(families []*dto.MetricFamily, now time.Time) otlpRequest {
+	attrs := []keyValue{attr("service.name", e.cfg.ServiceName)}
+	for k, v := range e.cfg.ResourceAttributes {
+		attrs = append(attrs, attr(k, v))
+	}
+	start, ts := nanos(e.start), nanos(now)
+
+	var out []otlpMetric
+	for _, mf := range families {
+		m := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Sum = &sum{AggregationTemporality: cumulative, IsMonotonic: true}
+			for _, pm := range mf.GetMetric() {
+				m.Sum.DataPoints = append(m.Sum.DataPoints, numberPoint{labels(pm), start, ts, pm.GetCounter().GetValue()})
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			m.Gauge = &gauge{}
+			for _, pm := range mf.GetMetric() {
+				v := pm.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = pm.GetUntyped().GetValue()
+				}
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, numberPoint{Attributes: labels(pm), TimeUnixNano: ts, AsDouble: v})
+			}
+		case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+			m.Histogram = &histogram{AggregationTemporality: cumulative}
+			for _, pm := range mf.GetMetric() {
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, histogramDataPoint(pm, start, ts))
+			}
+		case dto.MetricType_SUMMARY:
+			m.Summary = &summary{}
+			for _, pm := range mf.GetMetric() {
+				s := pm.GetSummary()
+				p := summaryPoint{
+					Attributes:        labels(pm),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					Count:             strconv.FormatUint(s.GetSampleCount(), 10),
+					Sum:               s.GetSampleSum(),
+				}
+				for _, q := range s.GetQuantile() {
+					p.QuantileValues = append(p.QuantileValues, quantileValue{q.GetQuantile(), q.GetValue()})
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+			}
+		default:
+			continue
+		}
+		out = append(out, m)
+	}
+	return otlpRequest{ResourceMetrics: []resourceMetrics{{
+		Resource:     resource{Attributes: attrs},
+		ScopeMetrics: []scopeMetrics{{Scope: scope{Name: "github.com/andro-kes/gateway"}, Metrics: out}},
+	}}}
+}
+
+// histogramDataPoint converts Prometheus' cumulative buckets to OTLP's
+// per-bucket counts, the last one counting the values above every bound.
+func histogramDataPoint(pm *dto.Metric, start, ts string) histogramPoint {
+	h := pm.GetHistogram()
+	p := histogramPoint{
+		Attributes:        labels(pm),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      ts,
+		Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:               h.GetSampleSum(),
+	}
+	var below uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+		p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+		p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-below, 10))
+		below = b.GetCumulativeCount()
+	}
+	p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-below, 10))
+	return p
+}
+
+func labels(pm *dto.Metric) []keyValue {
+	var kvs []keyValue
+	for _, l := range pm.GetLabel() {
+		kvs = append(kvs, attr(l.GetName(), l.GetValue()))
+	}
+	return kvs
+}
+
+func attr(k, v string) keyValue {
+	return keyValue{Key: k, Value: stringValue{StringValue: v}}
+}
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
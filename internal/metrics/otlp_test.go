@@ -0,0 +1,98 @@
+package metrics_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testCounter = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "otlp_test_total",
+		Help: "Test counter.",
+	}, []string{"route"})
+	testHistogram = promauto.With(metrics.Registry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "otlp_test_seconds",
+		Buckets: []float64{0.1, 1},
+	})
+)
+
+// TestExporter_Push tests the OTLP JSON sent to the collector
+func TestExporter_Push(t *testing.T) {
+	var (
+		got    map[string]any
+		apiKey string
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("X-API-Key")
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer collector.Close()
+
+	testCounter.WithLabelValues("/inventory/get").Add(3)
+	for _, v := range []float64{0.05, 0.5, 5} {
+		testHistogram.Observe(v)
+	}
+
+	e, err := metrics.NewExporter(metrics.Config{
+		Exporter: metrics.OTLP,
+		OTLP: metrics.OTLPConfig{
+			Endpoint: collector.URL + "/v1/metrics",
+			Headers:  map[string]string{"X-API-Key": "secret"},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Close(context.Background()))
+	assert.Equal(t, "secret", apiKey)
+
+	rm := got["resourceMetrics"].([]any)[0].(map[string]any)
+	assert.Contains(t, rm["resource"].(map[string]any)["attributes"], map[string]any{
+		"key": "service.name", "value": map[string]any{"stringValue": "gateway"},
+	})
+	byName := map[string]map[string]any{}
+	for _, m := range rm["scopeMetrics"].([]any)[0].(map[string]any)["metrics"].([]any) {
+		byName[m.(map[string]any)["name"].(string)] = m.(map[string]any)
+	}
+
+	sum := byName["otlp_test_total"]["sum"].(map[string]any)
+	assert.Equal(t, true, sum["isMonotonic"])
+	assert.Equal(t, float64(2), sum["aggregationTemporality"])
+	point := sum["dataPoints"].([]any)[0].(map[string]any)
+	assert.Equal(t, float64(3), point["asDouble"])
+	assert.Equal(t, []any{map[string]any{"key": "route", "value": map[string]any{"stringValue": "/inventory/get"}}}, point["attributes"])
+
+	hist := byName["otlp_test_seconds"]["histogram"].(map[string]any)["dataPoints"].([]any)[0].(map[string]any)
+	assert.Equal(t, "3", hist["count"])
+	assert.Equal(t, []any{0.1, 1.0}, hist["explicitBounds"])
+	assert.Equal(t, []any{"1", "1", "1"}, hist["bucketCounts"])
+
+	assert.Contains(t, byName, "go_goroutines")
+}
+
+// TestNewExporter tests exporter selection and validation
+func TestNewExporter(t *testing.T) {
+	e, err := metrics.NewExporter(metrics.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, e)
+	assert.NoError(t, e.Close(context.Background()))
+	assert.True(t, metrics.Config{Exporter: metrics.Both}.ServesPrometheus())
+	assert.False(t, metrics.Config{Exporter: metrics.OTLP}.ServesPrometheus())
+
+	for _, cfg := range []metrics.Config{
+		{Exporter: "statsd"},
+		{Exporter: metrics.OTLP},
+		{Exporter: metrics.OTLP, OTLP: metrics.OTLPConfig{Endpoint: "otel-collector:4318"}},
+	} {
+		_, err := metrics.NewExporter(cfg)
+		assert.Error(t, err, cfg)
+	}
+}
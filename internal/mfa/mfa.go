@@ -0,0 +1,183 @@
+// Package mfa issues the challenge tokens of the two-step login of users
+// with multi-factor authentication. Once their password is checked, such
+// users get a short-lived challenge token instead of their tokens, and
+// exchange it along with a TOTP code for the tokens. Challenge tokens are
+// signed by the gateway, bound to one user, and accept a limited number of
+// codes.
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config configures multi-factor authentication.
+type Config struct {
+	// Enabled asks auth_service after each login whether the user has MFA
+	// set up, and serves /auth/mfa.
+	Enabled bool `yaml:"enabled"`
+
+	// ChallengeSecret signs challenge tokens. All gateway instances behind
+	// one load balancer need the same secret. If empty, a random secret is
+	// generated, so challenges only work on the instance that issued them.
+	ChallengeSecret string `yaml:"challenge_secret"`
+
+	// ChallengeTTL is how long users have to enter their code. Default: 5m.
+	ChallengeTTL time.Duration `yaml:"challenge_ttl"`
+
+	// MaxAttempts is how many codes a challenge accepts before the user
+	// must log in again. Default: 5.
+	MaxAttempts int `yaml:"max_attempts"`
+}
+
+// Errors returned by Attempt.
+var (
+	ErrInvalid         = errors.New("invalid mfa challenge")
+	ErrExpired         = errors.New("mfa challenge expired")
+	ErrUsed            = errors.New("mfa challenge already used")
+	ErrTooManyAttempts = errors.New("too many mfa codes for this challenge")
+)
+
+// minSecretBytes is the minimum length of a configured challenge secret.
+const minSecretBytes = 32
+
+// Challenge is the login a challenge token stands for.
+type Challenge struct {
+	ID     string `json:"jti"`
+	UserID string `json:"uid"`
+
+	// RememberMe is the remember-me choice of the login, if made.
+	RememberMe *bool `json:"rem,omitempty"`
+
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// Challenges issues and checks challenge tokens. A nil *Challenges means MFA
+// is disabled.
+type Challenges struct {
+	key         []byte
+	ttl         time.Duration
+	maxAttempts int
+
+	mu sync.Mutex
+	// attempts counts the codes tried per challenge ID; used challenges
+	// are marked with -1. Entries are dropped once expired.
+	attempts map[string]attempts
+}
+
+type attempts struct {
+	n       int
+	expires time.Time
+}
+
+// New returns the Challenges of cfg, or nil when MFA is disabled.
+func New(cfg Config) (*Challenges, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.ChallengeTTL <= 0 {
+		cfg.ChallengeTTL = 5 * time.Minute
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	key := []byte(cfg.ChallengeSecret)
+	switch {
+	case len(key) == 0:
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	case len(key) < minSecretBytes:
+		return nil, fmt.Errorf("mfa challenge secret is %d bytes, at least %d are required", len(key), minSecretBytes)
+	}
+	return &Challenges{key: key, ttl: cfg.ChallengeTTL, maxAttempts: cfg.MaxAttempts, attempts: map[string]attempts{}}, nil
+}
+
+// Issue returns a challenge token for the login of userID.
+func (c *Challenges) Issue(userID string, remember *bool) (string, *Challenge, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	ch := &Challenge{
+		ID:         base64.RawURLEncoding.EncodeToString(id),
+		UserID:     userID,
+		RememberMe: remember,
+		ExpiresAt:  time.Now().Add(c.ttl).Truncate(time.Second),
+	}
+	payload, err := json.Marshal(ch)
+	if err != nil {
+		return "", nil, err
+	}
+	p := base64.RawURLEncoding.EncodeToString(payload)
+	return p + "." + base64.RawURLEncoding.EncodeToString(c.sign(p)), ch, nil
+}
+
+// Attempt checks raw and counts a code tried against it. It fails once the
+// challenge expired, was used or had MaxAttempts codes tried.
+func (c *Challenges) Attempt(raw string) (*Challenge, error) {
+	p, sig, ok := strings.Cut(raw, ".")
+	if !ok {
+		return nil, ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, c.sign(p)) {
+		return nil, ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var ch Challenge
+	if err := json.Unmarshal(payload, &ch); err != nil || ch.ID == "" || ch.UserID == "" {
+		return nil, ErrInvalid
+	}
+	now := time.Now()
+	if !now.Before(ch.ExpiresAt) {
+		return nil, ErrExpired
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, a := range c.attempts {
+		if now.After(a.expires) {
+			delete(c.attempts, id)
+		}
+	}
+	a := c.attempts[ch.ID]
+	switch {
+	case a.n < 0:
+		return nil, ErrUsed
+	case a.n >= c.maxAttempts:
+		return nil, ErrTooManyAttempts
+	}
+	c.attempts[ch.ID] = attempts{n: a.n + 1, expires: ch.ExpiresAt}
+	return &ch, nil
+}
+
+// Done marks ch used, once its code was accepted.
+func (c *Challenges) Done(ch *Challenge) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts[ch.ID] = attempts{n: -1, expires: ch.ExpiresAt}
+}
+
+// TTL is how long challenges are valid.
+func (c *Challenges) TTL() time.Duration {
+	return c.ttl
+}
+
+func (c *Challenges) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package mfa_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/mfa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+// TestChallenges tests that challenges carry their login and are accepted until used
+func TestChallenges(t *testing.T) {
+	c, err := mfa.New(mfa.Config{Enabled: true, ChallengeSecret: secret})
+	require.NoError(t, err)
+	remember := true
+
+	raw, issued, err := c.Issue("user-1", &remember)
+	require.NoError(t, err)
+	ch, err := c.Attempt(raw)
+	require.NoError(t, err)
+	assert.Equal(t, issued.ID, ch.ID)
+	assert.Equal(t, "user-1", ch.UserID)
+	require.NotNil(t, ch.RememberMe)
+	assert.True(t, *ch.RememberMe)
+
+	// a wrong code leaves the challenge usable
+	_, err = c.Attempt(raw)
+	require.NoError(t, err)
+	c.Done(ch)
+	_, err = c.Attempt(raw)
+	assert.ErrorIs(t, err, mfa.ErrUsed)
+
+	// another instance with the same secret accepts the challenge
+	other, err := mfa.New(mfa.Config{Enabled: true, ChallengeSecret: secret})
+	require.NoError(t, err)
+	raw, _, err = c.Issue("user-2", nil)
+	require.NoError(t, err)
+	ch, err = other.Attempt(raw)
+	require.NoError(t, err)
+	assert.Nil(t, ch.RememberMe)
+}
+
+// TestChallenges_Rejected tests that tampered, expired and overused challenges are refused
+func TestChallenges_Rejected(t *testing.T) {
+	c, err := mfa.New(mfa.Config{Enabled: true, ChallengeSecret: secret, MaxAttempts: 2})
+	require.NoError(t, err)
+
+	raw, _, err := c.Issue("user-1", nil)
+	require.NoError(t, err)
+	payload, sig, _ := strings.Cut(raw, ".")
+	for _, tampered := range []string{"", payload, payload + "x." + sig, "e30." + sig} {
+		_, err := c.Attempt(tampered)
+		assert.ErrorIs(t, err, mfa.ErrInvalid, tampered)
+	}
+
+	other, err := mfa.New(mfa.Config{Enabled: true})
+	require.NoError(t, err)
+	_, err = other.Attempt(raw)
+	assert.ErrorIs(t, err, mfa.ErrInvalid, "signed with another secret")
+
+	for range 2 {
+		_, err = c.Attempt(raw)
+		require.NoError(t, err)
+	}
+	_, err = c.Attempt(raw)
+	assert.ErrorIs(t, err, mfa.ErrTooManyAttempts)
+
+	short, err := mfa.New(mfa.Config{Enabled: true, ChallengeSecret: secret, ChallengeTTL: time.Second})
+	require.NoError(t, err)
+	raw, _, err = short.Issue("user-1", nil)
+	require.NoError(t, err)
+	time.Sleep(1100 * time.Millisecond)
+	_, err = short.Attempt(raw)
+	assert.ErrorIs(t, err, mfa.ErrExpired)
+}
+
+// TestNew tests that MFA is off unless enabled and that short secrets are refused
+func TestNew(t *testing.T) {
+	c, err := mfa.New(mfa.Config{ChallengeSecret: secret})
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	_, err = mfa.New(mfa.Config{Enabled: true, ChallengeSecret: "too-short"})
+	assert.Error(t, err)
+
+	c, err = mfa.New(mfa.Config{Enabled: true})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, c.TTL())
+}
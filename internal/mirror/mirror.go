@@ -0,0 +1,172 @@
+// Package mirror duplicates selected backend RPCs to a shadow backend. Shadow
+// calls run asynchronously after the primary call; their responses are
+// discarded and failures are only logged and counted.
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Config describes the shadow backend. Mirroring is disabled when Address is empty.
+type Config struct {
+	// Address is the gRPC address of the shadow backend.
+	Address string `yaml:"address"`
+
+	// Methods are the full gRPC method names to mirror.
+	// Default: the inventory read RPCs (GetProduct, ListProducts).
+	Methods []string `yaml:"methods"`
+
+	// Percent of matching calls (0-100) that are mirrored; 0 mirrors
+	// nothing. Default: 100.
+	Percent *float64 `yaml:"percent"`
+
+	// Timeout bounds each shadow call. Default: 5s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// MaxInFlight caps concurrent shadow calls; extra calls are dropped. Default: 100.
+	MaxInFlight int `yaml:"max_in_flight"`
+}
+
+var mirroredTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "mirror",
+	Name:      "requests_total",
+	Help:      "Shadow RPCs by method and result (ok, error, dropped).",
+}, []string{"method", "result"})
+
+// Mirror sends copies of selected RPCs to the shadow backend.
+type Mirror struct {
+	cfg     Config
+	percent float64
+	conn    *grpc.ClientConn
+	methods map[string]bool
+	slots   chan struct{}
+}
+
+// New dials the shadow backend. It returns a nil Mirror when mirroring is
+// disabled, without an address or at 0 percent; a nil Mirror's Conn returns the primary unchanged.
+func New(cfg Config, opts ...grpc.DialOption) (*Mirror, error) {
+	if cfg.Address == "" {
+		return nil, nil
+	}
+	percent := 100.0
+	if cfg.Percent != nil {
+		percent = *cfg.Percent
+	}
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("mirror percent %v must be between 0 and 100", percent)
+	}
+	if percent == 0 {
+		return nil, nil
+	}
+	if len(cfg.Methods) == 0 {
+		cfg.Methods = []string{
+			pbInv.InventoryService_GetProduct_FullMethodName,
+			pbInv.InventoryService_ListProducts_FullMethodName,
+		}
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 100
+	}
+
+	conn, err := grpc.NewClient(cfg.Address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial shadow backend %s: %w", cfg.Address, err)
+	}
+
+	m := &Mirror{
+		cfg:     cfg,
+		percent: percent,
+		conn:    conn,
+		methods: make(map[string]bool, len(cfg.Methods)),
+		slots:   make(chan struct{}, cfg.MaxInFlight),
+	}
+	for _, method := range cfg.Methods {
+		m.methods[method] = true
+	}
+	return m, nil
+}
+
+// Close closes the shadow connection.
+func (m *Mirror) Close() error {
+	if m == nil {
+		return nil
+	}
+	return m.conn.Close()
+}
+
+// Conn wraps primary so that calls to mirrored methods are also replayed
+// against the shadow backend.
+func (m *Mirror) Conn(primary grpc.ClientConnInterface) grpc.ClientConnInterface {
+	if m == nil {
+		return primary
+	}
+	return &mirrorConn{ClientConnInterface: primary, m: m}
+}
+
+type mirrorConn struct {
+	grpc.ClientConnInterface
+	m *Mirror
+}
+
+func (c *mirrorConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	if !c.m.methods[method] || rand.Float64()*100 >= c.m.percent {
+		return c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+	}
+
+	// copy the request before the primary call so the shadow sees exactly what was sent
+	in, ok := args.(proto.Message)
+	out, ok2 := reply.(proto.Message)
+	if !ok || !ok2 {
+		return c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+	}
+	shadowIn := proto.Clone(in)
+	shadowOut := out.ProtoReflect().New().Interface()
+
+	err := c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+	c.m.shadow(ctx, method, shadowIn, shadowOut)
+	return err
+}
+
+func (m *Mirror) shadow(ctx context.Context, method string, in, out proto.Message) {
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		mirroredTotal.WithLabelValues(method, "dropped").Inc()
+		return
+	}
+
+	// keep request-scoped values (auth, metadata, trace) but not the cancellation
+	sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.cfg.Timeout)
+	go func() {
+		defer func() { <-m.slots }()
+		defer cancel()
+
+		if err := m.conn.Invoke(sctx, method, in, out); err != nil {
+			mirroredTotal.WithLabelValues(method, "error").Inc()
+			logger.Logger().Warn("Shadow call failed",
+				zap.String("method", method),
+				zap.String("code", status.Code(err).String()),
+				zap.Error(err),
+			)
+			return
+		}
+		mirroredTotal.WithLabelValues(method, "ok").Inc()
+	}()
+}
@@ -0,0 +1,128 @@
+package mirror_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/mirror"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// shadowServer counts the calls it receives
+type shadowServer struct {
+	pbInv.UnimplementedInventoryServiceServer
+	gets    atomic.Int32
+	deletes atomic.Int32
+	auth    atomic.Value
+}
+
+func (s *shadowServer) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	s.gets.Add(1)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		s.auth.Store(md.Get("authorization"))
+	}
+	return &pbInv.GetResponse{Product: &pbInv.Product{Id: "shadow-" + in.Id}}, nil
+}
+
+func (s *shadowServer) DeleteProduct(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error) {
+	s.deletes.Add(1)
+	return &pbInv.DeleteResponse{Success: true}, nil
+}
+
+// primaryConn answers every call locally
+type primaryConn struct {
+	grpc.ClientConnInterface
+}
+
+func (primaryConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	if out, ok := reply.(*pbInv.GetResponse); ok {
+		out.Product = &pbInv.Product{Id: "primary"}
+	}
+	return nil
+}
+
+func startShadow(t *testing.T) (*shadowServer, string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := grpc.NewServer()
+	shadow := &shadowServer{}
+	pbInv.RegisterInventoryServiceServer(srv, shadow)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return shadow, lis.Addr().String()
+}
+
+// TestMirror_ReadsAreMirrored tests that configured reads reach the shadow while the client sees the primary response
+func TestMirror_ReadsAreMirrored(t *testing.T) {
+	shadow, addr := startShadow(t)
+
+	m, err := mirror.New(mirror.Config{Address: addr}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer m.Close()
+
+	client := pbInv.NewInventoryServiceClient(m.Conn(primaryConn{}))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+
+	resp, err := client.GetProduct(ctx, &pbInv.GetRequest{Id: "p1"})
+	require.NoError(t, err)
+	assert.Equal(t, "primary", resp.Product.Id)
+
+	_, err = client.DeleteProduct(ctx, &pbInv.DeleteRequest{Id: "p1"})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return shadow.gets.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"Bearer token"}, shadow.auth.Load())
+	// writes are never mirrored by default
+	assert.Equal(t, int32(0), shadow.deletes.Load())
+}
+
+// TestMirror_Disabled tests that an empty address leaves the primary connection untouched
+func TestMirror_Disabled(t *testing.T) {
+	m, err := mirror.New(mirror.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	primary := primaryConn{}
+	assert.Equal(t, primary, m.Conn(primary))
+	assert.NoError(t, m.Close())
+}
+
+// TestNew_Percent tests that 0 percent disables mirroring and percentages outside 0-100 are rejected
+func TestNew_Percent(t *testing.T) {
+	percent := func(p float64) *float64 { return &p }
+	tests := []struct {
+		name    string
+		percent *float64
+		enabled bool
+		err     bool
+	}{
+		{"default", nil, true, false},
+		{"zero", percent(0), false, false},
+		{"some", percent(25), true, false},
+		{"negative", percent(-1), false, true},
+		{"over 100", percent(101), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := mirror.New(mirror.Config{Address: "127.0.0.1:1", Percent: tt.percent}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if tt.err {
+				assert.ErrorContains(t, err, "between 0 and 100")
+				return
+			}
+			require.NoError(t, err)
+			defer m.Close()
+			assert.Equal(t, tt.enabled, m != nil)
+		})
+	}
+}
@@ -0,0 +1,263 @@
+// Package money handles prices as exact decimal amounts in a currency. The
+// inventory service stores prices as floats, which show up in responses as
+// 29.990000000000002; the gateway instead exchanges decimal strings such as
+// "29.99" with clients, validates their precision against the currency and
+// converts them to the backend representation.
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// exponents are the ISO 4217 minor unit digits of supported currencies.
+var exponents = map[string]int{
+	"AUD": 2, "BGN": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "CZK": 2,
+	"DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "ILS": 2, "INR": 2,
+	"KZT": 2, "MXN": 2, "NOK": 2, "NZD": 2, "PLN": 2, "RON": 2, "RUB": 2,
+	"SEK": 2, "SGD": 2, "TRY": 2, "UAH": 2, "USD": 2, "ZAR": 2,
+	"CLP": 0, "ISK": 0, "JPY": 0, "KRW": 0, "VND": 0,
+	"BHD": 3, "JOD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+}
+
+// maxMinor keeps amounts exactly representable as float64.
+const maxMinor = 1 << 53
+
+var (
+	ErrInvalidAmount = errors.New("invalid amount")
+	ErrPrecision     = errors.New("too many decimal places for currency")
+	ErrOutOfRange    = errors.New("amount out of range")
+)
+
+// Amount is a non-negative amount of money in minor units, e.g. cents.
+type Amount struct {
+	Minor    int64
+	Currency string
+}
+
+// Exponent returns the number of minor unit digits of currency.
+func Exponent(currency string) (int, bool) {
+	exp, ok := exponents[strings.ToUpper(currency)]
+	return exp, ok
+}
+
+// Parse parses a plain decimal string such as "29.99" in currency. Trailing
+// zeros beyond the currency precision are accepted, other digits are not.
+func Parse(s, currency string) (Amount, error) {
+	exp, ok := Exponent(currency)
+	if !ok {
+		return Amount{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || !digits(whole) || (frac == "" && strings.HasSuffix(s, ".")) || !digits(frac) {
+		return Amount{}, ErrInvalidAmount
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > exp {
+		return Amount{}, ErrPrecision
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || minor > maxMinor {
+		return Amount{}, ErrOutOfRange
+	}
+	return Amount{Minor: minor, Currency: strings.ToUpper(currency)}, nil
+}
+
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FromFloat rounds a float amount in major units to the nearest minor unit,
+// absorbing float representation errors.
+func FromFloat(f float64, currency string) (Amount, error) {
+	exp, ok := Exponent(currency)
+	if !ok {
+		return Amount{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+	minor := math.Round(f * math.Pow10(exp))
+	if math.IsNaN(minor) || minor < 0 || minor > maxMinor {
+		return Amount{}, ErrOutOfRange
+	}
+	return Amount{Minor: int64(minor), Currency: strings.ToUpper(currency)}, nil
+}
+
+// Float64 returns the amount in major units, the closest float to the
+// decimal value.
+func (a Amount) Float64() float64 {
+	f, _ := strconv.ParseFloat(a.String(), 64)
+	return f
+}
+
+// String returns the amount as a decimal string with exactly the currency's
+// number of decimal places, e.g. "29.90".
+func (a Amount) String() string {
+	exp, _ := Exponent(a.Currency)
+	s := strconv.FormatInt(a.Minor, 10)
+	if exp == 0 {
+		return s
+	}
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+	return s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+// Config configures price handling.
+type Config struct {
+	// DecimalPrices makes the gateway emit prices as decimal strings with a
+	// currency field and accept them that way (plain JSON numbers are still
+	// accepted). Prices with more decimal places than the currency allows are
+	// rejected.
+	DecimalPrices bool `yaml:"decimal_prices"`
+
+	// Currency is the ISO 4217 code all backend prices are in. Default: USD.
+	Currency string `yaml:"currency"`
+
+	// BackendMinorUnits means the backend stores prices in minor units
+	// (2999 for 29.99) rather than major units.
+	BackendMinorUnits bool `yaml:"backend_minor_units"`
+}
+
+// Converter translates prices between clients and the backend.
+type Converter struct {
+	currency   string
+	minorUnits bool
+}
+
+// New returns a Converter for cfg.
+func New(cfg Config) (*Converter, error) {
+	if cfg.Currency == "" {
+		cfg.Currency = "USD"
+	}
+	if _, ok := Exponent(cfg.Currency); !ok {
+		return nil, fmt.Errorf("money: unsupported currency %q", cfg.Currency)
+	}
+	return &Converter{currency: strings.ToUpper(cfg.Currency), minorUnits: cfg.BackendMinorUnits}, nil
+}
+
+// Currency returns the currency of backend prices.
+func (c *Converter) Currency() string {
+	return c.currency
+}
+
+// ToBackend returns the backend representation of a.
+func (c *Converter) ToBackend(a Amount) float64 {
+	if c.minorUnits {
+		return float64(a.Minor)
+	}
+	return a.Float64()
+}
+
+// FromBackend converts a backend price.
+func (c *Converter) FromBackend(f float64) (Amount, error) {
+	if c.minorUnits {
+		minor := math.Round(f)
+		if math.IsNaN(minor) || minor < 0 || minor > maxMinor {
+			return Amount{}, ErrOutOfRange
+		}
+		return Amount{Minor: int64(minor), Currency: c.currency}, nil
+	}
+	return FromFloat(f, c.currency)
+}
+
+// Transform formats the "price" fields of a response in its JSON data
+// model (see render.Transform) as decimal strings and adds a "currency"
+// field next to them. Prices the backend sent out of range are left alone.
+func (c *Converter) Transform(v any) any {
+	switch v := v.(type) {
+	case []any:
+		for i, item := range v {
+			v[i] = c.Transform(item)
+		}
+	case map[string]any:
+		for k, child := range v {
+			if k == "price" {
+				continue
+			}
+			v[k] = c.Transform(child)
+		}
+		if n, ok := v["price"].(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				if a, err := c.FromBackend(f); err == nil {
+					v["price"] = a.String()
+					v["currency"] = c.currency
+				}
+			}
+		}
+	}
+	return v
+}
+
+// NormalizeRequest rewrites the "price" fields of a JSON request body to the
+// backend representation. Prices may be decimal strings or numbers and must
+// not have more decimal places than the currency allows; a "currency" field
+// next to them must match the backend currency and is removed.
+func (c *Converter) NormalizeRequest(body []byte) ([]byte, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if err := c.normalize(v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (c *Converter) normalize(v any) error {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			if err := c.normalize(item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for k, child := range v {
+			if k == "price" {
+				continue
+			}
+			if err := c.normalize(child); err != nil {
+				return err
+			}
+		}
+		if cur, ok := v["currency"]; ok {
+			if s, _ := cur.(string); !strings.EqualFold(s, c.currency) {
+				return fmt.Errorf("prices must be in %s", c.currency)
+			}
+			delete(v, "currency")
+		}
+		price, ok := v["price"]
+		if !ok {
+			return nil
+		}
+		var s string
+		switch p := price.(type) {
+		case string:
+			s = p
+		case json.Number:
+			s = p.String()
+		default:
+			return fmt.Errorf("price: %w", ErrInvalidAmount)
+		}
+		a, err := Parse(s, c.currency)
+		if err != nil {
+			return fmt.Errorf("price: %w", err)
+		}
+		v["price"] = c.ToBackend(a)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package money_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParse tests decimal parsing and precision validation
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		minor    int64
+		err      error
+	}{
+		{"29.99", "USD", 2999, nil},
+		{"29.9", "usd", 2990, nil},
+		{"29", "EUR", 2900, nil},
+		{"29.990", "USD", 2999, nil},
+		{"0.001", "KWD", 1, nil},
+		{"1500", "JPY", 1500, nil},
+		{"29.999", "USD", 0, money.ErrPrecision},
+		{"1.5", "JPY", 0, money.ErrPrecision},
+		{"-1.00", "USD", 0, money.ErrInvalidAmount},
+		{"1e3", "USD", 0, money.ErrInvalidAmount},
+		{".5", "USD", 0, money.ErrInvalidAmount},
+		{"5.", "USD", 0, money.ErrInvalidAmount},
+		{"99999999999999999999", "USD", 0, money.ErrOutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in+" "+tt.currency, func(t *testing.T) {
+			a, err := money.Parse(tt.in, tt.currency)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.minor, a.Minor)
+		})
+	}
+
+	_, err := money.Parse("1.00", "XXX")
+	assert.Error(t, err)
+}
+
+// TestAmount_String tests formatting with the currency's decimal places
+func TestAmount_String(t *testing.T) {
+	assert.Equal(t, "29.90", money.Amount{Minor: 2990, Currency: "USD"}.String())
+	assert.Equal(t, "0.05", money.Amount{Minor: 5, Currency: "EUR"}.String())
+	assert.Equal(t, "1.234", money.Amount{Minor: 1234, Currency: "BHD"}.String())
+	assert.Equal(t, "1500", money.Amount{Minor: 1500, Currency: "JPY"}.String())
+	assert.Equal(t, 29.99, money.Amount{Minor: 2999, Currency: "USD"}.Float64())
+
+	a, err := money.FromFloat(29.990000000000002, "USD")
+	require.NoError(t, err)
+	assert.Equal(t, "29.99", a.String())
+}
+
+// TestConverter_Transform tests response price formatting
+func TestConverter_Transform(t *testing.T) {
+	c, err := money.New(money.Config{})
+	require.NoError(t, err)
+	v := map[string]any{"products": []any{
+		map[string]any{"id": "p1", "price": json.Number("29.990000000000002")},
+		map[string]any{"id": "p2"},
+	}}
+	out, err := json.Marshal(c.Transform(v))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"products":[{"id":"p1","price":"29.99","currency":"USD"},{"id":"p2"}]}`, string(out))
+
+	minor, err := money.New(money.Config{Currency: "EUR", BackendMinorUnits: true})
+	require.NoError(t, err)
+	out, err = json.Marshal(minor.Transform(map[string]any{"price": json.Number("2999")}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"price":"29.99","currency":"EUR"}`, string(out))
+}
+
+// TestConverter_NormalizeRequest tests request price validation and conversion
+func TestConverter_NormalizeRequest(t *testing.T) {
+	c, err := money.New(money.Config{Currency: "USD"})
+	require.NoError(t, err)
+
+	out, err := c.NormalizeRequest([]byte(`{"product":{"name":"Tea","price":"29.99","currency":"usd"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"product":{"name":"Tea","price":29.99}}`, string(out))
+
+	out, err = c.NormalizeRequest([]byte(`{"product":{"price":5}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"product":{"price":5}}`, string(out))
+
+	_, err = c.NormalizeRequest([]byte(`{"product":{"price":"29.999"}}`))
+	assert.ErrorIs(t, err, money.ErrPrecision)
+	_, err = c.NormalizeRequest([]byte(`{"product":{"price":29.999}}`))
+	assert.ErrorIs(t, err, money.ErrPrecision)
+	_, err = c.NormalizeRequest([]byte(`{"product":{"price":"1.00","currency":"EUR"}}`))
+	assert.ErrorContains(t, err, "prices must be in USD")
+
+	minor, err := money.New(money.Config{BackendMinorUnits: true})
+	require.NoError(t, err)
+	out, err = minor.NormalizeRequest([]byte(`{"product":{"price":"29.99"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"product":{"price":2999}}`, string(out))
+
+	_, err = money.New(money.Config{Currency: "XXX"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,302 @@
+// Package notification dispatches notifications (emails, SMS, pushes) to the
+// notifications backend in the background. Send returns as soon as a
+// notification is queued; a bounded pool of workers delivers the queue,
+// retrying transient failures, and notifications that cannot be delivered
+// are written to a dead-letter log instead of being lost silently.
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// DefaultMethod is the RPC notifications are sent with. Its request is a
+// google.protobuf.Struct with the Message fields.
+const DefaultMethod = "/notifications.NotificationService/Send"
+
+// ErrQueueFull is returned by Send when the queue has no room left.
+var ErrQueueFull = errors.New("notification queue full")
+
+// ErrClosed is returned by Send after Close.
+var ErrClosed = errors.New("notification dispatcher closed")
+
+// Config configures notification dispatch.
+type Config struct {
+	// Method is the full gRPC method name of the send RPC. Default: DefaultMethod.
+	Method string `yaml:"method"`
+
+	// Workers is the number of concurrent deliveries. Default: 4.
+	Workers int `yaml:"workers"`
+
+	// QueueSize bounds the notifications waiting for a worker; Send fails
+	// with ErrQueueFull beyond it. Default: 1000.
+	QueueSize int `yaml:"queue_size"`
+
+	// MaxAttempts is the number of delivery attempts per notification. Default: 5.
+	MaxAttempts int `yaml:"max_attempts"`
+
+	// RetryBackoff is the delay before the first retry, doubled for each
+	// further one (with jitter). Default: 1s.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+
+	// Timeout bounds each delivery attempt. Default: 10s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// DeadLetterFile receives undeliverable notifications as JSON lines.
+	// When empty, they are only logged.
+	DeadLetterFile string `yaml:"dead_letter_file"`
+}
+
+// Message is a notification to send.
+type Message struct {
+	ID       string         `json:"id"`
+	Channel  string         `json:"channel"`
+	To       string         `json:"to"`
+	Subject  string         `json:"subject,omitempty"`
+	Body     string         `json:"body,omitempty"`
+	Template string         `json:"template,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+var (
+	dispatchedTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "notifications",
+		Name:      "total",
+		Help:      "Notifications by result (queued, rejected, sent, retried, dead_lettered).",
+	}, []string{"result"})
+
+	queueDepth = promauto.With(metrics.Registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "notifications",
+		Name:      "queue_depth",
+		Help:      "Notifications waiting for a worker.",
+	})
+)
+
+type job struct {
+	ctx context.Context
+	msg Message
+}
+
+// Dispatcher queues notifications and delivers them in the background.
+type Dispatcher struct {
+	cfg  Config
+	conn grpc.ClientConnInterface
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan job
+	wg     sync.WaitGroup
+
+	// stop aborts retry waits on Close
+	stop chan struct{}
+
+	deadMu sync.Mutex
+	dead   *os.File
+}
+
+// New starts the workers of a Dispatcher sending to conn.
+func New(cfg Config, conn grpc.ClientConnInterface) (*Dispatcher, error) {
+	if cfg.Method == "" {
+		cfg.Method = DefaultMethod
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	d := &Dispatcher{
+		cfg:   cfg,
+		conn:  conn,
+		queue: make(chan job, cfg.QueueSize),
+		stop:  make(chan struct{}),
+	}
+	if cfg.DeadLetterFile != "" {
+		f, err := os.OpenFile(cfg.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open dead-letter file: %w", err)
+		}
+		d.dead = f
+	}
+	for range cfg.Workers {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d, nil
+}
+
+// Send queues msg for delivery. Request-scoped values of ctx, such as the
+// caller's credentials, are kept for the backend call but its cancellation
+// is not, so delivery outlives the HTTP request.
+func (d *Dispatcher) Send(ctx context.Context, msg Message) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrClosed
+	}
+	select {
+	case d.queue <- job{ctx: context.WithoutCancel(ctx), msg: msg}:
+		dispatchedTotal.WithLabelValues("queued").Inc()
+		queueDepth.Inc()
+		return nil
+	default:
+		dispatchedTotal.WithLabelValues("rejected").Inc()
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting notifications and waits until the queue is drained
+// or ctx is done. Notifications still waiting for a retry when ctx is done
+// are dead-lettered.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		close(d.stop)
+		<-done
+		err = ctx.Err()
+	}
+	if d.dead != nil {
+		if cerr := d.dead.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for j := range d.queue {
+		queueDepth.Dec()
+		d.deliver(j)
+	}
+}
+
+func (d *Dispatcher) deliver(j job) {
+	req, err := structpb.NewStruct(j.msg.fields())
+	if err != nil {
+		d.deadLetter(j.msg, 0, err)
+		return
+	}
+
+	backoff := d.cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(j.ctx, d.cfg.Timeout)
+		err = d.conn.Invoke(ctx, d.cfg.Method, req, &emptypb.Empty{})
+		cancel()
+		if err == nil {
+			dispatchedTotal.WithLabelValues("sent").Inc()
+			return
+		}
+		if attempt >= d.cfg.MaxAttempts || !retryable(err) {
+			d.deadLetter(j.msg, attempt, err)
+			return
+		}
+
+		dispatchedTotal.WithLabelValues("retried").Inc()
+		wait := backoff/2 + rand.N(backoff)
+		backoff *= 2
+		select {
+		case <-time.After(wait):
+		case <-d.stop:
+			d.deadLetter(j.msg, attempt, err)
+			return
+		}
+	}
+}
+
+// retryable reports whether a failed delivery may succeed when repeated.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+func (m Message) fields() map[string]any {
+	fields := map[string]any{
+		"id":      m.ID,
+		"channel": m.Channel,
+		"to":      m.To,
+	}
+	for k, v := range map[string]string{"subject": m.Subject, "body": m.Body, "template": m.Template} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	if len(m.Data) > 0 {
+		fields["data"] = m.Data
+	}
+	return fields
+}
+
+type deadLetter struct {
+	Time     time.Time `json:"time"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Message  Message   `json:"message"`
+}
+
+func (d *Dispatcher) deadLetter(msg Message, attempts int, cause error) {
+	dispatchedTotal.WithLabelValues("dead_lettered").Inc()
+	logger.Logger().Error("Notification undeliverable",
+		zap.String("id", msg.ID),
+		zap.String("channel", msg.Channel),
+		zap.Int("attempts", attempts),
+		zap.String("code", status.Code(cause).String()),
+		zap.Error(cause),
+	)
+	if d.dead == nil {
+		return
+	}
+
+	line, _ := json.Marshal(deadLetter{Time: time.Now().UTC(), Attempts: attempts, Error: cause.Error(), Message: msg})
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+	if _, err := d.dead.Write(append(line, '\n')); err != nil {
+		logger.Logger().Error("Failed to write dead letter", zap.String("id", msg.ID), zap.Error(err))
+	}
+}
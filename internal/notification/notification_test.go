@@ -0,0 +1,146 @@
+package notification_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/notification"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// fakeConn records send RPCs and fails them with the queued errors
+type fakeConn struct {
+	mu       sync.Mutex
+	methods  []string
+	requests []map[string]any
+	errs     []error
+	block    chan struct{}
+}
+
+func (c *fakeConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.methods = append(c.methods, method)
+	c.requests = append(c.requests, args.(*structpb.Struct).AsMap())
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return err
+	}
+	return nil
+}
+
+func (c *fakeConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, "no streams")
+}
+
+func (c *fakeConn) calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.requests)
+}
+
+var welcome = notification.Message{ID: "n1", Channel: "email", To: "a@example.com", Subject: "Hi", Body: "Welcome", Data: map[string]any{"name": "Ann"}}
+
+// TestDispatcher_Send tests asynchronous delivery of the notification fields
+func TestDispatcher_Send(t *testing.T) {
+	conn := &fakeConn{}
+	d, err := notification.New(notification.Config{}, conn)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Send(context.Background(), welcome))
+	require.NoError(t, d.Close(context.Background()))
+
+	require.Equal(t, 1, conn.calls())
+	assert.Equal(t, notification.DefaultMethod, conn.methods[0])
+	assert.Equal(t, map[string]any{
+		"id": "n1", "channel": "email", "to": "a@example.com", "subject": "Hi", "body": "Welcome",
+		"data": map[string]any{"name": "Ann"},
+	}, conn.requests[0])
+	assert.ErrorIs(t, d.Send(context.Background(), welcome), notification.ErrClosed)
+}
+
+// TestDispatcher_Retry tests that transient failures are retried
+func TestDispatcher_Retry(t *testing.T) {
+	conn := &fakeConn{errs: []error{
+		status.Error(codes.Unavailable, "down"),
+		status.Error(codes.DeadlineExceeded, "slow"),
+	}}
+	dead := filepath.Join(t.TempDir(), "dead.jsonl")
+	d, err := notification.New(notification.Config{RetryBackoff: time.Millisecond, DeadLetterFile: dead}, conn)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Send(context.Background(), welcome))
+	require.NoError(t, d.Close(context.Background()))
+	assert.Equal(t, 3, conn.calls())
+
+	data, err := os.ReadFile(dead)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+// TestDispatcher_DeadLetter tests that undeliverable notifications are dead-lettered
+func TestDispatcher_DeadLetter(t *testing.T) {
+	conn := &fakeConn{errs: []error{
+		status.Error(codes.InvalidArgument, "bad address"),
+		status.Error(codes.Unavailable, "down"),
+		status.Error(codes.Unavailable, "down"),
+	}}
+	dead := filepath.Join(t.TempDir(), "dead.jsonl")
+	d, err := notification.New(notification.Config{Workers: 1, MaxAttempts: 2, RetryBackoff: time.Millisecond, DeadLetterFile: dead}, conn)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Send(context.Background(), welcome))
+	second := welcome
+	second.ID = "n2"
+	require.NoError(t, d.Send(context.Background(), second))
+	require.NoError(t, d.Close(context.Background()))
+	assert.Equal(t, 3, conn.calls())
+
+	data, err := os.ReadFile(dead)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var entry struct {
+		Attempts int
+		Error    string
+		Message  notification.Message
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, 1, entry.Attempts)
+	assert.Contains(t, entry.Error, "bad address")
+	assert.Equal(t, "n1", entry.Message.ID)
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, 2, entry.Attempts)
+	assert.Equal(t, "n2", entry.Message.ID)
+}
+
+// TestDispatcher_QueueFull tests that Send never blocks on a full queue
+func TestDispatcher_QueueFull(t *testing.T) {
+	conn := &fakeConn{block: make(chan struct{})}
+	d, err := notification.New(notification.Config{Workers: 1, QueueSize: 1}, conn)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Send(context.Background(), welcome))
+	// wait for the worker to pick up the first notification and block
+	require.Eventually(t, func() bool { return d.Send(context.Background(), welcome) == nil }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, d.Send(context.Background(), welcome), notification.ErrQueueFull)
+
+	close(conn.block)
+	require.NoError(t, d.Close(context.Background()))
+	assert.Equal(t, 2, conn.calls())
+}
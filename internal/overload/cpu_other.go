@@ -0,0 +1,10 @@
+//go:build !unix
+
+package overload
+
+import "time"
+
+// processCPUTime is not available on this platform; the CPU signal is ignored.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
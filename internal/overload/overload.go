@@ -0,0 +1,352 @@
+// Package overload sheds low-priority requests when the gateway is saturated.
+// A controller samples p99 latency, in-flight requests and process CPU once
+// per interval and raises or lowers a rejection rate; low-priority requests
+// are shed first, critical ones (health checks, auth) never.
+package overload
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Priority orders requests for shedding.
+type Priority int
+
+const (
+	Low Priority = iota
+	Normal
+	High
+	Critical
+)
+
+var priorityNames = []string{"low", "normal", "high", "critical"}
+
+func (p Priority) String() string {
+	return priorityNames[p]
+}
+
+// ParsePriority parses "low", "normal", "high" or "critical".
+func ParsePriority(s string) (Priority, error) {
+	for i, name := range priorityNames {
+		if strings.EqualFold(s, name) {
+			return Priority(i), nil
+		}
+	}
+	return Normal, fmt.Errorf("unknown priority %q", s)
+}
+
+// Config configures the overload controller. It is disabled unless at least
+// one of TargetP99, MaxInFlight or MaxCPU is set.
+type Config struct {
+	// TargetP99 is the request latency p99 above which the gateway counts as saturated.
+	TargetP99 time.Duration `yaml:"target_p99"`
+
+	// MaxInFlight is the number of concurrent requests above which the gateway counts as saturated.
+	MaxInFlight int `yaml:"max_in_flight"`
+
+	// MaxCPU is the process CPU utilisation (0-1, relative to all cores)
+	// above which the gateway counts as saturated.
+	MaxCPU float64 `yaml:"max_cpu"`
+
+	// Interval is how often the signals are evaluated. Default: 1s.
+	Interval time.Duration `yaml:"interval"`
+
+	// Step is how much the rejection rate moves per interval. Default: 0.1.
+	Step float64 `yaml:"step"`
+
+	// Routes assign priorities by path prefix (longest match wins). /health,
+	// /metrics, /auth and /admin are critical unless overridden; everything
+	// else defaults to normal.
+	Routes []RouteConfig `yaml:"routes"`
+
+	// PriorityHeader lets clients lower the priority of their requests below
+	// that of the route, e.g. batch jobs declaring themselves "low". Higher
+	// priorities are ignored.
+	PriorityHeader string `yaml:"priority_header"`
+
+	// RetryAfter is sent with shed requests. Default: 1s.
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// RouteConfig assigns a priority to a path prefix.
+type RouteConfig struct {
+	PathPrefix string `yaml:"path_prefix"`
+	Priority   string `yaml:"priority"`
+}
+
+// maxRate keeps a trickle of high-priority traffic flowing even under
+// sustained overload, so the controller can observe recovery.
+const maxRate = 0.95
+
+var (
+	rejectionRate = promauto.With(metrics.Registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "overload",
+		Name:      "rejection_rate",
+		Help:      "Current load shedding level (0-1).",
+	})
+
+	latencyP99 = promauto.With(metrics.Registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "overload",
+		Name:      "latency_p99_seconds",
+		Help:      "Request latency p99 over the last interval.",
+	})
+
+	cpuUtilisation = promauto.With(metrics.Registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "overload",
+		Name:      "cpu_utilisation",
+		Help:      "Process CPU utilisation over the last interval (0-1).",
+	})
+
+	shedTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "overload",
+		Name:      "shed_total",
+		Help:      "Requests rejected by the overload controller.",
+	}, []string{"priority"})
+)
+
+// maxSamples bounds the latency samples kept per interval.
+const maxSamples = 4096
+
+// Controller tracks load and decides which requests to shed.
+type Controller struct {
+	cfg        Config
+	routes     []route
+	retryAfter int
+
+	inFlight atomic.Int64
+	rate     atomic.Uint64 // math.Float64bits
+
+	mu      sync.Mutex
+	samples []time.Duration
+	seen    int
+
+	stop chan struct{}
+}
+
+type route struct {
+	prefix   string
+	priority Priority
+}
+
+// New validates cfg and starts the controller. It returns nil when disabled;
+// a nil Controller passes every request through.
+func New(cfg Config) (*Controller, error) {
+	if cfg.TargetP99 <= 0 && cfg.MaxInFlight <= 0 && cfg.MaxCPU <= 0 {
+		return nil, nil
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Step <= 0 {
+		cfg.Step = 0.1
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = time.Second
+	}
+
+	c := &Controller{
+		cfg:        cfg,
+		retryAfter: int(math.Ceil(cfg.RetryAfter.Seconds())),
+		stop:       make(chan struct{}),
+	}
+	for _, prefix := range []string{"/health", "/metrics", "/auth", "/admin"} {
+		c.routes = append(c.routes, route{prefix: prefix, priority: Critical})
+	}
+	for _, rc := range cfg.Routes {
+		p, err := ParsePriority(rc.Priority)
+		if err != nil {
+			return nil, fmt.Errorf("overload route %s: %w", rc.PathPrefix, err)
+		}
+		c.routes = append(c.routes, route{prefix: rc.PathPrefix, priority: p})
+	}
+	// longest prefix first; for equal prefixes the configured route (later) wins
+	sort.SliceStable(c.routes, func(i, j int) bool {
+		return len(c.routes[i].prefix) > len(c.routes[j].prefix)
+	})
+	for i := 0; i+1 < len(c.routes); i++ {
+		if c.routes[i].prefix == c.routes[i+1].prefix {
+			c.routes = append(c.routes[:i], c.routes[i+1:]...)
+			i--
+		}
+	}
+
+	go c.run()
+	return c, nil
+}
+
+// Close stops the controller.
+func (c *Controller) Close() {
+	if c != nil {
+		close(c.stop)
+	}
+}
+
+// Rate returns the current rejection rate.
+func (c *Controller) Rate() float64 {
+	return math.Float64frombits(c.rate.Load())
+}
+
+func (c *Controller) run() {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	lastCPU, cpuOK := processCPUTime()
+	lastTick := time.Now()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			saturated := false
+
+			if p99 := c.takeP99(); c.cfg.TargetP99 > 0 {
+				latencyP99.Set(p99.Seconds())
+				saturated = saturated || p99 > c.cfg.TargetP99
+			}
+			if c.cfg.MaxInFlight > 0 {
+				saturated = saturated || c.inFlight.Load() > int64(c.cfg.MaxInFlight)
+			}
+			if cpu, ok := processCPUTime(); ok && cpuOK {
+				util := float64(cpu-lastCPU) / float64(now.Sub(lastTick)) / float64(runtime.GOMAXPROCS(0))
+				cpuUtilisation.Set(util)
+				if c.cfg.MaxCPU > 0 {
+					saturated = saturated || util > c.cfg.MaxCPU
+				}
+				lastCPU = cpu
+			}
+			lastTick = now
+
+			c.adjust(saturated)
+		}
+	}
+}
+
+func (c *Controller) adjust(saturated bool) {
+	prev := c.Rate()
+	rate := prev
+	if saturated {
+		rate = math.Min(maxRate, rate+c.cfg.Step)
+	} else {
+		rate = math.Max(0, rate-c.cfg.Step)
+	}
+	if rate == prev {
+		return
+	}
+	c.rate.Store(math.Float64bits(rate))
+	rejectionRate.Set(rate)
+	if prev == 0 {
+		logger.Logger().Warn("Gateway overloaded, shedding low-priority requests", zap.Float64("rate", rate))
+	} else if rate == 0 {
+		logger.Logger().Info("Gateway load back to normal")
+	}
+}
+
+// record keeps a latency sample, using reservoir sampling once full.
+func (c *Controller) record(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen++
+	if len(c.samples) < maxSamples {
+		c.samples = append(c.samples, d)
+	} else if i := rand.IntN(c.seen); i < maxSamples {
+		c.samples[i] = d
+	}
+}
+
+func (c *Controller) takeP99() time.Duration {
+	c.mu.Lock()
+	samples := c.samples
+	c.samples = make([]time.Duration, 0, len(samples))
+	c.seen = 0
+	c.mu.Unlock()
+
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[(len(samples)*99)/100]
+}
+
+// Priority returns the priority of r: that of its route, or the lower one
+// its priority header asks for.
+func (c *Controller) Priority(r *http.Request) Priority {
+	p := Normal
+	for _, rt := range c.routes {
+		if strings.HasPrefix(r.URL.Path, rt.prefix) {
+			p = rt.priority
+			break
+		}
+	}
+	if p == Critical || c.cfg.PriorityHeader == "" {
+		return p
+	}
+	if v := r.Header.Get(c.cfg.PriorityHeader); v != "" {
+		if hp, err := ParsePriority(v); err == nil && hp < p {
+			return hp
+		}
+	}
+	return p
+}
+
+// shed decides whether to reject a request of priority p. The rate is split
+// in thirds: low-priority requests are shed first, then normal, then high.
+func (c *Controller) shed(p Priority) bool {
+	rate := c.Rate()
+	if rate == 0 || p == Critical {
+		return false
+	}
+	prob := math.Max(0, math.Min(1, 3*rate-float64(p)))
+	return prob > 0 && rand.Float64() < prob
+}
+
+// Middleware measures requests and sheds them according to their priority.
+func (c *Controller) Middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := c.Priority(r)
+		if c.shed(p) {
+			shedTotal.WithLabelValues(p.String()).Inc()
+			message := i18n.Message(w, r, "overloaded")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(c.retryAfter))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]any{
+				"error":               "overloaded",
+				"message":             message,
+				"retry_after_seconds": c.retryAfter,
+			})
+			return
+		}
+
+		c.inFlight.Add(1)
+		start := time.Now()
+		defer func() {
+			c.record(time.Since(start))
+			c.inFlight.Add(-1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,100 @@
+package overload_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/overload"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(h http.Handler, path string, header http.Header) int {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+// TestController_ShedsByPriority tests that a latency overload sheds low priority traffic but keeps critical routes alive
+func TestController_ShedsByPriority(t *testing.T) {
+	c, err := overload.New(overload.Config{
+		TargetP99: time.Millisecond,
+		Interval:  10 * time.Millisecond,
+		Step:      0.5,
+		Routes:    []overload.RouteConfig{{PathPrefix: "/inventory/list", Priority: "low"}},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+
+	require.Eventually(t, func() bool {
+		serve(h, "/slow", nil)
+		return c.Rate() > 0.9
+	}, 2*time.Second, time.Millisecond)
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve(h, "/inventory/list", nil))
+	assert.Equal(t, http.StatusOK, serve(h, "/health", nil))
+	assert.Equal(t, http.StatusOK, serve(h, "/auth/login", nil))
+
+	// without slow requests the controller recovers
+	require.Eventually(t, func() bool { return c.Rate() == 0 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, http.StatusOK, serve(h, "/inventory/list", nil))
+}
+
+// TestController_Priority tests route and header based priorities
+func TestController_Priority(t *testing.T) {
+	c, err := overload.New(overload.Config{
+		MaxInFlight:    100,
+		PriorityHeader: "X-Priority",
+		Routes: []overload.RouteConfig{
+			{PathPrefix: "/inventory", Priority: "high"},
+			{PathPrefix: "/inventory/list", Priority: "low"},
+			{PathPrefix: "/auth/register", Priority: "normal"},
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	prio := func(path, header string) overload.Priority {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if header != "" {
+			r.Header.Set("X-Priority", header)
+		}
+		return c.Priority(r)
+	}
+
+	assert.Equal(t, overload.High, prio("/inventory/get", ""))
+	assert.Equal(t, overload.Low, prio("/inventory/list", ""))
+	assert.Equal(t, overload.Critical, prio("/auth/login", ""))
+	assert.Equal(t, overload.Normal, prio("/auth/register", ""))
+	assert.Equal(t, overload.Normal, prio("/other", ""))
+	assert.Equal(t, overload.Low, prio("/inventory/get", "low"))
+	// clients cannot promote themselves
+	assert.Equal(t, overload.High, prio("/inventory/get", "critical"))
+	assert.Equal(t, overload.Low, prio("/inventory/list", "high"))
+	assert.Equal(t, overload.Normal, prio("/other", "high"))
+	assert.Equal(t, overload.Low, prio("/other", "low"))
+	assert.Equal(t, overload.Critical, prio("/health", "low"))
+}
+
+// TestNew_Disabled tests that an unconfigured controller passes everything through
+func TestNew_Disabled(t *testing.T) {
+	c, err := overload.New(overload.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, c)
+	assert.Equal(t, http.StatusNotFound, serve(c.Middleware(http.NotFoundHandler()), "/", nil))
+
+	_, err = overload.New(overload.Config{MaxCPU: 0.8, Routes: []overload.RouteConfig{{PathPrefix: "/", Priority: "urgent"}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package pagination_test
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/pagination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPager_RoundTrip tests that cursors decode to what was encoded
+func TestPager_RoundTrip(t *testing.T) {
+	p, err := pagination.New(pagination.Config{Secret: "s3cret"})
+	require.NoError(t, err)
+
+	c := pagination.Cursor{Offset: 40, PageSize: 20, Filter: "tea", OrderBy: "price"}
+	got, err := p.Decode(p.Encode(c))
+	require.NoError(t, err)
+	assert.Equal(t, c, got)
+}
+
+// TestPager_Decode_Rejects tests that tampered and foreign cursors are rejected
+func TestPager_Decode_Rejects(t *testing.T) {
+	p, err := pagination.New(pagination.Config{Secret: "s3cret"})
+	require.NoError(t, err)
+	other, err := pagination.New(pagination.Config{Secret: "other"})
+	require.NoError(t, err)
+
+	valid := p.Encode(pagination.Cursor{Offset: 40, PageSize: 20})
+	forged := other.Encode(pagination.Cursor{Offset: 1000000, PageSize: 20})
+	for _, s := range []string{"", "garbage", "a.b", valid[:len(valid)-2], forged} {
+		_, err := p.Decode(s)
+		assert.ErrorIs(t, err, pagination.ErrInvalidCursor, s)
+	}
+}
+
+// TestPager_PageSize tests the default and maximum page size
+func TestPager_PageSize(t *testing.T) {
+	p, err := pagination.New(pagination.Config{DefaultPageSize: 10, MaxPageSize: 50})
+	require.NoError(t, err)
+	assert.Equal(t, int32(10), p.PageSize(0))
+	assert.Equal(t, int32(25), p.PageSize(25))
+	assert.Equal(t, int32(50), p.PageSize(1000000))
+
+	_, err = pagination.New(pagination.Config{DefaultPageSize: 100, MaxPageSize: 50})
+	assert.Error(t, err)
+}
+
+// TestPager_Links tests next and prev links
+func TestPager_Links(t *testing.T) {
+	p, err := pagination.New(pagination.Config{})
+	require.NoError(t, err)
+	u, _ := url.Parse("/inventory/list?cursor=old&lang=en")
+	re := regexp.MustCompile(`<(/inventory/list\?[^>]+)>; rel="(next|prev)"`)
+
+	decode := func(links string) map[string]pagination.Cursor {
+		out := map[string]pagination.Cursor{}
+		for _, m := range re.FindAllStringSubmatch(links, -1) {
+			target, err := url.Parse(m[1])
+			require.NoError(t, err)
+			assert.Equal(t, "en", target.Query().Get("lang"))
+			c, err := p.Decode(target.Query().Get("cursor"))
+			require.NoError(t, err)
+			out[m[2]] = c
+		}
+		return out
+	}
+
+	links := decode(p.Links(u, pagination.Cursor{Offset: 10, PageSize: 20, Filter: "tea"}, 20))
+	assert.Equal(t, pagination.Cursor{Offset: 30, PageSize: 20, Filter: "tea"}, links["next"])
+	assert.Equal(t, pagination.Cursor{Offset: 0, PageSize: 20, Filter: "tea"}, links["prev"])
+
+	assert.Empty(t, p.Links(u, pagination.Cursor{Offset: 0, PageSize: 20}, 5))
+}
@@ -0,0 +1,388 @@
+// Package passthrough serves HTTP routes declared in the configuration by
+// calling a backend RPC. The request message is built from the JSON
+// body, then from the path and query parameters, matched to top-level fields
+// by name; the response message is rendered like any other response. The
+// responses of server-streaming RPCs are streamed as NDJSON or SSE, and the
+// bodies of client-streaming RPCs are uploaded as a stream of messages.
+//
+// Declared RPCs are resolved from the protobuf descriptors linked into the
+// gateway, so only methods of the services it was built with can be declared.
+// POST /rpc/{service}/{method} calls allowed RPCs of any service instead,
+// resolved from the schemas fetched from the backends.
+package passthrough
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Auth policies.
+const (
+	// Token requires a valid access token. It is the default.
+	Token = "token"
+	// Public serves the route without an access token.
+	Public = "public"
+	// Optional serves the route without an access token, but with the
+	// caller's identity when it sends a valid one.
+	Optional = "optional"
+)
+
+// Route declares an endpoint served by a backend RPC.
+type Route struct {
+	// Method is the HTTP method. Default: POST.
+	Method string `yaml:"method"`
+
+	// Path is the chi pattern of the route, e.g. /catalog/products/{id}.
+	Path string `yaml:"path"`
+
+	// Backend is the backend pool the RPC is sent to, e.g. inventory.
+	Backend string `yaml:"backend"`
+
+	// RPC is the full method name, e.g.
+	// inventory.InventoryService/GetProduct.
+	RPC string `yaml:"rpc"`
+
+	// Auth is "token" (default), "public" or "optional".
+	Auth string `yaml:"auth"`
+
+	// Roles, when set, restrict the route to tokens with one of them.
+	Roles []string `yaml:"roles"`
+
+	// Timeout bounds the backend call. Default: the grpc_client timeout.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// RateLimit limits the requests of each client IP to the route.
+	RateLimit RateLimit `yaml:"rate_limit"`
+
+	// Upload configures how the body is streamed to a client-streaming RPC.
+	Upload Upload `yaml:"upload"`
+
+	// Methods declares the path once for several HTTP methods, each with
+	// its own RPC and, optionally, auth policy and roles, which default to
+	// those of the route. It replaces Method and RPC.
+	Methods map[string]MethodRoute `yaml:"methods"`
+}
+
+// MethodRoute declares one HTTP method of a Route with Methods.
+type MethodRoute struct {
+	// RPC is the full method name, e.g. inventory.InventoryService/GetProduct.
+	RPC string `yaml:"rpc"`
+
+	// Auth is "token", "public" or "optional". Default: the auth of the
+	// route.
+	Auth string `yaml:"auth"`
+
+	// Roles replace the roles of the route when set.
+	Roles []string `yaml:"roles"`
+}
+
+// Expand returns the routes rt declares: rt itself, or one route per
+// method of Methods, sorted by method.
+func (rt Route) Expand() ([]Route, error) {
+	if len(rt.Methods) == 0 {
+		return []Route{rt}, nil
+	}
+	if rt.Method != "" || rt.RPC != "" {
+		return nil, fmt.Errorf("route %s: methods replace method and rpc", rt.Path)
+	}
+	byMethod := make(map[string]MethodRoute, len(rt.Methods))
+	for m, mr := range rt.Methods {
+		m = strings.ToUpper(m)
+		if _, ok := byMethod[m]; ok {
+			return nil, fmt.Errorf("route %s: method %s is declared twice", rt.Path, m)
+		}
+		byMethod[m] = mr
+	}
+	methods := make([]string, 0, len(byMethod))
+	for m := range byMethod {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	routes := make([]Route, 0, len(methods))
+	for _, m := range methods {
+		mr := byMethod[m]
+		route := rt
+		route.Methods = nil
+		route.Method, route.RPC = m, mr.RPC
+		if mr.Auth != "" {
+			route.Auth = mr.Auth
+		}
+		if mr.Roles != nil {
+			route.Roles = mr.Roles
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// Endpoint serves a Route.
+type Endpoint struct {
+	Route
+
+	method  protoreflect.MethodDescriptor
+	conn    grpc.ClientConnInterface
+	limiter *limiter
+	upload  *uploader
+}
+
+// New resolves the RPC of rt and returns its endpoint, calling conn.
+func New(rt Route, conn grpc.ClientConnInterface) (*Endpoint, error) {
+	if rt.Method == "" {
+		rt.Method = http.MethodPost
+	}
+	rt.Method = strings.ToUpper(rt.Method)
+	if !strings.HasPrefix(rt.Path, "/") {
+		return nil, fmt.Errorf("route path %q must start with /", rt.Path)
+	}
+	switch rt.Auth {
+	case "":
+		rt.Auth = Token
+	case Token, Public, Optional:
+	default:
+		return nil, fmt.Errorf("route %s: unknown auth policy %q", rt.Path, rt.Auth)
+	}
+	if rt.Auth != Token && len(rt.Roles) > 0 {
+		return nil, fmt.Errorf("route %s: roles need the token auth policy", rt.Path)
+	}
+	if conn == nil {
+		return nil, fmt.Errorf("route %s: backend %q is not configured", rt.Path, rt.Backend)
+	}
+	method, err := findMethod(protoregistry.GlobalFiles, rt.RPC)
+	if err != nil {
+		return nil, fmt.Errorf("route %s: %w", rt.Path, err)
+	}
+	if !method.IsStreamingClient() && rt.Upload != (Upload{}) {
+		return nil, fmt.Errorf("route %s: upload needs a client-streaming rpc", rt.Path)
+	}
+	upload, err := newUploader(rt.Upload, method.Input())
+	if err != nil {
+		return nil, fmt.Errorf("route %s: %w", rt.Path, err)
+	}
+	return &Endpoint{Route: rt, method: method, conn: conn, limiter: newLimiter(rt.RateLimit), upload: upload}, nil
+}
+
+// MediaTypes returns the request media types the endpoint accepts besides
+// JSON: those of uploads, for client-streaming RPCs.
+func (e *Endpoint) MediaTypes() []string {
+	if !e.method.IsStreamingClient() {
+		return nil
+	}
+	return e.upload.mediaTypes()
+}
+
+// findMethod resolves a method that is not bidirectional streaming, named
+// "pkg.Service/Method" or "/pkg.Service/Method".
+func findMethod(files *protoregistry.Files, rpc string) (protoreflect.MethodDescriptor, error) {
+	service, name, ok := strings.Cut(strings.TrimPrefix(rpc, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("rpc %q is not of the form package.Service/Method", rpc)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("unknown service %s", service)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	return lookupMethod(sd, name)
+}
+
+// lookupMethod returns the method name of sd, unless bidirectional.
+func lookupMethod(sd protoreflect.ServiceDescriptor, name string) (protoreflect.MethodDescriptor, error) {
+	method := sd.Methods().ByName(protoreflect.Name(name))
+	if method == nil {
+		return nil, fmt.Errorf("service %s has no method %s", sd.FullName(), name)
+	}
+	if method.IsStreamingClient() && method.IsStreamingServer() {
+		return nil, fmt.Errorf("%s/%s is a bidirectional streaming method", sd.FullName(), name)
+	}
+	return method, nil
+}
+
+func (e *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !hasRole(r, e.Roles) {
+		http.Error(w, e.Method+" "+e.Path+" requires role "+strings.Join(e.Roles, " or "), http.StatusForbidden)
+		return
+	}
+	if !e.limiter.allow(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	serve(ctx, w, r, e.conn, e.method, e.upload)
+}
+
+// hasRole reports whether r carries a verified token with one of roles, or
+// roles is empty.
+func hasRole(r *http.Request, roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	claims, ok := token.FromContext(r.Context())
+	return ok && claims.Verified && claims.HasRole(roles...)
+}
+
+// serve decodes the request message of method from r, invokes method on
+// conn and writes the response, or streams the responses of a
+// server-streaming method. The body of a client-streaming method is
+// uploaded by up.
+func serve(ctx context.Context, w http.ResponseWriter, r *http.Request, conn grpc.ClientConnInterface, method protoreflect.MethodDescriptor, up *uploader) {
+	if method.IsStreamingClient() {
+		up.serve(ctx, w, r, conn, method)
+		return
+	}
+	in, err := decode(r, method.Input())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if method.IsStreamingServer() {
+		serveStream(ctx, w, r, conn, method, in)
+		return
+	}
+	out := dynamicpb.NewMessage(method.Output())
+	if err := conn.Invoke(ctx, fullMethod(method), in, out); err != nil {
+		st := status.Convert(err)
+		http.Error(w, st.Message(), HTTPStatus(st.Code()))
+		return
+	}
+	tree, err := jsonTree(out)
+	if err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+	if err := render.Write(w, r, http.StatusOK, tree); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+	}
+}
+
+// fullMethod returns the gRPC method name of method, /pkg.Service/Method.
+func fullMethod(method protoreflect.MethodDescriptor) string {
+	return "/" + string(method.Parent().FullName()) + "/" + string(method.Name())
+}
+
+// jsonTree returns msg in the JSON data model that render expects of every
+// response, with proto field names.
+func jsonTree(msg proto.Message) (any, error) {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree any
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// decode builds a message of desc from the JSON body of r, then sets the
+// path and query parameters naming its top-level fields.
+func decode(r *http.Request, desc protoreflect.MessageDescriptor) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(desc)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.New("failed to read request body")
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, msg); err != nil {
+			return nil, fmt.Errorf("invalid request body: %v", err)
+		}
+	}
+	if err := setParams(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// setParams sets the path and query parameters of r naming top-level fields
+// of msg.
+func setParams(r *http.Request, msg *dynamicpb.Message) error {
+	params := map[string]string{}
+	for name, values := range r.URL.Query() {
+		if name != render.FieldsParam && len(values) > 0 {
+			params[name] = values[0]
+		}
+	}
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		for i, key := range rctx.URLParams.Keys {
+			params[key] = rctx.URLParams.Values[i]
+		}
+	}
+	for name, value := range params {
+		if err := setNamed(msg, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setNamed sets the top-level field of msg named name, by proto or JSON
+// name, if any.
+func setNamed(msg *dynamicpb.Message, name, value string) error {
+	fields := msg.Descriptor().Fields()
+	fd := fields.ByName(protoreflect.Name(name))
+	if fd == nil {
+		fd = fields.ByJSONName(name)
+	}
+	if fd == nil {
+		return nil
+	}
+	if err := setField(msg, fd, value); err != nil {
+		return fmt.Errorf("invalid parameter %s: %v", name, err)
+	}
+	return nil
+}
+
+// setField sets the scalar field fd of msg from its text form.
+func setField(msg *dynamicpb.Message, fd protoreflect.FieldDescriptor, value string) error {
+	if fd.IsList() || fd.IsMap() || fd.Message() != nil {
+		return errors.New("only scalar fields can be set from parameters")
+	}
+	if fd.Kind() == protoreflect.BoolKind {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("not a valid bool")
+		}
+		msg.Set(fd, protoreflect.ValueOfBool(b))
+		return nil
+	}
+	// protojson parses numbers, enums and bytes from JSON strings too
+	quoted, err := json.Marshal(map[string]string{fd.JSONName(): value})
+	if err != nil {
+		return err
+	}
+	tmp := dynamicpb.NewMessage(fd.ContainingMessage())
+	if err := protojson.Unmarshal(quoted, tmp); err != nil {
+		return fmt.Errorf("not a valid %s", fd.Kind())
+	}
+	msg.Set(fd, tmp.Get(fd))
+	return nil
+}
@@ -0,0 +1,185 @@
+package passthrough_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/passthrough"
+	"github.com/andro-kes/gateway/internal/token"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+type inventoryServer struct {
+	pbInv.UnimplementedInventoryServiceServer
+}
+
+func (inventoryServer) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	if in.Id == "missing" {
+		return nil, status.Error(codes.NotFound, "product not found")
+	}
+	return &pbInv.GetResponse{Product: &pbInv.Product{Id: in.Id, Name: "Tea", Quantity: 3}}, nil
+}
+
+func (inventoryServer) ListProducts(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error) {
+	return &pbInv.ListResponse{Products: []*pbInv.Product{{Name: in.Filter}}, TotalSize: in.PageSize}, nil
+}
+
+func dial(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pbInv.RegisterInventoryServiceServer(srv, inventoryServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func serve(t *testing.T, ep *passthrough.Endpoint, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	r := chi.NewRouter()
+	r.Method(ep.Method, ep.Path, ep)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// TestEndpoint tests that requests are built from the body, path and query and that backend errors map to HTTP statuses
+func TestEndpoint(t *testing.T) {
+	conn := dial(t)
+
+	get, err := passthrough.New(passthrough.Route{Method: "get", Path: "/catalog/{id}", Backend: "inventory", RPC: "inventory.InventoryService/GetProduct"}, conn)
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodGet, get.Method)
+
+	w := serve(t, get, httptest.NewRequest(http.MethodGet, "/catalog/p1", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"product":{"id":"p1","name":"Tea","quantity":3}}`, w.Body.String())
+
+	w = serve(t, get, httptest.NewRequest(http.MethodGet, "/catalog/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "product not found")
+
+	list, err := passthrough.New(passthrough.Route{Path: "/catalog/search", Backend: "inventory", RPC: "/inventory.InventoryService/ListProducts"}, conn)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/catalog/search?page_size=7", strings.NewReader(`{"filter":"tea","unknown":1}`))
+	w = serve(t, list, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"products":[{"name":"tea"}],"total_size":7}`, w.Body.String())
+
+	w = serve(t, list, httptest.NewRequest(http.MethodPost, "/catalog/search?pageSize=many", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid parameter pageSize")
+
+	w = serve(t, list, httptest.NewRequest(http.MethodPost, "/catalog/search", strings.NewReader(`{"filter":`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestEndpoint_Roles tests that routes with roles require a verified token with one of them
+func TestEndpoint_Roles(t *testing.T) {
+	ep, err := passthrough.New(passthrough.Route{Method: "GET", Path: "/catalog/{id}", RPC: "inventory.InventoryService/GetProduct", Roles: []string{"admin"}}, dial(t))
+	require.NoError(t, err)
+
+	w := serve(t, ep, httptest.NewRequest(http.MethodGet, "/catalog/p1", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/catalog/p1", nil)
+	req = req.WithContext(token.WithClaims(req.Context(), &token.Claims{UserID: "u1", Roles: []string{"admin"}, Verified: true}))
+	w = serve(t, ep, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestRoute_Expand tests that a route with methods declares one route per method, each with its own policy
+func TestRoute_Expand(t *testing.T) {
+	rt := passthrough.Route{
+		Path:    "/catalog/products/{id}",
+		Backend: "inventory",
+		Roles:   []string{"inventory:write"},
+		Methods: map[string]passthrough.MethodRoute{
+			"get":    {RPC: "inventory.InventoryService/GetProduct", Auth: passthrough.Public, Roles: []string{}},
+			"PUT":    {RPC: "inventory.InventoryService/UpdateProduct"},
+			"DELETE": {RPC: "inventory.InventoryService/DeleteProduct", Roles: []string{"admin"}},
+		},
+	}
+	routes, err := rt.Expand()
+	require.NoError(t, err)
+	require.Len(t, routes, 3)
+	assert.Equal(t, passthrough.Route{Method: "DELETE", Path: rt.Path, Backend: "inventory", RPC: "inventory.InventoryService/DeleteProduct", Roles: []string{"admin"}}, routes[0])
+	assert.Equal(t, passthrough.Route{Method: "GET", Path: rt.Path, Backend: "inventory", RPC: "inventory.InventoryService/GetProduct", Auth: passthrough.Public, Roles: []string{}}, routes[1])
+	assert.Equal(t, passthrough.Route{Method: "PUT", Path: rt.Path, Backend: "inventory", RPC: "inventory.InventoryService/UpdateProduct", Roles: []string{"inventory:write"}}, routes[2])
+
+	plain := passthrough.Route{Method: "GET", Path: "/catalog", RPC: "inventory.InventoryService/ListProducts"}
+	routes, err = plain.Expand()
+	require.NoError(t, err)
+	assert.Equal(t, []passthrough.Route{plain}, routes)
+
+	rt.Method = "GET"
+	_, err = rt.Expand()
+	assert.ErrorContains(t, err, "methods replace method and rpc")
+
+	twice := passthrough.Route{Path: "/catalog", Methods: map[string]passthrough.MethodRoute{"get": {}, "GET": {}}}
+	_, err = twice.Expand()
+	assert.ErrorContains(t, err, "method GET is declared twice")
+}
+
+// TestEndpoint_RateLimit tests that each client IP gets its own budget
+func TestEndpoint_RateLimit(t *testing.T) {
+	ep, err := passthrough.New(passthrough.Route{Method: "GET", Path: "/catalog/{id}", RPC: "inventory.InventoryService/GetProduct", RateLimit: passthrough.RateLimit{Requests: 2}}, dial(t))
+	require.NoError(t, err)
+
+	request := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/catalog/p1", nil)
+		req.RemoteAddr = ip + ":1234"
+		return serve(t, ep, req)
+	}
+	assert.Equal(t, http.StatusOK, request("10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.1").Code)
+	w := request("10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, request("10.0.0.2").Code)
+}
+
+// TestNew tests the validation of declared routes
+func TestNew(t *testing.T) {
+	conn := dial(t)
+	rpc := "inventory.InventoryService/GetProduct"
+	tests := []struct {
+		name  string
+		route passthrough.Route
+		conn  grpc.ClientConnInterface
+		err   string
+	}{
+		{"relative path", passthrough.Route{Path: "catalog", RPC: rpc}, conn, "must start with /"},
+		{"unknown auth", passthrough.Route{Path: "/catalog", RPC: rpc, Auth: "basic"}, conn, "unknown auth policy"},
+		{"public roles", passthrough.Route{Path: "/catalog", RPC: rpc, Auth: passthrough.Public, Roles: []string{"admin"}}, conn, "roles need the token auth policy"},
+		{"optional roles", passthrough.Route{Path: "/catalog", RPC: rpc, Auth: passthrough.Optional, Roles: []string{"admin"}}, conn, "roles need the token auth policy"},
+		{"no backend", passthrough.Route{Path: "/catalog", Backend: "billing", RPC: rpc}, nil, `backend "billing" is not configured`},
+		{"malformed rpc", passthrough.Route{Path: "/catalog", RPC: "GetProduct"}, conn, "not of the form"},
+		{"unknown service", passthrough.Route{Path: "/catalog", RPC: "billing.BillingService/Charge"}, conn, "unknown service"},
+		{"unknown method", passthrough.Route{Path: "/catalog", RPC: "inventory.InventoryService/Charge"}, conn, "has no method Charge"},
+		{"bidi streaming", passthrough.Route{Path: "/chat", RPC: "grpc.testing.TestService/FullDuplexCall"}, conn, "bidirectional streaming method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := passthrough.New(tt.route, tt.conn)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package passthrough
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/realip"
+)
+
+// RateLimit allows each client IP Requests requests per Per, in bursts of
+// up to Requests.
+type RateLimit struct {
+	Requests int `yaml:"requests"`
+
+	// Per is the period of the budget. Default: 1s.
+	Per time.Duration `yaml:"per"`
+}
+
+// idleBuckets is how long a full bucket is kept after its last request.
+const idleBuckets = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a token bucket per client IP. A nil limiter allows everything.
+type limiter struct {
+	capacity float64
+	rate     float64 // tokens per second
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+func newLimiter(cfg RateLimit) *limiter {
+	if cfg.Requests <= 0 {
+		return nil
+	}
+	if cfg.Per <= 0 {
+		cfg.Per = time.Second
+	}
+	return &limiter{
+		capacity: float64(cfg.Requests),
+		rate:     float64(cfg.Requests) / cfg.Per.Seconds(),
+		buckets:  map[string]*bucket{},
+	}
+}
+
+// allow takes a token for the client of r, or answers 429.
+func (l *limiter) allow(w http.ResponseWriter, r *http.Request) bool {
+	if l == nil {
+		return true
+	}
+	ip, ok := realip.FromContext(r.Context())
+	if !ok {
+		ip = realip.Peer(r)
+	}
+	wait := l.take(ip, time.Now())
+	if wait <= 0 {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	return false
+}
+
+// take takes a token of key at now and returns 0, or how long until a token
+// is available.
+func (l *limiter) take(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) > idleBuckets {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > idleBuckets {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.capacity, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
@@ -0,0 +1,105 @@
+package passthrough
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/schema"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// RPCConfig configures POST /rpc/{service}/{method}, which calls any allowed
+// RPC but bidirectional streaming ones, resolving it from the schema fetched
+// from the backend.
+type RPCConfig struct {
+	// Allow maps backends to the RPCs that may be called on them, as
+	// "package.Service/Method" patterns, e.g. "inventory.InventoryService/*".
+	// /rpc is not served when empty.
+	Allow map[string][]string `yaml:"allow"`
+
+	// Roles, when set, restrict /rpc to tokens with one of them.
+	Roles []string `yaml:"roles"`
+}
+
+// Wildcard serves POST /rpc/{service}/{method}.
+type Wildcard struct {
+	cfg     RPCConfig
+	conns   map[string]grpc.ClientConnInterface
+	schemas *schema.Cache
+	upload  *uploader
+}
+
+// NewWildcard validates the allowlist of cfg, whose backends are reached
+// through conns and described by schemas. It returns nil when nothing is
+// allowed.
+func NewWildcard(cfg RPCConfig, conns map[string]grpc.ClientConnInterface, schemas *schema.Cache) (*Wildcard, error) {
+	if len(cfg.Allow) == 0 {
+		return nil, nil
+	}
+	// client-streaming RPCs take NDJSON bodies, one message per line
+	upload, _ := newUploader(Upload{}, nil)
+	w := &Wildcard{cfg: cfg, conns: conns, schemas: schemas, upload: upload}
+	for backend, patterns := range cfg.Allow {
+		conn := conns[backend]
+		if conn == nil {
+			return nil, fmt.Errorf("backend %q is not configured", backend)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil || !strings.Contains(pattern, "/") {
+				return nil, fmt.Errorf("backend %s: invalid pattern %q", backend, pattern)
+			}
+		}
+	}
+	return w, nil
+}
+
+// backend returns the first backend, by name, allowed to serve rpc, or "".
+func (w *Wildcard) backend(rpc string) string {
+	for _, backend := range slices.Sorted(maps.Keys(w.cfg.Allow)) {
+		for _, pattern := range w.cfg.Allow[backend] {
+			if ok, _ := path.Match(pattern, rpc); ok {
+				return backend
+			}
+		}
+	}
+	return ""
+}
+
+func (w *Wildcard) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if !hasRole(r, w.cfg.Roles) {
+		http.Error(rw, "rpc requires role "+strings.Join(w.cfg.Roles, " or "), http.StatusForbidden)
+		return
+	}
+	service, name := chi.URLParam(r, "service"), chi.URLParam(r, "method")
+	rpc := service + "/" + name
+	backend := w.backend(rpc)
+	if backend == "" {
+		http.Error(rw, "unknown rpc "+rpc, http.StatusNotFound)
+		return
+	}
+
+	sd, err := w.schemas.Service(r.Context(), backend, service)
+	if errors.Is(err, schema.ErrUnknownService) {
+		http.Error(rw, "unknown rpc "+rpc, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Logger().Warn("Failed to resolve rpc", zap.String("backend", backend), zap.String("rpc", rpc), zap.Error(err))
+		http.Error(rw, "failed to resolve rpc", http.StatusBadGateway)
+		return
+	}
+	method, err := lookupMethod(sd, name)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	serve(r.Context(), rw, r, w.conns[backend], method, w.upload)
+}
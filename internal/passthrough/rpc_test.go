@@ -0,0 +1,82 @@
+package passthrough_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/passthrough"
+	"github.com/andro-kes/gateway/internal/schema"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+)
+
+func dialReflection(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pbInv.RegisterInventoryServiceServer(srv, inventoryServer{})
+	reflection.Register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestWildcard tests that allowed RPCs are resolved with reflection and called, and others are not found
+func TestWildcard(t *testing.T) {
+	cfg := passthrough.RPCConfig{Allow: map[string][]string{"inventory": {"inventory.InventoryService/Get*", "inventory.Missing/*"}}}
+	conns := map[string]grpc.ClientConnInterface{"inventory": dialReflection(t)}
+	schemas := schema.New(schema.Config{}, conns)
+	require.NoError(t, schemas.Load(context.Background()))
+	t.Cleanup(schemas.Close)
+	w, err := passthrough.NewWildcard(cfg, conns, schemas)
+	require.NoError(t, err)
+	r := chi.NewRouter()
+	r.Post("/rpc/{service}/{method}", w.ServeHTTP)
+
+	call := func(target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+		return rec
+	}
+	rec := call("/rpc/inventory.InventoryService/GetProduct", `{"id":"p1"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(t, `{"product":{"id":"p1","name":"Tea","quantity":3}}`, rec.Body.String())
+
+	rec = call("/rpc/inventory.InventoryService/GetProduct", `{"id":"missing"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// not allowed
+	assert.Equal(t, http.StatusNotFound, call("/rpc/inventory.InventoryService/DeleteProduct", `{"id":"p1"}`).Code)
+	// allowed but not served by the backend
+	assert.Equal(t, http.StatusNotFound, call("/rpc/inventory.Missing/Get", `{}`).Code)
+	assert.Equal(t, http.StatusNotFound, call("/rpc/inventory.InventoryService/GetNothing", `{}`).Code)
+}
+
+// TestNewWildcard tests the validation of the allowlist
+func TestNewWildcard(t *testing.T) {
+	w, err := passthrough.NewWildcard(passthrough.RPCConfig{}, nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, w)
+
+	_, err = passthrough.NewWildcard(passthrough.RPCConfig{Allow: map[string][]string{"billing": {"billing.Billing/*"}}}, nil, nil)
+	assert.ErrorContains(t, err, `backend "billing" is not configured`)
+
+	conns := map[string]grpc.ClientConnInterface{"inventory": dialReflection(t)}
+	for _, pattern := range []string{"inventory.InventoryService", "inventory.[/*"} {
+		_, err = passthrough.NewWildcard(passthrough.RPCConfig{Allow: map[string][]string{"inventory": {pattern}}}, conns, schema.New(schema.Config{}, conns))
+		assert.ErrorContains(t, err, "invalid pattern", pattern)
+	}
+}
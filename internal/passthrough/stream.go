@@ -0,0 +1,137 @@
+package passthrough
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Media types of streamed responses.
+const (
+	// NDJSON writes one JSON message per line. It is the default.
+	NDJSON = "application/x-ndjson"
+	// EventStream writes one server-sent event per message, for clients
+	// sending Accept: text/event-stream.
+	EventStream = "text/event-stream"
+)
+
+// streamWriteTimeout bounds writing one streamed message, replacing the
+// server's write timeout for the stream.
+const streamWriteTimeout = 30 * time.Second
+
+// serveStream calls the server-streaming method with in and writes every
+// response as soon as it arrives. The next response is only received once
+// the previous one is written, so a slow client slows the backend down
+// through gRPC flow control rather than buffering in the gateway. The
+// backend stream is canceled when the client goes away. Errors after the
+// first response end the stream with an error line or event.
+func serveStream(ctx context.Context, w http.ResponseWriter, r *http.Request, conn grpc.ClientConnInterface, method protoreflect.MethodDescriptor, in *dynamicpb.Message) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	desc := &grpc.StreamDesc{StreamName: string(method.Name()), ServerStreams: true}
+	stream, err := conn.NewStream(ctx, desc, fullMethod(method))
+	if err == nil {
+		err = stream.SendMsg(in)
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		st := status.Convert(err)
+		http.Error(w, st.Message(), HTTPStatus(st.Code()))
+		return
+	}
+
+	sse := render.Accepts(r.Header.Get("Accept"), EventStream)
+	fields := r.URL.Query().Get(render.FieldsParam)
+	rc := http.NewResponseController(w)
+	started := false
+	start := func() {
+		if sse {
+			w.Header().Set("Content-Type", EventStream)
+			w.Header().Set("X-Accel-Buffering", "no")
+		} else {
+			w.Header().Set("Content-Type", NDJSON)
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	for {
+		out := dynamicpb.NewMessage(method.Output())
+		err := stream.RecvMsg(out)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if r.Context().Err() != nil {
+				// client went away
+				return
+			}
+			st := status.Convert(err)
+			if !started {
+				http.Error(w, st.Message(), HTTPStatus(st.Code()))
+				return
+			}
+			_ = writeMessage(w, sse, "error", map[string]string{"error": st.Message(), "code": st.Code().String()})
+			return
+		}
+
+		tree, err := jsonTree(out)
+		if err == nil {
+			tree, err = render.Prepare(r, tree, fields)
+		}
+		if !started {
+			if err != nil {
+				http.Error(w, "failed to encode result", http.StatusInternalServerError)
+				return
+			}
+			start()
+		}
+		_ = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err != nil {
+			_ = writeMessage(w, sse, "error", map[string]string{"error": "failed to encode result"})
+			return
+		}
+		if err := writeMessage(w, sse, "", tree); err != nil {
+			// client went away
+			return
+		}
+		_ = rc.Flush()
+	}
+	if !started {
+		start()
+	}
+}
+
+// writeMessage writes v as an NDJSON line, or as a server-sent event of the
+// given type ("" for the default message type).
+func writeMessage(w io.Writer, sse bool, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !sse {
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+	var buf []byte
+	if event != "" {
+		buf = append(buf, "event: "+event+"\n"...)
+	}
+	buf = append(buf, "data: "...)
+	buf = append(buf, data...)
+	_, err = w.Write(append(buf, "\n\n"...))
+	return err
+}
@@ -0,0 +1,86 @@
+package passthrough_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/passthrough"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+)
+
+// streamServer streams one payload per response parameter, then fails with
+// the response status, if any.
+type streamServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (streamServer) StreamingOutputCall(in *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	for _, p := range in.ResponseParameters {
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: &testpb.Payload{Body: make([]byte, p.Size)}}); err != nil {
+			return err
+		}
+	}
+	if s := in.ResponseStatus; s != nil {
+		return status.Error(codes.Code(s.Code), s.Message)
+	}
+	return nil
+}
+
+func dialStream(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	testpb.RegisterTestServiceServer(srv, streamServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestEndpoint_Stream tests that server-streaming RPCs are streamed as NDJSON or SSE, errors included
+func TestEndpoint_Stream(t *testing.T) {
+	ep, err := passthrough.New(passthrough.Route{Path: "/feed", RPC: "grpc.testing.TestService/StreamingOutputCall"}, dialStream(t))
+	require.NoError(t, err)
+
+	call := func(body, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/feed", strings.NewReader(body))
+		req.Header.Set("Accept", accept)
+		return serve(t, ep, req)
+	}
+
+	w := call(`{"response_parameters":[{"size":1},{"size":2}]}`, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, passthrough.NDJSON, w.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"payload\":{\"body\":\"AA==\"}}\n{\"payload\":{\"body\":\"AAA=\"}}\n", w.Body.String())
+
+	w = call(`{"response_parameters":[{"size":1}]}`, passthrough.EventStream)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, passthrough.EventStream, w.Header().Get("Content-Type"))
+	assert.Equal(t, "data: {\"payload\":{\"body\":\"AA==\"}}\n\n", w.Body.String())
+
+	// fails after the first message
+	w = call(`{"response_parameters":[{"size":1}],"response_status":{"code":5,"message":"feed closed"}}`, passthrough.EventStream)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "data: {\"payload\":{\"body\":\"AA==\"}}\n\nevent: error\ndata: {\"code\":\"NotFound\",\"error\":\"feed closed\"}\n\n", w.Body.String())
+
+	// fails before the first message
+	w = call(`{"response_status":{"code":5,"message":"no feed"}}`, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "no feed")
+
+	w = call(`{}`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+}
@@ -0,0 +1,485 @@
+package passthrough
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/token"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Upload configures how request bodies are streamed to a client-streaming
+// RPC. NDJSON bodies are always accepted, one request message per line.
+type Upload struct {
+	// Field is the bytes field of the request message that carries the
+	// chunks of raw (application/octet-stream) and multipart/form-data
+	// bodies, as a dotted path for nested fields, e.g. "payload.body".
+	// Without it only NDJSON bodies are accepted.
+	Field string `yaml:"field"`
+
+	// ChunkSize is the number of body bytes per message. Default: 64KiB.
+	ChunkSize int `yaml:"chunk_size"`
+
+	// MaxBytes bounds the body of an upload, across the requests of a
+	// resumable one. Zero means unlimited.
+	MaxBytes int64 `yaml:"max_bytes"`
+
+	// SessionTTL is how long the backend stream of an idle resumable upload
+	// is kept open. Default: 10m.
+	SessionTTL time.Duration `yaml:"session_ttl"`
+}
+
+// Upload headers.
+const (
+	// SessionHeader names a resumable upload, chosen by the client.
+	SessionHeader = "X-Upload-Session"
+	// OffsetHeader is the number of body bytes sent to the backend. Clients
+	// resuming an upload send the offset they resume at.
+	OffsetHeader = "X-Upload-Offset"
+	// MessagesHeader is the number of messages sent to the backend.
+	MessagesHeader = "X-Upload-Messages"
+	// CompleteHeader marks the last request of a resumable upload.
+	CompleteHeader = "X-Upload-Complete"
+)
+
+// Upload media types besides NDJSON.
+const (
+	OctetStream = "application/octet-stream"
+	Multipart   = "multipart/form-data"
+)
+
+const (
+	// maxSessions bounds the resumable uploads open on a route.
+	maxSessions = 1000
+	// maxFormValue bounds the form fields before the file of a multipart
+	// body.
+	maxFormValue = 1 << 20
+)
+
+// uploadError is a problem with an uploaded body, answered with status.
+type uploadError struct {
+	status int
+	msg    string
+	// resumable is true when the upload can be resumed from its offset.
+	resumable bool
+}
+
+func (e *uploadError) Error() string {
+	return e.msg
+}
+
+// readError classifies an error reading the body.
+func readError(err error) error {
+	if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+		return &uploadError{status: http.StatusRequestEntityTooLarge, msg: "upload too large"}
+	}
+	return &uploadError{status: http.StatusBadRequest, msg: "failed to read upload", resumable: true}
+}
+
+// progress counts what an upload sent to the backend.
+type progress struct {
+	offset   int64
+	messages int64
+}
+
+func (p progress) set(h http.Header) {
+	h.Set(OffsetHeader, strconv.FormatInt(p.offset, 10))
+	h.Set(MessagesHeader, strconv.FormatInt(p.messages, 10))
+}
+
+// uploader streams request bodies to a client-streaming method.
+type uploader struct {
+	cfg   Upload
+	field []protoreflect.FieldDescriptor // the path to the bytes field; nil: NDJSON only
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// session is a resumable upload, whose backend stream outlives requests.
+type session struct {
+	mu       sync.Mutex // held by the request writing to the session
+	user     string
+	stream   grpc.ClientStream
+	cancel   context.CancelFunc
+	tmpl     *dynamicpb.Message
+	progress progress
+	timer    *time.Timer
+	closed   bool
+}
+
+func newUploader(cfg Upload, input protoreflect.MessageDescriptor) (*uploader, error) {
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 64 << 10
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 10 * time.Minute
+	}
+	u := &uploader{cfg: cfg, sessions: map[string]*session{}}
+	if cfg.Field == "" {
+		return u, nil
+	}
+	md := input
+	for name := range strings.SplitSeq(cfg.Field, ".") {
+		var fd protoreflect.FieldDescriptor
+		if md != nil {
+			fd = md.Fields().ByName(protoreflect.Name(name))
+		}
+		if fd == nil || fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("upload field %q is not a bytes field of %s", cfg.Field, input.FullName())
+		}
+		u.field = append(u.field, fd)
+		md = fd.Message()
+	}
+	if u.field[len(u.field)-1].Kind() != protoreflect.BytesKind {
+		return nil, fmt.Errorf("upload field %q is not a bytes field of %s", cfg.Field, input.FullName())
+	}
+	return u, nil
+}
+
+// setChunk sets the upload field of msg to chunk.
+func (u *uploader) setChunk(msg protoreflect.Message, chunk []byte) {
+	last := len(u.field) - 1
+	for _, fd := range u.field[:last] {
+		msg = msg.Mutable(fd).Message()
+	}
+	msg.Set(u.field[last], protoreflect.ValueOfBytes(chunk))
+}
+
+func (u *uploader) mediaTypes() []string {
+	if u.field == nil {
+		return []string{NDJSON}
+	}
+	return []string{NDJSON, OctetStream, Multipart}
+}
+
+func openUpload(ctx context.Context, conn grpc.ClientConnInterface, method protoreflect.MethodDescriptor) (grpc.ClientStream, error) {
+	desc := &grpc.StreamDesc{StreamName: string(method.Name()), ClientStreams: true}
+	return conn.NewStream(ctx, desc, fullMethod(method))
+}
+
+// serve streams the body of r to method in one go, or continues the
+// resumable upload it names.
+func (u *uploader) serve(ctx context.Context, w http.ResponseWriter, r *http.Request, conn grpc.ClientConnInterface, method protoreflect.MethodDescriptor) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != NDJSON && u.field == nil {
+		http.Error(w, "upload must be "+NDJSON, http.StatusUnsupportedMediaType)
+		return
+	}
+	if id := r.Header.Get(SessionHeader); id != "" {
+		if mediaType == NDJSON || mediaType == Multipart {
+			http.Error(w, "resumable uploads must be "+OctetStream, http.StatusUnsupportedMediaType)
+			return
+		}
+		u.resume(w, r, conn, method, id)
+		return
+	}
+
+	tmpl := dynamicpb.NewMessage(method.Input())
+	if err := setParams(r, tmpl); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body := r.Body
+	if u.cfg.MaxBytes > 0 {
+		body = http.MaxBytesReader(w, body, u.cfg.MaxBytes)
+	}
+
+	// canceling tells the backend that a failed upload is not complete
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := openUpload(ctx, conn, method)
+	if err != nil {
+		st := status.Convert(err)
+		http.Error(w, st.Message(), HTTPStatus(st.Code()))
+		return
+	}
+	var p progress
+	switch mediaType {
+	case NDJSON:
+		err = sendLines(stream, body, tmpl, &p)
+	case Multipart:
+		err = u.sendMultipart(stream, r, body, tmpl, &p)
+	default:
+		err = u.sendChunks(stream, body, tmpl, &p)
+	}
+	finish(w, r, stream, method, p, err)
+}
+
+// sendChunks sends body in chunks, each in a copy of tmpl.
+func (u *uploader) sendChunks(stream grpc.ClientStream, body io.Reader, tmpl *dynamicpb.Message, p *progress) error {
+	for {
+		buf := make([]byte, u.cfg.ChunkSize)
+		n, err := fill(body, buf)
+		if n > 0 {
+			msg := proto.Clone(tmpl).(*dynamicpb.Message)
+			u.setChunk(msg, buf[:n])
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+			p.offset += int64(n)
+			p.messages++
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return readError(err)
+		}
+	}
+}
+
+// fill reads until buf is full or r fails.
+func fill(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// sendMultipart sets the form fields before the first file on tmpl, then
+// sends the file in chunks.
+func (u *uploader) sendMultipart(stream grpc.ClientStream, r *http.Request, body io.ReadCloser, tmpl *dynamicpb.Message, p *progress) error {
+	r.Body = body
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return &uploadError{status: http.StatusBadRequest, msg: "invalid multipart body"}
+	}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return &uploadError{status: http.StatusBadRequest, msg: "multipart body has no file"}
+		}
+		if err != nil {
+			return readError(err)
+		}
+		if part.FileName() != "" {
+			return u.sendChunks(stream, part, tmpl, p)
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxFormValue))
+		if err != nil {
+			return readError(err)
+		}
+		if err := setNamed(tmpl, part.FormName(), string(value)); err != nil {
+			return &uploadError{status: http.StatusBadRequest, msg: err.Error()}
+		}
+	}
+}
+
+// sendLines sends every JSON line of body as a message, with the fields of
+// tmpl set unless the line sets them.
+func sendLines(stream grpc.ClientStream, body io.Reader, tmpl *dynamicpb.Message, p *progress) error {
+	dec := json.NewDecoder(body)
+	for {
+		var line json.RawMessage
+		err := dec.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if syntaxErr := (*json.SyntaxError)(nil); errors.As(err, &syntaxErr) {
+			return &uploadError{status: http.StatusBadRequest, msg: fmt.Sprintf("invalid line %d: %v", p.messages+1, err)}
+		}
+		if err != nil {
+			return readError(err)
+		}
+		msg := dynamicpb.NewMessage(tmpl.Descriptor())
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(line, msg); err != nil {
+			return &uploadError{status: http.StatusBadRequest, msg: fmt.Sprintf("invalid line %d: %v", p.messages+1, err)}
+		}
+		tmpl.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			if !msg.Has(fd) {
+				msg.Set(fd, v)
+			}
+			return true
+		})
+		if err := stream.SendMsg(msg); err != nil {
+			return err
+		}
+		p.offset = dec.InputOffset()
+		p.messages++
+	}
+}
+
+// finish answers an upload that sent p and ended with err: the backend's
+// response, with the progress in trailers, or the error.
+func finish(w http.ResponseWriter, r *http.Request, stream grpc.ClientStream, method protoreflect.MethodDescriptor, p progress, err error) {
+	if ue := (*uploadError)(nil); errors.As(err, &ue) {
+		p.set(w.Header())
+		http.Error(w, ue.msg, ue.status)
+		return
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	// after a failed send, the backend's status comes with the response
+	out := dynamicpb.NewMessage(method.Output())
+	if err := stream.RecvMsg(out); err != nil {
+		p.set(w.Header())
+		st := status.Convert(err)
+		http.Error(w, st.Message(), HTTPStatus(st.Code()))
+		return
+	}
+	tree, err := jsonTree(out)
+	if err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Trailer", OffsetHeader+", "+MessagesHeader)
+	if err := render.Write(w, r, http.StatusOK, tree); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+	p.set(w.Header())
+}
+
+// resume continues the resumable upload id. Requests without
+// X-Upload-Complete: true leave the backend stream open and answer 204 with
+// the offset to resume at.
+func (u *uploader) resume(w http.ResponseWriter, r *http.Request, conn grpc.ClientConnInterface, method protoreflect.MethodDescriptor, id string) {
+	var offset int64 = -1
+	if v := r.Header.Get(OffsetHeader); v != "" {
+		var err error
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			http.Error(w, "invalid "+OffsetHeader, http.StatusBadRequest)
+			return
+		}
+	}
+	s, err := u.session(r, conn, method, id, offset)
+	if err != nil {
+		if ue := (*uploadError)(nil); errors.As(err, &ue) {
+			http.Error(w, ue.msg, ue.status)
+			return
+		}
+		st := status.Convert(err)
+		http.Error(w, st.Message(), HTTPStatus(st.Code()))
+		return
+	}
+	defer func() {
+		if !s.closed {
+			s.timer.Reset(u.cfg.SessionTTL)
+		}
+		s.mu.Unlock()
+	}()
+
+	if offset >= 0 && offset != s.progress.offset {
+		s.progress.set(w.Header())
+		http.Error(w, "upload offset mismatch", http.StatusConflict)
+		return
+	}
+	body := r.Body
+	if u.cfg.MaxBytes > 0 {
+		body = http.MaxBytesReader(w, body, u.cfg.MaxBytes-s.progress.offset)
+	}
+	err = u.sendChunks(s.stream, body, s.tmpl, &s.progress)
+	if ue := (*uploadError)(nil); errors.As(err, &ue) && ue.resumable {
+		s.progress.set(w.Header())
+		http.Error(w, ue.msg, ue.status)
+		return
+	}
+	if complete, _ := strconv.ParseBool(r.Header.Get(CompleteHeader)); err == nil && !complete {
+		s.progress.set(w.Header())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	finish(w, r, s.stream, method, s.progress, err)
+	u.close(id, s)
+}
+
+// session returns the locked session id, opening it if the upload starts.
+func (u *uploader) session(r *http.Request, conn grpc.ClientConnInterface, method protoreflect.MethodDescriptor, id string, offset int64) (*session, error) {
+	user := ""
+	if claims, ok := token.FromContext(r.Context()); ok {
+		user = claims.UserID
+	}
+	unknown := &uploadError{status: http.StatusNotFound, msg: "unknown upload session"}
+
+	u.mu.Lock()
+	s, ok := u.sessions[id]
+	n := len(u.sessions)
+	u.mu.Unlock()
+	if !ok {
+		if offset > 0 {
+			return nil, unknown
+		}
+		if n >= maxSessions {
+			return nil, &uploadError{status: http.StatusServiceUnavailable, msg: "too many upload sessions"}
+		}
+		tmpl := dynamicpb.NewMessage(method.Input())
+		if err := setParams(r, tmpl); err != nil {
+			return nil, &uploadError{status: http.StatusBadRequest, msg: err.Error()}
+		}
+		// the stream outlives the request that opens it
+		ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		stream, err := openUpload(ctx, conn, method)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s = &session{user: user, stream: stream, cancel: cancel, tmpl: tmpl}
+		s.timer = time.AfterFunc(u.cfg.SessionTTL, func() { u.expire(id, s) })
+
+		u.mu.Lock()
+		if existing, ok := u.sessions[id]; ok {
+			// opened concurrently by another request
+			cancel()
+			s.timer.Stop()
+			s = existing
+		} else {
+			u.sessions[id] = s
+		}
+		u.mu.Unlock()
+	}
+	if s.user != user {
+		return nil, unknown
+	}
+	if !s.mu.TryLock() {
+		return nil, &uploadError{status: http.StatusConflict, msg: "upload session is busy"}
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return nil, unknown
+	}
+	s.timer.Stop()
+	return s, nil
+}
+
+// expire closes the idle session id. A session in use is left to the request
+// using it, which rearms the timer.
+func (u *uploader) expire(id string, s *session) {
+	if !s.mu.TryLock() {
+		return
+	}
+	defer s.mu.Unlock()
+	u.close(id, s)
+}
+
+// close cancels the stream of the locked session id and forgets it.
+func (u *uploader) close(id string, s *session) {
+	s.closed = true
+	s.cancel()
+	u.mu.Lock()
+	if u.sessions[id] == s {
+		delete(u.sessions, id)
+	}
+	u.mu.Unlock()
+}
@@ -0,0 +1,172 @@
+package passthrough_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/passthrough"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+// uploadServer sums the sizes of the payloads it receives.
+type uploadServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (uploadServer) StreamingInputCall(stream testpb.TestService_StreamingInputCallServer) error {
+	var size int32
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&testpb.StreamingInputCallResponse{AggregatedPayloadSize: size})
+		}
+		if err != nil {
+			return err
+		}
+		size += int32(len(in.GetPayload().GetBody()))
+	}
+}
+
+func dialUpload(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	testpb.RegisterTestServiceServer(srv, uploadServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+const uploadRPC = "grpc.testing.TestService/StreamingInputCall"
+
+// TestEndpoint_Upload tests that raw, multipart and NDJSON bodies are streamed to client-streaming RPCs, with the progress in trailers
+func TestEndpoint_Upload(t *testing.T) {
+	ep, err := passthrough.New(passthrough.Route{Path: "/upload", RPC: uploadRPC, Upload: passthrough.Upload{Field: "payload.body", ChunkSize: 4, MaxBytes: 1000}}, dialUpload(t))
+	require.NoError(t, err)
+	assert.Equal(t, []string{passthrough.NDJSON, passthrough.OctetStream, passthrough.Multipart}, ep.MediaTypes())
+
+	upload := func(contentType string, body io.Reader) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", contentType)
+		return serve(t, ep, req).Result()
+	}
+
+	resp := upload(passthrough.OctetStream, strings.NewReader("0123456789"))
+	body, _ := io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.JSONEq(t, `{"aggregated_payload_size":10}`, string(body))
+	assert.Equal(t, "10", resp.Trailer.Get(passthrough.OffsetHeader))
+	assert.Equal(t, "3", resp.Trailer.Get(passthrough.MessagesHeader))
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile("file", "data.bin")
+	require.NoError(t, err)
+	fw.Write([]byte("hello"))
+	require.NoError(t, mw.Close())
+	resp = upload(mw.FormDataContentType(), &form)
+	body, _ = io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.JSONEq(t, `{"aggregated_payload_size":5}`, string(body))
+
+	resp = upload(passthrough.NDJSON, strings.NewReader(`{"payload":{"body":"AA=="}}`+"\n"+`{"payload":{"body":"AAA="}}`+"\n"))
+	body, _ = io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.JSONEq(t, `{"aggregated_payload_size":3}`, string(body))
+	assert.Equal(t, "2", resp.Trailer.Get(passthrough.MessagesHeader))
+
+	resp = upload(passthrough.NDJSON, strings.NewReader(`{"payload":`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = upload(passthrough.OctetStream, strings.NewReader(strings.Repeat("x", 1001)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Equal(t, "1000", resp.Header.Get(passthrough.OffsetHeader))
+}
+
+// TestEndpoint_UploadNDJSON tests that routes without an upload field only take NDJSON bodies
+func TestEndpoint_UploadNDJSON(t *testing.T) {
+	ep, err := passthrough.New(passthrough.Route{Path: "/upload", RPC: uploadRPC}, dialUpload(t))
+	require.NoError(t, err)
+	assert.Equal(t, []string{passthrough.NDJSON}, ep.MediaTypes())
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("0123"))
+	req.Header.Set("Content-Type", passthrough.OctetStream)
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(t, ep, req).Code)
+}
+
+// TestEndpoint_UploadResume tests that resumable uploads keep the backend stream open across requests until complete
+func TestEndpoint_UploadResume(t *testing.T) {
+	ep, err := passthrough.New(passthrough.Route{Path: "/upload", RPC: uploadRPC, Upload: passthrough.Upload{Field: "payload.body"}}, dialUpload(t))
+	require.NoError(t, err)
+
+	upload := func(session, offset, body string, complete bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(body))
+		req.Header.Set("Content-Type", passthrough.OctetStream)
+		req.Header.Set(passthrough.SessionHeader, session)
+		if offset != "" {
+			req.Header.Set(passthrough.OffsetHeader, offset)
+		}
+		if complete {
+			req.Header.Set(passthrough.CompleteHeader, "true")
+		}
+		return serve(t, ep, req)
+	}
+
+	w := upload("s1", "0", "0123", false)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Equal(t, "4", w.Header().Get(passthrough.OffsetHeader))
+
+	// the client resumes at the wrong offset and learns the right one
+	w = upload("s1", "2", "23456", false)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "4", w.Header().Get(passthrough.OffsetHeader))
+
+	w = upload("s1", "4", "456", true)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"aggregated_payload_size":7}`, w.Body.String())
+
+	// the session ended with the upload
+	w = upload("s1", "7", "789", true)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", passthrough.NDJSON)
+	req.Header.Set(passthrough.SessionHeader, "s2")
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(t, ep, req).Code)
+}
+
+// TestNew_Upload tests the validation of upload settings
+func TestNew_Upload(t *testing.T) {
+	conn := dialUpload(t)
+	tests := []struct {
+		name  string
+		route passthrough.Route
+		err   string
+	}{
+		{"unary", passthrough.Route{Path: "/upload", RPC: "grpc.testing.TestService/UnaryCall", Upload: passthrough.Upload{Field: "payload.body"}}, "needs a client-streaming rpc"},
+		{"message field", passthrough.Route{Path: "/upload", RPC: uploadRPC, Upload: passthrough.Upload{Field: "payload"}}, "is not a bytes field"},
+		{"unknown field", passthrough.Route{Path: "/upload", RPC: uploadRPC, Upload: passthrough.Upload{Field: "payload.data"}}, "is not a bytes field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := passthrough.New(tt.route, conn)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// Package personalize forwards per-user attributes, such as the locale,
+// currency or tier of the caller, to backends as gRPC metadata, so that they
+// can answer with personalized prices or texts without decoding tokens
+// themselves. Attributes come from the claims of the verified access token
+// and, for the claims it lacks, from a profile lookup in auth_service whose
+// answers are cached per user.
+package personalize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/interceptor"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+// Config maps claims to metadata.
+type Config struct {
+	// Claims are the claims forwarded, each under its metadata key.
+	Claims []Claim `yaml:"claims"`
+
+	// Lookup fetches the claims a token lacks from auth_service.
+	Lookup LookupConfig `yaml:"lookup"`
+}
+
+// Claim forwards one claim.
+type Claim struct {
+	// Claim is the name of the claim, e.g. locale.
+	Claim string `yaml:"claim"`
+
+	// Metadata is the metadata key the value is sent under, e.g.
+	// x-user-locale.
+	Metadata string `yaml:"metadata"`
+
+	// Default is sent when neither the token nor the lookup has the claim.
+	// Without it, the key is left out.
+	Default string `yaml:"default"`
+}
+
+// LookupConfig configures the profile lookup.
+type LookupConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// TTL is how long a profile is cached. Default: 5m.
+	TTL time.Duration `yaml:"ttl"`
+
+	// Timeout bounds each lookup. Default: 500ms.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// MaxEntries bounds the cached profiles. Default: 10000.
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// LookupFunc returns the profile attributes of a user, by claim name.
+type LookupFunc func(ctx context.Context, userID string) (map[string]string, error)
+
+var lookupsTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "personalization",
+	Name:      "lookups_total",
+	Help:      "Profile lookups by result (hit, miss, error).",
+}, []string{"result"})
+
+// Personalizer attaches the personalization metadata of the caller to the
+// backend calls of a request. A nil Personalizer attaches nothing.
+type Personalizer struct {
+	claims  []Claim
+	lookup  LookupFunc
+	ttl     time.Duration
+	timeout time.Duration
+	max     int
+
+	mu       sync.Mutex
+	profiles map[string]profile
+}
+
+type profile struct {
+	attrs   map[string]string
+	expires time.Time
+}
+
+// New returns the personalizer of cfg, or nil when no claim is configured.
+// lookup is only called when cfg.Lookup is enabled.
+func New(cfg Config, lookup LookupFunc) (*Personalizer, error) {
+	if len(cfg.Claims) == 0 {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	for _, c := range cfg.Claims {
+		if c.Claim == "" {
+			return nil, errors.New("personalization claim needs a name")
+		}
+		if c.Metadata == "" {
+			return nil, fmt.Errorf("personalization claim %s needs a metadata key", c.Claim)
+		}
+		if err := interceptor.CheckMetadata(map[string]string{c.Metadata: c.Default}); err != nil {
+			return nil, fmt.Errorf("personalization claim %s: %w", c.Claim, err)
+		}
+		if seen[c.Metadata] {
+			return nil, fmt.Errorf("personalization metadata %s is set by two claims", c.Metadata)
+		}
+		seen[c.Metadata] = true
+	}
+	if cfg.Lookup.TTL < 0 || cfg.Lookup.Timeout < 0 || cfg.Lookup.MaxEntries < 0 {
+		return nil, errors.New("personalization lookup ttl, timeout and max_entries must not be negative")
+	}
+	p := &Personalizer{
+		claims:   cfg.Claims,
+		ttl:      cfg.Lookup.TTL,
+		timeout:  cfg.Lookup.Timeout,
+		max:      cfg.Lookup.MaxEntries,
+		profiles: map[string]profile{},
+	}
+	if cfg.Lookup.Enabled {
+		p.lookup = lookup
+	}
+	if p.ttl == 0 {
+		p.ttl = 5 * time.Minute
+	}
+	if p.timeout == 0 {
+		p.timeout = 500 * time.Millisecond
+	}
+	if p.max == 0 {
+		p.max = 10000
+	}
+	return p, nil
+}
+
+// Keys returns the metadata keys the personalizer sets, for the startup
+// checks to keep clients from sending them.
+func (p *Personalizer) Keys() []string {
+	if p == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(p.claims))
+	for _, c := range p.claims {
+		keys = append(keys, c.Metadata)
+	}
+	return keys
+}
+
+// Middleware attaches the personalization metadata of requests with
+// verified claims, so it goes after authentication. Anonymous requests pass
+// through untouched.
+func (p *Personalizer) Middleware(next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := token.FromContext(r.Context())
+		if !ok || !claims.Verified {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if kv := p.metadata(r.Context(), claims); len(kv) > 0 {
+			r = r.WithContext(metadata.AppendToOutgoingContext(r.Context(), kv...))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// metadata returns the metadata key-value pairs of claims.
+func (p *Personalizer) metadata(ctx context.Context, claims *token.Claims) []string {
+	var kv []string
+	var attrs map[string]string
+	looked := false
+	for _, c := range p.claims {
+		v, ok := claimValue(claims.Payload[c.Claim])
+		if !ok && p.lookup != nil && claims.UserID != "" {
+			if !looked {
+				attrs, looked = p.profile(ctx, claims.UserID), true
+			}
+			v, ok = attrs[c.Claim]
+		}
+		if !ok || v == "" {
+			v = c.Default
+		}
+		if v == "" {
+			continue
+		}
+		if err := interceptor.CheckMetadata(map[string]string{c.Metadata: v}); err != nil {
+			logger.Logger().Debug("Personalization value not sent", zap.String("claim", c.Claim), zap.Error(err))
+			continue
+		}
+		kv = append(kv, c.Metadata, v)
+	}
+	return kv
+}
+
+// claimValue formats a scalar claim value.
+func claimValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// profile returns the cached profile of userID, looking it up when it is
+// missing or expired. Failed lookups are not cached.
+func (p *Personalizer) profile(ctx context.Context, userID string) map[string]string {
+	now := time.Now()
+	p.mu.Lock()
+	cached, ok := p.profiles[userID]
+	p.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		lookupsTotal.WithLabelValues("hit").Inc()
+		return cached.attrs
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	attrs, err := p.lookup(ctx, userID)
+	if err != nil {
+		lookupsTotal.WithLabelValues("error").Inc()
+		logger.Logger().Warn("Personalization lookup failed", zap.String("user_id", userID), zap.Error(err))
+		return nil
+	}
+	lookupsTotal.WithLabelValues("miss").Inc()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.profiles) >= p.max {
+		for id, cached := range p.profiles {
+			if !now.Before(cached.expires) {
+				delete(p.profiles, id)
+			}
+		}
+	}
+	if len(p.profiles) >= p.max {
+		// evict an arbitrary entry rather than grow
+		for id := range p.profiles {
+			delete(p.profiles, id)
+			break
+		}
+	}
+	p.profiles[userID] = profile{attrs: attrs, expires: now.Add(p.ttl)}
+	return attrs
+}
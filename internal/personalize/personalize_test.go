@@ -0,0 +1,104 @@
+package personalize_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/personalize"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+// send serves a request carrying claims with p and returns the outgoing
+// metadata the handler saw.
+func send(t *testing.T, p *personalize.Personalizer, claims *token.Claims) metadata.MD {
+	t.Helper()
+	var md metadata.MD
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		md, _ = metadata.FromOutgoingContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/inventory/get", nil)
+	if claims != nil {
+		req = req.WithContext(token.WithClaims(req.Context(), claims))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	return md
+}
+
+// TestPersonalizer tests that claims are forwarded from the token, then from the cached profile, then by default
+func TestPersonalizer(t *testing.T) {
+	lookups := 0
+	p, err := personalize.New(personalize.Config{
+		Claims: []personalize.Claim{
+			{Claim: "locale", Metadata: "x-user-locale"},
+			{Claim: "currency", Metadata: "x-user-currency", Default: "EUR"},
+			{Claim: "tier", Metadata: "x-user-tier"},
+		},
+		Lookup: personalize.LookupConfig{Enabled: true},
+	}, func(ctx context.Context, userID string) (map[string]string, error) {
+		lookups++
+		if userID == "broken" {
+			return nil, errors.New("auth_service unavailable")
+		}
+		return map[string]string{"tier": "gold", "locale": "fr-FR"}, nil
+	})
+	require.NoError(t, err)
+
+	md := send(t, p, &token.Claims{UserID: "u1", Verified: true, Payload: map[string]any{"locale": "de-DE", "currency": "CHF", "tier": json.Number("2")}})
+	assert.Equal(t, []string{"de-DE"}, md.Get("x-user-locale"))
+	assert.Equal(t, []string{"CHF"}, md.Get("x-user-currency"))
+	assert.Equal(t, []string{"2"}, md.Get("x-user-tier"))
+	assert.Zero(t, lookups, "a token with every claim needs no lookup")
+
+	md = send(t, p, &token.Claims{UserID: "u2", Verified: true, Payload: map[string]any{"locale": "de-DE"}})
+	assert.Equal(t, []string{"de-DE"}, md.Get("x-user-locale"))
+	assert.Equal(t, []string{"EUR"}, md.Get("x-user-currency"))
+	assert.Equal(t, []string{"gold"}, md.Get("x-user-tier"))
+	send(t, p, &token.Claims{UserID: "u2", Verified: true})
+	assert.Equal(t, 1, lookups, "profiles are cached")
+
+	md = send(t, p, &token.Claims{UserID: "broken", Verified: true})
+	assert.Equal(t, []string{"EUR"}, md.Get("x-user-currency"))
+	assert.Empty(t, md.Get("x-user-tier"))
+
+	assert.Empty(t, send(t, p, nil), "anonymous requests carry no personalization")
+	assert.Empty(t, send(t, p, &token.Claims{UserID: "u1", Payload: map[string]any{"tier": "gold"}}), "unverified claims are not forwarded")
+	assert.Empty(t, send(t, p, &token.Claims{UserID: "u1", Verified: true, Payload: map[string]any{"locale": "de\nDE"}}).Get("x-user-locale"))
+}
+
+// TestPersonalizer_Nil tests that a nil personalizer forwards nothing
+func TestPersonalizer_Nil(t *testing.T) {
+	p, err := personalize.New(personalize.Config{}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+	assert.Empty(t, send(t, p, &token.Claims{UserID: "u1", Verified: true, Payload: map[string]any{"locale": "de-DE"}}))
+	assert.Empty(t, p.Keys())
+}
+
+// TestNew tests the validation of the claim mapping
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims []personalize.Claim
+		err    string
+	}{
+		{"no claim name", []personalize.Claim{{Metadata: "x-user-locale"}}, "needs a name"},
+		{"no metadata", []personalize.Claim{{Claim: "locale"}}, "needs a metadata key"},
+		{"reserved metadata", []personalize.Claim{{Claim: "sub", Metadata: "x-user-id"}}, "cannot be propagated"},
+		{"uppercase metadata", []personalize.Claim{{Claim: "locale", Metadata: "X-Locale"}}, "must be lowercase"},
+		{"duplicate metadata", []personalize.Claim{{Claim: "locale", Metadata: "x-locale"}, {Claim: "lang", Metadata: "x-locale"}}, "set by two claims"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := personalize.New(personalize.Config{Claims: tt.claims}, nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
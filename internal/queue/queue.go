@@ -0,0 +1,342 @@
+// Package queue limits the requests and background calls that reach the
+// backends at the same time, and queues the rest by priority class: auth
+// requests are served before interactive inventory traffic, which is served
+// before bulk imports and exports. Background bulk traffic therefore cannot
+// starve logins when the gateway is saturated; it waits instead.
+package queue
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Class is a priority class. Lower values are served first.
+type Class int
+
+const (
+	Auth Class = iota
+	Interactive
+	Bulk
+)
+
+var classNames = []string{"auth", "interactive", "bulk"}
+
+func (c Class) String() string {
+	return classNames[c]
+}
+
+// ParseClass parses "auth", "interactive" or "bulk".
+func ParseClass(s string) (Class, error) {
+	for i, name := range classNames {
+		if strings.EqualFold(s, name) {
+			return Class(i), nil
+		}
+	}
+	return Interactive, fmt.Errorf("unknown priority class %q", s)
+}
+
+var (
+	// ErrQueueFull is returned when the queue of a class is at its depth.
+	ErrQueueFull = errors.New("queue: queue is full")
+	// ErrTimeout is returned when no slot freed up within the class timeout.
+	ErrTimeout = errors.New("queue: timed out waiting for a slot")
+)
+
+// Config configures the queue. It is disabled unless MaxInFlight is set.
+type Config struct {
+	// MaxInFlight is the number of queued requests and bulk calls served at
+	// the same time; the rest wait in the queue of their class.
+	MaxInFlight int `yaml:"max_in_flight"`
+
+	// Routes assign classes by path prefix (longest match wins), on top of
+	// the defaults: /auth is auth, /inventory and /notifications are
+	// interactive, and /inventory/products/import and export are bulk.
+	// Requests on other routes are not queued.
+	Routes []RouteConfig `yaml:"routes"`
+
+	// Classes tune the queue of each class ("auth", "interactive", "bulk").
+	Classes map[string]ClassConfig `yaml:"classes"`
+
+	// RetryAfter is sent with rejected requests. Default: 1s.
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// RouteConfig assigns a class to a path prefix.
+type RouteConfig struct {
+	PathPrefix string `yaml:"path_prefix"`
+	Class      string `yaml:"class"`
+}
+
+// ClassConfig configures the queue of one class.
+type ClassConfig struct {
+	// QueueDepth is the number of requests that may wait; more are
+	// rejected. Default: 100.
+	QueueDepth int `yaml:"queue_depth"`
+
+	// Timeout is how long a request may wait for a slot. Default: 2s, and
+	// 30s for bulk.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+var defaultRoutes = []route{
+	{prefix: "/auth", class: Auth},
+	{prefix: "/inventory", class: Interactive},
+	{prefix: "/notifications", class: Interactive},
+	{prefix: "/inventory/products/import", class: Bulk},
+	{prefix: "/inventory/products/export", class: Bulk},
+}
+
+var (
+	inFlight = promauto.With(metrics.Registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "queue",
+		Name:      "in_flight",
+		Help:      "Requests and bulk calls currently holding a queue slot.",
+	})
+
+	waiting = promauto.With(metrics.Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "queue",
+		Name:      "waiting",
+		Help:      "Requests and bulk calls waiting for a slot, by class.",
+	}, []string{"class"})
+
+	waitDuration = promauto.With(metrics.Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "queue",
+		Name:      "wait_seconds",
+		Help:      "Time spent waiting for a slot, by class.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"class"})
+
+	rejectedTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "queue",
+		Name:      "rejected_total",
+		Help:      "Requests and bulk calls rejected because their queue was full or they waited too long.",
+	}, []string{"class", "reason"})
+)
+
+// Queue hands out slots by class priority, first come first served within
+// a class.
+type Queue struct {
+	max        int
+	routes     []route
+	classes    []*class
+	retryAfter int
+
+	mu       sync.Mutex
+	inFlight int
+}
+
+type route struct {
+	prefix string
+	class  Class
+}
+
+type class struct {
+	depth   int
+	timeout time.Duration
+	// waiters holds the chan closed when each waiter is handed a slot
+	waiters list.List
+}
+
+// New validates cfg and returns its Queue. It returns nil when disabled; a
+// nil Queue lets everything through.
+func New(cfg Config) (*Queue, error) {
+	if cfg.MaxInFlight <= 0 {
+		return nil, nil
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = time.Second
+	}
+
+	q := &Queue{
+		max:        cfg.MaxInFlight,
+		retryAfter: int(math.Ceil(cfg.RetryAfter.Seconds())),
+	}
+	for i := range classNames {
+		c := &class{depth: 100, timeout: 2 * time.Second}
+		if Class(i) == Bulk {
+			c.timeout = 30 * time.Second
+		}
+		q.classes = append(q.classes, c)
+	}
+	for name, cc := range cfg.Classes {
+		i, err := ParseClass(name)
+		if err != nil {
+			return nil, err
+		}
+		if cc.QueueDepth > 0 {
+			q.classes[i].depth = cc.QueueDepth
+		}
+		if cc.Timeout > 0 {
+			q.classes[i].timeout = cc.Timeout
+		}
+	}
+
+	q.routes = append(q.routes, defaultRoutes...)
+	for _, rc := range cfg.Routes {
+		c, err := ParseClass(rc.Class)
+		if err != nil {
+			return nil, fmt.Errorf("queue route %s: %w", rc.PathPrefix, err)
+		}
+		q.routes = append(q.routes, route{prefix: rc.PathPrefix, class: c})
+	}
+	// longest prefix first; for equal prefixes the configured route (later) wins
+	sort.SliceStable(q.routes, func(i, j int) bool {
+		return len(q.routes[i].prefix) > len(q.routes[j].prefix)
+	})
+	for i := 0; i+1 < len(q.routes); i++ {
+		if q.routes[i].prefix == q.routes[i+1].prefix {
+			q.routes = append(q.routes[:i], q.routes[i+1:]...)
+			i--
+		}
+	}
+	return q, nil
+}
+
+// Acquire waits for a slot for a request of class c and returns the
+// function that gives it back. It fails when the queue of c is full, when
+// c's timeout passes first or when ctx is done.
+func (q *Queue) Acquire(ctx context.Context, c Class) (release func(), err error) {
+	if q == nil {
+		return func() {}, nil
+	}
+	cl := q.classes[c]
+
+	q.mu.Lock()
+	if q.inFlight < q.max {
+		q.inFlight++
+		q.mu.Unlock()
+		inFlight.Inc()
+		return q.releaser(), nil
+	}
+	if cl.waiters.Len() >= cl.depth {
+		q.mu.Unlock()
+		rejectedTotal.WithLabelValues(c.String(), "full").Inc()
+		return nil, ErrQueueFull
+	}
+	ready := make(chan struct{})
+	elem := cl.waiters.PushBack(ready)
+	q.mu.Unlock()
+
+	gauge := waiting.WithLabelValues(c.String())
+	gauge.Inc()
+	defer gauge.Dec()
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDuration(ctx, waitDuration.WithLabelValues(c.String()), time.Since(start), err != nil)
+	}()
+
+	timer := time.NewTimer(cl.timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return q.releaser(), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = ErrTimeout
+		rejectedTotal.WithLabelValues(c.String(), "timeout").Inc()
+	}
+
+	q.mu.Lock()
+	select {
+	case <-ready:
+		// handed a slot while giving up; pass it on
+		q.mu.Unlock()
+		q.releaser()()
+	default:
+		cl.waiters.Remove(elem)
+		q.mu.Unlock()
+	}
+	return nil, err
+}
+
+// releaser returns the function handing a held slot to the first waiter of
+// the highest class, or freeing it. Calls after the first are no-ops.
+func (q *Queue) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			for _, cl := range q.classes {
+				if front := cl.waiters.Front(); front != nil {
+					cl.waiters.Remove(front)
+					close(front.Value.(chan struct{}))
+					return
+				}
+			}
+			q.inFlight--
+			inFlight.Dec()
+		})
+	}
+}
+
+// Class returns the class of r, and false when r is not queued.
+func (q *Queue) Class(r *http.Request) (Class, bool) {
+	for _, rt := range q.routes {
+		if strings.HasPrefix(r.URL.Path, rt.prefix) {
+			return rt.class, true
+		}
+	}
+	return Interactive, false
+}
+
+// Middleware holds a slot of the class of each request while it is served.
+// Requests that cannot get one are rejected with 503 and Retry-After.
+func (q *Queue) Middleware(next http.Handler) http.Handler {
+	if q == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := q.Class(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		release, err := q.Acquire(r.Context(), c)
+		if err != nil {
+			if r.Context().Err() != nil {
+				// the client is gone
+				return
+			}
+			q.reject(w, r, err)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (q *Queue) reject(w http.ResponseWriter, r *http.Request, err error) {
+	msg := i18n.Message(w, r, "queue_full")
+	if errors.Is(err, ErrTimeout) {
+		msg = i18n.Message(w, r, "queue_timeout")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(q.retryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":               "overloaded",
+		"message":             msg,
+		"retry_after_seconds": q.retryAfter,
+	})
+}
@@ -0,0 +1,167 @@
+package queue_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitFor enqueues an Acquire of class c and returns the channel that
+// receives its release function once it gets a slot.
+func waitFor(t *testing.T, q *queue.Queue, c queue.Class) chan func() {
+	t.Helper()
+	got := make(chan func(), 1)
+	go func() {
+		release, err := q.Acquire(context.Background(), c)
+		assert.NoError(t, err)
+		got <- release
+	}()
+	// let the waiter join its queue
+	time.Sleep(20 * time.Millisecond)
+	return got
+}
+
+// TestQueue_Priority tests that freed slots go to higher classes first
+func TestQueue_Priority(t *testing.T) {
+	q, err := queue.New(queue.Config{MaxInFlight: 1})
+	require.NoError(t, err)
+
+	release, err := q.Acquire(context.Background(), queue.Bulk)
+	require.NoError(t, err)
+
+	bulk := waitFor(t, q, queue.Bulk)
+	interactive := waitFor(t, q, queue.Interactive)
+	auth := waitFor(t, q, queue.Auth)
+
+	release()
+	next := <-auth
+	select {
+	case <-interactive:
+		t.Fatal("interactive request served before the auth request finished")
+	case <-bulk:
+		t.Fatal("bulk request served before the auth request finished")
+	default:
+	}
+
+	next()
+	next = <-interactive
+	next()
+	next = <-bulk
+	next()
+	next() // releasing twice is a no-op
+
+	// the slot is free again
+	release, err = q.Acquire(context.Background(), queue.Bulk)
+	require.NoError(t, err)
+	release()
+}
+
+// TestQueue_Limits tests rejections when a class queue is full or times out
+func TestQueue_Limits(t *testing.T) {
+	q, err := queue.New(queue.Config{
+		MaxInFlight: 1,
+		Classes: map[string]queue.ClassConfig{
+			"bulk": {QueueDepth: 1, Timeout: 50 * time.Millisecond},
+		},
+	})
+	require.NoError(t, err)
+
+	release, err := q.Acquire(context.Background(), queue.Interactive)
+	require.NoError(t, err)
+	defer release()
+
+	timedOut := make(chan error, 1)
+	go func() {
+		_, err := q.Acquire(context.Background(), queue.Bulk)
+		timedOut <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = q.Acquire(context.Background(), queue.Bulk)
+	assert.ErrorIs(t, err, queue.ErrQueueFull)
+	assert.ErrorIs(t, <-timedOut, queue.ErrTimeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = q.Acquire(ctx, queue.Bulk)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// TestMiddleware tests classification by route and rejection of requests that cannot be queued
+func TestMiddleware(t *testing.T) {
+	q, err := queue.New(queue.Config{
+		MaxInFlight: 1,
+		Routes:      []queue.RouteConfig{{PathPrefix: "/inventory/get", Class: "auth"}},
+		Classes: map[string]queue.ClassConfig{
+			"interactive": {Timeout: 20 * time.Millisecond},
+		},
+	})
+	require.NoError(t, err)
+
+	classOf := func(path string) (queue.Class, bool) {
+		return q.Class(httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	c, ok := classOf("/auth/login")
+	assert.True(t, ok)
+	assert.Equal(t, queue.Auth, c)
+	c, _ = classOf("/inventory/list")
+	assert.Equal(t, queue.Interactive, c)
+	c, _ = classOf("/inventory/products/import")
+	assert.Equal(t, queue.Bulk, c)
+	c, _ = classOf("/inventory/get")
+	assert.Equal(t, queue.Auth, c)
+	_, ok = classOf("/health")
+	assert.False(t, ok)
+
+	var started sync.WaitGroup
+	started.Add(1)
+	unblock := make(chan struct{})
+	h := q.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth/login" {
+			started.Done()
+			<-unblock
+		}
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/auth/login", nil))
+	}()
+	started.Wait()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/inventory/list", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "timed out waiting in the request queue")
+
+	// unqueued routes pass while the slot is held
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	close(unblock)
+	<-done
+}
+
+// TestNew tests that disabled queues pass everything through and invalid classes are rejected
+func TestNew(t *testing.T) {
+	q, err := queue.New(queue.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, q)
+	release, err := q.Acquire(context.Background(), queue.Bulk)
+	require.NoError(t, err)
+	release()
+
+	_, err = queue.New(queue.Config{MaxInFlight: 1, Classes: map[string]queue.ClassConfig{"batch": {}}})
+	assert.Error(t, err)
+	_, err = queue.New(queue.Config{MaxInFlight: 1, Routes: []queue.RouteConfig{{PathPrefix: "/x", Class: "urgent"}}})
+	assert.Error(t, err)
+}
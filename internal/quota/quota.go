@@ -0,0 +1,272 @@
+// Package quota enforces daily and monthly request budgets per client, a
+// client being a known API key or tenant sent in a header, the
+// authenticated user or, failing both, the client IP. Budgets are counted per calendar day and month in UTC, in memory or
+// in Redis, and reported in X-RateLimit-* response headers.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Config configures the request budgets.
+type Config struct {
+	// Header identifies the client, e.g. X-API-Key or X-Tenant-ID. Only
+	// values listed in Keys or Clients are accepted; requests with another
+	// value are rejected. Requests without the header are counted for the
+	// user of their verified access token, or else for their client IP.
+	Header string `yaml:"header"`
+
+	// Keys are the header values accepted as clients with the default
+	// limits, in addition to those in Clients.
+	Keys []string `yaml:"keys"`
+
+	// Limits applies to every client without its own entry in Clients.
+	Limits Limits `yaml:",inline"`
+
+	// Clients overrides the limits of individual clients.
+	Clients map[string]Limits `yaml:"clients"`
+
+	// Redis keeps the counters in Redis instead of memory when its address
+	// is set, so that every gateway instance shares the budgets.
+	Redis RedisConfig `yaml:"redis"`
+
+	// MaxCounters bounds the counters kept in memory. Default: 100000.
+	MaxCounters int `yaml:"max_counters"`
+}
+
+// Limits are the budgets of a client. Zero means unlimited.
+type Limits struct {
+	Daily   int64 `yaml:"daily" json:"daily"`
+	Monthly int64 `yaml:"monthly" json:"monthly"`
+}
+
+// Periods.
+const (
+	Daily   = "daily"
+	Monthly = "monthly"
+)
+
+var rejectedTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "quota",
+	Name:      "rejected_total",
+	Help:      "Requests rejected because a client ran out of its budget, by exhausted period, or sent an unknown key (unknown_client).",
+}, []string{"period"})
+
+// Meter counts requests against the budgets.
+type Meter struct {
+	cfg   Config
+	keys  map[string]bool
+	store Store
+}
+
+// New returns a Meter counting in Redis when configured, in memory
+// otherwise. It returns nil when no limit is set; a nil Meter lets every
+// request through.
+func New(cfg Config) (*Meter, error) {
+	if cfg.Limits == (Limits{}) && len(cfg.Clients) == 0 {
+		return nil, nil
+	}
+	if cfg.Header != "" && len(cfg.Keys) == 0 && len(cfg.Clients) == 0 {
+		return nil, fmt.Errorf("quotas header %s needs keys or clients to accept", cfg.Header)
+	}
+	if cfg.MaxCounters < 0 {
+		return nil, fmt.Errorf("quotas max_counters %d must not be negative", cfg.MaxCounters)
+	}
+	var store Store = NewMemoryStore(cfg.MaxCounters)
+	if cfg.Redis.Addr != "" {
+		var err error
+		if store, err = NewRedisStore(cfg.Redis); err != nil {
+			return nil, err
+		}
+	}
+	return NewWithStore(cfg, store), nil
+}
+
+// NewWithStore returns a Meter keeping its counters in store.
+func NewWithStore(cfg Config, store Store) *Meter {
+	keys := map[string]bool{}
+	for _, key := range cfg.Keys {
+		keys[key] = true
+	}
+	for key := range cfg.Clients {
+		keys[key] = true
+	}
+	return &Meter{cfg: cfg, keys: keys, store: store}
+}
+
+// Usage is the state of one budget of a client.
+type Usage struct {
+	Period    string    `json:"period"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// window is the counter of a period at some time.
+type window struct {
+	period string
+	limit  int64
+	key    string
+	reset  time.Time
+}
+
+// windows returns the limited periods of client at now.
+func (m *Meter) windows(client string, now time.Time) []window {
+	limits, ok := m.cfg.Clients[client]
+	if !ok {
+		limits = m.cfg.Limits
+	}
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var out []window
+	if limits.Daily > 0 {
+		out = append(out, window{Daily, limits.Daily, client + ":" + day.Format("2006-01-02"), day.AddDate(0, 0, 1)})
+	}
+	if limits.Monthly > 0 {
+		out = append(out, window{Monthly, limits.Monthly, client + ":" + month.Format("2006-01"), month.AddDate(0, 1, 0)})
+	}
+	return out
+}
+
+// Take counts one request of client. It returns the usage of every limited
+// period and, when a budget is exhausted, that period; the request is then
+// not counted.
+func (m *Meter) Take(ctx context.Context, client string) ([]Usage, string, error) {
+	windows := m.windows(client, time.Now())
+	usage := make([]Usage, 0, len(windows))
+	exhausted := ""
+	for _, w := range windows {
+		used, err := m.store.Add(ctx, w.key, 1, time.Until(w.reset)+time.Hour)
+		if err != nil {
+			return nil, "", err
+		}
+		if used > w.limit && exhausted == "" {
+			exhausted = w.period
+		}
+		usage = append(usage, Usage{Period: w.period, Limit: w.limit, Used: used, Remaining: max(w.limit-used, 0), Reset: w.reset})
+	}
+	if exhausted != "" {
+		// refund: a rejected request does not spend the other budgets
+		for i, w := range windows {
+			if _, err := m.store.Add(ctx, w.key, -1, time.Until(w.reset)+time.Hour); err != nil {
+				return nil, "", err
+			}
+			usage[i].Used--
+			usage[i].Remaining = max(w.limit-usage[i].Used, 0)
+		}
+	}
+	return usage, exhausted, nil
+}
+
+// Usage returns the current usage of every limited period of client.
+func (m *Meter) Usage(ctx context.Context, client string) ([]Usage, error) {
+	var usage []Usage
+	for _, w := range m.windows(client, time.Now()) {
+		used, err := m.store.Get(ctx, w.key)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, Usage{Period: w.period, Limit: w.limit, Used: used, Remaining: max(w.limit-used, 0), Reset: w.reset})
+	}
+	return usage, nil
+}
+
+// Reset clears the current counters of client.
+func (m *Meter) Reset(ctx context.Context, client string) error {
+	for _, w := range m.windows(client, time.Now()) {
+		if err := m.store.Delete(ctx, w.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the store.
+func (m *Meter) Close() error {
+	if m == nil {
+		return nil
+	}
+	return m.store.Close()
+}
+
+// Client returns the client r is counted for: the value of the header when
+// it is a known key, else the user of a verified access token, else the
+// client IP prefixed with "ip:". It returns false when r carries an unknown
+// key.
+func (m *Meter) Client(r *http.Request) (string, bool) {
+	if m.cfg.Header != "" {
+		if key := r.Header.Get(m.cfg.Header); key != "" {
+			return key, m.keys[key]
+		}
+	}
+	if claims, ok := token.FromContext(r.Context()); ok && claims.Verified && claims.UserID != "" {
+		return claims.UserID, true
+	}
+	ip, ok := realip.FromContext(r.Context())
+	if !ok {
+		ip = realip.Peer(r)
+	}
+	return "ip:" + ip, true
+}
+
+// Middleware counts requests against their client's budgets, sets the
+// X-RateLimit-* headers of the tightest one and rejects requests over
+// budget with 429. Counters that cannot be reached let requests through.
+func (m *Meter) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := m.Client(r)
+		if !ok {
+			rejectedTotal.WithLabelValues("unknown_client").Inc()
+			http.Error(w, "unknown "+m.cfg.Header, http.StatusForbidden)
+			return
+		}
+		usage, exhausted, err := m.Take(r.Context(), client)
+		if err != nil {
+			logger.Logger().Warn("Failed to count request quota", zap.String("client", client), zap.Error(err))
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(usage) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tightest := usage[0]
+		for _, u := range usage[1:] {
+			if u.Period == exhausted || (exhausted == "" && u.Remaining < tightest.Remaining) {
+				tightest = u
+			}
+		}
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.FormatInt(tightest.Limit, 10))
+		h.Set("X-RateLimit-Remaining", strconv.FormatInt(tightest.Remaining, 10))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(tightest.Reset.Unix(), 10))
+
+		if exhausted != "" {
+			rejectedTotal.WithLabelValues(exhausted).Inc()
+			h.Set("Retry-After", strconv.Itoa(int(time.Until(tightest.Reset).Seconds())+1))
+			http.Error(w, fmt.Sprintf("%s quota exceeded", exhausted), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package quota_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMeter_Middleware tests the rate limit headers and the 429 of an exhausted budget
+func TestMeter_Middleware(t *testing.T) {
+	m, err := quota.New(quota.Config{
+		Header:  "X-API-Key",
+		Keys:    []string{"small"},
+		Limits:  quota.Limits{Daily: 2, Monthly: 10},
+		Clients: map[string]quota.Limits{"big": {Daily: 100}},
+	})
+	require.NoError(t, err)
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/inventory/get", nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := send("small")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+	now := time.Now().UTC()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, strconv.FormatInt(tomorrow.Unix(), 10), w.Header().Get("X-RateLimit-Reset"))
+
+	assert.Equal(t, http.StatusOK, send("small").Code)
+	w = send("small")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "daily quota exceeded")
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	usage, err := m.Usage(context.Background(), "small")
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	assert.Equal(t, int64(2), usage[0].Used)
+	assert.Equal(t, int64(2), usage[1].Used, "rejected requests are not counted")
+
+	for range 3 {
+		assert.Equal(t, http.StatusOK, send("big").Code)
+	}
+	w = send("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"), "requests without a key are counted per client IP")
+	assert.Equal(t, http.StatusOK, send("").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send("").Code)
+
+	w = send("made-up")
+	assert.Equal(t, http.StatusForbidden, w.Code, "unknown keys cannot open fresh budgets")
+	assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+
+	require.NoError(t, m.Reset(context.Background(), "small"))
+	assert.Equal(t, http.StatusOK, send("small").Code)
+}
+
+// TestNew_Disabled tests that a nil Meter passes every request through
+func TestNew_Disabled(t *testing.T) {
+	m, err := quota.New(quota.Config{Header: "X-API-Key"})
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	w := httptest.NewRecorder()
+	m.Middleware(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestNew_UnknownKeys tests that a client header needs the keys it accepts
+func TestNew_UnknownKeys(t *testing.T) {
+	_, err := quota.New(quota.Config{Header: "X-API-Key", Limits: quota.Limits{Daily: 10}})
+	assert.ErrorContains(t, err, "needs keys or clients")
+}
+
+// TestMemoryStore_Bounded tests that many distinct clients cannot grow the memory store past its bound
+func TestMemoryStore_Bounded(t *testing.T) {
+	store := quota.NewMemoryStore(100)
+	ctx := context.Background()
+	for i := range 10000 {
+		_, err := store.Add(ctx, "client-"+strconv.Itoa(i), 1, time.Hour)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 100, store.Len())
+
+	n, err := store.Add(ctx, "client-9999", 1, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n, "recent counters are kept")
+
+	_, err = store.Add(ctx, "short", 1, time.Nanosecond)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	n, err = store.Add(ctx, "short", 1, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "expired counters start over")
+}
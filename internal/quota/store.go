@@ -0,0 +1,184 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store keeps the request counters.
+type Store interface {
+	// Add adds delta to the counter of key, created to expire after ttl,
+	// and returns the new value.
+	Add(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	Get(ctx context.Context, key string) (int64, error)
+	Delete(ctx context.Context, key string) error
+	Close() error
+}
+
+type counter struct {
+	n       int64
+	expires time.Time
+}
+
+// sweepInterval is how often a MemoryStore drops expired counters.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the counters in process memory. Each gateway instance
+// then enforces the whole budget on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]counter
+	max       int
+	nextSweep time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore keeping at most max counters,
+// 100000 when max is 0.
+func NewMemoryStore(max int) *MemoryStore {
+	if max <= 0 {
+		max = 100000
+	}
+	return &MemoryStore{counters: map[string]counter{}, max: max}
+}
+
+// Len returns the number of counters kept.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.counters)
+}
+
+// Add updates the counter of key. Expired counters are dropped once per
+// sweep interval, or when the store is full; a full store then evicts an
+// arbitrary counter rather than grow.
+func (s *MemoryStore) Add(_ context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	c, ok := s.counters[key]
+	if ok && now.After(c.expires) {
+		c, ok = counter{}, false
+	}
+	if !ok {
+		if len(s.counters) >= s.max || now.After(s.nextSweep) {
+			s.sweep(now)
+		}
+		if len(s.counters) >= s.max {
+			for k := range s.counters {
+				delete(s.counters, k)
+				break
+			}
+		}
+		c.expires = now.Add(ttl)
+	}
+	c.n += delta
+	s.counters[key] = c
+	return c.n, nil
+}
+
+// sweep drops the expired counters.
+func (s *MemoryStore) sweep(now time.Time) {
+	for k, c := range s.counters {
+		if now.After(c.expires) {
+			delete(s.counters, k)
+		}
+	}
+	s.nextSweep = now.Add(sweepInterval)
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[key]
+	if !ok || time.Now().After(c.expires) {
+		return 0, nil
+	}
+	return c.n, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// RedisConfig configures the Redis store.
+type RedisConfig struct {
+	// Addr is the host:port of the Redis server.
+	Addr string `yaml:"addr"`
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+
+	// Prefix is prepended to counter keys. Default: "gateway:quota:".
+	Prefix string `yaml:"prefix"`
+}
+
+// storeTimeout bounds the connection check of the Redis store.
+const storeTimeout = 5 * time.Second
+
+// RedisStore keeps the counters in Redis, shared by every gateway instance.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects to Redis and checks that it answers.
+func NewRedisStore(cfg RedisConfig) (*RedisStore, error) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "gateway:quota:"
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("quota: failed to connect to Redis: %w", err)
+	}
+	return &RedisStore{client: client, prefix: cfg.Prefix}, nil
+}
+
+func (s *RedisStore) Add(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		incr = p.IncrBy(ctx, s.prefix+key, delta)
+		p.ExpireNX(ctx, s.prefix+key, ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (int64, error) {
+	n, err := s.client.Get(ctx, s.prefix+key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
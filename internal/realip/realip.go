@@ -0,0 +1,148 @@
+// Package realip resolves the originating client IP of a request. Forwarding
+// headers are only believed when the connecting peer is a trusted proxy, so
+// clients cannot spoof their address by sending X-Forwarded-For themselves.
+package realip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Config configures client IP resolution.
+type Config struct {
+	// TrustedProxies are the CIDRs (or single IPs) of proxies whose
+	// X-Forwarded-For, X-Real-IP and X-Forwarded-Proto headers are honoured.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// Resolver derives client IPs from requests.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// New parses the trusted proxy ranges.
+func New(cfg Config) (*Resolver, error) {
+	trusted, err := ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	return &Resolver{trusted: trusted}, nil
+}
+
+// ParseCIDRs parses a list of CIDRs. Bare IPs are treated as single hosts.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Trusted reports whether addr belongs to a trusted proxy. A nil Resolver
+// trusts nobody.
+func (res *Resolver) Trusted(addr string) bool {
+	if res == nil {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range res.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Peer returns the IP of the directly connected peer.
+func Peer(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// ForwardedFor returns the addresses listed in the request's X-Forwarded-For
+// headers, oldest first.
+func ForwardedFor(r *http.Request) []string {
+	var chain []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(h, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				chain = append(chain, addr)
+			}
+		}
+	}
+	return chain
+}
+
+// ClientIP returns the originating client IP. When the peer is trusted, it is
+// the rightmost X-Forwarded-For address that is not itself a trusted proxy,
+// falling back to X-Real-IP; otherwise it is the peer.
+func (res *Resolver) ClientIP(r *http.Request) string {
+	peer := Peer(r)
+	if !res.Trusted(peer) {
+		return peer
+	}
+
+	chain := ForwardedFor(r)
+	for i := len(chain) - 1; i >= 0; i-- {
+		if net.ParseIP(chain[i]) == nil {
+			// garbage in the chain: the trusted peer is the last hop we can vouch for
+			return peer
+		}
+		if !res.Trusted(chain[i]) {
+			return chain[i]
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil && !res.Trusted(ip) {
+		return ip
+	}
+	if len(chain) > 0 {
+		// every hop is trusted; the first one is the closest we get to the client
+		return chain[0]
+	}
+	return peer
+}
+
+type ipKey struct{}
+
+// WithIP returns a copy of ctx carrying the client IP.
+func WithIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ipKey{}, ip)
+}
+
+// FromContext returns the client IP stored by Middleware.
+func FromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(ipKey{}).(string)
+	return ip, ok && ip != ""
+}
+
+// Middleware resolves the client IP once per request and stores it in the
+// request context for logging, rate limiting and audit.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithIP(r.Context(), res.ClientIP(r))))
+	})
+}
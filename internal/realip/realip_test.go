@@ -0,0 +1,66 @@
+package realip_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(remote string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remote
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+// TestClientIP tests client IP resolution for trusted and untrusted peers
+func TestClientIP(t *testing.T) {
+	res, err := realip.New(realip.Config{TrustedProxies: []string{"10.0.0.0/8", "::1"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer ignores headers", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"}, "203.0.113.7"},
+		{"trusted peer uses forwarded for", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+		{"skips trusted hops", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.9"}, "1.2.3.4"},
+		{"stops at garbage", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, bogus"}, "10.0.0.1"},
+		{"falls back to real ip", "[::1]:1234", map[string]string{"X-Real-IP": "198.51.100.4"}, "198.51.100.4"},
+		{"no headers", "10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, res.ClientIP(newRequest(tt.remote, tt.headers)))
+		})
+	}
+}
+
+// TestMiddleware tests that the resolved IP is stored in the request context
+func TestMiddleware(t *testing.T) {
+	res, err := realip.New(realip.Config{TrustedProxies: []string{"10.0.0.1"}})
+	require.NoError(t, err)
+
+	var got string
+	h := res.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = realip.FromContext(r.Context())
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), newRequest("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}))
+	assert.Equal(t, "1.2.3.4", got)
+}
+
+// TestNew_InvalidProxy tests that malformed trusted proxies are rejected
+func TestNew_InvalidProxy(t *testing.T) {
+	_, err := realip.New(realip.Config{TrustedProxies: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+	_, err = realip.New(realip.Config{TrustedProxies: []string{"proxy.local"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,56 @@
+// Package recovery turns handler panics into 500 responses instead of
+// dropped connections, and logs them with their stack.
+package recovery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/andro-kes/gateway/internal/http/instrument"
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"go.uber.org/zap"
+)
+
+// Middleware recovers panics of the handlers after it. A response whose
+// header is not out yet becomes a JSON 500; one already under way can no
+// longer be fixed, so its connection is aborted to show the client it is
+// incomplete.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iw := instrument.Wrap(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(p)
+			}
+			id, _ := requestid.FromContext(r.Context())
+			logger.Logger().Error("Handler panicked",
+				zap.Any("panic", p),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("request_id", id),
+				zap.Bool("response_started", iw.Written()),
+				zap.ByteString("stack", debug.Stack()),
+			)
+			if iw.Written() || iw.Hijacked() {
+				panic(http.ErrAbortHandler)
+			}
+			message := i18n.Message(iw, r, "internal_error")
+			iw.Header().Set("Content-Type", "application/json")
+			iw.Header().Set("X-Content-Type-Options", "nosniff")
+			iw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(iw).Encode(map[string]string{
+				"error":   "internal_error",
+				"message": message,
+			})
+		}()
+		next.ServeHTTP(iw, r)
+	})
+}
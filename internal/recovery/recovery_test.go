@@ -0,0 +1,42 @@
+package recovery_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/http/instrument"
+	"github.com/andro-kes/gateway/internal/recovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMiddleware tests that panics before the response become a JSON 500 seen by the middlewares before recovery
+func TestMiddleware(t *testing.T) {
+	rec := httptest.NewRecorder()
+	iw := instrument.Wrap(rec)
+	recovery.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("nil map")
+	})).ServeHTTP(iw, httptest.NewRequest("GET", "/inventory/42", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, http.StatusInternalServerError, iw.Status())
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "internal_error", body["error"])
+}
+
+// TestMiddleware_Started tests that panics after the response started abort it instead of appending an error
+func TestMiddleware_Started(t *testing.T) {
+	h := recovery.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"products": [`))
+		panic("nil map")
+	}))
+	rec := httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/inventory", nil))
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"products": [`, rec.Body.String())
+}
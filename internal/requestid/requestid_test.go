@@ -0,0 +1,43 @@
+package requestid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(header string) (ctxID, respID string) {
+	h := requestid.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxID, _ = requestid.FromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if header != "" {
+		req.Header.Set(requestid.Header, header)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return ctxID, rec.Header().Get(requestid.Header)
+}
+
+// TestMiddleware_KeepsClientID tests that a valid inbound ID is reused
+func TestMiddleware_KeepsClientID(t *testing.T) {
+	ctxID, respID := serve("frontend-7f3a:42")
+	assert.Equal(t, "frontend-7f3a:42", ctxID)
+	assert.Equal(t, "frontend-7f3a:42", respID)
+}
+
+// TestMiddleware_GeneratesID tests that missing or unsafe IDs are replaced
+func TestMiddleware_GeneratesID(t *testing.T) {
+	for _, header := range []string{"", "bad id\r\n", strings.Repeat("a", 200)} {
+		ctxID, respID := serve(header)
+		assert.Len(t, ctxID, 32)
+		assert.Equal(t, ctxID, respID)
+	}
+	a, _ := serve("")
+	b, _ := serve("")
+	assert.NotEqual(t, a, b)
+}
@@ -0,0 +1,531 @@
+// Package respcache caches the responses of GET routes in memory, so that
+// repeated reads such as product lookups are answered without a backend
+// call. Operators list, purge and toggle cached routes through the admin
+// API, e.g. to drop stale product data without a restart.
+package respcache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Header reports on cached routes whether a response came from the cache
+// (HIT) or from the handler (MISS).
+const Header = "X-Cache"
+
+// Config configures the response cache.
+type Config struct {
+	// Rules are the routes whose responses are cached. The first rule whose
+	// path prefix matches applies. No rules disable the cache.
+	Rules []Rule `yaml:"rules"`
+
+	// MaxEntries bounds the cache; the least recently used entries are
+	// evicted. Default: 10000.
+	MaxEntries int `yaml:"max_entries"`
+
+	// MaxBodySize is the largest response body that is cached. Default: 1 MiB.
+	MaxBodySize int `yaml:"max_body_size"`
+}
+
+// Rule caches the GET responses of a group of routes.
+type Rule struct {
+	// PathPrefix selects the routes, e.g. "/inventory/get".
+	PathPrefix string `yaml:"path_prefix"`
+
+	// TTL is how long responses are served from the cache.
+	TTL time.Duration `yaml:"ttl"`
+
+	// Shared serves one cached response to every caller. Otherwise each
+	// user gets their own entries. Only share data that is the same for
+	// every user.
+	Shared bool `yaml:"shared"`
+
+	// Vary are the request headers that select different responses, in
+	// addition to Accept and Accept-Language.
+	Vary []string `yaml:"vary"`
+
+	// Disabled starts the rule switched off; the admin API switches it on.
+	Disabled bool `yaml:"disabled"`
+}
+
+var (
+	requestsTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "response_cache",
+		Name:      "requests_total",
+		Help:      "Requests of cached routes, by route and result (hit, miss).",
+	}, []string{"route", "result"})
+
+	entriesGauge = promauto.With(metrics.Registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "response_cache",
+		Name:      "entries",
+		Help:      "Responses held by the response cache.",
+	})
+
+	purgedTotal = promauto.With(metrics.Registry).NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "response_cache",
+		Name:      "purged_total",
+		Help:      "Responses purged from the response cache through the admin API.",
+	})
+)
+
+// Cache holds responses in memory. A nil *Cache caches nothing.
+type Cache struct {
+	maxEntries int
+	maxBody    int
+
+	mu      sync.Mutex
+	rules   []*rule
+	entries map[string]*list.Element
+	lru     *list.List // of *entry, most recently used first
+}
+
+type rule struct {
+	Rule
+	vary    []string
+	enabled bool
+}
+
+type entry struct {
+	key     string
+	url     string
+	reqBody string // hash of the request body, if any
+	route   string
+	user    string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+	hits    int
+}
+
+// New returns the Cache of cfg, or nil without rules.
+func New(cfg Config) (*Cache, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 10000
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = 1 << 20
+	}
+	c := &Cache{
+		maxEntries: cfg.MaxEntries,
+		maxBody:    cfg.MaxBodySize,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+	for _, r := range cfg.Rules {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return nil, fmt.Errorf("response cache path prefix %q must start with /", r.PathPrefix)
+		}
+		if r.TTL <= 0 {
+			return nil, fmt.Errorf("response cache %s: ttl must be positive", r.PathPrefix)
+		}
+		vary := []string{"Accept", "Accept-Language"}
+		for _, h := range r.Vary {
+			vary = append(vary, http.CanonicalHeaderKey(h))
+		}
+		c.rules = append(c.rules, &rule{Rule: r, vary: vary, enabled: !r.Disabled})
+	}
+	return c, nil
+}
+
+func (c *Cache) match(path string) *rule {
+	for _, r := range c.rules {
+		if strings.HasPrefix(path, r.PathPrefix) {
+			return r
+		}
+	}
+	return nil
+}
+
+// key identifies the response to r, whose body hashes to bodyHash, under
+// rule. Unshared entries are keyed by the user of the verified token.
+func (rule *rule) key(r *http.Request, bodyHash string) (key, user string) {
+	if !rule.Shared {
+		if claims, ok := token.FromContext(r.Context()); ok {
+			user = claims.UserID
+		}
+	}
+	var b strings.Builder
+	b.WriteString(r.URL.RequestURI())
+	b.WriteString("\x00")
+	b.WriteString(bodyHash)
+	b.WriteString("\x00")
+	b.WriteString(user)
+	for _, h := range rule.vary {
+		b.WriteString("\x00")
+		b.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String(), user
+}
+
+// hashBody reads the request body, which selects the response on routes
+// such as /inventory/get, hashes it and puts it back for the handler. ok is
+// false when the body is larger than limit or fails to read; the handler
+// still gets all of it.
+func hashBody(r *http.Request, limit int) (hash string, ok bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", true
+	}
+	prefix, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	if err != nil || len(prefix) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+		return "", false
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(prefix))
+	if len(prefix) == 0 {
+		return "", true
+	}
+	sum := sha256.Sum256(prefix)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// Middleware answers GET requests of enabled routes from the cache, and
+// caches their 200 responses for the TTL of the route. Requests are keyed by
+// their body as well as their URL; bodies over the largest cached response
+// size bypass the cache. Responses setting cookies or marked no-store, and
+// private ones on shared routes, are not cached. Place it after
+// authentication: cached responses skip the handlers.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.mu.Lock()
+		rule := c.match(r.URL.Path)
+		if rule == nil || !rule.enabled {
+			c.mu.Unlock()
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.mu.Unlock()
+		bodyHash, ok := hashBody(r, c.maxBody)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, user := rule.key(r, bodyHash)
+		c.mu.Lock()
+		e := c.lookup(key)
+		c.mu.Unlock()
+
+		if e != nil {
+			requestsTotal.WithLabelValues(rule.PathPrefix, "hit").Inc()
+			e.serve(w, r)
+			return
+		}
+		requestsTotal.WithLabelValues(rule.PathPrefix, "miss").Inc()
+		rec := &recorder{ResponseWriter: w, limit: c.maxBody}
+		next.ServeHTTP(rec, r)
+		if !rec.cacheable(rule.Shared) {
+			return
+		}
+		now := time.Now()
+		c.store(&entry{
+			key:     key,
+			url:     r.URL.RequestURI(),
+			reqBody: bodyHash,
+			route:   rule.PathPrefix,
+			user:    user,
+			status:  rec.status,
+			header:  rec.header,
+			body:    rec.body.Bytes(),
+			stored:  now,
+			expires: now.Add(rule.TTL),
+		})
+	})
+}
+
+// lookup returns the live entry of key and counts the hit. c.mu is held.
+func (c *Cache) lookup(key string) *entry {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*entry)
+	if !time.Now().Before(e.expires) {
+		c.remove(el)
+		return nil
+	}
+	e.hits++
+	c.lru.MoveToFront(el)
+	return e
+}
+
+func (c *Cache) store(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rule := c.match(strings.SplitN(e.url, "?", 2)[0]); rule == nil || !rule.enabled {
+		// switched off while the response was produced
+		return
+	}
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	for c.lru.Len() >= c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	entriesGauge.Set(float64(c.lru.Len()))
+}
+
+// remove drops el. c.mu is held.
+func (c *Cache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*entry).key)
+	c.lru.Remove(el)
+	entriesGauge.Set(float64(c.lru.Len()))
+}
+
+func (e *entry) serve(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = slices.Clone(v)
+	}
+	h.Set(Header, "HIT")
+	h.Set("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+	if etag := e.header.Get("ETag"); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// etagMatches reports whether an If-None-Match header value lists etag or
+// "*".
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder keeps a copy of the response to cache it.
+type recorder struct {
+	http.ResponseWriter
+	limit     int
+	status    int
+	header    http.Header
+	body      bytes.Buffer
+	truncated bool
+	flushed   bool
+}
+
+func (w *recorder) WriteHeader(code int) {
+	// informational responses are followed by the final one
+	if w.status == 0 && code >= 200 {
+		w.status = code
+		w.Header().Set(Header, "MISS")
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.body.Len()+len(b) > w.limit {
+		w.truncated = true
+	} else if !w.truncated {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush passes the flush on. Flushed responses are streams and are not
+// cached.
+func (w *recorder) Flush() {
+	w.flushed = true
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *recorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *recorder) cacheable(shared bool) bool {
+	if w.status != http.StatusOK || w.truncated || w.flushed {
+		return false
+	}
+	if w.header.Get("Set-Cookie") != "" || w.header.Get("Vary") == "*" {
+		return false
+	}
+	cc := strings.ToLower(w.header.Get("Cache-Control"))
+	if strings.Contains(cc, "no-store") || shared && strings.Contains(cc, "private") {
+		return false
+	}
+	w.header.Del(Header)
+	return true
+}
+
+// Route is the state of a cache rule, as reported and set by the admin API.
+type Route struct {
+	PathPrefix string `json:"path_prefix"`
+	TTL        string `json:"ttl,omitempty"`
+	Shared     bool   `json:"shared"`
+	Enabled    bool   `json:"enabled"`
+	Entries    int    `json:"entries"`
+}
+
+// Entry describes a cached response.
+type Entry struct {
+	URL        string    `json:"url"`
+	BodyHash   string    `json:"body_sha256,omitempty"`
+	Route      string    `json:"route"`
+	User       string    `json:"user,omitempty"`
+	Status     int       `json:"status"`
+	Size       int       `json:"size"`
+	Hits       int       `json:"hits"`
+	TTLSeconds int       `json:"ttl_seconds"`
+	Stored     time.Time `json:"stored"`
+}
+
+// Routes reports the state of every rule.
+func (c *Cache) Routes() []Route {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := map[string]int{}
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		counts[el.Value.(*entry).route]++
+	}
+	out := make([]Route, len(c.rules))
+	for i, r := range c.rules {
+		out[i] = Route{PathPrefix: r.PathPrefix, TTL: r.TTL.String(), Shared: r.Shared, Enabled: r.enabled, Entries: counts[r.PathPrefix]}
+	}
+	return out
+}
+
+// Entries returns the live entries whose URL matches pattern, sorted by
+// URL. In pattern, * matches any text; an empty pattern matches all.
+func (c *Cache) Entries(pattern string) []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	out := []Entry{}
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+		if !now.Before(e.expires) || !matchPattern(pattern, e.url) {
+			continue
+		}
+		out = append(out, Entry{
+			URL:        e.url,
+			BodyHash:   e.reqBody,
+			Route:      e.route,
+			User:       e.user,
+			Status:     e.status,
+			Size:       len(e.body),
+			Hits:       e.hits,
+			TTLSeconds: int(e.expires.Sub(now).Seconds()),
+			Stored:     e.stored,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	return out
+}
+
+// Purge drops the entries whose URL matches pattern and that belong to
+// route, when set, and returns how many it dropped.
+func (c *Cache) Purge(pattern, route string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*entry)
+		if (route == "" || e.route == route) && matchPattern(pattern, e.url) {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+	purgedTotal.Add(float64(n))
+	return n
+}
+
+// ErrUnknownRoute is returned by SetEnabled for a path prefix without a
+// rule.
+var ErrUnknownRoute = errors.New("no response cache rule for this path prefix")
+
+// SetEnabled switches the rule of pathPrefix on or off. Switching it off
+// drops its entries.
+func (c *Cache) SetEnabled(pathPrefix string, enabled bool) error {
+	c.mu.Lock()
+	var found *rule
+	for _, r := range c.rules {
+		if r.PathPrefix == pathPrefix {
+			found = r
+			break
+		}
+	}
+	if found == nil {
+		c.mu.Unlock()
+		return ErrUnknownRoute
+	}
+	found.enabled = enabled
+	c.mu.Unlock()
+	if !enabled {
+		c.Purge("", pathPrefix)
+	}
+	return nil
+}
+
+// matchPattern reports whether s matches pattern, in which * matches any
+// text.
+func matchPattern(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[last])
+}
@@ -0,0 +1,221 @@
+package respcache_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/respcache"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backend counts its calls and answers with the call number.
+type backend struct {
+	calls int
+}
+
+func (b *backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.calls++
+	switch r.URL.Query().Get("mode") {
+	case "no-store":
+		w.Header().Set("Cache-Control", "no-store")
+	case "cookie":
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s"})
+	case "error":
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("ETag", `"v1"`)
+	fmt.Fprintf(w, "call %d", b.calls)
+}
+
+func newCache(t *testing.T, rules ...respcache.Rule) *respcache.Cache {
+	t.Helper()
+	c, err := respcache.New(respcache.Config{Rules: rules, MaxEntries: 3})
+	require.NoError(t, err)
+	return c
+}
+
+func get(h http.Handler, target, user string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if user != "" {
+		r = r.WithContext(token.WithClaims(r.Context(), &token.Claims{UserID: user}))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestCache_HitAndMiss tests that GET responses are served from the cache until their TTL ends
+func TestCache_HitAndMiss(t *testing.T) {
+	b := &backend{}
+	c := newCache(t, respcache.Rule{PathPrefix: "/inventory/get", TTL: 50 * time.Millisecond, Shared: true})
+	h := c.Middleware(b)
+
+	w := get(h, "/inventory/get?id=p1", "")
+	assert.Equal(t, "call 1", w.Body.String())
+	assert.Equal(t, "MISS", w.Header().Get(respcache.Header))
+
+	w = get(h, "/inventory/get?id=p1", "u2")
+	assert.Equal(t, "call 1", w.Body.String(), "shared entries serve every user")
+	assert.Equal(t, "HIT", w.Header().Get(respcache.Header))
+	assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+
+	assert.Equal(t, "call 2", get(h, "/inventory/get?id=p2", "").Body.String())
+	assert.Equal(t, "call 3", get(h, "/inventory/list", "").Body.String(), "other routes are not cached")
+	assert.Equal(t, "call 4", get(h, "/inventory/list", "").Body.String())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "call 5", get(h, "/inventory/get?id=p1", "").Body.String())
+}
+
+// TestCache_RequestBody tests that routes addressed by their request body, like /inventory/get, keep an entry per body
+func TestCache_RequestBody(t *testing.T) {
+	calls := 0
+	products := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req struct {
+			ID string `json:"id"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		fmt.Fprintf(w, `{"product":{"id":%q}}`, req.ID)
+	})
+	c, err := respcache.New(respcache.Config{Rules: []respcache.Rule{{PathPrefix: "/inventory/get", TTL: time.Minute, Shared: true}}, MaxBodySize: 64})
+	require.NoError(t, err)
+	h := c.Middleware(products)
+
+	getID := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/inventory/get", strings.NewReader(body)))
+		return w
+	}
+	assert.JSONEq(t, `{"product":{"id":"p1"}}`, getID(`{"id":"p1"}`).Body.String())
+	assert.JSONEq(t, `{"product":{"id":"p2"}}`, getID(`{"id":"p2"}`).Body.String())
+	w := getID(`{"id":"p1"}`)
+	assert.Equal(t, "HIT", w.Header().Get(respcache.Header))
+	assert.JSONEq(t, `{"product":{"id":"p1"}}`, w.Body.String())
+	assert.Equal(t, 2, calls)
+	assert.Len(t, c.Entries("/inventory/get"), 2)
+
+	large := `{"id":"p3","pad":"` + strings.Repeat("x", 64) + `"}`
+	assert.JSONEq(t, `{"product":{"id":"p3"}}`, getID(large).Body.String())
+	assert.Empty(t, getID(large).Header().Get(respcache.Header), "bodies over the limit bypass the cache")
+	assert.Equal(t, 4, calls)
+}
+
+// TestCache_PerUser tests that unshared routes keep entries per user
+func TestCache_PerUser(t *testing.T) {
+	b := &backend{}
+	h := newCache(t, respcache.Rule{PathPrefix: "/inventory", TTL: time.Minute}).Middleware(b)
+
+	assert.Equal(t, "call 1", get(h, "/inventory/get?id=p1", "u1").Body.String())
+	assert.Equal(t, "call 2", get(h, "/inventory/get?id=p1", "u2").Body.String())
+	assert.Equal(t, "call 1", get(h, "/inventory/get?id=p1", "u1").Body.String())
+}
+
+// TestCache_Uncacheable tests that errors, no-store responses and responses setting cookies are not cached
+func TestCache_Uncacheable(t *testing.T) {
+	b := &backend{}
+	h := newCache(t, respcache.Rule{PathPrefix: "/", TTL: time.Minute}).Middleware(b)
+
+	for _, mode := range []string{"no-store", "cookie", "error"} {
+		get(h, "/p?mode="+mode, "")
+		w := get(h, "/p?mode="+mode, "")
+		assert.Equal(t, "MISS", w.Header().Get(respcache.Header), mode)
+	}
+	assert.Equal(t, 6, b.calls)
+
+	r := httptest.NewRequest(http.MethodPost, "/p", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, 8, b.calls, "only GET requests are cached")
+}
+
+// TestCache_NotModified tests that cached responses answer conditional requests
+func TestCache_NotModified(t *testing.T) {
+	h := newCache(t, respcache.Rule{PathPrefix: "/", TTL: time.Minute}).Middleware(&backend{})
+	get(h, "/p", "")
+
+	r := httptest.NewRequest(http.MethodGet, "/p", nil)
+	r.Header.Set("If-None-Match", `"v0", "v1"`)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+// TestCache_Eviction tests that the least recently used entry is evicted once the cache is full
+func TestCache_Eviction(t *testing.T) {
+	b := &backend{}
+	c := newCache(t, respcache.Rule{PathPrefix: "/", TTL: time.Minute})
+	h := c.Middleware(b)
+	for _, p := range []string{"/a", "/b", "/c", "/a", "/d"} {
+		get(h, p, "")
+	}
+	var urls []string
+	for _, e := range c.Entries("") {
+		urls = append(urls, e.URL)
+	}
+	assert.Equal(t, []string{"/a", "/c", "/d"}, urls)
+}
+
+// TestCache_Admin tests listing, purging and toggling cached routes
+func TestCache_Admin(t *testing.T) {
+	b := &backend{}
+	c, err := respcache.New(respcache.Config{Rules: []respcache.Rule{
+		{PathPrefix: "/inventory/get", TTL: time.Minute, Shared: true},
+		{PathPrefix: "/catalog", TTL: time.Minute, Disabled: true},
+	}})
+	require.NoError(t, err)
+	h := c.Middleware(b)
+
+	get(h, "/inventory/get?id=p1", "")
+	get(h, "/inventory/get?id=p1", "")
+	get(h, "/inventory/get?id=p2", "")
+	get(h, "/catalog/shoes", "")
+	get(h, "/catalog/shoes", "")
+	assert.Equal(t, 4, b.calls, "disabled routes are not cached")
+
+	entries := c.Entries("*id=p1")
+	require.Len(t, entries, 1)
+	assert.Equal(t, "/inventory/get?id=p1", entries[0].URL)
+	assert.Equal(t, "/inventory/get", entries[0].Route)
+	assert.Equal(t, 1, entries[0].Hits)
+	assert.InDelta(t, 60, entries[0].TTLSeconds, 1)
+	assert.Len(t, c.Entries(""), 2)
+
+	routes := c.Routes()
+	require.Len(t, routes, 2)
+	assert.Equal(t, respcache.Route{PathPrefix: "/inventory/get", TTL: "1m0s", Shared: true, Enabled: true, Entries: 2}, routes[0])
+	assert.False(t, routes[1].Enabled)
+
+	assert.Equal(t, 1, c.Purge("/inventory/get?id=p1", ""))
+	assert.Equal(t, 0, c.Purge("/inventory/get?id=p1", ""))
+	assert.Equal(t, "call 5", get(h, "/inventory/get?id=p1", "").Body.String())
+	assert.Equal(t, 2, c.Purge("", "/inventory/get"))
+
+	require.NoError(t, c.SetEnabled("/catalog", true))
+	get(h, "/catalog/shoes", "")
+	assert.Equal(t, "HIT", get(h, "/catalog/shoes", "").Header().Get(respcache.Header))
+	require.NoError(t, c.SetEnabled("/catalog", false))
+	assert.Empty(t, c.Entries(""), "switching a route off drops its entries")
+	assert.ErrorIs(t, c.SetEnabled("/unknown", true), respcache.ErrUnknownRoute)
+}
+
+// TestNew tests that rules need a path prefix and a TTL
+func TestNew(t *testing.T) {
+	c, err := respcache.New(respcache.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	_, err = respcache.New(respcache.Config{Rules: []respcache.Rule{{PathPrefix: "inventory", TTL: time.Minute}}})
+	assert.Error(t, err)
+	_, err = respcache.New(respcache.Config{Rules: []respcache.Rule{{PathPrefix: "/inventory"}}})
+	assert.Error(t, err)
+}
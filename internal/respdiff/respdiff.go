@@ -0,0 +1,319 @@
+// Package respdiff replays recorded requests against two gateways and
+// compares their answers: status codes, and JSON bodies by structure
+// rather than bytes. It backs `gateway diff`, which validates an upgrade or
+// a change of the JSON encoding before traffic moves to the new version.
+package respdiff
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/bench"
+)
+
+// Options tune the comparison.
+type Options struct {
+	// Ignore are the JSON paths whose values are not compared, such as
+	// request IDs or timestamps, e.g. "$.meta.request_id". In a path, *
+	// matches any text: "$.products[*].updated_at".
+	Ignore []string
+
+	// LooseNumbers treats a number and a string holding the same number as
+	// equal, e.g. 5 and "5", as when 64-bit integers move to protojson.
+	LooseNumbers bool
+
+	// Timeout bounds each request. Default: 10s.
+	Timeout time.Duration
+}
+
+// Difference is one mismatch between the answers, at a JSON path, or at
+// "status" or "body".
+type Difference struct {
+	Path string `json:"path"`
+	A    string `json:"a"`
+	B    string `json:"b"`
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: %s != %s", d.Path, d.A, d.B)
+}
+
+// Result is the comparison of the answers to one request.
+type Result struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	StatusA     int          `json:"status_a"`
+	StatusB     int          `json:"status_b"`
+	Differences []Difference `json:"differences,omitempty"`
+	Err         error        `json:"-"`
+}
+
+// Differs reports whether the answers differ or a request failed.
+func (r Result) Differs() bool {
+	return r.Err != nil || len(r.Differences) > 0
+}
+
+// Run sends each target to a and b in turn, replacing the scheme and host
+// of its URL by theirs, and compares the answers. Requests are sent one at
+// a time and in order, so a corpus may depend on earlier requests. It stops
+// early when ctx is done.
+func Run(ctx context.Context, client *http.Client, targets []bench.Target, a, b *url.URL, opts Options) []Result {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	var results []Result
+	for _, t := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		res := Result{Method: t.Method, URL: t.URL}
+		statusA, bodyA, errA := send(ctx, client, t, a, opts.Timeout)
+		statusB, bodyB, errB := send(ctx, client, t, b, opts.Timeout)
+		res.StatusA, res.StatusB = statusA, statusB
+		switch {
+		case errA != nil:
+			res.Err = errA
+		case errB != nil:
+			res.Err = errB
+		default:
+			res.Differences = compareAnswers(statusA, bodyA, statusB, bodyB, opts)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// rebase returns target with the scheme and host of base, and the path of
+// base prepended.
+func rebase(target string, base *url.URL) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if base == nil {
+		return u.String(), nil
+	}
+	u.Scheme, u.Host = base.Scheme, base.Host
+	u.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+type answer struct {
+	contentType string
+	body        []byte
+}
+
+func send(ctx context.Context, client *http.Client, t bench.Target, base *url.URL, timeout time.Duration) (int, answer, error) {
+	target, err := rebase(t.URL, base)
+	if err != nil {
+		return 0, answer{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, t.Method, target, bytes.NewReader(t.Body))
+	if err != nil {
+		return 0, answer{}, err
+	}
+	req.Header = t.Header.Clone()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, answer{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, answer{}, fmt.Errorf("%s: %w", target, err)
+	}
+	return resp.StatusCode, answer{contentType: resp.Header.Get("Content-Type"), body: body}, nil
+}
+
+// compareAnswers returns the differences between two answers. JSON bodies
+// are compared by value; other bodies byte for byte.
+func compareAnswers(statusA int, a answer, statusB int, b answer, opts Options) []Difference {
+	var diffs []Difference
+	if statusA != statusB {
+		diffs = append(diffs, Difference{Path: "status", A: strconv.Itoa(statusA), B: strconv.Itoa(statusB)})
+	}
+	va, okA := decode(a)
+	vb, okB := decode(b)
+	if !okA || !okB {
+		if !bytes.Equal(a.body, b.body) {
+			diffs = append(diffs, Difference{Path: "body", A: summary(a.body), B: summary(b.body)})
+		}
+		return diffs
+	}
+	c := comparer{opts: opts}
+	c.compare("$", va, vb)
+	return append(diffs, c.diffs...)
+}
+
+// CompareJSON returns the differences between two JSON documents, or a
+// "body" difference when one is not JSON.
+func CompareJSON(a, b []byte, opts Options) []Difference {
+	return compareAnswers(0, answer{contentType: "application/json", body: a}, 0, answer{contentType: "application/json", body: b}, opts)
+}
+
+func decode(a answer) (any, bool) {
+	mediaType, _, _ := mime.ParseMediaType(a.contentType)
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(a.body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+func summary(body []byte) string {
+	const max = 80
+	if len(body) > max {
+		return fmt.Sprintf("%q... (%d bytes)", body[:max], len(body))
+	}
+	return fmt.Sprintf("%q", body)
+}
+
+type comparer struct {
+	opts  Options
+	diffs []Difference
+}
+
+func (c *comparer) compare(path string, a, b any) {
+	if slices.ContainsFunc(c.opts.Ignore, func(p string) bool { return match(p, path) }) {
+		return
+	}
+	switch va := a.(type) {
+	case map[string]any:
+		vb, ok := b.(map[string]any)
+		if !ok {
+			c.add(path, a, b)
+			return
+		}
+		keys := make([]string, 0, len(va)+len(vb))
+		for k := range va {
+			keys = append(keys, k)
+		}
+		for k := range vb {
+			if _, ok := va[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			var ea, eb any = missing{}, missing{}
+			if v, ok := va[k]; ok {
+				ea = v
+			}
+			if v, ok := vb[k]; ok {
+				eb = v
+			}
+			c.compare(path+"."+k, ea, eb)
+		}
+	case []any:
+		vb, ok := b.([]any)
+		if !ok {
+			c.add(path, a, b)
+			return
+		}
+		for i := range max(len(va), len(vb)) {
+			var ea, eb any = missing{}, missing{}
+			if i < len(va) {
+				ea = va[i]
+			}
+			if i < len(vb) {
+				eb = vb[i]
+			}
+			c.compare(fmt.Sprintf("%s[%d]", path, i), ea, eb)
+		}
+	default:
+		if !c.equal(a, b) {
+			c.add(path, a, b)
+		}
+	}
+}
+
+// missing stands for a key or array element only one side has.
+type missing struct{}
+
+func (c *comparer) equal(a, b any) bool {
+	na, okA := c.number(a)
+	nb, okB := c.number(b)
+	if okA && okB {
+		return na.Cmp(nb) == 0
+	}
+	return a == b
+}
+
+// number returns the exact value of a JSON number, or, with LooseNumbers,
+// of a string holding one, so that 1.0 equals 1 and large integers keep
+// every digit.
+func (c *comparer) number(v any) (*big.Rat, bool) {
+	var s string
+	switch t := v.(type) {
+	case json.Number:
+		s = t.String()
+	case string:
+		if !c.opts.LooseNumbers {
+			return nil, false
+		}
+		s = t
+	default:
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+func (c *comparer) add(path string, a, b any) {
+	c.diffs = append(c.diffs, Difference{Path: path, A: show(a), B: show(b)})
+}
+
+func show(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case missing:
+		return "(missing)"
+	case map[string]any:
+		return "{...}"
+	case []any:
+		return "[...]"
+	}
+	out, _ := json.Marshal(v)
+	return string(out)
+}
+
+// match reports whether path matches pattern, in which * matches any text.
+func match(pattern, path string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return path == pattern
+	}
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		i := strings.Index(path, part)
+		if i < 0 {
+			return false
+		}
+		path = path[i+len(part):]
+	}
+	return strings.HasSuffix(path, parts[last])
+}
@@ -0,0 +1,95 @@
+package respdiff_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/bench"
+	"github.com/andro-kes/gateway/internal/respdiff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCompareJSON tests that JSON documents are compared by value and that differences carry their path
+func TestCompareJSON(t *testing.T) {
+	a := `{"products":[{"id":"p1","price":10,"tags":["a"]}],"total":1,"next":null}`
+	b := `{"total":1.0,"products":[{"id":"p1","price":12,"tags":["a","b"]}],"extra":true}`
+
+	diffs := respdiff.CompareJSON([]byte(a), []byte(b), respdiff.Options{})
+	assert.Equal(t, []respdiff.Difference{
+		{Path: "$.extra", A: "(missing)", B: "true"},
+		{Path: "$.next", A: "null", B: "(missing)"},
+		{Path: "$.products[0].price", A: "10", B: "12"},
+		{Path: "$.products[0].tags[1]", A: "(missing)", B: `"b"`},
+	}, diffs)
+
+	assert.Empty(t, respdiff.CompareJSON([]byte(a), []byte(a), respdiff.Options{}))
+	assert.Equal(t, []respdiff.Difference{{Path: "$", A: "[...]", B: "{...}"}},
+		respdiff.CompareJSON([]byte(`[]`), []byte(`{}`), respdiff.Options{}))
+}
+
+// TestCompareJSON_Options tests ignored paths and loose numbers
+func TestCompareJSON_Options(t *testing.T) {
+	a := `{"meta":{"request_id":"r1"},"products":[{"id":"p1","stock":9007199254740993,"updated_at":"t1"}]}`
+	b := `{"meta":{"request_id":"r2"},"products":[{"id":"p1","stock":"9007199254740993","updated_at":"t2"}]}`
+	ignore := []string{"$.meta.request_id", "$.products[*].updated_at"}
+
+	diffs := respdiff.CompareJSON([]byte(a), []byte(b), respdiff.Options{Ignore: ignore})
+	assert.Equal(t, []respdiff.Difference{{Path: "$.products[0].stock", A: "9007199254740993", B: `"9007199254740993"`}}, diffs)
+
+	assert.Empty(t, respdiff.CompareJSON([]byte(a), []byte(b), respdiff.Options{Ignore: ignore, LooseNumbers: true}))
+
+	diffs = respdiff.CompareJSON([]byte(`{"n":9007199254740993}`), []byte(`{"n":9007199254740992}`), respdiff.Options{})
+	assert.Len(t, diffs, 1, "large integers keep every digit")
+}
+
+// TestRun tests replaying targets against two servers
+func TestRun(t *testing.T) {
+	handler := func(version string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v1/inventory/get":
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"id":%q,"auth":%q,"version":%q}`, r.URL.Query().Get("id"), r.Header.Get("Authorization"), version)
+			case "/v1/health":
+				fmt.Fprint(w, "ok")
+			case "/v1/new":
+				if version == "v1" {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprint(w, "new")
+			default:
+				http.NotFound(w, r)
+			}
+		}
+	}
+	a := httptest.NewServer(handler("v1"))
+	defer a.Close()
+	b := httptest.NewServer(handler("v2"))
+	defer b.Close()
+
+	targets, err := bench.ParseTargets(strings.NewReader("GET http://gateway/inventory/get?id=p1\nAuthorization: Bearer t\n\nGET http://gateway/health\n\nGET http://gateway/new\n"), "")
+	require.NoError(t, err)
+	baseA, _ := url.Parse(a.URL + "/v1")
+	baseB, _ := url.Parse(b.URL + "/v1/")
+
+	results := respdiff.Run(context.Background(), http.DefaultClient, targets, baseA, baseB, respdiff.Options{})
+	require.Len(t, results, 3)
+
+	assert.Equal(t, http.StatusOK, results[0].StatusA)
+	assert.Equal(t, []respdiff.Difference{{Path: "$.version", A: `"v1"`, B: `"v2"`}}, results[0].Differences)
+	assert.False(t, results[1].Differs())
+	assert.Equal(t, []respdiff.Difference{
+		{Path: "status", A: "404", B: "200"},
+		{Path: "body", A: `"404 page not found\n"`, B: `"new"`},
+	}, results[2].Differences)
+
+	results = respdiff.Run(context.Background(), http.DefaultClient, targets[:1], baseA, baseA, respdiff.Options{})
+	assert.False(t, results[0].Differs())
+}
@@ -0,0 +1,173 @@
+// Package rotation detects the reuse of refresh tokens that were already
+// exchanged for new ones. auth_service rotates the refresh token on every
+// refresh, so a rotated token presented again means that two parties hold
+// it — typically a stolen cookie being replayed. The gateway then revokes
+// the live token of the whole session, logging out the thief and the
+// victim alike, instead of letting the race decide who keeps it.
+package rotation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Config configures refresh token reuse detection.
+type Config struct {
+	// Enabled tracks rotated refresh tokens.
+	Enabled bool `yaml:"enabled"`
+
+	// TTL is how long a rotated token is remembered when it carries no exp
+	// claim. Default: 720h.
+	TTL time.Duration `yaml:"ttl"`
+
+	// Redis keeps rotated tokens in Redis instead of memory when its address
+	// is set, so that reuse is detected across gateway instances.
+	Redis RedisConfig `yaml:"redis"`
+}
+
+// ErrNotFound is returned by Store.Load for tokens that were never rotated.
+var ErrNotFound = errors.New("rotation: token not rotated")
+
+// Record is kept for every rotated refresh token.
+type Record struct {
+	// Hash identifies the rotated token, so that a forged token reusing its
+	// jti is not taken for a replay.
+	Hash string `json:"hash"`
+
+	UserID    string    `json:"user_id"`
+	RotatedAt time.Time `json:"rotated_at"`
+
+	// Next is the refresh token the rotated one was exchanged for.
+	Next string `json:"next"`
+}
+
+// Store keeps the records of rotated tokens.
+type Store interface {
+	Save(ctx context.Context, key string, rec Record, ttl time.Duration) error
+	Load(ctx context.Context, key string) (*Record, error)
+	Close() error
+}
+
+var reuseTotal = promauto.With(metrics.Registry).NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "auth",
+	Name:      "refresh_reuse_total",
+	Help:      "Rotated refresh tokens presented again, each revoking its session.",
+})
+
+// Tracker remembers rotated refresh tokens.
+type Tracker struct {
+	store Store
+	ttl   time.Duration
+}
+
+// New returns a Tracker storing in Redis when configured, in memory
+// otherwise. It returns nil when disabled; a nil Tracker detects nothing.
+func New(cfg Config) (*Tracker, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var store Store = NewMemoryStore()
+	if cfg.Redis.Addr != "" {
+		var err error
+		if store, err = NewRedisStore(cfg.Redis); err != nil {
+			return nil, err
+		}
+	}
+	return NewWithStore(cfg, store), nil
+}
+
+// NewWithStore returns a Tracker keeping its records in store.
+func NewWithStore(cfg Config, store Store) *Tracker {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 720 * time.Hour
+	}
+	return &Tracker{store: store, ttl: cfg.TTL}
+}
+
+// Reuse describes a detected replay.
+type Reuse struct {
+	UserID string
+
+	// Live is the newest refresh token of the session, which should be
+	// revoked. Empty when the chain of rotations is no longer known.
+	Live string
+}
+
+// Check returns the reuse raw is part of, or nil when raw was never rotated.
+func (t *Tracker) Check(ctx context.Context, raw string) (*Reuse, error) {
+	if t == nil || raw == "" {
+		return nil, nil
+	}
+	key, hash := keys(raw)
+	rec, err := t.store.Load(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rec.Hash != hash {
+		return nil, nil
+	}
+	reuseTotal.Inc()
+
+	// follow the rotations to the token still in use
+	reuse := &Reuse{UserID: rec.UserID, Live: rec.Next}
+	for range 100 {
+		key, hash := keys(reuse.Live)
+		next, err := t.store.Load(ctx, key)
+		if errors.Is(err, ErrNotFound) || (err == nil && next.Hash != hash) {
+			break
+		}
+		if err != nil {
+			return reuse, err
+		}
+		reuse.Live = next.Next
+	}
+	return reuse, nil
+}
+
+// Rotated records that raw, a refresh token of userID, was exchanged for
+// next.
+func (t *Tracker) Rotated(ctx context.Context, raw, next, userID string) error {
+	if t == nil || raw == "" || next == "" || raw == next {
+		return nil
+	}
+	key, hash := keys(raw)
+	ttl := t.ttl
+	if c, err := token.Parse(raw); err == nil {
+		ttl = time.Until(c.ExpiresAt)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	return t.store.Save(ctx, key, Record{Hash: hash, UserID: userID, RotatedAt: time.Now(), Next: next}, ttl)
+}
+
+// Close closes the store.
+func (t *Tracker) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.store.Close()
+}
+
+// keys returns the store key of raw, its jti when it has one, and the hash
+// of the whole token.
+func keys(raw string) (key, hash string) {
+	sum := sha256.Sum256([]byte(raw))
+	hash = hex.EncodeToString(sum[:])
+	if c, err := token.Parse(raw); err == nil && c.ID != "" {
+		return "jti:" + c.ID, hash
+	}
+	return "sha256:" + hash, hash
+}
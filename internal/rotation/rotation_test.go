@@ -0,0 +1,75 @@
+package rotation_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/rotation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func refreshToken(t *testing.T, jti, sig string) string {
+	t.Helper()
+	enc := func(v any) string {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	claims := map[string]any{"sub": "user-1", "jti": jti, "exp": time.Now().Add(time.Hour).Unix()}
+	return enc(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + enc(claims) + "." + sig
+}
+
+// TestTracker tests that a rotated token is detected and followed to the live token of its session
+func TestTracker(t *testing.T) {
+	ctx := context.Background()
+	tr, err := rotation.New(rotation.Config{Enabled: true})
+	require.NoError(t, err)
+
+	first, second, third := refreshToken(t, "a", "sig"), refreshToken(t, "b", "sig"), refreshToken(t, "c", "sig")
+
+	reuse, err := tr.Check(ctx, first)
+	require.NoError(t, err)
+	assert.Nil(t, reuse)
+
+	require.NoError(t, tr.Rotated(ctx, first, second, "user-1"))
+	require.NoError(t, tr.Rotated(ctx, second, third, "user-1"))
+
+	reuse, err = tr.Check(ctx, third)
+	require.NoError(t, err)
+	assert.Nil(t, reuse)
+
+	reuse, err = tr.Check(ctx, first)
+	require.NoError(t, err)
+	require.NotNil(t, reuse)
+	assert.Equal(t, "user-1", reuse.UserID)
+	assert.Equal(t, third, reuse.Live)
+
+	// same jti, different token: not a replay of the rotated one
+	reuse, err = tr.Check(ctx, refreshToken(t, "a", "forged"))
+	require.NoError(t, err)
+	assert.Nil(t, reuse)
+}
+
+// TestTracker_Opaque tests tokens without a jti and a disabled tracker
+func TestTracker_Opaque(t *testing.T) {
+	ctx := context.Background()
+	tr := rotation.NewWithStore(rotation.Config{}, rotation.NewMemoryStore())
+	require.NoError(t, tr.Rotated(ctx, "opaque-1", "opaque-2", "user-1"))
+
+	reuse, err := tr.Check(ctx, "opaque-1")
+	require.NoError(t, err)
+	require.NotNil(t, reuse)
+	assert.Equal(t, "opaque-2", reuse.Live)
+
+	disabled, err := rotation.New(rotation.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, disabled)
+	require.NoError(t, disabled.Rotated(ctx, "opaque-1", "opaque-2", "user-1"))
+	reuse, err = disabled.Check(ctx, "opaque-1")
+	require.NoError(t, err)
+	assert.Nil(t, reuse)
+}
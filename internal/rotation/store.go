@@ -0,0 +1,128 @@
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type entry struct {
+	rec     Record
+	expires time.Time
+}
+
+// MemoryStore keeps rotated tokens in process memory. Reuse is only
+// detected on the instance that saw the rotation.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]entry
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]entry{}}
+}
+
+// Save stores rec and drops expired records.
+func (s *MemoryStore) Save(_ context.Context, key string, rec Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, e := range s.records {
+		if now.After(e.expires) {
+			delete(s.records, k)
+		}
+	}
+	s.records[key] = entry{rec: rec, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Load(_ context.Context, key string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.records[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, ErrNotFound
+	}
+	rec := e.rec
+	return &rec, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// RedisConfig configures the Redis store.
+type RedisConfig struct {
+	// Addr is the host:port of the Redis server.
+	Addr string `yaml:"addr"`
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+
+	// Prefix is prepended to token keys. Default: "gateway:refresh:".
+	Prefix string `yaml:"prefix"`
+}
+
+// storeTimeout bounds the connection check of the Redis store.
+const storeTimeout = 5 * time.Second
+
+// RedisStore keeps rotated tokens in Redis, one JSON value per token, so
+// that every gateway instance detects reuse.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects to Redis and checks that it answers.
+func NewRedisStore(cfg RedisConfig) (*RedisStore, error) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "gateway:refresh:"
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("rotation: failed to connect to Redis: %w", err)
+	}
+	return &RedisStore{client: client, prefix: cfg.Prefix}, nil
+}
+
+func (s *RedisStore) Save(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+key, data, ttl).Err()
+}
+
+func (s *RedisStore) Load(ctx context.Context, key string) (*Record, error) {
+	data, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,90 @@
+package routing
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Middleware answers OPTIONS for every route with its Allow set and serves
+// HEAD with the GET handler of routes without a HEAD handler, unless
+// disabled. Routes with their own OPTIONS or HEAD handler keep it.
+func (f *Fallback) Middleware(next http.Handler) http.Handler {
+	if f.cfg.DisableAutoMethods {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			if f.match(http.MethodOptions, r.URL.Path) {
+				break
+			}
+			allowed := f.Allowed(r.URL.Path)
+			if len(allowed) == 0 {
+				break
+			}
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			if origin := r.Header.Get("Origin"); origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+				f.preflight(w, r, origin, allowed)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case http.MethodHead:
+			if f.match(http.MethodHead, r.URL.Path) || !f.match(http.MethodGet, r.URL.Path) {
+				break
+			}
+			r2 := r.Clone(r.Context())
+			r2.Method = http.MethodGet
+			next.ServeHTTP(headWriter{w}, r2)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && f.allowOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers a CORS preflight from origin with the methods of the
+// route, if origin is allowed.
+func (f *Fallback) preflight(w http.ResponseWriter, r *http.Request, origin string, allowed []string) {
+	w.Header().Add("Vary", "Origin")
+	if !f.allowOrigin(origin) {
+		return
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	if f.cfg.CORSMaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(f.cfg.CORSMaxAge.Seconds())))
+	}
+}
+
+func (f *Fallback) allowOrigin(origin string) bool {
+	return slices.Contains(f.cfg.CORSOrigins, origin) || slices.Contains(f.cfg.CORSOrigins, "*")
+}
+
+func (f *Fallback) match(method, path string) bool {
+	return f.routes.Match(chi.NewRouteContext(), method, path)
+}
+
+// headWriter drops the body of GET handlers serving HEAD, keeping the
+// headers, Content-Length included.
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,117 @@
+package routing
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// NormalizeConfig configures how request paths without a route are
+// normalized before routing. Paths with a route are never changed.
+type NormalizeConfig struct {
+	// CollapseSlashes routes /inventory//list as /inventory/list.
+	CollapseSlashes bool `yaml:"collapse_slashes"`
+
+	// TrailingSlash routes /inventory/list/ as /inventory/list.
+	TrailingSlash bool `yaml:"trailing_slash"`
+
+	// CaseInsensitive routes /Inventory/LIST as /inventory/list. Only the
+	// static segments are changed: /Inventory/AbC keeps its ID AbC.
+	CaseInsensitive bool `yaml:"case_insensitive"`
+
+	// Redirect answers 308 Permanent Redirect to the normalized path,
+	// instead of routing it as if it had been requested.
+	Redirect bool `yaml:"redirect"`
+}
+
+// Normalizer rewrites or redirects request paths by a NormalizeConfig.
+type Normalizer struct {
+	cfg    NormalizeConfig
+	routes chi.Routes
+}
+
+// NewNormalizer returns the Normalizer of the router routes, or nil when cfg
+// normalizes nothing; a nil Normalizer leaves paths alone.
+func NewNormalizer(cfg NormalizeConfig, routes chi.Routes) *Normalizer {
+	if !cfg.CollapseSlashes && !cfg.TrailingSlash && !cfg.CaseInsensitive {
+		return nil
+	}
+	return &Normalizer{cfg: cfg, routes: routes}
+}
+
+// Middleware routes requests without a route by their normalized path, or
+// redirects them to it.
+func (n *Normalizer) Middleware(next http.Handler) http.Handler {
+	if n == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := n.normalize(r.Method, r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if n.cfg.Redirect {
+			target := *r.URL
+			target.Path, target.RawPath = path, ""
+			http.Redirect(w, r, target.RequestURI(), http.StatusPermanentRedirect)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path, r2.URL.RawPath = path, ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// normalize returns the normalized path of a request without a route, and
+// whether it has one.
+func (n *Normalizer) normalize(method, path string) (string, bool) {
+	if n.match(method, path) {
+		return "", false
+	}
+	p := path
+	if n.cfg.CollapseSlashes {
+		for strings.Contains(p, "//") {
+			p = strings.ReplaceAll(p, "//", "/")
+		}
+	}
+	if n.cfg.TrailingSlash && len(p) > 1 {
+		p = strings.TrimRight(p, "/")
+		if p == "" {
+			p = "/"
+		}
+	}
+	if p != path && n.match(method, p) {
+		return p, true
+	}
+	if n.cfg.CaseInsensitive {
+		if pattern := n.routes.Find(chi.NewRouteContext(), method, strings.ToLower(p)); pattern != "" {
+			return withCase(pattern, p), true
+		}
+	}
+	return "", false
+}
+
+func (n *Normalizer) match(method, path string) bool {
+	return n.routes.Match(chi.NewRouteContext(), method, path)
+}
+
+// withCase returns path with its static segments spelled as in pattern,
+// keeping the parameters and the wildcard tail as requested.
+func withCase(pattern, path string) string {
+	ps, segs := strings.Split(pattern, "/"), strings.Split(path, "/")
+	for i, p := range ps {
+		if i >= len(segs) {
+			break
+		}
+		switch {
+		case p == "*":
+			// the tail of mounted routers, e.g. passthrough routes, is theirs to match
+			return strings.Join(append(ps[:i:i], segs[i:]...), "/")
+		case strings.HasPrefix(p, "{"):
+			ps[i] = segs[i]
+		}
+	}
+	return strings.Join(ps, "/")
+}
@@ -0,0 +1,185 @@
+// Package routing handles the requests the router has no route for: paths
+// that only differ from a route by slashes or case are normalized, and the
+// rest get 404 and 405 responses in the gateway's JSON error format, with
+// the Allow header and, optionally, the known route nearest to a mistyped
+// path.
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/go-chi/chi/v5"
+)
+
+// Config configures the answers to unrouted requests.
+type Config struct {
+	// SuggestRoutes adds the known route nearest to the path of a 404, if
+	// any is close, as "suggestion". Admin routes are never suggested.
+	SuggestRoutes bool `yaml:"suggest_routes"`
+
+	// Normalize routes paths that differ from a route by their slashes or
+	// case.
+	Normalize NormalizeConfig `yaml:"normalize"`
+
+	// DisableAutoMethods stops answering OPTIONS with the Allow header of
+	// the route and serving HEAD with its GET handler.
+	DisableAutoMethods bool `yaml:"disable_auto_methods"`
+
+	// CORSOrigins are the origins, or "*" for any, whose CORS preflights
+	// are allowed the methods of the route and whose requests get
+	// Access-Control-Allow-Origin.
+	CORSOrigins []string `yaml:"cors_origins"`
+
+	// CORSMaxAge is how long browsers may cache a preflight answer.
+	CORSMaxAge time.Duration `yaml:"cors_max_age"`
+}
+
+// methods are the methods tried to build the Allow header.
+var methods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// Fallback answers requests without a route.
+type Fallback struct {
+	cfg    Config
+	routes chi.Routes
+
+	// patterns are walked on the first 404, once every route is registered
+	patterns func() []string
+}
+
+// New returns the Fallback of the router routes.
+func New(cfg Config, routes chi.Routes) *Fallback {
+	f := &Fallback{cfg: cfg, routes: routes}
+	f.patterns = sync.OnceValue(func() []string {
+		var out []string
+		seen := map[string]bool{}
+		chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			route = strings.TrimSuffix(strings.ReplaceAll(route, "/*/", "/"), "/*")
+			if route == "" || seen[route] || route == "/admin" || strings.HasPrefix(route, "/admin/") {
+				return nil
+			}
+			seen[route] = true
+			out = append(out, route)
+			return nil
+		})
+		return out
+	})
+	return f
+}
+
+// Allowed returns the methods the router serves path with, HEAD and OPTIONS
+// included unless automatic methods are disabled.
+func (f *Fallback) Allowed(path string) []string {
+	var allowed []string
+	for _, m := range methods {
+		if f.match(m, path) {
+			allowed = append(allowed, m)
+		}
+	}
+	if len(allowed) == 0 || f.cfg.DisableAutoMethods {
+		return allowed
+	}
+	if slices.Contains(allowed, http.MethodGet) && !slices.Contains(allowed, http.MethodHead) {
+		allowed = slices.Insert(allowed, 1, http.MethodHead)
+	}
+	if !slices.Contains(allowed, http.MethodOptions) {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	return allowed
+}
+
+// NotFound answers 404 with a JSON error body.
+func (f *Fallback) NotFound(w http.ResponseWriter, r *http.Request) {
+	body := map[string]any{
+		"error":   "not_found",
+		"message": i18n.Message(w, r, "not_found", "method", r.Method, "path", r.URL.Path),
+	}
+	if f.cfg.SuggestRoutes {
+		if s := suggest(r.URL.Path, f.patterns()); s != "" {
+			body["suggestion"] = s
+		}
+	}
+	writeJSON(w, http.StatusNotFound, body)
+}
+
+// MethodNotAllowed answers 405 with a JSON error body and the methods the
+// route serves in the Allow header.
+func (f *Fallback) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	allowed := f.Allowed(r.URL.Path)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]any{
+		"error":   "method_not_allowed",
+		"message": i18n.Message(w, r, "method_not_allowed", "method", r.Method, "path", r.URL.Path),
+		"allowed": allowed,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// suggest returns the pattern nearest to path, with the parameters filled
+// in from path, or "" if none is within a few edits.
+func suggest(path string, patterns []string) string {
+	best, bestDist := "", -1
+	for _, p := range patterns {
+		candidate := fill(p, path)
+		d := distance(strings.ToLower(path), strings.ToLower(candidate))
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = candidate, d
+		}
+	}
+	// a third of the path, at least 2 and at most 5 edits
+	limit := min(max(len(path)/3, 2), 5)
+	if bestDist < 0 || bestDist > limit || best == path {
+		return ""
+	}
+	return best
+}
+
+// fill replaces the {param} segments of pattern with the segments of path
+// at the same position, so that /inventory/{id} suggests
+// /inventory/42 rather than the pattern.
+func fill(pattern, path string) string {
+	ps, segs := strings.Split(pattern, "/"), strings.Split(path, "/")
+	for i, p := range ps {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if i < len(segs) && segs[i] != "" {
+				ps[i] = segs[i]
+			}
+		}
+	}
+	return strings.Join(ps, "/")
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
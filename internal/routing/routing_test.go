@@ -0,0 +1,153 @@
+package routing_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/routing"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouter(cfg routing.Config) *chi.Mux {
+	r := chi.NewRouter()
+	fallback := routing.New(cfg, r)
+	r.NotFound(fallback.NotFound)
+	r.MethodNotAllowed(fallback.MethodNotAllowed)
+	r.Use(fallback.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.Route("/inventory", func(r chi.Router) {
+		r.Get("/list", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "4")
+			w.Write([]byte("list"))
+		})
+		r.Post("/create", ok)
+		r.Get("/{id}", ok)
+		r.Delete("/{id}", ok)
+	})
+	r.Route("/admin", func(r chi.Router) {
+		r.Get("/backends", ok)
+	})
+	return r
+}
+
+func serve(h http.Handler, method, path string) (*httptest.ResponseRecorder, map[string]any) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	var body map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec, body
+}
+
+// TestFallback_NotFound tests that unknown paths get a JSON 404 with the nearest route when suggestions are on
+func TestFallback_NotFound(t *testing.T) {
+	rec, body := serve(newRouter(routing.Config{SuggestRoutes: true}), "GET", "/inventroy/list")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "not_found", body["error"])
+	assert.Equal(t, "/inventory/list", body["suggestion"])
+
+	_, body = serve(newRouter(routing.Config{SuggestRoutes: true}), "GET", "/admin/backend")
+	assert.Nil(t, body["suggestion"], "admin routes are not suggested")
+
+	_, body = serve(newRouter(routing.Config{SuggestRoutes: true}), "GET", "/completely/elsewhere")
+	assert.Nil(t, body["suggestion"])
+
+	_, body = serve(newRouter(routing.Config{}), "GET", "/inventroy/list")
+	assert.Nil(t, body["suggestion"])
+}
+
+// TestFallback_MethodNotAllowed tests that 405s list the methods of the route in Allow and the body
+func TestFallback_MethodNotAllowed(t *testing.T) {
+	rec, body := serve(newRouter(routing.Config{}), "PUT", "/inventory/42")
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, HEAD, DELETE, OPTIONS", rec.Header().Get("Allow"))
+	assert.Equal(t, "method_not_allowed", body["error"])
+	assert.Equal(t, []any{"GET", "HEAD", "DELETE", "OPTIONS"}, body["allowed"])
+
+	rec, _ = serve(newRouter(routing.Config{DisableAutoMethods: true}), "PUT", "/inventory/42")
+	assert.Equal(t, "GET, DELETE", rec.Header().Get("Allow"))
+}
+
+// TestFallback_Middleware tests that OPTIONS is answered with the Allow set of the route and HEAD by the GET handler without a body
+func TestFallback_Middleware(t *testing.T) {
+	h := newRouter(routing.Config{CORSOrigins: []string{"https://app.example.com"}, CORSMaxAge: time.Hour})
+
+	rec, _ := serve(h, "OPTIONS", "/inventory/42")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "GET, HEAD, DELETE, OPTIONS", rec.Header().Get("Allow"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	rec, _ = serve(h, "OPTIONS", "/missing")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec, _ = serve(h, "HEAD", "/inventory/list")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "4", rec.Header().Get("Content-Length"))
+	assert.Empty(t, rec.Body.String())
+
+	rec, _ = serve(h, "HEAD", "/missing")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/inventory/42", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "DELETE")
+		req.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec = preflight("https://app.example.com")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, HEAD, DELETE, OPTIONS", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization, Content-Type", rec.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "3600", rec.Header().Get("Access-Control-Max-Age"))
+
+	rec = preflight("https://evil.example.com")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+// TestNormalizer tests that paths without a route are routed by their normalized path or redirected to it
+func TestNormalizer(t *testing.T) {
+	newNormalized := func(cfg routing.NormalizeConfig) *chi.Mux {
+		r := chi.NewRouter()
+		r.Use(routing.NewNormalizer(cfg, r).Middleware)
+		echo := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.Path + " " + chi.URLParam(r, "id")))
+		}
+		r.Route("/inventory", func(r chi.Router) {
+			r.Get("/list", echo)
+			r.Get("/products/{id}", echo)
+			r.Get("/tree/", echo)
+		})
+		return r
+	}
+
+	r := newNormalized(routing.NormalizeConfig{CollapseSlashes: true, TrailingSlash: true, CaseInsensitive: true})
+	for path, want := range map[string]string{
+		"/inventory/list":          "/inventory/list ",
+		"/inventory//list":         "/inventory/list ",
+		"/inventory/list/":         "/inventory/list ",
+		"/Inventory/LIST":          "/inventory/list ",
+		"/INVENTORY/Products/AbC/": "/inventory/products/AbC AbC",
+		"/inventory/tree/":         "/inventory/tree/ ",
+	} {
+		rec, _ := serve(r, "GET", path)
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Equal(t, want, rec.Body.String(), path)
+	}
+
+	rec, _ := serve(newNormalized(routing.NormalizeConfig{}), "GET", "/inventory/list/")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec, _ = serve(newNormalized(routing.NormalizeConfig{TrailingSlash: true, Redirect: true}), "GET", "/inventory/list/?page=2")
+	assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+	assert.Equal(t, "/inventory/list?page=2", rec.Header().Get("Location"))
+}
@@ -0,0 +1,101 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// fetch asks the reflection service of conn for its services, the files
+// declaring them, and the dependencies of those files the gateway does not
+// know.
+func fetch(ctx context.Context, conn grpc.ClientConnInterface) (*protoregistry.Files, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	call := func(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("reflection: %s", e.ErrorMessage)
+		}
+		return resp, nil
+	}
+
+	resp, err := call(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var pending []*rpb.ServerReflectionRequest
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		if strings.HasPrefix(s.Name, "grpc.reflection.") {
+			continue
+		}
+		pending = append(pending, &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: s.Name},
+		})
+	}
+
+	fds := map[string]*descriptorpb.FileDescriptorProto{}
+	requested := map[string]bool{}
+	for len(pending) > 0 {
+		resp, err := call(pending[0])
+		if err != nil {
+			return nil, err
+		}
+		pending = pending[1:]
+		for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(data, fd); err != nil {
+				return nil, fmt.Errorf("reflection: %w", err)
+			}
+			fds[fd.GetName()] = fd
+		}
+
+		// missing dependencies come from the gateway when it knows them
+		queue := slices.Collect(maps.Values(fds))
+		for len(queue) > 0 {
+			fd := queue[0]
+			queue = queue[1:]
+			for _, dep := range fd.Dependency {
+				if _, ok := fds[dep]; ok || requested[dep] {
+					continue
+				}
+				if known, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+					fds[dep] = protodesc.ToFileDescriptorProto(known)
+					queue = append(queue, fds[dep])
+					continue
+				}
+				requested[dep] = true
+				pending = append(pending, &rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+				})
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, name := range slices.Sorted(maps.Keys(fds)) {
+		set.File = append(set.File, fds[name])
+	}
+	return protodesc.NewFiles(set)
+}
@@ -0,0 +1,267 @@
+// Package schema fetches the service descriptors of backends with their gRPC
+// reflection service and caches them, refreshing them periodically, so that
+// the gateway works with the schemas the backends actually serve rather than
+// the ones it was built with.
+package schema
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Config configures the schema cache.
+type Config struct {
+	// Backends are fetched at startup and refreshed, in addition to the
+	// backends the features using schemas need, e.g. those allowed by rpc.
+	Backends []string `yaml:"backends"`
+
+	// Refresh is the interval between fetches. Default: 5m.
+	Refresh time.Duration `yaml:"refresh"`
+
+	// Timeout bounds each fetch. Default: 10s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// retryMiss is the minimum interval between the fetches of a backend caused
+// by lookups of services it did not have.
+const retryMiss = 10 * time.Second
+
+// ErrUnknownService reports a service the backend does not serve.
+var ErrUnknownService = errors.New("unknown service")
+
+var fetchErrors = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "schema",
+	Name:      "fetch_errors_total",
+	Help:      "Failed fetches of backend schemas, by backend.",
+}, []string{"backend"})
+
+// Cache holds the schemas of backends.
+type Cache struct {
+	cfg   Config
+	conns map[string]grpc.ClientConnInterface
+
+	mu      sync.RWMutex
+	schemas map[string]*Schema
+	tried   map[string]time.Time
+
+	stop chan struct{}
+}
+
+// Schema is the schema of a backend as last fetched.
+type Schema struct {
+	Files     *protoregistry.Files
+	FetchedAt time.Time
+}
+
+// New returns a Cache of the schemas of the backends of conns. It returns
+// nil when conns is empty; a nil Cache knows no service. Call Load to fetch
+// the schemas and start refreshing them.
+func New(cfg Config, conns map[string]grpc.ClientConnInterface) *Cache {
+	if len(conns) == 0 {
+		return nil
+	}
+	if cfg.Refresh <= 0 {
+		cfg.Refresh = 5 * time.Minute
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &Cache{
+		cfg:     cfg,
+		conns:   conns,
+		schemas: map[string]*Schema{},
+		tried:   map[string]time.Time{},
+		stop:    make(chan struct{}),
+	}
+}
+
+// Load fetches every schema, then refreshes them in the background until
+// Close. It returns the errors of the backends that could not be fetched;
+// those are retried on refresh.
+func (c *Cache) Load(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	err := c.refresh(ctx)
+	go c.run()
+	return err
+}
+
+// Close stops the refresh.
+func (c *Cache) Close() {
+	if c != nil {
+		close(c.stop)
+	}
+}
+
+func (c *Cache) run() {
+	ticker := time.NewTicker(c.cfg.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.refresh(context.Background()); err != nil {
+				logger.Logger().Warn("Failed to refresh backend schemas", zap.Error(err))
+			}
+		}
+	}
+}
+
+// refresh fetches every schema, keeping the previous schema of the backends
+// that fail.
+func (c *Cache) refresh(ctx context.Context) error {
+	var errs []error
+	for _, backend := range c.Backends() {
+		c.mu.Lock()
+		c.tried[backend] = time.Now()
+		c.mu.Unlock()
+		if err := c.fetch(ctx, backend); err != nil {
+			errs = append(errs, fmt.Errorf("backend %s: %w", backend, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Cache) fetch(ctx context.Context, backend string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	files, err := fetch(ctx, c.conns[backend])
+	if err != nil {
+		fetchErrors.WithLabelValues(backend).Inc()
+		return err
+	}
+	c.mu.Lock()
+	c.schemas[backend] = &Schema{Files: files, FetchedAt: time.Now()}
+	c.mu.Unlock()
+	return nil
+}
+
+// Backends returns the names of the cached backends, sorted.
+func (c *Cache) Backends() []string {
+	if c == nil {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(c.conns))
+}
+
+// Schema returns the last fetched schema of backend, or nil.
+func (c *Cache) Schema(backend string) *Schema {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.schemas[backend]
+}
+
+// Service returns the descriptor of the service name of backend. When the
+// schema does not have it, the backend is fetched again, at most every 10s,
+// in case it was deployed since the last refresh.
+func (c *Cache) Service(ctx context.Context, backend, name string) (protoreflect.ServiceDescriptor, error) {
+	if c == nil || c.conns[backend] == nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownService, name)
+	}
+	if sd := c.service(backend, name); sd != nil {
+		return sd, nil
+	}
+	c.mu.Lock()
+	retry := time.Since(c.tried[backend]) >= retryMiss
+	if retry {
+		c.tried[backend] = time.Now()
+	}
+	c.mu.Unlock()
+	if retry {
+		if err := c.fetch(ctx, backend); err != nil {
+			return nil, err
+		}
+		if sd := c.service(backend, name); sd != nil {
+			return sd, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownService, name)
+}
+
+func (c *Cache) service(backend, name string) protoreflect.ServiceDescriptor {
+	s := c.Schema(backend)
+	if s == nil {
+		return nil
+	}
+	desc, err := s.Files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil
+	}
+	sd, _ := desc.(protoreflect.ServiceDescriptor)
+	return sd
+}
+
+// Services returns the services of the last fetched schema of backend,
+// sorted by name.
+func (c *Cache) Services(backend string) []protoreflect.ServiceDescriptor {
+	s := c.Schema(backend)
+	if s == nil {
+		return nil
+	}
+	var out []protoreflect.ServiceDescriptor
+	s.Files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := range fd.Services().Len() {
+			out = append(out, fd.Services().Get(i))
+		}
+		return true
+	})
+	slices.SortFunc(out, func(a, b protoreflect.ServiceDescriptor) int {
+		return cmp.Compare(a.FullName(), b.FullName())
+	})
+	return out
+}
+
+// Status describes the cached schema of a backend.
+type Status struct {
+	Backend   string          `json:"backend"`
+	FetchedAt time.Time       `json:"fetched_at,omitzero"`
+	Services  []ServiceStatus `json:"services"`
+}
+
+// ServiceStatus lists the methods of a service.
+type ServiceStatus struct {
+	Name    string   `json:"name"`
+	Methods []string `json:"methods"`
+}
+
+// Status reports the schema of every backend, sorted by backend. Backends
+// never fetched have no services.
+func (c *Cache) Status() []Status {
+	out := []Status{}
+	for _, backend := range c.Backends() {
+		st := Status{Backend: backend, Services: []ServiceStatus{}}
+		if s := c.Schema(backend); s != nil {
+			st.FetchedAt = s.FetchedAt
+		}
+		for _, sd := range c.Services(backend) {
+			ss := ServiceStatus{Name: string(sd.FullName()), Methods: []string{}}
+			for i := range sd.Methods().Len() {
+				ss.Methods = append(ss.Methods, string(sd.Methods().Get(i).Name()))
+			}
+			st.Services = append(st.Services, ss)
+		}
+		out = append(out, st)
+	}
+	return out
+}
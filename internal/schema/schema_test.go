@@ -0,0 +1,68 @@
+package schema_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/schema"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+)
+
+func dial(t *testing.T, reflect bool) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pbInv.RegisterInventoryServiceServer(srv, pbInv.UnimplementedInventoryServiceServer{})
+	if reflect {
+		reflection.Register(srv)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestCache tests that schemas are fetched with reflection and that backends without it are reported
+func TestCache(t *testing.T) {
+	assert.Nil(t, schema.New(schema.Config{}, nil))
+
+	c := schema.New(schema.Config{}, map[string]grpc.ClientConnInterface{
+		"inventory": dial(t, true),
+		"legacy":    dial(t, false),
+	})
+	err := c.Load(context.Background())
+	t.Cleanup(c.Close)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "backend legacy")
+	assert.Nil(t, c.Schema("legacy"))
+
+	ctx := context.Background()
+	sd, err := c.Service(ctx, "inventory", "inventory.InventoryService")
+	require.NoError(t, err)
+	assert.NotNil(t, sd.Methods().ByName("GetProduct"))
+	assert.Equal(t, "inventory.GetRequest", string(sd.Methods().ByName("GetProduct").Input().FullName()))
+
+	_, err = c.Service(ctx, "inventory", "inventory.Missing")
+	assert.ErrorIs(t, err, schema.ErrUnknownService)
+	_, err = c.Service(ctx, "billing", "inventory.InventoryService")
+	assert.ErrorIs(t, err, schema.ErrUnknownService)
+
+	status := c.Status()
+	require.Len(t, status, 2)
+	assert.Equal(t, "inventory", status[0].Backend)
+	require.Len(t, status[0].Services, 1)
+	assert.Equal(t, "inventory.InventoryService", status[0].Services[0].Name)
+	assert.Contains(t, status[0].Services[0].Methods, "ListProducts")
+	assert.False(t, status[0].FetchedAt.IsZero())
+	assert.Equal(t, "legacy", status[1].Backend)
+	assert.Empty(t, status[1].Services)
+}
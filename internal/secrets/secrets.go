@@ -0,0 +1,197 @@
+// Package secrets resolves configuration values that reference a secret
+// store instead of holding the secret: "file:/run/secrets/jwt" reads a file,
+// "vault:/secret/data/gateway#jwt" reads a field of a HashiCorp Vault
+// secret and "awssm:gateway/prod#jwt" reads AWS Secrets Manager. Other
+// values are used as they are.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Config configures the secret stores.
+type Config struct {
+	// Refresh is how often references are resolved again after startup.
+	// Zero resolves them once.
+	Refresh time.Duration `yaml:"refresh"`
+
+	// Timeout bounds the resolution of each reference. Default: 10s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	Vault VaultConfig `yaml:"vault"`
+
+	AWS AWSConfig `yaml:"aws"`
+
+	// Refs maps the configuration fields that were resolved from a
+	// reference, e.g. "auth.jwt.hmac_secret", to the reference. Filled in
+	// when the configuration is loaded.
+	Refs map[string]string `yaml:"-"`
+}
+
+// Provider reads secrets from one store.
+type Provider interface {
+	// Resolve returns the secret at ref, the part of the reference after
+	// the scheme.
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// Schemes of the references.
+const (
+	File  = "file"
+	Vault = "vault"
+	AWSSM = "awssm"
+)
+
+// IsRef reports whether value references a secret.
+func IsRef(value string) bool {
+	scheme, _, ok := strings.Cut(value, ":")
+	if !ok {
+		return false
+	}
+	switch scheme {
+	case File, Vault, AWSSM:
+		return true
+	}
+	return false
+}
+
+// Resolver resolves references with the provider of their scheme.
+type Resolver struct {
+	timeout   time.Duration
+	providers map[string]Provider
+}
+
+// NewResolver returns the Resolver of cfg. Stores are only contacted when a
+// reference to them is resolved.
+func NewResolver(cfg Config) *Resolver {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &Resolver{
+		timeout: timeout,
+		providers: map[string]Provider{
+			File:  fileProvider{},
+			Vault: newVaultProvider(cfg.Vault),
+			AWSSM: newAWSProvider(cfg.AWS),
+		},
+	}
+}
+
+// Resolve returns the secret value references, or value itself if it is not
+// a reference.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	if !IsRef(value) {
+		return value, nil
+	}
+	scheme, ref, _ := strings.Cut(value, ":")
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	secret, err := r.providers[scheme].Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", value, err)
+	}
+	return secret, nil
+}
+
+// fileProvider reads secrets from files, e.g. Docker or Kubernetes secrets.
+type fileProvider struct{}
+
+func (fileProvider) Resolve(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	// files written by editors and echo end in a newline that is not part of the secret
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// field returns the field named by the fragment of ref from a secret made
+// of several, or the only one when ref has no fragment.
+func field(ref string, fields map[string]any) (string, error) {
+	_, name, ok := strings.Cut(ref, "#")
+	if !ok {
+		if len(fields) != 1 {
+			return "", fmt.Errorf("secret has %d fields, select one with #field", len(fields))
+		}
+		for _, v := range fields {
+			name, _ = v.(string)
+			return name, nil
+		}
+	}
+	v, ok := fields[name].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string field %q", name)
+	}
+	return v, nil
+}
+
+// Watcher resolves references again periodically and applies the secrets
+// that changed.
+type Watcher struct {
+	resolver *Resolver
+	interval time.Duration
+	watches  []watch
+}
+
+type watch struct {
+	name, ref string
+	last      string
+	apply     func(string)
+}
+
+// NewWatcher returns a Watcher resolving references every interval.
+func NewWatcher(resolver *Resolver, interval time.Duration) *Watcher {
+	return &Watcher{resolver: resolver, interval: interval}
+}
+
+// Watch watches the reference of the configuration field name, whose value
+// is currently current. apply receives the new secret when it changes; a
+// nil apply only logs that a restart is needed to use it.
+func (w *Watcher) Watch(name, ref, current string, apply func(string)) {
+	w.watches = append(w.watches, watch{name: name, ref: ref, last: current, apply: apply})
+}
+
+// Run refreshes the watched secrets until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh resolves every watched reference once and applies the changes.
+func (w *Watcher) Refresh(ctx context.Context) {
+	zl := logger.Logger()
+	for i := range w.watches {
+		wt := &w.watches[i]
+		secret, err := w.resolver.Resolve(ctx, wt.ref)
+		if err != nil {
+			zl.Warn("Failed to refresh secret", zap.String("field", wt.name), zap.Error(err))
+			continue
+		}
+		if secret == wt.last {
+			continue
+		}
+		wt.last = secret
+		if wt.apply == nil {
+			zl.Warn("Secret changed, restart to apply it", zap.String("field", wt.name), zap.String("ref", wt.ref))
+			continue
+		}
+		wt.apply(secret)
+		zl.Info("Secret refreshed", zap.String("field", wt.name), zap.String("ref", wt.ref))
+	}
+}
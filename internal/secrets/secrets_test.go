@@ -0,0 +1,108 @@
+package secrets_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestResolver_File tests that file references are read without the trailing newline and other values are kept
+func TestResolver_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt")
+	require.NoError(t, os.WriteFile(path, []byte("s3cret\n"), 0o600))
+	r := secrets.NewResolver(secrets.Config{})
+
+	v, err := r.Resolve(context.Background(), "file:"+path)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", v)
+
+	v, err = r.Resolve(context.Background(), "https://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", v)
+
+	_, err = r.Resolve(context.Background(), "file:"+path+".missing")
+	assert.Error(t, err)
+}
+
+// TestResolver_Vault tests that a field of a KV version 2 secret is read with the configured token
+func TestResolver_Vault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		assert.Equal(t, "/v1/secret/data/gateway", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"data":     map[string]any{"jwt": "from-vault", "admin": "other"},
+				"metadata": map[string]any{"version": 3},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	r := secrets.NewResolver(secrets.Config{Vault: secrets.VaultConfig{Address: srv.URL, Token: "root"}})
+	v, err := r.Resolve(context.Background(), "vault:/secret/data/gateway#jwt")
+	require.NoError(t, err)
+	assert.Equal(t, "from-vault", v)
+
+	_, err = r.Resolve(context.Background(), "vault:/secret/data/gateway")
+	assert.ErrorContains(t, err, "select one with #field")
+
+	r = secrets.NewResolver(secrets.Config{Vault: secrets.VaultConfig{Address: srv.URL, Token: "wrong"}})
+	_, err = r.Resolve(context.Background(), "vault:/secret/data/gateway#jwt")
+	assert.ErrorContains(t, err, "403")
+}
+
+// TestResolver_AWS tests that Secrets Manager is called with a signed GetSecretValue request
+func TestResolver_AWS(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+		auth := r.Header.Get("Authorization")
+		assert.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"), auth)
+		assert.Contains(t, auth, "/eu-west-1/secretsmanager/aws4_request")
+		var in struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&in)
+		assert.Equal(t, "gateway/prod", in.SecretId)
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"jwt":"from-aws"}`})
+	}))
+	defer srv.Close()
+
+	r := secrets.NewResolver(secrets.Config{AWS: secrets.AWSConfig{Region: "eu-west-1", Endpoint: srv.URL}})
+	v, err := r.Resolve(context.Background(), "awssm:gateway/prod#jwt")
+	require.NoError(t, err)
+	assert.Equal(t, "from-aws", v)
+
+	v, err = r.Resolve(context.Background(), "awssm:gateway/prod")
+	require.NoError(t, err)
+	assert.Equal(t, `{"jwt":"from-aws"}`, v)
+}
+
+// TestWatcher_Refresh tests that changed secrets are applied and unchanged ones are not
+func TestWatcher_Refresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt")
+	require.NoError(t, os.WriteFile(path, []byte("first"), 0o600))
+
+	var applied []string
+	w := secrets.NewWatcher(secrets.NewResolver(secrets.Config{}), 0)
+	w.Watch("auth.jwt.hmac_secret", "file:"+path, "first", func(v string) { applied = append(applied, v) })
+
+	w.Refresh(context.Background())
+	assert.Empty(t, applied)
+
+	require.NoError(t, os.WriteFile(path, []byte("second"), 0o600))
+	w.Refresh(context.Background())
+	w.Refresh(context.Background())
+	assert.Equal(t, []string{"second"}, applied)
+}
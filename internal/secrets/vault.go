@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// VaultConfig configures access to HashiCorp Vault.
+type VaultConfig struct {
+	// Address is the Vault server, e.g. "https://vault:8200". Env: VAULT_ADDR.
+	Address string `yaml:"address"`
+
+	// Token authenticates the requests. Env: VAULT_TOKEN.
+	Token string `yaml:"token"`
+
+	// TokenFile is read for the token instead, e.g. one written by a
+	// Vault agent. It is read again for every request, so renewed tokens
+	// are picked up.
+	TokenFile string `yaml:"token_file"`
+
+	// Namespace is the Vault Enterprise namespace. Env: VAULT_NAMESPACE.
+	Namespace string `yaml:"namespace"`
+}
+
+// vaultProvider reads secrets from the KV secrets engine, version 1 or 2:
+// "vault:/secret/data/gateway#jwt" is the jwt field of the secret at
+// secret/data/gateway.
+type vaultProvider struct {
+	cfg    VaultConfig
+	client *http.Client
+}
+
+func newVaultProvider(cfg VaultConfig) *vaultProvider {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	return &vaultProvider{cfg: cfg, client: &http.Client{}}
+}
+
+func (p *vaultProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	if p.cfg.Address == "" {
+		return "", fmt.Errorf("vault address is not configured")
+	}
+	token := p.cfg.Token
+	if p.cfg.TokenFile != "" {
+		data, err := os.ReadFile(p.cfg.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	path, _, _ := strings.Cut(ref, "#")
+	url := strings.TrimSuffix(p.cfg.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if p.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	fields := body.Data
+	// KV version 2 nests the secret under data.data, next to its metadata
+	if nested, ok := fields["data"].(map[string]any); ok {
+		if _, ok := fields["metadata"]; ok {
+			fields = nested
+		}
+	}
+	return field(ref, fields)
+}
@@ -0,0 +1,448 @@
+// Package server runs the gateway's HTTP listeners. Each listener binds a TCP
+// address or Unix socket and speaks HTTP/1.1, optionally with TLS, HTTP/2
+// cleartext (h2c) and experimental HTTP/3 over QUIC.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/systemd"
+	"github.com/quic-go/quic-go/http3"
+	"go.uber.org/zap"
+)
+
+const (
+	unixPrefix    = "unix://"
+	systemdPrefix = "systemd:"
+)
+
+// TLSConfig points to the certificate served by a listener.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// Cert and Key hold the PEM-encoded certificate and key instead of the
+	// files, e.g. resolved from a secret store.
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+
+	// ClientCAFile, or the PEM-encoded ClientCA, holds the CA certificates
+	// client certificates are verified against. Setting one asks clients
+	// for a certificate.
+	ClientCAFile string `yaml:"client_ca_file"`
+	ClientCA     string `yaml:"client_ca"`
+
+	// ClientAuth is ClientOptional (default), which lets clients without a
+	// certificate through for other authentication, or ClientRequired.
+	ClientAuth string `yaml:"client_auth"`
+}
+
+// Client certificate policies of a listener with a client CA.
+const (
+	ClientOptional = "optional"
+	ClientRequired = "required"
+)
+
+// Enabled reports whether TLS is configured.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.Cert != "" || c.Key != ""
+}
+
+// load returns the configured certificate.
+func (c TLSConfig) load() (tls.Certificate, error) {
+	if c.Cert != "" || c.Key != "" {
+		return tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+	}
+	return tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+}
+
+// tlsConfig returns the TLS config of the listener: its certificate and,
+// with a client CA, how client certificates are verified.
+func (c TLSConfig) tlsConfig() (*tls.Config, error) {
+	cert, err := c.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	ca := []byte(c.ClientCA)
+	if c.ClientCAFile != "" {
+		if ca, err = os.ReadFile(c.ClientCAFile); err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+	}
+	if len(ca) == 0 {
+		if c.ClientAuth != "" {
+			return nil, errors.New("client_auth requires a client CA")
+		}
+		return conf, nil
+	}
+	conf.ClientCAs = x509.NewCertPool()
+	if !conf.ClientCAs.AppendCertsFromPEM(ca) {
+		return nil, errors.New("client CA holds no PEM certificate")
+	}
+	switch c.ClientAuth {
+	case "", ClientOptional:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientRequired:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client_auth %q", c.ClientAuth)
+	}
+	return conf, nil
+}
+
+// ListenerConfig describes one listener.
+type ListenerConfig struct {
+	// Address is a TCP address ("0.0.0.0:8080"), a Unix socket
+	// ("unix:///var/run/gateway.sock") or a socket inherited from systemd
+	// socket activation, selected by FileDescriptorName= or position
+	// ("systemd:http", "systemd:0").
+	Address string `yaml:"address"`
+
+	// TLS enables HTTPS; HTTP/2 is then negotiated through ALPN.
+	TLS TLSConfig `yaml:"tls"`
+
+	// H2C serves HTTP/2 without TLS next to HTTP/1.1, for internal load
+	// balancers that speak prior-knowledge HTTP/2. Ignored with TLS.
+	H2C bool `yaml:"h2c"`
+
+	// HTTP3 additionally serves HTTP/3 on the same port over UDP and
+	// advertises it with Alt-Svc. Experimental; requires TLS and a TCP address.
+	HTTP3 bool `yaml:"http3"`
+}
+
+// Limits protect the listeners against slow or abusive clients (slowloris,
+// oversized headers) and bound how long idle connections are kept.
+type Limits struct {
+	// ReadTimeout bounds reading the whole request, body included. Default: 30s.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+
+	// ReadHeaderTimeout bounds reading the request headers. Default: 10s.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+
+	// WriteTimeout bounds writing the response. Default: 60s.
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// IdleTimeout closes keep-alive connections idle for longer. Default: 120s.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// MaxHeaderBytes caps the size of request headers. Default: 1 MiB.
+	MaxHeaderBytes int `yaml:"max_header_bytes"`
+}
+
+func (l *Limits) setDefaults() {
+	if l.ReadTimeout <= 0 {
+		l.ReadTimeout = 30 * time.Second
+	}
+	if l.ReadHeaderTimeout <= 0 {
+		l.ReadHeaderTimeout = 10 * time.Second
+	}
+	if l.WriteTimeout <= 0 {
+		l.WriteTimeout = 60 * time.Second
+	}
+	if l.IdleTimeout <= 0 {
+		l.IdleTimeout = 120 * time.Second
+	}
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = 1 << 20
+	}
+}
+
+// Server serves a handler on a set of listeners.
+type Server struct {
+	listeners []*listener
+	inherited func(name string) *os.File
+}
+
+type listener struct {
+	cfg  ListenerConfig
+	http *http.Server
+	h3   *http3.Server
+	ln   net.Listener
+	udp  net.PacketConn
+}
+
+// New validates the listener configs and prepares a server for them.
+func New(cfgs []ListenerConfig, limits Limits, handler http.Handler) (*Server, error) {
+	if len(cfgs) == 0 {
+		return nil, errors.New("no listeners configured")
+	}
+	limits.setDefaults()
+	s := &Server{}
+	for _, cfg := range cfgs {
+		l, err := newListener(cfg, limits, handler)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", cfg.Address, err)
+		}
+		s.listeners = append(s.listeners, l)
+	}
+	return s, nil
+}
+
+func newListener(cfg ListenerConfig, limits Limits, handler http.Handler) (*listener, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("address is required")
+	}
+	if cfg.HTTP3 && !cfg.TLS.Enabled() {
+		return nil, errors.New("http3 requires tls")
+	}
+	if !cfg.TLS.Enabled() && (cfg.TLS.ClientCA != "" || cfg.TLS.ClientCAFile != "") {
+		return nil, errors.New("client certificates require tls")
+	}
+	if cfg.HTTP3 && strings.HasPrefix(cfg.Address, unixPrefix) {
+		return nil, errors.New("http3 is not supported on unix sockets")
+	}
+
+	l := &listener{
+		cfg: cfg,
+		http: &http.Server{
+			Handler:           handler,
+			ReadTimeout:       limits.ReadTimeout,
+			ReadHeaderTimeout: limits.ReadHeaderTimeout,
+			WriteTimeout:      limits.WriteTimeout,
+			IdleTimeout:       limits.IdleTimeout,
+			MaxHeaderBytes:    limits.MaxHeaderBytes,
+		},
+	}
+
+	if cfg.TLS.Enabled() {
+		conf, err := cfg.TLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		l.http.TLSConfig = conf
+	} else if cfg.H2C {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		l.http.Protocols = protocols
+	}
+
+	if cfg.HTTP3 {
+		l.h3 = &http3.Server{
+			Handler:        handler,
+			TLSConfig:      http3.ConfigureTLSConfig(l.http.TLSConfig.Clone()),
+			IdleTimeout:    limits.IdleTimeout,
+			MaxHeaderBytes: limits.MaxHeaderBytes,
+		}
+		l.http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l.h3.SetQUICHeaders(w.Header())
+			handler.ServeHTTP(w, r)
+		})
+	}
+	return l, nil
+}
+
+// Inherit makes Listen take sockets handed over by a previous process (see
+// Files) instead of binding new ones. files returns nil for unknown names.
+func (s *Server) Inherit(files func(name string) *os.File) {
+	s.inherited = files
+}
+
+// Files duplicates the bound sockets, keyed by name, so they can be handed to
+// another process. Unix sockets stop being removed on close, as the other
+// process keeps serving on them.
+func (s *Server) Files() (map[string]*os.File, error) {
+	type filer interface {
+		File() (*os.File, error)
+	}
+
+	files := map[string]*os.File{}
+	add := func(name string, sock any) error {
+		fl, ok := sock.(filer)
+		if !ok {
+			return fmt.Errorf("socket %s cannot be handed over", name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files[name] = f
+		return nil
+	}
+
+	for _, l := range s.listeners {
+		if ul, ok := l.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		err := add(l.cfg.Address, l.ln)
+		if err == nil && l.udp != nil {
+			err = add(quicName(l.cfg.Address), l.udp)
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func quicName(addr string) string {
+	return addr + "#quic"
+}
+
+// Listen binds every listener. On error, the ones already bound are closed.
+func (s *Server) Listen() error {
+	used := map[int]bool{}
+	inherit := func(name string) (net.Listener, error) {
+		fds, err := systemd.Listeners()
+		if err != nil {
+			return nil, err
+		}
+		for i, fd := range fds {
+			if fd.Name == name && !used[i] {
+				used[i] = true
+				return fd.Listener, nil
+			}
+		}
+		if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(fds) && !used[i] {
+			used[i] = true
+			return fds[i].Listener, nil
+		}
+		return nil, fmt.Errorf("no socket %q passed by systemd", name)
+	}
+
+	for i, l := range s.listeners {
+		if err := l.listen(inherit, s.inherited); err != nil {
+			for _, bound := range s.listeners[:i] {
+				bound.close()
+			}
+			return fmt.Errorf("listener %s: %w", l.cfg.Address, err)
+		}
+	}
+	return nil
+}
+
+func (l *listener) listen(inherit func(name string) (net.Listener, error), handedOver func(name string) *os.File) error {
+	var err error
+	if f := take(handedOver, l.cfg.Address); f != nil {
+		l.ln, err = net.FileListener(f)
+		f.Close()
+	} else if name, ok := strings.CutPrefix(l.cfg.Address, systemdPrefix); ok {
+		l.ln, err = inherit(name)
+	} else if path, ok := strings.CutPrefix(l.cfg.Address, unixPrefix); ok {
+		// a stale socket from a previous run would make bind fail, but
+		// anything else at the path is left alone
+		if fi, err := os.Lstat(path); err == nil {
+			if fi.Mode()&os.ModeSocket == 0 {
+				return fmt.Errorf("%s exists and is not a socket", path)
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		l.ln, err = net.Listen("unix", path)
+	} else {
+		l.ln, err = net.Listen("tcp", l.cfg.Address)
+	}
+	if err != nil {
+		return err
+	}
+
+	if l.h3 != nil {
+		if f := take(handedOver, quicName(l.cfg.Address)); f != nil {
+			l.udp, err = net.FilePacketConn(f)
+			f.Close()
+		} else {
+			l.udp, err = net.ListenPacket("udp", l.ln.Addr().String())
+		}
+		if err != nil {
+			l.ln.Close()
+			return fmt.Errorf("failed to listen for HTTP/3: %w", err)
+		}
+	}
+	return nil
+}
+
+func take(files func(name string) *os.File, name string) *os.File {
+	if files == nil {
+		return nil
+	}
+	return files(name)
+}
+
+func (l *listener) close() {
+	l.ln.Close()
+	if l.udp != nil {
+		l.udp.Close()
+	}
+}
+
+// Addrs returns the bound addresses, in config order. Only valid after Listen.
+func (s *Server) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.ln.Addr())
+	}
+	return addrs
+}
+
+// Serve serves on all bound listeners and returns the first error.
+// It returns http.ErrServerClosed after Shutdown.
+func (s *Server) Serve() error {
+	errs := make(chan error, 2*len(s.listeners))
+	for _, l := range s.listeners {
+		logger.Logger().Info("Serving HTTP",
+			zap.String("addr", l.ln.Addr().String()),
+			zap.Bool("tls", l.http.TLSConfig != nil),
+			zap.Bool("h2c", l.http.Protocols != nil),
+			zap.Bool("http3", l.h3 != nil),
+		)
+		if l.h3 != nil {
+			go func() {
+				if err := l.h3.Serve(l.udp); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+					errs <- err
+				}
+			}()
+		}
+		go func() {
+			if l.http.TLSConfig != nil {
+				errs <- l.http.ServeTLS(l.ln, "", "")
+			} else {
+				errs <- l.http.Serve(l.ln)
+			}
+		}()
+	}
+	return <-errs
+}
+
+// ListenAndServe binds and serves every listener.
+func (s *Server) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Shutdown gracefully stops all listeners, waiting for in-flight requests
+// until ctx expires.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, l := range s.listeners {
+		if l.h3 != nil {
+			errs = append(errs, l.h3.Shutdown(ctx))
+		}
+		errs = append(errs, l.http.Shutdown(ctx))
+		if l.ln != nil {
+			// not closed by http.Server if Serve never ran
+			l.close()
+		}
+	}
+	return errors.Join(errs...)
+}
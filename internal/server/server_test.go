@@ -0,0 +1,332 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/server"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and returns its TLS config
+func writeCert(t *testing.T) server.TLSConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gateway-test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	cfg := server.TLSConfig{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	require.NoError(t, os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return cfg
+}
+
+// start serves the listeners and returns their bound addresses
+func start(t *testing.T, cfgs ...server.ListenerConfig) []net.Addr {
+	t.Helper()
+	return startWithLimits(t, server.Limits{}, cfgs...)
+}
+
+func startWithLimits(t *testing.T, limits server.Limits, cfgs ...server.ListenerConfig) []net.Addr {
+	t.Helper()
+	srv, err := server.New(cfgs, limits, okHandler)
+	require.NoError(t, err)
+	require.NoError(t, srv.Listen())
+	go srv.Serve()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	return srv.Addrs()
+}
+
+// TestServer_H2C tests that prior-knowledge HTTP/2 works without TLS
+func TestServer_H2C(t *testing.T) {
+	addr := start(t, server.ListenerConfig{Address: "127.0.0.1:0", H2C: true})[0].String()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	resp, err := client.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+}
+
+// TestServer_HTTP3 tests that HTTP/3 is served next to HTTPS and advertised with Alt-Svc
+func TestServer_HTTP3(t *testing.T) {
+	addr := start(t, server.ListenerConfig{Address: "127.0.0.1:0", TLS: writeCert(t), HTTP3: true})[0].String()
+	tlsConf := &tls.Config{InsecureSkipVerify: true}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf, ForceAttemptHTTP2: true}}
+	resp, err := client.Get("https://" + addr + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Contains(t, resp.Header.Get("Alt-Svc"), "h3=")
+
+	h3 := &http3.Transport{TLSClientConfig: tlsConf}
+	defer h3.Close()
+	client = &http.Client{Transport: h3, Timeout: 5 * time.Second}
+	require.Eventually(t, func() bool {
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.ProtoMajor == 3
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+// TestNew_HTTP3RequiresTLS tests that HTTP/3 cannot be enabled without a certificate
+func TestNew_HTTP3RequiresTLS(t *testing.T) {
+	_, err := server.New([]server.ListenerConfig{{Address: ":0", HTTP3: true}}, server.Limits{}, okHandler)
+	assert.Error(t, err)
+}
+
+// TestServer_MultipleListeners tests serving a TCP port and a Unix socket at once
+func TestServer_MultipleListeners(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "gateway.sock")
+	// a leftover socket from a previous run must not prevent binding
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	require.FileExists(t, sock)
+
+	addrs := start(t,
+		server.ListenerConfig{Address: "127.0.0.1:0"},
+		server.ListenerConfig{Address: "unix://" + sock},
+	)
+	require.Len(t, addrs, 2)
+
+	resp, err := http.Get("http://" + addrs[0].String() + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err = client.Get("http://gateway/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// TestServer_UnixPathNotSocket tests that a unix listener refuses to replace a file that is not a socket
+func TestServer_UnixPathNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.conf")
+	require.NoError(t, os.WriteFile(path, []byte("keep me"), 0o600))
+
+	srv, err := server.New([]server.ListenerConfig{{Address: "unix://" + path}}, server.Limits{}, okHandler)
+	require.NoError(t, err)
+	assert.ErrorContains(t, srv.Listen(), "is not a socket")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "keep me", string(data))
+}
+
+// TestServer_ListenFailure tests that a bind error releases the listeners already bound
+func TestServer_ListenFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	freeAddr := free.Addr().String()
+	free.Close()
+
+	srv, err := server.New([]server.ListenerConfig{
+		{Address: freeAddr},
+		{Address: taken.Addr().String()},
+	}, server.Limits{}, okHandler)
+	require.NoError(t, err)
+	assert.Error(t, srv.Listen())
+
+	ln, err := net.Listen("tcp", freeAddr)
+	require.NoError(t, err)
+	ln.Close()
+}
+
+// TestServer_InheritFiles tests that a second server takes over the sockets of the first
+func TestServer_InheritFiles(t *testing.T) {
+	cfgs := []server.ListenerConfig{{Address: "127.0.0.1:0"}}
+	old, err := server.New(cfgs, server.Limits{}, okHandler)
+	require.NoError(t, err)
+	require.NoError(t, old.Listen())
+
+	files, err := old.Files()
+	require.NoError(t, err)
+	require.Contains(t, files, "127.0.0.1:0")
+
+	srv, err := server.New(cfgs, server.Limits{}, okHandler)
+	require.NoError(t, err)
+	srv.Inherit(func(name string) *os.File { return files[name] })
+	require.NoError(t, srv.Listen())
+	assert.Equal(t, old.Addrs()[0].String(), srv.Addrs()[0].String())
+
+	// the old server drains while the new one keeps the port open
+	require.NoError(t, old.Shutdown(context.Background()))
+	go srv.Serve()
+	defer srv.Shutdown(context.Background())
+
+	resp, err := http.Get("http://" + srv.Addrs()[0].String() + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// TestServer_Limits tests that slow headers are cut off and oversized headers rejected
+func TestServer_Limits(t *testing.T) {
+	addr := startWithLimits(t, server.Limits{
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		MaxHeaderBytes:    1024,
+	}, server.ListenerConfig{Address: "127.0.0.1:0"})[0].String()
+
+	// slowloris: send a partial request and never finish the headers
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	buf := make([]byte, 1)
+	for err == nil {
+		_, err = conn.Read(buf)
+	}
+	var netErr net.Error
+	assert.False(t, errors.As(err, &netErr) && netErr.Timeout(), "server kept the slow connection open")
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Big", strings.Repeat("a", 8192))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+}
+
+// clientCA returns a CA certificate in PEM and a client certificate it signed for commonName
+func clientCA(t *testing.T, commonName string) (string, tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "internal-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})), tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TestServer_ClientCertificates tests that listeners with a client CA verify client certificates, optionally or always
+func TestServer_ClientCertificates(t *testing.T) {
+	caPEM, clientCert := clientCA(t, "billing")
+	_, strangerCert := clientCA(t, "billing")
+	cn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+		}
+	})
+	tlsCfg := writeCert(t)
+	tlsCfg.ClientCA = caPEM
+	optional := server.ListenerConfig{Address: "127.0.0.1:0", TLS: tlsCfg}
+	tlsCfg.ClientAuth = server.ClientRequired
+	required := server.ListenerConfig{Address: "127.0.0.1:0", TLS: tlsCfg}
+	srv, err := server.New([]server.ListenerConfig{optional, required}, server.Limits{}, cn)
+	require.NoError(t, err)
+	require.NoError(t, srv.Listen())
+	go srv.Serve()
+	defer srv.Shutdown(context.Background())
+	addrs := srv.Addrs()
+
+	get := func(addr net.Addr, certs ...tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs}}}
+		resp, err := client.Get("https://" + addr.String() + "/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body := new(strings.Builder)
+		_, err = io.Copy(body, resp.Body)
+		return body.String(), err
+	}
+
+	body, err := get(addrs[0], clientCert)
+	require.NoError(t, err)
+	assert.Equal(t, "billing", body)
+	body, err = get(addrs[0])
+	require.NoError(t, err)
+	assert.Empty(t, body, "optional client certificates")
+	_, err = get(addrs[0], strangerCert)
+	assert.Error(t, err, "certificates of another CA are refused")
+
+	_, err = get(addrs[1])
+	assert.Error(t, err, "required client certificates")
+	body, err = get(addrs[1], clientCert)
+	require.NoError(t, err)
+	assert.Equal(t, "billing", body)
+
+	_, err = server.New([]server.ListenerConfig{{Address: ":0", TLS: server.TLSConfig{ClientCA: caPEM}}}, server.Limits{}, cn)
+	assert.Error(t, err, "client certificates require tls")
+}
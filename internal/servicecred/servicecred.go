@@ -0,0 +1,285 @@
+// Package servicecred attaches the gateway's own credential to every backend
+// call, next to the token of the user, so that backends can verify that
+// calls really came through the gateway. The credential is a static token,
+// an access token obtained with the OAuth 2.0 client credentials grant and
+// cached until it expires, or the client certificate of an mTLS connection.
+package servicecred
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// DefaultMetadata is the metadata key of the service token. The user token
+// keeps authorization.
+const DefaultMetadata = "x-gateway-authorization"
+
+// Config configures the credential of the gateway towards its backends.
+type Config struct {
+	// Token is a static service token, sent as "Bearer <token>".
+	Token string `yaml:"token"`
+
+	// OAuth obtains the service token with the client credentials grant.
+	OAuth OAuthConfig `yaml:"oauth"`
+
+	// Metadata is the metadata key of the service token. Default:
+	// DefaultMetadata.
+	Metadata string `yaml:"metadata"`
+
+	// TLS connects to the backends over TLS, presenting a client
+	// certificate when one is set.
+	TLS TLSConfig `yaml:"tls"`
+}
+
+// OAuthConfig configures the client credentials grant.
+type OAuthConfig struct {
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+
+	// Audience is sent as the audience parameter, which some providers
+	// require to pick the token audience.
+	Audience string `yaml:"audience"`
+
+	// Timeout bounds each token request. Default: 10s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// TLSConfig configures TLS towards the backends.
+type TLSConfig struct {
+	// CAFile verifies the backend certificates. Default: the system roots.
+	CAFile string `yaml:"ca_file"`
+
+	// CertFile and KeyFile, or the PEM-encoded Cert and Key, are the client
+	// certificate of the gateway.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+
+	// ServerName overrides the name the backend certificates are verified
+	// for.
+	ServerName string `yaml:"server_name"`
+}
+
+// MetadataKey returns the metadata key of the service token.
+func (c Config) MetadataKey() string {
+	if c.Metadata == "" {
+		return DefaultMetadata
+	}
+	return strings.ToLower(c.Metadata)
+}
+
+// Enabled reports whether the backends are dialed over TLS.
+func (c TLSConfig) Enabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.Cert != "" || c.Key != "" || c.ServerName != ""
+}
+
+var refreshesTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "service_token",
+	Name:      "refreshes_total",
+	Help:      "Service token requests to the OAuth token endpoint, by result (ok, error).",
+}, []string{"result"})
+
+// DialOptions returns the dial options of the backend connections: the
+// transport credentials, plain unless TLS is configured, and the service
+// token attached to every call.
+func DialOptions(cfg Config) ([]grpc.DialOption, error) {
+	if cfg.Token != "" && cfg.OAuth.TokenURL != "" {
+		return nil, errors.New("backend auth: token and oauth are exclusive")
+	}
+	cfg.Metadata = cfg.MetadataKey()
+	if cfg.Metadata == "authorization" {
+		return nil, errors.New("backend auth: the authorization metadata carries the user token")
+	}
+
+	creds := insecure.NewCredentials()
+	if cfg.TLS.Enabled() {
+		conf, err := cfg.TLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(conf)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+
+	switch {
+	case cfg.Token != "":
+		opts = append(opts, grpc.WithPerRPCCredentials(&static{key: cfg.Metadata, value: "Bearer " + cfg.Token}))
+	case cfg.OAuth.TokenURL != "":
+		src, err := NewClientCredentials(cfg.OAuth, cfg.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(src))
+	}
+	return opts, nil
+}
+
+func (c TLSConfig) tlsConfig() (*tls.Config, error) {
+	conf := &tls.Config{ServerName: c.ServerName, MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("backend auth: failed to read CA: %w", err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("backend auth: CA holds no PEM certificate")
+		}
+	}
+	var cert tls.Certificate
+	var err error
+	switch {
+	case c.Cert != "" || c.Key != "":
+		cert, err = tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+	case c.CertFile != "" || c.KeyFile != "":
+		cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	default:
+		return conf, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("backend auth: failed to load client certificate: %w", err)
+	}
+	conf.Certificates = []tls.Certificate{cert}
+	return conf, nil
+}
+
+// static sends the same token with every call.
+type static struct {
+	key, value string
+}
+
+func (s *static) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{s.key: s.value}, nil
+}
+
+func (s *static) RequireTransportSecurity() bool {
+	return false
+}
+
+// ClientCredentials sends a token obtained with the OAuth 2.0 client
+// credentials grant. Tokens are cached and renewed shortly before they
+// expire; while renewal fails, the current token is used until it expires.
+type ClientCredentials struct {
+	cfg    OAuthConfig
+	key    string
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	renew   time.Time
+	expires time.Time
+}
+
+// NewClientCredentials returns the ClientCredentials of cfg, sent as the
+// metadata key.
+func NewClientCredentials(cfg OAuthConfig, key string) (*ClientCredentials, error) {
+	u, err := url.Parse(cfg.TokenURL)
+	if err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("backend auth: invalid token_url %q", cfg.TokenURL)
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("backend auth: oauth needs a client_id")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &ClientCredentials{cfg: cfg, key: key, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (c *ClientCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	tok, err := c.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{c.key: "Bearer " + tok}, nil
+}
+
+func (c *ClientCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// Token returns the cached token, or a new one once it is about to expire.
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.token != "" && now.Before(c.renew) {
+		return c.token, nil
+	}
+	tok, ttl, err := c.fetch(ctx)
+	if err != nil {
+		refreshesTotal.WithLabelValues("error").Inc()
+		if c.token != "" && now.Before(c.expires) {
+			logger.Logger().Warn("Failed to renew service token, using the current one", zap.Time("expires", c.expires), zap.Error(err))
+			return c.token, nil
+		}
+		return "", err
+	}
+	refreshesTotal.WithLabelValues("ok").Inc()
+	// renew a tenth of the lifetime, but at most a minute, before expiry
+	c.token, c.expires = tok, now.Add(ttl)
+	c.renew = c.expires.Add(-min(ttl/10, time.Minute))
+	return tok, nil
+}
+
+func (c *ClientCredentials) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.cfg.Scopes, " "))
+	}
+	if c.cfg.Audience != "" {
+		form.Set("audience", c.cfg.Audience)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("backend auth: token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil && resp.StatusCode == http.StatusOK {
+		return "", 0, fmt.Errorf("backend auth: invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || out.AccessToken == "" {
+		return "", 0, fmt.Errorf("backend auth: token endpoint answered %d %s", resp.StatusCode, out.Error)
+	}
+	ttl := time.Duration(out.ExpiresIn) * time.Second
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return out.AccessToken, ttl, nil
+}
@@ -0,0 +1,171 @@
+package servicecred_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/servicecred"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// backend serves the health service and records the metadata of each call.
+func backend(t *testing.T) (string, <-chan metadata.MD) {
+	t.Helper()
+	calls := make(chan metadata.MD, 10)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		calls <- md
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String(), calls
+}
+
+func call(t *testing.T, cfg servicecred.Config) metadata.MD {
+	t.Helper()
+	addr, calls := backend(t)
+	opts, err := servicecred.DialOptions(cfg)
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(addr, opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer user-token")
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	return <-calls
+}
+
+// TestDialOptions_StaticToken tests that the static token is sent next to the user token
+func TestDialOptions_StaticToken(t *testing.T) {
+	md := call(t, servicecred.Config{Token: "gateway-secret"})
+	assert.Equal(t, []string{"Bearer gateway-secret"}, md.Get(servicecred.DefaultMetadata))
+	assert.Equal(t, []string{"Bearer user-token"}, md.Get("authorization"))
+
+	md = call(t, servicecred.Config{Token: "gateway-secret", Metadata: "X-Service-Token"})
+	assert.Equal(t, []string{"Bearer gateway-secret"}, md.Get("x-service-token"))
+
+	md = call(t, servicecred.Config{})
+	assert.Empty(t, md.Get(servicecred.DefaultMetadata))
+}
+
+// TestDialOptions_Invalid tests that conflicting settings are rejected
+func TestDialOptions_Invalid(t *testing.T) {
+	_, err := servicecred.DialOptions(servicecred.Config{Token: "a", OAuth: servicecred.OAuthConfig{TokenURL: "https://idp/token", ClientID: "gw"}})
+	assert.Error(t, err)
+
+	_, err = servicecred.DialOptions(servicecred.Config{Token: "a", Metadata: "Authorization"})
+	assert.Error(t, err, "the user token keeps authorization")
+
+	_, err = servicecred.DialOptions(servicecred.Config{OAuth: servicecred.OAuthConfig{TokenURL: "/token", ClientID: "gw"}})
+	assert.Error(t, err)
+
+	_, err = servicecred.DialOptions(servicecred.Config{TLS: servicecred.TLSConfig{CertFile: "missing.pem", KeyFile: "missing.key"}})
+	assert.Error(t, err)
+}
+
+// tokenServer issues client credentials tokens valid for expiresIn seconds.
+func tokenServer(t *testing.T, expiresIn int, fail *atomic.Bool) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var issued atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if fail != nil && fail.Load() || !ok || id != "gateway" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+		assert.Equal(t, "backends:call", r.FormValue("scope"))
+		n := issued.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": fmt.Sprintf("service-token-%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &issued
+}
+
+func oauth(url string) servicecred.OAuthConfig {
+	return servicecred.OAuthConfig{TokenURL: url, ClientID: "gateway", ClientSecret: "s3cret", Scopes: []string{"backends:call"}}
+}
+
+// TestDialOptions_OAuth tests that the client credentials token is attached to backend calls
+func TestDialOptions_OAuth(t *testing.T) {
+	srv, issued := tokenServer(t, 3600, nil)
+	md := call(t, servicecred.Config{OAuth: oauth(srv.URL)})
+	assert.Equal(t, []string{"Bearer service-token-1"}, md.Get(servicecred.DefaultMetadata))
+	assert.Equal(t, []string{"Bearer user-token"}, md.Get("authorization"))
+	assert.EqualValues(t, 1, issued.Load())
+}
+
+// TestClientCredentials_Cache tests that tokens are cached until shortly before they expire
+func TestClientCredentials_Cache(t *testing.T) {
+	srv, issued := tokenServer(t, 3600, nil)
+	cc, err := servicecred.NewClientCredentials(oauth(srv.URL), servicecred.DefaultMetadata)
+	require.NoError(t, err)
+	for range 3 {
+		tok, err := cc.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "service-token-1", tok)
+	}
+	assert.EqualValues(t, 1, issued.Load())
+
+	srv, issued = tokenServer(t, 1, nil)
+	cc, err = servicecred.NewClientCredentials(oauth(srv.URL), servicecred.DefaultMetadata)
+	require.NoError(t, err)
+	_, err = cc.Token(context.Background())
+	require.NoError(t, err)
+	time.Sleep(1100 * time.Millisecond)
+	tok, err := cc.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "service-token-2", tok, "expired tokens are renewed")
+	assert.EqualValues(t, 2, issued.Load())
+}
+
+// TestClientCredentials_RenewalFailure tests that the current token is kept while renewal fails and it has not expired
+func TestClientCredentials_RenewalFailure(t *testing.T) {
+	var fail atomic.Bool
+	// a tenth of two seconds before expiry, the token is renewed
+	srv, _ := tokenServer(t, 2, &fail)
+	cc, err := servicecred.NewClientCredentials(oauth(srv.URL), servicecred.DefaultMetadata)
+	require.NoError(t, err)
+	_, err = cc.Token(context.Background())
+	require.NoError(t, err)
+
+	fail.Store(true)
+	time.Sleep(1900 * time.Millisecond)
+	tok, err := cc.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "service-token-1", tok)
+
+	time.Sleep(200 * time.Millisecond)
+	_, err = cc.Token(context.Background())
+	assert.Error(t, err, "expired tokens are not used")
+
+	bad := oauth(srv.URL)
+	bad.ClientSecret = "wrong"
+	fail.Store(false)
+	cc, err = servicecred.NewClientCredentials(bad, servicecred.DefaultMetadata)
+	require.NoError(t, err)
+	_, err = cc.Token(context.Background())
+	assert.ErrorContains(t, err, "invalid_client")
+}
@@ -0,0 +1,252 @@
+// Package signature authenticates machine clients by HMAC request
+// signatures, for partners that cannot use OAuth but need more than a static
+// API key. Each request carries its key ID, a timestamp, a single-use nonce
+// and the HMAC-SHA256 of the request, keyed with the secret of the key.
+// Requests with stale timestamps are refused, and every nonce is remembered
+// for as long as its timestamp is accepted, so that a captured request
+// cannot be replayed.
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Headers of signed requests.
+const (
+	KeyIDHeader     = "X-Key-ID"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+
+	// SignatureHeader is "sha256=" followed by the hex HMAC-SHA256 of the
+	// string to sign (see StringToSign), keyed with the key secret.
+	SignatureHeader = "X-Signature"
+)
+
+// Config configures request signatures.
+type Config struct {
+	// Keys are the signing keys of the machine clients.
+	Keys []Key `yaml:"keys"`
+
+	// MaxSkew is how far the timestamp of a request may be from the
+	// gateway clock. Default: 5m.
+	MaxSkew time.Duration `yaml:"max_skew"`
+
+	// MaxBody bounds the bodies of signed requests, which are read to be
+	// hashed. Default: 10 MiB.
+	MaxBody int64 `yaml:"max_body"`
+
+	// Redis keeps the seen nonces in Redis instead of memory when its
+	// address is set, so that replays are refused by every instance.
+	Redis RedisConfig `yaml:"redis"`
+}
+
+// Key is the signing key of a machine client.
+type Key struct {
+	// ID is sent in X-Key-ID and becomes the user ID of the requests.
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+
+	// Roles are granted to the requests, as access token roles are.
+	Roles []string `yaml:"roles"`
+}
+
+// Errors returned by Verify.
+var (
+	ErrMalformed  = errors.New("malformed request signature")
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrStale      = errors.New("request timestamp outside the accepted window")
+	ErrMismatch   = errors.New("request signature does not match")
+	ErrReplayed   = errors.New("request nonce already used")
+	ErrTooLarge   = errors.New("signed request body too large")
+)
+
+var signaturesTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "auth",
+	Name:      "signatures_total",
+	Help:      "Signed requests checked, by result (malformed, unknown_key, stale, mismatch, replayed, too_large, error, accepted).",
+}, []string{"result"})
+
+var results = map[error]string{
+	ErrMalformed:  "malformed",
+	ErrUnknownKey: "unknown_key",
+	ErrStale:      "stale",
+	ErrMismatch:   "mismatch",
+	ErrReplayed:   "replayed",
+	ErrTooLarge:   "too_large",
+}
+
+// Verifier checks request signatures. A nil *Verifier accepts none.
+type Verifier struct {
+	keys    map[string]Key
+	maxSkew time.Duration
+	maxBody int64
+	nonces  NonceStore
+}
+
+// New returns the Verifier of cfg, remembering nonces in Redis when
+// configured and in memory otherwise. It returns nil without keys.
+func New(cfg Config) (*Verifier, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+	var store NonceStore = NewMemoryStore()
+	if cfg.Redis.Addr != "" {
+		var err error
+		if store, err = NewRedisStore(cfg.Redis); err != nil {
+			return nil, err
+		}
+	}
+	return NewWithStore(cfg, store)
+}
+
+// NewWithStore returns the Verifier of cfg remembering nonces in store.
+func NewWithStore(cfg Config, store NonceStore) (*Verifier, error) {
+	if cfg.MaxSkew <= 0 {
+		cfg.MaxSkew = 5 * time.Minute
+	}
+	if cfg.MaxBody <= 0 {
+		cfg.MaxBody = 10 << 20
+	}
+	v := &Verifier{keys: map[string]Key{}, maxSkew: cfg.MaxSkew, maxBody: cfg.MaxBody, nonces: store}
+	for _, k := range cfg.Keys {
+		if k.ID == "" || k.Secret == "" {
+			return nil, errors.New("signing keys need an id and a secret")
+		}
+		if _, ok := v.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key %q", k.ID)
+		}
+		v.keys[k.ID] = k
+	}
+	return v, nil
+}
+
+// Signed reports whether r carries a signature.
+func Signed(r *http.Request) bool {
+	return r.Header.Get(SignatureHeader) != ""
+}
+
+// Verify checks the signature of r and returns its key. The body of r is
+// read and replaced, so that handlers can still read it.
+func (v *Verifier) Verify(r *http.Request) (*Key, error) {
+	key, err := v.verify(r)
+	result := "accepted"
+	if err != nil {
+		result = "error"
+		for e, res := range results {
+			if errors.Is(err, e) {
+				result = res
+			}
+		}
+	}
+	signaturesTotal.WithLabelValues(result).Inc()
+	return key, err
+}
+
+func (v *Verifier) verify(r *http.Request) (*Key, error) {
+	if v == nil {
+		return nil, ErrUnknownKey
+	}
+	id, ts, nonce := r.Header.Get(KeyIDHeader), r.Header.Get(TimestampHeader), r.Header.Get(NonceHeader)
+	sig, ok := strings.CutPrefix(r.Header.Get(SignatureHeader), "sha256=")
+	if !ok || id == "" || ts == "" || len(nonce) < 16 || len(nonce) > 128 {
+		return nil, ErrMalformed
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	key, ok := v.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > v.maxSkew || skew < -v.maxSkew {
+		return nil, ErrStale
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, v.maxBody+1))
+		r.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("signature: failed to read body: %w", err)
+		}
+		if int64(len(body)) > v.maxBody {
+			return nil, ErrTooLarge
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	want := Sum(key.Secret, StringToSign(r.Method, r.URL.RequestURI(), ts, nonce, body))
+	if !hmac.Equal(mac, want) {
+		return nil, ErrMismatch
+	}
+
+	// the nonce is only recorded once the signature proves it is the
+	// client's, and kept until its timestamp is refused anyway
+	fresh, err := v.nonces.Remember(r.Context(), id+":"+nonce, 2*v.maxSkew)
+	if err != nil {
+		return nil, fmt.Errorf("signature: failed to check nonce: %w", err)
+	}
+	if !fresh {
+		return nil, ErrReplayed
+	}
+	return &key, nil
+}
+
+// Close closes the nonce store.
+func (v *Verifier) Close() error {
+	if v == nil {
+		return nil
+	}
+	return v.nonces.Close()
+}
+
+// StringToSign is what clients sign: the method, the path and query, the
+// timestamp, the nonce and the hex SHA-256 of the body, one per line.
+func StringToSign(method, uri, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{method, uri, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n")
+}
+
+// Sum returns the HMAC-SHA256 of s keyed with secret.
+func Sum(secret, s string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+// Sign sets the signature headers of r, a request with body, for the key id
+// with secret. It is what clients do, and is used by tests.
+func Sign(r *http.Request, id, secret, nonce string, body []byte, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(KeyIDHeader, id)
+	r.Header.Set(TimestampHeader, ts)
+	r.Header.Set(NonceHeader, nonce)
+	r.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(Sum(secret, StringToSign(r.Method, r.URL.RequestURI(), ts, nonce, body))))
+}
+
+// NonceStore remembers the nonces of accepted requests.
+type NonceStore interface {
+	// Remember records nonce for ttl and reports whether it was new.
+	Remember(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+	Close() error
+}
@@ -0,0 +1,81 @@
+package signature_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const nonce = "8c6f2b1e0d9a4f37"
+
+func signed(t *testing.T, body string, secret string, now time.Time) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest("PUT", "/inventory/products/42?notify=1", bytes.NewReader([]byte(body)))
+	signature.Sign(r, "partner-1", secret, nonce, []byte(body), now)
+	return r
+}
+
+// TestVerifier tests that only fresh, untampered requests signed with a known key are accepted, once
+func TestVerifier(t *testing.T) {
+	v, err := signature.New(signature.Config{
+		Keys:    []signature.Key{{ID: "partner-1", Secret: "partner-secret"}},
+		MaxSkew: time.Minute,
+		MaxBody: 64,
+	})
+	require.NoError(t, err)
+	defer v.Close()
+
+	key, err := v.Verify(signed(t, `{"stock": 3}`, "partner-secret", time.Now()))
+	require.NoError(t, err)
+	assert.Equal(t, "partner-1", key.ID)
+
+	_, err = v.Verify(signed(t, `{"stock": 3}`, "partner-secret", time.Now()))
+	assert.ErrorIs(t, err, signature.ErrReplayed)
+
+	r := signed(t, `{"stock": 3}`, "partner-secret", time.Now())
+	r.Header.Set(signature.NonceHeader, "a5d1c7e2f3b40968")
+	_, err = v.Verify(r)
+	assert.ErrorIs(t, err, signature.ErrMismatch, "the nonce is signed")
+
+	r = signed(t, `{"stock": 3}`, "partner-secret", time.Now())
+	r.Body = httptest.NewRequest("PUT", "/", bytes.NewReader([]byte(`{"stock": 300}`))).Body
+	r.Header.Set(signature.NonceHeader, "f00dfeedc0ffee11")
+	_, err = v.Verify(r)
+	assert.ErrorIs(t, err, signature.ErrMismatch)
+
+	_, err = v.Verify(signed(t, `{"stock": 3}`, "guessed-secret", time.Now()))
+	assert.ErrorIs(t, err, signature.ErrMismatch)
+
+	_, err = v.Verify(signed(t, `{"stock": 3}`, "partner-secret", time.Now().Add(-2*time.Minute)))
+	assert.ErrorIs(t, err, signature.ErrStale)
+
+	_, err = v.Verify(signed(t, string(make([]byte, 65)), "partner-secret", time.Now()))
+	assert.ErrorIs(t, err, signature.ErrTooLarge)
+
+	r = signed(t, "", "partner-secret", time.Now())
+	r.Header.Set(signature.KeyIDHeader, "partner-2")
+	_, err = v.Verify(r)
+	assert.ErrorIs(t, err, signature.ErrUnknownKey)
+
+	r = signed(t, "", "partner-secret", time.Now())
+	r.Header.Set(signature.NonceHeader, "short")
+	_, err = v.Verify(r)
+	assert.ErrorIs(t, err, signature.ErrMalformed)
+}
+
+// TestNew tests that keys need an ID and a secret, and that no keys means no verifier
+func TestNew(t *testing.T) {
+	_, err := signature.New(signature.Config{Keys: []signature.Key{{ID: "partner-1"}}})
+	assert.Error(t, err)
+	_, err = signature.New(signature.Config{Keys: []signature.Key{{ID: "a", Secret: "x"}, {ID: "a", Secret: "y"}}})
+	assert.Error(t, err)
+	v, err := signature.New(signature.Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+}
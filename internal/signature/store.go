@@ -0,0 +1,94 @@
+package signature
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryStore keeps nonces in process memory. Replays are only refused by
+// the instance that saw the request.
+type MemoryStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nonces: map[string]time.Time{}}
+}
+
+// Remember records nonce and drops expired nonces.
+func (s *MemoryStore) Remember(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for n, expires := range s.nonces {
+		if now.After(expires) {
+			delete(s.nonces, n)
+		}
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return false, nil
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// RedisConfig configures the Redis store.
+type RedisConfig struct {
+	// Addr is the host:port of the Redis server.
+	Addr string `yaml:"addr"`
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+
+	// Prefix is prepended to nonce keys. Default: "gateway:nonce:".
+	Prefix string `yaml:"prefix"`
+}
+
+// storeTimeout bounds the connection check of the Redis store.
+const storeTimeout = 5 * time.Second
+
+// RedisStore keeps nonces in Redis, one expiring key per nonce, so that
+// every gateway instance refuses replays.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects to Redis and checks that it answers.
+func NewRedisStore(cfg RedisConfig) (*RedisStore, error) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "gateway:nonce:"
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("signature: failed to connect to Redis: %w", err)
+	}
+	return &RedisStore{client: client, prefix: cfg.Prefix}, nil
+}
+
+func (s *RedisStore) Remember(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+nonce, 1, ttl).Result()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
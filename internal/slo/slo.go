@@ -0,0 +1,355 @@
+// Package slo tracks service level objectives per route group, such as
+// "99.9% of logins succeed within 300ms". Every request of a group is good
+// or bad; the share of bad requests over rolling windows, divided by the
+// error budget the objective allows, is its burn rate. Burn rates are
+// exported as metrics and on /statusz, and an event is emitted, e.g. for
+// webhooks, when the budget of an objective burns too fast and when it
+// recovers.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/disconnect"
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/http/instrument"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Event types emitted when an objective starts and stops burning its budget
+// too fast.
+const (
+	BudgetBurning   = "slo.budget_burning"
+	BudgetRecovered = "slo.budget_recovered"
+)
+
+// Config configures the objectives.
+type Config struct {
+	// Objectives are tried in order; a request counts towards the first
+	// one whose path_prefix and methods match it.
+	Objectives []Objective `yaml:"objectives"`
+
+	// Windows are the rolling windows burn rates are computed over.
+	// Default: [5m, 1h].
+	Windows []time.Duration `yaml:"windows"`
+
+	// AlertBurnRate is the burn rate over every window at which an
+	// objective is burning, e.g. 14.4 spends the budget of 30 days in two.
+	// Default: 14.4.
+	AlertBurnRate float64 `yaml:"alert_burn_rate"`
+
+	// Interval is how often burn rates are computed. Default: 30s.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Objective is the share of good requests a route group must reach.
+type Objective struct {
+	Name string `yaml:"name"`
+
+	// PathPrefix selects the routes, e.g. "/auth/login".
+	PathPrefix string `yaml:"path_prefix"`
+
+	// Methods restricts the objective to these request methods. Empty
+	// means all.
+	Methods []string `yaml:"methods"`
+
+	// Target is the percentage of good requests, e.g. 99.9.
+	Target float64 `yaml:"target"`
+
+	// Latency makes requests slower than it bad. Zero only counts errors.
+	Latency time.Duration `yaml:"latency"`
+}
+
+// Status is the state of an objective, as shown on /statusz.
+type Status struct {
+	Name    string        `json:"name"`
+	Target  float64       `json:"target"`
+	Latency time.Duration `json:"latency,omitempty"`
+
+	// BudgetRemaining is the share of the error budget of the longest
+	// window left, negative once overspent.
+	BudgetRemaining float64        `json:"budget_remaining"`
+	Burning         bool           `json:"burning"`
+	Windows         []WindowStatus `json:"windows"`
+}
+
+// WindowStatus is the state of an objective over one window.
+type WindowStatus struct {
+	Window   time.Duration `json:"window"`
+	Requests uint64        `json:"requests"`
+	Bad      uint64        `json:"bad"`
+	BurnRate float64       `json:"burn_rate"`
+}
+
+var (
+	requestsTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "slo",
+		Name:      "requests_total",
+		Help:      "Requests counted towards objectives, by objective and result (good, bad).",
+	}, []string{"objective", "result"})
+
+	burnRate = promauto.With(metrics.Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "slo",
+		Name:      "burn_rate",
+		Help:      "Rate at which objectives spend their error budget over each window; 1 spends it exactly.",
+	}, []string{"objective", "window"})
+
+	budgetRemaining = promauto.With(metrics.Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "slo",
+		Name:      "error_budget_remaining",
+		Help:      "Share of the error budget of the longest window left, by objective.",
+	}, []string{"objective"})
+)
+
+// bucketSize is the resolution of the rolling windows.
+const bucketSize = 10 * time.Second
+
+type bucket struct {
+	epoch int64
+	total uint64
+	bad   uint64
+}
+
+type objective struct {
+	Objective
+	budget float64
+
+	mu      sync.Mutex
+	buckets []bucket
+	burning bool
+}
+
+// Tracker records requests against the objectives. A nil *Tracker tracks
+// nothing.
+type Tracker struct {
+	cfg        Config
+	objectives []*objective
+	emitter    *events.Emitter
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New returns the Tracker of cfg, emitting alerts with emitter, or nil when
+// no objective is configured.
+func New(cfg Config, emitter *events.Emitter) (*Tracker, error) {
+	if len(cfg.Objectives) == 0 {
+		return nil, nil
+	}
+	if len(cfg.Windows) == 0 {
+		cfg.Windows = []time.Duration{5 * time.Minute, time.Hour}
+	}
+	if cfg.AlertBurnRate <= 0 {
+		cfg.AlertBurnRate = 14.4
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	for _, w := range cfg.Windows {
+		if w < bucketSize {
+			return nil, fmt.Errorf("slo window %s is shorter than %s", w, bucketSize)
+		}
+	}
+	slices.Sort(cfg.Windows)
+	n := int(cfg.Windows[len(cfg.Windows)-1]/bucketSize) + 1
+
+	t := &Tracker{cfg: cfg, emitter: emitter}
+	seen := map[string]bool{}
+	for _, o := range cfg.Objectives {
+		switch {
+		case o.Name == "" || seen[o.Name]:
+			return nil, fmt.Errorf("slo objectives need unique names, got %q", o.Name)
+		case !strings.HasPrefix(o.PathPrefix, "/"):
+			return nil, fmt.Errorf("slo %s: path prefix %q must start with /", o.Name, o.PathPrefix)
+		case o.Target <= 0 || o.Target >= 100:
+			return nil, fmt.Errorf("slo %s: target %v must be between 0 and 100", o.Name, o.Target)
+		}
+		seen[o.Name] = true
+		methods := make([]string, len(o.Methods))
+		for i, m := range o.Methods {
+			methods[i] = strings.ToUpper(m)
+		}
+		o.Methods = methods
+		t.objectives = append(t.objectives, &objective{
+			Objective: o,
+			budget:    1 - o.Target/100,
+			buckets:   make([]bucket, n),
+		})
+	}
+
+	var ctx context.Context
+	ctx, t.cancel = context.WithCancel(context.Background())
+	t.done = make(chan struct{})
+	go t.run(ctx)
+	return t, nil
+}
+
+// Middleware counts the requests of each objective as good or bad. Requests
+// whose client went away count as neither.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o := t.match(r)
+		if o == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		iw := instrument.Wrap(w)
+		next.ServeHTTP(iw, r)
+		if disconnect.Closed(r) {
+			return
+		}
+		bad := iw.Status() >= 500 || (o.Latency > 0 && time.Since(start) > o.Latency)
+		t.record(o, bad)
+	})
+}
+
+func (t *Tracker) match(r *http.Request) *objective {
+	for _, o := range t.objectives {
+		if !strings.HasPrefix(r.URL.Path, o.PathPrefix) {
+			continue
+		}
+		if len(o.Methods) > 0 && !slices.Contains(o.Methods, r.Method) {
+			continue
+		}
+		return o
+	}
+	return nil
+}
+
+func (t *Tracker) record(o *objective, bad bool) {
+	result := "good"
+	if bad {
+		result = "bad"
+	}
+	requestsTotal.WithLabelValues(o.Name, result).Inc()
+
+	epoch := time.Now().UnixNano() / int64(bucketSize)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b := &o.buckets[epoch%int64(len(o.buckets))]
+	if b.epoch != epoch {
+		*b = bucket{epoch: epoch}
+	}
+	b.total++
+	if bad {
+		b.bad++
+	}
+}
+
+// Status returns the state of every objective, in configuration order.
+func (t *Tracker) Status() []Status {
+	if t == nil {
+		return nil
+	}
+	out := make([]Status, 0, len(t.objectives))
+	for _, o := range t.objectives {
+		out = append(out, t.status(o))
+	}
+	return out
+}
+
+func (t *Tracker) status(o *objective) Status {
+	s := Status{Name: o.Name, Target: o.Target, Latency: o.Latency, BudgetRemaining: 1}
+	epoch := time.Now().UnixNano() / int64(bucketSize)
+	o.mu.Lock()
+	for _, w := range t.cfg.Windows {
+		ws := WindowStatus{Window: w}
+		oldest := epoch - int64(w/bucketSize)
+		for _, b := range o.buckets {
+			if b.epoch > oldest && b.epoch <= epoch {
+				ws.Requests += b.total
+				ws.Bad += b.bad
+			}
+		}
+		if ws.Requests > 0 {
+			ws.BurnRate = float64(ws.Bad) / float64(ws.Requests) / o.budget
+		}
+		s.Windows = append(s.Windows, ws)
+	}
+	s.Burning = o.burning
+	o.mu.Unlock()
+
+	if longest := s.Windows[len(s.Windows)-1]; longest.Requests > 0 {
+		s.BudgetRemaining = 1 - longest.BurnRate
+	}
+	return s
+}
+
+func (t *Tracker) run(ctx context.Context) {
+	defer close(t.done)
+	ticker := time.NewTicker(t.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Evaluate(ctx)
+		}
+	}
+}
+
+// Evaluate computes the burn rates, exports them and emits BudgetBurning or
+// BudgetRecovered for the objectives whose state changed. It runs every
+// interval.
+func (t *Tracker) Evaluate(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	for _, o := range t.objectives {
+		s := t.status(o)
+		burning := true
+		for _, w := range s.Windows {
+			burnRate.WithLabelValues(o.Name, w.Window.String()).Set(w.BurnRate)
+			if w.BurnRate < t.cfg.AlertBurnRate {
+				burning = false
+			}
+		}
+		budgetRemaining.WithLabelValues(o.Name).Set(s.BudgetRemaining)
+
+		o.mu.Lock()
+		changed := o.burning != burning
+		o.burning = burning
+		o.mu.Unlock()
+		if !changed {
+			continue
+		}
+		s.Burning = burning
+		typ := BudgetRecovered
+		if burning {
+			typ = BudgetBurning
+			logger.Logger().Warn("Error budget burning too fast",
+				zap.String("objective", o.Name),
+				zap.Float64("burn_rate", s.Windows[0].BurnRate),
+				zap.Float64("budget_remaining", s.BudgetRemaining),
+			)
+		}
+		t.emitter.Emit(ctx, typ, o.Name, s)
+	}
+}
+
+// Close stops evaluating the objectives.
+func (t *Tracker) Close() {
+	if t == nil {
+		return
+	}
+	t.cancel()
+	<-t.done
+}
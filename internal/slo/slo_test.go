@@ -0,0 +1,99 @@
+package slo_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/slo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTracker tests that bad and slow requests burn the budget of their objective and alert once when it burns too fast and once when it recovers
+func TestTracker(t *testing.T) {
+	emitter := events.NewWithPublisher(events.Config{}, nil)
+	var alerts []events.Event
+	emitter.Listen(func(msg events.Message) {
+		var ev events.Event
+		require.NoError(t, json.Unmarshal(msg.Value, &ev))
+		alerts = append(alerts, ev)
+	})
+
+	tracker, err := slo.New(slo.Config{
+		Objectives: []slo.Objective{{
+			Name:       "login",
+			PathPrefix: "/auth/login",
+			Methods:    []string{"post"},
+			Target:     90,
+			Latency:    50 * time.Millisecond,
+		}},
+		Windows:       []time.Duration{time.Minute, time.Hour},
+		AlertBurnRate: 2,
+		Interval:      time.Hour,
+	}, emitter)
+	require.NoError(t, err)
+	defer tracker.Close()
+
+	var status int
+	var delay time.Duration
+	h := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	}))
+	serve := func(method string, code int, d time.Duration) {
+		status, delay = code, d
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/auth/login", nil))
+	}
+
+	for range 6 {
+		serve("POST", http.StatusOK, 0)
+	}
+	serve("POST", http.StatusBadGateway, 0)
+	serve("POST", http.StatusUnauthorized, 0)
+	serve("POST", http.StatusOK, 80*time.Millisecond)
+	serve("GET", http.StatusInternalServerError, 0) // not part of the objective
+
+	tracker.Evaluate(context.Background())
+	s := tracker.Status()
+	require.Len(t, s, 1)
+	assert.True(t, s[0].Burning)
+	assert.Equal(t, uint64(9), s[0].Windows[0].Requests)
+	assert.Equal(t, uint64(2), s[0].Windows[0].Bad)
+	// 2 bad out of 9 against a budget of 10%
+	assert.InDelta(t, 2.22, s[0].Windows[0].BurnRate, 0.01)
+	assert.InDelta(t, -1.22, s[0].BudgetRemaining, 0.01)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, slo.BudgetBurning, alerts[0].Type)
+	assert.Equal(t, "login", alerts[0].Subject)
+
+	tracker.Evaluate(context.Background())
+	assert.Len(t, alerts, 1, "alerts are only emitted when the state changes")
+
+	for range 20 {
+		serve("POST", http.StatusOK, 0)
+	}
+	tracker.Evaluate(context.Background())
+	assert.False(t, tracker.Status()[0].Burning)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, slo.BudgetRecovered, alerts[1].Type)
+}
+
+// TestNew_Invalid tests that objectives without a name, a path prefix or a sensible target are rejected
+func TestNew_Invalid(t *testing.T) {
+	for _, o := range []slo.Objective{
+		{PathPrefix: "/auth/login", Target: 99},
+		{Name: "login", PathPrefix: "auth/login", Target: 99},
+		{Name: "login", PathPrefix: "/auth/login", Target: 100},
+	} {
+		_, err := slo.New(slo.Config{Objectives: []slo.Objective{o}}, nil)
+		assert.Error(t, err, o)
+	}
+	tracker, err := slo.New(slo.Config{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, tracker)
+}
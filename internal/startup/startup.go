@@ -0,0 +1,369 @@
+// Package startup collects the outcome of the gateway's startup checks:
+// configuration of every component, key material and reachability of the
+// backends. All failures are reported together, so an operator can fix a
+// broken config in one go, and a table of the enabled features is printed
+// once the gateway is ready to serve.
+package startup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/backend"
+	"github.com/andro-kes/gateway/internal/bot"
+	"github.com/andro-kes/gateway/internal/config"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/quota"
+	"github.com/andro-kes/gateway/internal/token"
+	"go.uber.org/zap"
+)
+
+// Error lists every failed startup check.
+type Error struct {
+	Errs []error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if len(e.Errs) == 1 {
+		b.WriteString("startup failed with 1 error:")
+	} else {
+		fmt.Fprintf(&b, "startup failed with %d errors:", len(e.Errs))
+	}
+	for _, err := range e.Errs {
+		b.WriteString("\n  - ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() []error {
+	return e.Errs
+}
+
+// Report accumulates check results and enabled features.
+type Report struct {
+	errs     []error
+	warnings []string
+	features []feature
+}
+
+type feature struct {
+	name    string
+	enabled bool
+	detail  string
+}
+
+// Check records err, if any, as a failure of component. It reports whether
+// the check passed.
+func (r *Report) Check(component string, err error) bool {
+	if err == nil {
+		return true
+	}
+	r.errs = append(r.errs, fmt.Errorf("%s: %w", component, err))
+	return false
+}
+
+// Warn records and logs a problem that does not prevent startup.
+func (r *Report) Warn(component, msg string) {
+	r.warnings = append(r.warnings, component+": "+msg)
+	logger.Logger().Warn(msg, zap.String("component", component))
+}
+
+// Err returns an *Error listing every failed check, or nil.
+func (r *Report) Err() error {
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return &Error{Errs: r.errs}
+}
+
+// Must panics with the report's error if any check failed. It is called
+// before the components that depend on the checked ones are wired up.
+func (r *Report) Must() {
+	if err := r.Err(); err != nil {
+		panic(err)
+	}
+}
+
+// Feature adds a row to the table of features.
+func (r *Report) Feature(name string, enabled bool, detail string) {
+	r.features = append(r.features, feature{name: name, enabled: enabled, detail: detail})
+}
+
+// Enabled returns the names of the enabled features, in table order.
+func (r *Report) Enabled() []string {
+	var names []string
+	for _, f := range r.features {
+		if f.enabled {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// CheckJWT records the outcome of loading the access token keys and any
+// weaknesses of the key material.
+func (r *Report) CheckJWT(cfg token.Config, v *token.Verifier, err error) {
+	if !r.Check("auth.jwt", err) {
+		return
+	}
+	if v == nil {
+		r.Warn("auth.jwt", "No JWT key configured: access token signatures are not verified and identity metadata is not propagated")
+	}
+	for _, w := range cfg.Warnings() {
+		r.Warn("auth.jwt", w)
+	}
+}
+
+// ProbeBackends checks that every backend is reachable within the
+// configured timeout. Unreachable backends fail startup unless cfg allows
+// them. Backends named in skip are not checked.
+func (r *Report) ProbeBackends(m *backend.Manager, cfg config.StartupConfig, skip ...string) {
+	timeout := cfg.BackendTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	errs := m.Probe(ctx, skip...)
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if cfg.AllowUnreachableBackends {
+			r.Warn("backend "+name, "Backend unreachable at startup: "+errs[name].Error())
+			continue
+		}
+		r.Check("backend "+name, errs[name])
+	}
+}
+
+// Describe adds the features configured in cfg to the table. m may be nil
+// when the backends failed to start.
+func (r *Report) Describe(cfg *config.Config, m *backend.Manager, v *token.Verifier) {
+	if m != nil {
+		for _, s := range m.Status() {
+			detail := s.Address
+			bc := cfg.Backends[s.Name]
+			if len(bc.Fallbacks) > 0 {
+				detail += ", fallbacks " + strings.Join(bc.Fallbacks, " ")
+			}
+			if bc.HealthCheck.Mode != "" {
+				detail += ", " + bc.HealthCheck.Mode + " health checks"
+			} else if len(bc.Fallbacks) > 0 {
+				detail += ", " + backend.HealthTCP + " health checks"
+			}
+			r.Feature("backend "+s.Name, true, detail)
+		}
+	}
+	keys := ""
+	if v != nil {
+		keys = v.Describe()
+	}
+	r.Feature("jwt verification", v != nil, keys)
+	r.Feature("identity metadata", !cfg.Auth.DisableIdentityMetadata, "")
+	personalization := count(len(cfg.Auth.Personalization.Claims), "claim")
+	if personalization != "" && cfg.Auth.Personalization.Lookup.Enabled {
+		personalization += ", profile lookup"
+	}
+	r.Feature("personalization", len(cfg.Auth.Personalization.Claims) > 0, personalization)
+	r.Feature("cookie encryption", len(cfg.Auth.Cookies.EncryptionKeys) > 0, count(len(cfg.Auth.Cookies.EncryptionKeys), "key"))
+	rotation := cfg.Auth.RefreshRotation
+	rotationStore := ""
+	if rotation.Enabled {
+		rotationStore = "memory store"
+		if rotation.Redis.Addr != "" {
+			rotationStore = "redis store " + rotation.Redis.Addr
+		}
+	}
+	r.Feature("refresh token reuse detection", rotation.Enabled, rotationStore)
+	r.Feature("admin api", cfg.Admin.Token != "", "")
+	r.Feature("user administration", cfg.Admin.Users.Enabled, "")
+	r.Feature("maintenance mode", cfg.Maintenance.Enabled, "")
+	r.Feature("concurrency limits", cfg.Concurrency.MaxInFlight > 0 || len(cfg.Concurrency.Backends) > 0, count(len(cfg.Concurrency.Backends), "backend limit"))
+	r.Feature("load shedding", cfg.Overload.TargetP99 > 0 || cfg.Overload.MaxInFlight > 0 || cfg.Overload.MaxCPU > 0, "")
+	r.Feature("request queue", cfg.Queue.MaxInFlight > 0, maxInFlight(cfg.Queue.MaxInFlight))
+	r.Feature("events", cfg.Events.Driver != "", cfg.Events.Driver)
+	r.Feature("webhooks", true, count(len(cfg.Webhooks.Endpoints), "configured endpoint"))
+	jobStore := "memory store"
+	if cfg.Jobs.Redis.Addr != "" {
+		jobStore = "redis store " + cfg.Jobs.Redis.Addr
+	}
+	r.Feature("background jobs", true, jobStore)
+	quotaStore := ""
+	quotas := cfg.Quotas.Limits != (quota.Limits{}) || len(cfg.Quotas.Clients) > 0
+	if quotas {
+		quotaStore = "memory store"
+		if cfg.Quotas.Redis.Addr != "" {
+			quotaStore = "redis store " + cfg.Quotas.Redis.Addr
+		}
+	}
+	r.Feature("quotas", quotas, quotaStore)
+	_, notifications := cfg.Backends[backend.Notifications]
+	r.Feature("notifications", notifications, "")
+	metadataBackends := 0
+	for _, bc := range cfg.Backends {
+		if len(bc.Metadata) > 0 {
+			metadataBackends++
+		}
+	}
+	var metadataDetail []string
+	for _, c := range []string{count(len(cfg.GRPCClient.Labels), "label"), count(metadataBackends, "backend")} {
+		if c != "" {
+			metadataDetail = append(metadataDetail, c)
+		}
+	}
+	r.Feature("backend metadata", len(metadataDetail) > 0, strings.Join(metadataDetail, ", "))
+	r.Feature("declared routes", len(cfg.Routes) > 0, count(len(cfg.Routes), "route"))
+	r.Feature("rpc passthrough", len(cfg.RPC.Allow) > 0, count(len(cfg.RPC.Allow), "backend"))
+	r.Feature("schema cache", len(cfg.Schema.Backends) > 0 || len(cfg.RPC.Allow) > 0, "")
+	r.Feature("canary routing", len(cfg.Canary) > 0, count(len(cfg.Canary), "rule"))
+	r.Feature("traffic mirroring", cfg.Mirror.Address != "" && (cfg.Mirror.Percent == nil || *cfg.Mirror.Percent != 0), cfg.Mirror.Address)
+	r.Feature("request filters", len(cfg.Filters) > 0, count(len(cfg.Filters), "filter"))
+	r.Feature("decimal prices", cfg.Money.DecimalPrices, cfg.Money.Currency)
+	r.Feature("protobuf passthrough", cfg.ProtobufPassthrough, "")
+	r.Feature("signed cursors", cfg.Pagination.Secret != "", "")
+	fixtures := ""
+	if cfg.Fixtures.Mode != "" {
+		fixtures = cfg.Fixtures.Mode
+		if cfg.Fixtures.Dir != "" {
+			fixtures += " " + cfg.Fixtures.Dir
+		}
+	}
+	r.Feature("fixtures", cfg.Fixtures.Mode != "", fixtures)
+	accessLog := cfg.AccessLog.Format
+	if accessLog == "" {
+		accessLog = "json"
+	}
+	r.Feature("access log", accessLog != "off", accessLog)
+	r.Feature("otlp metrics", cfg.Metrics.Exporter == metrics.OTLP || cfg.Metrics.Exporter == metrics.Both, cfg.Metrics.OTLP.Endpoint)
+	envelopeDetail := count(len(cfg.ResponseEnvelope.Routes), "route")
+	if cfg.ResponseEnvelope.Enabled {
+		envelopeDetail = "all routes"
+	} else if envelopeDetail == "" {
+		envelopeDetail = "on request"
+	}
+	r.Feature("response envelope", cfg.ResponseEnvelope.Enabled || len(cfg.ResponseEnvelope.Routes) > 0 || cfg.ResponseEnvelope.Header != "", envelopeDetail)
+	r.Feature("message catalogs", len(cfg.I18n.Catalogs) > 0, count(len(cfg.I18n.Catalogs), "file"))
+	r.Feature("feature flags", len(cfg.Features.Flags) > 0, count(len(cfg.Features.Flags), "flag"))
+	discovery := cfg.Auth.Discovery
+	discoverySource := ""
+	if discovery.Enabled {
+		discoverySource = "generated from the jwt public key"
+		if discovery.Upstream != "" {
+			discoverySource = "proxied from " + discovery.Upstream
+		}
+	}
+	r.Feature("account endpoints", cfg.Auth.Account.Enabled, "")
+	r.Feature("oidc discovery", discovery.Enabled, discoverySource)
+	mfaDetail := ""
+	if cfg.Auth.MFA.Enabled && cfg.Auth.MFA.ChallengeSecret == "" {
+		mfaDetail = "random challenge secret, challenges only work on this instance"
+	}
+	r.Feature("mfa", cfg.Auth.MFA.Enabled, mfaDetail)
+	r.Feature("magic links", cfg.Auth.MagicLink.Enabled, cfg.Auth.MagicLink.URL)
+	r.Feature("client certificates", len(cfg.Auth.ClientCerts.Identities) > 0, count(len(cfg.Auth.ClientCerts.Identities), "caller"))
+	var backendAuth []string
+	switch {
+	case cfg.BackendAuth.Token != "":
+		backendAuth = append(backendAuth, "static token")
+	case cfg.BackendAuth.OAuth.TokenURL != "":
+		backendAuth = append(backendAuth, "oauth "+cfg.BackendAuth.OAuth.TokenURL)
+	}
+	if cfg.BackendAuth.TLS.Enabled() {
+		transport := "tls"
+		if cfg.BackendAuth.TLS.CertFile != "" || cfg.BackendAuth.TLS.Cert != "" {
+			transport = "mtls"
+		}
+		backendAuth = append(backendAuth, transport)
+	}
+	r.Feature("service credential", len(backendAuth) > 0, strings.Join(backendAuth, ", "))
+	r.Feature("request signatures", len(cfg.Auth.Signatures.Keys) > 0, count(len(cfg.Auth.Signatures.Keys), "key"))
+	r.Feature("fault injection", cfg.Faults.Allow, count(len(cfg.Faults.Rules), "rule"))
+	r.Feature("response cache", len(cfg.ResponseCache.Rules) > 0, count(len(cfg.ResponseCache.Rules), "route"))
+	var sampling []string
+	if t := cfg.Tracing; t.Percent != 0 || t.Errors || t.SlowerThan > 0 || len(t.Routes) > 0 || t.IgnoreUpstream {
+		sampling = append(sampling, fmt.Sprintf("%g%%", t.Percent))
+		if t.Errors {
+			sampling = append(sampling, "errors")
+		}
+		if t.SlowerThan > 0 {
+			sampling = append(sampling, "slower than "+t.SlowerThan.String())
+		}
+		if len(t.Routes) > 0 {
+			sampling = append(sampling, count(len(t.Routes), "route rule"))
+		}
+		if t.IgnoreUpstream {
+			sampling = append(sampling, "ignoring upstream decisions")
+		}
+	}
+	r.Feature("trace sampling", len(sampling) > 0, strings.Join(sampling, ", "))
+	var botRules []string
+	for name, action := range map[string]string{
+		bot.MissingHeaders: cfg.Bots.MissingHeaders.Action,
+		bot.BadUserAgent:   cfg.Bots.BadUserAgents.Action,
+		bot.Honeypot:       cfg.Bots.Honeypot.Action,
+		bot.Timing:         cfg.Bots.Timing.Action,
+	} {
+		if action != "" {
+			botRules = append(botRules, name+" ("+action+")")
+		}
+	}
+	sort.Strings(botRules)
+	r.Feature("bot detection", cfg.Bots.Enabled(), strings.Join(botRules, ", "))
+	r.Feature("slo tracking", len(cfg.SLO.Objectives) > 0, count(len(cfg.SLO.Objectives), "objective"))
+	secretRefs := count(len(cfg.Secrets.Refs), "reference")
+	if len(cfg.Secrets.Refs) > 0 && cfg.Secrets.Refresh > 0 {
+		secretRefs += ", refreshed every " + cfg.Secrets.Refresh.String()
+	}
+	r.Feature("secret store", len(cfg.Secrets.Refs) > 0, secretRefs)
+}
+
+func count(n int, noun string) string {
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return "1 " + noun
+	default:
+		return fmt.Sprintf("%d %ss", n, noun)
+	}
+}
+
+func maxInFlight(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("max %d in flight", n)
+}
+
+// Print writes the table of features and the warnings to w.
+func (r *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FEATURE\tSTATUS\tDETAIL")
+	for _, f := range r.features {
+		status := "off"
+		if f.enabled {
+			status = "on"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.name, status, f.detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, warning := range r.warnings {
+		if _, err := fmt.Fprintln(w, "warning: "+warning); err != nil {
+			return err
+		}
+	}
+	return nil
+}
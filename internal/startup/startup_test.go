@@ -0,0 +1,51 @@
+package startup_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/config"
+	"github.com/andro-kes/gateway/internal/startup"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReport_Errors tests that every failed check is listed in one error
+func TestReport_Errors(t *testing.T) {
+	var r startup.Report
+	assert.True(t, r.Check("real_ip", nil))
+	assert.NoError(t, r.Err())
+	assert.NotPanics(t, r.Must)
+
+	errBadPrefix := errors.New(`path prefix "x" must start with /`)
+	assert.False(t, r.Check("cache_control", errBadPrefix))
+	r.Check("queue", errors.New(`unknown priority class "urgent"`))
+
+	err := r.Err()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errBadPrefix)
+	assert.Equal(t, "startup failed with 2 errors:\n"+
+		"  - cache_control: path prefix \"x\" must start with /\n"+
+		"  - queue: unknown priority class \"urgent\"", err.Error())
+	assert.PanicsWithError(t, err.Error(), r.Must)
+}
+
+// TestReport_Print tests the feature table and warnings
+func TestReport_Print(t *testing.T) {
+	var r startup.Report
+	cfg := &config.Config{}
+	cfg.Admin.Token = "admin"
+	cfg.Events.Driver = "nats"
+	r.Describe(cfg, nil, nil)
+	r.Warn("pagination", "No pagination secret configured")
+
+	var out bytes.Buffer
+	require.NoError(t, r.Print(&out))
+	s := out.String()
+	assert.Contains(t, s, "FEATURE")
+	assert.Regexp(t, `(?m)^admin api\s+on\s*$`, s)
+	assert.Regexp(t, `(?m)^events\s+on\s+nats$`, s)
+	assert.Regexp(t, `(?m)^jwt verification\s+off\s*$`, s)
+	assert.Contains(t, s, "warning: pagination: No pagination secret configured\n")
+}
@@ -0,0 +1,80 @@
+package syslog_test
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/syslog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWriter_TCPFraming tests RFC 5424 messages with octet counting over TCP
+func TestWriter_TCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	w, err := syslog.New(syslog.Config{Network: "tcp", Address: ln.Addr().String(), AppName: "gw", Facility: "daemon"})
+	require.NoError(t, err)
+	defer w.Close()
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, w.Send(syslog.Warning, "first"))
+	_, err = w.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"<28>1 ", "<30>1 "} {
+		lenStr, err := r.ReadString(' ')
+		require.NoError(t, err)
+		n, err := strconv.Atoi(strings.TrimSpace(lenStr))
+		require.NoError(t, err)
+		buf := make([]byte, n)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(buf), want), string(buf))
+		assert.Contains(t, string(buf), " gw ")
+	}
+}
+
+// TestNew_Invalid tests configuration errors
+func TestNew_Invalid(t *testing.T) {
+	_, err := syslog.New(syslog.Config{Network: "sctp", Address: "localhost:514"})
+	assert.Error(t, err)
+	_, err = syslog.New(syslog.Config{Network: "udp", Address: "localhost:514", Facility: "local9"})
+	assert.Error(t, err)
+}
+
+// TestWriter_SendData tests structured data encoding
+func TestWriter_SendData(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	w, err := syslog.New(syslog.Config{Address: conn.LocalAddr().String()})
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, w.SendData(syslog.Error, map[string]string{
+		"trace_id":   "abc",
+		"error":      `bad "quote" ] \`,
+		"client ip=": "10.0.0.1",
+	}, "call failed"))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Regexp(t, `^<131>1 \S+ \S+ gateway \d+ - `, string(buf[:n]))
+	assert.True(t, strings.HasSuffix(string(buf[:n]),
+		` - [fields@32473 client_ip_="10.0.0.1" error="bad \"quote\" \] \\" trace_id="abc"] call failed`), string(buf[:n]))
+}
@@ -0,0 +1,100 @@
+// Package systemd implements the parts of the systemd service protocol the
+// gateway uses: socket activation (LISTEN_FDS) and readiness notification
+// (sd_notify).
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// Listener is a socket inherited from systemd.
+type Listener struct {
+	// Name is the FileDescriptorName= of the socket unit, or the unit name.
+	Name string
+	net.Listener
+}
+
+var (
+	inheritOnce sync.Once
+	inherited   []Listener
+	inheritErr  error
+)
+
+// Listeners returns the stream sockets passed by systemd socket activation,
+// in the order of the socket unit. The environment variables are consumed on
+// the first call so child processes do not inherit them.
+func Listeners() ([]Listener, error) {
+	inheritOnce.Do(func() {
+		inherited, inheritErr = listeners()
+	})
+	return inherited, inheritErr
+}
+
+func listeners() ([]Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	out := make([]Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		// FileListener dups the descriptor (close-on-exec); drop the original
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited fd %d (%s) is not a stream socket: %w", fd, name, err)
+		}
+		out = append(out, Listener{Name: name, Listener: ln})
+	}
+	return out, nil
+}
+
+// Notify sends a state string such as "READY=1" to the service manager. It
+// reports false without error when the process is not run by systemd.
+func Notify(state string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+	if addr[0] == '@' {
+		// abstract namespace socket
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
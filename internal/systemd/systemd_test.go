@@ -0,0 +1,81 @@
+package systemd_test
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/andro-kes/gateway/internal/systemd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNotify tests that states are written to NOTIFY_SOCKET
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	sent, err := systemd.Notify("READY=1")
+	require.NoError(t, err)
+	assert.True(t, sent)
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+// TestNotify_NotSystemd tests that Notify is a no-op outside systemd
+func TestNotify_NotSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := systemd.Notify("READY=1")
+	assert.NoError(t, err)
+	assert.False(t, sent)
+}
+
+// TestListeners tests socket activation by re-running the test binary with
+// a listening socket on fd 3, as systemd would pass it
+func TestListeners(t *testing.T) {
+	if os.Getenv("SYSTEMD_TEST_CHILD") == "1" {
+		// systemd sets LISTEN_PID to the activated process itself
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		lns, err := systemd.Listeners()
+		require.NoError(t, err)
+		require.Len(t, lns, 1)
+		assert.Equal(t, "http", lns[0].Name)
+		assert.Empty(t, os.Getenv("LISTEN_FDS"))
+
+		conn, err := lns[0].Accept()
+		require.NoError(t, err)
+		conn.Write([]byte("ok"))
+		conn.Close()
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListeners$")
+	cmd.Env = append(os.Environ(), "SYSTEMD_TEST_CHILD=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=http")
+	cmd.ExtraFiles = []*os.File{f}
+	require.NoError(t, cmd.Start())
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = conn.Read(buf)
+	conn.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(buf))
+	require.NoError(t, cmd.Wait())
+}
@@ -0,0 +1,110 @@
+package timing
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/tracing"
+	"go.uber.org/zap"
+)
+
+// Config configures the latency budgets.
+type Config struct {
+	// Default is the budget of requests no rule matches. Zero means
+	// unlimited.
+	Default time.Duration `yaml:"default"`
+
+	// Rules are tried in order; the first matching one applies.
+	Rules []BudgetRule `yaml:"rules"`
+}
+
+// BudgetRule is the latency budget of a group of routes.
+type BudgetRule struct {
+	// PathPrefix selects the routes the rule applies to, e.g. "/inventory/list".
+	PathPrefix string `yaml:"path_prefix"`
+
+	// Methods restricts the rule to these request methods. Empty means all.
+	Methods []string `yaml:"methods"`
+
+	// Budget is how long matching requests may take. Zero exempts them.
+	Budget time.Duration `yaml:"budget"`
+}
+
+// Budgets logs requests that exceed the latency budget of their route.
+type Budgets struct {
+	def   time.Duration
+	rules []BudgetRule
+}
+
+// NewBudgets validates cfg and returns its Budgets.
+func NewBudgets(cfg Config) (*Budgets, error) {
+	if cfg.Default < 0 {
+		return nil, fmt.Errorf("default latency budget must not be negative")
+	}
+	b := &Budgets{def: cfg.Default}
+	for _, r := range cfg.Rules {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return nil, fmt.Errorf("latency budget path prefix %q must start with /", r.PathPrefix)
+		}
+		if r.Budget < 0 {
+			return nil, fmt.Errorf("latency budget for %q must not be negative", r.PathPrefix)
+		}
+		methods := make([]string, len(r.Methods))
+		for i, m := range r.Methods {
+			methods[i] = strings.ToUpper(m)
+		}
+		r.Methods = methods
+		b.rules = append(b.rules, r)
+	}
+	return b, nil
+}
+
+// Budget returns the latency budget of r, zero if unlimited.
+func (b *Budgets) Budget(r *http.Request) time.Duration {
+	for _, rule := range b.rules {
+		if !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			continue
+		}
+		if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, r.Method) {
+			continue
+		}
+		return rule.Budget
+	}
+	return b.def
+}
+
+// Middleware carries Timings in the request context and logs requests that
+// take longer than their budget at warn level, with the time per segment.
+func (b *Budgets) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, t := WithTimings(r.Context())
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		budget := b.Budget(r)
+		total := t.Total()
+		if budget <= 0 || total <= budget {
+			return
+		}
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Duration("duration", total),
+			zap.Duration("budget", budget),
+		}
+		for _, s := range t.Breakdown(total) {
+			fields = append(fields, zap.Duration(s.Name, s.Duration))
+		}
+		if sc, ok := tracing.FromContext(ctx); ok {
+			fields = append(fields, zap.String("trace_id", sc.TraceIDString()))
+		}
+		if id, ok := requestid.FromContext(ctx); ok {
+			fields = append(fields, zap.String("request_id", id))
+		}
+		logger.Logger().Warn("Request over latency budget", fields...)
+	})
+}
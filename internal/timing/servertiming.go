@@ -0,0 +1,91 @@
+package timing
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerTimingConfig configures the Server-Timing response header.
+type ServerTimingConfig struct {
+	// Enabled adds a Server-Timing header to every response, so browser
+	// devtools show where the gateway spent its time.
+	Enabled bool `yaml:"enabled"`
+
+	// AllowOrigin is sent as Timing-Allow-Origin, letting scripts of these
+	// origins read the timings too, e.g. "*" or "https://shop.example.com".
+	AllowOrigin string `yaml:"allow_origin"`
+}
+
+// ServerTiming returns middleware adding a Server-Timing header with the
+// time spent per segment, and in total, up to when the response headers are
+// written. Handlers encode responses before writing them, so the encode
+// segment is complete; streamed responses only report what came before the
+// first line.
+func ServerTiming(cfg ServerTimingConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			t := FromContext(ctx)
+			if t == nil {
+				ctx, t = WithTimings(ctx)
+				r = r.WithContext(ctx)
+			}
+			next.ServeHTTP(&timingWriter{ResponseWriter: w, timings: t, allowOrigin: cfg.AllowOrigin}, r)
+		})
+	}
+}
+
+// timingWriter sets the Server-Timing header just before the headers go out.
+type timingWriter struct {
+	http.ResponseWriter
+	timings     *Timings
+	allowOrigin string
+	written     bool
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.setHeader()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *timingWriter) setHeader() {
+	if w.written {
+		return
+	}
+	w.written = true
+	total := w.timings.Total()
+	var b strings.Builder
+	for _, s := range w.timings.Breakdown(total) {
+		writeMetric(&b, s.Name, s.Duration)
+	}
+	writeMetric(&b, "total", total)
+	w.Header().Add("Server-Timing", b.String())
+	if w.allowOrigin != "" {
+		w.Header().Set("Timing-Allow-Origin", w.allowOrigin)
+	}
+}
+
+// writeMetric appends a Server-Timing metric, with its duration in
+// milliseconds.
+func writeMetric(b *strings.Builder, name string, d time.Duration) {
+	if b.Len() > 0 {
+		b.WriteString(", ")
+	}
+	b.WriteString(name)
+	b.WriteString(";dur=")
+	b.WriteString(strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64))
+}
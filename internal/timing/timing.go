@@ -0,0 +1,109 @@
+// Package timing breaks the time of a request down by where it went: token
+// verification, backend calls and response encoding are recorded in a
+// Timings carried by the request context, and the rest is the gateway's own
+// middleware. Requests slower than the latency budget of their route are
+// logged with the breakdown, which can also be sent to clients in a
+// Server-Timing header.
+package timing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Segments recorded by the gateway.
+const (
+	// Auth is the time spent verifying access tokens.
+	Auth = "auth"
+	// Backend is the time spent in backend calls, retries and coalesced
+	// waits included.
+	Backend = "backend"
+	// Encode is the time spent transforming and encoding responses.
+	Encode = "encode"
+	// Middleware is the rest of the request: everything not recorded in
+	// another segment.
+	Middleware = "middleware"
+)
+
+// Timings accumulates the time a request spends per segment. Concurrent
+// calls, e.g. the sub-requests of a batch, add up, so segments can exceed
+// the wall time of the request. A nil *Timings ignores everything.
+type Timings struct {
+	start time.Time
+
+	mu    sync.Mutex
+	spent map[string]time.Duration
+	order []string
+}
+
+type timingsKey struct{}
+
+// WithTimings returns a copy of ctx carrying new Timings started now.
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{start: time.Now(), spent: map[string]time.Duration{}}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// FromContext returns the Timings of ctx, or nil.
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+// Add records d in segment.
+func (t *Timings) Add(segment string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.spent[segment]; !ok {
+		t.order = append(t.order, segment)
+	}
+	t.spent[segment] += d
+}
+
+// Start starts timing segment for the Timings of ctx and returns the
+// function that stops it:
+//
+//	defer timing.Start(ctx, timing.Encode)()
+func Start(ctx context.Context, segment string) func() {
+	t := FromContext(ctx)
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { t.Add(segment, time.Since(start)) }
+}
+
+// Segment is the time spent in one segment.
+type Segment struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Total returns the time since the Timings started.
+func (t *Timings) Total() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Since(t.start)
+}
+
+// Breakdown returns the recorded segments in the order first recorded,
+// followed by Middleware, the part of total they do not cover.
+func (t *Timings) Breakdown(total time.Duration) []Segment {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	segments := make([]Segment, 0, len(t.order)+1)
+	rest := total
+	for _, name := range t.order {
+		segments = append(segments, Segment{Name: name, Duration: t.spent[name]})
+		rest -= t.spent[name]
+	}
+	return append(segments, Segment{Name: Middleware, Duration: max(rest, 0)})
+}
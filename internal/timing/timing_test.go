@@ -0,0 +1,105 @@
+package timing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/timing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBreakdown tests that segments add up and the rest is middleware
+func TestBreakdown(t *testing.T) {
+	ctx, tm := timing.WithTimings(context.Background())
+	tm.Add(timing.Backend, 30*time.Millisecond)
+	tm.Add(timing.Encode, 5*time.Millisecond)
+	tm.Add(timing.Backend, 20*time.Millisecond)
+	assert.Same(t, tm, timing.FromContext(ctx))
+
+	assert.Equal(t, []timing.Segment{
+		{Name: timing.Backend, Duration: 50 * time.Millisecond},
+		{Name: timing.Encode, Duration: 5 * time.Millisecond},
+		{Name: timing.Middleware, Duration: 45 * time.Millisecond},
+	}, tm.Breakdown(100*time.Millisecond))
+
+	// without timings in the context nothing is recorded
+	timing.Start(context.Background(), timing.Backend)()
+	assert.Nil(t, timing.FromContext(context.Background()).Breakdown(time.Second))
+}
+
+// TestBudgets_Rules tests budget selection by path prefix and method
+func TestBudgets_Rules(t *testing.T) {
+	b, err := timing.NewBudgets(timing.Config{
+		Default: time.Second,
+		Rules: []timing.BudgetRule{
+			{PathPrefix: "/inventory/list", Methods: []string{"post"}, Budget: 300 * time.Millisecond},
+			{PathPrefix: "/inventory/products/import", Budget: 0},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 300*time.Millisecond, b.Budget(httptest.NewRequest(http.MethodPost, "/inventory/list", nil)))
+	assert.Equal(t, time.Second, b.Budget(httptest.NewRequest(http.MethodGet, "/inventory/list", nil)))
+	assert.Zero(t, b.Budget(httptest.NewRequest(http.MethodPost, "/inventory/products/import", nil)))
+
+	_, err = timing.NewBudgets(timing.Config{Rules: []timing.BudgetRule{{PathPrefix: "inventory"}}})
+	assert.Error(t, err)
+}
+
+// TestBudgets_LogsSlowRequests tests that requests over budget are logged with their breakdown
+func TestBudgets_LogsSlowRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	require.NoError(t, logger.Init(logger.Config{Level: "info", OutputPaths: []string{path}}))
+	t.Cleanup(func() { logger.Init(logger.Config{Level: "info"}) })
+
+	b, err := timing.NewBudgets(timing.Config{Rules: []timing.BudgetRule{{PathPrefix: "/slow", Budget: 10 * time.Millisecond}}})
+	require.NoError(t, err)
+	h := b.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stop := timing.Start(r.Context(), timing.Backend)
+		time.Sleep(20 * time.Millisecond)
+		stop()
+	}))
+
+	for _, target := range []string{"/fast", "/slow"} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r = r.WithContext(requestid.WithID(r.Context(), "req"+target))
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	out := string(data)
+	assert.Contains(t, out, `"msg":"Request over latency budget"`)
+	assert.Contains(t, out, `"request_id":"req/slow"`)
+	assert.Contains(t, out, `"budget":0.01`)
+	assert.Contains(t, out, `"backend":0.02`)
+	assert.Contains(t, out, `"middleware":`)
+	assert.NotContains(t, out, "req/fast")
+}
+
+// TestServerTiming tests the Server-Timing header and that it is off by default
+func TestServerTiming(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timing.FromContext(r.Context()).Add(timing.Auth, 2*time.Millisecond)
+		timing.FromContext(r.Context()).Add(timing.Backend, 10*time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+
+	w := httptest.NewRecorder()
+	timing.ServerTiming(timing.ServerTimingConfig{Enabled: true, AllowOrigin: "*"})(handler).
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	header := w.Header().Get("Server-Timing")
+	assert.Regexp(t, `^auth;dur=2\.000, backend;dur=10\.000, middleware;dur=\d+\.\d{3}, total;dur=\d+\.\d{3}$`, header)
+	assert.Equal(t, "*", w.Header().Get("Timing-Allow-Origin"))
+
+	w = httptest.NewRecorder()
+	timing.ServerTiming(timing.ServerTimingConfig{})(handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, w.Header().Get("Server-Timing"))
+}
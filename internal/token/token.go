@@ -0,0 +1,186 @@
+// Package token parses and verifies the JWT access tokens issued by
+// auth_service.
+package token
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Claims are the access token claims the gateway cares about.
+type Claims struct {
+	// UserID is the "uid" claim, falling back to "sub".
+	UserID    string
+	Type      string
+	ID        string
+	Roles     []string
+	ExpiresAt time.Time
+	IssuedAt  time.Time
+	NotBefore time.Time
+	Issuer    string
+	Audience  []string
+
+	// AuthTime is the "auth_time" claim: when the user last entered their
+	// credentials, as opposed to when the token was refreshed. Zero if absent.
+	AuthTime time.Time
+
+	// Verified is true when the signature was checked by a Verifier.
+	Verified bool
+
+	// Payload is the decoded payload, for claims without a field of their
+	// own, e.g. "locale". Numbers are json.Number.
+	Payload map[string]any
+}
+
+// HasRole reports whether the claims carry any of roles.
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, have := range c.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Expired reports whether the token has expired at now.
+func (c *Claims) Expired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
+
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrNoExpiry  = errors.New("exp not present")
+)
+
+// Parse decodes the token payload without verifying the signature.
+func Parse(raw string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) < 2 {
+		return nil, ErrMalformed
+	}
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]any
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	c := &Claims{
+		UserID:  stringClaim(m, "uid"),
+		Type:    stringClaim(m, "typ"),
+		ID:      stringClaim(m, "jti"),
+		Roles:   rolesClaim(m),
+		Issuer:  stringClaim(m, "iss"),
+		Payload: m,
+	}
+	if c.UserID == "" {
+		c.UserID = stringClaim(m, "sub")
+	}
+	switch aud := m["aud"].(type) {
+	case string:
+		c.Audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				c.Audience = append(c.Audience, s)
+			}
+		}
+	}
+
+	exp, ok, err := timeClaim(m, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNoExpiry
+	}
+	c.ExpiresAt = exp
+	if c.IssuedAt, _, err = timeClaim(m, "iat"); err != nil {
+		return nil, err
+	}
+	if c.NotBefore, _, err = timeClaim(m, "nbf"); err != nil {
+		return nil, err
+	}
+	if c.AuthTime, _, err = timeClaim(m, "auth_time"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func decodeSegment(seg string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		// try standard base64 if padding present
+		raw, err = base64.StdEncoding.DecodeString(seg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+func stringClaim(m map[string]any, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+func timeClaim(m map[string]any, key string) (time.Time, bool, error) {
+	v, ok := m[key]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("invalid %s type", key)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.Unix(int64(f), 0), true, nil
+}
+
+// rolesClaim accepts "roles" as an array or a single string, or "role".
+func rolesClaim(m map[string]any) []string {
+	var roles []string
+	switch v := m["roles"].(type) {
+	case []any:
+		for _, r := range v {
+			if s, ok := r.(string); ok && s != "" {
+				roles = append(roles, s)
+			}
+		}
+	case string:
+		roles = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+	if r := stringClaim(m, "role"); r != "" {
+		roles = append(roles, r)
+	}
+	return roles
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx carrying the caller's claims.
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// FromContext returns the claims stored in ctx, if any.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok && c != nil
+}
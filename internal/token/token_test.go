@@ -0,0 +1,204 @@
+package token_test
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func encode(v any) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signHS256(claims map[string]any, key string) string {
+	signed := encode(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encode(claims)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TestParse_Claims tests decoding of the claims issued by auth_service
+func TestParse_Claims(t *testing.T) {
+	exp := time.Now().Add(time.Minute).Unix()
+	raw := signHS256(map[string]any{
+		"uid":   "user-1",
+		"typ":   "access",
+		"jti":   "abc",
+		"exp":   exp,
+		"roles": []string{"admin", "inventory:write"},
+	}, secret)
+
+	c, err := token.Parse(raw)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", c.UserID)
+	assert.Equal(t, "access", c.Type)
+	assert.Equal(t, []string{"admin", "inventory:write"}, c.Roles)
+	assert.Equal(t, exp, c.ExpiresAt.Unix())
+	assert.False(t, c.Verified)
+	assert.False(t, c.Expired(time.Now()))
+}
+
+// TestParse_Errors tests malformed tokens
+func TestParse_Errors(t *testing.T) {
+	_, err := token.Parse("not-a-jwt")
+	assert.ErrorIs(t, err, token.ErrMalformed)
+
+	_, err = token.Parse(signHS256(map[string]any{"uid": "user-1"}, secret))
+	assert.ErrorIs(t, err, token.ErrNoExpiry)
+
+	_, err = token.Parse(signHS256(map[string]any{"exp": "tomorrow"}, secret))
+	assert.Error(t, err)
+}
+
+// TestVerifier_HMAC tests accepting genuine and rejecting forged HS256 tokens
+func TestVerifier_HMAC(t *testing.T) {
+	v, err := token.NewVerifier(token.Config{HMACSecret: secret})
+	require.NoError(t, err)
+
+	claims := map[string]any{"uid": "user-1", "exp": time.Now().Add(time.Minute).Unix()}
+
+	c, err := v.Verify(signHS256(claims, secret))
+	require.NoError(t, err)
+	assert.True(t, c.Verified)
+	assert.Equal(t, "user-1", c.UserID)
+
+	_, err = v.Verify(signHS256(claims, "another-secret-another-secret-xx"))
+	assert.ErrorIs(t, err, token.ErrSignature)
+
+	unsigned := encode(map[string]string{"alg": "none"}) + "." + encode(claims) + "."
+	_, err = v.Verify(unsigned)
+	assert.Error(t, err)
+}
+
+// TestVerifier_RSA tests RS256 verification with a PEM public key file
+func TestVerifier_RSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	v, err := token.NewVerifier(token.Config{PublicKeyFile: path})
+	require.NoError(t, err)
+
+	signed := encode(map[string]string{"alg": "RS256"}) + "." + encode(map[string]any{"sub": "user-2", "exp": time.Now().Add(time.Minute).Unix()})
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	c, err := v.Verify(signed + "." + base64.RawURLEncoding.EncodeToString(sig))
+	require.NoError(t, err)
+	assert.Equal(t, "user-2", c.UserID)
+
+	// an HS256 token must not be accepted when only an RSA key is configured
+	_, err = v.Verify(signHS256(map[string]any{"exp": time.Now().Add(time.Minute).Unix()}, secret))
+	assert.Error(t, err)
+}
+
+// TestNewVerifier_KeyStrength tests that short RSA keys are rejected and short HMAC secrets flagged
+func TestNewVerifier_KeyStrength(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	_, err = token.NewVerifier(token.Config{PublicKeyFile: path})
+	assert.ErrorContains(t, err, "1024-bit")
+
+	assert.Len(t, token.Config{HMACSecret: "short"}.Warnings(), 1)
+	assert.Empty(t, token.Config{HMACSecret: secret}.Warnings())
+
+	v, err := token.NewVerifier(token.Config{HMACSecret: secret})
+	require.NoError(t, err)
+	assert.Equal(t, "HMAC secret", v.Describe())
+}
+
+// TestNewVerifier_Disabled tests that no key material disables verification
+func TestNewVerifier_Disabled(t *testing.T) {
+	v, err := token.NewVerifier(token.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, v)
+}
+
+// TestClaims_HasRole tests role membership checks
+func TestClaims_HasRole(t *testing.T) {
+	c := &token.Claims{Roles: []string{"user", "inventory:write"}}
+	assert.True(t, c.HasRole("admin", "inventory:write"))
+	assert.False(t, c.HasRole("admin"))
+	assert.False(t, c.HasRole())
+}
+
+// TestValidator tests clock skew tolerance and the time, issuer and audience checks
+func TestValidator(t *testing.T) {
+	now := time.Now()
+	parse := func(claims map[string]any) *token.Claims {
+		t.Helper()
+		if _, ok := claims["exp"]; !ok {
+			claims["exp"] = now.Add(time.Minute).Unix()
+		}
+		c, err := token.Parse(signHS256(claims, secret))
+		require.NoError(t, err)
+		return c
+	}
+	v := token.NewValidator(token.Config{ClockSkew: 30 * time.Second, Issuer: "auth_service", Audience: "gateway"})
+	valid := map[string]any{"iss": "auth_service", "aud": []string{"gateway", "admin"}}
+
+	assert.NoError(t, v.Validate(parse(valid), now))
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   error
+	}{
+		{"expired within skew", map[string]any{"exp": now.Add(-20 * time.Second).Unix()}, nil},
+		{"expired", map[string]any{"exp": now.Add(-time.Minute).Unix()}, token.ErrExpired},
+		{"not yet valid within skew", map[string]any{"nbf": now.Add(20 * time.Second).Unix()}, nil},
+		{"not yet valid", map[string]any{"nbf": now.Add(time.Minute).Unix()}, token.ErrNotYetValid},
+		{"issued in the future", map[string]any{"iat": now.Add(time.Minute).Unix()}, token.ErrIssuedInFuture},
+		{"wrong issuer", map[string]any{"iss": "someone"}, token.ErrIssuer},
+		{"no audience", map[string]any{"aud": nil}, token.ErrAudience},
+		{"single audience", map[string]any{"aud": "gateway"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := map[string]any{}
+			for k, val := range valid {
+				claims[k] = val
+			}
+			for k, val := range tt.claims {
+				claims[k] = val
+			}
+			err := v.Validate(parse(claims), now)
+			if tt.want == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+
+	// without a validator only exp is checked, without tolerance
+	var none *token.Validator
+	assert.NoError(t, none.Validate(parse(map[string]any{"nbf": now.Add(time.Hour).Unix()}), now))
+	assert.ErrorIs(t, none.Validate(parse(map[string]any{"exp": now.Add(-time.Second).Unix()}), now), token.ErrExpired)
+}
@@ -0,0 +1,56 @@
+package token
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// Reasons a token with valid syntax and signature is refused.
+var (
+	ErrExpired        = errors.New("token expired")
+	ErrNotYetValid    = errors.New("token not valid yet")
+	ErrIssuedInFuture = errors.New("token issued in the future")
+	ErrIssuer         = errors.New("token issuer not accepted")
+	ErrAudience       = errors.New("token audience not accepted")
+)
+
+// Validator checks the time, issuer and audience claims of a token.
+type Validator struct {
+	skew     time.Duration
+	issuer   string
+	audience string
+}
+
+// NewValidator returns the validator of the claims rules in cfg.
+func NewValidator(cfg Config) *Validator {
+	return &Validator{skew: max(cfg.ClockSkew, 0), issuer: cfg.Issuer, audience: cfg.Audience}
+}
+
+// Validate checks c at now, tolerating the configured clock skew, and
+// returns one of the Err* reasons above. A nil Validator only checks the
+// expiry, without tolerance.
+func (v *Validator) Validate(c *Claims, now time.Time) error {
+	if v == nil {
+		if c.Expired(now) {
+			return ErrExpired
+		}
+		return nil
+	}
+	if c.Expired(now.Add(-v.skew)) {
+		return ErrExpired
+	}
+	if !c.NotBefore.IsZero() && now.Add(v.skew).Before(c.NotBefore) {
+		return ErrNotYetValid
+	}
+	if !c.IssuedAt.IsZero() && now.Add(v.skew).Before(c.IssuedAt) {
+		return ErrIssuedInFuture
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return ErrIssuer
+	}
+	if v.audience != "" && !slices.Contains(c.Audience, v.audience) {
+		return ErrAudience
+	}
+	return nil
+}
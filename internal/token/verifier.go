@@ -0,0 +1,235 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config holds the key material used to verify access token signatures,
+// and the rules the claims must satisfy. Signature verification is disabled
+// when neither key is set.
+type Config struct {
+	// HMACSecret is the shared secret for HS256/HS384/HS512 tokens. Env: JWT_SECRET.
+	HMACSecret string `yaml:"hmac_secret"`
+
+	// PublicKeyFile is a PEM-encoded RSA or ECDSA public key for RS*/ES* tokens.
+	PublicKeyFile string `yaml:"public_key_file"`
+
+	// ClockSkew is the tolerated difference between the gateway's clock and
+	// the issuer's when checking exp, nbf and iat, e.g. 30s.
+	ClockSkew time.Duration `yaml:"clock_skew"`
+
+	// Issuer, when set, must equal the iss claim.
+	Issuer string `yaml:"issuer"`
+
+	// Audience, when set, must be one of the aud claim values.
+	Audience string `yaml:"audience"`
+}
+
+var ErrSignature = errors.New("invalid token signature")
+
+// Minimum key sizes, from RFC 7518: RSA keys of at least 2048 bits, and HMAC
+// secrets at least as long as the HS256 hash output.
+const (
+	minRSABits     = 2048
+	minSecretBytes = 32
+)
+
+// Verifier checks token signatures.
+type Verifier struct {
+	secret atomic.Pointer[[]byte]
+	rsaKey *rsa.PublicKey
+	ecKey  *ecdsa.PublicKey
+}
+
+// NewVerifier loads the configured keys. It returns a nil Verifier when no
+// key material is configured.
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if cfg.HMACSecret == "" && cfg.PublicKeyFile == "" {
+		return nil, nil
+	}
+
+	v := &Verifier{}
+	if cfg.HMACSecret != "" {
+		v.SetHMACSecret(cfg.HMACSecret)
+	}
+	if cfg.PublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data in %s", cfg.PublicKeyFile)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT public key: %w", err)
+		}
+		switch k := pub.(type) {
+		case *rsa.PublicKey:
+			if k.N.BitLen() < minRSABits {
+				return nil, fmt.Errorf("JWT public key is %d-bit RSA, at least %d bits are required", k.N.BitLen(), minRSABits)
+			}
+			v.rsaKey = k
+		case *ecdsa.PublicKey:
+			v.ecKey = k
+		default:
+			return nil, fmt.Errorf("unsupported JWT public key type %T", pub)
+		}
+	}
+	return v, nil
+}
+
+// SetHMACSecret replaces the HMAC secret, e.g. with a refreshed one.
+// Tokens signed with the previous secret no longer verify.
+func (v *Verifier) SetHMACSecret(secret string) {
+	b := []byte(secret)
+	v.secret.Store(&b)
+}
+
+// PublicKey returns the RSA or ECDSA public key tokens are verified with,
+// or nil when there is none. HMAC secrets are never returned.
+func (v *Verifier) PublicKey() crypto.PublicKey {
+	switch {
+	case v == nil:
+		return nil
+	case v.rsaKey != nil:
+		return v.rsaKey
+	case v.ecKey != nil:
+		return v.ecKey
+	}
+	return nil
+}
+
+// Describe summarizes the loaded key material, e.g. "HMAC secret, RSA-2048
+// public key".
+func (v *Verifier) Describe() string {
+	if v == nil {
+		return "disabled"
+	}
+	var keys []string
+	if v.secret.Load() != nil {
+		keys = append(keys, "HMAC secret")
+	}
+	if v.rsaKey != nil {
+		keys = append(keys, fmt.Sprintf("RSA-%d public key", v.rsaKey.N.BitLen()))
+	}
+	if v.ecKey != nil {
+		keys = append(keys, v.ecKey.Curve.Params().Name+" public key")
+	}
+	return strings.Join(keys, ", ")
+}
+
+// Warnings lists weaknesses of the configured key material that do not
+// prevent verification, such as a short HMAC secret.
+func (c Config) Warnings() []string {
+	var out []string
+	if c.HMACSecret != "" && len(c.HMACSecret) < minSecretBytes {
+		out = append(out, fmt.Sprintf("HMAC secret is %d bytes, at least %d are recommended", len(c.HMACSecret), minSecretBytes))
+	}
+	return out
+}
+
+// Verify checks the token signature and returns its claims.
+func (v *Verifier) Verify(raw string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, err
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, ErrSignature
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	if err := v.verifySignature(header.Alg, signed, sig); err != nil {
+		return nil, err
+	}
+
+	c, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	c.Verified = true
+	return c, nil
+}
+
+func (v *Verifier) verifySignature(alg string, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	var (
+		newHash func() hash.Hash
+		h       crypto.Hash
+	)
+	switch alg[2:] {
+	case "256":
+		newHash, h = sha256.New, crypto.SHA256
+	case "384":
+		newHash, h = sha512.New384, crypto.SHA384
+	case "512":
+		newHash, h = sha512.New, crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+
+	secret := v.secret.Load()
+	switch {
+	case strings.HasPrefix(alg, "HS") && secret != nil:
+		mac := hmac.New(newHash, *secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrSignature
+		}
+		return nil
+
+	case strings.HasPrefix(alg, "RS") && v.rsaKey != nil:
+		d := newHash()
+		d.Write(signed)
+		if rsa.VerifyPKCS1v15(v.rsaKey, h, d.Sum(nil), sig) != nil {
+			return ErrSignature
+		}
+		return nil
+
+	case strings.HasPrefix(alg, "ES") && v.ecKey != nil:
+		size := (v.ecKey.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrSignature
+		}
+		d := newHash()
+		d.Write(signed)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(v.ecKey, d.Sum(nil), r, s) {
+			return ErrSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported token algorithm %q", alg)
+}
@@ -0,0 +1,228 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/http/instrument"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Config decides which requests are traced. Without it, every request is,
+// unless its traceparent says the caller did not sample it.
+type Config struct {
+	// Percent of requests (0-100) traced when no route rule matches.
+	Percent float64 `yaml:"percent"`
+
+	// Errors traces every request answered with a 5xx status or with a
+	// failed backend call.
+	Errors bool `yaml:"errors"`
+
+	// SlowerThan traces every request that takes longer, e.g. 500ms.
+	SlowerThan time.Duration `yaml:"slower_than"`
+
+	// Routes override Percent and SlowerThan under a path prefix. The first
+	// rule whose prefix matches the request path applies.
+	Routes []RouteRule `yaml:"routes"`
+
+	// IgnoreUpstream applies Percent even to requests whose traceparent
+	// carries the caller's decision. By default, the caller decides, though
+	// Errors and SlowerThan still trace requests it did not sample.
+	IgnoreUpstream bool `yaml:"ignore_upstream"`
+}
+
+// RouteRule samples the requests under a path prefix.
+type RouteRule struct {
+	// PathPrefix selects the requests, e.g. "/inventory".
+	PathPrefix string `yaml:"path_prefix"`
+
+	// Percent of the requests (0-100) traced.
+	Percent float64 `yaml:"percent"`
+
+	// SlowerThan replaces the SlowerThan of the configuration when set.
+	SlowerThan time.Duration `yaml:"slower_than"`
+}
+
+// ExemplarLabel is the exemplar label holding the trace ID, under which
+// Grafana looks for it to link a histogram bucket to a trace.
+const ExemplarLabel = "trace_id"
+
+// maxPending bounds the spans kept for a trace that is not sampled yet.
+const maxPending = 256
+
+var tracesTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "tracing",
+	Name:      "requests_total",
+	Help:      "Requests by sampling decision (upstream, percent, error, slow, dropped).",
+}, []string{"decision"})
+
+// Sampler decides which requests are traced: at their start from the
+// caller's decision or by chance, and at their end for errors and slow
+// requests. A nil Sampler traces every request the caller did not opt out.
+type Sampler struct {
+	cfg Config
+}
+
+// New returns the sampler of cfg, or nil when cfg is empty.
+func New(cfg Config) (*Sampler, error) {
+	if cfg.Percent == 0 && !cfg.Errors && cfg.SlowerThan == 0 && len(cfg.Routes) == 0 && !cfg.IgnoreUpstream {
+		return nil, nil
+	}
+	if cfg.Percent < 0 || cfg.Percent > 100 {
+		return nil, fmt.Errorf("tracing percent %v must be between 0 and 100", cfg.Percent)
+	}
+	if cfg.SlowerThan < 0 {
+		return nil, fmt.Errorf("tracing slower_than %s must not be negative", cfg.SlowerThan)
+	}
+	for _, r := range cfg.Routes {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return nil, fmt.Errorf("tracing path prefix %q must start with /", r.PathPrefix)
+		}
+		if r.Percent < 0 || r.Percent > 100 {
+			return nil, fmt.Errorf("tracing %s: percent %v must be between 0 and 100", r.PathPrefix, r.Percent)
+		}
+		if r.SlowerThan < 0 {
+			return nil, fmt.Errorf("tracing %s: slower_than %s must not be negative", r.PathPrefix, r.SlowerThan)
+		}
+	}
+	return &Sampler{cfg: cfg}, nil
+}
+
+// policy returns the percent and latency threshold of path.
+func (s *Sampler) policy(path string) (float64, time.Duration) {
+	if s == nil {
+		return 100, 0
+	}
+	for _, r := range s.cfg.Routes {
+		if strings.HasPrefix(path, r.PathPrefix) {
+			if r.SlowerThan > 0 {
+				return r.Percent, r.SlowerThan
+			}
+			return r.Percent, s.cfg.SlowerThan
+		}
+	}
+	return s.cfg.Percent, s.cfg.SlowerThan
+}
+
+// Middleware continues the trace from the inbound traceparent header (or
+// starts a new one), records a span for the request if it is sampled and
+// returns the trace ID in the X-Trace-Id response header. Latency metrics
+// observed for the request link to the trace with an exemplar when it is
+// recorded.
+func (s *Sampler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		percent, slowerThan := s.policy(r.URL.Path)
+
+		parent, upstream := Parse(r.Header.Get(Header))
+		var sc SpanContext
+		if upstream {
+			sc = parent.Child()
+		} else {
+			sc = NewTrace()
+		}
+		decision := "dropped"
+		switch {
+		case upstream && (s == nil || !s.cfg.IgnoreUpstream):
+			sc.Sampled = parent.Sampled
+			if sc.Sampled {
+				decision = "upstream"
+			}
+		default:
+			sc.Sampled = rand.Float64()*100 < percent
+			if sc.Sampled {
+				decision = "percent"
+			}
+		}
+
+		var p *pending
+		if !sc.Sampled && s != nil && (s.cfg.Errors || slowerThan > 0) {
+			p = &pending{errors: s.cfg.Errors, slowerThan: slowerThan}
+			ctx = context.WithValue(ctx, pendingKey{}, p)
+		}
+		span := &Span{Name: r.Method + " " + r.URL.Path, Context: sc, Parent: parent, Start: time.Now(), pending: p}
+		ctx = WithSpanContext(ctx, sc)
+		ctx = metrics.WithExemplar(ctx, func(failed bool, elapsed time.Duration) prometheus.Labels {
+			if !sc.Sampled && (p == nil || !p.qualifies(failed, elapsed)) {
+				return nil
+			}
+			return prometheus.Labels{ExemplarLabel: sc.TraceIDString()}
+		})
+		w.Header().Set("X-Trace-Id", sc.TraceIDString())
+
+		iw := instrument.Wrap(w)
+		next.ServeHTTP(iw, r.WithContext(ctx))
+
+		if p != nil {
+			failed := p.finish()
+			switch {
+			case p.errors && (iw.Status() >= 500 || failed):
+				decision = "error"
+			case p.slowerThan > 0 && time.Since(span.Start) > p.slowerThan:
+				decision = "slow"
+			}
+			if decision != "dropped" {
+				span.Context.Sampled = true
+				span.SetAttributes(zap.String("sampled_by", decision))
+				for _, fields := range p.spans {
+					logger.Logger().Debug("Span finished", fields...)
+				}
+			}
+		}
+		tracesTotal.WithLabelValues(decision).Inc()
+		span.End(nil)
+	})
+}
+
+type pendingKey struct{}
+
+// pending keeps the finished spans of a trace that is not sampled yet,
+// until its request ends and the Sampler decides.
+type pending struct {
+	errors     bool
+	slowerThan time.Duration
+
+	mu     sync.Mutex
+	spans  [][]zap.Field
+	failed bool
+	done   bool
+}
+
+func (p *pending) add(fields []zap.Field, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done {
+		return
+	}
+	p.failed = p.failed || failed
+	if len(p.spans) < maxPending {
+		p.spans = append(p.spans, fields)
+	}
+}
+
+// finish stops keeping spans and reports whether one of them failed.
+func (p *pending) finish() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = true
+	return p.failed
+}
+
+// qualifies reports whether the request will be traced, given that it or
+// a part of it, such as a backend call, failed or not and took elapsed.
+// The request takes at least as long as its parts.
+func (p *pending) qualifies(failed bool, elapsed time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.errors && (failed || p.failed) || p.slowerThan > 0 && elapsed > p.slowerThan
+}
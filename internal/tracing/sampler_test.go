@@ -0,0 +1,186 @@
+package tracing_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordSpans sends the debug log to a file and returns a function reading the spans logged since its last call
+func recordSpans(t *testing.T) func() []map[string]any {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	require.NoError(t, logger.Init(logger.Config{Level: "debug", OutputPaths: []string{path}}))
+	t.Cleanup(func() { logger.Init(logger.Config{Level: "info"}) })
+
+	read := 0
+	return func() []map[string]any {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		var spans []map[string]any
+		sc := bufio.NewScanner(f)
+		for line := 0; sc.Scan(); line++ {
+			if line < read {
+				continue
+			}
+			read++
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal(sc.Bytes(), &entry))
+			if entry["msg"] == "Span finished" {
+				spans = append(spans, entry)
+			}
+		}
+		return spans
+	}
+}
+
+// app answers by path: a 500, a failed backend call, a slow answer or a plain 200
+var app = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/error":
+		w.WriteHeader(http.StatusInternalServerError)
+	case "/fail":
+		_, span := tracing.StartSpan(r.Context(), "/inventory.InventoryService/GetProduct")
+		span.End(errors.New("unavailable"))
+	case "/slow":
+		time.Sleep(30 * time.Millisecond)
+	}
+})
+
+func serve(h http.Handler, target, traceparent string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if traceparent != "" {
+		r.Header.Set(tracing.Header, traceparent)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestSampler tests that errors, slow requests, route rules and the caller's decision select the traced requests
+func TestSampler(t *testing.T) {
+	spans := recordSpans(t)
+	s, err := tracing.New(tracing.Config{
+		Errors:     true,
+		SlowerThan: 20 * time.Millisecond,
+		Routes:     []tracing.RouteRule{{PathPrefix: "/always", Percent: 100}},
+	})
+	require.NoError(t, err)
+	h := s.Middleware(app)
+
+	w := serve(h, "/ok", "")
+	assert.Len(t, w.Header().Get("X-Trace-Id"), 32)
+	assert.Empty(t, spans(), "0% of other requests")
+
+	serve(h, "/error", "")
+	got := spans()
+	require.Len(t, got, 1)
+	assert.Equal(t, "GET /error", got[0]["span"])
+	assert.Equal(t, "error", got[0]["sampled_by"])
+
+	serve(h, "/fail", "")
+	got = spans()
+	require.Len(t, got, 2, "the spans of the request are kept until it ends")
+	assert.Equal(t, "/inventory.InventoryService/GetProduct", got[0]["span"])
+	assert.Equal(t, "unavailable", got[0]["error"])
+	assert.Equal(t, got[0]["trace_id"], got[1]["trace_id"])
+	assert.Equal(t, "error", got[1]["sampled_by"])
+
+	serve(h, "/slow", "")
+	got = spans()
+	require.Len(t, got, 1)
+	assert.Equal(t, "slow", got[0]["sampled_by"])
+
+	serve(h, "/always/x", "")
+	assert.Len(t, spans(), 1)
+
+	sampled := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	serve(h, "/ok", sampled)
+	got = spans()
+	require.Len(t, got, 1)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", got[0]["trace_id"])
+	assert.Equal(t, "b7ad6b7169203331", got[0]["parent_span_id"])
+
+	serve(h, "/always/x", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	assert.Empty(t, spans(), "the caller decides")
+
+	s, err = tracing.New(tracing.Config{IgnoreUpstream: true})
+	require.NoError(t, err)
+	serve(s.Middleware(app), "/ok", sampled)
+	assert.Empty(t, spans())
+}
+
+// TestSampler_Nil tests that without a configuration every request is traced unless the caller opted out
+func TestSampler_Nil(t *testing.T) {
+	spans := recordSpans(t)
+	s, err := tracing.New(tracing.Config{})
+	require.NoError(t, err)
+	require.Nil(t, s)
+	h := s.Middleware(app)
+
+	serve(h, "/fail", "")
+	assert.Len(t, spans(), 2)
+	serve(h, "/error", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	assert.Empty(t, spans())
+}
+
+// TestSampler_Exemplars tests that latency observations link to the traces that are recorded
+func TestSampler_Exemplars(t *testing.T) {
+	s, err := tracing.New(tracing.Config{SlowerThan: 20 * time.Millisecond, Routes: []tracing.RouteRule{{PathPrefix: "/always", Percent: 100}}})
+	require.NoError(t, err)
+
+	var exemplars []string
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		elapsed := time.Millisecond
+		if r.URL.Path == "/slow" {
+			elapsed = 30 * time.Millisecond
+		}
+		hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds"})
+		metrics.ObserveDuration(r.Context(), hist, elapsed, false)
+		var m dto.Metric
+		require.NoError(t, hist.Write(&m))
+		for _, b := range m.Histogram.Bucket {
+			if e := b.Exemplar; e != nil {
+				exemplars = append(exemplars, e.Label[0].GetName()+"="+e.Label[0].GetValue())
+			}
+		}
+	}))
+
+	w := serve(h, "/always/x", "")
+	assert.Equal(t, []string{"trace_id=" + w.Header().Get("X-Trace-Id")}, exemplars)
+
+	exemplars = nil
+	serve(h, "/ok", "")
+	assert.Empty(t, exemplars, "traces that are not recorded have no exemplar")
+
+	w = serve(h, "/slow", "")
+	assert.Equal(t, []string{"trace_id=" + w.Header().Get("X-Trace-Id")}, exemplars, "slow requests will be recorded")
+}
+
+// TestNew tests that percents are bounded and rules need a path prefix
+func TestNew(t *testing.T) {
+	for _, cfg := range []tracing.Config{
+		{Percent: 101},
+		{SlowerThan: -time.Second},
+		{Routes: []tracing.RouteRule{{PathPrefix: "health"}}},
+		{Routes: []tracing.RouteRule{{PathPrefix: "/health", Percent: -1}}},
+	} {
+		_, err := tracing.New(cfg)
+		assert.Error(t, err, "%+v", cfg)
+	}
+}
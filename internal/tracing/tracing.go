@@ -0,0 +1,162 @@
+// Package tracing implements lightweight distributed tracing for the gateway.
+// It propagates W3C Trace Context (traceparent) from inbound HTTP requests to
+// outbound gRPC calls and records spans to the logger. A Sampler decides
+// which requests are traced; see sampler.go.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Header is the W3C Trace Context propagation header.
+const Header = "traceparent"
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are non-zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceIDString returns the hex-encoded trace ID.
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// SpanIDString returns the hex-encoded span ID.
+func (sc SpanContext) SpanIDString() string {
+	return hex.EncodeToString(sc.SpanID[:])
+}
+
+// TraceParent formats sc as a traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceIDString() + "-" + sc.SpanIDString() + "-" + flags
+}
+
+// Parse decodes a traceparent header value.
+func Parse(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&0x01 == 1
+	return sc, sc.IsValid()
+}
+
+// NewTrace starts a new sampled trace.
+func NewTrace() SpanContext {
+	var sc SpanContext
+	_, _ = rand.Read(sc.TraceID[:])
+	_, _ = rand.Read(sc.SpanID[:])
+	sc.Sampled = true
+	return sc
+}
+
+// Child returns a new span in the same trace.
+func (sc SpanContext) Child() SpanContext {
+	c := sc
+	_, _ = rand.Read(c.SpanID[:])
+	return c
+}
+
+type spanKey struct{}
+
+// WithSpanContext returns a copy of ctx carrying sc.
+func WithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// FromContext returns the span context carried by ctx, if any.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Span is an in-progress unit of work.
+type Span struct {
+	Name    string
+	Context SpanContext
+	Parent  SpanContext
+	Start   time.Time
+	attrs   []zap.Field
+	pending *pending
+}
+
+// StartSpan starts a child of the span in ctx (or a new trace) and returns a
+// context carrying the new span.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent, ok := FromContext(ctx)
+	var sc SpanContext
+	if ok {
+		sc = parent.Child()
+	} else {
+		sc = NewTrace()
+	}
+	s := &Span{Name: name, Context: sc, Parent: parent, Start: time.Now()}
+	s.pending, _ = ctx.Value(pendingKey{}).(*pending)
+	return WithSpanContext(ctx, sc), s
+}
+
+// SetAttributes attaches fields that are recorded with the span.
+func (s *Span) SetAttributes(fields ...zap.Field) {
+	s.attrs = append(s.attrs, fields...)
+}
+
+// End finishes the span and records it if the trace is sampled. Spans of a
+// trace that is not sampled yet are kept until its request ends, in case
+// the Sampler then decides to trace it.
+func (s *Span) End(err error) {
+	if !s.Context.Sampled {
+		if s.pending != nil {
+			s.pending.add(s.fields(err), err != nil)
+		}
+		return
+	}
+	logger.Logger().Debug("Span finished", s.fields(err)...)
+}
+
+func (s *Span) fields(err error) []zap.Field {
+	fields := []zap.Field{
+		zap.String("span", s.Name),
+		zap.String("trace_id", s.Context.TraceIDString()),
+		zap.String("span_id", s.Context.SpanIDString()),
+		zap.Duration("duration", time.Since(s.Start)),
+	}
+	if s.Parent.IsValid() {
+		fields = append(fields, zap.String("parent_span_id", s.Parent.SpanIDString()))
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	return append(fields, s.attrs...)
+}
@@ -0,0 +1,200 @@
+// Package upgrade hands the gateway's listening sockets to a freshly started
+// copy of the binary, so a new version can take over without refusing or
+// dropping connections: the new process inherits the sockets, reports ready,
+// and only then does the old process drain and exit.
+package upgrade
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	envFiles = "GATEWAY_UPGRADE_FILES"
+
+	// firstFD is the descriptor of the first exec.Cmd.ExtraFiles entry.
+	firstFD = 3
+
+	defaultTimeout = 30 * time.Second
+)
+
+// ErrInProgress is returned when an upgrade is already running.
+var ErrInProgress = errors.New("upgrade already in progress")
+
+// Upgrader manages the sockets inherited from a parent process and upgrades
+// to a new process.
+type Upgrader struct {
+	// Timeout bounds how long the new process may take to become ready. Default: 30s.
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	ready     *os.File
+	upgrading bool
+}
+
+// New reads the sockets passed by a parent gateway, if any. The environment
+// is consumed so further children do not misinterpret it.
+func New() (*Upgrader, error) {
+	u := &Upgrader{inherited: map[string]*os.File{}}
+
+	raw := os.Getenv(envFiles)
+	if raw == "" {
+		return u, nil
+	}
+	os.Unsetenv(envFiles)
+
+	var names []string
+	if err := json.Unmarshal([]byte(raw), &names); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envFiles, err)
+	}
+	for i, name := range names {
+		u.inherited[name] = os.NewFile(uintptr(firstFD+i), name)
+	}
+	// the readiness pipe follows the sockets
+	u.ready = os.NewFile(uintptr(firstFD+len(names)), "upgrade-ready")
+	return u, nil
+}
+
+// Upgraded reports whether this process was started by an upgrade.
+func (u *Upgrader) Upgraded() bool {
+	return u.ready != nil
+}
+
+// Names returns the names of the sockets not yet taken, sorted.
+func (u *Upgrader) Names() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	names := make([]string, 0, len(u.inherited))
+	for name := range u.inherited {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// File returns the inherited socket registered under name, or nil. Each
+// socket can be taken once; the caller owns the returned file.
+func (u *Upgrader) File(name string) *os.File {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	f := u.inherited[name]
+	delete(u.inherited, name)
+	return f
+}
+
+// Ready tells the parent process that this one serves traffic, so the parent
+// can drain and exit. Sockets that were inherited but not taken are closed.
+// It is a no-op when the process was not started by an upgrade.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for name, f := range u.inherited {
+		f.Close()
+		delete(u.inherited, name)
+	}
+	if u.ready == nil {
+		return nil
+	}
+	_, err := u.ready.Write([]byte("ready"))
+	u.ready.Close()
+	u.ready = nil
+	return err
+}
+
+// Upgrade starts the current executable with the same arguments, passing it
+// files (keyed by socket name), and waits until it reports ready. It returns
+// the PID of the new process. On error the new process is killed and the
+// caller keeps serving. The files are closed in every case.
+func (u *Upgrader) Upgrade(files map[string]*os.File) (int, error) {
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	u.mu.Lock()
+	if u.upgrading {
+		u.mu.Unlock()
+		return 0, ErrInProgress
+	}
+	u.upgrading = true
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.upgrading = false
+		u.mu.Unlock()
+	}()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	extra := make([]*os.File, 0, len(names)+1)
+	for _, name := range names {
+		extra = append(extra, files[name])
+	}
+	encoded, err := json.Marshal(names)
+	if err != nil {
+		return 0, err
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), envFiles+"="+string(encoded))
+	cmd.ExtraFiles = append(extra, readyW)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start new process: %w", err)
+	}
+
+	readyCh := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len("ready"))
+		_, err := io.ReadFull(readyR, buf)
+		readyCh <- err
+	}()
+
+	timeout := u.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-readyCh:
+		if err == nil {
+			return cmd.Process.Pid, nil
+		}
+		// the pipe closed without a ready message: the child exited or gave up
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, fmt.Errorf("new process exited before becoming ready: %w", err)
+	case <-timer.C:
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, fmt.Errorf("new process not ready after %s", timeout)
+	}
+}
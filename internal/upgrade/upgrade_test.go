@@ -0,0 +1,98 @@
+package upgrade_test
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/upgrade"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runChild plays the new process when the test binary is re-executed by Upgrade
+func runChild(t *testing.T, mode string) {
+	u, err := upgrade.New()
+	require.NoError(t, err)
+	require.True(t, u.Upgraded())
+	if mode == "fail" {
+		os.Exit(1)
+	}
+
+	assert.Equal(t, []string{"http"}, u.Names())
+	f := u.File("http")
+	require.NotNil(t, f)
+	ln, err := net.FileListener(f)
+	require.NoError(t, err)
+	f.Close()
+	require.NoError(t, u.Ready())
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	conn.Write([]byte("child"))
+	conn.Close()
+}
+
+// upgradeListener hands ln over to a re-executed test binary in the given mode
+func upgradeListener(t *testing.T, ln net.Listener, mode string) (int, error) {
+	t.Setenv("UPGRADE_TEST_CHILD", mode)
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^" + t.Name() + "$"}
+	defer func() { os.Args = args }()
+
+	f, err := ln.(*net.TCPListener).File()
+	require.NoError(t, err)
+	u, err := upgrade.New()
+	require.NoError(t, err)
+	u.Timeout = 10 * time.Second
+	return u.Upgrade(map[string]*os.File{"http": f})
+}
+
+// TestUpgrade_HandsOverListener tests that the new process serves on the inherited socket
+func TestUpgrade_HandsOverListener(t *testing.T) {
+	if mode := os.Getenv("UPGRADE_TEST_CHILD"); mode != "" {
+		runChild(t, mode)
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	pid, err := upgradeListener(t, ln, "ok")
+	require.NoError(t, err)
+	assert.NotEqual(t, os.Getpid(), pid)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	buf := make([]byte, 5)
+	_, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "child", string(buf))
+}
+
+// TestUpgrade_ChildFails tests that a new process exiting before ready fails the upgrade
+func TestUpgrade_ChildFails(t *testing.T) {
+	if mode := os.Getenv("UPGRADE_TEST_CHILD"); mode != "" {
+		runChild(t, mode)
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	_, err = upgradeListener(t, ln, "fail")
+	assert.Error(t, err)
+}
+
+// TestNew_NotUpgraded tests a normally started process
+func TestNew_NotUpgraded(t *testing.T) {
+	u, err := upgrade.New()
+	require.NoError(t, err)
+	assert.False(t, u.Upgraded())
+	assert.Nil(t, u.File("http"))
+	assert.NoError(t, u.Ready())
+}
@@ -0,0 +1,522 @@
+// Package webhook delivers gateway events to operator-registered HTTP
+// endpoints. Each delivery is a POST of the event JSON, signed with the
+// endpoint's secret, and retried with exponential backoff until it succeeds
+// or runs out of attempts. Recent deliveries are kept for the admin API.
+package webhook
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/jobs"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" with the endpoint secret, prefixed with "sha256=".
+const (
+	SignatureHeader = "X-Gateway-Signature"
+	TimestampHeader = "X-Gateway-Timestamp"
+	EventHeader     = "X-Gateway-Event"
+	DeliveryHeader  = "X-Gateway-Delivery"
+)
+
+// RetryJobKind is the kind of the jobs running webhook retries.
+const RetryJobKind = "webhook.retry"
+
+// Delivery states.
+const (
+	StatePending   = "pending"
+	StateRetrying  = "retrying"
+	StateDelivered = "delivered"
+	StateFailed    = "failed"
+)
+
+// Config configures webhook delivery.
+type Config struct {
+	// Endpoints are registered at startup; more can be added with the admin API.
+	Endpoints []Endpoint `yaml:"endpoints"`
+
+	// Workers is the number of concurrent deliveries. Default: 4.
+	Workers int `yaml:"workers"`
+
+	// QueueSize bounds deliveries waiting for a worker, and separately those
+	// waiting for a retry; further ones fail immediately. Default: 1000.
+	QueueSize int `yaml:"queue_size"`
+
+	// MaxAttempts is the number of attempts per delivery. Default: 8.
+	MaxAttempts int `yaml:"max_attempts"`
+
+	// RetryBackoff is the delay before the first retry, doubled for each
+	// further one (with jitter) up to MaxBackoff. Default: 1s.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+
+	// MaxBackoff caps the delay between attempts. Default: 10m.
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+
+	// Timeout bounds each attempt. Default: 10s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// History is the number of recent deliveries kept for the admin API. Default: 200.
+	History int `yaml:"history"`
+}
+
+// Endpoint is a webhook target.
+type Endpoint struct {
+	ID     string `yaml:"id" json:"id"`
+	URL    string `yaml:"url" json:"url"`
+	Secret string `yaml:"secret" json:"secret,omitempty"`
+
+	// Events lists the event types to deliver, e.g. "product.created".
+	// Empty means all events.
+	Events []string `yaml:"events" json:"events,omitempty"`
+}
+
+func (e Endpoint) wants(typ string) bool {
+	return len(e.Events) == 0 || slices.Contains(e.Events, typ)
+}
+
+// Delivery is the status of one event sent to one endpoint.
+type Delivery struct {
+	ID          string    `json:"id"`
+	Endpoint    string    `json:"endpoint"`
+	Event       string    `json:"event"`
+	State       string    `json:"state"`
+	Attempts    int       `json:"attempts"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitzero"`
+	// JobID is the job of the latest retry, when retries run as jobs.
+	JobID     string    `json:"job_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var deliveriesTotal = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "webhooks",
+	Name:      "deliveries_total",
+	Help:      "Webhook delivery attempts by endpoint and result (delivered, retried, failed, dropped).",
+}, []string{"endpoint", "result"})
+
+type task struct {
+	delivery *Delivery
+	endpoint Endpoint
+	body     []byte
+}
+
+// retryQueue is a heap of tasks waiting for a retry, earliest first.
+type retryQueue []task
+
+func (q retryQueue) Len() int           { return len(q) }
+func (q retryQueue) Less(i, j int) bool { return q.at(i).Before(q.at(j)) }
+func (q retryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *retryQueue) Push(x any)        { *q = append(*q, x.(task)) }
+func (q retryQueue) at(i int) time.Time { return q[i].delivery.NextAttempt }
+
+func (q *retryQueue) Pop() any {
+	old := *q
+	t := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return t
+}
+
+// Dispatcher delivers events to the registered endpoints.
+type Dispatcher struct {
+	// Jobs, when set, runs retries as background jobs of kind RetryJobKind,
+	// which report in GET /jobs/{id} like other jobs. Otherwise the
+	// dispatcher's own workers run them. Set it before events are delivered.
+	Jobs *jobs.Runner
+
+	cfg    Config
+	client *http.Client
+
+	mu         sync.Mutex
+	endpoints  map[string]Endpoint
+	deliveries []*Delivery // oldest first, at most cfg.History
+	retries    retryQueue  // at most cfg.QueueSize
+	closed     bool
+
+	queue chan task
+	// wake tells the scheduler a retry was added
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New validates the configured endpoints and starts the workers.
+func New(cfg Config) (*Dispatcher, error) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 10 * time.Minute
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.History <= 0 {
+		cfg.History = 200
+	}
+
+	d := &Dispatcher{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		endpoints: make(map[string]Endpoint),
+		queue:     make(chan task, cfg.QueueSize),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	for _, e := range cfg.Endpoints {
+		if err := d.Register(e); err != nil {
+			return nil, err
+		}
+	}
+	for range cfg.Workers {
+		d.wg.Add(1)
+		go d.work()
+	}
+	d.wg.Add(1)
+	go d.schedule()
+	return d, nil
+}
+
+// Register adds or replaces an endpoint.
+func (d *Dispatcher) Register(e Endpoint) error {
+	if e.ID == "" {
+		return errors.New("webhook: endpoint id is required")
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook %s: invalid url %q", e.ID, e.URL)
+	}
+	if e.Secret == "" {
+		return fmt.Errorf("webhook %s: secret is required", e.ID)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.endpoints[e.ID] = e
+	return nil
+}
+
+// Remove deletes an endpoint. Deliveries already queued still go out.
+func (d *Dispatcher) Remove(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.endpoints[id]
+	delete(d.endpoints, id)
+	return ok
+}
+
+// Endpoints returns the registered endpoints sorted by ID, without secrets.
+func (d *Dispatcher) Endpoints() []Endpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]Endpoint, 0, len(d.endpoints))
+	for _, e := range d.endpoints {
+		e.Secret = ""
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Deliveries returns the recent deliveries, newest first.
+func (d *Dispatcher) Deliveries() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]Delivery, len(d.deliveries))
+	for i, del := range d.deliveries {
+		out[len(out)-1-i] = *del
+	}
+	return out
+}
+
+// Listen is an events.Listener queuing a delivery of msg to every endpoint
+// subscribed to its type.
+func (d *Dispatcher) Listen(msg events.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	now := time.Now().UTC()
+	for _, e := range d.endpoints {
+		if !e.wants(msg.Type) {
+			continue
+		}
+		del := &Delivery{
+			ID:        requestid.New(),
+			Endpoint:  e.ID,
+			Event:     msg.Type,
+			State:     StatePending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		d.record(del)
+		select {
+		case d.queue <- task{delivery: del, endpoint: e, body: msg.Value}:
+		default:
+			del.State, del.Error = StateFailed, "queue full"
+			deliveriesTotal.WithLabelValues(e.ID, "dropped").Inc()
+		}
+	}
+}
+
+// record adds del to the history, evicting the oldest entry. d.mu must be held.
+func (d *Dispatcher) record(del *Delivery) {
+	if len(d.deliveries) >= d.cfg.History {
+		d.deliveries = append(d.deliveries[:0], d.deliveries[1:]...)
+	}
+	d.deliveries = append(d.deliveries, del)
+}
+
+// Close stops accepting events and waits for queued deliveries until ctx is
+// done. Pending retries are abandoned.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.stop)
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for t := range d.queue {
+		d.attempt(t)
+	}
+}
+
+// attempt sends t once and records the outcome, scheduling a retry if it
+// failed and may succeed later. It returns the error of the attempt.
+func (d *Dispatcher) attempt(t task) error {
+	code, err := d.send(t)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	del := t.delivery
+	del.Attempts++
+	del.StatusCode = code
+	del.UpdatedAt = time.Now().UTC()
+	del.NextAttempt = time.Time{}
+	if err == nil {
+		del.State, del.Error = StateDelivered, ""
+		deliveriesTotal.WithLabelValues(del.Endpoint, "delivered").Inc()
+		return nil
+	}
+	del.Error = err.Error()
+	if del.Attempts >= d.cfg.MaxAttempts || !retryable(code) || d.closed || len(d.retries) >= d.cfg.QueueSize {
+		del.State = StateFailed
+		deliveriesTotal.WithLabelValues(del.Endpoint, "failed").Inc()
+		logger.Logger().Warn("Webhook delivery failed",
+			zap.String("endpoint", del.Endpoint),
+			zap.String("event", del.Event),
+			zap.String("delivery", del.ID),
+			zap.Int("attempts", del.Attempts),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	del.State = StateRetrying
+	wait := d.backoff(del.Attempts)
+	del.NextAttempt = del.UpdatedAt.Add(wait)
+	deliveriesTotal.WithLabelValues(del.Endpoint, "retried").Inc()
+	heap.Push(&d.retries, t)
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// schedule starts retries once their backoff has passed, and abandons the
+// remaining ones on Close.
+func (d *Dispatcher) schedule() {
+	defer d.wg.Done()
+	timer := time.NewTimer(d.cfg.MaxBackoff)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.stop:
+			d.mu.Lock()
+			for _, t := range d.retries {
+				t.delivery.State, t.delivery.NextAttempt = StateFailed, time.Time{}
+			}
+			d.retries = nil
+			// retries handed to the job runner may never run now
+			for _, del := range d.deliveries {
+				if del.State == StateRetrying {
+					del.State, del.NextAttempt = StateFailed, time.Time{}
+				}
+			}
+			d.mu.Unlock()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Reset(d.startDue())
+	}
+}
+
+// startDue starts the retries that are due and returns the time until the
+// next one. While there is no room for them, due retries wait another
+// RetryBackoff.
+func (d *Dispatcher) startDue() time.Duration {
+	for {
+		d.mu.Lock()
+		if len(d.retries) == 0 || d.closed {
+			d.mu.Unlock()
+			return d.cfg.MaxBackoff
+		}
+		if wait := time.Until(d.retries.at(0)); wait > 0 {
+			d.mu.Unlock()
+			return wait
+		}
+		t := heap.Pop(&d.retries).(task)
+		d.mu.Unlock()
+
+		if !d.start(t) {
+			d.mu.Lock()
+			t.delivery.NextAttempt = time.Now().UTC().Add(d.cfg.RetryBackoff)
+			heap.Push(&d.retries, t)
+			d.mu.Unlock()
+			return d.cfg.RetryBackoff
+		}
+	}
+}
+
+// start runs a retry as a job, or queues it for the workers without a job
+// runner. It reports false when there is no room for it yet.
+func (d *Dispatcher) start(t task) bool {
+	if d.Jobs == nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.closed {
+			t.delivery.State, t.delivery.NextAttempt = StateFailed, time.Time{}
+			return true
+		}
+		select {
+		case d.queue <- t:
+			return true
+		default:
+			return false
+		}
+	}
+
+	job, err := d.Jobs.Submit(context.Background(), RetryJobKind, func(ctx context.Context, _ *jobs.Tracker) (any, error) {
+		if err := d.attempt(t); err != nil {
+			// the details are in the delivery, for admins only
+			return nil, errors.New("webhook delivery attempt failed")
+		}
+		return nil, nil
+	})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		t.delivery.State, t.delivery.Error, t.delivery.NextAttempt = StateFailed, err.Error(), time.Time{}
+		deliveriesTotal.WithLabelValues(t.delivery.Endpoint, "failed").Inc()
+		return true
+	}
+	t.delivery.JobID = job.ID
+	return true
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.RetryBackoff << min(attempts-1, 30)
+	if wait <= 0 || wait > d.cfg.MaxBackoff {
+		wait = d.cfg.MaxBackoff
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// retryable reports whether a failed attempt with the given status code
+// (0 for transport errors) may succeed later.
+func retryable(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+func (d *Dispatcher) send(t task) (int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, t.endpoint.URL, bytes.NewReader(t.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gateway-webhooks")
+	req.Header.Set(EventHeader, t.delivery.Event)
+	req.Header.Set(DeliveryHeader, t.delivery.ID)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, Sign(t.endpoint.Secret, ts, t.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value of body sent at timestamp ts (Unix
+// seconds), for receivers to compare against.
+func Sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,199 @@
+package webhook_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/jobs"
+	"github.com/andro-kes/gateway/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var created = events.Message{Type: events.ProductCreated, Value: []byte(`{"type":"product.created","subject":"prod-1"}`)}
+
+// TestDispatcher_Deliver tests signed delivery to subscribed endpoints only
+func TestDispatcher_Deliver(t *testing.T) {
+	received := make(chan *http.Request, 2)
+	bodies := make(chan []byte, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	d, err := webhook.New(webhook.Config{Endpoints: []webhook.Endpoint{
+		{ID: "shop", URL: srv.URL, Secret: "s3cret", Events: []string{events.ProductCreated}},
+		{ID: "crm", URL: srv.URL, Secret: "other", Events: []string{events.UserRegistered}},
+	}})
+	require.NoError(t, err)
+
+	d.Listen(created)
+	require.NoError(t, d.Close(context.Background()))
+
+	require.Len(t, received, 1)
+	r, body := <-received, <-bodies
+	assert.Equal(t, created.Value, body)
+	assert.Equal(t, events.ProductCreated, r.Header.Get(webhook.EventHeader))
+	ts := r.Header.Get(webhook.TimestampHeader)
+	assert.Equal(t, webhook.Sign("s3cret", ts, body), r.Header.Get(webhook.SignatureHeader))
+
+	deliveries := d.Deliveries()
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "shop", deliveries[0].Endpoint)
+	assert.Equal(t, webhook.StateDelivered, deliveries[0].State)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, r.Header.Get(webhook.DeliveryHeader), deliveries[0].ID)
+}
+
+// TestDispatcher_Retry tests retries with backoff on server errors
+func TestDispatcher_Retry(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	d, err := webhook.New(webhook.Config{RetryBackoff: time.Millisecond, Endpoints: []webhook.Endpoint{{ID: "shop", URL: srv.URL, Secret: "s"}}})
+	require.NoError(t, err)
+	defer d.Close(context.Background())
+
+	d.Listen(created)
+	require.Eventually(t, func() bool { return d.Deliveries()[0].State == webhook.StateDelivered }, time.Second, time.Millisecond)
+	assert.Equal(t, 3, d.Deliveries()[0].Attempts)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+// TestDispatcher_RetryJobs tests that retries run as jobs of the job runner
+func TestDispatcher_RetryJobs(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	runner, err := jobs.New(jobs.Config{})
+	require.NoError(t, err)
+	defer runner.Close(context.Background())
+	d, err := webhook.New(webhook.Config{RetryBackoff: time.Millisecond, Endpoints: []webhook.Endpoint{{ID: "shop", URL: srv.URL, Secret: "s"}}})
+	require.NoError(t, err)
+	defer d.Close(context.Background())
+	d.Jobs = runner
+
+	d.Listen(created)
+	require.Eventually(t, func() bool { return d.Deliveries()[0].State == webhook.StateDelivered }, time.Second, time.Millisecond)
+	del := d.Deliveries()[0]
+	assert.Equal(t, 3, del.Attempts)
+	require.NotEmpty(t, del.JobID)
+
+	require.Eventually(t, func() bool {
+		job, err := runner.Get(context.Background(), del.JobID)
+		return err == nil && job.Finished()
+	}, time.Second, time.Millisecond)
+	job, err := runner.Get(context.Background(), del.JobID)
+	require.NoError(t, err)
+	assert.Equal(t, webhook.RetryJobKind, job.Kind)
+	assert.Equal(t, jobs.StateSucceeded, job.State)
+}
+
+// TestDispatcher_Failed tests that client errors and exhausted attempts fail the delivery
+func TestDispatcher_Failed(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	d, err := webhook.New(webhook.Config{MaxAttempts: 2, RetryBackoff: time.Millisecond, Endpoints: []webhook.Endpoint{
+		{ID: "gone", URL: srv.URL + "/gone", Secret: "s"},
+		{ID: "broken", URL: srv.URL + "/broken", Secret: "s"},
+	}})
+	require.NoError(t, err)
+	defer d.Close(context.Background())
+
+	d.Listen(created)
+	require.Eventually(t, func() bool {
+		for _, del := range d.Deliveries() {
+			if del.State != webhook.StateFailed {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond)
+
+	attempts := map[string]int{}
+	for _, del := range d.Deliveries() {
+		attempts[del.Endpoint] = del.Attempts
+		assert.Contains(t, del.Error, "endpoint answered")
+	}
+	assert.Equal(t, map[string]int{"gone": 1, "broken": 2}, attempts)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+// TestDispatcher_RetryQueue tests that waiting retries are bounded and abandoned on Close
+func TestDispatcher_RetryQueue(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	d, err := webhook.New(webhook.Config{QueueSize: 2, RetryBackoff: time.Hour, Endpoints: []webhook.Endpoint{{ID: "shop", URL: srv.URL, Secret: "s"}}})
+	require.NoError(t, err)
+
+	for i := range 3 {
+		d.Listen(created)
+		require.Eventually(t, func() bool { return d.Deliveries()[0].Attempts == 1 }, time.Second, time.Millisecond, i)
+	}
+	states := []string{}
+	for _, del := range d.Deliveries() {
+		states = append(states, del.State)
+	}
+	assert.Equal(t, []string{webhook.StateFailed, webhook.StateRetrying, webhook.StateRetrying}, states)
+
+	require.NoError(t, d.Close(context.Background()))
+	for _, del := range d.Deliveries() {
+		assert.Equal(t, webhook.StateFailed, del.State)
+		assert.Zero(t, del.NextAttempt)
+	}
+}
+
+// TestDispatcher_Register tests endpoint validation and listing
+func TestDispatcher_Register(t *testing.T) {
+	d, err := webhook.New(webhook.Config{})
+	require.NoError(t, err)
+	defer d.Close(context.Background())
+
+	assert.Error(t, d.Register(webhook.Endpoint{URL: "https://example.com", Secret: "s"}))
+	assert.Error(t, d.Register(webhook.Endpoint{ID: "a", URL: "ftp://example.com", Secret: "s"}))
+	assert.Error(t, d.Register(webhook.Endpoint{ID: "a", URL: "https://example.com"}))
+	require.NoError(t, d.Register(webhook.Endpoint{ID: "b", URL: "https://example.com/b", Secret: "s"}))
+	require.NoError(t, d.Register(webhook.Endpoint{ID: "a", URL: "https://example.com/a", Secret: "s"}))
+
+	endpoints := d.Endpoints()
+	require.Len(t, endpoints, 2)
+	assert.Equal(t, "a", endpoints[0].ID)
+	assert.Empty(t, endpoints[0].Secret)
+
+	assert.True(t, d.Remove("a"))
+	assert.False(t, d.Remove("a"))
+	assert.Len(t, d.Endpoints(), 1)
+
+	_, err = webhook.New(webhook.Config{Endpoints: []webhook.Endpoint{{ID: "x"}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,974 @@
+// Package gateway embeds the API gateway in other programs and tests. New
+// builds every component from a Config, exactly as the gateway binary does;
+// Handler serves the resulting router, RegisterService adds routes for
+// further backends, OnStart, OnReady, OnDrain and OnStop register lifecycle
+// hooks, and Run listens on the configured addresses until its context is
+// canceled.
+//
+//	cfg, err := gateway.LoadConfig("gateway.yaml")
+//	if err != nil {
+//		return err
+//	}
+//	gw, err := gateway.New(*cfg)
+//	if err != nil {
+//		return err
+//	}
+//	return gw.Run(ctx)
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	pbAuth "github.com/andro-kes/auth_service/proto"
+	"github.com/andro-kes/gateway/internal/access"
+	"github.com/andro-kes/gateway/internal/accesslog"
+	"github.com/andro-kes/gateway/internal/backend"
+	"github.com/andro-kes/gateway/internal/batch"
+	"github.com/andro-kes/gateway/internal/bodydump"
+	"github.com/andro-kes/gateway/internal/bot"
+	"github.com/andro-kes/gateway/internal/buildinfo"
+	"github.com/andro-kes/gateway/internal/bulkhead"
+	"github.com/andro-kes/gateway/internal/cachecontrol"
+	"github.com/andro-kes/gateway/internal/canary"
+	"github.com/andro-kes/gateway/internal/clientcert"
+	"github.com/andro-kes/gateway/internal/clientinfo"
+	"github.com/andro-kes/gateway/internal/config"
+	"github.com/andro-kes/gateway/internal/contenttype"
+	"github.com/andro-kes/gateway/internal/cookie"
+	"github.com/andro-kes/gateway/internal/disconnect"
+	"github.com/andro-kes/gateway/internal/discovery"
+	"github.com/andro-kes/gateway/internal/envelope"
+	"github.com/andro-kes/gateway/internal/events"
+	"github.com/andro-kes/gateway/internal/fault"
+	"github.com/andro-kes/gateway/internal/feature"
+	"github.com/andro-kes/gateway/internal/filter"
+	"github.com/andro-kes/gateway/internal/fixture"
+	"github.com/andro-kes/gateway/internal/http/handlers"
+	"github.com/andro-kes/gateway/internal/http/instrument"
+	"github.com/andro-kes/gateway/internal/http/render"
+	"github.com/andro-kes/gateway/internal/i18n"
+	"github.com/andro-kes/gateway/internal/interceptor"
+	"github.com/andro-kes/gateway/internal/jobs"
+	"github.com/andro-kes/gateway/internal/lifecycle"
+	"github.com/andro-kes/gateway/internal/logger"
+	"github.com/andro-kes/gateway/internal/maintenance"
+	"github.com/andro-kes/gateway/internal/metrics"
+	"github.com/andro-kes/gateway/internal/mfa"
+	"github.com/andro-kes/gateway/internal/mirror"
+	"github.com/andro-kes/gateway/internal/money"
+	"github.com/andro-kes/gateway/internal/notification"
+	"github.com/andro-kes/gateway/internal/overload"
+	"github.com/andro-kes/gateway/internal/pagination"
+	"github.com/andro-kes/gateway/internal/passthrough"
+	"github.com/andro-kes/gateway/internal/personalize"
+	"github.com/andro-kes/gateway/internal/queue"
+	"github.com/andro-kes/gateway/internal/quota"
+	"github.com/andro-kes/gateway/internal/realip"
+	"github.com/andro-kes/gateway/internal/recovery"
+	"github.com/andro-kes/gateway/internal/requestid"
+	"github.com/andro-kes/gateway/internal/respcache"
+	"github.com/andro-kes/gateway/internal/rotation"
+	"github.com/andro-kes/gateway/internal/routing"
+	"github.com/andro-kes/gateway/internal/schema"
+	"github.com/andro-kes/gateway/internal/secrets"
+	"github.com/andro-kes/gateway/internal/server"
+	"github.com/andro-kes/gateway/internal/servicecred"
+	"github.com/andro-kes/gateway/internal/signature"
+	"github.com/andro-kes/gateway/internal/slo"
+	"github.com/andro-kes/gateway/internal/startup"
+	"github.com/andro-kes/gateway/internal/timing"
+	"github.com/andro-kes/gateway/internal/token"
+	"github.com/andro-kes/gateway/internal/tracing"
+	"github.com/andro-kes/gateway/internal/webhook"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Config is the gateway configuration, as read from YAML by LoadConfig.
+type Config = config.Config
+
+// LoadConfig reads the configuration file at path, if not empty, and applies
+// the environment overrides (HTTP_ADDR, GRPC_ADDR, JWT_SECRET, ...).
+func LoadConfig(path string) (*Config, error) {
+	return config.Load(path)
+}
+
+// Option customizes New.
+type Option func(*options)
+
+type options struct {
+	probe     bool
+	dialOpts  []grpc.DialOption
+	auth      AuthService
+	inventory InventoryService
+}
+
+// WithoutBackendProbe skips the startup check that every backend is
+// reachable, e.g. when the backends start after the gateway.
+func WithoutBackendProbe() Option {
+	return func(o *options) { o.probe = false }
+}
+
+// WithDialOptions adds gRPC dial options to every backend connection, e.g.
+// a dialer for in-memory listeners in tests.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) { o.dialOpts = append(o.dialOpts, opts...) }
+}
+
+// AuthService is the auth backend behind the /auth routes.
+type AuthService = handlers.AuthService
+
+// AccountService changes and deletes accounts behind /auth/account. An
+// AuthService passed to WithAuthService may implement it.
+type AccountService = handlers.AccountService
+
+// UserAdminService administers users behind /admin/users. An AuthService
+// passed to WithAuthService may implement it.
+type UserAdminService = handlers.UserAdminService
+
+// MFAService enrolls users in MFA and checks their codes behind /auth/mfa.
+// An AuthService passed to WithAuthService may implement it.
+type MFAService = handlers.MFAService
+
+// MagicLinkService issues and redeems the tokens of magic link logins behind
+// /auth/magic-link. An AuthService passed to WithAuthService may implement
+// it.
+type MagicLinkService = handlers.MagicLinkService
+
+// Inputs and outputs of the AccountService, UserAdminService, MFAService and
+// MagicLinkService methods.
+type (
+	ChangePasswordRequest   = handlers.ChangePasswordRequest
+	ChangeEmailRequest      = handlers.ChangeEmailRequest
+	DeleteAccountRequest    = handlers.DeleteAccountRequest
+	ListUsersRequest        = handlers.ListUsersRequest
+	ListUsersResponse       = handlers.ListUsersResponse
+	User                    = handlers.User
+	LockUserRequest         = handlers.LockUserRequest
+	UserRequest             = handlers.UserRequest
+	MFAStatusResponse       = handlers.MFAStatusResponse
+	SetupMFAResponse        = handlers.SetupMFAResponse
+	MFACodeRequest          = handlers.MFACodeRequest
+	CreateMagicLinkRequest  = handlers.CreateMagicLinkRequest
+	CreateMagicLinkResponse = handlers.CreateMagicLinkResponse
+	RedeemMagicLinkRequest  = handlers.RedeemMagicLinkRequest
+)
+
+// InventoryService is the inventory backend behind the /inventory routes.
+type InventoryService = handlers.InventoryService
+
+// WithAuthService serves the /auth routes from s instead of the gRPC auth
+// backend, e.g. a REST client or an in-memory fake.
+func WithAuthService(s AuthService) Option {
+	return func(o *options) { o.auth = s }
+}
+
+// WithInventoryService serves the /inventory routes, imports and exports
+// from s instead of the gRPC inventory backend.
+func WithInventoryService(s InventoryService) Option {
+	return func(o *options) { o.inventory = s }
+}
+
+// Service is an additional API served by the gateway in front of a backend
+// of its own.
+type Service struct {
+	// Name is the backend the service talks to, which must be configured
+	// under backends, and the prefix of its routes: /<Name>/...
+	Name string
+
+	// Public serves the routes without access token authentication.
+	Public bool
+
+	// Routes registers the HTTP routes of the service. conn sends RPCs to
+	// the backend through the gateway's pool, canary and mirror settings.
+	Routes func(r chi.Router, conn grpc.ClientConnInterface)
+}
+
+// reserved are the route prefixes of the built-in APIs.
+var reserved = []string{backend.Auth, backend.Inventory, backend.Notifications, "jobs", "admin", "batch", "health", "metrics", "rpc", "statusz", "version", ".well-known"}
+
+// Gateway is a configured gateway.
+type Gateway struct {
+	cfg    Config
+	report startup.Report
+	router *chi.Mux
+
+	resolver      *realip.Resolver
+	acl           *access.Controller
+	accessLog     *accesslog.Logger
+	shedder       *overload.Controller
+	limiter       *bulkhead.Limiter
+	authenticator *handlers.Authenticator
+	backends      *backend.Manager
+	splitter      *canary.Splitter
+	shadow        *mirror.Mirror
+	verifier      *token.Verifier
+	recorder      *fixture.Recorder
+	emitter       *events.Emitter
+	webhooks      *webhook.Dispatcher
+	runner        *jobs.Runner
+	rotations     *rotation.Tracker
+	quotas        *quota.Meter
+	schemas       *schema.Cache
+	notifications *notification.Dispatcher
+	exporter      *metrics.Exporter
+	flags         *feature.Flags
+	objectives    *slo.Tracker
+	signatures    *signature.Verifier
+
+	hooks lifecycle.Hooks
+}
+
+// New builds every component described by cfg and wires them into the
+// router. It runs the startup checks, including a reachability check of the
+// backends unless disabled, and returns an error listing every failed check.
+func New(cfg Config, opts ...Option) (*Gateway, error) {
+	o := options{probe: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	g := &Gateway{cfg: cfg}
+
+	resolver, err := realip.New(cfg.RealIP)
+	g.report.Check("real_ip", err)
+
+	acl, err := access.New(cfg.Access)
+	g.report.Check("access", err)
+
+	accessLog, err := accesslog.New(cfg.AccessLog)
+	g.report.Check("access_log", err)
+
+	cachePolicies, err := cachecontrol.New(cfg.CacheControl)
+	g.report.Check("cache_control", err)
+
+	responses, err := respcache.New(cfg.ResponseCache)
+	g.report.Check("response_cache", err)
+	envelopes, err := envelope.New(cfg.ResponseEnvelope)
+	g.report.Check("response_envelope", err)
+	sampler, err := tracing.New(cfg.Tracing)
+	g.report.Check("tracing", err)
+
+	messages, err := i18n.New(cfg.I18n)
+	g.report.Check("i18n", err)
+
+	budgets, err := timing.NewBudgets(cfg.LatencyBudgets)
+	g.report.Check("latency_budgets", err)
+
+	exporter, err := metrics.NewExporter(cfg.Metrics)
+	g.report.Check("metrics", err)
+
+	mode, err := maintenance.New(cfg.Maintenance)
+	g.report.Check("maintenance", err)
+
+	flags, err := feature.New(cfg.Features)
+	g.report.Check("features", err)
+
+	bots, err := bot.New(cfg.Bots)
+	g.report.Check("bots", err)
+	faults, err := fault.New(cfg.Faults)
+	g.report.Check("faults", err)
+	if faults != nil && cfg.Admin.Token == "" {
+		g.report.Warn("faults", "Fault injection is allowed but can only be enabled through the admin API, which has no token configured")
+	}
+
+	limiter := bulkhead.New(cfg.Concurrency)
+	contentTypes := contenttype.New(cfg.ContentTypes)
+	renderer := render.NewRegistry(render.JSON{}, render.XML{}, render.MessagePack{})
+	if cfg.ProtobufPassthrough {
+		renderer.Register(render.Protobuf{})
+		for _, t := range (render.Protobuf{}).MediaTypes() {
+			contentTypes.Allow(t)
+		}
+	}
+	contentTypes.Allow(handlers.CSV)
+	dumper := bodydump.New(cfg.BodyDump)
+
+	shedder, err := overload.New(cfg.Overload)
+	g.report.Check("overload", err)
+
+	admission, err := queue.New(cfg.Queue)
+	g.report.Check("queue", err)
+
+	filters, err := filter.NewChain(cfg.Filters)
+	g.report.Check("filters", err)
+
+	var prices *money.Converter
+	if cfg.Money.DecimalPrices {
+		prices, err = money.New(cfg.Money)
+		g.report.Check("money", err)
+	}
+	if cfg.Pagination.Secret == "" {
+		g.report.Warn("pagination", "No pagination secret configured: list cursors are only valid on this instance until it restarts")
+	}
+	pager, err := pagination.New(cfg.Pagination)
+	g.report.Check("pagination", err)
+
+	emitter, err := events.New(cfg.Events)
+	g.report.Check("events", err)
+	webhooks, err := webhook.New(cfg.Webhooks)
+	g.report.Check("webhooks", err)
+	objectives, err := slo.New(cfg.SLO, emitter)
+	g.report.Check("slo", err)
+	runner, err := jobs.New(cfg.Jobs)
+	g.report.Check("jobs", err)
+	if webhooks != nil {
+		webhooks.Jobs = runner
+	}
+	quotas, err := quota.New(cfg.Quotas)
+	g.report.Check("quotas", err)
+	rotations, err := rotation.New(cfg.Auth.RefreshRotation)
+	g.report.Check("refresh_rotation", err)
+	signatures, err := signature.New(cfg.Auth.Signatures)
+	g.report.Check("auth.signatures", err)
+	clientCerts, err := clientcert.New(cfg.Auth.ClientCerts)
+	g.report.Check("auth.client_certs", err)
+	if clientCerts != nil && !slices.ContainsFunc(cfg.Listeners, func(l server.ListenerConfig) bool {
+		return l.TLS.ClientCA != "" || l.TLS.ClientCAFile != ""
+	}) {
+		g.report.Warn("auth.client_certs", "Client certificate identities are configured but no listener has a client CA")
+	}
+
+	cookies, err := cookie.New(cfg.Auth.Cookies)
+	g.report.Check("auth.cookies", err)
+	switch cfg.Auth.ResponseMode {
+	case "", handlers.ResponseCookies, handlers.ResponseTokens:
+	default:
+		g.report.Check("auth.response_mode", fmt.Errorf("unknown response mode %q", cfg.Auth.ResponseMode))
+	}
+
+	verifier, err := token.NewVerifier(cfg.Auth.JWT)
+	g.report.CheckJWT(cfg.Auth.JWT, verifier, err)
+	if verifier == nil && err == nil {
+		// roles are only taken from verified tokens, so role lists would
+		// refuse every caller
+		if len(cfg.Inventory.HardDeleteRoles) > 0 || len(cfg.Inventory.AvailabilityRoles) > 0 {
+			g.report.Check("inventory", errors.New("hard_delete_roles and availability_roles need a JWT key to verify roles"))
+		} else if cfg.Inventory.HardDeleteRoles == nil || cfg.Inventory.AvailabilityRoles == nil {
+			g.report.Warn("inventory", "No JWT key configured: availability changes and permanent deletes are allowed for every caller")
+		}
+	}
+	wellKnown, err := discovery.New(cfg.Auth.Discovery, cfg.Auth.JWT.Issuer, verifier.PublicKey())
+	g.report.Check("auth.discovery", err)
+	challenges, err := mfa.New(cfg.Auth.MFA)
+	g.report.Check("auth.mfa", err)
+
+	recorder, err := fixture.New(cfg.Fixtures)
+	g.report.Check("fixtures", err)
+	grpcAddr := cfg.GRPCAddr
+	if recorder.Mode() == fixture.Replay {
+		o.probe = false
+		if grpcAddr == "" {
+			// never dialed: every call is answered from the fixtures
+			grpcAddr = "passthrough:///fixtures"
+		}
+	}
+
+	g.report.Check("grpc_client.propagate_headers", interceptor.CheckHeaders(cfg.GRPCClient.PropagateHeaders))
+	dialOpts, err := servicecred.DialOptions(cfg.BackendAuth)
+	tokenKey := cfg.BackendAuth.MetadataKey()
+	// the default key is reserved, and rejected with the propagated headers
+	if err == nil && tokenKey != servicecred.DefaultMetadata && slices.ContainsFunc(cfg.GRPCClient.PropagateHeaders, func(h string) bool {
+		return strings.EqualFold(h, tokenKey)
+	}) {
+		err = fmt.Errorf("header %s carries the service token and cannot be propagated", tokenKey)
+	}
+	if _, ok := (backend.Config{}).StaticMetadata(cfg.GRPCClient.Labels)[tokenKey]; ok && err == nil {
+		err = fmt.Errorf("label metadata %s carries the service token", tokenKey)
+	}
+	for name, bc := range cfg.Backends {
+		if _, ok := bc.StaticMetadata(cfg.GRPCClient.Labels)[tokenKey]; ok && err == nil {
+			err = fmt.Errorf("metadata %s of backend %s carries the service token", tokenKey, name)
+		}
+	}
+	if !g.report.Check("backend_auth", err) {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	dialOpts = append(dialOpts,
+		interceptor.DialOption(cfg.GRPCClient),
+		interceptor.StreamDialOption(),
+	)
+	dialOpts = append(dialOpts, o.dialOpts...)
+	backends, err := backend.NewManager(cfg.Backends, backend.Defaults{Address: grpcAddr, Labels: cfg.GRPCClient.Labels}, dialOpts...)
+	if g.report.Check("backends", err) && o.probe {
+		var replaced []string
+		if o.auth != nil {
+			replaced = append(replaced, backend.Auth)
+		}
+		if o.inventory != nil {
+			replaced = append(replaced, backend.Inventory)
+		}
+		g.report.ProbeBackends(backends, cfg.Startup, replaced...)
+	}
+
+	// canary and shadow backends get the labels under the default prefix
+	dialOpts = append(dialOpts, interceptor.StaticMetadata((backend.Config{}).StaticMetadata(cfg.GRPCClient.Labels))...)
+	splitter, err := canary.New(cfg.Canary, dialOpts...)
+	g.report.Check("canary", err)
+	if err == nil {
+		for variant, name := range splitter.Backends() {
+			if backends == nil || backends.Pool(name) == nil {
+				g.report.Check("canary", fmt.Errorf("canary rule %s: unknown backend %q", variant, name))
+			}
+		}
+	}
+
+	shadow, err := mirror.New(cfg.Mirror, dialOpts...)
+	g.report.Check("mirror", err)
+
+	var notifications *notification.Dispatcher
+	if backends != nil {
+		if pool := backends.Pool(backend.Notifications); pool != nil {
+			notifications, err = notification.New(cfg.Notifications, recorder.Conn(backend.Notifications, pool))
+			g.report.Check("notifications", err)
+		}
+	}
+	var magicLink *url.URL
+	if cfg.Auth.MagicLink.Enabled {
+		magicLink, err = url.Parse(cfg.Auth.MagicLink.URL)
+		switch {
+		case err != nil:
+			g.report.Check("auth.magic_link", err)
+		case !magicLink.IsAbs():
+			g.report.Check("auth.magic_link", fmt.Errorf("url %q is not absolute", cfg.Auth.MagicLink.URL))
+		case notifications == nil:
+			g.report.Check("auth.magic_link", errors.New("magic links need the notifications backend"))
+		}
+	}
+
+	conn := func(name string) grpc.ClientConnInterface {
+		if backends == nil || backends.Pool(name) == nil {
+			return nil
+		}
+		return recorder.Conn(name, shadow.Conn(splitter.Conn(name, backends.Pool(name))))
+	}
+	var routes []*passthrough.Endpoint
+	for _, rt := range cfg.Routes {
+		if prefix, _, _ := strings.Cut(strings.TrimPrefix(rt.Path, "/"), "/"); slices.Contains(reserved, prefix) {
+			g.report.Check("routes", fmt.Errorf("route %s: prefix /%s is reserved", rt.Path, prefix))
+			continue
+		}
+		expanded, err := rt.Expand()
+		if !g.report.Check("routes", err) {
+			continue
+		}
+		for _, rt := range expanded {
+			ep, err := passthrough.New(rt, conn(rt.Backend))
+			if g.report.Check("routes", err) {
+				routes = append(routes, ep)
+			}
+		}
+	}
+	schemaConns := map[string]grpc.ClientConnInterface{}
+	for _, name := range cfg.Schema.Backends {
+		if c := conn(name); c != nil {
+			schemaConns[name] = c
+		} else {
+			g.report.Check("schema", fmt.Errorf("backend %q is not configured", name))
+		}
+	}
+	_, authAccounts := o.auth.(handlers.AccountService)
+	_, authUsers := o.auth.(handlers.UserAdminService)
+	_, authMFA := o.auth.(handlers.MFAService)
+	_, authMagicLinks := o.auth.(handlers.MagicLinkService)
+	if (cfg.Auth.Account.Enabled && !authAccounts) || (cfg.Admin.Users.Enabled && !authUsers) ||
+		(challenges != nil && !authMFA) || (cfg.Auth.MagicLink.Enabled && !authMagicLinks) {
+		// these RPCs are called by name, see NewGRPCAccountService
+		if c := conn(backend.Auth); c != nil {
+			schemaConns[backend.Auth] = c
+		}
+	}
+	for name := range cfg.RPC.Allow {
+		if c := conn(name); c != nil {
+			schemaConns[name] = c
+		}
+	}
+	schemas := schema.New(cfg.Schema, schemaConns)
+	wildcard, err := passthrough.NewWildcard(cfg.RPC, schemaConns, schemas)
+	g.report.Check("rpc", err)
+	for _, ep := range routes {
+		for _, t := range ep.MediaTypes() {
+			contentTypes.Allow(t)
+		}
+	}
+	if wildcard != nil {
+		contentTypes.Allow(passthrough.NDJSON)
+	}
+
+	// profileService is set once the auth service is known; the personalizer
+	// is built here so that its config is checked with the rest
+	var profileService handlers.ProfileService
+	personalizer, err := personalize.New(cfg.Auth.Personalization, func(ctx context.Context, userID string) (map[string]string, error) {
+		resp, err := profileService.GetProfile(ctx, &handlers.UserRequest{UserID: userID})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Attributes, nil
+	})
+	if err == nil {
+		for _, key := range personalizer.Keys() {
+			if slices.ContainsFunc(cfg.GRPCClient.PropagateHeaders, func(h string) bool { return strings.EqualFold(h, key) }) {
+				err = fmt.Errorf("metadata %s is set by the gateway and cannot be propagated", key)
+				break
+			}
+		}
+	}
+	g.report.Check("auth.personalization", err)
+
+	if err := g.report.Err(); err != nil {
+		return nil, err
+	}
+	g.resolver, g.acl, g.accessLog, g.shedder, g.limiter = resolver, acl, accessLog, shedder, limiter
+	g.backends, g.splitter, g.shadow, g.verifier, g.recorder = backends, splitter, shadow, verifier, recorder
+	g.emitter, g.webhooks, g.runner, g.notifications, g.rotations, g.quotas = emitter, webhooks, runner, notifications, rotations, quotas
+	g.schemas, g.exporter, g.flags, g.objectives, g.signatures = schemas, exporter, flags, objectives, signatures
+	var described startup.Report
+	described.Describe(&cfg, backends, verifier)
+	build := buildinfo.Get()
+	build.Features = described.Enabled()
+	buildinfo.Publish(build)
+	if cfg.Secrets.Refresh > 0 && len(cfg.Secrets.Refs) > 0 {
+		g.watchSecrets()
+	}
+	if err := schemas.Load(context.Background()); err != nil {
+		g.report.Warn("schema", "Failed to fetch backend schemas, retrying in the background: "+err.Error())
+	}
+
+	authConn := recorder.Conn(backend.Auth, shadow.Conn(splitter.Conn(backend.Auth, backends.Pool(backend.Auth))))
+	invConn := recorder.Conn(backend.Inventory, shadow.Conn(splitter.Conn(backend.Inventory, backends.Pool(backend.Inventory))))
+
+	g.authenticator = handlers.NewAuthenticator(verifier, !cfg.Auth.DisableIdentityMetadata)
+	g.authenticator.Validator = token.NewValidator(cfg.Auth.JWT)
+	g.authenticator.Cookies = cookies
+	g.authenticator.Signatures = signatures
+	g.authenticator.ClientCerts = clientCerts
+	emitter.Listen(webhooks.Listen)
+	authService := o.auth
+	if authService == nil {
+		authService = handlers.NewGRPCAuthService(pbAuth.NewAuthServiceClient(authConn))
+	}
+	authManager := handlers.NewAuthManager(authService)
+	authManager.Events = emitter
+	authManager.Rotations = rotations
+	authManager.Cookies = cookies
+	authManager.ResponseMode = cfg.Auth.ResponseMode
+	authManager.RememberTTL = cfg.Auth.RememberTTL
+	if challenges != nil {
+		mfaService, ok := authService.(handlers.MFAService)
+		if !ok {
+			mfaService = handlers.NewGRPCMFAService(authConn, schemas)
+		}
+		authManager.MFA = mfaService
+		authManager.Challenges = challenges
+	}
+
+	var accountManager *handlers.AccountManager
+	if cfg.Auth.Account.Enabled {
+		accountService, ok := authService.(handlers.AccountService)
+		if !ok {
+			accountService = handlers.NewGRPCAccountService(authConn, schemas)
+		}
+		accountManager = handlers.NewAccountManager(accountService, authService, cfg.Auth.Account.MaxAuthAge)
+		accountManager.Events = emitter
+		accountManager.Cookies = cookies
+	}
+
+	var magicLinks *handlers.MagicLinkManager
+	if magicLink != nil {
+		magicLinkService, ok := authService.(handlers.MagicLinkService)
+		if !ok {
+			magicLinkService = handlers.NewGRPCMagicLinkService(authConn, schemas)
+		}
+		magicLinks = handlers.NewMagicLinkManager(magicLinkService, authManager, notifications, magicLink)
+		if cfg.Auth.MagicLink.Template != "" {
+			magicLinks.Template = cfg.Auth.MagicLink.Template
+		}
+		magicLinks.Subject = cfg.Auth.MagicLink.Subject
+	}
+
+	var userAdmin *handlers.UserAdminManager
+	if cfg.Admin.Users.Enabled {
+		userService, ok := authService.(handlers.UserAdminService)
+		if !ok {
+			userService = handlers.NewGRPCUserAdminService(authConn, schemas)
+		}
+		userAdmin = handlers.NewUserAdminManager(userService, cfg.Admin.Users.Roles)
+	}
+
+	if ps, ok := authService.(handlers.ProfileService); ok {
+		profileService = ps
+	} else {
+		profileService = handlers.NewGRPCProfileService(authConn, schemas)
+	}
+
+	invService := o.inventory
+	if invService == nil {
+		invService = handlers.NewGRPCInventoryService(pbInv.NewInventoryServiceClient(invConn))
+	}
+	invManager := handlers.NewInvManager(invService)
+	invManager.Events = emitter
+	invManager.Jobs = runner
+	invManager.Queue = admission
+	invManager.RequireIfMatch = cfg.Inventory.RequireIfMatch
+	invManager.StreamPageSize = cfg.Inventory.StreamPageSize
+	invManager.HardDeleteRoles = cfg.Inventory.HardDeleteRoles
+	if invManager.HardDeleteRoles == nil && g.verifier != nil {
+		invManager.HardDeleteRoles = []string{"admin"}
+	}
+	invManager.AvailabilityRoles = cfg.Inventory.AvailabilityRoles
+	if invManager.AvailabilityRoles == nil && g.verifier != nil {
+		invManager.AvailabilityRoles = []string{"admin"}
+	}
+	if prices != nil {
+		renderer.AddTransform(prices.Transform)
+		invManager.Money = prices
+	}
+	invManager.Pager = pager
+
+	r := chi.NewRouter()
+	g.router = r
+	fallback := routing.New(cfg.Routing, r)
+	r.NotFound(fallback.NotFound)
+	r.MethodNotAllowed(fallback.MethodNotAllowed)
+	r.Use(sampler.Middleware)
+	r.Use(requestid.Middleware)
+	r.Use(renderer.Middleware)
+	r.Use(messages.Middleware)
+	r.Use(routing.NewNormalizer(cfg.Routing.Normalize, r).Middleware)
+	r.Use(budgets.Middleware)
+	r.Use(timing.ServerTiming(cfg.ServerTiming))
+	r.Use(resolver.Middleware)
+	r.Use(clientinfo.Middleware(resolver))
+	r.Use(interceptor.PropagateHeaders(cfg.GRPCClient.PropagateHeaders))
+	r.Use(accessLog.Middleware)
+	r.Use(instrument.Metrics)
+	r.Use(objectives.Middleware)
+	r.Use(envelopes.Middleware)
+	r.Use(recovery.Middleware)
+	r.Use(disconnect.Middleware)
+	r.Use(fallback.Middleware)
+	r.Use(cachePolicies.Middleware)
+	r.Use(mode.Middleware)
+	r.Use(flags.Middleware)
+	r.Use(faults.Middleware)
+	r.Use(contentTypes.Middleware)
+	r.Use(bots.Middleware)
+	r.Use(shedder.Middleware)
+	r.Use(limiter.Middleware(bulkhead.Global))
+	r.Use(admission.Middleware)
+	r.Use(filters.Middleware)
+	r.Use(splitter.Middleware)
+	r.Use(dumper.Middleware)
+
+	r.Get("/health", handlers.CheckHealth)
+	r.With(acl.Middleware("statusz")).Get("/statusz", handlers.StatusHandler(backends, objectives))
+	r.With(acl.Middleware("version")).Get("/version", handlers.VersionHandler(build))
+	if cfg.Metrics.ServesPrometheus() {
+		r.Handle("/metrics", metrics.Handler())
+	}
+	if wellKnown != nil {
+		r.Group(func(r chi.Router) {
+			r.Use(acl.Middleware("discovery"))
+			r.Get(discovery.ConfigurationPath, wellKnown.ConfigurationHandler)
+			r.Get(discovery.KeysPath, wellKnown.KeysHandler)
+		})
+	}
+
+	r.Route("/auth", func(r chi.Router) {
+		r.Use(acl.Middleware("auth"))
+		r.Use(limiter.Middleware(backend.Auth))
+		r.Post("/login", authManager.LoginHandler)
+		r.Post("/register", authManager.RegisterHandler)
+		r.Post("/refresh", authManager.RefreshHandler)
+		r.Post("/revoke", authManager.RevokeHandler)
+		if accountManager != nil {
+			r.Route("/account", func(r chi.Router) {
+				r.Use(g.authenticator.Middleware)
+				r.Use(accountManager.RequireRecentLogin)
+				r.Put("/password", accountManager.ChangePasswordHandler)
+				r.Put("/email", accountManager.ChangeEmailHandler)
+				r.Delete("/", accountManager.DeleteAccountHandler)
+			})
+		}
+		if magicLinks != nil {
+			r.Post("/magic-link", magicLinks.RequestHandler)
+			r.Post("/magic-link/verify", magicLinks.VerifyHandler)
+		}
+		if challenges != nil {
+			r.Post("/mfa/verify", authManager.MFAVerifyHandler)
+			r.Group(func(r chi.Router) {
+				r.Use(g.authenticator.Middleware)
+				r.Use(handlers.RequireRecentLogin(cfg.Auth.Account.MaxAuthAge))
+				r.Post("/mfa/setup", authManager.MFASetupHandler)
+				r.Post("/mfa/setup/confirm", authManager.MFAConfirmHandler)
+			})
+		}
+	})
+
+	r.Route("/inventory", func(r chi.Router) {
+		r.Use(acl.Middleware("inventory"))
+		r.Use(limiter.Middleware(backend.Inventory))
+		r.Use(g.authenticator.Middleware)
+		r.Use(quotas.Middleware)
+		r.Use(responses.Middleware)
+		// Protected routes
+		r.Post("/create", invManager.CreateHandler)
+		r.Post("/delete", invManager.DeleteHandler)
+		r.With(personalizer.Middleware).Get("/get", invManager.GetHandler)
+		r.With(personalizer.Middleware).Post("/list", invManager.ListHandler)
+		r.Post("/update", invManager.UpdateHandler)
+		r.Delete("/products/{id}", invManager.ProductDeleteHandler)
+		r.Post("/products/{id}/restore", invManager.RestoreHandler)
+		r.Post("/products/{id}/adjust", invManager.AdjustHandler)
+		r.Post("/products/{id}/availability", invManager.AvailabilityHandler)
+		r.Post("/products/import", invManager.ImportHandler)
+		r.Post("/products/export", invManager.ExportHandler)
+	})
+
+	// sub-requests go through the full router, middleware included
+	r.With(acl.Middleware("batch")).Post(batch.Path, batch.New(cfg.Batch, r).ServeHTTP)
+
+	jobsManager := handlers.NewJobsManager(runner)
+	r.Route("/jobs", func(r chi.Router) {
+		r.Use(acl.Middleware("jobs"))
+		r.Use(g.authenticator.Middleware)
+		r.Use(quotas.Middleware)
+		r.Get("/{id}", jobsManager.GetHandler)
+		r.Get("/{id}/output", jobsManager.OutputHandler)
+	})
+
+	if notifications != nil {
+		notifyManager := handlers.NewNotifyManager(notifications)
+		r.Route("/notifications", func(r chi.Router) {
+			r.Use(acl.Middleware("notifications"))
+			r.Use(limiter.Middleware(backend.Notifications))
+			r.Use(g.authenticator.Middleware)
+			r.Use(quotas.Middleware)
+			r.Post("/send", notifyManager.SendHandler)
+		})
+	}
+
+	if wildcard != nil {
+		r.Route("/rpc", func(r chi.Router) {
+			r.Use(acl.Middleware("rpc"))
+			r.Use(g.authenticator.Middleware)
+			r.Use(quotas.Middleware)
+			r.Post("/{service}/{method}", wildcard.ServeHTTP)
+		})
+	}
+
+	for _, ep := range routes {
+		r.Group(func(r chi.Router) {
+			r.Use(acl.Middleware(ep.Backend))
+			r.Use(limiter.Middleware(ep.Backend))
+			switch ep.Auth {
+			case passthrough.Token:
+				r.Use(g.authenticator.Middleware)
+			case passthrough.Optional:
+				r.Use(g.authenticator.OptionalMiddleware)
+			}
+			r.Use(personalizer.Middleware)
+			r.Use(quotas.Middleware)
+			r.Use(responses.Middleware)
+			r.Method(ep.Method, ep.Path, ep)
+		})
+	}
+
+	if userAdmin != nil {
+		r.Route("/admin/users", func(r chi.Router) {
+			r.Use(acl.Middleware("admin"))
+			r.Use(g.authenticator.Middleware)
+			r.Use(userAdmin.RequireRole)
+			r.Get("/", userAdmin.ListHandler)
+			r.Post("/{id}/lock", userAdmin.LockHandler)
+			r.Post("/{id}/unlock", userAdmin.UnlockHandler)
+			r.Post("/{id}/password-reset", userAdmin.PasswordResetHandler)
+			r.Delete("/{id}/sessions", userAdmin.RevokeSessionsHandler)
+		})
+	}
+
+	if cfg.Admin.Token != "" {
+		adminManager := handlers.NewAdminManager(backends, mode, dumper)
+		adminManager.Webhooks = webhooks
+		adminManager.Quotas = quotas
+		adminManager.Schemas = schemas
+		adminManager.Features = flags
+		adminManager.Faults = faults
+		adminManager.Cache = responses
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(acl.Middleware("admin"))
+			r.Use(handlers.RequireAdminToken(cfg.Admin.Token))
+			r.Get("/backends", adminManager.BackendsHandler)
+			r.Get("/maintenance", adminManager.MaintenanceHandler)
+			r.Put("/maintenance", adminManager.SetMaintenanceHandler)
+			r.Get("/debug/bodydump", adminManager.BodyDumpsHandler)
+			r.Post("/debug/bodydump", adminManager.EnableBodyDumpHandler)
+			r.Delete("/debug/bodydump", adminManager.DisableBodyDumpHandler)
+			r.Get("/webhooks", adminManager.WebhooksHandler)
+			r.Post("/webhooks", adminManager.RegisterWebhookHandler)
+			r.Delete("/webhooks/{id}", adminManager.RemoveWebhookHandler)
+			r.Get("/webhooks/deliveries", adminManager.WebhookDeliveriesHandler)
+			if schemas != nil {
+				r.Get("/schemas", adminManager.SchemasHandler)
+			}
+			if quotas != nil {
+				r.Get("/quotas/{client}", adminManager.QuotaHandler)
+				r.Delete("/quotas/{client}", adminManager.ResetQuotaHandler)
+			}
+			if flags != nil {
+				r.Get("/features", adminManager.FeaturesHandler)
+				r.Put("/features/{name}", adminManager.SetFeatureHandler)
+			}
+			if faults != nil {
+				r.Get("/faults", adminManager.FaultsHandler)
+				r.Put("/faults", adminManager.SetFaultsHandler)
+			}
+			if responses != nil {
+				r.Get("/cache", adminManager.CacheHandler)
+				r.Delete("/cache", adminManager.PurgeCacheHandler)
+				r.Put("/cache/routes", adminManager.SetCacheRouteHandler)
+			}
+		})
+	}
+	return g, nil
+}
+
+// watchSecrets refreshes the secrets referenced by the configuration while
+// the gateway runs. The JWT HMAC secret is applied as it changes; other
+// changes are logged and need a restart.
+func (g *Gateway) watchSecrets() {
+	watcher := secrets.NewWatcher(secrets.NewResolver(g.cfg.Secrets), g.cfg.Secrets.Refresh)
+	for _, name := range slices.Sorted(maps.Keys(g.cfg.Secrets.Refs)) {
+		var apply func(string)
+		if name == "auth.jwt.hmac_secret" {
+			apply = g.verifier.SetHMACSecret
+		}
+		watcher.Watch(name, g.cfg.Secrets.Refs[name], g.cfg.SecretValue(name), apply)
+	}
+	g.OnStart("secrets", func(ctx context.Context) error {
+		go watcher.Run(ctx)
+		return nil
+	})
+}
+
+// FeatureEnabled reports whether the feature flag name is on for the tenant
+// of r, a request served by the gateway. Unknown flags are off.
+func FeatureEnabled(r *http.Request, name string) bool {
+	return feature.Enabled(r, name)
+}
+
+// Handler returns the gateway's router.
+func (g *Gateway) Handler() http.Handler {
+	return g.router
+}
+
+// Routes returns the gateway's router for inspection, e.g. with chi.Walk.
+func (g *Gateway) Routes() chi.Routes {
+	return g.router
+}
+
+// RegisterService mounts the routes of svc at /<svc.Name>, behind the same
+// access rules, concurrency limit and authentication as the built-in APIs.
+// Register services before serving requests.
+func (g *Gateway) RegisterService(svc Service) error {
+	if slices.Contains(reserved, svc.Name) {
+		return fmt.Errorf("gateway: service name %q is reserved", svc.Name)
+	}
+	pool := g.backends.Pool(svc.Name)
+	if pool == nil {
+		return fmt.Errorf("gateway: service %s has no backend configured", svc.Name)
+	}
+	conn := g.recorder.Conn(svc.Name, g.shadow.Conn(g.splitter.Conn(svc.Name, pool)))
+	g.router.Route("/"+svc.Name, func(r chi.Router) {
+		r.Use(g.acl.Middleware(svc.Name))
+		r.Use(g.limiter.Middleware(svc.Name))
+		if !svc.Public {
+			r.Use(g.authenticator.Middleware)
+		}
+		r.Use(g.quotas.Middleware)
+		svc.Routes(r, conn)
+	})
+	return nil
+}
+
+// Run serves the configured listeners, or HTTPAddr when there are none,
+// until ctx is canceled, then shuts down gracefully and closes the gateway,
+// running the lifecycle hooks along the way as Serve does.
+func (g *Gateway) Run(ctx context.Context) error {
+	listeners := g.cfg.Listeners
+	if len(listeners) == 0 {
+		listeners = []server.ListenerConfig{{Address: g.cfg.HTTPAddr}}
+	}
+	srv, err := server.New(listeners, g.cfg.HTTPServer, g.router)
+	if err != nil {
+		return err
+	}
+	if err := srv.Listen(); err != nil {
+		return err
+	}
+	return g.Serve(ctx, srv)
+}
+
+// Reload applies the parts of cfg that can change at runtime, the access
+// rules, and reopens the access log file.
+func (g *Gateway) Reload(cfg *Config) error {
+	err := g.acl.Reload(cfg.Access)
+	if reopenErr := g.accessLog.Reopen(); err == nil {
+		err = reopenErr
+	}
+	return err
+}
+
+// PrintFeatures writes the table of enabled features and the startup
+// warnings to w.
+func (g *Gateway) PrintFeatures(w io.Writer) error {
+	report := g.report
+	report.Describe(&g.cfg, g.backends, g.verifier)
+	return report.Print(w)
+}
+
+// Close runs the stop hooks, drains the background work (notifications,
+// jobs, events, webhooks, metrics) until ctx is done and closes every
+// backend connection.
+func (g *Gateway) Close(ctx context.Context) {
+	g.hooks.Run(ctx, lifecycle.Stop)
+	zl := logger.Logger()
+	if g.notifications != nil {
+		if err := g.notifications.Close(ctx); err != nil {
+			zl.Warn("Notifications left undelivered at shutdown", zap.Error(err))
+		}
+	}
+	if err := g.runner.Close(ctx); err != nil {
+		zl.Warn("Jobs left running at shutdown", zap.Error(err))
+	}
+	if err := g.emitter.Close(ctx); err != nil {
+		zl.Warn("Failed to publish remaining events", zap.Error(err))
+	}
+	if err := g.webhooks.Close(ctx); err != nil {
+		zl.Warn("Webhooks left undelivered at shutdown", zap.Error(err))
+	}
+	if err := g.exporter.Close(ctx); err != nil {
+		zl.Warn("Failed to push the last metrics", zap.Error(err))
+	}
+	g.shadow.Close()
+	g.splitter.Close()
+	g.backends.Close()
+	g.shedder.Close()
+	g.accessLog.Close()
+	g.rotations.Close()
+	g.quotas.Close()
+	g.schemas.Close()
+	g.flags.Close()
+	g.objectives.Close()
+	g.signatures.Close()
+}
@@ -0,0 +1,620 @@
+package gateway_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/backend"
+	"github.com/andro-kes/gateway/internal/buildinfo"
+	"github.com/andro-kes/gateway/internal/canary"
+	"github.com/andro-kes/gateway/internal/passthrough"
+	"github.com/andro-kes/gateway/internal/personalize"
+	"github.com/andro-kes/gateway/pkg/gateway"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+type catalogServer struct {
+	pbInv.UnimplementedInventoryServiceServer
+}
+
+func (catalogServer) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	return &pbInv.GetResponse{Product: &pbInv.Product{Id: in.Id, Name: "catalog"}}, nil
+}
+
+// fakeInventory serves GetProduct from memory, without a backend
+type fakeInventory struct {
+	gateway.InventoryService
+}
+
+func (fakeInventory) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	return &pbInv.GetResponse{Product: &pbInv.Product{Id: in.Id, Name: "fake"}}, nil
+}
+
+type fakeAuth struct {
+	gateway.AuthService
+}
+
+func startServer(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pbInv.RegisterInventoryServiceServer(srv, catalogServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func signedToken(exp time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, _ := json.Marshal(map[string]any{"sub": "user-1", "exp": exp.Unix()})
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newGateway(t *testing.T, addr string) *gateway.Gateway {
+	t.Helper()
+	cfg := gateway.Config{
+		GRPCAddr: addr,
+		Backends: map[string]backend.Config{"catalog": {Address: addr}},
+	}
+	cfg.Auth.JWT.HMACSecret = secret
+	cfg.Pagination.Secret = secret
+	gw, err := gateway.New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		gw.Close(ctx)
+	})
+	return gw
+}
+
+// TestNew tests that an embedded gateway serves the built-in routes and reports failed startup checks
+func TestNew(t *testing.T) {
+	gw := newGateway(t, startServer(t))
+
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/inventory/get?id=p1", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	cfg := gateway.Config{GRPCAddr: "127.0.0.1:1"}
+	cfg.Startup.BackendTimeout = 100 * time.Millisecond
+	cfg.Events.Driver = "carrier-pigeon"
+	_, err := gateway.New(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "events")
+	assert.Contains(t, err.Error(), "backend inventory")
+
+	cfg.Events.Driver = ""
+	gw, err = gateway.New(cfg, gateway.WithoutBackendProbe())
+	require.NoError(t, err)
+	gw.Close(context.Background())
+}
+
+// TestNew_Version tests that the build and the enabled features are served on /version and exported as metrics
+func TestNew_Version(t *testing.T) {
+	gw := newGateway(t, startServer(t))
+
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var info buildinfo.Info
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.NotEmpty(t, info.Version)
+	assert.NotEmpty(t, info.Commit)
+	assert.Contains(t, info.Features, "backend catalog")
+
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `gateway_build_info{build_date="",commit="`+info.Commit+`",go_version="`+info.GoVersion+`",version="`+info.Version+`"} 1`)
+	assert.Contains(t, w.Body.String(), `gateway_feature_enabled{feature="backend catalog"} 1`)
+}
+
+// TestNew_LocalizedErrors tests that JSON error messages follow Accept-Language and configured catalogs while codes stay the same
+func TestNew_LocalizedErrors(t *testing.T) {
+	catalog := filepath.Join(t.TempDir(), "pt-BR.yaml")
+	require.NoError(t, os.WriteFile(catalog, []byte("not_found: nenhuma rota para {method} {path}\n"), 0o600))
+	cfg := gateway.Config{GRPCAddr: startServer(t)}
+	cfg.Pagination.Secret = secret
+	cfg.I18n.Catalogs = []string{catalog}
+	gw, err := gateway.New(cfg)
+	require.NoError(t, err)
+	defer gw.Close(context.Background())
+
+	for accept, message := range map[string]string{
+		"":               "no route for GET /nowhere",
+		"de-DE,de;q=0.9": "keine Route für GET /nowhere",
+		"pt-BR":          "nenhuma rota para GET /nowhere",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+		r.Header.Set("Accept-Language", accept)
+		w := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "not_found", body["error"])
+		assert.Equal(t, message, body["message"], accept)
+	}
+}
+
+// TestNew_Envelope tests that the response envelope wraps error bodies of the router on request
+func TestNew_Envelope(t *testing.T) {
+	cfg := gateway.Config{GRPCAddr: startServer(t)}
+	cfg.Pagination.Secret = secret
+	cfg.ResponseEnvelope.Header = "X-Envelope"
+	gw, err := gateway.New(cfg)
+	require.NoError(t, err)
+	defer gw.Close(context.Background())
+
+	r := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+	r.Header.Set("X-Request-ID", "envelope-1")
+	r.Header.Set("X-Envelope", "true")
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var body struct {
+		Error map[string]any `json:"error"`
+		Meta  map[string]any `json:"meta"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "not_found", body.Error["error"])
+	assert.Equal(t, "envelope-1", body.Meta["request_id"])
+	assert.Contains(t, body.Meta, "duration_ms")
+
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+	var plain map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &plain))
+	assert.Equal(t, "not_found", plain["error"], "requests without the header are not wrapped")
+}
+
+// TestNew_Exemplars tests that OpenMetrics scrapes link request latencies to their traces
+func TestNew_Exemplars(t *testing.T) {
+	gw := newGateway(t, startServer(t))
+
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	traceID := w.Header().Get("X-Trace-Id")
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, r)
+	assert.Contains(t, w.Body.String(), `# {trace_id="`+traceID+`"}`)
+}
+
+// TestNew_Services tests that injected services replace the gRPC backends, which are then not probed
+func TestNew_Services(t *testing.T) {
+	cfg := gateway.Config{GRPCAddr: "127.0.0.1:1"}
+	cfg.Auth.JWT.HMACSecret = secret
+	cfg.Pagination.Secret = secret
+	cfg.Startup.BackendTimeout = 100 * time.Millisecond
+	gw, err := gateway.New(cfg, gateway.WithAuthService(fakeAuth{}), gateway.WithInventoryService(fakeInventory{}))
+	require.NoError(t, err)
+	defer gw.Close(context.Background())
+
+	req := httptest.NewRequest(http.MethodGet, "/inventory/get", strings.NewReader(`{"id":"p1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+signedToken(time.Now().Add(time.Minute)))
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"fake"`)
+}
+
+// TestGateway_RegisterService tests that registered services reach their backend behind authentication
+func TestGateway_RegisterService(t *testing.T) {
+	gw := newGateway(t, startServer(t))
+
+	routes := func(r chi.Router, conn grpc.ClientConnInterface) {
+		client := pbInv.NewInventoryServiceClient(conn)
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			resp, err := client.GetProduct(r.Context(), &pbInv.GetRequest{Id: chi.URLParam(r, "id")})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(resp.Product.Name))
+		})
+	}
+	require.NoError(t, gw.RegisterService(gateway.Service{Name: "catalog", Routes: routes}))
+	assert.Error(t, gw.RegisterService(gateway.Service{Name: "inventory", Routes: routes}))
+	assert.Error(t, gw.RegisterService(gateway.Service{Name: "orders", Routes: routes}))
+
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/catalog/p1", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/catalog/p1", nil)
+	req.Header.Set("Authorization", "Bearer "+signedToken(time.Now().Add(time.Minute)))
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "catalog", w.Body.String())
+}
+
+// TestNew_Routes tests that declared routes reach their backend behind authentication unless public or optional, with a policy per method
+func TestNew_Routes(t *testing.T) {
+	addr := startServer(t)
+	cfg := gateway.Config{
+		GRPCAddr: addr,
+		Backends: map[string]backend.Config{"catalog": {Address: addr}},
+		Routes: []passthrough.Route{
+			{Method: "GET", Path: "/catalog/{id}", Backend: "catalog", RPC: "inventory.InventoryService/GetProduct"},
+			{Method: "GET", Path: "/public/{id}", Backend: "catalog", RPC: "inventory.InventoryService/GetProduct", Auth: passthrough.Public},
+			{Method: "GET", Path: "/mixed/{id}", Backend: "catalog", RPC: "inventory.InventoryService/GetProduct", Auth: passthrough.Optional},
+			{Path: "/products/{id}", Backend: "catalog", Methods: map[string]passthrough.MethodRoute{
+				"GET":    {RPC: "inventory.InventoryService/GetProduct", Auth: passthrough.Public},
+				"DELETE": {RPC: "inventory.InventoryService/DeleteProduct", Roles: []string{"admin"}},
+			}},
+		},
+	}
+	cfg.Auth.JWT.HMACSecret = secret
+	cfg.Pagination.Secret = secret
+	gw, err := gateway.New(cfg)
+	require.NoError(t, err)
+	defer gw.Close(context.Background())
+
+	w := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/catalog/p1", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/catalog/p1", nil)
+	req.Header.Set("Authorization", "Bearer "+signedToken(time.Now().Add(time.Minute)))
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"product":{"id":"p1","name":"catalog"}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public/p2", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mixed/p2", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/mixed/p2", nil)
+	req.Header.Set("Authorization", "Bearer "+signedToken(time.Now().Add(-time.Minute)))
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/p3", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/products/p3", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/products/p3", nil)
+	req.Header.Set("Authorization", "Bearer "+signedToken(time.Now().Add(time.Minute)))
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "DELETE /products/{id} requires role admin")
+
+	w = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/products/p3", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	cfg.Routes = []passthrough.Route{
+		{Path: "/inventory/extra", Backend: "catalog", RPC: "inventory.InventoryService/GetProduct"},
+		{Path: "/orders", Backend: "orders", RPC: "inventory.InventoryService/GetProduct"},
+	}
+	_, err = gateway.New(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "prefix /inventory is reserved")
+	assert.Contains(t, err.Error(), `backend "orders" is not configured`)
+}
+
+// TestGateway_Run tests that Run serves until its context is canceled
+func TestGateway_Run(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	cfg := gateway.Config{HTTPAddr: addr, GRPCAddr: startServer(t)}
+	cfg.Pagination.Secret = secret
+	gw, err := gateway.New(cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- gw.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr + "/health")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 2*time.Second, 20*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+// TestGateway_Hooks tests that Run runs the lifecycle hooks in order and passes start hooks a context that lasts until stop
+func TestGateway_Hooks(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	cfg := gateway.Config{HTTPAddr: addr, GRPCAddr: startServer(t)}
+	cfg.Pagination.Secret = secret
+	gw, err := gateway.New(cfg)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var stages []string
+	record := func(stage string) gateway.Hook {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			stages = append(stages, stage)
+			return nil
+		}
+	}
+	var life context.Context
+	gw.OnStart("watcher", func(ctx context.Context) error {
+		life = ctx
+		return record("start")(ctx)
+	})
+	gw.OnReady("announce", record("ready"))
+	gw.OnDrain("deregister", record("drain"))
+	gw.OnStop("publisher", func(ctx context.Context) error {
+		assert.Error(t, life.Err(), "start context still live at stop")
+		return record("stop 1")(ctx)
+	})
+	gw.OnStop("cache", record("stop 2"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- gw.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(stages) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.NoError(t, life.Err())
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	assert.Equal(t, []string{"start", "ready", "drain", "stop 2", "stop 1"}, stages)
+}
+
+// TestGateway_StartHookFails tests that a failing start hook aborts Run
+func TestGateway_StartHookFails(t *testing.T) {
+	cfg := gateway.Config{HTTPAddr: "127.0.0.1:0", GRPCAddr: startServer(t)}
+	cfg.Pagination.Secret = secret
+	gw, err := gateway.New(cfg)
+	require.NoError(t, err)
+
+	stopped := false
+	gw.OnStart("warmer", func(context.Context) error { return errors.New("cache unavailable") })
+	gw.OnStop("publisher", func(context.Context) error {
+		stopped = true
+		return nil
+	})
+
+	err = gw.Run(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "start hook warmer: cache unavailable")
+	assert.True(t, stopped)
+}
+
+// fakeUserAdmin is an auth service that also administers users
+type fakeUserAdmin struct {
+	fakeAuth
+	locked []string
+}
+
+func (f *fakeUserAdmin) ListUsers(ctx context.Context, in *gateway.ListUsersRequest) (*gateway.ListUsersResponse, error) {
+	return &gateway.ListUsersResponse{Users: []gateway.User{{ID: "user-2", Username: "bob"}}}, nil
+}
+
+func (f *fakeUserAdmin) LockUser(ctx context.Context, in *gateway.LockUserRequest) error {
+	f.locked = append(f.locked, in.UserID)
+	return nil
+}
+
+func (f *fakeUserAdmin) UnlockUser(ctx context.Context, in *gateway.UserRequest) error {
+	return nil
+}
+
+func (f *fakeUserAdmin) ForcePasswordReset(ctx context.Context, in *gateway.UserRequest) error {
+	return nil
+}
+
+func (f *fakeUserAdmin) RevokeSessions(ctx context.Context, in *gateway.UserRequest) error {
+	return nil
+}
+
+// TestNew_UserAdmin tests that /admin/users takes operator tokens with the user admin role, next to the admin token routes
+func TestNew_UserAdmin(t *testing.T) {
+	cfg := gateway.Config{GRPCAddr: "127.0.0.1:1"}
+	cfg.Auth.JWT.HMACSecret = secret
+	cfg.Pagination.Secret = secret
+	cfg.Admin.Token = "admin-token"
+	cfg.Admin.Users.Enabled = true
+	users := &fakeUserAdmin{}
+	gw, err := gateway.New(cfg, gateway.WithAuthService(users), gateway.WithInventoryService(fakeInventory{}))
+	require.NoError(t, err)
+	defer gw.Close(context.Background())
+
+	operator := func(roles ...string) string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+		payload, _ := json.Marshal(map[string]any{"sub": "operator-1", "roles": roles, "exp": time.Now().Add(time.Minute).Unix()})
+		signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(signed))
+		return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	serve := func(method, path, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "127.0.0.1:40000"
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		w := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodGet, "/admin/users", operator("user_admin"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"bob"`)
+
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/admin/users/user-2/lock", operator("user_admin")).Code)
+	assert.Equal(t, []string{"user-2"}, users.locked)
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/admin/users/user-2/lock", operator("support")).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/admin/users", "admin-token").Code, "the admin token is no operator token")
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/admin/maintenance", "admin-token").Code)
+	assert.Len(t, users.locked, 1)
+}
+
+// TestNew_MagicLink tests that magic links need an absolute link URL and the notifications backend
+func TestNew_MagicLink(t *testing.T) {
+	cfg := gateway.Config{GRPCAddr: "127.0.0.1:1"}
+	cfg.Auth.JWT.HMACSecret = secret
+	cfg.Pagination.Secret = secret
+	cfg.Auth.MagicLink.Enabled = true
+	cfg.Auth.MagicLink.URL = "/login/magic"
+	_, err := gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not absolute")
+
+	cfg.Auth.MagicLink.URL = "https://shop.example.com/login/magic"
+	_, err = gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "notifications backend")
+}
+
+// TestNew_BackendAuth tests that the service token cannot be forwarded from a request header
+func TestNew_BackendAuth(t *testing.T) {
+	cfg := gateway.Config{GRPCAddr: "127.0.0.1:1"}
+	cfg.Auth.JWT.HMACSecret = secret
+	cfg.Pagination.Secret = secret
+	cfg.BackendAuth.Token = "gateway-secret"
+	cfg.GRPCClient.PropagateHeaders = []string{"X-Gateway-Authorization"}
+	_, err := gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service token")
+
+	cfg.BackendAuth.Token = ""
+	_, err = gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.Error(t, err, "the default key is reserved without backend auth too")
+
+	cfg.BackendAuth.Token = "gateway-secret"
+	cfg.BackendAuth.Metadata = "x-service-token"
+	cfg.GRPCClient.PropagateHeaders = []string{"X-Service-Token"}
+	_, err = gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service token")
+
+	cfg.GRPCClient.PropagateHeaders = nil
+	_, err = gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.NoError(t, err)
+}
+
+// TestNew_Personalization tests that invalid personalization config fails startup
+func TestNew_Personalization(t *testing.T) {
+	cfg := gateway.Config{GRPCAddr: "127.0.0.1:1"}
+	cfg.Auth.JWT.HMACSecret = secret
+	cfg.Pagination.Secret = secret
+	cfg.Auth.Personalization.Claims = []personalize.Claim{{Claim: "tier"}}
+	_, err := gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "needs a metadata key")
+
+	cfg.Auth.Personalization.Claims = []personalize.Claim{{Claim: "tier", Metadata: "x-user-tier"}}
+	cfg.GRPCClient.PropagateHeaders = []string{"X-User-Tier"}
+	_, err = gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot be propagated")
+
+	cfg.GRPCClient.PropagateHeaders = nil
+	_, err = gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.NoError(t, err)
+}
+
+// TestNew_CanaryBackend tests that canary rules must name a configured backend
+func TestNew_CanaryBackend(t *testing.T) {
+	cfg := gateway.Config{GRPCAddr: "127.0.0.1:1"}
+	cfg.Auth.JWT.HMACSecret = secret
+	cfg.Pagination.Secret = secret
+	cfg.Canary = []canary.Config{{Name: "v2", PathPrefix: "/inventory", Backend: "billing", Address: "127.0.0.1:2"}}
+	_, err := gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown backend "billing"`)
+
+	cfg.Canary[0].Backend = ""
+	gw, err := gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.NoError(t, err)
+	gw.Close(context.Background())
+}
+
+// TestNew_DeleteRoles tests that delete roles cannot be configured without a JWT key to verify them
+func TestNew_DeleteRoles(t *testing.T) {
+	cfg := gateway.Config{GRPCAddr: "127.0.0.1:1"}
+	cfg.Pagination.Secret = secret
+	cfg.Inventory.HardDeleteRoles = []string{"admin"}
+	_, err := gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "need a JWT key")
+
+	cfg.Inventory.HardDeleteRoles = nil
+	gw, err := gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.NoError(t, err)
+	gw.Close(context.Background())
+
+	cfg.Auth.JWT.HMACSecret = secret
+	cfg.Inventory.HardDeleteRoles = []string{"admin"}
+	gw, err = gateway.New(cfg, gateway.WithAuthService(&fakeUserAdmin{}), gateway.WithInventoryService(fakeInventory{}))
+	require.NoError(t, err)
+	gw.Close(context.Background())
+}
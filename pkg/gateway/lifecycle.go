@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/andro-kes/gateway/internal/lifecycle"
+)
+
+// Hook is a function run at a stage of the gateway's life, see OnStart,
+// OnReady, OnDrain and OnStop.
+type Hook = lifecycle.Hook
+
+// OnStart registers fn to run before the gateway serves traffic, e.g. to
+// warm a cache or start a discovery watcher. Its context is canceled when
+// the gateway stops. A failing start hook aborts Run and Serve.
+func (g *Gateway) OnStart(name string, fn Hook) {
+	g.hooks.Register(lifecycle.Start, name, fn)
+}
+
+// OnReady registers fn to run once the gateway serves traffic, e.g. to
+// announce itself. Failures are logged.
+func (g *Gateway) OnReady(name string, fn Hook) {
+	g.hooks.Register(lifecycle.Ready, name, fn)
+}
+
+// OnDrain registers fn to run when shutdown begins, while in-flight
+// requests still complete, e.g. to deregister from discovery. Failures are
+// logged.
+func (g *Gateway) OnDrain(name string, fn Hook) {
+	g.hooks.Register(lifecycle.Drain, name, fn)
+}
+
+// OnStop registers fn to run once the listeners are closed, before the
+// gateway closes its own components, e.g. to flush an event publisher.
+// Stop hooks run in reverse registration order; failures are logged.
+func (g *Gateway) OnStop(name string, fn Hook) {
+	g.hooks.Register(lifecycle.Stop, name, fn)
+}
+
+// Server is a set of listeners already bound, served by Serve.
+type Server interface {
+	// Serve serves until Shutdown and returns http.ErrServerClosed then.
+	Serve() error
+	// Shutdown stops accepting connections and waits for in-flight
+	// requests until ctx is done.
+	Shutdown(ctx context.Context) error
+}
+
+// Serve runs the start hooks, serves srv and runs the ready hooks, until
+// ctx is canceled or srv fails. It then runs the drain hooks, shuts srv down
+// gracefully and closes the gateway, stop hooks first.
+func (g *Gateway) Serve(ctx context.Context, srv Server) error {
+	err := g.hooks.Run(ctx, lifecycle.Start)
+	if err == nil {
+		served := make(chan error, 1)
+		go func() { served <- srv.Serve() }()
+		g.hooks.Run(ctx, lifecycle.Ready)
+		select {
+		case err = <-served:
+		case <-ctx.Done():
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 15*time.Second)
+	defer cancel()
+	g.hooks.Run(shutdownCtx, lifecycle.Drain)
+	if shutdownErr := srv.Shutdown(shutdownCtx); err == nil {
+		err = shutdownErr
+	}
+	g.Close(shutdownCtx)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,297 @@
+package gatewaytest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	pbAuth "github.com/andro-kes/auth_service/proto"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Auth is an in-memory auth backend. Logins issue access tokens from its
+// Tokens and opaque refresh tokens that can be refreshed once and revoked.
+type Auth struct {
+	tokens *Tokens
+
+	mu      sync.Mutex
+	users   map[string]*user
+	refresh map[string]string // refresh token -> user ID
+	err     error
+}
+
+type user struct {
+	id       string
+	password string
+	roles    []string
+}
+
+// NewAuth returns an Auth without users.
+func NewAuth(tokens *Tokens) *Auth {
+	return &Auth{tokens: tokens, users: map[string]*user{}, refresh: map[string]string{}}
+}
+
+// AddUser registers a user with roles and returns its ID.
+func (a *Auth) AddUser(username, password string, roles ...string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.addUser(username, password, roles)
+}
+
+func (a *Auth) addUser(username, password string, roles []string) string {
+	u := &user{id: fmt.Sprintf("user-%d", len(a.users)+1), password: password, roles: roles}
+	a.users[username] = u
+	return u.id
+}
+
+// Fail makes every call return err until it is called with nil.
+func (a *Auth) Fail(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.err = err
+}
+
+func (a *Auth) Login(ctx context.Context, in *pbAuth.LoginRequest) (*pbAuth.TokenResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return nil, a.err
+	}
+	u, ok := a.users[in.Username]
+	if !ok || u.password != in.Password {
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+	return a.issue(u), nil
+}
+
+func (a *Auth) Register(ctx context.Context, in *pbAuth.RegisterRequest) (*pbAuth.RegisterResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return nil, a.err
+	}
+	if _, ok := a.users[in.Username]; ok {
+		return nil, status.Error(codes.AlreadyExists, "user already exists")
+	}
+	return &pbAuth.RegisterResponse{UserId: a.addUser(in.Username, in.Password, nil)}, nil
+}
+
+func (a *Auth) Refresh(ctx context.Context, in *pbAuth.RefreshRequest) (*pbAuth.TokenResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return nil, a.err
+	}
+	id, ok := a.refresh[in.RefreshToken]
+	if !ok || (in.ExpectedUserId != "" && in.ExpectedUserId != id) {
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+	delete(a.refresh, in.RefreshToken)
+	for _, u := range a.users {
+		if u.id == id {
+			return a.issue(u), nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "user not found")
+}
+
+func (a *Auth) Revoke(ctx context.Context, in *pbAuth.RevokeRequest) (*pbAuth.RevokeResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return nil, a.err
+	}
+	delete(a.refresh, in.RefreshToken)
+	return &pbAuth.RevokeResponse{}, nil
+}
+
+func (a *Auth) issue(u *user) *pbAuth.TokenResponse {
+	b := make([]byte, 16)
+	rand.Read(b)
+	refresh := hex.EncodeToString(b)
+	a.refresh[refresh] = u.id
+	return &pbAuth.TokenResponse{
+		UserId:           u.id,
+		AccessToken:      a.tokens.Valid(u.id, u.roles...),
+		AccessExpiresIn:  durationpb.New(a.tokens.TTL),
+		RefreshToken:     refresh,
+		RefreshExpiresIn: durationpb.New(24 * time.Hour),
+	}
+}
+
+// Inventory is an in-memory inventory backend. Products are listed by ID;
+// list filters and ordering are ignored.
+type Inventory struct {
+	mu       sync.Mutex
+	products map[string]*pbInv.Product
+	nextID   int
+	err      error
+}
+
+// NewInventory returns an Inventory holding products.
+func NewInventory(products ...*pbInv.Product) *Inventory {
+	f := &Inventory{products: map[string]*pbInv.Product{}}
+	for _, p := range products {
+		f.Put(p)
+	}
+	return f
+}
+
+// Put stores a copy of p, assigning an ID when it has none, and returns
+// the ID.
+func (f *Inventory) Put(p *pbInv.Product) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.put(p)
+}
+
+func (f *Inventory) put(p *pbInv.Product) string {
+	p = proto.Clone(p).(*pbInv.Product)
+	if p.Id == "" {
+		f.nextID++
+		p.Id = fmt.Sprintf("prod-%d", f.nextID)
+	}
+	now := timestamppb.Now()
+	if p.CreatedAt == nil {
+		p.CreatedAt = now
+	}
+	p.UpdatedAt = now
+	f.products[p.Id] = p
+	return p.Id
+}
+
+// Product returns a copy of the stored product, or nil.
+func (f *Inventory) Product(id string) *pbInv.Product {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.products[id]
+	if !ok {
+		return nil
+	}
+	return proto.Clone(p).(*pbInv.Product)
+}
+
+// Fail makes every call return err until it is called with nil.
+func (f *Inventory) Fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *Inventory) ListProducts(ctx context.Context, in *pbInv.ListRequest) (*pbInv.ListResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	ids := make([]string, 0, len(f.products))
+	for id := range f.products {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	resp := &pbInv.ListResponse{TotalSize: int32(len(ids))}
+	start := min(int(in.PrevSize), len(ids))
+	end := len(ids)
+	if in.PageSize > 0 {
+		end = min(start+int(in.PageSize), len(ids))
+	}
+	for _, id := range ids[start:end] {
+		resp.Products = append(resp.Products, proto.Clone(f.products[id]).(*pbInv.Product))
+	}
+	return resp, nil
+}
+
+func (f *Inventory) GetProduct(ctx context.Context, in *pbInv.GetRequest) (*pbInv.GetResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	p, ok := f.products[in.Id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "product %s not found", in.Id)
+	}
+	return &pbInv.GetResponse{Product: proto.Clone(p).(*pbInv.Product)}, nil
+}
+
+func (f *Inventory) CreateProduct(ctx context.Context, in *pbInv.CreateRequest) (*pbInv.CreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	p := in.GetProduct()
+	if p == nil {
+		p = &pbInv.Product{}
+	}
+	if p.Id != "" {
+		if _, ok := f.products[p.Id]; ok {
+			return nil, status.Errorf(codes.AlreadyExists, "product %s already exists", p.Id)
+		}
+	}
+	id := f.put(p)
+	return &pbInv.CreateResponse{Product: proto.Clone(f.products[id]).(*pbInv.Product)}, nil
+}
+
+// UpdateProduct replaces the fields in the update mask, or every field
+// without one.
+func (f *Inventory) UpdateProduct(ctx context.Context, in *pbInv.UpdateRequest) (*pbInv.UpdateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	patch := in.GetProduct()
+	current, ok := f.products[patch.GetId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "product %s not found", patch.GetId())
+	}
+	updated := proto.Clone(current).(*pbInv.Product)
+	if paths := in.GetUpdateMask().GetPaths(); len(paths) > 0 {
+		for _, path := range paths {
+			switch path {
+			case "name":
+				updated.Name = patch.Name
+			case "description":
+				updated.Description = patch.Description
+			case "price":
+				updated.Price = patch.Price
+			case "quantity":
+				updated.Quantity = patch.Quantity
+			case "tags":
+				updated.Tags = patch.Tags
+			case "available":
+				updated.Available = patch.Available
+			default:
+				return nil, status.Errorf(codes.InvalidArgument, "unknown update mask path %q", path)
+			}
+		}
+	} else {
+		updated = proto.Clone(patch).(*pbInv.Product)
+		updated.CreatedAt = current.CreatedAt
+	}
+	f.put(updated)
+	return &pbInv.UpdateResponse{Product: proto.Clone(f.products[updated.Id]).(*pbInv.Product)}, nil
+}
+
+func (f *Inventory) DeleteProduct(ctx context.Context, in *pbInv.DeleteRequest) (*pbInv.DeleteResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if _, ok := f.products[in.Id]; !ok {
+		return nil, status.Errorf(codes.NotFound, "product %s not found", in.Id)
+	}
+	delete(f.products, in.Id)
+	return &pbInv.DeleteResponse{Success: true}, nil
+}
@@ -0,0 +1,105 @@
+package gatewaytest_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/andro-kes/gateway/pkg/gateway"
+	"github.com/andro-kes/gateway/pkg/gatewaytest"
+	pbInv "github.com/andro-kes/inventory_service/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServer_Tokens tests that the gateway accepts valid tokens and rejects expired and rolled ones
+func TestServer_Tokens(t *testing.T) {
+	srv := gatewaytest.NewServer(t)
+	id := srv.Inventory.Put(&pbInv.Product{Name: "Tea"})
+	get := srv.Request(http.MethodGet, "/inventory/get").JSON(map[string]string{"id": id})
+
+	assert.Equal(t, http.StatusUnauthorized, get.Send().StatusCode)
+
+	resp := get.As("user-1").Send()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var out struct {
+		Product struct{ Name string } `json:"product"`
+	}
+	gatewaytest.DecodeJSON(t, resp, &out)
+	assert.Equal(t, "Tea", out.Product.Name)
+
+	w := get.Bearer(srv.Tokens.Expired("user-1")).Record()
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "expired")
+
+	w = get.Bearer(srv.Tokens.Rolled("user-1")).Record()
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid")
+}
+
+// TestServer_Auth tests the login and refresh flow against the fake auth backend
+func TestServer_Auth(t *testing.T) {
+	srv := gatewaytest.NewServer(t)
+	userID := srv.Auth.AddUser("alice", "secret", "admin")
+
+	resp := srv.Request(http.MethodPost, "/auth/login").JSON(map[string]string{"username": "alice", "password": "wrong"}).Send()
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+
+	resp = srv.Request(http.MethodPost, "/auth/login").JSON(map[string]string{"username": "alice", "password": "secret"}).Send()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var login struct {
+		UserID      string `json:"user_id"`
+		AccessToken string `json:"access_token"`
+	}
+	gatewaytest.DecodeJSON(t, resp, &login)
+	assert.Equal(t, userID, login.UserID)
+
+	w := srv.Request(http.MethodDelete, "/inventory/products/missing").Bearer(login.AccessToken).Record()
+	assert.NotEqual(t, http.StatusUnauthorized, w.Code)
+	assert.NotEqual(t, http.StatusForbidden, w.Code)
+}
+
+// TestServer_Inventory tests the fake inventory backend through the gateway
+func TestServer_Inventory(t *testing.T) {
+	srv := gatewaytest.NewServer(t)
+
+	resp := srv.Request(http.MethodPost, "/inventory/create").
+		As("user-1").
+		JSON(map[string]any{"product": map[string]any{"name": "Tea", "quantity": 3}}).
+		Send()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var created struct {
+		Product struct{ Id string } `json:"product"`
+	}
+	gatewaytest.DecodeJSON(t, resp, &created)
+	require.NotEmpty(t, created.Product.Id)
+	assert.Equal(t, int32(3), srv.Inventory.Product(created.Product.Id).Quantity)
+
+	resp = srv.Request(http.MethodPost, "/inventory/products/"+created.Product.Id+"/adjust").
+		As("user-1").
+		JSON(map[string]any{"delta": 2, "reason": "recount"}).
+		Send()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(5), srv.Inventory.Product(created.Product.Id).Quantity)
+
+	srv.Inventory.Fail(errors.New("backend down"))
+	w := srv.Request(http.MethodPost, "/inventory/list").As("user-1").JSON(map[string]any{}).Record()
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+// TestWithInventory tests that a custom service replaces the fake
+func TestWithInventory(t *testing.T) {
+	inv := gatewaytest.NewInventory(&pbInv.Product{Id: "p1", Name: "Coffee"})
+	srv := gatewaytest.NewServer(t,
+		gatewaytest.WithInventory(inv),
+		gatewaytest.WithConfig(func(cfg *gateway.Config) { cfg.Inventory.RequireIfMatch = true }),
+	)
+	assert.Nil(t, srv.Inventory)
+
+	w := srv.Request(http.MethodGet, "/inventory/get").As("user-1").JSON(map[string]string{"id": "p1"}).Record()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Coffee")
+
+	w = srv.Request(http.MethodPost, "/inventory/update").As("user-1").JSON(map[string]any{"product": map[string]any{"id": "p1", "name": "Tea"}}).Record()
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+}
@@ -0,0 +1,97 @@
+package gatewaytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Request builds a request to the server. It can be sent several times.
+type Request struct {
+	t      testing.TB
+	s      *Server
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// Request starts building a request with method to path, e.g.
+// "/inventory/list".
+func (s *Server) Request(method, path string) *Request {
+	return &Request{t: s.t, s: s, method: method, path: path, header: http.Header{}}
+}
+
+// Header sets a request header.
+func (r *Request) Header(key, value string) *Request {
+	r.header.Set(key, value)
+	return r
+}
+
+// Bearer sends token in the Authorization header.
+func (r *Request) Bearer(token string) *Request {
+	return r.Header("Authorization", "Bearer "+token)
+}
+
+// As authenticates the request as userID with roles, with a valid token.
+func (r *Request) As(userID string, roles ...string) *Request {
+	return r.Bearer(r.s.Tokens.Valid(userID, roles...))
+}
+
+// Body sends body with the given content type.
+func (r *Request) Body(contentType string, body []byte) *Request {
+	r.body = body
+	return r.Header("Content-Type", contentType)
+}
+
+// JSON sends v encoded as JSON.
+func (r *Request) JSON(v any) *Request {
+	r.t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		r.t.Fatalf("gatewaytest: encode request body: %v", err)
+	}
+	return r.Body("application/json", body)
+}
+
+// Build returns the request as served by Record.
+func (r *Request) Build() *http.Request {
+	req := httptest.NewRequest(r.method, r.path, bytes.NewReader(r.body))
+	req.Header = r.header.Clone()
+	return req
+}
+
+// Send sends the request over the network and returns the response. The
+// body is closed when the test ends.
+func (r *Request) Send() *http.Response {
+	r.t.Helper()
+	req, err := http.NewRequest(r.method, r.s.URL+r.path, bytes.NewReader(r.body))
+	if err != nil {
+		r.t.Fatalf("gatewaytest: %v", err)
+	}
+	req.Header = r.header.Clone()
+	resp, err := r.s.Client().Do(req)
+	if err != nil {
+		r.t.Fatalf("gatewaytest: %s %s: %v", r.method, r.path, err)
+	}
+	r.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// Record serves the request with the gateway's handler, without the
+// network, and returns the recorded response.
+func (r *Request) Record() *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.s.Gateway.Handler().ServeHTTP(w, r.Build())
+	return w
+}
+
+// DecodeJSON decodes the JSON body of resp into v.
+func DecodeJSON(t testing.TB, resp *http.Response, v any) {
+	t.Helper()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("gatewaytest: decode response body: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package gatewaytest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// Secret is the HMAC secret NewServer configures the gateway with.
+const Secret = "gatewaytest-secret-0123456789abcdef"
+
+// rolledSecret signs the tokens of Tokens.Rolled.
+const rolledSecret = "gatewaytest-rolled-secret-0123456789"
+
+// Tokens mints HS256 access tokens.
+type Tokens struct {
+	secret []byte
+
+	// TTL is the lifetime of Valid tokens. Default: 1h.
+	TTL time.Duration
+}
+
+// NewTokens returns a minter signing with secret.
+func NewTokens(secret string) *Tokens {
+	return &Tokens{secret: []byte(secret), TTL: time.Hour}
+}
+
+// Valid returns a token for userID with roles that expires after TTL.
+func (t *Tokens) Valid(userID string, roles ...string) string {
+	now := time.Now()
+	return t.Sign(claims(userID, roles, now, now.Add(t.TTL)))
+}
+
+// Expired returns a token for userID with roles that expired a minute ago.
+func (t *Tokens) Expired(userID string, roles ...string) string {
+	now := time.Now()
+	return t.Sign(claims(userID, roles, now.Add(-time.Hour), now.Add(-time.Minute)))
+}
+
+// Rolled returns an otherwise valid token signed with another secret, as
+// issued before a key roll. The gateway rejects it.
+func (t *Tokens) Rolled(userID string, roles ...string) string {
+	now := time.Now()
+	return (&Tokens{secret: []byte(rolledSecret)}).Sign(claims(userID, roles, now, now.Add(time.Hour)))
+}
+
+// Sign returns a token carrying the given claims.
+func (t *Tokens) Sign(claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		panic(err)
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func claims(userID string, roles []string, iat, exp time.Time) map[string]any {
+	c := map[string]any{
+		"sub": userID,
+		"typ": "access",
+		"iat": iat.Unix(),
+		"exp": exp.Unix(),
+	}
+	if len(roles) > 0 {
+		c["roles"] = roles
+	}
+	return c
+}